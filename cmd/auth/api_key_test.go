@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMakeAPIKey(t *testing.T) {
+	key, prefix, err := MakeAPIKey()
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(key, APIKeyPrefix))
+	assert.True(t, strings.HasPrefix(key, prefix))
+	assert.Len(t, prefix, len(APIKeyPrefix)+8)
+	assert.Len(t, key, len(APIKeyPrefix)+43)
+	assert.True(t, IsAPIKey(key))
+
+	other, _, err := MakeAPIKey()
+	require.NoError(t, err)
+	assert.NotEqual(t, key, other)
+	assert.NotEqual(t, HashAPIKey(key), HashAPIKey(other))
+	assert.Equal(t, HashAPIKey(key), HashAPIKey(key))
+}
+
+func TestIsAPIKey(t *testing.T) {
+	token, err := MakeJWT(uuid.New(), "test-secret", time.Minute)
+	require.NoError(t, err)
+
+	assert.False(t, IsAPIKey(token))
+	assert.False(t, IsAPIKey(""))
+}
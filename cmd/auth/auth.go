@@ -1,10 +1,17 @@
 package auth
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"math/big"
 	"net/smtp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -18,18 +25,104 @@ type TokenType string
 const (
 	// TokenTypeAccess -
 	TokenTypeAccess TokenType = "media-access"
+	// TokenTypeService is the issuer of tokens services get with the client credentials grant
+	TokenTypeService TokenType = "media-service"
 )
 
-// MakeJWT generates a JWT token for the specified user ID
-func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
-	signingKey := []byte(tokenSecret)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+// NewClaims returns the registered claims shared by every token issued for a user
+func NewClaims(userID uuid.UUID, expiresIn time.Duration) jwt.RegisteredClaims {
+	now := time.Now().UTC()
+	return jwt.RegisteredClaims{
 		Issuer:    string(TokenTypeAccess),
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
 		Subject:   userID.String(),
-	})
-	return token.SignedString(signingKey)
+	}
+}
+
+// AccessClaims are the claims of an access token. Roles, permissions and entitlements are copied from the
+// database when the token is issued, so changes to them take effect when the token is refreshed
+type AccessClaims struct {
+	jwt.RegisteredClaims
+	Roles []string `json:"roles,omitempty"`
+	// Scope is the space separated list of permissions granted by the roles
+	Scope   string `json:"scope,omitempty"`
+	Premium bool   `json:"premium"`
+	// Entitlements are the plans the user is entitled to
+	Entitlements []string `json:"entitlements,omitempty"`
+}
+
+// AccessGrants are what an access token grants the user besides their identity
+type AccessGrants struct {
+	Roles        []string
+	Permissions  []string
+	Premium      bool
+	Entitlements []string
+}
+
+// Permissions returns the permissions granted to the user
+func (c AccessClaims) Permissions() []string {
+	return strings.Fields(c.Scope)
+}
+
+// HasPermission reports whether the user was granted permission
+func (c AccessClaims) HasPermission(permission string) bool {
+	return slices.Contains(c.Permissions(), permission)
+}
+
+// HasRole reports whether the user had role when the token was issued
+func (c AccessClaims) HasRole(role string) bool {
+	return slices.Contains(c.Roles, role)
+}
+
+// HasEntitlement reports whether the user was entitled to plan when the token was issued
+func (c AccessClaims) HasEntitlement(plan string) bool {
+	return slices.Contains(c.Entitlements, plan)
+}
+
+// MakeJWT generates a JWT token for the specified user ID
+func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	return MakeAccessToken(userID, AccessGrants{}, tokenSecret, expiresIn)
+}
+
+// MakeAccessToken generates an access token for the user carrying their roles, permissions and entitlements
+func MakeAccessToken(userID uuid.UUID, grants AccessGrants, tokenSecret string, expiresIn time.Duration) (string, error) {
+	claims := AccessClaims{
+		RegisteredClaims: NewClaims(userID, expiresIn),
+		Roles:            grants.Roles,
+		Scope:            strings.Join(grants.Permissions, " "),
+		Premium:          grants.Premium,
+		Entitlements:     grants.Entitlements,
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(tokenSecret))
+}
+
+// ValidateJWT checks the signature, expiry and issuer of an access token made by MakeJWT and returns its user ID
+func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
+	claims, err := ValidateAccessClaims(tokenString, tokenSecret)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return uuid.Parse(claims.Subject)
+}
+
+// ValidateAccessClaims checks an access token like ValidateJWT and returns all of its claims
+func ValidateAccessClaims(tokenString, tokenSecret string) (AccessClaims, error) {
+	claims := AccessClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(tokenSecret), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(string(TokenTypeAccess)),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return AccessClaims{}, err
+	}
+
+	return claims, nil
 }
 
 // MakeRefreshToken generates a secure random token for refresh authentication
@@ -42,6 +135,21 @@ func MakeRefreshToken() (string, error) {
 	return hex.EncodeToString(token), nil
 }
 
+// SessionID identifies the session of a refresh token in logs and security events without revealing the token
+func SessionID(refreshToken string) string {
+	if refreshToken == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(sum[:8])
+}
+
+// HashRefreshToken returns the hash a rotated refresh token is remembered by
+func HashRefreshToken(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+	return hex.EncodeToString(sum[:])
+}
+
 // HashPassword hashes the user's password using bcrypt
 func HashPassword(password string) (string, error) {
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
@@ -59,25 +167,129 @@ func CheckPassword(hashedPassword, password string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 }
 
-// GenerateVerificationCode generates a random 4-digit verification code
-func GenerateVerificationCode() (int32, error) {
-	var code int32
-	b := make([]byte, 4)
-	_, err := rand.Read(b)
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// CheckDummyPassword compares the password against a throwaway hash. It is used when a user does not exist
+// so that a failed login takes as long as a wrong password and response time doesn't reveal registered accounts
+func CheckDummyPassword(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+	})
+	_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
+
+// Verification code length limits. Codes are int32 values, so they can't have more than 9 digits
+const (
+	MinVerificationCodeLength     = 4
+	MaxVerificationCodeLength     = 9
+	DefaultVerificationCodeLength = 6
+)
+
+// GenerateVerificationCode generates a random verification code with the given number of digits.
+// The first digit is never zero, so the code keeps its length when shown as a number
+func GenerateVerificationCode(length int) (int32, error) {
+	if length < MinVerificationCodeLength || length > MaxVerificationCodeLength {
+		return 0, fmt.Errorf("verification code length should be between %d and %d, got %d",
+			MinVerificationCodeLength, MaxVerificationCodeLength, length)
+	}
+
+	lowest := int64(1)
+	for i := 1; i < length; i++ {
+		lowest *= 10
+	}
+
+	n, err := rand.Int(rand.Reader, big.NewInt(9*lowest))
 	if err != nil {
 		return 0, err
 	}
-	code = 1000 + (int32(b[0])|int32(b[1])<<8|int32(b[2])<<16|int32(b[3])<<24)%9000
-	return code, nil
+
+	return int32(lowest + n.Int64()), nil
+}
+
+// HashVerificationCode returns a keyed hash of a verification code for storage.
+// Codes are short enough to be brute forced from a plain hash, so the hash is keyed with a server secret
+func HashVerificationCode(code int32, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.Itoa(int(code))))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CheckVerificationCode reports whether the code matches the stored hash, in constant time
+func CheckVerificationCode(hash string, code int32, secret string) bool {
+	return hmac.Equal([]byte(hash), []byte(HashVerificationCode(code, secret)))
 }
 
 // SendVerificationEmail sends an email with a verification code using SMTP protocol
-func SendVerificationEmail(email, emailSender, emailSecret string, code int32) error {
-	from := emailSender
-	password := emailSecret
-	to := email
-	subject := "Email Verification"
-	body := fmt.Sprintf("Your verification code is: %d", code)
+func SendVerificationEmail(email, emailSender, emailSecret string, code int32, expiresIn time.Duration) error {
+	body := fmt.Sprintf("Your verification code is: %d\n\nThe code expires in %d minutes.", code, int(expiresIn.Minutes()))
+	return sendEmail(email, emailSender, emailSecret, "Email Verification", body)
+}
+
+// SendLoginCodeEmail sends an email with a one time login code
+func SendLoginCodeEmail(email, emailSender, emailSecret string, code int32, expiresIn time.Duration) error {
+	body := fmt.Sprintf("Your login code is: %d\n\nThe code expires in %d minutes. If you didn't try to log in, you can ignore this email.",
+		code, int(expiresIn.Minutes()))
+	return sendEmail(email, emailSender, emailSecret, "Your login code", body)
+}
+
+// SendLoginLinkEmail sends an email with a single use login link
+func SendLoginLinkEmail(email, emailSender, emailSecret, link string, expiresIn time.Duration) error {
+	body := fmt.Sprintf("Follow this link to log in:\n\n%s\n\nThe link works once, on the device that requested it, and expires in %d minutes. "+
+		"If you didn't try to log in, you can ignore this email.", link, int(expiresIn.Minutes()))
+	return sendEmail(email, emailSender, emailSecret, "Your login link", body)
+}
+
+// SendNewSignInEmail tells the user about a login from a device they haven't used before. reportLink lets them
+// end the session and reset their password if it wasn't them
+func SendNewSignInEmail(email, emailSender, emailSecret, device, network string, at time.Time, reportLink string) error {
+	body := fmt.Sprintf("Your account was just used to log in from a new device.\n\n"+
+		"Device: %s\nNetwork: %s\nTime: %s\n\n"+
+		"If this was you, you can ignore this email. If it wasn't, follow this link to sign the device out and reset your password:\n\n%s",
+		device, network, at.UTC().Format(time.RFC1123), reportLink)
+	return sendEmail(email, emailSender, emailSecret, "New sign-in to your account", body)
+}
+
+// SendEmailChangeCodeEmail sends the code that confirms newEmail as the new address of an account
+func SendEmailChangeCodeEmail(newEmail, emailSender, emailSecret string, code int32, expiresIn time.Duration) error {
+	body := fmt.Sprintf("Your code to confirm this address is: %d\n\nThe code expires in %d minutes. "+
+		"If you didn't ask to change the email address of your account, you can ignore this email.", code, int(expiresIn.Minutes()))
+	return sendEmail(newEmail, emailSender, emailSecret, "Confirm your new email address", body)
+}
+
+// SendEmailChangeRequestedEmail tells the user at their current address that a change to newEmail was requested
+func SendEmailChangeRequestedEmail(email, emailSender, emailSecret, newEmail string) error {
+	body := fmt.Sprintf("A change of the email address of your account to %s was requested. "+
+		"The change happens once the code sent to the new address is entered.\n\n"+
+		"If this wasn't you, change your password now.", newEmail)
+	return sendEmail(email, emailSender, emailSecret, "Email change requested", body)
+}
+
+// SendEmailChangedEmail tells the user at their old address that the account moved to newEmail. An empty undoLink
+// leaves the undo paragraph out
+func SendEmailChangedEmail(email, emailSender, emailSecret, newEmail, undoLink string, undoFor time.Duration) error {
+	body := fmt.Sprintf("The email address of your account was changed to %s.", newEmail)
+	if undoLink != "" {
+		body += fmt.Sprintf("\n\nIf this wasn't you, follow this link within %d days to move the account back to this address, "+
+			"sign out every session and reset your password:\n\n%s", int(undoFor.Hours()/24), undoLink)
+	}
+	return sendEmail(email, emailSender, emailSecret, "Your email address was changed", body)
+}
+
+// SendAccountDeletionScheduledEmail tells the user that their account will be deleted at purgeAt. An empty
+// cancelLink leaves the cancel paragraph out
+func SendAccountDeletionScheduledEmail(email, emailSender, emailSecret, cancelLink string, purgeAt time.Time) error {
+	body := fmt.Sprintf("Your account was signed out everywhere and will be deleted on %s.", purgeAt.UTC().Format(time.RFC1123))
+	if cancelLink != "" {
+		body += fmt.Sprintf("\n\nIf you changed your mind or this wasn't you, follow this link before then to keep the account:\n\n%s", cancelLink)
+	}
+	return sendEmail(email, emailSender, emailSecret, "Your account will be deleted", body)
+}
+
+// sendEmail sends a plain text email through the gmail SMTP server
+func sendEmail(to, from, password, subject, body string) error {
 	msg := "From: " + from + "\n" +
 		"To: " + to + "\n" +
 		"Subject: " + subject + "\n\n" +
@@ -1,6 +1,7 @@
 package auth
 
 import (
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -40,6 +41,77 @@ func TestMakeJWT(t *testing.T) {
 	assert.Equal(t, 3, len(parts))
 }
 
+func TestValidateJWT(t *testing.T) {
+	userID := uuid.New()
+	tokenSecret := "token-secret"
+
+	validToken, _ := MakeJWT(userID, tokenSecret, time.Hour)
+	expiredToken, _ := MakeJWT(userID, tokenSecret, -time.Hour)
+
+	signingKey, err := GenerateSigningKey()
+	assert.NoError(t, err)
+	idToken, _ := MakeIDToken(signingKey, NewIDTokenClaims(userID, "https://issuer.example.com", "client", time.Hour))
+
+	testCases := []struct {
+		name        string
+		token       string
+		secret      string
+		expectError bool
+	}{
+		{name: "valid token", token: validToken, secret: tokenSecret},
+		{name: "wrong secret", token: validToken, secret: "other-secret", expectError: true},
+		{name: "expired token", token: expiredToken, secret: tokenSecret, expectError: true},
+		{name: "ID token is not an access token", token: idToken, secret: tokenSecret, expectError: true},
+		{name: "garbage", token: "not-a-token", secret: tokenSecret, expectError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			parsedID, err := ValidateJWT(tc.token, tc.secret)
+			if tc.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, userID, parsedID)
+			}
+		})
+	}
+}
+
+func TestMakeAccessToken(t *testing.T) {
+	userID := uuid.New()
+	tokenSecret := "token-secret"
+
+	token, err := MakeAccessToken(userID, AccessGrants{
+		Roles:        []string{"admin"},
+		Permissions:  []string{"roles:read", "roles:write"},
+		Premium:      true,
+		Entitlements: []string{"premium"},
+	}, tokenSecret, time.Hour)
+	assert.NoError(t, err)
+
+	claims, err := ValidateAccessClaims(token, tokenSecret)
+	assert.NoError(t, err)
+	assert.Equal(t, userID.String(), claims.Subject)
+	assert.Equal(t, []string{"admin"}, claims.Roles)
+	assert.Equal(t, []string{"roles:read", "roles:write"}, claims.Permissions())
+	assert.True(t, claims.HasRole("admin"))
+	assert.True(t, claims.HasPermission("roles:write"))
+	assert.False(t, claims.HasPermission("users:write"))
+	assert.True(t, claims.Premium)
+	assert.True(t, claims.HasEntitlement("premium"))
+	assert.False(t, claims.HasEntitlement("team"))
+
+	plain, err := MakeJWT(userID, tokenSecret, time.Hour)
+	assert.NoError(t, err)
+	claims, err = ValidateAccessClaims(plain, tokenSecret)
+	assert.NoError(t, err)
+	assert.Empty(t, claims.Roles)
+	assert.Empty(t, claims.Permissions())
+	assert.False(t, claims.Premium)
+	assert.Empty(t, claims.Entitlements)
+}
+
 func TestMakeRefreshToken(t *testing.T) {
 	token1, err := MakeRefreshToken()
 	assert.NoError(t, err)
@@ -54,24 +126,68 @@ func TestMakeRefreshToken(t *testing.T) {
 	assert.Equal(t, 64, len(token1))
 }
 
-func TestGenerateVerificationCode(t *testing.T) {
-	code, err := GenerateVerificationCode()
+func TestSessionID(t *testing.T) {
+	token1, err := MakeRefreshToken()
 	assert.NoError(t, err)
-	assert.NotEmpty(t, code)
+	token2, err := MakeRefreshToken()
+	assert.NoError(t, err)
+
+	assert.Len(t, SessionID(token1), 16)
+	assert.Equal(t, SessionID(token1), SessionID(token1))
+	assert.NotEqual(t, SessionID(token1), SessionID(token2))
+	assert.NotContains(t, token1, SessionID(token1))
+	assert.Empty(t, SessionID(""))
+}
+
+func TestHashRefreshToken(t *testing.T) {
+	token1, err := MakeRefreshToken()
+	assert.NoError(t, err)
+	token2, err := MakeRefreshToken()
+	assert.NoError(t, err)
+
+	assert.Len(t, HashRefreshToken(token1), 64)
+	assert.Equal(t, HashRefreshToken(token1), HashRefreshToken(token1))
+	assert.NotEqual(t, HashRefreshToken(token1), HashRefreshToken(token2))
+}
 
-	// Verify code is in range 1000-9999
-	assert.GreaterOrEqual(t, code, int32(1000))
-	assert.LessOrEqual(t, code, int32(9999))
+func TestGenerateVerificationCode(t *testing.T) {
+	for length := MinVerificationCodeLength; length <= MaxVerificationCodeLength; length++ {
+		for i := 0; i < 100; i++ {
+			code, err := GenerateVerificationCode(length)
+			assert.NoError(t, err)
+
+			// Verify code always has the requested number of digits
+			assert.Len(t, strconv.Itoa(int(code)), length)
+		}
+	}
 
 	// Verify different codes are generated
-	code2, err := GenerateVerificationCode()
+	code, err := GenerateVerificationCode(DefaultVerificationCodeLength)
+	assert.NoError(t, err)
+	code2, err := GenerateVerificationCode(DefaultVerificationCodeLength)
+	assert.NoError(t, err)
 
 	// Checks if the second verification code is same as first
 	if code == code2 {
-		code2, err = GenerateVerificationCode()
+		code2, err = GenerateVerificationCode(DefaultVerificationCodeLength)
 		assert.NoError(t, err)
 		assert.NotEqual(t, code, code2)
 	}
 
-	assert.NoError(t, err)
+	// Lengths outside of the supported range are rejected
+	_, err = GenerateVerificationCode(MinVerificationCodeLength - 1)
+	assert.Error(t, err)
+	_, err = GenerateVerificationCode(MaxVerificationCodeLength + 1)
+	assert.Error(t, err)
+}
+
+func TestHashAndCheckVerificationCode(t *testing.T) {
+	hash := HashVerificationCode(123456, "secret")
+	assert.NotContains(t, hash, "123456")
+
+	assert.True(t, CheckVerificationCode(hash, 123456, "secret"))
+	assert.False(t, CheckVerificationCode(hash, 654321, "secret"))
+
+	// A hash computed with another secret doesn't match
+	assert.False(t, CheckVerificationCode(hash, 123456, "other-secret"))
 }
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// TokenTypeDataExport is the issuer of data export download tokens. Neither ValidateJWT nor ParseTokenType accept
+// them, so a download token can't be used to call the API
+const TokenTypeDataExport TokenType = "media-data-export"
+
+// MakeDataExportToken generates a token that lets its bearer download the archive of one data export of a user
+func MakeDataExportToken(userID, exportID uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
+	claims := NewClaims(userID, expiresIn)
+	claims.Issuer = string(TokenTypeDataExport)
+	claims.ID = exportID.String()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(tokenSecret))
+}
+
+// ValidateDataExportToken checks the signature, expiry and issuer of a token made by MakeDataExportToken and returns
+// the user and export it is for
+func ValidateDataExportToken(tokenString, tokenSecret string) (userID, exportID uuid.UUID, err error) {
+	claims := jwt.RegisteredClaims{}
+	_, err = jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(tokenSecret), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(string(TokenTypeDataExport)),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+
+	if userID, err = uuid.Parse(claims.Subject); err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+	if exportID, err = uuid.Parse(claims.ID); err != nil {
+		return uuid.Nil, uuid.Nil, err
+	}
+	return userID, exportID, nil
+}
@@ -0,0 +1,41 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataExportToken(t *testing.T) {
+	secret := "test-secret"
+	userID, exportID := uuid.New(), uuid.New()
+
+	token, err := MakeDataExportToken(userID, exportID, secret, time.Minute)
+	require.NoError(t, err)
+
+	gotUser, gotExport, err := ValidateDataExportToken(token, secret)
+	require.NoError(t, err)
+	assert.Equal(t, userID, gotUser)
+	assert.Equal(t, exportID, gotExport)
+
+	_, _, err = ValidateDataExportToken(token, "wrong-secret")
+	assert.Error(t, err)
+
+	_, err = ValidateJWT(token, secret)
+	assert.Error(t, err, "download tokens are not access tokens")
+	_, err = ParseTokenType(token, secret)
+	assert.Error(t, err)
+
+	accessToken, err := MakeJWT(userID, secret, time.Minute)
+	require.NoError(t, err)
+	_, _, err = ValidateDataExportToken(accessToken, secret)
+	assert.Error(t, err, "access tokens are not download tokens")
+
+	expired, err := MakeDataExportToken(userID, exportID, secret, -time.Minute)
+	require.NoError(t, err)
+	_, _, err = ValidateDataExportToken(expired, secret)
+	assert.Error(t, err)
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"strings"
+)
+
+// userCodeAlphabet has no vowels, so user codes never spell words, and no characters that are easy to
+// confuse on a TV screen, as recommended by RFC 8628 section 6.1
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// UserCodeLength is the number of characters in a user code, not counting the separator
+const UserCodeLength = 8
+
+// MakeDeviceCode generates the secret code a device polls with
+func MakeDeviceCode() (string, error) {
+	return MakeRefreshToken()
+}
+
+// HashDeviceCode returns the hash a device code is stored under
+func HashDeviceCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// MakeUserCode generates the short code a user types to approve a device, formatted like "WDJB-MJHT"
+func MakeUserCode() (string, error) {
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	code := make([]byte, UserCodeLength)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(code[:UserCodeLength/2]) + "-" + string(code[UserCodeLength/2:]), nil
+}
+
+// NormalizeUserCode makes user codes typed by people comparable: case, dashes and spaces are ignored
+func NormalizeUserCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '-' || r == ' ':
+			return -1
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		default:
+			return r
+		}
+	}, code)
+}
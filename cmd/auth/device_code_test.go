@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMakeUserCode(t *testing.T) {
+	format := regexp.MustCompile(`^[BCDFGHJKLMNPQRSTVWXZ]{4}-[BCDFGHJKLMNPQRSTVWXZ]{4}$`)
+
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		code, err := MakeUserCode()
+		require.NoError(t, err)
+		assert.Regexp(t, format, code)
+		seen[code] = true
+	}
+	assert.Greater(t, len(seen), 90)
+}
+
+func TestNormalizeUserCode(t *testing.T) {
+	assert.Equal(t, "WDJBMJHT", NormalizeUserCode("WDJB-MJHT"))
+	assert.Equal(t, "WDJBMJHT", NormalizeUserCode("wdjb mjht"))
+	assert.Equal(t, "WDJBMJHT", NormalizeUserCode("wdjbmjht"))
+}
+
+func TestDeviceCode(t *testing.T) {
+	code, err := MakeDeviceCode()
+	require.NoError(t, err)
+	other, err := MakeDeviceCode()
+	require.NoError(t, err)
+
+	assert.NotEqual(t, code, other)
+	assert.Equal(t, HashDeviceCode(code), HashDeviceCode(code))
+	assert.NotEqual(t, HashDeviceCode(code), HashDeviceCode(other))
+	assert.NotContains(t, HashDeviceCode(code), code)
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// IDTokenClaims are the claims of an OpenID Connect ID token. They extend the claims of access tokens
+// with the audience, nonce and profile of the user
+type IDTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce             string `json:"nonce,omitempty"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+}
+
+// NewIDTokenClaims builds the ID token claims for userID on top of the access token claims
+func NewIDTokenClaims(userID uuid.UUID, issuer, clientID string, expiresIn time.Duration) IDTokenClaims {
+	claims := IDTokenClaims{RegisteredClaims: NewClaims(userID, expiresIn)}
+	claims.Issuer = issuer
+	claims.Audience = jwt.ClaimStrings{clientID}
+	return claims
+}
+
+// SigningKey is the RSA key ID tokens are signed with. Its public part is published as a JWK
+type SigningKey struct {
+	ID  string
+	Key *rsa.PrivateKey
+}
+
+// NewSigningKey wraps key and derives its key ID from the public key
+func NewSigningKey(key *rsa.PrivateKey) (*SigningKey, error) {
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(der)
+	return &SigningKey{
+		ID:  base64.RawURLEncoding.EncodeToString(sum[:12]),
+		Key: key,
+	}, nil
+}
+
+// GenerateSigningKey creates a new 2048 bit RSA signing key
+func GenerateSigningKey() (*SigningKey, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return NewSigningKey(key)
+}
+
+// LoadSigningKey reads a PEM encoded RSA private key in PKCS #1 or PKCS #8 form
+func LoadSigningKey(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return NewSigningKey(key)
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New(path + ": signing key should be an RSA key")
+	}
+	return NewSigningKey(key)
+}
+
+// MakeIDToken signs the ID token claims with RS256
+func MakeIDToken(key *SigningKey, claims IDTokenClaims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Key)
+}
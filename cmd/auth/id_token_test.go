@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMakeIDToken(t *testing.T) {
+	signingKey, err := GenerateSigningKey()
+	require.NoError(t, err)
+
+	userID := uuid.New()
+	verified := true
+	claims := NewIDTokenClaims(userID, "https://issuer.example.com", "client-1", time.Hour)
+	claims.Nonce = "nonce-1"
+	claims.Email = "user@example.com"
+	claims.EmailVerified = &verified
+
+	token, err := MakeIDToken(signingKey, claims)
+	require.NoError(t, err)
+
+	parsed := IDTokenClaims{}
+	jwtToken, err := jwt.ParseWithClaims(token, &parsed, func(token *jwt.Token) (interface{}, error) {
+		return &signingKey.Key.PublicKey, nil
+	}, jwt.WithValidMethods([]string{"RS256"}), jwt.WithAudience("client-1"), jwt.WithIssuer("https://issuer.example.com"))
+	require.NoError(t, err)
+
+	assert.Equal(t, signingKey.ID, jwtToken.Header["kid"])
+	assert.Equal(t, userID.String(), parsed.Subject)
+	assert.Equal(t, "nonce-1", parsed.Nonce)
+	assert.Equal(t, "user@example.com", parsed.Email)
+	assert.True(t, *parsed.EmailVerified)
+}
+
+func TestLoadSigningKey(t *testing.T) {
+	signingKey, err := GenerateSigningKey()
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+
+	pkcs1 := filepath.Join(dir, "pkcs1.pem")
+	require.NoError(t, os.WriteFile(pkcs1, pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(signingKey.Key),
+	}), 0o600))
+
+	der, err := x509.MarshalPKCS8PrivateKey(signingKey.Key)
+	require.NoError(t, err)
+	pkcs8 := filepath.Join(dir, "pkcs8.pem")
+	require.NoError(t, os.WriteFile(pkcs8, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+
+	for _, path := range []string{pkcs1, pkcs8} {
+		loaded, err := LoadSigningKey(path)
+		require.NoError(t, err)
+		assert.Equal(t, signingKey.ID, loaded.ID)
+		assert.True(t, signingKey.Key.Equal(loaded.Key))
+	}
+
+	invalid := filepath.Join(dir, "invalid.pem")
+	require.NoError(t, os.WriteFile(invalid, []byte("not a key"), 0o600))
+	_, err = LoadSigningKey(invalid)
+	assert.Error(t, err)
+}
+
+func TestClientSecret(t *testing.T) {
+	secret, err := MakeClientSecret()
+	require.NoError(t, err)
+
+	other, err := MakeClientSecret()
+	require.NoError(t, err)
+	assert.NotEqual(t, secret, other)
+
+	hash := HashClientSecret(secret)
+	assert.NotEqual(t, secret, hash)
+	assert.True(t, CheckClientSecret(hash, secret))
+	assert.False(t, CheckClientSecret(hash, other))
+	assert.False(t, CheckClientSecret(hash, ""))
+}
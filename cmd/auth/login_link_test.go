@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginLinkToken(t *testing.T) {
+	userID := uuid.New()
+	secret := "token-secret"
+
+	token, err := MakeLoginLinkToken(userID, secret, time.Now().Add(15*time.Minute))
+	assert.NoError(t, err)
+
+	otherToken, err := MakeLoginLinkToken(userID, secret, time.Now().Add(15*time.Minute))
+	assert.NoError(t, err)
+	assert.NotEqual(t, token, otherToken)
+
+	expiredToken, err := MakeLoginLinkToken(userID, secret, time.Now().Add(-time.Minute))
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name        string
+		token       string
+		secret      string
+		expectedErr error
+	}{
+		{
+			name:   "valid token",
+			token:  token,
+			secret: secret,
+		},
+		{
+			name:        "wrong secret",
+			token:       token,
+			secret:      "other-secret",
+			expectedErr: ErrLoginLinkInvalid,
+		},
+		{
+			name:        "tampered payload",
+			token:       "A" + token[1:],
+			secret:      secret,
+			expectedErr: ErrLoginLinkInvalid,
+		},
+		{
+			name:        "missing signature",
+			token:       token[:len(token)-44],
+			secret:      secret,
+			expectedErr: ErrLoginLinkInvalid,
+		},
+		{
+			name:        "garbage",
+			token:       "not-a-token",
+			secret:      secret,
+			expectedErr: ErrLoginLinkInvalid,
+		},
+		{
+			name:        "expired token",
+			token:       expiredToken,
+			secret:      secret,
+			expectedErr: ErrLoginLinkExpired,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			parsedID, err := ParseLoginLinkToken(tc.token, tc.secret)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+				assert.Equal(t, uuid.Nil, parsedID)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, userID, parsedID)
+			}
+		})
+	}
+}
+
+func TestHashLoginLinkToken(t *testing.T) {
+	hash := HashLoginLinkToken("token", "secret")
+	assert.Len(t, hash, 64)
+	assert.Equal(t, hash, HashLoginLinkToken("token", "secret"))
+	assert.NotEqual(t, hash, HashLoginLinkToken("token", "other-secret"))
+	assert.NotEqual(t, hash, HashDeviceID("token", "secret"))
+
+	assert.True(t, CheckLoginLinkToken(hash, "token", "secret"))
+	assert.False(t, CheckLoginLinkToken(hash, "other-token", "secret"))
+}
+
+func TestCheckDeviceID(t *testing.T) {
+	secret := "secret"
+	hash := HashDeviceID("device-1", secret)
+
+	assert.Empty(t, HashDeviceID("", secret))
+	assert.True(t, CheckDeviceID(hash, "device-1", secret))
+	assert.False(t, CheckDeviceID(hash, "device-2", secret))
+	assert.False(t, CheckDeviceID(hash, "", secret))
+
+	// Codes that aren't bound to a device are accepted from anywhere
+	assert.True(t, CheckDeviceID("", "device-1", secret))
+	assert.True(t, CheckDeviceID("", "", secret))
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/netip"
+)
+
+// DeviceFingerprint identifies the client a login came from by its user agent, the network it connected from
+// and the device ID it sent, if any. It also returns the network, the /24 of an IPv4 address or the /64 of an
+// IPv6 one, so moving around within a network doesn't look like a new device
+func DeviceFingerprint(userAgent, ip, deviceID string) (fingerprint, network string) {
+	network = IPPrefix(ip)
+	sum := sha256.Sum256([]byte(userAgent + "\n" + network + "\n" + deviceID))
+	return hex.EncodeToString(sum[:]), network
+}
+
+// IPPrefix returns the network of ip, or "" when it isn't an IP address
+func IPPrefix(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+
+	bits := 64
+	if addr.Is4() {
+		bits = 24
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ""
+	}
+	return prefix.String()
+}
+
+// MakeSignInReportToken generates the token of the "this wasn't me" link in new sign-in emails
+func MakeSignInReportToken() (string, error) {
+	return MakeRefreshToken()
+}
+
+// MakePasswordResetToken generates a single use token that lets the user set a new password
+func MakePasswordResetToken() (string, error) {
+	return MakeRefreshToken()
+}
+
+// MakeEmailChangeUndoToken generates the token of the undo link sent to the old address after an email change
+func MakeEmailChangeUndoToken() (string, error) {
+	return MakeRefreshToken()
+}
+
+// MakeAccountDeletionCancelToken generates the token of the cancel link sent after a user asked to delete their account
+func MakeAccountDeletionCancelToken() (string, error) {
+	return MakeRefreshToken()
+}
+
+// HashSingleUseToken returns the hash sign-in report, password reset, email change undo and account deletion cancel
+// tokens are stored under
+func HashSingleUseToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIPPrefix(t *testing.T) {
+	testCases := []struct {
+		ip       string
+		expected string
+	}{
+		{ip: "203.0.113.7", expected: "203.0.113.0/24"},
+		{ip: "::ffff:203.0.113.7", expected: "203.0.113.0/24"},
+		{ip: "2001:db8:1:2:3:4:5:6", expected: "2001:db8:1:2::/64"},
+		{ip: "", expected: ""},
+		{ip: "not an ip", expected: ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.ip, func(t *testing.T) {
+			assert.Equal(t, tc.expected, IPPrefix(tc.ip))
+		})
+	}
+}
+
+func TestDeviceFingerprint(t *testing.T) {
+	fingerprint, network := DeviceFingerprint("app/1.0", "203.0.113.7", "device-1")
+	assert.Equal(t, "203.0.113.0/24", network)
+	assert.Len(t, fingerprint, 64)
+
+	sameNetwork, _ := DeviceFingerprint("app/1.0", "203.0.113.200", "device-1")
+	assert.Equal(t, fingerprint, sameNetwork)
+
+	for name, other := range map[string][3]string{
+		"other user agent": {"app/2.0", "203.0.113.7", "device-1"},
+		"other network":    {"app/1.0", "198.51.100.7", "device-1"},
+		"other device":     {"app/1.0", "203.0.113.7", "device-2"},
+	} {
+		otherFingerprint, _ := DeviceFingerprint(other[0], other[1], other[2])
+		assert.NotEqual(t, fingerprint, otherFingerprint, name)
+	}
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// TokenTypeOIDC is the issuer of the access tokens the OpenID Connect provider gives its clients. Neither
+// ValidateJWT nor ParseTokenType accept them, so a relying party can only use them at the userinfo endpoint and
+// never to call the API as the user
+const TokenTypeOIDC TokenType = "media-oidc"
+
+// OIDCAccessClaims are the claims of an access token issued by the OpenID Connect provider. The audience is the
+// client the token was issued to and the scope lists the scopes the user granted it
+type OIDCAccessClaims struct {
+	jwt.RegisteredClaims
+	Scope string `json:"scope,omitempty"`
+}
+
+// Scopes returns the scopes the user granted the client
+func (c OIDCAccessClaims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// HasScope reports whether the user granted the client scope
+func (c OIDCAccessClaims) HasScope(scope string) bool {
+	return slices.Contains(c.Scopes(), scope)
+}
+
+// MakeOIDCAccessToken generates the access token the OpenID Connect provider gives clientID for the user
+func MakeOIDCAccessToken(userID uuid.UUID, clientID string, scopes []string, tokenSecret string, expiresIn time.Duration) (string, error) {
+	claims := OIDCAccessClaims{
+		RegisteredClaims: NewClaims(userID, expiresIn),
+		Scope:            strings.Join(scopes, " "),
+	}
+	claims.Issuer = string(TokenTypeOIDC)
+	claims.Audience = jwt.ClaimStrings{clientID}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(tokenSecret))
+}
+
+// ValidateOIDCAccessToken checks the signature, expiry and issuer of a token made by MakeOIDCAccessToken and
+// returns its claims
+func ValidateOIDCAccessToken(tokenString, tokenSecret string) (OIDCAccessClaims, error) {
+	claims := OIDCAccessClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(tokenSecret), nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(string(TokenTypeOIDC)),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return OIDCAccessClaims{}, err
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOIDCAccessToken(t *testing.T) {
+	secret := "test-secret"
+	userID := uuid.New()
+
+	token, err := MakeOIDCAccessToken(userID, "web", []string{"openid", "email"}, secret, time.Minute)
+	require.NoError(t, err)
+
+	claims, err := ValidateOIDCAccessToken(token, secret)
+	require.NoError(t, err)
+	assert.Equal(t, userID.String(), claims.Subject)
+	assert.Equal(t, []string{"web"}, []string(claims.Audience))
+	assert.Equal(t, []string{"openid", "email"}, claims.Scopes())
+	assert.True(t, claims.HasScope("email"))
+	assert.False(t, claims.HasScope("profile"))
+
+	_, err = ValidateOIDCAccessToken(token, "wrong-secret")
+	assert.Error(t, err)
+
+	_, err = ValidateJWT(token, secret)
+	assert.Error(t, err, "tokens of relying parties are not access tokens")
+	_, err = ValidateAccessClaims(token, secret)
+	assert.Error(t, err)
+	_, err = ParseTokenType(token, secret)
+	assert.Error(t, err)
+
+	accessToken, err := MakeJWT(userID, secret, time.Minute)
+	require.NoError(t, err)
+	_, err = ValidateOIDCAccessToken(accessToken, secret)
+	assert.Error(t, err, "access tokens are not tokens of relying parties")
+
+	expired, err := MakeOIDCAccessToken(userID, "web", []string{"openid"}, secret, -time.Minute)
+	require.NoError(t, err)
+	_, err = ValidateOIDCAccessToken(expired, secret)
+	assert.Error(t, err)
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceToken(t *testing.T) {
+	secret := "test-secret"
+
+	token, err := MakeServiceToken("post-service", []string{"users:read", "users:write"},
+		[]string{"auth-service", "messaging"}, secret, time.Minute)
+	require.NoError(t, err)
+
+	claims, err := ValidateServiceToken(token, secret, "messaging")
+	require.NoError(t, err)
+	assert.Equal(t, "post-service", claims.Subject)
+	assert.Equal(t, []string{"users:read", "users:write"}, claims.Scopes())
+	assert.True(t, claims.HasScope("users:read"))
+	assert.False(t, claims.HasScope("users:delete"))
+	assert.NotEmpty(t, claims.ID)
+
+	_, err = ValidateServiceToken(token, secret, "billing")
+	assert.Error(t, err, "token issued for other audiences")
+
+	_, err = ValidateServiceToken(token, secret, "")
+	assert.ErrorIs(t, err, ErrAudienceRequired)
+
+	_, err = ValidateServiceToken(token, "wrong-secret", "messaging")
+	assert.Error(t, err)
+
+	_, err = ValidateJWT(token, secret)
+	assert.Error(t, err, "service tokens are not user tokens")
+
+	expired, err := MakeServiceToken("post-service", nil, []string{"messaging"}, secret, -time.Minute)
+	require.NoError(t, err)
+	_, err = ValidateServiceToken(expired, secret, "messaging")
+	assert.Error(t, err)
+}
+
+func TestValidateServiceTokenRejectsUserTokens(t *testing.T) {
+	secret := "test-secret"
+
+	token, err := MakeJWT(uuid.New(), secret, time.Minute)
+	require.NoError(t, err)
+
+	_, err = ValidateServiceToken(token, secret, "auth-service")
+	assert.Error(t, err)
+}
+
+func TestParseTokenType(t *testing.T) {
+	secret := "test-secret"
+
+	userToken, err := MakeJWT(uuid.New(), secret, time.Minute)
+	require.NoError(t, err)
+	tokenType, err := ParseTokenType(userToken, secret)
+	require.NoError(t, err)
+	assert.Equal(t, TokenTypeAccess, tokenType)
+
+	serviceToken, err := MakeServiceToken("post-service", nil, []string{"messaging"}, secret, time.Minute)
+	require.NoError(t, err)
+	tokenType, err = ParseTokenType(serviceToken, secret)
+	require.NoError(t, err)
+	assert.Equal(t, TokenTypeService, tokenType)
+
+	_, err = ParseTokenType(serviceToken, "wrong-secret")
+	assert.Error(t, err)
+}
@@ -0,0 +1,208 @@
+// Package authz checks that the callers of gRPC methods hold the permissions the methods require. Permissions
+// come from the "scope" claim of access tokens, so the check needs no database and any service that shares the
+// token secret can use it. The auth service itself also checks that the calling user may still log in, see
+// WithUserCheck
+package authz
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/cmd/helper"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Policy maps full gRPC method names, like "/auth.AuthService/AssignRole", to the permission they require.
+// Methods that aren't listed can be called without a token
+type Policy map[string]string
+
+// Principal is the caller of a protected method
+type Principal struct {
+	// Subject is the user ID, or the client ID for service tokens
+	Subject     string
+	TokenType   auth.TokenType
+	Roles       []string
+	Permissions []string
+	// Premium and Entitlements are copied from access tokens, services have neither
+	Premium      bool
+	Entitlements []string
+}
+
+// HasPermission reports whether the caller was granted permission
+func (p Principal) HasPermission(permission string) bool {
+	return slices.Contains(p.Permissions, permission)
+}
+
+// HasEntitlement reports whether the calling user is entitled to plan
+func (p Principal) HasEntitlement(plan string) bool {
+	return slices.Contains(p.Entitlements, plan)
+}
+
+// UserID returns the ID of the calling user. It fails for services
+func (p Principal) UserID() (uuid.UUID, bool) {
+	if p.TokenType != auth.TokenTypeAccess {
+		return uuid.Nil, false
+	}
+	userID, err := uuid.Parse(p.Subject)
+	return userID, err == nil
+}
+
+type principalKey struct{}
+
+// NewContext returns a copy of ctx carrying the principal
+func NewContext(ctx context.Context, principal Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// FromContext returns the principal of a call that passed the interceptor
+func FromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+// BearerToken returns the token sent in the "authorization" metadata as "Bearer <token>"
+func BearerToken(ctx context.Context) (string, bool) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		if token, ok := strings.CutPrefix(value, "Bearer "); ok {
+			return strings.TrimSpace(token), true
+		}
+	}
+	return "", false
+}
+
+// UserCheck decides whether a user may still call protected methods with the access tokens issued to them, for
+// example because the account was suspended since. The error it returns is what the call fails with
+type UserCheck func(ctx context.Context, userID uuid.UUID) error
+
+// Option configures an Authorizer
+type Option func(*Authorizer)
+
+// WithUserCheck runs check for every call of a protected method made with an access token, after the token was
+// validated and before its permissions are checked
+func WithUserCheck(check UserCheck) Option {
+	return func(a *Authorizer) {
+		a.userCheck = check
+	}
+}
+
+// Authorizer enforces a Policy
+type Authorizer struct {
+	tokenSecret string
+	audience    string
+	policy      Policy
+	userCheck   UserCheck
+}
+
+// New creates an Authorizer for tokens signed with tokenSecret. Service tokens are accepted when they were issued
+// for audience, which is the name of the service being called. With an empty audience only users can call
+// protected methods
+func New(tokenSecret, audience string, policy Policy, opts ...Option) *Authorizer {
+	a := &Authorizer{
+		tokenSecret: tokenSecret,
+		audience:    audience,
+		policy:      policy,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Authorize checks the caller of method and returns ctx with the caller's Principal
+func (a *Authorizer) Authorize(ctx context.Context, method string) (context.Context, error) {
+	permission, protected := a.policy[method]
+	if !protected {
+		return ctx, nil
+	}
+
+	token, ok := BearerToken(ctx)
+	if !ok {
+		return nil, autherr.AccessTokenInvalid()
+	}
+
+	principal, err := a.principal(token)
+	if err != nil {
+		return nil, autherr.AccessTokenInvalid().WithCause(err)
+	}
+	if userID, ok := principal.UserID(); ok && a.userCheck != nil {
+		if err := a.userCheck(ctx, userID); err != nil {
+			return nil, err
+		}
+	}
+	if !principal.HasPermission(permission) {
+		return nil, autherr.PermissionDenied(permission)
+	}
+
+	return NewContext(ctx, principal), nil
+}
+
+// principal validates token and returns who it was issued to
+func (a *Authorizer) principal(token string) (Principal, error) {
+	tokenType, err := auth.ParseTokenType(token, a.tokenSecret)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	if tokenType == auth.TokenTypeService && a.audience != "" {
+		claims, err := auth.ValidateServiceToken(token, a.tokenSecret, a.audience)
+		if err != nil {
+			return Principal{}, err
+		}
+		return Principal{
+			Subject:     claims.Subject,
+			TokenType:   auth.TokenTypeService,
+			Permissions: claims.Scopes(),
+		}, nil
+	}
+
+	claims, err := auth.ValidateAccessClaims(token, a.tokenSecret)
+	if err != nil {
+		return Principal{}, err
+	}
+	return Principal{
+		Subject:      claims.Subject,
+		TokenType:    auth.TokenTypeAccess,
+		Roles:        claims.Roles,
+		Permissions:  claims.Permissions(),
+		Premium:      claims.Premium,
+		Entitlements: claims.Entitlements,
+	}, nil
+}
+
+// UnaryServerInterceptor rejects calls to protected methods unless the caller has the required permission
+func (a *Authorizer) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		authorized, err := a.Authorize(ctx, info.FullMethod)
+		if err != nil {
+			return nil, helper.RespondWithError(ctx, err)
+		}
+		return handler(authorized, req)
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streaming methods
+func (a *Authorizer) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		authorized, err := a.Authorize(ss.Context(), info.FullMethod)
+		if err != nil {
+			return helper.RespondWithError(ss.Context(), err)
+		}
+		return handler(srv, &serverStream{ServerStream: ss, ctx: authorized})
+	}
+}
+
+// serverStream replaces the context of a stream
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
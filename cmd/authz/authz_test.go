@@ -0,0 +1,165 @@
+package authz
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	tokenSecret     = "test-secret"
+	protectedMethod = "/auth.AuthService/AssignRole"
+	publicMethod    = "/auth.AuthService/Login"
+)
+
+var policy = Policy{protectedMethod: "roles:write"}
+
+func withToken(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+}
+
+func TestAuthorize(t *testing.T) {
+	userID := uuid.New()
+
+	admin, err := auth.MakeAccessToken(userID, auth.AccessGrants{Roles: []string{"admin"}, Permissions: []string{"roles:read", "roles:write"}}, tokenSecret, time.Hour)
+	require.NoError(t, err)
+	reader, err := auth.MakeAccessToken(userID, auth.AccessGrants{Roles: []string{"support"}, Permissions: []string{"roles:read"}}, tokenSecret, time.Hour)
+	require.NoError(t, err)
+	expired, err := auth.MakeAccessToken(userID, auth.AccessGrants{Roles: []string{"admin"}, Permissions: []string{"roles:write"}}, tokenSecret, -time.Hour)
+	require.NoError(t, err)
+	service, err := auth.MakeServiceToken("admin-panel", []string{"roles:write"}, []string{"auth-service"}, tokenSecret, time.Hour)
+	require.NoError(t, err)
+	otherService, err := auth.MakeServiceToken("admin-panel", []string{"roles:write"}, []string{"messaging"}, tokenSecret, time.Hour)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name        string
+		ctx         context.Context
+		method      string
+		audience    string
+		subject     string
+		errorCode   codes.Code
+		errorReason autherr.Reason
+	}{
+		{name: "public method without token", ctx: context.Background(), method: publicMethod},
+		{name: "admin", ctx: withToken(admin), method: protectedMethod, subject: userID.String()},
+		{name: "service with scope", ctx: withToken(service), method: protectedMethod, audience: "auth-service", subject: "admin-panel"},
+		{
+			name: "missing token", ctx: context.Background(), method: protectedMethod,
+			errorCode: codes.Unauthenticated, errorReason: autherr.ReasonAccessTokenInvalid,
+		},
+		{
+			name: "expired token", ctx: withToken(expired), method: protectedMethod,
+			errorCode: codes.Unauthenticated, errorReason: autherr.ReasonAccessTokenInvalid,
+		},
+		{
+			name: "missing permission", ctx: withToken(reader), method: protectedMethod,
+			errorCode: codes.PermissionDenied, errorReason: autherr.ReasonPermissionDenied,
+		},
+		{
+			name: "service for another audience", ctx: withToken(otherService), method: protectedMethod, audience: "auth-service",
+			errorCode: codes.Unauthenticated, errorReason: autherr.ReasonAccessTokenInvalid,
+		},
+		{
+			name: "services not accepted", ctx: withToken(service), method: protectedMethod,
+			errorCode: codes.Unauthenticated, errorReason: autherr.ReasonAccessTokenInvalid,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, err := New(tokenSecret, tc.audience, policy).Authorize(tc.ctx, tc.method)
+
+			if tc.errorReason != "" {
+				require.Error(t, err)
+				assert.Equal(t, tc.errorCode, status.Code(err))
+				assert.Equal(t, tc.errorReason, autherr.ReasonOf(err))
+				return
+			}
+
+			require.NoError(t, err)
+			principal, ok := FromContext(ctx)
+			assert.Equal(t, tc.subject != "", ok)
+			assert.Equal(t, tc.subject, principal.Subject)
+		})
+	}
+}
+
+func TestAuthorizeUserCheck(t *testing.T) {
+	suspendedID, activeID := uuid.New(), uuid.New()
+	var checked []uuid.UUID
+	authorizer := New(tokenSecret, "auth-service", policy, WithUserCheck(func(_ context.Context, userID uuid.UUID) error {
+		checked = append(checked, userID)
+		if userID == suspendedID {
+			return autherr.AccountSuspended(time.Time{})
+		}
+		return nil
+	}))
+	grants := auth.AccessGrants{Roles: []string{"admin"}, Permissions: []string{"roles:write"}}
+
+	suspended, err := auth.MakeAccessToken(suspendedID, grants, tokenSecret, time.Hour)
+	require.NoError(t, err)
+	_, err = authorizer.Authorize(withToken(suspended), protectedMethod)
+	assert.Equal(t, autherr.ReasonAccountSuspended, autherr.ReasonOf(err))
+
+	active, err := auth.MakeAccessToken(activeID, grants, tokenSecret, time.Hour)
+	require.NoError(t, err)
+	_, err = authorizer.Authorize(withToken(active), protectedMethod)
+	assert.NoError(t, err)
+
+	// Services have no account to check, and public methods aren't checked at all
+	service, err := auth.MakeServiceToken("admin-panel", []string{"roles:write"}, []string{"auth-service"}, tokenSecret, time.Hour)
+	require.NoError(t, err)
+	_, err = authorizer.Authorize(withToken(service), protectedMethod)
+	assert.NoError(t, err)
+	_, err = authorizer.Authorize(withToken(suspended), publicMethod)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []uuid.UUID{suspendedID, activeID}, checked)
+}
+
+func TestPrincipalUserID(t *testing.T) {
+	userID := uuid.New()
+
+	id, ok := Principal{Subject: userID.String(), TokenType: auth.TokenTypeAccess}.UserID()
+	assert.True(t, ok)
+	assert.Equal(t, userID, id)
+
+	_, ok = Principal{Subject: "admin-panel", TokenType: auth.TokenTypeService}.UserID()
+	assert.False(t, ok)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	interceptor := New(tokenSecret, "", policy).UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: protectedMethod}
+
+	called := false
+	handler := func(ctx context.Context, req any) (any, error) {
+		called = true
+		principal, ok := FromContext(ctx)
+		assert.True(t, ok)
+		assert.Equal(t, []string{"admin"}, principal.Roles)
+		return "ok", nil
+	}
+
+	_, err := interceptor(context.Background(), nil, info, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.False(t, called)
+
+	admin, err := auth.MakeAccessToken(uuid.New(), auth.AccessGrants{Roles: []string{"admin"}, Permissions: []string{"roles:write"}}, tokenSecret, time.Hour)
+	require.NoError(t, err)
+	response, err := interceptor(withToken(admin), nil, info, handler)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", response)
+	assert.True(t, called)
+}
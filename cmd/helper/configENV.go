@@ -3,7 +3,12 @@ package helper
 import (
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/imhasandl/auth-service/internal/oauth"
+	"github.com/imhasandl/auth-service/internal/outbox"
 	"github.com/joho/godotenv"
 )
 
@@ -15,6 +20,77 @@ type EnvConfig struct {
 	EmailSecret string
 	TokenSecret string
 	RedisSecret string
+	AutoMigrate bool
+
+	VerificationCodeLength int
+	VerificationCodeTTL    time.Duration
+
+	LoginLinkURL         string
+	LoginLinkTTL         time.Duration
+	LoginRateLimit       int
+	LoginRateLimitWindow time.Duration
+
+	ExternalProviders []oauth.Config
+
+	OIDCIssuerURL      string
+	OIDCHTTPPort       string
+	OIDCSigningKeyFile string
+
+	ServiceTokenTTL time.Duration
+
+	DeviceVerificationURL string
+	DeviceCodeTTL         time.Duration
+	DevicePollInterval    time.Duration
+
+	SuspensionSweepInterval time.Duration
+
+	AuthEventRetention     time.Duration
+	AuditTrustForwardedFor bool
+
+	SignInReportURL  string
+	PasswordResetTTL time.Duration
+
+	EmailChangeUndoURL     string
+	EmailChangeGracePeriod time.Duration
+
+	UsernameChangeCooldown time.Duration
+	UsernameHoldPeriod     time.Duration
+	ReservedUsernames      []string
+
+	EmailAllowedDomains             []string
+	EmailDeniedDomains              []string
+	DisposableDomainsFile           string
+	DisposableDomainsReloadInterval time.Duration
+
+	AccountDeletionCancelURL     string
+	AccountDeletionGracePeriod   time.Duration
+	AccountDeletionAnonymize     bool
+	AccountDeletionSweepInterval time.Duration
+
+	DataExportDir          string
+	DataExportHTTPPort     string
+	DataExportDownloadURL  string
+	DataExportRetention    time.Duration
+	DataExportTokenTTL     time.Duration
+	DataExportPollInterval time.Duration
+
+	// Events configures the publisher of the event relay. Events.Publisher is empty when the relay is off
+	Events             outbox.Config
+	EventRelayInterval time.Duration
+	EventRetention     time.Duration
+
+	WebhookPollInterval      time.Duration
+	WebhookDeliveryRetention time.Duration
+
+	DeviceTokenTTL           time.Duration
+	DeviceTokenSweepInterval time.Duration
+
+	APIKeyScopes []string
+
+	BillingWebhookSecret    string
+	BillingWebhookHTTPPort  string
+	BillingPricePlans       map[string]string
+	EntitlementSyncInterval time.Duration
 }
 
 // GetENVSecrets loads environment variables from .env file and returns the configuration
@@ -30,6 +106,96 @@ func GetENVSecrets() EnvConfig {
 		EmailSecret: os.Getenv("EMAIL_SECRET"),
 		TokenSecret: os.Getenv("TOKEN_SECRET"),
 		RedisSecret: os.Getenv("REDIS_SECRET"),
+		AutoMigrate: getEnvBool("AUTO_MIGRATE", false),
+
+		VerificationCodeLength: getEnvInt("VERIFICATION_CODE_LENGTH", 6),
+		VerificationCodeTTL:    getEnvDuration("VERIFICATION_CODE_TTL", 15*time.Minute),
+
+		LoginLinkURL:         os.Getenv("LOGIN_LINK_URL"),
+		LoginLinkTTL:         getEnvDuration("LOGIN_LINK_TTL", 15*time.Minute),
+		LoginRateLimit:       getEnvInt("LOGIN_RATE_LIMIT", 5),
+		LoginRateLimitWindow: getEnvDuration("LOGIN_RATE_LIMIT_WINDOW", 15*time.Minute),
+
+		ExternalProviders: getExternalProviders(),
+
+		OIDCIssuerURL:      os.Getenv("OIDC_ISSUER_URL"),
+		OIDCHTTPPort:       getEnv("OIDC_HTTP_PORT", ":8081"),
+		OIDCSigningKeyFile: os.Getenv("OIDC_SIGNING_KEY_FILE"),
+
+		ServiceTokenTTL: getEnvDuration("SERVICE_TOKEN_TTL", 15*time.Minute),
+
+		DeviceVerificationURL: os.Getenv("DEVICE_VERIFICATION_URL"),
+		DeviceCodeTTL:         getEnvDuration("DEVICE_CODE_TTL", 10*time.Minute),
+		DevicePollInterval:    getEnvDuration("DEVICE_POLL_INTERVAL", 5*time.Second),
+
+		SuspensionSweepInterval: getEnvDuration("SUSPENSION_SWEEP_INTERVAL", time.Minute),
+
+		AuthEventRetention:     getEnvDuration("AUTH_EVENT_RETENTION", 365*24*time.Hour),
+		AuditTrustForwardedFor: getEnvBool("AUDIT_TRUST_FORWARDED_FOR", false),
+
+		SignInReportURL:  os.Getenv("SIGN_IN_REPORT_URL"),
+		PasswordResetTTL: getEnvDuration("PASSWORD_RESET_TTL", time.Hour),
+
+		EmailChangeUndoURL:     os.Getenv("EMAIL_CHANGE_UNDO_URL"),
+		EmailChangeGracePeriod: getEnvDuration("EMAIL_CHANGE_GRACE_PERIOD", 7*24*time.Hour),
+
+		UsernameChangeCooldown: getEnvDuration("USERNAME_CHANGE_COOLDOWN", 30*24*time.Hour),
+		UsernameHoldPeriod:     getEnvDuration("USERNAME_HOLD_PERIOD", 30*24*time.Hour),
+		ReservedUsernames:      splitList(os.Getenv("RESERVED_USERNAMES")),
+
+		EmailAllowedDomains:             splitList(os.Getenv("EMAIL_ALLOWED_DOMAINS")),
+		EmailDeniedDomains:              splitList(os.Getenv("EMAIL_DENIED_DOMAINS")),
+		DisposableDomainsFile:           os.Getenv("DISPOSABLE_DOMAINS_FILE"),
+		DisposableDomainsReloadInterval: getEnvDuration("DISPOSABLE_DOMAINS_RELOAD_INTERVAL", time.Hour),
+
+		AccountDeletionCancelURL:     os.Getenv("ACCOUNT_DELETION_CANCEL_URL"),
+		AccountDeletionGracePeriod:   getEnvDuration("ACCOUNT_DELETION_GRACE_PERIOD", 30*24*time.Hour),
+		AccountDeletionSweepInterval: getEnvDuration("ACCOUNT_DELETION_SWEEP_INTERVAL", time.Hour),
+
+		DataExportDir:          os.Getenv("DATA_EXPORT_DIR"),
+		DataExportHTTPPort:     getEnv("DATA_EXPORT_HTTP_PORT", ":8082"),
+		DataExportDownloadURL:  os.Getenv("DATA_EXPORT_DOWNLOAD_URL"),
+		DataExportRetention:    getEnvDuration("DATA_EXPORT_RETENTION", 7*24*time.Hour),
+		DataExportTokenTTL:     getEnvDuration("DATA_EXPORT_TOKEN_TTL", 5*time.Minute),
+		DataExportPollInterval: getEnvDuration("DATA_EXPORT_POLL_INTERVAL", 30*time.Second),
+
+		Events: outbox.Config{
+			Publisher:         os.Getenv("EVENT_PUBLISHER"),
+			File:              os.Getenv("EVENT_FILE"),
+			NATSURL:           getEnv("EVENT_NATS_URL", "nats://127.0.0.1:4222"),
+			NATSSubjectPrefix: getEnv("EVENT_NATS_SUBJECT_PREFIX", "auth.events"),
+			KafkaBrokers:      splitList(os.Getenv("EVENT_KAFKA_BROKERS")),
+			KafkaTopic:        getEnv("EVENT_KAFKA_TOPIC", "auth.events"),
+			RedisStream:       getEnv("EVENT_REDIS_STREAM", "auth:events"),
+			RedisStreamMax:    int64(getEnvInt("EVENT_REDIS_STREAM_MAX_LEN", 100000)),
+		},
+		EventRelayInterval: getEnvDuration("EVENT_RELAY_INTERVAL", time.Second),
+		EventRetention:     getEnvDuration("EVENT_RETENTION", 7*24*time.Hour),
+
+		WebhookPollInterval:      getEnvDuration("WEBHOOK_POLL_INTERVAL", 5*time.Second),
+		WebhookDeliveryRetention: getEnvDuration("WEBHOOK_DELIVERY_RETENTION", 30*24*time.Hour),
+
+		DeviceTokenTTL:           getEnvDuration("DEVICE_TOKEN_TTL", 60*24*time.Hour),
+		DeviceTokenSweepInterval: getEnvDuration("DEVICE_TOKEN_SWEEP_INTERVAL", time.Hour),
+
+		APIKeyScopes: splitList(os.Getenv("API_KEY_SCOPES")),
+
+		BillingWebhookSecret:    os.Getenv("BILLING_WEBHOOK_SECRET"),
+		BillingWebhookHTTPPort:  getEnv("BILLING_WEBHOOK_HTTP_PORT", ":8083"),
+		BillingPricePlans:       getPricePlans(),
+		EntitlementSyncInterval: getEnvDuration("ENTITLEMENT_SYNC_INTERVAL", time.Minute),
+	}
+	if config.Events.Publisher == "none" {
+		config.Events.Publisher = ""
+	}
+
+	switch mode := getEnv("ACCOUNT_DELETION_MODE", "anonymize"); mode {
+	case "anonymize":
+		config.AccountDeletionAnonymize = true
+	case "delete":
+		config.AccountDeletionAnonymize = false
+	default:
+		log.Fatalf("ACCOUNT_DELETION_MODE should be anonymize or delete, got %q", mode)
 	}
 
 	if config.Port == "" {
@@ -50,6 +216,185 @@ func GetENVSecrets() EnvConfig {
 	if config.RedisSecret == "" {
 		log.Fatalf("Set redis password in .env file")
 	}
+	if config.VerificationCodeLength < 4 || config.VerificationCodeLength > 9 {
+		log.Fatalf("VERIFICATION_CODE_LENGTH should be between 4 and 9")
+	}
+	if config.VerificationCodeTTL <= 0 {
+		log.Fatalf("VERIFICATION_CODE_TTL should be positive")
+	}
+	if config.LoginLinkTTL <= 0 {
+		log.Fatalf("LOGIN_LINK_TTL should be positive")
+	}
+	if config.LoginRateLimit <= 0 || config.LoginRateLimitWindow <= 0 {
+		log.Fatalf("LOGIN_RATE_LIMIT and LOGIN_RATE_LIMIT_WINDOW should be positive")
+	}
+	if config.ServiceTokenTTL <= 0 {
+		log.Fatalf("SERVICE_TOKEN_TTL should be positive")
+	}
+	if config.DeviceCodeTTL <= 0 || config.DevicePollInterval < time.Second {
+		log.Fatalf("DEVICE_CODE_TTL should be positive and DEVICE_POLL_INTERVAL at least 1s")
+	}
+	if config.SuspensionSweepInterval <= 0 {
+		log.Fatalf("SUSPENSION_SWEEP_INTERVAL should be positive")
+	}
+	if config.AuthEventRetention < 0 {
+		log.Fatalf("AUTH_EVENT_RETENTION can't be negative")
+	}
+	if config.PasswordResetTTL <= 0 {
+		log.Fatalf("PASSWORD_RESET_TTL should be positive")
+	}
+	if config.EmailChangeGracePeriod <= 0 {
+		log.Fatalf("EMAIL_CHANGE_GRACE_PERIOD should be positive")
+	}
+	if config.UsernameChangeCooldown < 0 || config.UsernameHoldPeriod < 0 {
+		log.Fatalf("USERNAME_CHANGE_COOLDOWN and USERNAME_HOLD_PERIOD can't be negative")
+	}
+	if config.DisposableDomainsReloadInterval <= 0 {
+		log.Fatalf("DISPOSABLE_DOMAINS_RELOAD_INTERVAL should be positive")
+	}
+	if config.AccountDeletionGracePeriod < 0 {
+		log.Fatalf("ACCOUNT_DELETION_GRACE_PERIOD can't be negative")
+	}
+	if config.AccountDeletionSweepInterval <= 0 {
+		log.Fatalf("ACCOUNT_DELETION_SWEEP_INTERVAL should be positive")
+	}
+	if config.DataExportDir != "" && config.DataExportDownloadURL == "" {
+		log.Fatalf("Set DATA_EXPORT_DOWNLOAD_URL to enable data exports")
+	}
+	if config.DataExportRetention <= 0 || config.DataExportTokenTTL <= 0 || config.DataExportPollInterval <= 0 {
+		log.Fatalf("DATA_EXPORT_RETENTION, DATA_EXPORT_TOKEN_TTL and DATA_EXPORT_POLL_INTERVAL should be positive")
+	}
+	if config.EventRelayInterval <= 0 || config.EventRetention < 0 {
+		log.Fatalf("EVENT_RELAY_INTERVAL should be positive and EVENT_RETENTION can't be negative")
+	}
+	if config.WebhookPollInterval <= 0 || config.WebhookDeliveryRetention < 0 {
+		log.Fatalf("WEBHOOK_POLL_INTERVAL should be positive and WEBHOOK_DELIVERY_RETENTION can't be negative")
+	}
+	if config.DeviceTokenTTL <= 0 || config.DeviceTokenSweepInterval <= 0 {
+		log.Fatalf("DEVICE_TOKEN_TTL and DEVICE_TOKEN_SWEEP_INTERVAL should be positive")
+	}
+	if config.BillingWebhookSecret != "" && len(config.BillingPricePlans) == 0 {
+		log.Fatalf("Set BILLING_PRICE_PLANS in env when BILLING_WEBHOOK_SECRET is set")
+	}
+	if config.EntitlementSyncInterval <= 0 {
+		log.Fatalf("ENTITLEMENT_SYNC_INTERVAL should be positive")
+	}
 
 	return config
 }
+
+// GetDatabaseURL loads environment variables from .env file and returns only the database connection string.
+// It is used by commands that don't start the gRPC server, like migrate
+func GetDatabaseURL() string {
+	if err := godotenv.Load(".env"); err != nil {
+		log.Println("Error loading .env file")
+	}
+
+	dbURL := os.Getenv("DB_URL")
+	if dbURL == "" {
+		log.Fatalf("Set db connection in env")
+	}
+
+	return dbURL
+}
+
+// getExternalProviders reads the providers listed in OAUTH_PROVIDERS. Each provider is configured with
+// OAUTH_<NAME>_CLIENT_ID, OAUTH_<NAME>_CLIENT_SECRET, OAUTH_<NAME>_REDIRECT_URL and optionally
+// OAUTH_<NAME>_ISSUER_URL and OAUTH_<NAME>_SCOPES
+func getExternalProviders() []oauth.Config {
+	var providers []oauth.Config
+	for _, name := range splitList(os.Getenv("OAUTH_PROVIDERS")) {
+		prefix := "OAUTH_" + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name)) + "_"
+
+		provider := oauth.Config{
+			Name:         name,
+			IssuerURL:    os.Getenv(prefix + "ISSUER_URL"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+			Scopes:       splitList(os.Getenv(prefix + "SCOPES")),
+		}
+		if provider.ClientID == "" || provider.ClientSecret == "" || provider.RedirectURL == "" {
+			log.Fatalf("Set %sCLIENT_ID, %sCLIENT_SECRET and %sREDIRECT_URL in env", prefix, prefix, prefix)
+		}
+
+		providers = append(providers, provider)
+	}
+
+	return providers
+}
+
+// getPricePlans reads BILLING_PRICE_PLANS, a list of price_id:plan_id pairs telling which plan each price of the
+// billing provider entitles to
+func getPricePlans() map[string]string {
+	plans := map[string]string{}
+	for _, pair := range splitList(os.Getenv("BILLING_PRICE_PLANS")) {
+		price, plan, ok := strings.Cut(pair, ":")
+		price, plan = strings.TrimSpace(price), strings.TrimSpace(plan)
+		if !ok || price == "" || plan == "" {
+			log.Fatalf("BILLING_PRICE_PLANS should be a list of price_id:plan_id pairs, got %q", pair)
+		}
+		plans[price] = plan
+	}
+	return plans
+}
+
+// splitList splits a comma separated list, ignoring empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("%s should be true or false, got %q", key, value)
+	}
+
+	return parsed
+}
+
+func getEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("%s should be a number, got %q", key, value)
+	}
+
+	return parsed
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("%s should be a duration like 15m, got %q", key, value)
+	}
+
+	return parsed
+}
@@ -5,12 +5,38 @@ import (
 	"encoding/json"
 	"log"
 
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 )
 
+// RespondWithError converts an error to a gRPC status error carrying ErrorInfo, BadRequest and RetryInfo details.
+// Errors that are not autherr domain errors are reported as internal errors. The underlying cause is logged
+// together with the gRPC method, but it is never sent to the client
+func RespondWithError(ctx context.Context, err error) error {
+	domainErr := autherr.From(err)
+	if domainErr == nil {
+		return nil
+	}
+
+	method, _ := grpc.Method(ctx)
+	if domainErr.Cause != nil {
+		log.Printf("%s: %v", method, domainErr.Cause)
+	}
+
+	if domainErr.Code == codes.Internal || domainErr.Code == codes.Unavailable || domainErr.Code == codes.Unknown {
+		log.Printf("Responding with 5XX gRPC error: %s", domainErr.Message)
+	}
+
+	log.Printf("AuthServiceError: %s, Reason: %s, Code: %s", domainErr.Message, domainErr.Reason, domainErr.Code.String())
+	return domainErr.GRPCStatus().Err()
+}
+
 // RespondWithErrorGRPC creates a gRPC error response with the specified code and message
 // It logs the error if provided and returns a formatted gRPC status error
+//
+// Deprecated: use RespondWithError with an autherr domain error, which also reports a stable reason
 func RespondWithErrorGRPC(ctx context.Context, code codes.Code, msg string, err error) error {
 	if err != nil {
 		log.Println(err)
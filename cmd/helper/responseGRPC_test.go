@@ -6,6 +6,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/imhasandl/auth-service/internal/autherr"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -53,3 +54,42 @@ func TestRespondWithErrorGRPC(t *testing.T) {
 		})
 	}
 }
+
+func TestRespondWithError(t *testing.T) {
+	ctx := context.Background()
+
+	testCases := []struct {
+		name           string
+		err            error
+		expectedCode   codes.Code
+		expectedReason autherr.Reason
+		expectedMsg    string
+	}{
+		{
+			name:           "domain error keeps its code and reason",
+			err:            autherr.InvalidCredentials(),
+			expectedCode:   codes.Unauthenticated,
+			expectedReason: autherr.ReasonInvalidCredentials,
+			expectedMsg:    "invalid credentials",
+		},
+		{
+			name:           "plain error becomes internal without leaking the cause",
+			err:            errors.New("pq: relation users does not exist"),
+			expectedCode:   codes.Internal,
+			expectedReason: autherr.ReasonInternal,
+			expectedMsg:    "internal error",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := RespondWithError(ctx, tc.err)
+
+			statusErr, ok := status.FromError(err)
+			assert.True(t, ok, "Error should be a gRPC status error")
+			assert.Equal(t, tc.expectedCode, statusErr.Code())
+			assert.Equal(t, tc.expectedMsg, statusErr.Message())
+			assert.Equal(t, tc.expectedReason, autherr.ReasonOf(err))
+		})
+	}
+}
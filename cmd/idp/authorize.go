@@ -0,0 +1,355 @@
+package idp
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/redis"
+)
+
+// authorizeParams are the parameters of an authorization request that survive the login and consent pages
+var authorizeParams = []string{
+	"client_id", "redirect_uri", "response_type", "scope", "state", "nonce", "code_challenge", "code_challenge_method",
+}
+
+// authorizeRequest is a validated authorization request
+type authorizeRequest struct {
+	client        database.OauthClient
+	redirectURI   string
+	state         string
+	nonce         string
+	codeChallenge string
+	scopes        []string
+	prompt        []string
+}
+
+// authorizationCode is what the provider remembers about an authorization code until the client redeems it
+type authorizationCode struct {
+	ClientID      string    `json:"client_id"`
+	UserID        uuid.UUID `json:"user_id"`
+	RedirectURI   string    `json:"redirect_uri"`
+	Scopes        []string  `json:"scopes"`
+	Nonce         string    `json:"nonce,omitempty"`
+	CodeChallenge string    `json:"code_challenge,omitempty"`
+}
+
+// authorizeError is an error returned to the client through its redirect URI
+type authorizeError struct {
+	code        string
+	description string
+}
+
+func (e *authorizeError) Error() string {
+	return e.code + ": " + e.description
+}
+
+// authorize handles the authorization endpoint. GET starts the flow, POST submits the consent form
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		renderError(w, http.StatusBadRequest, "The authorization request is malformed.")
+		return
+	}
+
+	client, redirectURI, ok := p.authorizeClient(w, r)
+	if !ok {
+		return
+	}
+
+	req, err := parseAuthorizeRequest(client, redirectURI, r.Form)
+	if err != nil {
+		p.redirectError(w, r, redirectURI, r.Form.Get("state"), err)
+		return
+	}
+
+	userID, session, ok := p.sessionUser(r)
+	if !ok || (r.Method == http.MethodGet && slices.Contains(req.prompt, "login")) {
+		if slices.Contains(req.prompt, "none") {
+			p.redirectError(w, r, redirectURI, req.state, &authorizeError{"login_required", "the user is not logged in"})
+			return
+		}
+		http.Redirect(w, r, p.loginURL(r.Form), http.StatusFound)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		if !hmac.Equal([]byte(r.PostForm.Get("csrf_token")), []byte(p.csrfToken(session))) {
+			renderError(w, http.StatusForbidden, "The consent form has expired. Go back to the application and try again.")
+			return
+		}
+		if r.PostForm.Get("consent") != "allow" {
+			p.redirectError(w, r, redirectURI, req.state, &authorizeError{"access_denied", "the user denied the request"})
+			return
+		}
+		if err := p.grantConsent(r.Context(), userID, req); err != nil {
+			log.Printf("idp: saving consent: %v", err)
+			p.redirectError(w, r, redirectURI, req.state, &authorizeError{"server_error", "consent could not be saved"})
+			return
+		}
+	} else {
+		needsConsent, err := p.needsConsent(r.Context(), userID, req)
+		if err != nil {
+			log.Printf("idp: loading consent: %v", err)
+			p.redirectError(w, r, redirectURI, req.state, &authorizeError{"server_error", "consent could not be loaded"})
+			return
+		}
+		if needsConsent {
+			if slices.Contains(req.prompt, "none") {
+				p.redirectError(w, r, redirectURI, req.state, &authorizeError{"consent_required", "the user has not authorized the client"})
+				return
+			}
+			render(w, http.StatusOK, "consent", consentPage{
+				Action:     p.basePath() + "/authorize",
+				ClientName: client.Name,
+				Scopes:     req.scopes,
+				Params:     authorizeValues(r.Form),
+				CSRFToken:  p.csrfToken(session),
+			})
+			return
+		}
+	}
+
+	code, err := p.issueCode(userID, req)
+	if err != nil {
+		log.Printf("idp: issuing authorization code: %v", err)
+		p.redirectError(w, r, redirectURI, req.state, &authorizeError{"server_error", "authorization code could not be issued"})
+		return
+	}
+
+	target, _ := url.Parse(redirectURI)
+	query := target.Query()
+	query.Set("code", code)
+	if req.state != "" {
+		query.Set("state", req.state)
+	}
+	target.RawQuery = query.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// authorizeClient looks up the client and checks the redirect URI. Errors at this stage are shown to the user
+// instead of being sent to the redirect URI, which can't be trusted yet
+func (p *Provider) authorizeClient(w http.ResponseWriter, r *http.Request) (database.OauthClient, string, bool) {
+	clientID := r.Form.Get("client_id")
+	if clientID == "" {
+		renderError(w, http.StatusBadRequest, "The request is missing the client_id parameter.")
+		return database.OauthClient{}, "", false
+	}
+
+	client, err := p.db.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			renderError(w, http.StatusBadRequest, "The application is not registered.")
+		} else {
+			log.Printf("idp: loading client %q: %v", clientID, err)
+			renderError(w, http.StatusInternalServerError, "The application could not be loaded. Try again later.")
+		}
+		return database.OauthClient{}, "", false
+	}
+
+	redirectURI := r.Form.Get("redirect_uri")
+	if redirectURI == "" && len(client.RedirectUris) == 1 {
+		redirectURI = client.RedirectUris[0]
+	}
+	if !slices.Contains(client.RedirectUris, redirectURI) {
+		renderError(w, http.StatusBadRequest, "The redirect URI is not registered for this application.")
+		return database.OauthClient{}, "", false
+	}
+
+	return client, redirectURI, true
+}
+
+// parseAuthorizeRequest validates the parameters of an authorization request for client
+func parseAuthorizeRequest(client database.OauthClient, redirectURI string, form url.Values) (authorizeRequest, error) {
+	req := authorizeRequest{
+		client:        client,
+		redirectURI:   redirectURI,
+		state:         form.Get("state"),
+		nonce:         form.Get("nonce"),
+		codeChallenge: form.Get("code_challenge"),
+		scopes:        strings.Fields(form.Get("scope")),
+		prompt:        strings.Fields(form.Get("prompt")),
+	}
+
+	if form.Get("response_type") != "code" {
+		return req, &authorizeError{"unsupported_response_type", "only the code response type is supported"}
+	}
+
+	if !slices.Contains(req.scopes, ScopeOpenID) {
+		return req, &authorizeError{"invalid_scope", "the openid scope is required"}
+	}
+	for _, scope := range req.scopes {
+		if !slices.Contains(client.Scopes, scope) {
+			return req, &authorizeError{"invalid_scope", "the client may not request the " + scope + " scope"}
+		}
+	}
+	slices.Sort(req.scopes)
+	req.scopes = slices.Compact(req.scopes)
+
+	if req.codeChallenge != "" && form.Get("code_challenge_method") != "S256" {
+		return req, &authorizeError{"invalid_request", "only the S256 code challenge method is supported"}
+	}
+	if req.codeChallenge == "" && client.SecretHash == "" {
+		return req, &authorizeError{"invalid_request", "public clients must use PKCE"}
+	}
+
+	if slices.Contains(req.prompt, "none") && len(req.prompt) > 1 {
+		return req, &authorizeError{"invalid_request", "prompt=none can't be combined with other values"}
+	}
+
+	return req, nil
+}
+
+// needsConsent reports whether the user has to approve the request. Trusted clients never ask
+func (p *Provider) needsConsent(ctx context.Context, userID uuid.UUID, req authorizeRequest) (bool, error) {
+	if req.client.Trusted {
+		return false, nil
+	}
+	if slices.Contains(req.prompt, "consent") {
+		return true, nil
+	}
+
+	consent, err := p.db.GetOAuthConsent(ctx, database.GetOAuthConsentParams{
+		UserID:   userID,
+		ClientID: req.client.ID,
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return true, nil
+		}
+		return false, err
+	}
+
+	for _, scope := range req.scopes {
+		if !slices.Contains(consent.Scopes, scope) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// grantConsent records that the user approved the requested scopes, on top of the ones approved before
+func (p *Provider) grantConsent(ctx context.Context, userID uuid.UUID, req authorizeRequest) error {
+	scopes := slices.Clone(req.scopes)
+
+	consent, err := p.db.GetOAuthConsent(ctx, database.GetOAuthConsentParams{
+		UserID:   userID,
+		ClientID: req.client.ID,
+	})
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	scopes = append(scopes, consent.Scopes...)
+	slices.Sort(scopes)
+
+	return p.db.UpsertOAuthConsent(ctx, database.UpsertOAuthConsentParams{
+		UserID:   userID,
+		ClientID: req.client.ID,
+		Scopes:   slices.Compact(scopes),
+	})
+}
+
+// issueCode stores a single use authorization code for the request
+func (p *Provider) issueCode(userID uuid.UUID, req authorizeRequest) (string, error) {
+	code, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	value, err := json.Marshal(authorizationCode{
+		ClientID:      req.client.ID,
+		UserID:        userID,
+		RedirectURI:   req.redirectURI,
+		Scopes:        req.scopes,
+		Nonce:         req.nonce,
+		CodeChallenge: req.codeChallenge,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := redis.SaveAuthorizationCode(code, string(value), authorizationCodeTTL); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// redirectError sends an error back to the client as described in RFC 6749 section 4.1.2.1
+func (p *Provider) redirectError(w http.ResponseWriter, r *http.Request, redirectURI, state string, err error) {
+	var authErr *authorizeError
+	if !errors.As(err, &authErr) {
+		authErr = &authorizeError{"server_error", "unexpected error"}
+	}
+
+	target, parseErr := url.Parse(redirectURI)
+	if parseErr != nil {
+		renderError(w, http.StatusBadRequest, "The redirect URI is malformed.")
+		return
+	}
+
+	query := target.Query()
+	query.Set("error", authErr.code)
+	query.Set("error_description", authErr.description)
+	if state != "" {
+		query.Set("state", state)
+	}
+	target.RawQuery = query.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// sessionUser returns the user logged in at the provider and the raw session ID
+func (p *Provider) sessionUser(r *http.Request) (uuid.UUID, string, bool) {
+	cookie, err := r.Cookie(SessionCookie)
+	if err != nil || cookie.Value == "" {
+		return uuid.Nil, "", false
+	}
+
+	value, err := redis.GetOIDCSession(auth.HashSingleUseToken(cookie.Value))
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			log.Printf("idp: loading session: %v", err)
+		}
+		return uuid.Nil, "", false
+	}
+	userID, err := uuid.Parse(value)
+	if err != nil {
+		return uuid.Nil, "", false
+	}
+	return userID, cookie.Value, true
+}
+
+// csrfToken binds the consent form to the session it was shown in
+func (p *Provider) csrfToken(session string) string {
+	mac := hmac.New(sha256.New, []byte(p.tokenSecret))
+	mac.Write([]byte("consent:" + session))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// loginURL is the login page that resumes the authorization request afterwards. The prompt parameter is
+// dropped so prompt=login doesn't send the user back to the login page again
+func (p *Provider) loginURL(form url.Values) string {
+	returnTo := p.basePath() + "/authorize?" + url.Values(authorizeValues(form)).Encode()
+	return p.basePath() + "/login?" + url.Values{"return_to": {returnTo}}.Encode()
+}
+
+// authorizeValues copies the authorization request parameters out of form
+func authorizeValues(form url.Values) map[string][]string {
+	values := make(map[string][]string)
+	for _, name := range authorizeParams {
+		if value := form.Get(name); value != "" {
+			values[name] = []string{value}
+		}
+	}
+	return values
+}
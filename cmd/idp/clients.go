@@ -0,0 +1,159 @@
+package idp
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/internal/database"
+)
+
+// ClientUsage describes the oidc-client subcommand
+const ClientUsage = `usage: auth-service oidc-client <command> [flags]
+
+commands:
+  create -name NAME -redirect-uri URI [-redirect-uri URI...] [-scopes openid,profile,email] [-public] [-trusted]
+  list
+  delete -id CLIENT_ID`
+
+// RunClientCommand registers, lists and removes the clients allowed to log users in through the provider
+func RunClientCommand(ctx context.Context, db database.DBQuerier, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(ClientUsage)
+	}
+
+	switch args[0] {
+	case "create":
+		return createClient(ctx, db, args[1:], out)
+	case "list":
+		return listClients(ctx, db, out)
+	case "delete":
+		return deleteClient(ctx, db, args[1:], out)
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], ClientUsage)
+	}
+}
+
+func createClient(ctx context.Context, db database.DBQuerier, args []string, out io.Writer) error {
+	var redirectURIs stringList
+	flags := flag.NewFlagSet("create", flag.ContinueOnError)
+	flags.SetOutput(out)
+	name := flags.String("name", "", "name shown on the consent page")
+	flags.Var(&redirectURIs, "redirect-uri", "allowed redirect URI, can be repeated")
+	scopes := flags.String("scopes", strings.Join(SupportedScopes, ","), "comma separated scopes the client may request")
+	public := flags.Bool("public", false, "client can't keep a secret and has to use PKCE")
+	trusted := flags.Bool("trusted", false, "skip the consent page")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *name == "" {
+		return errors.New("-name is required")
+	}
+	if len(redirectURIs) == 0 {
+		return errors.New("at least one -redirect-uri is required")
+	}
+
+	clientScopes := strings.Split(*scopes, ",")
+	for _, scope := range clientScopes {
+		if !slices.Contains(SupportedScopes, scope) {
+			return fmt.Errorf("unsupported scope %q", scope)
+		}
+	}
+	if !slices.Contains(clientScopes, ScopeOpenID) {
+		return errors.New("scopes should include openid")
+	}
+
+	var secret, secretHash string
+	if !*public {
+		var err error
+		secret, err = auth.MakeClientSecret()
+		if err != nil {
+			return err
+		}
+		secretHash = auth.HashClientSecret(secret)
+	}
+
+	client, err := db.CreateOAuthClient(ctx, database.CreateOAuthClientParams{
+		ID:           uuid.New().String(),
+		SecretHash:   secretHash,
+		Name:         *name,
+		RedirectUris: redirectURIs,
+		Scopes:       clientScopes,
+		Trusted:      *trusted,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "client_id:     %s\n", client.ID)
+	if secret != "" {
+		fmt.Fprintf(out, "client_secret: %s\n", secret)
+		fmt.Fprintln(out, "The secret is not stored and can't be shown again.")
+	}
+	return nil
+}
+
+func listClients(ctx context.Context, db database.DBQuerier, out io.Writer) error {
+	clients, err := db.ListOAuthClients(ctx)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CLIENT ID\tNAME\tTYPE\tSCOPES\tREDIRECT URIS")
+	for _, client := range clients {
+		clientType := "confidential"
+		if client.SecretHash == "" {
+			clientType = "public"
+		}
+		if client.Trusted {
+			clientType += ", trusted"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", client.ID, client.Name, clientType,
+			strings.Join(client.Scopes, " "), strings.Join(client.RedirectUris, " "))
+	}
+	return tw.Flush()
+}
+
+func deleteClient(ctx context.Context, db database.DBQuerier, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("delete", flag.ContinueOnError)
+	flags.SetOutput(out)
+	id := flags.String("id", "", "client ID")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *id == "" {
+		return errors.New("-id is required")
+	}
+
+	deleted, err := db.DeleteOAuthClient(ctx, *id)
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return fmt.Errorf("client %s not found", *id)
+	}
+
+	fmt.Fprintf(out, "deleted client %s\n", *id)
+	return nil
+}
+
+// stringList is a flag that can be repeated
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
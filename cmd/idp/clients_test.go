@@ -0,0 +1,74 @@
+package idp
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/database/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunClientCommand(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("create confidential client", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		var created database.CreateOAuthClientParams
+		mockDB.On("CreateOAuthClient", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			created = args.Get(1).(database.CreateOAuthClientParams)
+		}).Return(database.OauthClient{ID: "client-1"}, nil)
+
+		var out bytes.Buffer
+		err := RunClientCommand(ctx, mockDB, []string{"create", "-name", "App",
+			"-redirect-uri", "https://app.example.com/a", "-redirect-uri", "https://app.example.com/b",
+			"-scopes", "openid,email"}, &out)
+		require.NoError(t, err)
+
+		secret := regexp.MustCompile(`client_secret: (\S+)`).FindStringSubmatch(out.String())
+		require.Len(t, secret, 2)
+		assert.True(t, auth.CheckClientSecret(created.SecretHash, secret[1]))
+		assert.Equal(t, []string{"https://app.example.com/a", "https://app.example.com/b"}, created.RedirectUris)
+		assert.Equal(t, []string{"openid", "email"}, created.Scopes)
+		assert.False(t, created.Trusted)
+	})
+
+	t.Run("create public client", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		mockDB.On("CreateOAuthClient", mock.Anything, mock.MatchedBy(func(arg database.CreateOAuthClientParams) bool {
+			return arg.SecretHash == "" && arg.Trusted
+		})).Return(database.OauthClient{ID: "client-1"}, nil)
+
+		var out bytes.Buffer
+		err := RunClientCommand(ctx, mockDB, []string{"create", "-name", "App",
+			"-redirect-uri", "https://app.example.com/a", "-public", "-trusted"}, &out)
+		require.NoError(t, err)
+		assert.NotContains(t, out.String(), "client_secret")
+	})
+
+	t.Run("invalid arguments", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		var out bytes.Buffer
+
+		assert.Error(t, RunClientCommand(ctx, mockDB, nil, &out))
+		assert.Error(t, RunClientCommand(ctx, mockDB, []string{"create", "-name", "App"}, &out))
+		assert.Error(t, RunClientCommand(ctx, mockDB, []string{"create", "-name", "App",
+			"-redirect-uri", "https://app.example.com/a", "-scopes", "openid,admin"}, &out))
+		assert.Error(t, RunClientCommand(ctx, mockDB, []string{"delete"}, &out))
+		mockDB.AssertNotCalled(t, "CreateOAuthClient", mock.Anything, mock.Anything)
+	})
+
+	t.Run("delete unknown client", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		mockDB.On("DeleteOAuthClient", mock.Anything, "missing").Return(int64(0), nil)
+
+		var out bytes.Buffer
+		err := RunClientCommand(ctx, mockDB, []string{"delete", "-id", "missing"}, &out)
+		assert.ErrorContains(t, err, "not found")
+	})
+}
@@ -0,0 +1,125 @@
+package idp
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/emailpolicy"
+	"github.com/imhasandl/auth-service/internal/redis"
+)
+
+// loginPage shows the login form
+func (p *Provider) loginPage(w http.ResponseWriter, r *http.Request) {
+	render(w, http.StatusOK, "login", loginPage{
+		Action:   p.basePath() + "/login",
+		ReturnTo: p.returnTo(r.URL.Query().Get("return_to")),
+	})
+}
+
+// login checks the credentials the same way the Login RPC does and starts a session at the provider. Attempts
+// count against the rate limit of the Login RPC and are recorded through the login guard
+func (p *Provider) login(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		renderError(w, http.StatusBadRequest, "The login form is malformed.")
+		return
+	}
+
+	identifier := strings.TrimSpace(r.PostForm.Get("identifier"))
+	password := r.PostForm.Get("password")
+	page := loginPage{
+		Action:     p.basePath() + "/login",
+		ReturnTo:   p.returnTo(r.PostForm.Get("return_to")),
+		Identifier: identifier,
+	}
+
+	if err := p.guard.CheckPasswordLogin(identifier); err != nil {
+		page.Error = "Too many login attempts. Try again later."
+		render(w, http.StatusTooManyRequests, "login", page)
+		return
+	}
+
+	user, err := p.db.GetUserByIdentifier(r.Context(), database.GetUserByIdentifierParams{
+		EmailCanonical: emailpolicy.LookupKey(identifier),
+		Username:       identifier,
+	})
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("idp: loading user: %v", err)
+			renderError(w, http.StatusInternalServerError, "Logging in failed. Try again later.")
+			return
+		}
+		auth.CheckDummyPassword(password)
+		p.guard.RecordFormLogin(r, database.User{}, identifier, err)
+	} else if err = auth.CheckPassword(user.Password, password); err == nil {
+		p.guard.RecordFormLogin(r, user, identifier, nil)
+		if !userActive(user) {
+			page.Error = "This account is suspended or closed."
+			render(w, http.StatusForbidden, "login", page)
+			return
+		}
+		p.startSession(w, r, user, page.ReturnTo)
+		return
+	} else {
+		p.guard.RecordFormLogin(r, user, identifier, err)
+	}
+
+	page.Error = "Invalid email, username or password."
+	render(w, http.StatusUnauthorized, "login", page)
+}
+
+func (p *Provider) startSession(w http.ResponseWriter, r *http.Request, user database.User, returnTo string) {
+	session, err := auth.MakeRefreshToken()
+	if err != nil {
+		log.Printf("idp: making session ID: %v", err)
+		renderError(w, http.StatusInternalServerError, "Logging in failed. Try again later.")
+		return
+	}
+	if err := redis.SaveOIDCSession(auth.HashSingleUseToken(session), user.ID.String(), sessionTTL); err != nil {
+		log.Printf("idp: saving session: %v", err)
+		renderError(w, http.StatusInternalServerError, "Logging in failed. Try again later.")
+		return
+	}
+
+	path := p.basePath()
+	if path == "" {
+		path = "/"
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    session,
+		Path:     path,
+		MaxAge:   int(sessionTTL.Seconds()),
+		Secure:   p.secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	if returnTo == "" {
+		render(w, http.StatusOK, "message", messagePage{
+			Title:   "Logged in",
+			Message: "You are logged in as " + user.Username + ".",
+		})
+		return
+	}
+	http.Redirect(w, r, returnTo, http.StatusFound)
+}
+
+// returnTo only lets the login page send the user back to the authorization endpoint, so it can't be used
+// as an open redirect
+func (p *Provider) returnTo(target string) string {
+	if !strings.HasPrefix(target, p.basePath()+"/authorize?") {
+		return ""
+	}
+	return target
+}
+
+// userActive reports whether user may log in, the same way the Login RPC decides it
+func userActive(user database.User) bool {
+	return database.CanLogIn(user.Status, user.StatusUntil, time.Now())
+}
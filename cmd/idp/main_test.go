@@ -0,0 +1,35 @@
+package idp
+
+import (
+	"os"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/internal/redis"
+)
+
+var testKey *auth.SigningKey
+
+// TestMain points the global redis client at an in-memory server, which holds authorization codes during tests,
+// and generates the signing key once since RSA key generation is slow
+func TestMain(m *testing.M) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		panic(err)
+	}
+
+	redis.InitRedisClient(&redis.Config{
+		Host: mr.Host(),
+		Port: mr.Port(),
+	})
+
+	testKey, err = auth.GenerateSigningKey()
+	if err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	mr.Close()
+	os.Exit(code)
+}
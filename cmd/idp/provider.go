@@ -0,0 +1,192 @@
+// Package idp serves the OpenID Connect provider endpoints over HTTP, so other applications can log their users
+// in with accounts of this service
+package idp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/internal/database"
+)
+
+const (
+	// SessionCookie holds the ID of the session of the user logged in at the provider. The session itself is kept
+	// in Redis, so the cookie is no credential anywhere else
+	SessionCookie = "idp_session"
+
+	sessionTTL           = time.Hour
+	authorizationCodeTTL = time.Minute
+	accessTokenTTL       = time.Hour
+	idTokenTTL           = time.Hour
+)
+
+// Scopes the provider understands. Clients are registered with a subset of them
+const (
+	ScopeOpenID  = "openid"
+	ScopeProfile = "profile"
+	ScopeEmail   = "email"
+)
+
+// SupportedScopes lists the scopes a client can be registered with
+var SupportedScopes = []string{ScopeOpenID, ScopeProfile, ScopeEmail}
+
+// LoginGuard limits and records the logins with the login form of the provider. The auth server implements it, so
+// the form counts against the same rate limit and shows up in the same security log as the Login RPC
+type LoginGuard interface {
+	// CheckPasswordLogin fails once too many password logins were tried for identifier
+	CheckPasswordLogin(identifier string) error
+	// RecordFormLogin records a login with the form. user is the zero User when identifier matched no account and
+	// passwordErr is the result of checking the password
+	RecordFormLogin(r *http.Request, user database.User, identifier string, passwordErr error)
+}
+
+type nopLoginGuard struct{}
+
+func (nopLoginGuard) CheckPasswordLogin(string) error { return nil }
+
+func (nopLoginGuard) RecordFormLogin(*http.Request, database.User, string, error) {}
+
+// Option configures a Provider
+type Option func(*Provider)
+
+// WithLoginGuard limits and records the logins with the login form through guard
+func WithLoginGuard(guard LoginGuard) Option {
+	return func(p *Provider) {
+		p.guard = guard
+	}
+}
+
+// Provider is an OpenID Connect provider backed by the users and sessions of the auth service
+type Provider struct {
+	db          database.DBQuerier
+	tokenSecret string
+	issuer      string
+	key         *auth.SigningKey
+	secure      bool
+	guard       LoginGuard
+}
+
+// New creates a provider that identifies itself as issuer and signs ID tokens with key
+func New(db database.DBQuerier, tokenSecret, issuer string, key *auth.SigningKey, opts ...Option) *Provider {
+	issuer = strings.TrimSuffix(issuer, "/")
+	p := &Provider{
+		db:          db,
+		tokenSecret: tokenSecret,
+		issuer:      issuer,
+		key:         key,
+		secure:      strings.HasPrefix(issuer, "https://"),
+		guard:       nopLoginGuard{},
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Handler returns the HTTP handler serving the provider endpoints under the path of the issuer URL
+func (p *Provider) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("GET /jwks", p.jwks)
+	mux.HandleFunc("GET /authorize", p.authorize)
+	mux.HandleFunc("POST /authorize", p.authorize)
+	mux.HandleFunc("GET /login", p.loginPage)
+	mux.HandleFunc("POST /login", p.login)
+	mux.HandleFunc("POST /token", p.token)
+	mux.HandleFunc("GET /userinfo", p.userinfo)
+	mux.HandleFunc("POST /userinfo", p.userinfo)
+
+	if prefix := p.basePath(); prefix != "" {
+		return http.StripPrefix(prefix, mux)
+	}
+	return mux
+}
+
+// basePath is the path of the issuer URL, which all endpoints are served under
+func (p *Provider) basePath() string {
+	u, err := url.Parse(p.issuer)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(u.Path, "/")
+}
+
+func (p *Provider) endpoint(path string) string {
+	return p.issuer + path
+}
+
+type discoveryDocument struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// discovery serves the provider metadata described by OpenID Connect Discovery
+func (p *Provider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, discoveryDocument{
+		Issuer:                            p.issuer,
+		AuthorizationEndpoint:             p.endpoint("/authorize"),
+		TokenEndpoint:                     p.endpoint("/token"),
+		UserinfoEndpoint:                  p.endpoint("/userinfo"),
+		JWKSURI:                           p.endpoint("/jwks"),
+		ScopesSupported:                   SupportedScopes,
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{"RS256"},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported: []string{"sub", "iss", "aud", "exp", "iat", "nonce",
+			"email", "email_verified", "preferred_username"},
+	})
+}
+
+type jsonWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Modulus   string `json:"n"`
+	Exponent  string `json:"e"`
+}
+
+// jwks serves the public part of the signing key so clients can verify ID tokens
+func (p *Provider) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := p.key.Key.PublicKey
+	key := jsonWebKey{
+		KeyType:   "RSA",
+		KeyID:     p.key.ID,
+		Use:       "sig",
+		Algorithm: "RS256",
+		Modulus:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		Exponent:  base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	writeJSON(w, http.StatusOK, map[string][]jsonWebKey{"keys": {key}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("idp: writing response: %v", err)
+	}
+}
@@ -0,0 +1,643 @@
+package idp
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/database/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+const (
+	testSecret       = "test-secret"
+	testClientSecret = "client-secret"
+	testRedirectURI  = "https://app.example.com/callback"
+	testPassword     = "password123"
+)
+
+var csrfField = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+type testProvider struct {
+	server *httptest.Server
+	mockDB *mocks.MockQueries
+	guard  *fakeLoginGuard
+	user   database.User
+	http   *http.Client
+}
+
+// fakeLoginGuard records the logins with the form and refuses them all once limited is set
+type fakeLoginGuard struct {
+	limited bool
+	logins  []recordedLogin
+}
+
+type recordedLogin struct {
+	userID      uuid.UUID
+	identifier  string
+	passwordErr error
+}
+
+func (g *fakeLoginGuard) CheckPasswordLogin(string) error {
+	if g.limited {
+		return errors.New("rate limited")
+	}
+	return nil
+}
+
+func (g *fakeLoginGuard) RecordFormLogin(_ *http.Request, user database.User, identifier string, passwordErr error) {
+	g.logins = append(g.logins, recordedLogin{userID: user.ID, identifier: identifier, passwordErr: passwordErr})
+}
+
+// newTestProvider serves a provider under a path of the issuer URL, with a confidential client "web",
+// a public client "spa", a trusted client "first-party" and one user
+func newTestProvider(t *testing.T) *testProvider {
+	mockDB := new(mocks.MockQueries)
+
+	var handler http.Handler
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	guard := &fakeLoginGuard{}
+	handler = New(mockDB, testSecret, server.URL+"/oidc", testKey, WithLoginGuard(guard)).Handler()
+
+	hashedPassword, err := auth.HashPassword(testPassword)
+	require.NoError(t, err)
+	user := database.User{
+		ID:         uuid.New(),
+		Email:      "user@example.com",
+		Username:   "user",
+		Password:   hashedPassword,
+		IsVerified: true,
+	}
+
+	clients := []database.OauthClient{
+		{ID: "web", Name: "Web App", SecretHash: auth.HashClientSecret(testClientSecret)},
+		{ID: "spa", Name: "Single Page App"},
+		{ID: "first-party", Name: "First Party", SecretHash: auth.HashClientSecret(testClientSecret), Trusted: true},
+	}
+	for _, client := range clients {
+		client.RedirectUris = []string{testRedirectURI}
+		client.Scopes = SupportedScopes
+		mockDB.On("GetOAuthClient", mock.Anything, client.ID).Return(client, nil).Maybe()
+	}
+	mockDB.On("GetOAuthClient", mock.Anything, mock.Anything).Return(database.OauthClient{}, sql.ErrNoRows).Maybe()
+	mockDB.On("GetUserByIdentifier", mock.Anything, database.GetUserByIdentifierParams{
+		EmailCanonical: user.Email,
+		Username:       user.Email,
+	}).Return(user, nil).Maybe()
+	mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(database.User{}, sql.ErrNoRows).Maybe()
+	mockDB.On("GetUserByID", mock.Anything, user.ID).Return(user, nil).Maybe()
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+
+	return &testProvider{
+		server: server,
+		mockDB: mockDB,
+		guard:  guard,
+		user:   user,
+		http: &http.Client{
+			Jar: jar,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+func (tp *testProvider) issuer() string {
+	return tp.server.URL + "/oidc"
+}
+
+func (tp *testProvider) oauthConfig(clientID string) *oauth2.Config {
+	config := &oauth2.Config{
+		ClientID:    clientID,
+		RedirectURL: testRedirectURI,
+		Scopes:      []string{oidc.ScopeOpenID, "email", "profile"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  tp.issuer() + "/authorize",
+			TokenURL: tp.issuer() + "/token",
+		},
+	}
+	if clientID != "spa" {
+		config.ClientSecret = testClientSecret
+	}
+	return config
+}
+
+func (tp *testProvider) get(t *testing.T, target string) *http.Response {
+	resp, err := tp.http.Get(target)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func (tp *testProvider) post(t *testing.T, target string, form url.Values) *http.Response {
+	resp, err := tp.http.PostForm(target, form)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func (tp *testProvider) userinfo(t *testing.T, token string) *http.Response {
+	req, err := http.NewRequest(http.MethodGet, tp.issuer()+"/userinfo", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := tp.http.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// login logs the user in at the provider and returns where the login page redirected to
+func (tp *testProvider) login(t *testing.T, returnTo string) *http.Response {
+	return tp.post(t, tp.issuer()+"/login", url.Values{
+		"identifier": {tp.user.Email},
+		"password":   {testPassword},
+		"return_to":  {returnTo},
+	})
+}
+
+func location(t *testing.T, resp *http.Response) *url.URL {
+	require.Equal(t, http.StatusFound, resp.StatusCode)
+	target, err := resp.Location()
+	require.NoError(t, err)
+	return target
+}
+
+func body(t *testing.T, resp *http.Response) string {
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	tp := newTestProvider(t)
+	ctx := context.Background()
+
+	provider, err := oidc.NewProvider(ctx, tp.issuer())
+	require.NoError(t, err)
+	assert.Equal(t, tp.issuer()+"/authorize", provider.Endpoint().AuthURL)
+
+	config := tp.oauthConfig("web")
+	config.Endpoint = provider.Endpoint()
+
+	tp.mockDB.On("GetOAuthConsent", mock.Anything, database.GetOAuthConsentParams{
+		UserID:   tp.user.ID,
+		ClientID: "web",
+	}).Return(database.OauthConsent{}, sql.ErrNoRows)
+	tp.mockDB.On("UpsertOAuthConsent", mock.Anything, database.UpsertOAuthConsentParams{
+		UserID:   tp.user.ID,
+		ClientID: "web",
+		Scopes:   []string{"email", "openid", "profile"},
+	}).Return(nil).Once()
+
+	verifier := oauth2.GenerateVerifier()
+	authURL := config.AuthCodeURL("state-1", oidc.Nonce("nonce-1"), oauth2.S256ChallengeOption(verifier))
+
+	// Not logged in yet, so the provider asks for credentials first
+	loginURL := location(t, tp.get(t, authURL))
+	assert.Equal(t, "/oidc/login", loginURL.Path)
+	returnTo := loginURL.Query().Get("return_to")
+	assert.True(t, strings.HasPrefix(returnTo, "/oidc/authorize?"))
+
+	loginPage := tp.get(t, tp.server.URL+loginURL.RequestURI())
+	assert.Equal(t, http.StatusOK, loginPage.StatusCode)
+
+	failed := tp.post(t, tp.issuer()+"/login", url.Values{
+		"identifier": {tp.user.Email},
+		"password":   {"wrong"},
+		"return_to":  {returnTo},
+	})
+	assert.Equal(t, http.StatusUnauthorized, failed.StatusCode)
+
+	loggedIn := tp.login(t, returnTo)
+	resumed := location(t, loggedIn)
+	assert.Equal(t, returnTo, resumed.RequestURI())
+
+	// The session cookie is an opaque ID, not a token the API accepts
+	sessionCookie := loggedIn.Cookies()
+	require.Len(t, sessionCookie, 1)
+	assert.Equal(t, SessionCookie, sessionCookie[0].Name)
+	_, err = auth.ValidateJWT(sessionCookie[0].Value, testSecret)
+	assert.Error(t, err)
+
+	require.Len(t, tp.guard.logins, 2)
+	assert.Equal(t, tp.user.ID, tp.guard.logins[0].userID)
+	assert.Error(t, tp.guard.logins[0].passwordErr)
+	assert.Equal(t, tp.user.ID, tp.guard.logins[1].userID)
+	assert.NoError(t, tp.guard.logins[1].passwordErr)
+
+	// The client isn't trusted, so the user has to consent
+	consentPage := tp.get(t, tp.server.URL+returnTo)
+	require.Equal(t, http.StatusOK, consentPage.StatusCode)
+	page := body(t, consentPage)
+	assert.Contains(t, page, "Web App")
+	match := csrfField.FindStringSubmatch(page)
+	require.Len(t, match, 2)
+
+	form, err := url.ParseQuery(strings.TrimPrefix(returnTo, "/oidc/authorize?"))
+	require.NoError(t, err)
+	form.Set("csrf_token", match[1])
+	form.Set("consent", "allow")
+	callback := location(t, tp.post(t, tp.issuer()+"/authorize", form))
+	assert.Equal(t, "app.example.com", callback.Host)
+	assert.Equal(t, "state-1", callback.Query().Get("state"))
+	code := callback.Query().Get("code")
+	require.NotEmpty(t, code)
+
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	require.NoError(t, err)
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	require.True(t, ok)
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: "web"}).Verify(ctx, rawIDToken)
+	require.NoError(t, err)
+	assert.Equal(t, tp.user.ID.String(), idToken.Subject)
+	assert.Equal(t, "nonce-1", idToken.Nonce)
+
+	var claims struct {
+		Email             string `json:"email"`
+		EmailVerified     bool   `json:"email_verified"`
+		PreferredUsername string `json:"preferred_username"`
+	}
+	require.NoError(t, idToken.Claims(&claims))
+	assert.Equal(t, tp.user.Email, claims.Email)
+	assert.True(t, claims.EmailVerified)
+	assert.Equal(t, tp.user.Username, claims.PreferredUsername)
+
+	// The access token is only good for the userinfo endpoint, not for the API
+	_, err = auth.ValidateJWT(token.AccessToken, testSecret)
+	assert.Error(t, err)
+	accessClaims, err := auth.ValidateOIDCAccessToken(token.AccessToken, testSecret)
+	require.NoError(t, err)
+	assert.Equal(t, tp.user.ID.String(), accessClaims.Subject)
+	assert.Equal(t, []string{"web"}, []string(accessClaims.Audience))
+
+	userInfo, err := provider.UserInfo(ctx, oauth2.StaticTokenSource(token))
+	require.NoError(t, err)
+	assert.Equal(t, tp.user.ID.String(), userInfo.Subject)
+	assert.Equal(t, tp.user.Email, userInfo.Email)
+
+	// Codes are single use
+	_, err = config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	assert.ErrorContains(t, err, "invalid_grant")
+
+	tp.mockDB.AssertExpectations(t)
+}
+
+func TestAuthorizeWithConsent(t *testing.T) {
+	tp := newTestProvider(t)
+	tp.mockDB.On("GetOAuthConsent", mock.Anything, database.GetOAuthConsentParams{
+		UserID:   tp.user.ID,
+		ClientID: "web",
+	}).Return(database.OauthConsent{Scopes: []string{"email", "openid", "profile"}}, nil)
+
+	config := tp.oauthConfig("web")
+	tp.login(t, "")
+
+	// Consent was given before, so the code is issued straight away
+	callback := location(t, tp.get(t, config.AuthCodeURL("state-1")))
+	assert.NotEmpty(t, callback.Query().Get("code"))
+
+	// Unless the client asks for it again
+	consentPage := tp.get(t, config.AuthCodeURL("state-1", oauth2.SetAuthURLParam("prompt", "consent")))
+	assert.Equal(t, http.StatusOK, consentPage.StatusCode)
+	assert.Contains(t, body(t, consentPage), "csrf_token")
+}
+
+func TestAuthorizeTrustedClient(t *testing.T) {
+	tp := newTestProvider(t)
+	tp.login(t, "")
+
+	callback := location(t, tp.get(t, tp.oauthConfig("first-party").AuthCodeURL("state-1")))
+	assert.NotEmpty(t, callback.Query().Get("code"))
+	tp.mockDB.AssertNotCalled(t, "GetOAuthConsent", mock.Anything, mock.Anything)
+}
+
+func TestAuthorizeErrors(t *testing.T) {
+	testCases := []struct {
+		name          string
+		loggedIn      bool
+		clientID      string
+		params        map[string]string
+		expectedPage  int
+		expectedError string
+	}{
+		{
+			name:         "unknown client",
+			clientID:     "unknown",
+			expectedPage: http.StatusBadRequest,
+		},
+		{
+			name:         "unregistered redirect uri",
+			clientID:     "web",
+			params:       map[string]string{"redirect_uri": "https://evil.example.com/callback"},
+			expectedPage: http.StatusBadRequest,
+		},
+		{
+			name:          "missing openid scope",
+			clientID:      "web",
+			params:        map[string]string{"scope": "email"},
+			expectedError: "invalid_scope",
+		},
+		{
+			name:          "unsupported response type",
+			clientID:      "web",
+			params:        map[string]string{"response_type": "token"},
+			expectedError: "unsupported_response_type",
+		},
+		{
+			name:          "public client without pkce",
+			clientID:      "spa",
+			expectedError: "invalid_request",
+		},
+		{
+			name:          "plain code challenge",
+			clientID:      "spa",
+			params:        map[string]string{"code_challenge": "challenge", "code_challenge_method": "plain"},
+			expectedError: "invalid_request",
+		},
+		{
+			name:          "prompt none without session",
+			clientID:      "first-party",
+			params:        map[string]string{"prompt": "none"},
+			expectedError: "login_required",
+		},
+		{
+			name:          "prompt none without consent",
+			loggedIn:      true,
+			clientID:      "web",
+			params:        map[string]string{"prompt": "none"},
+			expectedError: "consent_required",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tp := newTestProvider(t)
+			tp.mockDB.On("GetOAuthConsent", mock.Anything, mock.Anything).Return(database.OauthConsent{}, sql.ErrNoRows).Maybe()
+			if tc.loggedIn {
+				tp.login(t, "")
+			}
+
+			var opts []oauth2.AuthCodeOption
+			for name, value := range tc.params {
+				opts = append(opts, oauth2.SetAuthURLParam(name, value))
+			}
+			resp := tp.get(t, tp.oauthConfig(tc.clientID).AuthCodeURL("state-1", opts...))
+
+			if tc.expectedPage != 0 {
+				assert.Equal(t, tc.expectedPage, resp.StatusCode)
+				return
+			}
+
+			callback := location(t, resp)
+			assert.Equal(t, "app.example.com", callback.Host)
+			assert.Equal(t, tc.expectedError, callback.Query().Get("error"))
+			assert.Equal(t, "state-1", callback.Query().Get("state"))
+		})
+	}
+}
+
+func TestConsentDenied(t *testing.T) {
+	tp := newTestProvider(t)
+	tp.mockDB.On("GetOAuthConsent", mock.Anything, mock.Anything).Return(database.OauthConsent{}, sql.ErrNoRows)
+	tp.login(t, "")
+
+	authURL, err := url.Parse(tp.oauthConfig("web").AuthCodeURL("state-1"))
+	require.NoError(t, err)
+	page := body(t, tp.get(t, authURL.String()))
+	match := csrfField.FindStringSubmatch(page)
+	require.Len(t, match, 2)
+
+	form := authURL.Query()
+	form.Set("consent", "allow")
+	form.Set("csrf_token", "forged")
+	forged := tp.post(t, tp.issuer()+"/authorize", form)
+	assert.Equal(t, http.StatusForbidden, forged.StatusCode)
+
+	form.Set("consent", "deny")
+	form.Set("csrf_token", match[1])
+	callback := location(t, tp.post(t, tp.issuer()+"/authorize", form))
+	assert.Equal(t, "access_denied", callback.Query().Get("error"))
+	tp.mockDB.AssertNotCalled(t, "UpsertOAuthConsent", mock.Anything, mock.Anything)
+}
+
+func TestLoginReturnTo(t *testing.T) {
+	tp := newTestProvider(t)
+
+	resp := tp.login(t, "https://evil.example.com/")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Location"))
+	assert.Contains(t, body(t, resp), "logged in as user")
+}
+
+func TestLoginRateLimited(t *testing.T) {
+	tp := newTestProvider(t)
+	tp.guard.limited = true
+
+	resp := tp.login(t, "")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Contains(t, body(t, resp), "Too many login attempts")
+	assert.Empty(t, resp.Cookies())
+	assert.Empty(t, tp.guard.logins)
+	tp.mockDB.AssertNotCalled(t, "GetUserByIdentifier", mock.Anything, mock.Anything)
+}
+
+func TestLoginUnknownUser(t *testing.T) {
+	tp := newTestProvider(t)
+
+	resp := tp.post(t, tp.issuer()+"/login", url.Values{
+		"identifier": {"nobody@example.com"},
+		"password":   {testPassword},
+	})
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	require.Len(t, tp.guard.logins, 1)
+	assert.Equal(t, uuid.Nil, tp.guard.logins[0].userID)
+	assert.Equal(t, "nobody@example.com", tp.guard.logins[0].identifier)
+}
+
+func TestSessionCookieRequiresSession(t *testing.T) {
+	tp := newTestProvider(t)
+	accessToken, err := auth.MakeJWT(tp.user.ID, testSecret, time.Hour)
+	require.NoError(t, err)
+	issuer, err := url.Parse(tp.issuer())
+	require.NoError(t, err)
+	tp.http.Jar.SetCookies(issuer, []*http.Cookie{{Name: SessionCookie, Value: accessToken}})
+
+	// An access token in the cookie is not a session, so the user has to log in
+	loginURL := location(t, tp.get(t, tp.oauthConfig("first-party").AuthCodeURL("state-1")))
+	assert.Equal(t, "/oidc/login", loginURL.Path)
+}
+
+func TestLoginRefusesSuspendedUser(t *testing.T) {
+	tp := newTestProvider(t)
+	suspended := tp.user
+	suspended.Status = database.StatusSuspended
+
+	tp.mockDB.ExpectedCalls = slices.DeleteFunc(tp.mockDB.ExpectedCalls, func(call *mock.Call) bool {
+		return call.Method == "GetUserByIdentifier"
+	})
+	tp.mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(suspended, nil)
+
+	resp := tp.login(t, "")
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Contains(t, body(t, resp), "suspended or closed")
+	assert.Empty(t, resp.Cookies())
+}
+
+func TestTokenErrors(t *testing.T) {
+	ctx := context.Background()
+
+	testCases := []struct {
+		name          string
+		clientID      string
+		clientSecret  string
+		verifier      string
+		expectedError string
+	}{
+		{
+			name:          "wrong client secret",
+			clientID:      "web",
+			clientSecret:  "wrong",
+			expectedError: "invalid_client",
+		},
+		{
+			name:          "code issued to another client",
+			clientID:      "first-party",
+			clientSecret:  testClientSecret,
+			expectedError: "invalid_grant",
+		},
+		{
+			name:          "wrong code verifier",
+			clientID:      "spa",
+			verifier:      oauth2.GenerateVerifier(),
+			expectedError: "invalid_grant",
+		},
+		{
+			name:          "missing code verifier",
+			clientID:      "spa",
+			expectedError: "invalid_grant",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tp := newTestProvider(t)
+			tp.mockDB.On("GetOAuthConsent", mock.Anything, mock.Anything).Return(database.OauthConsent{
+				Scopes: SupportedScopes,
+			}, nil)
+			tp.login(t, "")
+
+			issuedTo := "web"
+			if tc.clientID == "spa" {
+				issuedTo = "spa"
+			}
+			verifier := oauth2.GenerateVerifier()
+			authURL := tp.oauthConfig(issuedTo).AuthCodeURL("state-1", oauth2.S256ChallengeOption(verifier))
+			code := location(t, tp.get(t, authURL)).Query().Get("code")
+			require.NotEmpty(t, code)
+
+			config := tp.oauthConfig(tc.clientID)
+			config.ClientSecret = tc.clientSecret
+			var opts []oauth2.AuthCodeOption
+			if tc.verifier != "" {
+				opts = append(opts, oauth2.VerifierOption(tc.verifier))
+			}
+			_, err := config.Exchange(ctx, code, opts...)
+			assert.ErrorContains(t, err, tc.expectedError)
+		})
+	}
+}
+
+func TestUserinfoRequiresToken(t *testing.T) {
+	tp := newTestProvider(t)
+
+	resp := tp.get(t, tp.issuer()+"/userinfo")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("WWW-Authenticate"), "Bearer")
+
+	// Access tokens of the API are not accepted
+	accessToken, err := auth.MakeJWT(tp.user.ID, testSecret, time.Hour)
+	require.NoError(t, err)
+	resp = tp.userinfo(t, accessToken)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("WWW-Authenticate"), "invalid_token")
+}
+
+func TestUserinfoScopes(t *testing.T) {
+	testCases := []struct {
+		name     string
+		scopes   []string
+		expected map[string]any
+	}{
+		{
+			name:     "openid",
+			scopes:   []string{ScopeOpenID},
+			expected: map[string]any{},
+		},
+		{
+			name:     "email",
+			scopes:   []string{ScopeOpenID, ScopeEmail},
+			expected: map[string]any{"email": "user@example.com", "email_verified": true},
+		},
+		{
+			name:     "profile",
+			scopes:   []string{ScopeOpenID, ScopeProfile},
+			expected: map[string]any{"preferred_username": "user"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tp := newTestProvider(t)
+			token, err := auth.MakeOIDCAccessToken(tp.user.ID, "web", tc.scopes, testSecret, time.Hour)
+			require.NoError(t, err)
+
+			resp := tp.userinfo(t, token)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+
+			var claims map[string]any
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&claims))
+			assert.Equal(t, tp.user.ID.String(), claims["sub"])
+			delete(claims, "sub")
+			assert.Equal(t, tc.expected, claims)
+		})
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	tp := newTestProvider(t)
+
+	resp := tp.get(t, tp.issuer()+"/jwks")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&set))
+	require.Len(t, set.Keys, 1)
+	assert.Equal(t, testKey.ID, set.Keys[0].KeyID)
+	assert.Equal(t, "AQAB", set.Keys[0].Exponent)
+}
@@ -0,0 +1,79 @@
+package idp
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+)
+
+var pages = template.Must(template.New("pages").Parse(`
+{{define "header"}}<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>{{.}}</title></head>
+<body>
+<h1>{{.}}</h1>
+{{end}}
+
+{{define "footer"}}</body>
+</html>
+{{end}}
+
+{{define "login"}}{{template "header" "Log in"}}
+{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="return_to" value="{{.ReturnTo}}">
+<label>Email or username <input name="identifier" value="{{.Identifier}}" autocomplete="username" required></label>
+<label>Password <input name="password" type="password" autocomplete="current-password" required></label>
+<button type="submit">Log in</button>
+</form>
+{{template "footer"}}{{end}}
+
+{{define "consent"}}{{template "header" "Authorize application"}}
+<p><strong>{{.ClientName}}</strong> wants to access your account.</p>
+<ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>
+<form method="post" action="{{.Action}}">
+{{range $name, $values := .Params}}{{range $values}}<input type="hidden" name="{{$name}}" value="{{.}}">
+{{end}}{{end}}<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<button type="submit" name="consent" value="allow">Allow</button>
+<button type="submit" name="consent" value="deny">Deny</button>
+</form>
+{{template "footer"}}{{end}}
+
+{{define "message"}}{{template "header" .Title}}
+<p>{{.Message}}</p>
+{{template "footer"}}{{end}}
+`))
+
+type loginPage struct {
+	Action     string
+	ReturnTo   string
+	Identifier string
+	Error      string
+}
+
+type consentPage struct {
+	Action     string
+	ClientName string
+	Scopes     []string
+	Params     map[string][]string
+	CSRFToken  string
+}
+
+type messagePage struct {
+	Title   string
+	Message string
+}
+
+func render(w http.ResponseWriter, status int, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(status)
+	if err := pages.ExecuteTemplate(w, name, data); err != nil {
+		log.Printf("idp: rendering %s page: %v", name, err)
+	}
+}
+
+func renderError(w http.ResponseWriter, status int, message string) {
+	render(w, status, "message", messagePage{Title: "Something went wrong", Message: message})
+}
@@ -0,0 +1,276 @@
+package idp
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/redis"
+)
+
+// errUserInactive is returned by issueTokens when the user can no longer log in
+var errUserInactive = errors.New("user is not active")
+
+// tokenError is an error response of the token endpoint, see RFC 6749 section 5.2
+type tokenError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	IDToken     string `json:"id_token"`
+	Scope       string `json:"scope"`
+}
+
+type userinfoResponse struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email,omitempty"`
+	EmailVerified     *bool  `json:"email_verified,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+}
+
+// token redeems authorization codes for an access token and an ID token
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	if err := r.ParseForm(); err != nil {
+		writeTokenError(w, http.StatusBadRequest, "invalid_request", "the request body is malformed")
+		return
+	}
+
+	client, ok := p.authenticateClient(w, r)
+	if !ok {
+		return
+	}
+
+	if grantType := r.PostForm.Get("grant_type"); grantType != "authorization_code" {
+		writeTokenError(w, http.StatusBadRequest, "unsupported_grant_type", "only the authorization_code grant is supported")
+		return
+	}
+
+	value, err := redis.TakeAuthorizationCode(r.PostForm.Get("code"))
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			log.Printf("idp: loading authorization code: %v", err)
+		}
+		writeTokenError(w, http.StatusBadRequest, "invalid_grant", "the authorization code is invalid or expired")
+		return
+	}
+
+	var code authorizationCode
+	if err := json.Unmarshal([]byte(value), &code); err != nil {
+		log.Printf("idp: decoding authorization code: %v", err)
+		writeTokenError(w, http.StatusBadRequest, "invalid_grant", "the authorization code is invalid or expired")
+		return
+	}
+
+	if code.ClientID != client.ID || code.RedirectURI != redirectURIParam(r.PostForm, client) {
+		writeTokenError(w, http.StatusBadRequest, "invalid_grant", "the authorization code was issued to another client")
+		return
+	}
+	if code.CodeChallenge != "" && !checkCodeVerifier(code.CodeChallenge, r.PostForm.Get("code_verifier")) {
+		writeTokenError(w, http.StatusBadRequest, "invalid_grant", "the code verifier does not match the code challenge")
+		return
+	}
+
+	resp, err := p.issueTokens(r.Context(), client, code)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeTokenError(w, http.StatusBadRequest, "invalid_grant", "the user no longer exists")
+			return
+		}
+		if errors.Is(err, errUserInactive) {
+			writeTokenError(w, http.StatusBadRequest, "invalid_grant", "the user's account is suspended or closed")
+			return
+		}
+		log.Printf("idp: issuing tokens: %v", err)
+		writeTokenError(w, http.StatusInternalServerError, "server_error", "tokens could not be issued")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// authenticateClient identifies the client with client_secret_basic, client_secret_post or, for public
+// clients, just the client_id
+func (p *Provider) authenticateClient(w http.ResponseWriter, r *http.Request) (database.OauthClient, bool) {
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		// Credentials in the Authorization header are form encoded, see RFC 6749 section 2.3.1
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	fail := func() (database.OauthClient, bool) {
+		if basic {
+			w.Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		}
+		writeTokenError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return database.OauthClient{}, false
+	}
+
+	if clientID == "" {
+		return fail()
+	}
+
+	client, err := p.db.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fail()
+		}
+		log.Printf("idp: loading client %q: %v", clientID, err)
+		writeTokenError(w, http.StatusInternalServerError, "server_error", "the client could not be loaded")
+		return database.OauthClient{}, false
+	}
+
+	if client.SecretHash == "" {
+		if secret != "" {
+			return fail()
+		}
+		return client, true
+	}
+	if !auth.CheckClientSecret(client.SecretHash, secret) {
+		return fail()
+	}
+	return client, true
+}
+
+// issueTokens builds the token response for a redeemed authorization code
+func (p *Provider) issueTokens(ctx context.Context, client database.OauthClient, code authorizationCode) (tokenResponse, error) {
+	user, err := p.db.GetUserByID(ctx, code.UserID)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	if !userActive(user) {
+		return tokenResponse{}, errUserInactive
+	}
+
+	accessToken, err := auth.MakeOIDCAccessToken(user.ID, client.ID, code.Scopes, p.tokenSecret, accessTokenTTL)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	claims := auth.NewIDTokenClaims(user.ID, p.issuer, client.ID, idTokenTTL)
+	claims.Nonce = code.Nonce
+	info := userClaims(user, code.Scopes)
+	claims.Email = info.Email
+	claims.EmailVerified = info.EmailVerified
+	claims.PreferredUsername = info.PreferredUsername
+
+	idToken, err := auth.MakeIDToken(p.key, claims)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	return tokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(accessTokenTTL.Seconds()),
+		IDToken:     idToken,
+		Scope:       strings.Join(code.Scopes, " "),
+	}, nil
+}
+
+// userinfo returns the claims the scopes granted to the access token give access to about the user it belongs
+// to. Only access tokens issued by the token endpoint are accepted here
+func (p *Provider) userinfo(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="userinfo"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := auth.ValidateOIDCAccessToken(strings.TrimSpace(token), p.tokenSecret)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="userinfo", error="invalid_token"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="userinfo", error="invalid_token"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	user, err := p.db.GetUserByID(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="userinfo", error="invalid_token"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		log.Printf("idp: loading user: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !userActive(user) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="userinfo", error="invalid_token"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, userClaims(user, claims.Scopes()))
+}
+
+// userClaims returns the claims about user that scopes give access to
+func userClaims(user database.User, scopes []string) userinfoResponse {
+	info := userinfoResponse{Subject: user.ID.String()}
+	if slices.Contains(scopes, ScopeEmail) {
+		verified := user.IsVerified
+		info.Email = user.Email
+		info.EmailVerified = &verified
+	}
+	if slices.Contains(scopes, ScopeProfile) {
+		info.PreferredUsername = user.Username
+	}
+	return info
+}
+
+// redirectURIParam returns the redirect_uri of a token request, which may be left out when the client has
+// a single redirect URI, just like in the authorization request
+func redirectURIParam(form url.Values, client database.OauthClient) string {
+	if redirectURI := form.Get("redirect_uri"); redirectURI != "" {
+		return redirectURI
+	}
+	if len(client.RedirectUris) == 1 {
+		return client.RedirectUris[0]
+	}
+	return ""
+}
+
+// checkCodeVerifier checks a PKCE code verifier against its S256 challenge
+func checkCodeVerifier(challenge, verifier string) bool {
+	if verifier == "" {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+func writeTokenError(w http.ResponseWriter, status int, code, description string) {
+	writeJSON(w, status, tokenError{Code: code, Description: description})
+}
@@ -0,0 +1,174 @@
+// Package role implements the role subcommand, which assigns roles from the command line. It is how the first
+// admin is made, since the AssignRole RPC already needs one
+package role
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/emailpolicy"
+)
+
+// Usage describes the role subcommand
+const Usage = `usage: auth-service role <command> [flags]
+
+commands:
+  assign -user USER -role ROLE
+  revoke -user USER -role ROLE
+  list [-user USER]
+
+USER is a user ID, email or username`
+
+// Run executes the role subcommand with args and writes its output to out
+func Run(ctx context.Context, db database.DBQuerier, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(Usage)
+	}
+
+	switch args[0] {
+	case "assign":
+		return assign(ctx, db, args[1:], out)
+	case "revoke":
+		return revoke(ctx, db, args[1:], out)
+	case "list":
+		return list(ctx, db, args[1:], out)
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], Usage)
+	}
+}
+
+func assign(ctx context.Context, db database.DBQuerier, args []string, out io.Writer) error {
+	user, role, err := parseAssignment(ctx, db, "assign", args, out)
+	if err != nil {
+		return err
+	}
+
+	assigned, err := db.AssignRole(ctx, database.AssignRoleParams{
+		UserID: user.ID,
+		Role:   role,
+	})
+	if err != nil {
+		return err
+	}
+
+	if assigned == 0 {
+		fmt.Fprintf(out, "%s already has the %s role\n", user.Username, role)
+	} else {
+		fmt.Fprintf(out, "assigned the %s role to %s\n", role, user.Username)
+	}
+	return nil
+}
+
+func revoke(ctx context.Context, db database.DBQuerier, args []string, out io.Writer) error {
+	user, role, err := parseAssignment(ctx, db, "revoke", args, out)
+	if err != nil {
+		return err
+	}
+
+	revoked, err := db.RevokeRole(ctx, database.RevokeRoleParams{
+		UserID: user.ID,
+		Role:   role,
+	})
+	if err != nil {
+		return err
+	}
+
+	if revoked == 0 {
+		fmt.Fprintf(out, "%s does not have the %s role\n", user.Username, role)
+	} else {
+		fmt.Fprintf(out, "revoked the %s role from %s\n", role, user.Username)
+	}
+	return nil
+}
+
+func list(ctx context.Context, db database.DBQuerier, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	flags.SetOutput(out)
+	identifier := flags.String("user", "", "only list the roles of this user")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ROLE\tPERMISSIONS\tDESCRIPTION")
+
+	if *identifier == "" {
+		roles, err := db.ListRoles(ctx)
+		if err != nil {
+			return err
+		}
+		for _, role := range roles {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", role.Name, strings.Join(role.Permissions, ","), role.Description)
+		}
+		return tw.Flush()
+	}
+
+	user, err := findUser(ctx, db, *identifier)
+	if err != nil {
+		return err
+	}
+	roles, err := db.ListUserRoles(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	for _, role := range roles {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", role.Name, strings.Join(role.Permissions, ","), role.Description)
+	}
+	return tw.Flush()
+}
+
+// parseAssignment parses the flags of assign and revoke and looks up the user and the role
+func parseAssignment(ctx context.Context, db database.DBQuerier, name string, args []string, out io.Writer) (database.User, string, error) {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(out)
+	identifier := flags.String("user", "", "user ID, email or username")
+	role := flags.String("role", "", "role name, like admin")
+	if err := flags.Parse(args); err != nil {
+		return database.User{}, "", err
+	}
+	if *identifier == "" {
+		return database.User{}, "", errors.New("-user is required")
+	}
+	if *role == "" {
+		return database.User{}, "", errors.New("-role is required")
+	}
+
+	if _, err := db.GetRole(ctx, *role); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return database.User{}, "", fmt.Errorf("role %s not found", *role)
+		}
+		return database.User{}, "", err
+	}
+
+	user, err := findUser(ctx, db, *identifier)
+	if err != nil {
+		return database.User{}, "", err
+	}
+	return user, *role, nil
+}
+
+// findUser looks up a user by ID, email or username
+func findUser(ctx context.Context, db database.DBQuerier, identifier string) (database.User, error) {
+	var user database.User
+	var err error
+	if id, parseErr := uuid.Parse(identifier); parseErr == nil {
+		user, err = db.GetUserByID(ctx, id)
+	} else {
+		user, err = db.GetUserByIdentifier(ctx, database.GetUserByIdentifierParams{
+			EmailCanonical: emailpolicy.LookupKey(identifier),
+			Username:       identifier,
+		})
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, fmt.Errorf("user %s not found", identifier)
+	}
+	return user, err
+}
@@ -0,0 +1,122 @@
+package role
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/database/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssign(t *testing.T) {
+	user := database.User{ID: uuid.New(), Username: "alice", Email: "alice@example.com"}
+
+	testCases := []struct {
+		name      string
+		args      []string
+		mockSetup func(*mocks.MockQueries)
+		expected  string
+	}{
+		{
+			name: "by email",
+			args: []string{"assign", "-user", "alice@example.com", "-role", "admin"},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByIdentifier", mock.Anything, database.GetUserByIdentifierParams{
+					EmailCanonical: "alice@example.com",
+					Username:       "alice@example.com",
+				}).Return(user, nil)
+			},
+			expected: "assigned the admin role to alice\n",
+		},
+		{
+			name: "by ID",
+			args: []string{"assign", "-user", user.ID.String(), "-role", "admin"},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByID", mock.Anything, user.ID).Return(user, nil)
+			},
+			expected: "assigned the admin role to alice\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB := new(mocks.MockQueries)
+			mockDB.On("GetRole", mock.Anything, "admin").Return(database.Role{Name: "admin"}, nil)
+			mockDB.On("AssignRole", mock.Anything, database.AssignRoleParams{UserID: user.ID, Role: "admin"}).Return(int64(1), nil)
+			tc.mockSetup(mockDB)
+
+			var out bytes.Buffer
+			require.NoError(t, Run(context.Background(), mockDB, tc.args, &out))
+			assert.Equal(t, tc.expected, out.String())
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAssignErrors(t *testing.T) {
+	testCases := []struct {
+		name      string
+		args      []string
+		mockSetup func(*mocks.MockQueries)
+	}{
+		{name: "missing user", args: []string{"assign", "-role", "admin"}, mockSetup: func(*mocks.MockQueries) {}},
+		{name: "missing role", args: []string{"assign", "-user", "alice"}, mockSetup: func(*mocks.MockQueries) {}},
+		{
+			name: "unknown role",
+			args: []string{"assign", "-user", "alice", "-role", "owner"},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetRole", mock.Anything, "owner").Return(database.Role{}, sql.ErrNoRows)
+			},
+		},
+		{
+			name: "unknown user",
+			args: []string{"assign", "-user", "bob", "-role", "admin"},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetRole", mock.Anything, "admin").Return(database.Role{Name: "admin"}, nil)
+				mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(database.User{}, sql.ErrNoRows)
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB := new(mocks.MockQueries)
+			tc.mockSetup(mockDB)
+
+			var out bytes.Buffer
+			assert.Error(t, Run(context.Background(), mockDB, tc.args, &out))
+			mockDB.AssertNotCalled(t, "AssignRole", mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	user := database.User{ID: uuid.New(), Username: "alice"}
+	mockDB := new(mocks.MockQueries)
+	mockDB.On("GetRole", mock.Anything, "admin").Return(database.Role{Name: "admin"}, nil)
+	mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(user, nil)
+	mockDB.On("RevokeRole", mock.Anything, database.RevokeRoleParams{UserID: user.ID, Role: "admin"}).Return(int64(0), nil)
+
+	var out bytes.Buffer
+	require.NoError(t, Run(context.Background(), mockDB, []string{"revoke", "-user", "alice", "-role", "admin"}, &out))
+	assert.Equal(t, "alice does not have the admin role\n", out.String())
+	mockDB.AssertExpectations(t)
+}
+
+func TestList(t *testing.T) {
+	mockDB := new(mocks.MockQueries)
+	mockDB.On("ListRoles", mock.Anything).Return([]database.ListRolesRow{
+		{Name: "admin", Description: "Manages users and their roles", Permissions: []string{"roles:read", "roles:write"}},
+	}, nil)
+
+	var out bytes.Buffer
+	require.NoError(t, Run(context.Background(), mockDB, []string{"list"}, &out))
+	assert.Contains(t, out.String(), "admin  roles:read,roles:write  Manages users and their roles")
+	mockDB.AssertExpectations(t)
+}
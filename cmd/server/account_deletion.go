@@ -0,0 +1,290 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/cmd/helper"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/outbox"
+	"github.com/imhasandl/auth-service/internal/redis"
+	pb "github.com/imhasandl/auth-service/protos"
+	eventsv1 "github.com/imhasandl/auth-service/protos/events/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// accountDeletionBatchSize is how many due accounts one run of PurgeDeletedAccounts deletes
+const accountDeletionBatchSize = 100
+
+// accountDeletionReason is the status reason of accounts waiting for deletion
+const accountDeletionReason = "deletion requested by the user"
+
+// DeleteAccount schedules the account of the logged in user for deletion. The user confirms with their password,
+// or with a login code when the account has none. The account is marked deleted right away, every session is signed
+// out and an email with a cancel link is sent. The account and its data are purged once the grace period ends.
+// Requests count against the login rate limit.
+func (s *Server) DeleteAccount(ctx context.Context, req *pb.DeleteAccountRequest) (*pb.DeleteAccountResponse, error) {
+	userID, err := s.authenticatedUser(ctx)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	if err := s.checkRateLimit("account_deletion:" + userID.String()); err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	user, err := s.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	var loginCode database.VerificationCode
+	if user.Password != "" {
+		if req.GetPassword() == "" {
+			return nil, helper.RespondWithError(ctx, autherr.InvalidArgument("password", "password is required"))
+		}
+		if err := auth.CheckPassword(user.Password, req.GetPassword()); err != nil {
+			return nil, helper.RespondWithError(ctx, autherr.InvalidCredentials().WithCause(err))
+		}
+	} else {
+		if req.GetVerificationCode() == 0 {
+			return nil, helper.RespondWithError(ctx, autherr.InvalidArgument("verification_code", "verification_code is required for accounts without a password"))
+		}
+		loginCode, err = s.checkCode(ctx, userID, database.PurposeLoginCode, req.GetDeviceId(), func(codeHash string) bool {
+			return auth.CheckVerificationCode(codeHash, req.GetVerificationCode(), s.tokenSecret)
+		})
+		if err != nil {
+			return nil, helper.RespondWithError(ctx, err)
+		}
+	}
+
+	cancelToken, err := auth.MakeAccountDeletionCancelToken()
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	purgeAfter := time.Now().Add(s.accountDeletionGracePeriod)
+
+	err = s.db.WithTx(ctx, func(q DBQuerier) error {
+		if loginCode.CodeHash != "" {
+			consumed, err := q.ConsumeVerificationCode(ctx, database.ConsumeVerificationCodeParams{
+				UserID:   userID,
+				Purpose:  database.PurposeLoginCode,
+				CodeHash: loginCode.CodeHash,
+			})
+			if err != nil {
+				return err
+			}
+			if consumed == 0 {
+				// Another request used the code first
+				return autherr.VerificationCodeExpired()
+			}
+		}
+
+		_, err := q.SetUserStatus(ctx, database.SetUserStatusParams{
+			ID:           userID,
+			Status:       database.StatusDeleted,
+			StatusReason: accountDeletionReason,
+			StatusUntil:  sql.NullTime{Time: purgeAfter, Valid: true},
+		})
+		if err != nil {
+			return err
+		}
+
+		err = q.ScheduleAccountDeletion(ctx, database.ScheduleAccountDeletionParams{
+			UserID:     userID,
+			TokenHash:  auth.HashSingleUseToken(cancelToken),
+			PurgeAfter: purgeAfter,
+		})
+		if err != nil {
+			return err
+		}
+
+		return deleteAllSessions(ctx, q, userID)
+	})
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	if err := redis.DeleteAllUserTokens(userID.String()); err != nil {
+		log.Printf("Error deleting cached tokens after account deletion request: %v", err)
+	}
+
+	s.recordEvent(ctx, AuthEvent{
+		Type:    EventAccountDeletionRequested,
+		UserID:  userID,
+		Details: map[string]string{"purge_after": purgeAfter.UTC().Format(time.RFC3339)},
+	})
+
+	if err := s.sendAccountDeletionScheduledEmail(user.Email, cancelToken, purgeAfter); err != nil {
+		log.Printf("Error sending account deletion notice: %v", err)
+	}
+
+	return &pb.DeleteAccountResponse{
+		Success:    true,
+		Message:    "The account was signed out everywhere and will be deleted after the grace period",
+		PurgeAfter: timestamppb.New(purgeAfter),
+	}, nil
+}
+
+func (s *Server) sendAccountDeletionScheduledEmail(email, cancelToken string, purgeAfter time.Time) error {
+	var link string
+	if s.accountDeletionCancelURL != "" {
+		var err error
+		link, err = linkWithToken(s.accountDeletionCancelURL, cancelToken)
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.email == "test@example.com" {
+		return nil
+	}
+	return auth.SendAccountDeletionScheduledEmail(email, s.email, s.emailSecret, link, purgeAfter)
+}
+
+// CancelAccountDeletion handles the cancel link sent after DeleteAccount. The account becomes active again and the
+// user can log in as before. The link works once and only until the grace period ends.
+func (s *Server) CancelAccountDeletion(ctx context.Context, req *pb.CancelAccountDeletionRequest) (*pb.CancelAccountDeletionResponse, error) {
+	if req.GetToken() == "" {
+		return nil, helper.RespondWithError(ctx, autherr.InvalidArgument("token", "token is required"))
+	}
+
+	var deletion database.AccountDeletion
+	err := s.db.WithTx(ctx, func(q DBQuerier) error {
+		var err error
+		deletion, err = q.TakeAccountDeletion(ctx, auth.HashSingleUseToken(req.GetToken()))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return autherr.AccountDeletionCancelInvalid().WithCause(err)
+			}
+			return err
+		}
+
+		_, err = q.SetUserStatus(ctx, database.SetUserStatusParams{
+			ID:     deletion.UserID,
+			Status: database.StatusActive,
+		})
+		return err
+	})
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	s.recordEvent(ctx, AuthEvent{
+		Type:   EventAccountDeletionCancelled,
+		UserID: deletion.UserID,
+	})
+
+	return &pb.CancelAccountDeletionResponse{
+		Success: true,
+		Message: "The account deletion was cancelled, you can log in again",
+	}, nil
+}
+
+// PurgeDeletedAccounts deletes the accounts whose deletion grace period ended. Tokens, devices, identities, data
+// export archives and other personal data are always deleted. Depending on the deletion mode the user row is then anonymized, keeping
+// posts, comments and messages of the user, or deleted with them. A user.deleted event is written to the outbox for
+// each account. main runs it periodically
+func (s *Server) PurgeDeletedAccounts(ctx context.Context) error {
+	due, err := s.db.ListDueAccountDeletions(ctx, accountDeletionBatchSize)
+	if err != nil {
+		return err
+	}
+
+	var purged int
+	for _, userID := range due {
+		ok, err := s.purgeAccount(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("purging account %s: %w", userID, err)
+		}
+		if ok {
+			purged++
+		}
+	}
+	if purged > 0 {
+		log.Printf("Purged %d deleted accounts", purged)
+	}
+	return nil
+}
+
+// purgeAccount deletes one account that is due. It reports false when the deletion was cancelled or another
+// replica purged the account in the meantime
+func (s *Server) purgeAccount(ctx context.Context, userID uuid.UUID) (bool, error) {
+	mode := "delete"
+	if s.accountDeletionAnonymize {
+		mode = "anonymize"
+	}
+
+	var (
+		claimed        bool
+		exportArchives []string
+	)
+	err := s.db.WithTx(ctx, func(q DBQuerier) error {
+		rows, err := q.ClaimAccountDeletion(ctx, userID)
+		if err != nil || rows == 0 {
+			return err
+		}
+		claimed = true
+
+		if err := q.DeleteUserPersonalData(ctx, userID); err != nil {
+			return err
+		}
+		if s.dataExportStore != nil {
+			if exportArchives, err = q.ExpireUserDataExports(ctx, userID); err != nil {
+				return err
+			}
+		}
+
+		err = enqueueEvent(ctx, q, outbox.UserDeleted, userID, &eventsv1.UserDeleted{
+			UserId: userID.String(),
+			Mode:   mode,
+		})
+		if err != nil {
+			return err
+		}
+
+		if s.accountDeletionAnonymize {
+			_, err := q.AnonymizeUser(ctx, database.AnonymizeUserParams{
+				ID:       userID,
+				Email:    "deleted+" + userID.String() + "@deleted.invalid",
+				Username: anonymizedUsername(userID),
+			})
+			return err
+		}
+
+		if err := q.DeleteUserContent(ctx, userID); err != nil {
+			return err
+		}
+		_, err = q.DeleteUser(ctx, userID)
+		return err
+	})
+	if err != nil || !claimed {
+		return false, err
+	}
+
+	if err := redis.DeleteAllUserTokens(userID.String()); err != nil {
+		log.Printf("Error deleting cached tokens of purged account: %v", err)
+	}
+	s.deleteDataExportArchives(ctx, exportArchives)
+
+	s.recordEvent(ctx, AuthEvent{
+		Type:    EventAccountDeleted,
+		UserID:  userID,
+		Details: map[string]string{"mode": mode},
+	})
+	return true, nil
+}
+
+// anonymizedUsername is the username an anonymized account keeps. It is made from the user ID, so it is unique and
+// says nothing about the user, and fits the username length limit
+func anonymizedUsername(userID uuid.UUID) string {
+	return ("deleted_" + strings.ReplaceAll(userID.String(), "-", ""))[:maxUsernameLength]
+}
@@ -0,0 +1,274 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/database/mocks"
+	"github.com/imhasandl/auth-service/internal/outbox"
+	"github.com/imhasandl/auth-service/internal/redis"
+	pb "github.com/imhasandl/auth-service/protos"
+	eventsv1 "github.com/imhasandl/auth-service/protos/events/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func TestDeleteAccount(t *testing.T) {
+	userID := uuid.New()
+	hashedPassword, err := auth.HashPassword("password123")
+	require.NoError(t, err)
+	user := database.User{ID: userID, Email: "user@example.com", Password: hashedPassword}
+	passwordless := database.User{ID: userID, Email: "user@example.com"}
+	loginCode := database.VerificationCode{
+		UserID:     userID,
+		Purpose:    database.PurposeLoginCode,
+		CodeHash:   auth.HashVerificationCode(123456, "test-secret"),
+		DeviceHash: auth.HashDeviceID("device-1", "test-secret"),
+		ExpiresAt:  time.Now().Add(time.Minute),
+	}
+
+	scheduled := func(mockDB *mocks.MockQueries) {
+		mockDB.On("SetUserStatus", mock.Anything, mock.MatchedBy(func(arg database.SetUserStatusParams) bool {
+			return arg.ID == userID && arg.Status == database.StatusDeleted && arg.StatusUntil.Valid
+		})).Return(database.User{}, nil)
+		mockDB.On("ScheduleAccountDeletion", mock.Anything, mock.MatchedBy(func(arg database.ScheduleAccountDeletionParams) bool {
+			return arg.UserID == userID && arg.TokenHash != "" &&
+				arg.PurgeAfter.Sub(time.Now()) > 29*24*time.Hour
+		})).Return(nil)
+		mockDB.On("DeleteTokenByUserID", mock.Anything, userID).Return(nil)
+		mockDB.On("DeleteUserDeviceTokens", mock.Anything, userID).Return(nil)
+		expectLoggedOutEverywhere(t, mockDB, userID)
+	}
+
+	testCases := []struct {
+		name      string
+		request   *pb.DeleteAccountRequest
+		mockSetup func(*mocks.MockQueries)
+		code      codes.Code
+		reason    autherr.Reason
+	}{
+		{
+			name:    "confirmed with password",
+			request: &pb.DeleteAccountRequest{Password: "password123"},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByID", mock.Anything, userID).Return(user, nil)
+				scheduled(mockDB)
+			},
+			code: codes.OK,
+		},
+		{
+			name:    "missing password",
+			request: &pb.DeleteAccountRequest{},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByID", mock.Anything, userID).Return(user, nil)
+			},
+			code:   codes.InvalidArgument,
+			reason: autherr.ReasonInvalidArgument,
+		},
+		{
+			name:    "wrong password",
+			request: &pb.DeleteAccountRequest{Password: "wrong"},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByID", mock.Anything, userID).Return(user, nil)
+			},
+			code:   codes.Unauthenticated,
+			reason: autherr.ReasonInvalidCredentials,
+		},
+		{
+			name:    "confirmed with login code",
+			request: &pb.DeleteAccountRequest{VerificationCode: 123456, DeviceId: "device-1"},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByID", mock.Anything, userID).Return(passwordless, nil)
+				mockDB.On("GetVerificationCode", mock.Anything, database.GetVerificationCodeParams{
+					UserID:  userID,
+					Purpose: database.PurposeLoginCode,
+				}).Return(loginCode, nil)
+				mockDB.On("ConsumeVerificationCode", mock.Anything, database.ConsumeVerificationCodeParams{
+					UserID:   userID,
+					Purpose:  database.PurposeLoginCode,
+					CodeHash: loginCode.CodeHash,
+				}).Return(int64(1), nil)
+				scheduled(mockDB)
+			},
+			code: codes.OK,
+		},
+		{
+			name:    "password-less account without a code",
+			request: &pb.DeleteAccountRequest{Password: "password123"},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByID", mock.Anything, userID).Return(passwordless, nil)
+			},
+			code:   codes.InvalidArgument,
+			reason: autherr.ReasonInvalidArgument,
+		},
+		{
+			name:    "login code from another device",
+			request: &pb.DeleteAccountRequest{VerificationCode: 123456, DeviceId: "device-2"},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByID", mock.Anything, userID).Return(passwordless, nil)
+				mockDB.On("GetVerificationCode", mock.Anything, mock.Anything).Return(loginCode, nil)
+				mockDB.On("IncrementVerificationCodeAttempts", mock.Anything, mock.Anything).Return(int32(1), nil)
+			},
+			code:   codes.PermissionDenied,
+			reason: autherr.ReasonDeviceMismatch,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testRedis.FlushAll()
+			mockDB := activeUsers(new(mocks.MockQueries))
+			tc.mockSetup(mockDB)
+			sink := &recordingSink{}
+			server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret",
+				WithAuditSink(sink, false),
+				WithAccountDeletion("https://example.com/cancel", 30*24*time.Hour, true),
+			)
+			require.NoError(t, redis.SaveRefreshToken(userID.String(), "refresh", time.Hour))
+
+			response, err := server.DeleteAccount(withAccessToken(t, userID), tc.request)
+
+			if tc.code != codes.OK {
+				assertReason(t, err, tc.code, tc.reason)
+				assert.Empty(t, sink.events)
+			} else {
+				require.NoError(t, err)
+				assert.True(t, response.Success)
+				assert.WithinDuration(t, time.Now().Add(30*24*time.Hour), response.PurgeAfter.AsTime(), time.Minute)
+				require.Len(t, sink.events, 1)
+				assert.Equal(t, EventAccountDeletionRequested, sink.events[0].Type)
+
+				_, err := redis.GetRefreshToken(userID.String())
+				assert.ErrorIs(t, err, redis.Nil, "cached sessions are signed out")
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCancelAccountDeletion(t *testing.T) {
+	userID := uuid.New()
+
+	t.Run("cancelled", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		sink := &recordingSink{}
+		server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret", WithAuditSink(sink, false))
+		mockDB.On("TakeAccountDeletion", mock.Anything, auth.HashSingleUseToken("cancel-token")).
+			Return(database.AccountDeletion{UserID: userID}, nil)
+		mockDB.On("SetUserStatus", mock.Anything, database.SetUserStatusParams{ID: userID, Status: database.StatusActive}).
+			Return(database.User{ID: userID, Status: database.StatusActive}, nil)
+
+		response, err := server.CancelAccountDeletion(context.Background(), &pb.CancelAccountDeletionRequest{Token: "cancel-token"})
+
+		require.NoError(t, err)
+		assert.True(t, response.Success)
+		require.Len(t, sink.events, 1)
+		assert.Equal(t, EventAccountDeletionCancelled, sink.events[0].Type)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("unknown or expired link", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+		mockDB.On("TakeAccountDeletion", mock.Anything, mock.Anything).Return(database.AccountDeletion{}, sql.ErrNoRows)
+
+		_, err := server.CancelAccountDeletion(context.Background(), &pb.CancelAccountDeletionRequest{Token: "cancel-token"})
+
+		assertReason(t, err, codes.Unauthenticated, autherr.ReasonAccountDeletionCancelInvalid)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("missing token", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+
+		_, err := server.CancelAccountDeletion(context.Background(), &pb.CancelAccountDeletionRequest{})
+
+		assertReason(t, err, codes.InvalidArgument, autherr.ReasonInvalidArgument)
+	})
+}
+
+func TestPurgeDeletedAccounts(t *testing.T) {
+	anonymized, deleted, cancelled := uuid.New(), uuid.New(), uuid.New()
+
+	t.Run("anonymize", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		sink := &recordingSink{}
+		server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret", WithAuditSink(sink, false))
+		mockDB.On("ListDueAccountDeletions", mock.Anything, int32(accountDeletionBatchSize)).
+			Return([]uuid.UUID{anonymized, cancelled}, nil)
+		mockDB.On("ClaimAccountDeletion", mock.Anything, anonymized).Return(int64(1), nil)
+		mockDB.On("ClaimAccountDeletion", mock.Anything, cancelled).Return(int64(0), nil)
+		mockDB.On("DeleteUserPersonalData", mock.Anything, anonymized).Return(nil)
+		mockDB.On("AnonymizeUser", mock.Anything, mock.MatchedBy(func(arg database.AnonymizeUserParams) bool {
+			return arg.ID == anonymized && arg.Email == "deleted+"+anonymized.String()+"@deleted.invalid" &&
+				len(arg.Username) == maxUsernameLength && usernameChars.MatchString(arg.Username)
+		})).Return(database.User{ID: anonymized}, nil)
+		var event eventsv1.UserDeleted
+		mockDB.On("CreateOutboxEvent", mock.Anything, outboxEvent(t, outbox.UserDeleted, anonymized, &event)).
+			Return(nil).Once()
+		mockDB.On("CreateWebhookDeliveries", mock.Anything, mock.Anything).Return(nil)
+
+		require.NoError(t, server.PurgeDeletedAccounts(context.Background()))
+
+		assert.Equal(t, anonymized.String(), event.GetUserId())
+		assert.Equal(t, "anonymize", event.GetMode())
+		require.Len(t, sink.events, 1)
+		assert.Equal(t, map[string]string{"mode": "anonymize"}, sink.events[0].Details)
+		mockDB.AssertExpectations(t)
+		mockDB.AssertNotCalled(t, "DeleteUserPersonalData", mock.Anything, cancelled)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret",
+			WithAccountDeletion("", 30*24*time.Hour, false),
+		)
+		mockDB.On("ListDueAccountDeletions", mock.Anything, mock.Anything).Return([]uuid.UUID{deleted}, nil)
+		mockDB.On("ClaimAccountDeletion", mock.Anything, deleted).Return(int64(1), nil)
+		mockDB.On("DeleteUserPersonalData", mock.Anything, deleted).Return(nil)
+		mockDB.On("DeleteUserContent", mock.Anything, deleted).Return(nil)
+		mockDB.On("DeleteUser", mock.Anything, deleted).Return(int64(1), nil)
+		var event eventsv1.UserDeleted
+		mockDB.On("CreateOutboxEvent", mock.Anything, outboxEvent(t, outbox.UserDeleted, deleted, &event)).
+			Return(nil).Once()
+		mockDB.On("CreateWebhookDeliveries", mock.Anything, mock.Anything).Return(nil)
+
+		require.NoError(t, server.PurgeDeletedAccounts(context.Background()))
+
+		assert.Equal(t, "delete", event.GetMode())
+		mockDB.AssertExpectations(t)
+		mockDB.AssertNotCalled(t, "AnonymizeUser", mock.Anything, mock.Anything)
+	})
+}
+
+func TestPurgeDeletedAccountDeletesDataExports(t *testing.T) {
+	userID := uuid.New()
+	mockDB := new(mocks.MockQueries)
+	server, store := newDataExportServer(t, mockDB, &recordingSink{})
+	_, err := store.Put(context.Background(), "export.zip", strings.NewReader("archive"))
+	require.NoError(t, err)
+	mockDB.On("ListDueAccountDeletions", mock.Anything, mock.Anything).Return([]uuid.UUID{userID}, nil)
+	mockDB.On("ClaimAccountDeletion", mock.Anything, userID).Return(int64(1), nil)
+	mockDB.On("DeleteUserPersonalData", mock.Anything, userID).Return(nil)
+	mockDB.On("ExpireUserDataExports", mock.Anything, userID).Return([]string{"export.zip"}, nil)
+	mockDB.On("AnonymizeUser", mock.Anything, mock.Anything).Return(database.User{ID: userID}, nil)
+	mockDB.On("CreateOutboxEvent", mock.Anything, mock.Anything).Return(nil)
+	mockDB.On("CreateWebhookDeliveries", mock.Anything, mock.Anything).Return(nil)
+
+	require.NoError(t, server.PurgeDeletedAccounts(context.Background()))
+
+	_, err = store.Open(context.Background(), "export.zip")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	mockDB.AssertExpectations(t)
+}
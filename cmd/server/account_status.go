@@ -0,0 +1,42 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+)
+
+// checkAccountStatus turns away users whose account isn't active
+func checkAccountStatus(status string, until sql.NullTime) error {
+	switch database.EffectiveStatus(status, until, time.Now()) {
+	case database.StatusSuspended:
+		var end time.Time
+		if until.Valid {
+			end = until.Time
+		}
+		return autherr.AccountSuspended(end)
+	case database.StatusBanned:
+		return autherr.AccountBanned()
+	case database.StatusDeleted:
+		return autherr.AccountDeleted()
+	default:
+		return nil
+	}
+}
+
+// LiftExpiredSuspensions makes users whose timed suspension ended active again. Logging in already works
+// once the suspension ends, this only keeps the users table up to date. main runs it periodically
+func (s *Server) LiftExpiredSuspensions(ctx context.Context) error {
+	lifted, err := s.db.LiftExpiredSuspensions(ctx)
+	if err != nil {
+		return err
+	}
+	if lifted > 0 {
+		log.Printf("Lifted %d expired suspensions", lifted)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/database/mocks"
+	pb "github.com/imhasandl/auth-service/protos"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func TestCheckAccountStatus(t *testing.T) {
+	until := time.Now().Add(time.Hour)
+
+	testCases := []struct {
+		name        string
+		status      string
+		until       sql.NullTime
+		errorReason autherr.Reason
+	}{
+		{name: "active", status: database.StatusActive},
+		{name: "suspended", status: database.StatusSuspended, errorReason: autherr.ReasonAccountSuspended},
+		{name: "suspended for an hour", status: database.StatusSuspended, until: sql.NullTime{Time: until, Valid: true},
+			errorReason: autherr.ReasonAccountSuspended},
+		{name: "suspension ended", status: database.StatusSuspended, until: sql.NullTime{Time: time.Now().Add(-time.Second), Valid: true}},
+		{name: "banned", status: database.StatusBanned, errorReason: autherr.ReasonAccountBanned},
+		{name: "deleted", status: database.StatusDeleted, errorReason: autherr.ReasonAccountDeleted},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkAccountStatus(tc.status, tc.until)
+			if tc.errorReason == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			var authErr *autherr.Error
+			require.True(t, errors.As(err, &authErr))
+			assert.Equal(t, codes.PermissionDenied, authErr.Code)
+			assert.Equal(t, tc.errorReason, authErr.Reason)
+			if tc.until.Valid {
+				assert.Equal(t, tc.until.Time.UTC().Format(time.RFC3339), authErr.Metadata["until"])
+			}
+		})
+	}
+}
+
+func TestAccessTokenOfSuspendedUser(t *testing.T) {
+	userID := uuid.New()
+	mockDB := new(mocks.MockQueries)
+	server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+
+	mockDB.On("GetUserStatus", mock.Anything, userID).Return(database.GetUserStatusRow{Status: database.StatusSuspended}, nil)
+
+	_, err := server.ListAPIKeys(withAccessToken(t, userID), &pb.ListAPIKeysRequest{})
+	assertReason(t, err, codes.PermissionDenied, autherr.ReasonAccountSuspended)
+	mockDB.AssertExpectations(t)
+}
+
+func TestCheckUserStatus(t *testing.T) {
+	bannedID, deletedID, unknownID, activeID := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	mockDB := new(mocks.MockQueries)
+	server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+	mockDB.On("GetUserStatus", mock.Anything, bannedID).Return(database.GetUserStatusRow{Status: database.StatusBanned}, nil)
+	mockDB.On("GetUserStatus", mock.Anything, deletedID).Return(database.GetUserStatusRow{Status: database.StatusDeleted}, nil)
+	mockDB.On("GetUserStatus", mock.Anything, unknownID).Return(database.GetUserStatusRow{}, sql.ErrNoRows)
+	mockDB.On("GetUserStatus", mock.Anything, activeID).Return(database.GetUserStatusRow{Status: database.StatusActive}, nil)
+
+	assertReason(t, server.CheckUserStatus(context.Background(), bannedID), codes.PermissionDenied, autherr.ReasonAccountBanned)
+	assertReason(t, server.CheckUserStatus(context.Background(), deletedID), codes.PermissionDenied, autherr.ReasonAccountDeleted)
+	assertReason(t, server.CheckUserStatus(context.Background(), unknownID), codes.Unauthenticated, autherr.ReasonAccessTokenInvalid)
+	assert.NoError(t, server.CheckUserStatus(context.Background(), activeID))
+}
+
+func TestLiftExpiredSuspensions(t *testing.T) {
+	mockDB := new(mocks.MockQueries)
+	server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+
+	mockDB.On("LiftExpiredSuspensions", mock.Anything).Return(int64(2), nil).Once()
+	require.NoError(t, server.LiftExpiredSuspensions(context.Background()))
+
+	mockDB.On("LiftExpiredSuspensions", mock.Anything).Return(int64(0), errors.New("connection refused")).Once()
+	assert.Error(t, server.LiftExpiredSuspensions(context.Background()))
+	mockDB.AssertExpectations(t)
+}
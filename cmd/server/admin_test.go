@@ -0,0 +1,561 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/cmd/authz"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/database/mocks"
+	"github.com/imhasandl/auth-service/internal/outbox"
+	"github.com/imhasandl/auth-service/internal/redis"
+	pb "github.com/imhasandl/auth-service/protos"
+	eventsv1 "github.com/imhasandl/auth-service/protos/events/v1"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// adminContext returns a context carrying an authorized admin, as the authz interceptor would leave it
+func adminContext(adminID uuid.UUID) context.Context {
+	return authz.NewContext(context.Background(), authz.Principal{
+		Subject:     adminID.String(),
+		TokenType:   auth.TokenTypeAccess,
+		Permissions: []string{PermissionUsersRead, PermissionUsersWrite},
+	})
+}
+
+// expectAudit expects an admin audit entry for action about target and returns its details once recorded
+func expectAudit(mockDB *mocks.MockQueries, adminID uuid.UUID, action string, target uuid.UUID) *map[string]any {
+	details := new(map[string]any)
+	mockDB.On("CreateAdminAuditEntry", mock.Anything, mock.MatchedBy(func(arg database.CreateAdminAuditEntryParams) bool {
+		return arg.Actor == adminID.String() && arg.Action == action &&
+			arg.TargetUserID == uuid.NullUUID{UUID: target, Valid: target != uuid.Nil}
+	})).Run(func(args mock.Arguments) {
+		_ = json.Unmarshal(args.Get(1).(database.CreateAdminAuditEntryParams).Details, details)
+	}).Return(nil)
+	return details
+}
+
+func TestAdminRequiresPrincipal(t *testing.T) {
+	mockDB := new(mocks.MockQueries)
+	admin := NewServer(mockDB, "test-secret", "test@example.com", "email-secret").Admin()
+
+	_, err := admin.GetUser(context.Background(), &pb.GetUserRequest{UserId: uuid.NewString()})
+	assertReason(t, err, codes.Unauthenticated, autherr.ReasonAccessTokenInvalid)
+
+	_, err = admin.ListUsers(context.Background(), &pb.ListUsersRequest{})
+	assertReason(t, err, codes.Unauthenticated, autherr.ReasonAccessTokenInvalid)
+	mockDB.AssertExpectations(t)
+}
+
+func TestAdminListUsers(t *testing.T) {
+	adminID := uuid.New()
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	users := []database.User{
+		{ID: uuid.New(), CreatedAt: now, Email: "first@example.com"},
+		{ID: uuid.New(), CreatedAt: now.Add(-time.Minute), Email: "second@example.com"},
+		{ID: uuid.New(), CreatedAt: now.Add(-2 * time.Minute), Email: "third@example.com"},
+	}
+
+	t.Run("pages through users", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		admin := NewServer(mockDB, "test-secret", "test@example.com", "email-secret").Admin()
+
+		mockDB.On("ListUsers", mock.Anything, database.ListUsersParams{
+			IsVerified: sql.NullBool{Bool: true, Valid: true},
+			Search:     sql.NullString{String: `ex\_ample`, Valid: true},
+			PageSize:   3,
+		}).Return(users, nil).Once()
+		details := expectAudit(mockDB, adminID, auditListUsers, uuid.Nil)
+
+		response, err := admin.ListUsers(adminContext(adminID), &pb.ListUsersRequest{
+			PageSize:   2,
+			IsVerified: proto.Bool(true),
+			Query:      "ex_ample",
+		})
+		require.NoError(t, err)
+		require.Len(t, response.Users, 2)
+		assert.Equal(t, "first@example.com", response.Users[0].User.Email)
+		assert.NotEmpty(t, response.NextPageToken)
+		assert.Equal(t, float64(2), (*details)["returned"])
+
+		createdAt, id, err := decodeUsersPageToken(response.NextPageToken)
+		require.NoError(t, err)
+		assert.True(t, users[1].CreatedAt.Equal(createdAt))
+		assert.Equal(t, users[1].ID, id)
+
+		mockDB.On("ListUsers", mock.Anything, database.ListUsersParams{
+			CursorCreatedAt: sql.NullTime{Time: createdAt, Valid: true},
+			CursorID:        uuid.NullUUID{UUID: users[1].ID, Valid: true},
+			PageSize:        3,
+		}).Return(users[2:], nil).Once()
+
+		response, err = admin.ListUsers(adminContext(adminID), &pb.ListUsersRequest{
+			PageSize:  2,
+			PageToken: response.NextPageToken,
+		})
+		require.NoError(t, err)
+		require.Len(t, response.Users, 1)
+		assert.Empty(t, response.NextPageToken)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("defaults the page size", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		admin := NewServer(mockDB, "test-secret", "test@example.com", "email-secret").Admin()
+
+		mockDB.On("ListUsers", mock.Anything, database.ListUsersParams{PageSize: defaultUsersPageSize + 1}).Return(users, nil)
+		expectAudit(mockDB, adminID, auditListUsers, uuid.Nil)
+
+		response, err := admin.ListUsers(adminContext(adminID), &pb.ListUsersRequest{})
+		require.NoError(t, err)
+		assert.Len(t, response.Users, 3)
+		assert.Empty(t, response.NextPageToken)
+		mockDB.AssertExpectations(t)
+	})
+
+	for name, req := range map[string]*pb.ListUsersRequest{
+		"page size too large": {PageSize: maxUsersPageSize + 1},
+		"negative page size":  {PageSize: -1},
+		"invalid page token":  {PageToken: "not a token"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			mockDB := new(mocks.MockQueries)
+			admin := NewServer(mockDB, "test-secret", "test@example.com", "email-secret").Admin()
+
+			_, err := admin.ListUsers(adminContext(adminID), req)
+			assertReason(t, err, codes.InvalidArgument, autherr.ReasonInvalidArgument)
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAdminGetUser(t *testing.T) {
+	adminID := uuid.New()
+	userID := uuid.New()
+
+	testCases := []struct {
+		name        string
+		userID      string
+		mockSetup   func(*mocks.MockQueries)
+		errorCode   codes.Code
+		errorReason autherr.Reason
+	}{
+		{
+			name:   "found",
+			userID: userID.String(),
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByID", mock.Anything, userID).Return(database.User{
+					ID:              userID,
+					Email:           "user@example.com",
+					Status:          database.StatusSuspended,
+					StatusChangedAt: sql.NullTime{Time: time.Now(), Valid: true},
+					StatusReason:    "spam",
+				}, nil)
+				mockDB.On("ListUserRoles", mock.Anything, userID).Return([]database.ListUserRolesRow{{Name: "admin"}}, nil)
+				expectAudit(mockDB, adminID, auditGetUser, userID)
+			},
+		},
+		{
+			name:   "not found",
+			userID: userID.String(),
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByID", mock.Anything, userID).Return(database.User{}, sql.ErrNoRows)
+			},
+			errorCode:   codes.NotFound,
+			errorReason: autherr.ReasonUserNotFound,
+		},
+		{
+			name:        "invalid user ID",
+			userID:      "not-a-uuid",
+			mockSetup:   func(mockDB *mocks.MockQueries) {},
+			errorCode:   codes.InvalidArgument,
+			errorReason: autherr.ReasonInvalidArgument,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB := new(mocks.MockQueries)
+			admin := NewServer(mockDB, "test-secret", "test@example.com", "email-secret").Admin()
+			tc.mockSetup(mockDB)
+
+			response, err := admin.GetUser(adminContext(adminID), &pb.GetUserRequest{UserId: tc.userID})
+
+			if tc.errorReason != "" {
+				assertReason(t, err, tc.errorCode, tc.errorReason)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, userID.String(), response.User.User.Id)
+				assert.Equal(t, []string{"admin"}, response.User.Roles)
+				assert.Equal(t, database.StatusSuspended, response.User.Status)
+				assert.Equal(t, "spam", response.User.StatusReason)
+				assert.NotNil(t, response.User.StatusChangedAt)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAdminUpdateUser(t *testing.T) {
+	adminID := uuid.New()
+	userID := uuid.New()
+
+	testCases := []struct {
+		name        string
+		request     *pb.UpdateUserRequest
+		mockSetup   func(*mocks.MockQueries)
+		errorCode   codes.Code
+		errorReason autherr.Reason
+	}{
+		{
+			name:    "updates the fields that are set",
+			request: &pb.UpdateUserRequest{UserId: userID.String(), Email: proto.String(" new@Example.COM "), IsPremium: proto.Bool(true)},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("UpdateUser", mock.Anything, database.UpdateUserParams{
+					ID:             userID,
+					Email:          sql.NullString{String: "new@example.com", Valid: true},
+					EmailCanonical: sql.NullString{String: "new@example.com", Valid: true},
+					IsPremium:      sql.NullBool{Bool: true, Valid: true},
+				}).Return(database.User{ID: userID, Email: "new@example.com", IsPremium: true}, nil)
+				expectAudit(mockDB, adminID, auditUpdateUser, userID)
+			},
+		},
+		{
+			name: "verifies the new address",
+			request: &pb.UpdateUserRequest{UserId: userID.String(), Email: proto.String("new@example.com"),
+				IsPremium: proto.Bool(true), IsVerified: proto.Bool(true)},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("UpdateUser", mock.Anything, database.UpdateUserParams{
+					ID:             userID,
+					Email:          sql.NullString{String: "new@example.com", Valid: true},
+					EmailCanonical: sql.NullString{String: "new@example.com", Valid: true},
+					IsPremium:      sql.NullBool{Bool: true, Valid: true},
+				}).Return(database.User{ID: userID, Email: "new@example.com", IsPremium: true,
+					EmailCanonical: sql.NullString{String: "new@example.com", Valid: true}}, nil)
+				mockDB.On("VerifyUser", mock.Anything, "new@example.com").Return(userID, nil)
+				mockDB.On("CreateOutboxEvent", mock.Anything, mock.MatchedBy(func(arg database.CreateOutboxEventParams) bool {
+					return arg.EventType == outbox.UserVerified && arg.UserID == userID
+				})).Return(nil)
+				mockDB.On("CreateWebhookDeliveries", mock.Anything, mock.Anything).Return(nil)
+				expectAudit(mockDB, adminID, auditUpdateUser, userID)
+			},
+		},
+		{
+			name:    "email taken",
+			request: &pb.UpdateUserRequest{UserId: userID.String(), Email: proto.String("taken@example.com")},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("UpdateUser", mock.Anything, mock.Anything).Return(database.User{},
+					&pq.Error{Code: "23505", Constraint: "users_email_key"})
+			},
+			errorCode:   codes.AlreadyExists,
+			errorReason: autherr.ReasonEmailTaken,
+		},
+		{
+			name:    "email taken in another case",
+			request: &pb.UpdateUserRequest{UserId: userID.String(), Email: proto.String("Taken@example.com")},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("UpdateUser", mock.Anything, mock.Anything).Return(database.User{},
+					&pq.Error{Code: "23505", Constraint: "users_email_canonical_key"})
+			},
+			errorCode:   codes.AlreadyExists,
+			errorReason: autherr.ReasonEmailTaken,
+		},
+		{
+			name:        "invalid email",
+			request:     &pb.UpdateUserRequest{UserId: userID.String(), Email: proto.String("Alice <alice@example.com>")},
+			mockSetup:   func(mockDB *mocks.MockQueries) {},
+			errorCode:   codes.InvalidArgument,
+			errorReason: autherr.ReasonInvalidArgument,
+		},
+		{
+			name:    "not found",
+			request: &pb.UpdateUserRequest{UserId: userID.String(), IsVerified: proto.Bool(true)},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("UpdateUser", mock.Anything, mock.Anything).Return(database.User{}, sql.ErrNoRows)
+			},
+			errorCode:   codes.NotFound,
+			errorReason: autherr.ReasonUserNotFound,
+		},
+		{
+			name:        "invalid fields",
+			request:     &pb.UpdateUserRequest{UserId: userID.String(), Email: proto.String(""), Username: proto.String("abc")},
+			mockSetup:   func(mockDB *mocks.MockQueries) {},
+			errorCode:   codes.InvalidArgument,
+			errorReason: autherr.ReasonInvalidArgument,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB := new(mocks.MockQueries)
+			admin := NewServer(mockDB, "test-secret", "test@example.com", "email-secret").Admin()
+			tc.mockSetup(mockDB)
+
+			response, err := admin.UpdateUser(adminContext(adminID), tc.request)
+
+			if tc.errorReason != "" {
+				assertReason(t, err, tc.errorCode, tc.errorReason)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, "new@example.com", response.User.User.Email)
+				assert.True(t, response.User.User.IsPremium)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAdminSuspendUser(t *testing.T) {
+	adminID := uuid.New()
+	userID := uuid.New()
+	until := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+
+	testCases := []struct {
+		name        string
+		request     *pb.SuspendUserRequest
+		mockSetup   func(*mocks.MockQueries)
+		errorCode   codes.Code
+		errorReason autherr.Reason
+	}{
+		{
+			name:    "until lifted",
+			request: &pb.SuspendUserRequest{UserId: userID.String(), Reason: "spam"},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByID", mock.Anything, userID).Return(database.User{ID: userID, Status: database.StatusActive}, nil)
+				mockDB.On("SetUserStatus", mock.Anything, database.SetUserStatusParams{
+					ID:           userID,
+					Status:       database.StatusSuspended,
+					StatusReason: "spam",
+				}).Return(database.User{ID: userID, Status: database.StatusSuspended, StatusReason: "spam"}, nil)
+				mockDB.On("DeleteTokenByUserID", mock.Anything, userID).Return(nil)
+				mockDB.On("DeleteUserDeviceTokens", mock.Anything, userID).Return(nil)
+				expectLoggedOutEverywhere(t, mockDB, userID)
+				expectAudit(mockDB, adminID, auditSuspendUser, userID)
+			},
+		},
+		{
+			name:    "for a day",
+			request: &pb.SuspendUserRequest{UserId: userID.String(), Reason: "spam", Until: timestamppb.New(until)},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByID", mock.Anything, userID).Return(database.User{ID: userID, Status: database.StatusActive}, nil)
+				mockDB.On("SetUserStatus", mock.Anything, database.SetUserStatusParams{
+					ID:           userID,
+					Status:       database.StatusSuspended,
+					StatusReason: "spam",
+					StatusUntil:  sql.NullTime{Time: until, Valid: true},
+				}).Return(database.User{
+					ID:          userID,
+					Status:      database.StatusSuspended,
+					StatusUntil: sql.NullTime{Time: until, Valid: true},
+				}, nil)
+				mockDB.On("DeleteTokenByUserID", mock.Anything, userID).Return(nil)
+				mockDB.On("DeleteUserDeviceTokens", mock.Anything, userID).Return(nil)
+				expectLoggedOutEverywhere(t, mockDB, userID)
+				expectAudit(mockDB, adminID, auditSuspendUser, userID)
+			},
+		},
+		{
+			name:    "deleted account",
+			request: &pb.SuspendUserRequest{UserId: userID.String(), Reason: "spam"},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByID", mock.Anything, userID).Return(database.User{ID: userID, Status: database.StatusDeleted}, nil)
+			},
+			errorCode:   codes.PermissionDenied,
+			errorReason: autherr.ReasonAccountDeleted,
+		},
+		{
+			name:        "missing reason",
+			request:     &pb.SuspendUserRequest{UserId: userID.String()},
+			mockSetup:   func(mockDB *mocks.MockQueries) {},
+			errorCode:   codes.InvalidArgument,
+			errorReason: autherr.ReasonInvalidArgument,
+		},
+		{
+			name:        "until in the past",
+			request:     &pb.SuspendUserRequest{UserId: userID.String(), Reason: "spam", Until: timestamppb.New(time.Now().Add(-time.Hour))},
+			mockSetup:   func(mockDB *mocks.MockQueries) {},
+			errorCode:   codes.InvalidArgument,
+			errorReason: autherr.ReasonInvalidArgument,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB := new(mocks.MockQueries)
+			admin := NewServer(mockDB, "test-secret", "test@example.com", "email-secret").Admin()
+			require.NoError(t, redis.SaveRefreshToken(userID.String(), "refresh-token", time.Hour))
+			tc.mockSetup(mockDB)
+
+			response, err := admin.SuspendUser(adminContext(adminID), tc.request)
+
+			if tc.errorReason != "" {
+				assertReason(t, err, tc.errorCode, tc.errorReason)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, database.StatusSuspended, response.User.Status)
+				assert.Equal(t, tc.request.Until.AsTime().Equal(until), response.User.StatusUntil != nil)
+
+				_, err = redis.GetRefreshToken(userID.String())
+				assert.ErrorIs(t, err, redis.Nil)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAdminBanUser(t *testing.T) {
+	adminID := uuid.New()
+	userID := uuid.New()
+	mockDB := new(mocks.MockQueries)
+	admin := NewServer(mockDB, "test-secret", "test@example.com", "email-secret").Admin()
+
+	require.NoError(t, redis.SaveAccessToken(userID.String(), "access-token", time.Hour))
+
+	mockDB.On("GetUserByID", mock.Anything, userID).Return(database.User{ID: userID, Status: database.StatusSuspended}, nil)
+	mockDB.On("SetUserStatus", mock.Anything, database.SetUserStatusParams{
+		ID:           userID,
+		Status:       database.StatusBanned,
+		StatusReason: "fraud",
+	}).Return(database.User{ID: userID, Status: database.StatusBanned, StatusReason: "fraud"}, nil)
+	mockDB.On("DeleteTokenByUserID", mock.Anything, userID).Return(nil)
+	mockDB.On("DeleteUserDeviceTokens", mock.Anything, userID).Return(nil)
+	expectLoggedOutEverywhere(t, mockDB, userID)
+	details := expectAudit(mockDB, adminID, auditBanUser, userID)
+
+	response, err := admin.BanUser(adminContext(adminID), &pb.BanUserRequest{UserId: userID.String(), Reason: "fraud"})
+	require.NoError(t, err)
+	assert.Equal(t, database.StatusBanned, response.User.Status)
+	assert.Equal(t, "fraud", (*details)["reason"])
+
+	_, err = redis.GetAccessToken(userID.String())
+	assert.ErrorIs(t, err, redis.Nil)
+
+	_, err = admin.BanUser(adminContext(adminID), &pb.BanUserRequest{UserId: userID.String()})
+	assertReason(t, err, codes.InvalidArgument, autherr.ReasonInvalidArgument)
+	mockDB.AssertExpectations(t)
+}
+
+func TestAdminUnsuspendUser(t *testing.T) {
+	adminID := uuid.New()
+	userID := uuid.New()
+	mockDB := new(mocks.MockQueries)
+	admin := NewServer(mockDB, "test-secret", "test@example.com", "email-secret").Admin()
+
+	mockDB.On("GetUserByID", mock.Anything, userID).Return(database.User{ID: userID, Status: database.StatusBanned}, nil)
+	mockDB.On("SetUserStatus", mock.Anything, database.SetUserStatusParams{
+		ID:     userID,
+		Status: database.StatusActive,
+	}).Return(database.User{ID: userID, Status: database.StatusActive}, nil)
+	details := expectAudit(mockDB, adminID, auditUnsuspendUser, userID)
+
+	response, err := admin.UnsuspendUser(adminContext(adminID), &pb.UnsuspendUserRequest{UserId: userID.String()})
+	require.NoError(t, err)
+	assert.Equal(t, database.StatusActive, response.User.Status)
+	assert.Equal(t, database.StatusBanned, (*details)["previous_status"])
+
+	missingID := uuid.New()
+	mockDB.On("GetUserByID", mock.Anything, missingID).Return(database.User{}, sql.ErrNoRows)
+	_, err = admin.UnsuspendUser(adminContext(adminID), &pb.UnsuspendUserRequest{UserId: missingID.String()})
+	assertReason(t, err, codes.NotFound, autherr.ReasonUserNotFound)
+
+	deletedID := uuid.New()
+	mockDB.On("GetUserByID", mock.Anything, deletedID).Return(database.User{ID: deletedID, Status: database.StatusDeleted}, nil)
+	_, err = admin.UnsuspendUser(adminContext(adminID), &pb.UnsuspendUserRequest{UserId: deletedID.String()})
+	assertReason(t, err, codes.PermissionDenied, autherr.ReasonAccountDeleted)
+	mockDB.AssertExpectations(t)
+}
+
+func TestAdminForceLogout(t *testing.T) {
+	adminID := uuid.New()
+	userID := uuid.New()
+	mockDB := new(mocks.MockQueries)
+	admin := NewServer(mockDB, "test-secret", "test@example.com", "email-secret").Admin()
+
+	require.NoError(t, redis.SaveAccessToken(userID.String(), "access-token", time.Hour))
+
+	mockDB.On("GetUserByID", mock.Anything, userID).Return(database.User{ID: userID}, nil)
+	mockDB.On("DeleteTokenByUserID", mock.Anything, userID).Return(nil)
+	mockDB.On("DeleteUserDeviceTokens", mock.Anything, userID).Return(nil)
+	expectLoggedOutEverywhere(t, mockDB, userID)
+	expectAudit(mockDB, adminID, auditForceLogout, userID)
+
+	response, err := admin.ForceLogout(adminContext(adminID), &pb.ForceLogoutRequest{UserId: userID.String()})
+	require.NoError(t, err)
+	assert.True(t, response.Success)
+
+	_, err = redis.GetAccessToken(userID.String())
+	assert.ErrorIs(t, err, redis.Nil)
+	mockDB.AssertExpectations(t)
+}
+
+func TestAdminForceVerifyEmail(t *testing.T) {
+	adminID := uuid.New()
+	userID := uuid.New()
+	mockDB := new(mocks.MockQueries)
+	admin := NewServer(mockDB, "test-secret", "test@example.com", "email-secret").Admin()
+
+	admin.cacheVerificationCode("user@example.com", 123456)
+
+	mockDB.On("GetUserByID", mock.Anything, userID).Return(database.User{
+		ID:             userID,
+		Email:          "user@example.com",
+		EmailCanonical: sql.NullString{String: "user@example.com", Valid: true},
+	}, nil)
+	mockDB.On("VerifyUser", mock.Anything, "user@example.com").Return(userID, nil)
+	var event eventsv1.UserVerified
+	mockDB.On("CreateOutboxEvent", mock.Anything, outboxEvent(t, outbox.UserVerified, userID, &event)).Return(nil)
+	mockDB.On("CreateWebhookDeliveries", mock.Anything, mock.Anything).Return(nil)
+	mockDB.On("DeleteVerificationCode", mock.Anything, database.DeleteVerificationCodeParams{
+		UserID:  userID,
+		Purpose: database.PurposeEmailVerify,
+	}).Return(nil)
+	expectAudit(mockDB, adminID, auditForceVerifyEmail, userID)
+
+	response, err := admin.ForceVerifyEmail(adminContext(adminID), &pb.ForceVerifyEmailRequest{UserId: userID.String()})
+	require.NoError(t, err)
+	assert.True(t, response.User.User.IsVerified)
+	assert.Equal(t, userID.String(), event.GetUserId())
+
+	_, err = redis.GetVerificationCode("user@example.com")
+	assert.ErrorIs(t, err, redis.Nil)
+	mockDB.AssertExpectations(t)
+}
+
+func TestAdminResendVerification(t *testing.T) {
+	adminID := uuid.New()
+	userID := uuid.New()
+	verifiedID := uuid.New()
+	mockDB := new(mocks.MockQueries)
+	admin := NewServer(mockDB, "test-secret", "test@example.com", "email-secret").Admin()
+
+	mockDB.On("GetUserByID", mock.Anything, userID).Return(database.User{ID: userID, Email: "resend@example.com"}, nil)
+	mockDB.On("UpsertVerificationCode", mock.Anything, mock.MatchedBy(func(arg database.UpsertVerificationCodeParams) bool {
+		return arg.UserID == userID && arg.Purpose == database.PurposeEmailVerify
+	})).Return(database.VerificationCode{}, nil)
+	expectAudit(mockDB, adminID, auditResendVerification, userID)
+
+	response, err := admin.ResendVerification(adminContext(adminID), &pb.ResendVerificationRequest{UserId: userID.String()})
+	require.NoError(t, err)
+	assert.True(t, response.Success)
+
+	code, err := redis.GetVerificationCode("resend@example.com")
+	require.NoError(t, err)
+	assert.NotEmpty(t, code)
+
+	mockDB.On("GetUserByID", mock.Anything, verifiedID).Return(database.User{ID: verifiedID, IsVerified: true}, nil)
+	_, err = admin.ResendVerification(adminContext(adminID), &pb.ResendVerificationRequest{UserId: verifiedID.String()})
+	assertReason(t, err, codes.FailedPrecondition, autherr.ReasonEmailAlreadyVerified)
+	mockDB.AssertExpectations(t)
+}
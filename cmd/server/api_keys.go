@@ -0,0 +1,213 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/cmd/helper"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	pb "github.com/imhasandl/auth-service/protos"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	maxAPIKeyNameLength = 64
+	maxAPIKeyScopes     = 20
+)
+
+// scopePattern matches scopes like "posts:read"
+var scopePattern = regexp.MustCompile(`^[A-Za-z0-9._:/-]{1,64}$`)
+
+// defaultAPIKeyScopes are the scopes every user may put on an API key. Other scopes need the user to hold the
+// permission of the same name, so a key never grants more than its owner has
+var defaultAPIKeyScopes = []string{
+	"posts:read", "posts:write",
+	"comments:read", "comments:write",
+	"messages:read", "messages:write",
+}
+
+// CreateAPIKey issues a personal API key for the logged in user. Only the hash is stored,
+// so the key is returned once and can't be shown again.
+func (s *Server) CreateAPIKey(ctx context.Context, req *pb.CreateAPIKeyRequest) (*pb.CreateAPIKeyResponse, error) {
+	userID, err := s.authenticatedUser(ctx)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	name := strings.TrimSpace(req.GetName())
+	var violations autherr.Violations
+	if name == "" {
+		violations.Add("name", "name is required")
+	} else if len(name) > maxAPIKeyNameLength {
+		violations.Add("name", "name is too long")
+	}
+	if len(req.GetScopes()) == 0 {
+		violations.Add("scopes", "at least one scope is required")
+	} else if len(req.GetScopes()) > maxAPIKeyScopes {
+		violations.Add("scopes", "too many scopes")
+	}
+	for _, scope := range req.GetScopes() {
+		if !scopePattern.MatchString(scope) {
+			violations.Add("scopes", "invalid scope "+scope)
+		}
+	}
+	var expiresAt sql.NullTime
+	if req.GetExpiresAt() != nil {
+		expiresAt = sql.NullTime{Time: req.GetExpiresAt().AsTime(), Valid: true}
+		if !expiresAt.Time.After(time.Now()) {
+			violations.Add("expires_at", "expiry must be in the future")
+		}
+	}
+	if err := violations.Err(); err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	if err := s.checkAPIKeyScopes(ctx, userID, req.GetScopes()); err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	key, displayPrefix, err := auth.MakeAPIKey()
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	apiKey, err := s.db.CreateAPIKey(ctx, database.CreateAPIKeyParams{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Name:      name,
+		KeyPrefix: displayPrefix,
+		KeyHash:   auth.HashAPIKey(key),
+		Scopes:    uniqueStrings(req.GetScopes()),
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		if database.IsUniqueViolation(err, "api_keys_user_id_name_key") {
+			return nil, helper.RespondWithError(ctx, autherr.APIKeyNameTaken().WithCause(err))
+		}
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	s.recordEvent(ctx, AuthEvent{
+		Type:    EventAPIKeyCreated,
+		UserID:  userID,
+		Details: map[string]string{"api_key_id": apiKey.ID.String(), "name": apiKey.Name},
+	})
+
+	return &pb.CreateAPIKeyResponse{
+		ApiKey: apiKeyResponse(apiKey),
+		Key:    key,
+	}, nil
+}
+
+// checkAPIKeyScopes fails for the first scope the user may not put on an API key: one that is neither in the API key
+// scope list nor a permission of the user. The permissions are only loaded when needed
+func (s *Server) checkAPIKeyScopes(ctx context.Context, userID uuid.UUID, scopes []string) error {
+	var permissions []string
+	loaded := false
+	for _, scope := range scopes {
+		if slices.Contains(s.apiKeyScopes, scope) {
+			continue
+		}
+		if !loaded {
+			authorization, err := s.db.GetUserAuthorization(ctx, userID)
+			if err != nil {
+				return err
+			}
+			permissions, loaded = authorization.Permissions, true
+		}
+		if !slices.Contains(permissions, scope) {
+			return autherr.PermissionDenied(scope)
+		}
+	}
+	return nil
+}
+
+// ListAPIKeys returns the API keys of the logged in user, without the keys themselves.
+func (s *Server) ListAPIKeys(ctx context.Context, req *pb.ListAPIKeysRequest) (*pb.ListAPIKeysResponse, error) {
+	userID, err := s.authenticatedUser(ctx)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	apiKeys, err := s.db.ListAPIKeys(ctx, userID)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	response := &pb.ListAPIKeysResponse{}
+	for _, apiKey := range apiKeys {
+		response.ApiKeys = append(response.ApiKeys, apiKeyResponse(apiKey))
+	}
+	return response, nil
+}
+
+// RevokeAPIKey deletes an API key of the logged in user. The key stops working right away.
+func (s *Server) RevokeAPIKey(ctx context.Context, req *pb.RevokeAPIKeyRequest) (*pb.RevokeAPIKeyResponse, error) {
+	userID, err := s.authenticatedUser(ctx)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	id, err := uuid.Parse(req.GetId())
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, autherr.InvalidArgument("id", "id should be a UUID"))
+	}
+
+	deleted, err := s.db.DeleteAPIKey(ctx, database.DeleteAPIKeyParams{
+		ID:     id,
+		UserID: userID,
+	})
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	if deleted == 0 {
+		return nil, helper.RespondWithError(ctx, autherr.APIKeyNotFound())
+	}
+
+	s.recordEvent(ctx, AuthEvent{
+		Type:    EventAPIKeyRevoked,
+		UserID:  userID,
+		Details: map[string]string{"api_key_id": id.String()},
+	})
+
+	return &pb.RevokeAPIKeyResponse{
+		Success: true,
+		Message: "API key revoked",
+	}, nil
+}
+
+func apiKeyResponse(apiKey database.ApiKey) *pb.APIKey {
+	response := &pb.APIKey{
+		Id:        apiKey.ID.String(),
+		Name:      apiKey.Name,
+		Prefix:    apiKey.KeyPrefix,
+		Scopes:    apiKey.Scopes,
+		CreatedAt: timestamppb.New(apiKey.CreatedAt),
+	}
+	if apiKey.ExpiresAt.Valid {
+		response.ExpiresAt = timestamppb.New(apiKey.ExpiresAt.Time)
+	}
+	if apiKey.LastUsedAt.Valid {
+		response.LastUsedAt = timestamppb.New(apiKey.LastUsedAt.Time)
+	}
+	return response
+}
+
+// uniqueStrings removes duplicates from values, keeping their order
+func uniqueStrings(values []string) []string {
+	unique := make([]string, 0, len(values))
+	seen := make(map[string]bool, len(values))
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
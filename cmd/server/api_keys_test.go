@@ -0,0 +1,347 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/database/mocks"
+	pb "github.com/imhasandl/auth-service/protos"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestCreateAPIKey(t *testing.T) {
+	userID := uuid.New()
+	expiresAt := time.Now().Add(24 * time.Hour).UTC()
+	var created database.CreateAPIKeyParams
+
+	testCases := []struct {
+		name        string
+		request     *pb.CreateAPIKeyRequest
+		mockSetup   func(*mocks.MockQueries)
+		errorCode   codes.Code
+		errorReason autherr.Reason
+	}{
+		{
+			name: "created",
+			request: &pb.CreateAPIKeyRequest{
+				Name:      " ci ",
+				Scopes:    []string{"posts:read", "posts:read", "posts:write"},
+				ExpiresAt: timestamppb.New(expiresAt),
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("CreateAPIKey", mock.Anything, mock.MatchedBy(func(arg database.CreateAPIKeyParams) bool {
+					created = arg
+					return arg.UserID == userID && arg.Name == "ci" &&
+						assert.ObjectsAreEqual([]string{"posts:read", "posts:write"}, arg.Scopes) &&
+						arg.ExpiresAt.Valid && arg.ExpiresAt.Time.Equal(expiresAt) &&
+						len(arg.KeyHash) == 64
+				})).Return(database.ApiKey{
+					ID:        uuid.New(),
+					UserID:    userID,
+					Name:      "ci",
+					KeyPrefix: "mak_abcdefgh",
+					Scopes:    []string{"posts:read", "posts:write"},
+					ExpiresAt: sql.NullTime{Time: expiresAt, Valid: true},
+					CreatedAt: time.Now(),
+				}, nil)
+			},
+		},
+		{
+			name:        "invalid fields",
+			request:     &pb.CreateAPIKeyRequest{Scopes: []string{"posts read"}, ExpiresAt: timestamppb.New(time.Now().Add(-time.Hour))},
+			mockSetup:   func(mockDB *mocks.MockQueries) {},
+			errorCode:   codes.InvalidArgument,
+			errorReason: autherr.ReasonInvalidArgument,
+		},
+		{
+			name:    "scope the user doesn't hold",
+			request: &pb.CreateAPIKeyRequest{Name: "ci", Scopes: []string{"posts:read", "users:write"}},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserAuthorization", mock.Anything, userID).
+					Return(database.GetUserAuthorizationRow{Permissions: []string{"users:read"}}, nil)
+			},
+			errorCode:   codes.PermissionDenied,
+			errorReason: autherr.ReasonPermissionDenied,
+		},
+		{
+			name:    "scope the user holds",
+			request: &pb.CreateAPIKeyRequest{Name: "ci", Scopes: []string{"users:read"}},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserAuthorization", mock.Anything, userID).
+					Return(database.GetUserAuthorizationRow{Permissions: []string{"users:read"}}, nil)
+				mockDB.On("CreateAPIKey", mock.Anything, mock.Anything).
+					Return(database.ApiKey{}, &pq.Error{Code: "23505", Constraint: "api_keys_user_id_name_key"})
+			},
+			errorCode:   codes.AlreadyExists,
+			errorReason: autherr.ReasonAPIKeyNameTaken,
+		},
+		{
+			name:    "name taken",
+			request: &pb.CreateAPIKeyRequest{Name: "ci", Scopes: []string{"posts:read"}},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("CreateAPIKey", mock.Anything, mock.Anything).
+					Return(database.ApiKey{}, &pq.Error{Code: "23505", Constraint: "api_keys_user_id_name_key"})
+			},
+			errorCode:   codes.AlreadyExists,
+			errorReason: autherr.ReasonAPIKeyNameTaken,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB := activeUsers(new(mocks.MockQueries))
+			server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+
+			tc.mockSetup(mockDB)
+
+			response, err := server.CreateAPIKey(withAccessToken(t, userID), tc.request)
+
+			if tc.errorReason != "" {
+				assertReason(t, err, tc.errorCode, tc.errorReason)
+				assert.Nil(t, response)
+			} else {
+				require.NoError(t, err)
+				assert.True(t, auth.IsAPIKey(response.Key))
+				assert.Equal(t, "ci", response.ApiKey.Name)
+				assert.Equal(t, auth.HashAPIKey(response.Key), created.KeyHash)
+				assert.Equal(t, response.Key[:len(created.KeyPrefix)], created.KeyPrefix)
+				assert.Equal(t, []string{"posts:read", "posts:write"}, response.ApiKey.Scopes)
+				assert.Nil(t, response.ApiKey.LastUsedAt)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCreateAPIKeyUnauthenticated(t *testing.T) {
+	mockDB := activeUsers(new(mocks.MockQueries))
+	server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+
+	_, err := server.CreateAPIKey(context.Background(), &pb.CreateAPIKeyRequest{Name: "ci", Scopes: []string{"posts:read"}})
+	assertReason(t, err, codes.Unauthenticated, autherr.ReasonAccessTokenInvalid)
+	mockDB.AssertExpectations(t)
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	userID := uuid.New()
+	keyID := uuid.New()
+
+	testCases := []struct {
+		name        string
+		id          string
+		mockSetup   func(*mocks.MockQueries)
+		errorCode   codes.Code
+		errorReason autherr.Reason
+	}{
+		{
+			name: "revoked",
+			id:   keyID.String(),
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("DeleteAPIKey", mock.Anything, database.DeleteAPIKeyParams{ID: keyID, UserID: userID}).Return(int64(1), nil)
+			},
+		},
+		{
+			name: "someone else's key",
+			id:   keyID.String(),
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("DeleteAPIKey", mock.Anything, database.DeleteAPIKeyParams{ID: keyID, UserID: userID}).Return(int64(0), nil)
+			},
+			errorCode:   codes.NotFound,
+			errorReason: autherr.ReasonAPIKeyNotFound,
+		},
+		{
+			name:        "invalid id",
+			id:          "not-a-uuid",
+			mockSetup:   func(mockDB *mocks.MockQueries) {},
+			errorCode:   codes.InvalidArgument,
+			errorReason: autherr.ReasonInvalidArgument,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB := activeUsers(new(mocks.MockQueries))
+			server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+
+			tc.mockSetup(mockDB)
+
+			response, err := server.RevokeAPIKey(withAccessToken(t, userID), &pb.RevokeAPIKeyRequest{Id: tc.id})
+
+			if tc.errorReason != "" {
+				assertReason(t, err, tc.errorCode, tc.errorReason)
+			} else {
+				require.NoError(t, err)
+				assert.True(t, response.Success)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestListAPIKeys(t *testing.T) {
+	userID := uuid.New()
+	lastUsed := time.Now().Add(-time.Hour)
+	mockDB := activeUsers(new(mocks.MockQueries))
+	server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+
+	mockDB.On("ListAPIKeys", mock.Anything, userID).Return([]database.ApiKey{
+		{ID: uuid.New(), UserID: userID, Name: "ci", KeyPrefix: "mak_abcdefgh", Scopes: []string{"posts:read"},
+			LastUsedAt: sql.NullTime{Time: lastUsed, Valid: true}},
+		{ID: uuid.New(), UserID: userID, Name: "backup", KeyPrefix: "mak_ijklmnop", Scopes: []string{"posts:read"}},
+	}, nil)
+
+	response, err := server.ListAPIKeys(withAccessToken(t, userID), &pb.ListAPIKeysRequest{})
+	require.NoError(t, err)
+	require.Len(t, response.ApiKeys, 2)
+	assert.Equal(t, "mak_abcdefgh", response.ApiKeys[0].Prefix)
+	assert.Equal(t, lastUsed.Unix(), response.ApiKeys[0].LastUsedAt.AsTime().Unix())
+	assert.Nil(t, response.ApiKeys[1].LastUsedAt)
+	assert.Nil(t, response.ApiKeys[1].ExpiresAt)
+	mockDB.AssertExpectations(t)
+}
+
+func TestIntrospectToken(t *testing.T) {
+	const secret = "test-secret"
+	userID := uuid.New()
+	keyID := uuid.New()
+
+	accessToken, err := auth.MakeAccessToken(userID, auth.AccessGrants{Roles: []string{"admin"}, Permissions: []string{"roles:read"}}, secret, time.Hour)
+	require.NoError(t, err)
+	expiredToken, err := auth.MakeJWT(userID, secret, -time.Hour)
+	require.NoError(t, err)
+	serviceToken, err := auth.MakeServiceToken("post-service", []string{"users:read"}, []string{"auth-service"}, secret, time.Hour)
+	require.NoError(t, err)
+	apiKey, _, err := auth.MakeAPIKey()
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name      string
+		request   *pb.IntrospectTokenRequest
+		mockSetup func(*mocks.MockQueries)
+		expected  *pb.IntrospectTokenResponse
+	}{
+		{
+			name:    "access token",
+			request: &pb.IntrospectTokenRequest{Token: accessToken},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserStatus", mock.Anything, userID).Return(database.GetUserStatusRow{Status: database.StatusActive}, nil)
+			},
+			expected: &pb.IntrospectTokenResponse{Active: true, TokenType: "access", Subject: userID.String(),
+				Scopes: []string{"roles:read"}, Roles: []string{"admin"}},
+		},
+		{
+			name:    "access token of a suspended user",
+			request: &pb.IntrospectTokenRequest{Token: accessToken},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserStatus", mock.Anything, userID).Return(database.GetUserStatusRow{Status: database.StatusSuspended}, nil)
+			},
+			expected: &pb.IntrospectTokenResponse{},
+		},
+		{
+			name:      "expired access token",
+			request:   &pb.IntrospectTokenRequest{Token: expiredToken},
+			mockSetup: func(mockDB *mocks.MockQueries) {},
+			expected:  &pb.IntrospectTokenResponse{},
+		},
+		{
+			name:      "service token",
+			request:   &pb.IntrospectTokenRequest{Token: serviceToken, Audience: "auth-service"},
+			mockSetup: func(mockDB *mocks.MockQueries) {},
+			expected: &pb.IntrospectTokenResponse{Active: true, TokenType: "service", Subject: "post-service",
+				Scopes: []string{"users:read"}, Audiences: []string{"auth-service"}},
+		},
+		{
+			name:      "service token for another audience",
+			request:   &pb.IntrospectTokenRequest{Token: serviceToken, Audience: "billing"},
+			mockSetup: func(mockDB *mocks.MockQueries) {},
+			expected:  &pb.IntrospectTokenResponse{},
+		},
+		{
+			name:    "API key",
+			request: &pb.IntrospectTokenRequest{Token: apiKey},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetAPIKeyByHash", mock.Anything, auth.HashAPIKey(apiKey)).Return(database.ApiKey{
+					ID: keyID, UserID: userID, Scopes: []string{"posts:read"},
+				}, nil)
+				mockDB.On("GetUserStatus", mock.Anything, userID).Return(database.GetUserStatusRow{Status: database.StatusActive}, nil)
+				mockDB.On("TouchAPIKey", mock.Anything, keyID).Return(nil)
+			},
+			expected: &pb.IntrospectTokenResponse{Active: true, TokenType: "api_key", Subject: userID.String(),
+				Scopes: []string{"posts:read"}, ApiKeyId: keyID.String()},
+		},
+		{
+			name:    "API key of a banned user",
+			request: &pb.IntrospectTokenRequest{Token: apiKey},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetAPIKeyByHash", mock.Anything, auth.HashAPIKey(apiKey)).Return(database.ApiKey{
+					ID: keyID, UserID: userID, Scopes: []string{"posts:read"},
+				}, nil)
+				mockDB.On("GetUserStatus", mock.Anything, userID).Return(database.GetUserStatusRow{Status: database.StatusBanned}, nil)
+			},
+			expected: &pb.IntrospectTokenResponse{},
+		},
+		{
+			name:    "expired API key",
+			request: &pb.IntrospectTokenRequest{Token: apiKey},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetAPIKeyByHash", mock.Anything, auth.HashAPIKey(apiKey)).Return(database.ApiKey{
+					ID: keyID, UserID: userID, ExpiresAt: sql.NullTime{Time: time.Now().Add(-time.Minute), Valid: true},
+				}, nil)
+			},
+			expected: &pb.IntrospectTokenResponse{},
+		},
+		{
+			name:    "revoked API key",
+			request: &pb.IntrospectTokenRequest{Token: apiKey},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetAPIKeyByHash", mock.Anything, auth.HashAPIKey(apiKey)).Return(database.ApiKey{}, sql.ErrNoRows)
+			},
+			expected: &pb.IntrospectTokenResponse{},
+		},
+		{
+			name:      "garbage",
+			request:   &pb.IntrospectTokenRequest{Token: "not-a-token"},
+			mockSetup: func(mockDB *mocks.MockQueries) {},
+			expected:  &pb.IntrospectTokenResponse{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB := new(mocks.MockQueries)
+			server := NewServer(mockDB, secret, "test@example.com", "email-secret")
+
+			tc.mockSetup(mockDB)
+
+			response, err := server.IntrospectToken(context.Background(), tc.request)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected.Active, response.Active)
+			assert.Equal(t, tc.expected.TokenType, response.TokenType)
+			assert.Equal(t, tc.expected.Subject, response.Subject)
+			assert.Equal(t, tc.expected.Scopes, response.Scopes)
+			assert.Equal(t, tc.expected.Audiences, response.Audiences)
+			assert.Equal(t, tc.expected.Roles, response.Roles)
+			assert.Equal(t, tc.expected.ApiKeyId, response.ApiKeyId)
+			assert.Equal(t, tc.expected.Active && tc.expected.TokenType != "api_key", response.ExpiresAt != nil)
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestIntrospectTokenRequiresToken(t *testing.T) {
+	server := NewServer(new(mocks.MockQueries), "test-secret", "test@example.com", "email-secret")
+
+	_, err := server.IntrospectToken(context.Background(), &pb.IntrospectTokenRequest{})
+	assertReason(t, err, codes.InvalidArgument, autherr.ReasonInvalidArgument)
+}
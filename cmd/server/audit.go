@@ -0,0 +1,207 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// Types of the events in the security log
+const (
+	EventRegistered               = "register"
+	EventLoginSucceeded           = "login_succeeded"
+	EventLoginFailed              = "login_failed"
+	EventTokenRefreshed           = "token_refreshed"
+	EventRefreshRejected          = "refresh_rejected"
+	EventRefreshTokenReused       = "refresh_token_reused"
+	EventLogout                   = "logout"
+	EventNewDevice                = "new_device"
+	EventSignInReported           = "sign_in_reported"
+	EventPasswordReset            = "password_reset"
+	EventEmailChangeRequested     = "email_change_requested"
+	EventEmailChanged             = "email_changed"
+	EventEmailChangeUndone        = "email_change_undone"
+	EventUsernameChanged          = "username_changed"
+	EventAccountDeletionRequested = "account_deletion_requested"
+	EventAccountDeletionCancelled = "account_deletion_cancelled"
+	EventAccountDeleted           = "account_deleted"
+	EventDataExportRequested      = "data_export_requested"
+	EventDataExportDownloaded     = "data_export_downloaded"
+	EventDeviceApproved           = "device_approved"
+	EventAPIKeyCreated            = "api_key_created"
+	EventAPIKeyRevoked            = "api_key_revoked"
+	EventRoleAssigned             = "role_assigned"
+	EventRoleRevoked              = "role_revoked"
+	EventAdminAction              = "admin_action"
+)
+
+// Ways of logging in, recorded as the method of login events. External logins are recorded as
+// "external:<provider>"
+const (
+	loginMethodPassword  = "password"
+	loginMethodLoginLink = "login_link"
+	loginMethodLoginCode = "login_code"
+	loginMethodDevice    = "device"
+	loginMethodOIDC      = "oidc"
+)
+
+// AuthEvent is a security relevant event in the life of an account
+type AuthEvent struct {
+	Type string
+	// UserID is uuid.Nil when no account is involved, like a failed login with an unknown identifier
+	UserID uuid.UUID
+	// Actor is set when someone other than the user caused the event, like an admin
+	Actor     string
+	IPAddress string
+	UserAgent string
+	// SessionID identifies the refresh token the event is about, see auth.SessionID
+	SessionID string
+	Details   map[string]string
+}
+
+// AuditSink stores auth events
+type AuditSink interface {
+	Record(ctx context.Context, event AuthEvent) error
+}
+
+type nopAuditSink struct{}
+
+func (nopAuditSink) Record(context.Context, AuthEvent) error {
+	return nil
+}
+
+// DatabaseAuditSink stores auth events in the append-only auth_events table, which ListSecurityEvents reads
+type DatabaseAuditSink struct {
+	db        DBQuerier
+	retention time.Duration
+}
+
+// NewDatabaseAuditSink creates a sink that keeps events for retention. Zero keeps them forever
+func NewDatabaseAuditSink(db DBQuerier, retention time.Duration) *DatabaseAuditSink {
+	return &DatabaseAuditSink{db: db, retention: retention}
+}
+
+// Record stores event
+func (d *DatabaseAuditSink) Record(ctx context.Context, event AuthEvent) error {
+	details := event.Details
+	if details == nil {
+		details = map[string]string{}
+	}
+	encoded, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	return d.db.CreateAuthEvent(ctx, database.CreateAuthEventParams{
+		UserID:    uuid.NullUUID{UUID: event.UserID, Valid: event.UserID != uuid.Nil},
+		Type:      event.Type,
+		Actor:     event.Actor,
+		IpAddress: event.IPAddress,
+		UserAgent: event.UserAgent,
+		SessionID: event.SessionID,
+		Details:   encoded,
+	})
+}
+
+// Purge deletes the events that are older than the retention period. main runs it periodically
+func (d *DatabaseAuditSink) Purge(ctx context.Context) error {
+	if d.retention <= 0 {
+		return nil
+	}
+	purged, err := d.db.DeleteAuthEventsBefore(ctx, time.Now().Add(-d.retention))
+	if err != nil {
+		return err
+	}
+	if purged > 0 {
+		log.Printf("Purged %d auth events", purged)
+	}
+	return nil
+}
+
+// recordEvent adds the address and user agent of the client to event and records it. The request it is about
+// already happened, so failing to record it is only logged
+func (s *Server) recordEvent(ctx context.Context, event AuthEvent) {
+	event.IPAddress, event.UserAgent = s.clientInfo(ctx)
+	if err := s.auditSink.Record(ctx, event); err != nil {
+		log.Printf("Error recording %s event: %v", event.Type, err)
+	}
+}
+
+// recordLogin records that the user logged in and started the session of refreshToken
+func (s *Server) recordLogin(ctx context.Context, userID uuid.UUID, method, refreshToken string) {
+	s.recordEvent(ctx, AuthEvent{
+		Type:      EventLoginSucceeded,
+		UserID:    userID,
+		SessionID: auth.SessionID(refreshToken),
+		Details:   map[string]string{"method": method},
+	})
+}
+
+// recordLoginFailure records a login that failed with err. The identifier the client sent is only kept when it
+// didn't match an account, so attempts against unknown accounts can still be told apart. Internal errors are
+// not the client's fault and aren't recorded
+func (s *Server) recordLoginFailure(ctx context.Context, userID uuid.UUID, identifier, method string, err error) {
+	if event, ok := loginFailureEvent(userID, identifier, method, err); ok {
+		s.recordEvent(ctx, event)
+	}
+}
+
+// loginFailureEvent builds the event recordLoginFailure records. ok is false for internal errors
+func loginFailureEvent(userID uuid.UUID, identifier, method string, err error) (event AuthEvent, ok bool) {
+	authErr := domainError(err)
+	if authErr == nil {
+		return AuthEvent{}, false
+	}
+
+	details := map[string]string{"method": method, "reason": string(authErr.Reason)}
+	if userID == uuid.Nil && identifier != "" {
+		details["identifier"] = identifier
+	}
+	return AuthEvent{Type: EventLoginFailed, UserID: userID, Details: details}, true
+}
+
+// domainError returns the domain error err wraps, or nil for unexpected errors
+func domainError(err error) *autherr.Error {
+	var authErr *autherr.Error
+	if !errors.As(err, &authErr) || authErr.Code == codes.Internal {
+		return nil
+	}
+	return authErr
+}
+
+// clientInfo returns the address and user agent of the client that sent the request. The address comes from
+// the connection, or from the x-forwarded-for metadata when the server runs behind a trusted proxy
+func (s *Server) clientInfo(ctx context.Context) (ip, userAgent string) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get("user-agent"); len(values) > 0 {
+		userAgent = values[0]
+	}
+
+	if s.trustForwardedFor {
+		if values := md.Get("x-forwarded-for"); len(values) > 0 {
+			first, _, _ := strings.Cut(values[0], ",")
+			if first = strings.TrimSpace(first); first != "" {
+				return first, userAgent
+			}
+		}
+	}
+
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		ip = p.Addr.String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+	}
+	return ip, userAgent
+}
@@ -0,0 +1,299 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/database/mocks"
+	pb "github.com/imhasandl/auth-service/protos"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// recordingSink keeps the recorded events in memory
+type recordingSink struct {
+	events []AuthEvent
+}
+
+func (r *recordingSink) Record(_ context.Context, event AuthEvent) error {
+	r.events = append(r.events, event)
+	return nil
+}
+
+func TestLoginRecordsEvents(t *testing.T) {
+	userID := uuid.New()
+	hashedPassword, err := auth.HashPassword("password123")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name          string
+		identifier    string
+		password      string
+		mockSetup     func(*mocks.MockQueries)
+		expectedEvent AuthEvent
+	}{
+		{
+			name:       "success",
+			identifier: "testuser",
+			password:   "password123",
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).
+					Return(database.User{ID: userID, Password: hashedPassword}, nil)
+				mockDB.On("GetUserAuthorization", mock.Anything, userID).Return(database.GetUserAuthorizationRow{}, nil)
+				mockDB.On("RefreshToken", mock.Anything, mock.Anything).Return(database.RefreshToken{}, nil)
+			},
+			expectedEvent: AuthEvent{
+				Type:    EventLoginSucceeded,
+				UserID:  userID,
+				Details: map[string]string{"method": loginMethodPassword},
+			},
+		},
+		{
+			name:       "wrong password",
+			identifier: "testuser",
+			password:   "wrong",
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).
+					Return(database.User{ID: userID, Password: hashedPassword}, nil)
+			},
+			expectedEvent: AuthEvent{
+				Type:    EventLoginFailed,
+				UserID:  userID,
+				Details: map[string]string{"method": loginMethodPassword, "reason": string(autherr.ReasonInvalidCredentials)},
+			},
+		},
+		{
+			name:       "unknown identifier",
+			identifier: "nobody",
+			password:   "password123",
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(database.User{}, sql.ErrNoRows)
+			},
+			expectedEvent: AuthEvent{
+				Type: EventLoginFailed,
+				Details: map[string]string{
+					"method":     loginMethodPassword,
+					"reason":     string(autherr.ReasonInvalidCredentials),
+					"identifier": "nobody",
+				},
+			},
+		},
+		{
+			name:       "suspended user",
+			identifier: "testuser",
+			password:   "password123",
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).
+					Return(database.User{ID: userID, Password: hashedPassword}, nil)
+				mockDB.On("GetUserAuthorization", mock.Anything, userID).
+					Return(database.GetUserAuthorizationRow{Status: database.StatusSuspended}, nil)
+			},
+			expectedEvent: AuthEvent{
+				Type:    EventLoginFailed,
+				UserID:  userID,
+				Details: map[string]string{"method": loginMethodPassword, "reason": string(autherr.ReasonAccountSuspended)},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB := new(mocks.MockQueries)
+			tc.mockSetup(mockDB)
+			sink := &recordingSink{}
+			server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret", WithAuditSink(sink, false))
+
+			ctx := peer.NewContext(
+				metadata.NewIncomingContext(context.Background(), metadata.Pairs("user-agent", "test-agent/1.0")),
+				&peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 51234}},
+			)
+			response, _ := server.Login(ctx, &pb.LoginRequest{Identifier: tc.identifier, Password: tc.password})
+
+			require.Len(t, sink.events, 1)
+			event := sink.events[0]
+			assert.Equal(t, tc.expectedEvent.Type, event.Type)
+			assert.Equal(t, tc.expectedEvent.UserID, event.UserID)
+			assert.Equal(t, tc.expectedEvent.Details, event.Details)
+			assert.Equal(t, "203.0.113.7", event.IPAddress)
+			assert.Equal(t, "test-agent/1.0", event.UserAgent)
+			if response != nil {
+				assert.Equal(t, auth.SessionID(response.RefreshToken), event.SessionID)
+			} else {
+				assert.Empty(t, event.SessionID)
+			}
+		})
+	}
+}
+
+func TestRefreshTokenRecordsEvents(t *testing.T) {
+	userID := uuid.New()
+
+	t.Run("refreshed", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		mockDB.On("GetRefreshToken", mock.Anything, "old-token").
+			Return(database.RefreshToken{Token: "old-token", UserID: userID, ExpiryTime: time.Now().Add(time.Hour)}, nil)
+		mockDB.On("GetUserAuthorization", mock.Anything, userID).Return(database.GetUserAuthorizationRow{}, nil)
+		mockDB.On("DeleteRefreshTokenByToken", mock.Anything, "old-token").Return(nil)
+		mockDB.On("RememberRotatedRefreshToken", mock.Anything, mock.Anything).Return(nil)
+		mockDB.On("MoveSessionDeviceTokens", mock.Anything, mock.Anything).Return(nil)
+		mockDB.On("RefreshToken", mock.Anything, mock.Anything).Return(database.RefreshToken{}, nil)
+		sink := &recordingSink{}
+		server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret", WithAuditSink(sink, false))
+
+		response, err := server.RefreshToken(context.Background(), &pb.RefreshTokenRequest{RefreshToken: "old-token"})
+		require.NoError(t, err)
+
+		require.Len(t, sink.events, 1)
+		assert.Equal(t, EventTokenRefreshed, sink.events[0].Type)
+		assert.Equal(t, userID, sink.events[0].UserID)
+		assert.Equal(t, auth.SessionID(response.RefreshToken), sink.events[0].SessionID)
+		assert.Equal(t, auth.SessionID("old-token"), sink.events[0].Details["previous_session_id"])
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		mockDB.On("GetRefreshToken", mock.Anything, "old-token").
+			Return(database.RefreshToken{Token: "old-token", UserID: userID, ExpiryTime: time.Now().Add(-time.Hour)}, nil)
+		sink := &recordingSink{}
+		server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret", WithAuditSink(sink, false))
+
+		_, err := server.RefreshToken(context.Background(), &pb.RefreshTokenRequest{RefreshToken: "old-token"})
+		assertReason(t, err, codes.Unauthenticated, autherr.ReasonRefreshTokenExpired)
+
+		require.Len(t, sink.events, 1)
+		assert.Equal(t, EventRefreshRejected, sink.events[0].Type)
+		assert.Equal(t, userID, sink.events[0].UserID)
+		assert.Equal(t, auth.SessionID("old-token"), sink.events[0].SessionID)
+		assert.Equal(t, string(autherr.ReasonRefreshTokenExpired), sink.events[0].Details["reason"])
+	})
+
+	t.Run("reused", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		mockDB.On("GetRefreshToken", mock.Anything, "old-token").Return(database.RefreshToken{}, sql.ErrNoRows)
+		mockDB.On("GetRotatedRefreshToken", mock.Anything, auth.HashRefreshToken("old-token")).
+			Return(database.RotatedRefreshToken{UserID: userID, FamilyID: "family-1"}, nil)
+		mockDB.On("DeleteRefreshTokenFamily", mock.Anything, mock.Anything).Return([]string{}, nil)
+		sink := &recordingSink{}
+		server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret", WithAuditSink(sink, false))
+
+		_, err := server.RefreshToken(context.Background(), &pb.RefreshTokenRequest{RefreshToken: "old-token"})
+		assertReason(t, err, codes.Unauthenticated, autherr.ReasonRefreshTokenInvalid)
+
+		require.Len(t, sink.events, 1)
+		assert.Equal(t, EventRefreshTokenReused, sink.events[0].Type)
+		assert.Equal(t, userID, sink.events[0].UserID)
+		assert.Equal(t, auth.SessionID("old-token"), sink.events[0].SessionID)
+		assert.Equal(t, "family-1", sink.events[0].Details["family_id"])
+	})
+
+	t.Run("database error", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		mockDB.On("GetRefreshToken", mock.Anything, "old-token").Return(database.RefreshToken{}, errors.New("database error"))
+		sink := &recordingSink{}
+		server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret", WithAuditSink(sink, false))
+
+		_, err := server.RefreshToken(context.Background(), &pb.RefreshTokenRequest{RefreshToken: "old-token"})
+		assert.Error(t, err)
+		assert.Empty(t, sink.events)
+	})
+}
+
+func TestClientInfo(t *testing.T) {
+	testCases := []struct {
+		name              string
+		trustForwardedFor bool
+		metadata          metadata.MD
+		expectedIP        string
+		expectedUserAgent string
+	}{
+		{
+			name:              "peer address",
+			metadata:          metadata.Pairs("user-agent", "grpc-go/1.70", "x-forwarded-for", "198.51.100.1"),
+			expectedIP:        "203.0.113.7",
+			expectedUserAgent: "grpc-go/1.70",
+		},
+		{
+			name:              "trusted forwarded for",
+			trustForwardedFor: true,
+			metadata:          metadata.Pairs("x-forwarded-for", "198.51.100.1, 10.0.0.1"),
+			expectedIP:        "198.51.100.1",
+		},
+		{
+			name:              "trusted without forwarded for",
+			trustForwardedFor: true,
+			metadata:          metadata.MD{},
+			expectedIP:        "203.0.113.7",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := NewServer(new(mocks.MockQueries), "test-secret", "test@example.com", "email-secret",
+				WithAuditSink(&recordingSink{}, tc.trustForwardedFor))
+			ctx := peer.NewContext(
+				metadata.NewIncomingContext(context.Background(), tc.metadata),
+				&peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 51234}},
+			)
+
+			ip, userAgent := server.clientInfo(ctx)
+			assert.Equal(t, tc.expectedIP, ip)
+			assert.Equal(t, tc.expectedUserAgent, userAgent)
+		})
+	}
+}
+
+func TestDatabaseAuditSink(t *testing.T) {
+	userID := uuid.New()
+	mockDB := new(mocks.MockQueries)
+	mockDB.On("CreateAuthEvent", mock.Anything, mock.MatchedBy(func(arg database.CreateAuthEventParams) bool {
+		var details map[string]string
+		return json.Unmarshal(arg.Details, &details) == nil && details["method"] == loginMethodPassword &&
+			arg.UserID == uuid.NullUUID{UUID: userID, Valid: true} && arg.Type == EventLoginSucceeded &&
+			arg.IpAddress == "203.0.113.7" && arg.SessionID == "abc"
+	})).Return(nil).Once()
+	mockDB.On("CreateAuthEvent", mock.Anything, mock.MatchedBy(func(arg database.CreateAuthEventParams) bool {
+		return !arg.UserID.Valid && string(arg.Details) == "{}"
+	})).Return(nil).Once()
+
+	sink := NewDatabaseAuditSink(mockDB, 0)
+	require.NoError(t, sink.Record(context.Background(), AuthEvent{
+		Type:      EventLoginSucceeded,
+		UserID:    userID,
+		IPAddress: "203.0.113.7",
+		SessionID: "abc",
+		Details:   map[string]string{"method": loginMethodPassword},
+	}))
+	require.NoError(t, sink.Record(context.Background(), AuthEvent{Type: EventLoginFailed}))
+	mockDB.AssertExpectations(t)
+}
+
+func TestDatabaseAuditSinkPurge(t *testing.T) {
+	t.Run("retention", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		mockDB.On("DeleteAuthEventsBefore", mock.Anything, mock.MatchedBy(func(before time.Time) bool {
+			return time.Since(before) > 29*24*time.Hour && time.Since(before) < 31*24*time.Hour
+		})).Return(int64(3), nil)
+
+		require.NoError(t, NewDatabaseAuditSink(mockDB, 30*24*time.Hour).Purge(context.Background()))
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("kept forever", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		require.NoError(t, NewDatabaseAuditSink(mockDB, 0).Purge(context.Background()))
+		mockDB.AssertNotCalled(t, "DeleteAuthEventsBefore", mock.Anything, mock.Anything)
+	})
+}
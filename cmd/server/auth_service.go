@@ -2,31 +2,29 @@ package server
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/imhasandl/auth-service/cmd/auth"
 	"github.com/imhasandl/auth-service/cmd/helper"
+	"github.com/imhasandl/auth-service/internal/autherr"
 	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/emailpolicy"
+	"github.com/imhasandl/auth-service/internal/exportstore"
+	"github.com/imhasandl/auth-service/internal/oauth"
+	"github.com/imhasandl/auth-service/internal/outbox"
 	"github.com/imhasandl/auth-service/internal/redis"
 	pb "github.com/imhasandl/auth-service/protos"
-	PostService "github.com/imhasandl/post-service/cmd/auth"
-	"google.golang.org/grpc/codes"
+	eventsv1 "github.com/imhasandl/auth-service/protos/events/v1"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // DBQuerier defines the interface for database operations used by the auth service
-type DBQuerier interface {
-	CreateUser(ctx context.Context, arg database.CreateUserParams) (database.User, error)
-	GetUserByIdentifier(ctx context.Context, arg database.GetUserByIdentifierParams) (database.User, error)
-	VerifyUser(ctx context.Context, email string) error
-	StoreVerificationCode(ctx context.Context, arg database.StoreVerificationCodeParams) error
-	SendVerifyCodeAgain(ctx context.Context, arg database.SendVerifyCodeAgainParams) error
-	RefreshToken(ctx context.Context, arg database.RefreshTokenParams) (database.RefreshToken, error)
-	GetRefreshToken(ctx context.Context, token string) (database.RefreshToken, error)
-	DeleteTokenByUserID(ctx context.Context, userID uuid.UUID) error
-}
+type DBQuerier = database.DBQuerier
 
 // Server implements the AuthService gRPC interface
 type Server struct {
@@ -35,133 +33,245 @@ type Server struct {
 	tokenSecret string
 	email       string
 	emailSecret string
+	codeLength  int
+	codeTTL     time.Duration
+
+	loginLinkURL    string
+	loginLinkTTL    time.Duration
+	loginRateLimit  int
+	loginRateWindow time.Duration
+	// loginEmails tracks login links and codes being sent in the background
+	loginEmails sync.WaitGroup
+
+	externalProviders map[string]oauth.Provider
+
+	serviceTokenTTL time.Duration
+
+	deviceVerificationURL string
+	deviceCodeTTL         time.Duration
+	devicePollInterval    time.Duration
+
+	auditSink         AuditSink
+	trustForwardedFor bool
+
+	signInReportURL  string
+	passwordResetTTL time.Duration
+
+	emailChangeUndoURL     string
+	emailChangeGracePeriod time.Duration
+
+	reservedUsernames  map[string]struct{}
+	usernameCooldown   time.Duration
+	usernameHoldPeriod time.Duration
+
+	emailPolicy *emailpolicy.Policy
+
+	accountDeletionCancelURL   string
+	accountDeletionGracePeriod time.Duration
+	accountDeletionAnonymize   bool
+
+	dataExportStore       exportstore.Store
+	dataExportDownloadURL string
+	dataExportRetention   time.Duration
+	dataExportTokenTTL    time.Duration
+
+	deviceTokenTTL time.Duration
+
+	apiKeyScopes []string
+
+	billingSecret     string
+	billingPricePlans map[string]string
 }
 
 // NewServer creates and initializes a new AuthService server instance
-func NewServer(db DBQuerier, tokenSecret, email, emailSecret string) *Server {
-	return &Server{
-		pb.UnimplementedAuthServiceServer{},
-		db,
-		tokenSecret,
-		email,
-		emailSecret,
+func NewServer(db DBQuerier, tokenSecret, email, emailSecret string, opts ...Option) *Server {
+	s := defaultServer()
+	s.db = db
+	s.tokenSecret = tokenSecret
+	s.email = email
+	s.emailSecret = emailSecret
+
+	for _, opt := range opts {
+		opt(s)
 	}
+
+	return s
 }
 
 // Register handles user registration by validating input data, creating a new user record,
 func (s *Server) Register(ctx context.Context, req *pb.RegisterRequest) (*pb.RegisterResponse, error) {
-	if len(req.GetUsername()) < 5 {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "username should be 5 characters long", nil)
+	var (
+		violations autherr.Violations
+		email      emailpolicy.Address
+	)
+	if req.GetEmail() == "" {
+		violations.Add("email", "email is required")
+	} else if addr, violation := s.newEmailViolation(req.GetEmail()); violation != "" {
+		violations.Add("email", violation)
+	} else {
+		email = addr
+	}
+	if req.GetPassword() == "" {
+		violations.Add("password", "password is required")
+	}
+	if violation := s.chosenUsernameViolation(req.GetUsername()); violation != "" {
+		violations.Add("username", violation)
+	}
+	if err := violations.Err(); err != nil {
+		return nil, helper.RespondWithError(ctx, err)
 	}
 
 	hashedPassword, err := auth.HashPassword(req.GetPassword())
 	if err != nil {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "failed to hash password - Register", err)
+		return nil, helper.RespondWithError(ctx, err)
 	}
 
-	verificationCode, err := auth.GenerateVerificationCode()
+	verificationCode, err := auth.GenerateVerificationCode(s.codeLength)
 	if err != nil {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "failed to generate verification code - Register", err)
+		return nil, helper.RespondWithError(ctx, err)
 	}
 
 	userParams := database.CreateUserParams{
-		ID:               uuid.New(),
-		Email:            req.GetEmail(),
-		Password:         hashedPassword,
-		Username:         req.GetUsername(),
-		IsPremium:        false,
-		VerificationCode: verificationCode,
-		IsVerified:       false,
-	}
+		ID:             uuid.New(),
+		Email:          email.Email,
+		Password:       hashedPassword,
+		Username:       req.GetUsername(),
+		IsPremium:      false,
+		IsVerified:     false,
+		EmailCanonical: email.Canonical,
+	}
+
+	var user database.User
+	err = s.db.WithTx(ctx, func(q DBQuerier) error {
+		available, err := q.UsernameAvailable(ctx, database.UsernameAvailableParams{
+			Username: userParams.Username,
+			UserID:   userParams.ID,
+		})
+		if err != nil {
+			return err
+		}
+		if !available {
+			return autherr.UsernameTaken()
+		}
 
-	user, err := s.db.CreateUser(ctx, userParams)
-	if err != nil {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't create user - Register", err)
-	}
+		user, err = q.CreateUser(ctx, userParams)
+		if err != nil {
+			// No row comes back when the address is held for the undo link of another account's email change
+			if isEmailUniqueViolation(err) || errors.Is(err, sql.ErrNoRows) {
+				return autherr.EmailTaken().WithCause(err)
+			}
+			if database.IsUniqueViolation(err, "users_username_lower_key") {
+				return autherr.UsernameTaken().WithCause(err)
+			}
+			return err
+		}
 
-	verifyParams := database.StoreVerificationCodeParams{
-		VerificationCode: verificationCode,
-		ID:               user.ID,
-	}
+		err = enqueueEvent(ctx, q, outbox.UserRegistered, user.ID, &eventsv1.UserRegistered{
+			UserId:   user.ID.String(),
+			Username: user.Username,
+		})
+		if err != nil {
+			return err
+		}
 
-	err = s.db.StoreVerificationCode(ctx, verifyParams)
+		return s.storeVerificationCode(ctx, q, user.ID, database.PurposeEmailVerify, verificationCode)
+	})
 	if err != nil {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "failed to store verification code - Register", err)
+		return nil, helper.RespondWithError(ctx, err)
 	}
 
-	err = redis.CacheVerificationCode(user.Email, verificationCode, time.Hour*2)
-	if err != nil {
-		log.Printf("WARNING: Failed to cache verification code in Redis: %v", err)
-	}
+	s.cacheVerificationCode(user.Email, verificationCode)
+	s.recordEvent(ctx, AuthEvent{Type: EventRegistered, UserID: user.ID})
 
 	if s.email != "test@example.com" {
-		err = auth.SendVerificationEmail(req.GetEmail(), s.email, s.emailSecret, verificationCode)
+		err = auth.SendVerificationEmail(user.Email, s.email, s.emailSecret, verificationCode, s.codeTTL)
 		if err != nil {
-			return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "failed to send verification email - Register", err)
+			return nil, helper.RespondWithError(ctx, autherr.EmailDeliveryFailed(err))
 		}
 	}
 
 	return &pb.RegisterResponse{
 		User: &pb.User{
-			Id:               user.ID.String(),
-			CreatedAt:        timestamppb.New(user.CreatedAt),
-			UpdatedAt:        timestamppb.New(user.UpdatedAt),
-			Email:            user.Email,
-			Username:         user.Username,
-			IsPremium:        user.IsPremium,
-			VerificationCode: user.VerificationCode,
-			IsVerified:       user.IsVerified,
+			Id:         user.ID.String(),
+			CreatedAt:  timestamppb.New(user.CreatedAt),
+			UpdatedAt:  timestamppb.New(user.UpdatedAt),
+			Email:      user.Email,
+			Username:   user.Username,
+			IsPremium:  user.IsPremium,
+			IsVerified: user.IsVerified,
 		},
 	}, nil
 }
 
 // VerifyEmail validates the verification code provided by the user against the one stored in the database.
+// Codes expire after the configured TTL and stop being accepted after too many wrong guesses.
 func (s *Server) VerifyEmail(ctx context.Context, req *pb.VerifyEmailRequest) (*pb.VerifyEmailResponse, error) {
-	cachedCode, err := redis.GetVerificationCode(req.GetEmail())
-	if err == nil {
-		if cachedCode == int(req.GetVerificationCode()) {
-			err = s.db.VerifyUser(ctx, req.GetEmail())
-			if err != nil {
-				return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "failed to verify user - VerifyEmail", err)
+	if req.GetEmail() == "" {
+		return nil, helper.RespondWithError(ctx, autherr.InvalidArgument("email", "email is required"))
+	}
+
+	canonical := emailpolicy.LookupKey(req.GetEmail())
+	cachedHash, err := redis.GetVerificationCode(canonical)
+	if err == nil && auth.CheckVerificationCode(cachedHash, req.GetVerificationCode(), s.tokenSecret) {
+		err = s.db.WithTx(ctx, func(q DBQuerier) error {
+			if err := verifyUser(ctx, q, canonical); err != nil {
+				return err
 			}
+			return q.DeleteVerificationCodeByEmail(ctx, database.DeleteVerificationCodeByEmailParams{
+				Purpose:        database.PurposeEmailVerify,
+				EmailCanonical: canonical,
+			})
+		})
+		if err != nil {
+			return nil, helper.RespondWithError(ctx, err)
+		}
 
-			_ = redis.DeleteVerificationCode(req.GetEmail())
+		_ = redis.DeleteVerificationCode(canonical)
 
-			return &pb.VerifyEmailResponse{
-				Success: true,
-				Message: "Email verified successfully",
-			}, nil
-		}
+		return &pb.VerifyEmailResponse{
+			Success: true,
+			Message: "Email verified successfully",
+		}, nil
 	}
 
 	userParams := database.GetUserByIdentifierParams{
-		Email:    req.GetEmail(),
-		Username: req.GetEmail(),
+		EmailCanonical: canonical,
 	}
 
 	user, err := s.db.GetUserByIdentifier(ctx, userParams)
 	if err != nil {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.NotFound, "can't get user with identifier - VerifyEmail", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, helper.RespondWithError(ctx, autherr.UserNotFound().WithCause(err))
+		}
+		return nil, helper.RespondWithError(ctx, err)
 	}
 
 	if user.IsVerified {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.AlreadyExists, "email already verified - VerifyEmail", nil)
+		return nil, helper.RespondWithError(ctx, autherr.EmailAlreadyVerified())
 	}
 
-	if user.VerificationExpireTime.Before(time.Now()) {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.DeadlineExceeded, "verification code expired - VerifyEmail", nil)
-	}
-
-	if user.VerificationCode != req.GetVerificationCode() {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "invalid verification code - VerifyEmail", nil)
+	err = s.checkVerificationCode(ctx, user.ID, database.PurposeEmailVerify, req.GetVerificationCode())
+	if err != nil {
+		if errors.Is(err, autherr.VerificationAttemptsExceeded()) {
+			_ = redis.DeleteVerificationCode(canonical)
+		}
+		return nil, helper.RespondWithError(ctx, err)
 	}
 
-	err = s.db.VerifyUser(ctx, req.GetEmail())
+	err = s.db.WithTx(ctx, func(q DBQuerier) error {
+		if err := verifyUser(ctx, q, canonical); err != nil {
+			return err
+		}
+		return q.DeleteVerificationCode(ctx, database.DeleteVerificationCodeParams{
+			UserID:  user.ID,
+			Purpose: database.PurposeEmailVerify,
+		})
+	})
 	if err != nil {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "failed to verify user - VerifyEmail", err)
+		return nil, helper.RespondWithError(ctx, err)
 	}
 
-	_ = redis.DeleteVerificationCode(req.GetEmail())
+	_ = redis.DeleteVerificationCode(canonical)
 
 	return &pb.VerifyEmailResponse{
 		Success: true,
@@ -170,39 +280,26 @@ func (s *Server) VerifyEmail(ctx context.Context, req *pb.VerifyEmailRequest) (*
 }
 
 // SendVerifyCode generates a new verification code for a user and sends it to their email.
-// It retrieves the user by email, generates a new verification code, and sends it via email.
+// It retrieves the user by email, replaces the previous code and resets its expiry, and sends it via email.
 func (s *Server) SendVerifyCode(ctx context.Context, req *pb.SendVerifyCodeRequest) (*pb.SendVerifyCodeResponse, error) {
-	userParams := database.GetUserByIdentifierParams{
-		Email:    req.GetEmail(),
-		Username: req.GetEmail(),
-	}
-
-	user, err := s.db.GetUserByIdentifier(ctx, userParams)
-	if err != nil {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get user with identifier - SendVerifyCodeAgain", err)
-	}
-
-	newVerifyCode, err := auth.GenerateVerificationCode()
-	if err != nil {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "failed to generate verification code - SendVerifyCodeAgain", err)
+	if req.GetEmail() == "" {
+		return nil, helper.RespondWithError(ctx, autherr.InvalidArgument("email", "email is required"))
 	}
 
-	sendVerifyAgainParams := database.SendVerifyCodeAgainParams{
-		VerificationCode: newVerifyCode,
-		ID:               user.ID,
+	userParams := database.GetUserByIdentifierParams{
+		EmailCanonical: emailpolicy.LookupKey(req.GetEmail()),
 	}
 
-	err = s.db.SendVerifyCodeAgain(ctx, sendVerifyAgainParams)
+	user, err := s.db.GetUserByIdentifier(ctx, userParams)
 	if err != nil {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "failed to send verification code again - SendVerifyCodeAgain", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, helper.RespondWithError(ctx, autherr.UserNotFound().WithCause(err))
+		}
+		return nil, helper.RespondWithError(ctx, err)
 	}
 
-	// Skip email sending in test mode
-	if s.email != "test@example.com" {
-		err = auth.SendVerificationEmail(req.GetEmail(), s.email, s.emailSecret, newVerifyCode)
-		if err != nil {
-			return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "failed to send verification email - SendVerifyCodeAgain", err)
-		}
+	if err := s.sendVerificationCode(ctx, user); err != nil {
+		return nil, helper.RespondWithError(ctx, err)
 	}
 
 	return &pb.SendVerifyCodeResponse{
@@ -212,50 +309,58 @@ func (s *Server) SendVerifyCode(ctx context.Context, req *pb.SendVerifyCodeReque
 }
 
 // Login authenticates a user using their email/username and password.
+// Attempts per identifier are rate limited together with the login form of the OpenID Connect provider.
+// An unknown identifier and a wrong password produce the same response, so Login can't be used to find out
+// which accounts exist.
 func (s *Server) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResponse, error) {
-	userParams := database.GetUserByIdentifierParams{
-		Email:    req.GetIdentifier(),
-		Username: req.GetIdentifier(),
+	var violations autherr.Violations
+	if req.GetIdentifier() == "" {
+		violations.Add("identifier", "identifier is required")
 	}
-
-	user, err := s.db.GetUserByIdentifier(ctx, userParams)
-	if err != nil {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't get user with identifier - Login", err)
+	if req.GetPassword() == "" {
+		violations.Add("password", "password is required")
 	}
-
-	err = auth.CheckPassword(user.Password, req.GetPassword())
-	if err != nil {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "invalid credentials - Login", err)
+	if err := violations.Err(); err != nil {
+		return nil, helper.RespondWithError(ctx, err)
 	}
 
-	accessToken, err := auth.MakeJWT(user.ID, s.tokenSecret, time.Hour)
-	if err != nil {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't create token - Login", err)
+	if err := s.CheckPasswordLogin(req.GetIdentifier()); err != nil {
+		s.recordLoginFailure(ctx, uuid.Nil, req.GetIdentifier(), loginMethodPassword, err)
+		return nil, helper.RespondWithError(ctx, err)
 	}
 
-	refreshToken, err := auth.MakeRefreshToken()
-	if err != nil {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't create refresh token - Login", err)
+	userParams := database.GetUserByIdentifierParams{
+		EmailCanonical: emailpolicy.LookupKey(req.GetIdentifier()),
+		Username:       req.GetIdentifier(),
 	}
 
-	refreshTokenParams := database.RefreshTokenParams{
-		Token:      refreshToken,
-		UserID:     user.ID,
-		ExpiryTime: time.Now().Add(7 * 24 * time.Hour),
+	user, err := s.db.GetUserByIdentifier(ctx, userParams)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			auth.CheckDummyPassword(req.GetPassword())
+			err = autherr.InvalidCredentials().WithCause(err)
+			s.recordLoginFailure(ctx, uuid.Nil, req.GetIdentifier(), loginMethodPassword, err)
+			return nil, helper.RespondWithError(ctx, err)
+		}
+		return nil, helper.RespondWithError(ctx, err)
 	}
 
-	_, err = s.db.RefreshToken(ctx, refreshTokenParams)
+	err = auth.CheckPassword(user.Password, req.GetPassword())
 	if err != nil {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't store refresh token - Login", err)
+		err = autherr.InvalidCredentials().WithCause(err)
+		s.recordLoginFailure(ctx, user.ID, req.GetIdentifier(), loginMethodPassword, err)
+		return nil, helper.RespondWithError(ctx, err)
 	}
 
-	if err := redis.SaveAccessToken(user.ID.String(), accessToken, time.Hour*1); err != nil {
-		log.Printf("Redis caching error for access token: %v", err)
+	accessToken, refreshToken, err := s.issueTokens(ctx, s.db, user.ID)
+	if err != nil {
+		s.recordLoginFailure(ctx, user.ID, req.GetIdentifier(), loginMethodPassword, err)
+		return nil, helper.RespondWithError(ctx, err)
 	}
 
-	if err := redis.SaveRefreshToken(user.ID.String(), refreshToken, time.Hour*7*24); err != nil {
-		log.Printf("Redis caching error for access token: %v", err)
-	}
+	cacheTokens(user.ID, accessToken, refreshToken)
+	s.recordLogin(ctx, user.ID, loginMethodPassword, refreshToken)
+	s.alertNewDevice(ctx, user, refreshToken)
 
 	return &pb.LoginResponse{
 		User: &pb.User{
@@ -271,49 +376,181 @@ func (s *Server) Login(ctx context.Context, req *pb.LoginRequest) (*pb.LoginResp
 	}, nil
 }
 
-// RefreshToken validates a refresh token and issues a new access token and refresh token pair.
-func (s *Server) RefreshToken(ctx context.Context, req *pb.RefreshTokenRequest) (*pb.RefreshTokenResponse, error) {
-	refreshToken := req.GetRefreshToken()
-	if refreshToken == "" {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "refresh token is required - RefreshToken", nil)
+// issueTokens creates an access token and a refresh token for the user and stores the refresh token with q
+func (s *Server) issueTokens(ctx context.Context, q DBQuerier, userID uuid.UUID) (accessToken, refreshToken string, err error) {
+	accessToken, err = s.makeAccessToken(ctx, q, userID)
+	if err != nil {
+		return "", "", err
 	}
 
-	storedToken, err := s.db.GetRefreshToken(ctx, refreshToken)
+	refreshToken, err = auth.MakeRefreshToken()
 	if err != nil {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.InvalidArgument, "can't get refresh token - RefreshToken", err)
+		return "", "", err
 	}
 
-	// Verify token is not expired
-	if time.Now().After(storedToken.ExpiryTime) {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.DeadlineExceeded, "refresh token expired - RefreshToken", nil)
+	refreshTokenParams := database.RefreshTokenParams{
+		Token:      refreshToken,
+		UserID:     userID,
+		ExpiryTime: time.Now().Add(7 * 24 * time.Hour),
+		SessionID:  auth.SessionID(refreshToken),
+		FamilyID:   auth.SessionID(refreshToken),
 	}
 
-	newAccessToken, err := auth.MakeJWT(storedToken.UserID, s.tokenSecret, time.Hour)
+	_, err = q.RefreshToken(ctx, refreshTokenParams)
 	if err != nil {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't create new access token - RefreshToken", err)
+		return "", "", err
 	}
 
-	newRefreshToken, err := auth.MakeRefreshToken()
-	if err != nil {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't create new refresh token - RefreshToken", err)
+	return accessToken, refreshToken, nil
+}
+
+// deleteAllSessions signs the user out everywhere with q. The push tokens go with the refresh tokens, so signed
+// out devices stop getting notifications. Other services learn about it from a user.logged_out event without a
+// session ID
+func deleteAllSessions(ctx context.Context, q DBQuerier, userID uuid.UUID) error {
+	if err := q.DeleteTokenByUserID(ctx, userID); err != nil {
+		return err
 	}
+	if err := q.DeleteUserDeviceTokens(ctx, userID); err != nil {
+		return err
+	}
+	return enqueueEvent(ctx, q, outbox.UserLoggedOut, userID, &eventsv1.UserLoggedOut{UserId: userID.String()})
+}
 
-	err = s.db.DeleteTokenByUserID(ctx, storedToken.UserID)
+// makeAccessToken creates an access token carrying the roles, permissions and entitlements the user has right now.
+// Every login and refresh goes through here, so it is also where users who aren't active are turned away
+func (s *Server) makeAccessToken(ctx context.Context, q DBQuerier, userID uuid.UUID) (string, error) {
+	authorization, err := q.GetUserAuthorization(ctx, userID)
 	if err != nil {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't delete old token - RefreshToken", err)
+		return "", err
 	}
+	if err := checkAccountStatus(authorization.Status, authorization.StatusUntil); err != nil {
+		return "", err
+	}
+	return auth.MakeAccessToken(userID, auth.AccessGrants{
+		Roles:        authorization.Roles,
+		Permissions:  authorization.Permissions,
+		Premium:      authorization.Premium,
+		Entitlements: authorization.Entitlements,
+	}, s.tokenSecret, time.Hour)
+}
 
-	refreshTokenParams := database.RefreshTokenParams{
-		Token:      newRefreshToken,
-		UserID:     storedToken.UserID,
-		ExpiryTime: time.Now().Add(7 * 24 * time.Hour),
+// cacheTokens caches a freshly issued token pair in Redis. Failures are only logged
+func cacheTokens(userID uuid.UUID, accessToken, refreshToken string) {
+	if err := redis.SaveAccessToken(userID.String(), accessToken, time.Hour*1); err != nil {
+		log.Printf("Redis caching error for access token: %v", err)
 	}
 
-	_, err = s.db.RefreshToken(ctx, refreshTokenParams)
+	if err := redis.SaveRefreshToken(userID.String(), refreshToken, time.Hour*7*24); err != nil {
+		log.Printf("Redis caching error for access token: %v", err)
+	}
+}
+
+// RefreshToken validates a refresh token and issues a new access token and refresh token pair. A refresh token
+// that was already rotated ends every session rotated from the same login, since someone holds a copy of it.
+func (s *Server) RefreshToken(ctx context.Context, req *pb.RefreshTokenRequest) (*pb.RefreshTokenResponse, error) {
+	refreshToken := req.GetRefreshToken()
+	if refreshToken == "" {
+		return nil, helper.RespondWithError(ctx, autherr.InvalidArgument("refresh_token", "refresh token is required"))
+	}
+
+	newRefreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	var (
+		newAccessToken string
+		userID         uuid.UUID
+		unknownToken   bool
+	)
+	err = s.db.WithTx(ctx, func(q DBQuerier) error {
+		storedToken, err := q.GetRefreshToken(ctx, refreshToken)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				unknownToken = true
+				return autherr.RefreshTokenInvalid().WithCause(err)
+			}
+			return err
+		}
+
+		userID = storedToken.UserID
+
+		// Verify token is not expired
+		if time.Now().After(storedToken.ExpiryTime) {
+			return autherr.RefreshTokenExpired()
+		}
+
+		newAccessToken, err = s.makeAccessToken(ctx, q, storedToken.UserID)
+		if err != nil {
+			return err
+		}
+
+		// Only the presented token is rotated, the user's other sessions and their push tokens stay
+		if err := q.DeleteRefreshTokenByToken(ctx, refreshToken); err != nil {
+			return err
+		}
+		err = q.RememberRotatedRefreshToken(ctx, database.RememberRotatedRefreshTokenParams{
+			TokenHash: auth.HashRefreshToken(refreshToken),
+			UserID:    storedToken.UserID,
+			FamilyID:  storedToken.FamilyID,
+			ExpiresAt: storedToken.ExpiryTime,
+		})
+		if err != nil {
+			return err
+		}
+
+		refreshTokenParams := database.RefreshTokenParams{
+			Token:      newRefreshToken,
+			UserID:     storedToken.UserID,
+			ExpiryTime: time.Now().Add(7 * 24 * time.Hour),
+			SessionID:  auth.SessionID(newRefreshToken),
+			FamilyID:   storedToken.FamilyID,
+		}
+
+		if _, err := q.RefreshToken(ctx, refreshTokenParams); err != nil {
+			return err
+		}
+		return q.MoveSessionDeviceTokens(ctx, database.MoveSessionDeviceTokensParams{
+			NewSessionID: auth.SessionID(newRefreshToken),
+			UserID:       storedToken.UserID,
+			OldSessionID: auth.SessionID(refreshToken),
+		})
+	})
 	if err != nil {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't store refresh token - RefreshToken", err)
+		if unknownToken {
+			rotated, reused, reuseErr := s.revokeReusedRefreshToken(ctx, refreshToken)
+			if reuseErr != nil {
+				return nil, helper.RespondWithError(ctx, reuseErr)
+			}
+			if reused {
+				s.recordEvent(ctx, AuthEvent{
+					Type:      EventRefreshTokenReused,
+					UserID:    rotated.UserID,
+					SessionID: auth.SessionID(refreshToken),
+					Details:   map[string]string{"family_id": rotated.FamilyID},
+				})
+				return nil, helper.RespondWithError(ctx, err)
+			}
+		}
+		if authErr := domainError(err); authErr != nil {
+			s.recordEvent(ctx, AuthEvent{
+				Type:      EventRefreshRejected,
+				UserID:    userID,
+				SessionID: auth.SessionID(refreshToken),
+				Details:   map[string]string{"reason": string(authErr.Reason)},
+			})
+		}
+		return nil, helper.RespondWithError(ctx, err)
 	}
 
+	s.recordEvent(ctx, AuthEvent{
+		Type:      EventTokenRefreshed,
+		UserID:    userID,
+		SessionID: auth.SessionID(newRefreshToken),
+		Details:   map[string]string{"previous_session_id": auth.SessionID(refreshToken)},
+	})
+
 	return &pb.RefreshTokenResponse{
 		AccessToken:  newAccessToken,
 		RefreshToken: newRefreshToken,
@@ -321,22 +558,102 @@ func (s *Server) RefreshToken(ctx context.Context, req *pb.RefreshTokenRequest)
 	}, nil
 }
 
+// revokeReusedRefreshToken ends the sessions of the family a refresh token was rotated in when the rotated token is
+// presented again. Either the user or someone with a stolen copy used it already, and there is no telling which,
+// so neither keeps the session. reused is false for tokens that were never rotated
+func (s *Server) revokeReusedRefreshToken(ctx context.Context, refreshToken string) (rotated database.RotatedRefreshToken, reused bool, err error) {
+	rotated, err = s.db.GetRotatedRefreshToken(ctx, auth.HashRefreshToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return rotated, false, nil
+		}
+		return rotated, false, err
+	}
+
+	err = s.db.WithTx(ctx, func(q DBQuerier) error {
+		sessionIDs, err := q.DeleteRefreshTokenFamily(ctx, database.DeleteRefreshTokenFamilyParams{
+			UserID:   rotated.UserID,
+			FamilyID: rotated.FamilyID,
+		})
+		if err != nil {
+			return err
+		}
+		for _, sessionID := range sessionIDs {
+			err := q.DeleteSessionDeviceTokens(ctx, database.DeleteSessionDeviceTokensParams{
+				UserID:    rotated.UserID,
+				SessionID: sessionID,
+			})
+			if err != nil {
+				return err
+			}
+			err = enqueueEvent(ctx, q, outbox.UserLoggedOut, rotated.UserID, &eventsv1.UserLoggedOut{
+				UserId:    rotated.UserID.String(),
+				SessionId: sessionID,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return rotated, true, err
+}
+
+// PurgeRotatedRefreshTokens forgets rotated refresh tokens that would have expired by now. main runs it periodically
+func (s *Server) PurgeRotatedRefreshTokens(ctx context.Context) error {
+	purged, err := s.db.DeleteExpiredRotatedRefreshTokens(ctx)
+	if err != nil {
+		return err
+	}
+	if purged > 0 {
+		log.Printf("Purged %d rotated refresh tokens", purged)
+	}
+	return nil
+}
+
 // Logout invalidates a user's refresh token, effectively ending their session.
 // It deletes the token from the database to prevent its future use.
 // It returns a success response or an appropriate error on failure.
 func (s *Server) Logout(ctx context.Context, req *pb.LogoutRequest) (*pb.LogoutResponse, error) {
-	userID, err := PostService.ValidateJWT(req.RefreshToken, s.tokenSecret)
-	if err != nil {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.Unauthenticated, "invalid token - Logout", err)
+	if req.GetRefreshToken() == "" {
+		return nil, helper.RespondWithError(ctx, autherr.InvalidArgument("refresh_token", "refresh token is required"))
 	}
 
-	if err := redis.DeleteAllUserTokens(userID.String()); err != nil {
-		log.Printf("Failed to delete user tokens from Redis: %v", err)
+	storedToken, err := s.db.GetRefreshToken(ctx, req.GetRefreshToken())
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, helper.RespondWithError(ctx, err)
 	}
 
-	err = s.db.DeleteTokenByUserID(ctx, userID)
+	sessionID := auth.SessionID(req.GetRefreshToken())
+	err = s.db.WithTx(ctx, func(q DBQuerier) error {
+		if err := q.DeleteRefreshTokenByToken(ctx, req.GetRefreshToken()); err != nil {
+			return err
+		}
+		if storedToken.UserID == uuid.Nil {
+			return nil
+		}
+		err := q.DeleteSessionDeviceTokens(ctx, database.DeleteSessionDeviceTokensParams{
+			UserID:    storedToken.UserID,
+			SessionID: sessionID,
+		})
+		if err != nil {
+			return err
+		}
+		return enqueueEvent(ctx, q, outbox.UserLoggedOut, storedToken.UserID, &eventsv1.UserLoggedOut{
+			UserId:    storedToken.UserID.String(),
+			SessionId: sessionID,
+		})
+	})
 	if err != nil {
-		return nil, helper.RespondWithErrorGRPC(ctx, codes.Internal, "can't delete token - Logout", err)
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	if storedToken.UserID != uuid.Nil {
+		s.recordEvent(ctx, AuthEvent{
+			Type:      EventLogout,
+			UserID:    storedToken.UserID,
+			SessionID: sessionID,
+		})
 	}
 
 	return &pb.LogoutResponse{
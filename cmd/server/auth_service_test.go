@@ -2,14 +2,18 @@ package server
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/internal/autherr"
 	"github.com/imhasandl/auth-service/internal/database"
 	"github.com/imhasandl/auth-service/internal/database/mocks"
+	"github.com/imhasandl/auth-service/internal/outbox"
+	"github.com/imhasandl/auth-service/internal/redis"
 	pb "github.com/imhasandl/auth-service/protos"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -24,7 +28,7 @@ func TestRegister(t *testing.T) {
 		mockSetup     func(*mocks.MockQueries)
 		expectedError bool
 		errorCode     codes.Code
-		errorMsg      string
+		errorReason   autherr.Reason
 	}{
 		{
 			name: "successful registration",
@@ -36,26 +40,34 @@ func TestRegister(t *testing.T) {
 			mockSetup: func(mockDB *mocks.MockQueries) {
 				userID := uuid.New()
 
+				mockDB.On("UsernameAvailable", mock.Anything, mock.MatchedBy(func(arg database.UsernameAvailableParams) bool {
+					return arg.Username == "testusername"
+				})).Return(true, nil)
 				mockDB.On("CreateUser", mock.Anything, mock.MatchedBy(func(arg database.CreateUserParams) bool {
 					return arg.Email == "test@example.com" && arg.Username == "testusername"
 				})).Return(database.User{
-					ID:               userID,
-					Email:            "test@example.com",
-					Username:         "testusername",
-					CreatedAt:        time.Now(),
-					UpdatedAt:        time.Now(),
-					IsPremium:        false,
-					VerificationCode: 1234,
-					IsVerified:       false,
+					ID:         userID,
+					Email:      "test@example.com",
+					Username:   "testusername",
+					CreatedAt:  time.Now(),
+					UpdatedAt:  time.Now(),
+					IsPremium:  false,
+					IsVerified: false,
 				}, nil)
 
-				mockDB.On("StoreVerificationCode", mock.Anything, mock.MatchedBy(func(arg database.StoreVerificationCodeParams) bool {
-					return arg.ID == userID
+				mockDB.On("UpsertVerificationCode", mock.Anything, mock.MatchedBy(func(arg database.UpsertVerificationCodeParams) bool {
+					return arg.UserID == userID &&
+						arg.Purpose == database.PurposeEmailVerify &&
+						arg.CodeHash != "" &&
+						arg.ExpiresAt.After(time.Now())
+				})).Return(database.VerificationCode{}, nil)
+				mockDB.On("CreateOutboxEvent", mock.Anything, mock.MatchedBy(func(arg database.CreateOutboxEventParams) bool {
+					return arg.EventType == outbox.UserRegistered && arg.UserID == userID
 				})).Return(nil)
+				mockDB.On("CreateWebhookDeliveries", mock.Anything, mock.Anything).Return(nil)
 			},
 			expectedError: false,
 			errorCode:     codes.OK,
-			errorMsg:      "",
 		},
 		{
 			name: "username too short",
@@ -66,8 +78,8 @@ func TestRegister(t *testing.T) {
 			},
 			mockSetup:     func(mockDB *mocks.MockQueries) {},
 			expectedError: true,
-			errorCode:     codes.Internal,
-			errorMsg:      "username should be 5 characters long",
+			errorCode:     codes.InvalidArgument,
+			errorReason:   autherr.ReasonInvalidArgument,
 		},
 		{
 			name: "database error during user creation",
@@ -77,11 +89,69 @@ func TestRegister(t *testing.T) {
 				Username: "testuser",
 			},
 			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("UsernameAvailable", mock.Anything, mock.Anything).Return(true, nil)
 				mockDB.On("CreateUser", mock.Anything, mock.Anything).Return(database.User{}, errors.New("database error"))
 			},
 			expectedError: true,
 			errorCode:     codes.Internal,
-			errorMsg:      "can't create user",
+			errorReason:   autherr.ReasonInternal,
+		},
+		{
+			name: "email held after an email change",
+			request: &pb.RegisterRequest{
+				Email:    "old@example.com",
+				Password: "password123",
+				Username: "testuser",
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("UsernameAvailable", mock.Anything, mock.Anything).Return(true, nil)
+				mockDB.On("CreateUser", mock.Anything, mock.Anything).Return(database.User{}, sql.ErrNoRows)
+			},
+			expectedError: true,
+			errorCode:     codes.AlreadyExists,
+			errorReason:   autherr.ReasonEmailTaken,
+		},
+		{
+			name: "username taken in another case",
+			request: &pb.RegisterRequest{
+				Email:    "test@example.com",
+				Password: "password123",
+				Username: "TestUser",
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("UsernameAvailable", mock.Anything, mock.MatchedBy(func(arg database.UsernameAvailableParams) bool {
+					return arg.Username == "TestUser"
+				})).Return(false, nil)
+			},
+			expectedError: true,
+			errorCode:     codes.AlreadyExists,
+			errorReason:   autherr.ReasonUsernameTaken,
+		},
+		{
+			name: "reserved username",
+			request: &pb.RegisterRequest{
+				Email:    "test@example.com",
+				Password: "password123",
+				Username: "Ad.Min_",
+			},
+			mockSetup:     func(mockDB *mocks.MockQueries) {},
+			expectedError: true,
+			errorCode:     codes.InvalidArgument,
+			errorReason:   autherr.ReasonInvalidArgument,
+		},
+		{
+			name: "transaction can't be started",
+			request: &pb.RegisterRequest{
+				Email:    "test@example.com",
+				Password: "password123",
+				Username: "testuser",
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("WithTx", mock.Anything).Return(errors.New("connection refused"))
+			},
+			expectedError: true,
+			errorCode:     codes.Internal,
+			errorReason:   autherr.ReasonInternal,
 		},
 	}
 
@@ -100,7 +170,7 @@ func TestRegister(t *testing.T) {
 				statusErr, ok := status.FromError(err)
 				assert.True(t, ok)
 				assert.Equal(t, tc.errorCode, statusErr.Code())
-				assert.Contains(t, statusErr.Message(), tc.errorMsg)
+				assert.Equal(t, tc.errorReason, autherr.ReasonOf(err))
 				assert.Nil(t, response)
 			} else {
 				assert.NoError(t, err)
@@ -114,34 +184,71 @@ func TestRegister(t *testing.T) {
 }
 
 func TestVerifyEmail(t *testing.T) {
+	const secret = "test-secret"
+	userID := uuid.New()
+
 	testCases := []struct {
 		name          string
 		request       *pb.VerifyEmailRequest
+		cachedCode    int32
 		mockSetup     func(mockDB *mocks.MockQueries)
 		expectedError bool
 		errorCode     codes.Code
-		errorMsg      string
+		errorReason   autherr.Reason
 	}{
 		{
 			name: "successful verification",
 			request: &pb.VerifyEmailRequest{
 				Email:            "test@example.com",
-				VerificationCode: 1234,
+				VerificationCode: 123456,
 			},
 			mockSetup: func(mockDB *mocks.MockQueries) {
 				mockDB.On("GetUserByIdentifier", mock.Anything, database.GetUserByIdentifierParams{
-					Email:    "test@example.com",
-					Username: "",
+					EmailCanonical: "test@example.com",
+					Username:       "",
 				}).Return(database.User{
-					ID:                     uuid.New(),
-					Email:                  "test@example.com",
-					Username:               "testuser",
-					VerificationCode:       1234,
-					IsVerified:             false,
-					VerificationExpireTime: time.Now().Add(time.Hour), // Not expired
+					ID:         userID,
+					Email:      "test@example.com",
+					Username:   "testuser",
+					IsVerified: false,
 				}, nil)
 
-				mockDB.On("VerifyUser", mock.Anything, "test@example.com").Return(nil)
+				mockDB.On("GetVerificationCode", mock.Anything, database.GetVerificationCodeParams{
+					UserID:  userID,
+					Purpose: database.PurposeEmailVerify,
+				}).Return(database.VerificationCode{
+					UserID:    userID,
+					Purpose:   database.PurposeEmailVerify,
+					CodeHash:  auth.HashVerificationCode(123456, secret),
+					ExpiresAt: time.Now().Add(time.Hour), // Not expired
+				}, nil)
+
+				mockDB.On("VerifyUser", mock.Anything, "test@example.com").Return(userID, nil)
+				mockDB.On("CreateOutboxEvent", mock.Anything, mock.MatchedBy(func(arg database.CreateOutboxEventParams) bool {
+					return arg.EventType == outbox.UserVerified && arg.UserID == userID
+				})).Return(nil)
+				mockDB.On("CreateWebhookDeliveries", mock.Anything, mock.Anything).Return(nil)
+				mockDB.On("DeleteVerificationCode", mock.Anything, database.DeleteVerificationCodeParams{
+					UserID:  userID,
+					Purpose: database.PurposeEmailVerify,
+				}).Return(nil)
+			},
+			expectedError: false,
+		},
+		{
+			name: "successful verification with cached code",
+			request: &pb.VerifyEmailRequest{
+				Email:            "cached@example.com",
+				VerificationCode: 123456,
+			},
+			cachedCode: 123456,
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				// The user was verified by an earlier request, so there is no new user.verified event
+				mockDB.On("VerifyUser", mock.Anything, "cached@example.com").Return(uuid.Nil, sql.ErrNoRows)
+				mockDB.On("DeleteVerificationCodeByEmail", mock.Anything, database.DeleteVerificationCodeByEmailParams{
+					Purpose:        database.PurposeEmailVerify,
+					EmailCanonical: "cached@example.com",
+				}).Return(nil)
 			},
 			expectedError: false,
 		},
@@ -149,20 +256,20 @@ func TestVerifyEmail(t *testing.T) {
 			name: "user not found",
 			request: &pb.VerifyEmailRequest{
 				Email:            "notfound@example.com",
-				VerificationCode: 1234,
+				VerificationCode: 123456,
 			},
 			mockSetup: func(mockDB *mocks.MockQueries) {
-				mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(database.User{}, errors.New("user not found"))
+				mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(database.User{}, sql.ErrNoRows)
 			},
 			expectedError: true,
 			errorCode:     codes.NotFound,
-			errorMsg:      "can't get user with identifier",
+			errorReason:   autherr.ReasonUserNotFound,
 		},
 		{
 			name: "already verified",
 			request: &pb.VerifyEmailRequest{
 				Email:            "notfound@example.com",
-				VerificationCode: 1234,
+				VerificationCode: 123456,
 			},
 			mockSetup: func(mockDB *mocks.MockQueries) {
 				mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(database.User{
@@ -170,62 +277,121 @@ func TestVerifyEmail(t *testing.T) {
 				}, nil)
 			},
 			expectedError: true,
-			errorCode:     codes.AlreadyExists,
-			errorMsg:      "email already verified - VerifyEmail",
+			errorCode:     codes.FailedPrecondition,
+			errorReason:   autherr.ReasonEmailAlreadyVerified,
 		},
 		{
 			name: "invalid verification code",
 			request: &pb.VerifyEmailRequest{
 				Email:            "test@example.com",
-				VerificationCode: 5678, // Different code than what's in the database
+				VerificationCode: 654321, // Different code than what's in the database
 			},
 			mockSetup: func(mockDB *mocks.MockQueries) {
 				mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(database.User{
-					VerificationCode:       1234,
-					IsVerified:             false,
-					VerificationExpireTime: time.Now().Add(time.Hour),
+					ID: userID,
+				}, nil)
+				mockDB.On("GetVerificationCode", mock.Anything, mock.Anything).Return(database.VerificationCode{
+					CodeHash:  auth.HashVerificationCode(123456, secret),
+					ExpiresAt: time.Now().Add(time.Hour),
 				}, nil)
+				mockDB.On("IncrementVerificationCodeAttempts", mock.Anything, database.IncrementVerificationCodeAttemptsParams{
+					UserID:  userID,
+					Purpose: database.PurposeEmailVerify,
+				}).Return(int32(1), nil)
 			},
 			expectedError: true,
-			errorCode:     codes.Unauthenticated,
-			errorMsg:      "invalid verification code - VerifyEmail",
+			errorCode:     codes.InvalidArgument,
+			errorReason:   autherr.ReasonVerificationCodeInvalid,
+		},
+		{
+			name: "too many invalid attempts",
+			request: &pb.VerifyEmailRequest{
+				Email:            "test@example.com",
+				VerificationCode: 654321,
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(database.User{
+					ID: userID,
+				}, nil)
+				mockDB.On("GetVerificationCode", mock.Anything, mock.Anything).Return(database.VerificationCode{
+					CodeHash:  auth.HashVerificationCode(123456, secret),
+					ExpiresAt: time.Now().Add(time.Hour),
+					Attempts:  maxVerificationAttempts - 1,
+				}, nil)
+				mockDB.On("IncrementVerificationCodeAttempts", mock.Anything, mock.Anything).Return(int32(maxVerificationAttempts), nil)
+			},
+			expectedError: true,
+			errorCode:     codes.ResourceExhausted,
+			errorReason:   autherr.ReasonVerificationAttemptsExceeded,
+		},
+		{
+			name: "locked code is rejected even when correct",
+			request: &pb.VerifyEmailRequest{
+				Email:            "test@example.com",
+				VerificationCode: 123456,
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(database.User{
+					ID: userID,
+				}, nil)
+				mockDB.On("GetVerificationCode", mock.Anything, mock.Anything).Return(database.VerificationCode{
+					CodeHash:  auth.HashVerificationCode(123456, secret),
+					ExpiresAt: time.Now().Add(time.Hour),
+					Attempts:  maxVerificationAttempts,
+				}, nil)
+			},
+			expectedError: true,
+			errorCode:     codes.ResourceExhausted,
+			errorReason:   autherr.ReasonVerificationAttemptsExceeded,
 		},
 		{
 			name: "verification code expired",
 			request: &pb.VerifyEmailRequest{
 				Email:            "test@example.com",
-				VerificationCode: 1234,
+				VerificationCode: 123456,
 			},
 			mockSetup: func(mockDB *mocks.MockQueries) {
-				expectedParams := database.GetUserByIdentifierParams{
-					Email:    "test@example.com",
-					Username: "",
-				}
-				returnedUser := database.User{
-					ID:                     uuid.New(),
-					Email:                  "test@example.com",
-					Username:               "testuser",
-					VerificationCode:       1234,
-					IsVerified:             false,
-					VerificationExpireTime: time.Now().Add(-2 * time.Hour),
-				}
-				mockDB.On("GetUserByIdentifier", mock.Anything, expectedParams).Return(returnedUser, nil)
-
-				mockDB.On("GetUserByIdentifier", mock.Anything, expectedParams).Return(returnedUser, nil)
+				mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(database.User{
+					ID: userID,
+				}, nil)
+				mockDB.On("GetVerificationCode", mock.Anything, mock.Anything).Return(database.VerificationCode{
+					CodeHash:  auth.HashVerificationCode(123456, secret),
+					ExpiresAt: time.Now().Add(-2 * time.Hour),
+				}, nil)
+			},
+			expectedError: true,
+			errorCode:     codes.FailedPrecondition,
+			errorReason:   autherr.ReasonVerificationCodeExpired,
+		},
+		{
+			name: "no active verification code",
+			request: &pb.VerifyEmailRequest{
+				Email:            "test@example.com",
+				VerificationCode: 123456,
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(database.User{
+					ID: userID,
+				}, nil)
+				mockDB.On("GetVerificationCode", mock.Anything, mock.Anything).Return(database.VerificationCode{}, sql.ErrNoRows)
 			},
 			expectedError: true,
-			errorCode:     codes.DeadlineExceeded,
-			errorMsg:      "verification code expired",
+			errorCode:     codes.FailedPrecondition,
+			errorReason:   autherr.ReasonVerificationCodeExpired,
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			mockDB := new(mocks.MockQueries)
-			server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+			server := NewServer(mockDB, secret, "test@example.com", "email-secret")
 			ctx := context.Background()
 
 			tc.mockSetup(mockDB)
+			if tc.cachedCode != 0 {
+				err := redis.CacheVerificationCode(tc.request.Email, auth.HashVerificationCode(tc.cachedCode, secret), time.Minute)
+				assert.NoError(t, err)
+			}
 
 			response, err := server.VerifyEmail(ctx, tc.request)
 
@@ -234,13 +400,17 @@ func TestVerifyEmail(t *testing.T) {
 				statusErr, ok := status.FromError(err)
 				assert.True(t, ok)
 				assert.Equal(t, tc.errorCode, statusErr.Code())
-				assert.Contains(t, statusErr.Message(), tc.errorMsg)
+				assert.Equal(t, tc.errorReason, autherr.ReasonOf(err))
 				assert.Nil(t, response)
 			} else {
 				assert.NoError(t, err)
 				assert.NotNil(t, response)
 				assert.True(t, response.Success)
 				assert.Equal(t, "Email verified successfully", response.Message)
+
+				// The cached code can't be used twice
+				_, err := redis.GetVerificationCode(tc.request.Email)
+				assert.Error(t, err)
 			}
 			mockDB.AssertExpectations(t)
 		})
@@ -254,7 +424,7 @@ func TestLogin(t *testing.T) {
 		mockSetup     func(*mocks.MockQueries)
 		expectedError bool
 		errorCode     codes.Code
-		errorMsg      string
+		errorReason   autherr.Reason
 	}{
 		{
 			name: "successfully logged in",
@@ -270,8 +440,8 @@ func TestLogin(t *testing.T) {
 				assert.NoError(t, err)
 
 				expectedParams := database.GetUserByIdentifierParams{
-					Email:    "test@example.com",
-					Username: "test@example.com",
+					EmailCanonical: "test@example.com",
+					Username:       "test@example.com",
 				}
 				mockDB.On("GetUserByIdentifier", mock.Anything, expectedParams).Return(database.User{
 					ID:       userID,
@@ -280,8 +450,9 @@ func TestLogin(t *testing.T) {
 					Password: hashedPassword, // Use hashed password
 				}, nil)
 
+				mockDB.On("GetUserAuthorization", mock.Anything, userID).Return(database.GetUserAuthorizationRow{}, nil)
 				mockDB.On("RefreshToken", mock.Anything, mock.MatchedBy(func(arg database.RefreshTokenParams) bool {
-					return arg.UserID == userID
+					return arg.UserID == userID && arg.SessionID == auth.SessionID(arg.Token)
 				})).Return(database.RefreshToken{
 					Token:      "test-refresh-token",
 					UserID:     userID,
@@ -291,7 +462,30 @@ func TestLogin(t *testing.T) {
 			},
 			expectedError: false,
 			errorCode:     codes.OK,
-			errorMsg:      "",
+		},
+		{
+			name: "suspended user",
+			request: &pb.LoginRequest{
+				Identifier: "test@example.com",
+				Password:   "password123",
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				userID := uuid.New()
+				hashedPassword, err := auth.HashPassword("password123")
+				assert.NoError(t, err)
+
+				mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(database.User{
+					ID:       userID,
+					Email:    "test@example.com",
+					Password: hashedPassword,
+				}, nil)
+				mockDB.On("GetUserAuthorization", mock.Anything, userID).Return(database.GetUserAuthorizationRow{
+					Status: database.StatusSuspended,
+				}, nil)
+			},
+			expectedError: true,
+			errorCode:     codes.PermissionDenied,
+			errorReason:   autherr.ReasonAccountSuspended,
 		},
 		{
 			name: "user not found",
@@ -300,11 +494,40 @@ func TestLogin(t *testing.T) {
 				Password:   "password123",
 			},
 			mockSetup: func(mockDB *mocks.MockQueries) {
-				mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(database.User{}, errors.New("User not found"))
+				mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(database.User{}, sql.ErrNoRows)
 			},
 			expectedError: true,
-			errorCode:     codes.Internal,
-			errorMsg:      "can't get user with identifier - Login",
+			errorCode:     codes.Unauthenticated,
+			errorReason:   autherr.ReasonInvalidCredentials,
+		},
+		{
+			name: "wrong password",
+			request: &pb.LoginRequest{
+				Identifier: "test@example.com",
+				Password:   "wrong-password",
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				hashedPassword, err := auth.HashPassword("password123")
+				assert.NoError(t, err)
+
+				mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(database.User{
+					ID:       uuid.New(),
+					Password: hashedPassword,
+				}, nil)
+			},
+			expectedError: true,
+			errorCode:     codes.Unauthenticated,
+			errorReason:   autherr.ReasonInvalidCredentials,
+		},
+		{
+			name: "missing identifier",
+			request: &pb.LoginRequest{
+				Password: "password123",
+			},
+			mockSetup:     func(mockDB *mocks.MockQueries) {},
+			expectedError: true,
+			errorCode:     codes.InvalidArgument,
+			errorReason:   autherr.ReasonInvalidArgument,
 		},
 		{
 			name: "database error storing refresh token",
@@ -318,19 +541,20 @@ func TestLogin(t *testing.T) {
 				assert.NoError(t, err)
 
 				expectedUserParams := database.GetUserByIdentifierParams{
-					Email:    "test@example.com",
-					Username: "test@example.com",
+					EmailCanonical: "test@example.com",
+					Username:       "test@example.com",
 				}
 				mockDB.On("GetUserByIdentifier", mock.Anything, expectedUserParams).Return(database.User{
 					ID:       userID,
 					Password: hashedPassword,
 				}, nil)
 
+				mockDB.On("GetUserAuthorization", mock.Anything, userID).Return(database.GetUserAuthorizationRow{}, nil)
 				mockDB.On("RefreshToken", mock.Anything, mock.Anything).Return(database.RefreshToken{}, errors.New("database error"))
 			},
 			expectedError: true,
 			errorCode:     codes.Internal,
-			errorMsg:      "can't store refresh token - Login",
+			errorReason:   autherr.ReasonInternal,
 		},
 	}
 
@@ -349,7 +573,7 @@ func TestLogin(t *testing.T) {
 				statusErr, ok := status.FromError(err)
 				assert.True(t, ok)
 				assert.Equal(t, tc.errorCode, statusErr.Code())
-				assert.Contains(t, statusErr.Message(), tc.errorMsg)
+				assert.Equal(t, tc.errorReason, autherr.ReasonOf(err))
 				assert.Nil(t, response)
 			} else {
 				assert.NoError(t, err)
@@ -370,7 +594,7 @@ func TestLogout(t *testing.T) {
 		mockSetup     func(*mocks.MockQueries)
 		expectedError bool
 		errorCode     codes.Code
-		errorMsg      string
+		errorReason   autherr.Reason
 	}{
 		{
 			name: "successful logout",
@@ -378,11 +602,20 @@ func TestLogout(t *testing.T) {
 				RefreshToken: "test-logout",
 			},
 			mockSetup: func(mockDB *mocks.MockQueries) {
+				userID := uuid.New()
+				mockDB.On("GetRefreshToken", mock.Anything, "test-logout").Return(database.RefreshToken{UserID: userID}, nil)
 				mockDB.On("DeleteRefreshTokenByToken", mock.Anything, "test-logout").Return(nil)
+				mockDB.On("DeleteSessionDeviceTokens", mock.Anything, database.DeleteSessionDeviceTokensParams{
+					UserID:    userID,
+					SessionID: auth.SessionID("test-logout"),
+				}).Return(nil)
+				mockDB.On("CreateOutboxEvent", mock.Anything, mock.MatchedBy(func(arg database.CreateOutboxEventParams) bool {
+					return arg.EventType == outbox.UserLoggedOut && arg.UserID == userID
+				})).Return(nil)
+				mockDB.On("CreateWebhookDeliveries", mock.Anything, mock.Anything).Return(nil)
 			},
 			expectedError: false,
 			errorCode:     codes.OK,
-			errorMsg:      "successfully logged out",
 		},
 		{
 			name: "database error during logout",
@@ -390,11 +623,12 @@ func TestLogout(t *testing.T) {
 				RefreshToken: "test-logout",
 			},
 			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetRefreshToken", mock.Anything, "test-logout").Return(database.RefreshToken{}, sql.ErrNoRows)
 				mockDB.On("DeleteRefreshTokenByToken", mock.Anything, "test-logout").Return(errors.New("database error"))
 			},
 			expectedError: true,
 			errorCode:     codes.Internal,
-			errorMsg:      "can't delete token - Logout",
+			errorReason:   autherr.ReasonInternal,
 		},
 		{
 			name: "empty refresh token",
@@ -406,7 +640,7 @@ func TestLogout(t *testing.T) {
 			},
 			expectedError: true,
 			errorCode:     codes.InvalidArgument,
-			errorMsg:      "refresh token is required",
+			errorReason:   autherr.ReasonInvalidArgument,
 		},
 	}
 
@@ -425,13 +659,13 @@ func TestLogout(t *testing.T) {
 				statusErr, ok := status.FromError(err)
 				assert.True(t, ok)
 				assert.Equal(t, tc.errorCode, statusErr.Code())
-				assert.Contains(t, statusErr.Message(), tc.errorMsg)
+				assert.Equal(t, tc.errorReason, autherr.ReasonOf(err))
 				assert.Nil(t, response)
 			} else {
 				assert.NoError(t, err)
 				assert.NotNil(t, response)
 				assert.True(t, response.Success)
-				assert.Equal(t, "successfully logged out", tc.errorMsg)
+				assert.Equal(t, "User logged out complete", response.Message)
 			}
 			mockDB.AssertExpectations(t)
 		})
@@ -445,7 +679,7 @@ func TestRefreshToken(t *testing.T) {
 		mockSetup     func(*mocks.MockQueries)
 		expectedError bool
 		errorCode     codes.Code
-		errorMsg      string
+		errorReason   autherr.Reason
 	}{
 		{
 			name: "successfully refreshed token",
@@ -454,15 +688,30 @@ func TestRefreshToken(t *testing.T) {
 			},
 			mockSetup: func(mockDB *mocks.MockQueries) {
 				userID := uuid.New()
+				expiry := time.Now().Add(time.Hour * 7 * 24)
 				mockDB.On("GetRefreshToken", mock.Anything, "test-refresh-token").Return(database.RefreshToken{
 					Token:      "test-refresh-token",
 					UserID:     userID,
-					ExpiryTime: time.Now().Add(time.Hour * 7 * 24),
+					ExpiryTime: expiry,
 					CreatedAt:  time.Now(),
+					FamilyID:   "family-1",
 				}, nil)
 
-				mockDB.On("DeleteTokenByUserID", mock.Anything, userID).Return(nil)
-				mockDB.On("RefreshToken", mock.Anything, mock.Anything).Return(database.RefreshToken{
+				mockDB.On("DeleteRefreshTokenByToken", mock.Anything, "test-refresh-token").Return(nil)
+				mockDB.On("RememberRotatedRefreshToken", mock.Anything, database.RememberRotatedRefreshTokenParams{
+					TokenHash: auth.HashRefreshToken("test-refresh-token"),
+					UserID:    userID,
+					FamilyID:  "family-1",
+					ExpiresAt: expiry,
+				}).Return(nil)
+				mockDB.On("MoveSessionDeviceTokens", mock.Anything, mock.MatchedBy(func(arg database.MoveSessionDeviceTokensParams) bool {
+					return arg.UserID == userID && arg.OldSessionID == auth.SessionID("test-refresh-token") &&
+						arg.NewSessionID != "" && arg.NewSessionID != arg.OldSessionID
+				})).Return(nil)
+				mockDB.On("GetUserAuthorization", mock.Anything, userID).Return(database.GetUserAuthorizationRow{}, nil)
+				mockDB.On("RefreshToken", mock.Anything, mock.MatchedBy(func(arg database.RefreshTokenParams) bool {
+					return arg.UserID == userID && arg.SessionID == auth.SessionID(arg.Token) && arg.FamilyID == "family-1"
+				})).Return(database.RefreshToken{
 					Token:      "new-refresh-token",
 					UserID:     userID,
 					ExpiryTime: time.Now().Add(time.Hour * 7 * 24),
@@ -471,7 +720,6 @@ func TestRefreshToken(t *testing.T) {
 			},
 			expectedError: false,
 			errorCode:     codes.OK,
-			errorMsg:      "",
 		},
 		{
 			name: "can't get token from database",
@@ -479,11 +727,40 @@ func TestRefreshToken(t *testing.T) {
 				RefreshToken: "wrong-token",
 			},
 			mockSetup: func(mockDB *mocks.MockQueries) {
-				mockDB.On("GetRefreshToken", mock.Anything, "wrong-token").Return(database.RefreshToken{}, errors.New("token not found"))
+				mockDB.On("GetRefreshToken", mock.Anything, "wrong-token").Return(database.RefreshToken{}, sql.ErrNoRows)
+				mockDB.On("GetRotatedRefreshToken", mock.Anything, auth.HashRefreshToken("wrong-token")).
+					Return(database.RotatedRefreshToken{}, sql.ErrNoRows)
 			},
 			expectedError: true,
-			errorCode:     codes.InvalidArgument,
-			errorMsg:      "can't get refresh token - RefreshToken",
+			errorCode:     codes.Unauthenticated,
+			errorReason:   autherr.ReasonRefreshTokenInvalid,
+		},
+		{
+			name: "rotated token presented again",
+			request: &pb.RefreshTokenRequest{
+				RefreshToken: "rotated-token",
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				userID := uuid.New()
+				mockDB.On("GetRefreshToken", mock.Anything, "rotated-token").Return(database.RefreshToken{}, sql.ErrNoRows)
+				mockDB.On("GetRotatedRefreshToken", mock.Anything, auth.HashRefreshToken("rotated-token")).
+					Return(database.RotatedRefreshToken{UserID: userID, FamilyID: "family-1"}, nil)
+				mockDB.On("DeleteRefreshTokenFamily", mock.Anything, database.DeleteRefreshTokenFamilyParams{
+					UserID:   userID,
+					FamilyID: "family-1",
+				}).Return([]string{"session-2"}, nil)
+				mockDB.On("DeleteSessionDeviceTokens", mock.Anything, database.DeleteSessionDeviceTokensParams{
+					UserID:    userID,
+					SessionID: "session-2",
+				}).Return(nil)
+				mockDB.On("CreateOutboxEvent", mock.Anything, mock.MatchedBy(func(arg database.CreateOutboxEventParams) bool {
+					return arg.EventType == outbox.UserLoggedOut && arg.UserID == userID
+				})).Return(nil)
+				mockDB.On("CreateWebhookDeliveries", mock.Anything, mock.Anything).Return(nil)
+			},
+			expectedError: true,
+			errorCode:     codes.Unauthenticated,
+			errorReason:   autherr.ReasonRefreshTokenInvalid,
 		},
 		{
 			name: "empty refresh token",
@@ -495,7 +772,7 @@ func TestRefreshToken(t *testing.T) {
 			},
 			expectedError: true,
 			errorCode:     codes.InvalidArgument,
-			errorMsg:      "refresh token is required",
+			errorReason:   autherr.ReasonInvalidArgument,
 		},
 		{
 			name: "expired refresh token",
@@ -512,8 +789,8 @@ func TestRefreshToken(t *testing.T) {
 				}, nil)
 			},
 			expectedError: true,
-			errorCode:     codes.DeadlineExceeded,
-			errorMsg:      "refresh token expired - RefreshToken",
+			errorCode:     codes.Unauthenticated,
+			errorReason:   autherr.ReasonRefreshTokenExpired,
 		},
 		{
 			name: "error deleting old token",
@@ -529,11 +806,12 @@ func TestRefreshToken(t *testing.T) {
 					CreatedAt:  time.Now(),
 				}, nil)
 
-				mockDB.On("DeleteTokenByUserID", mock.Anything, userID).Return(errors.New("database error"))
+				mockDB.On("GetUserAuthorization", mock.Anything, userID).Return(database.GetUserAuthorizationRow{}, nil)
+				mockDB.On("DeleteRefreshTokenByToken", mock.Anything, "valid-token").Return(errors.New("database error"))
 			},
 			expectedError: true,
 			errorCode:     codes.Internal,
-			errorMsg:      "can't delete old token - RefreshToken",
+			errorReason:   autherr.ReasonInternal,
 		},
 		{
 			name: "error storing new refresh token",
@@ -549,12 +827,14 @@ func TestRefreshToken(t *testing.T) {
 					CreatedAt:  time.Now(),
 				}, nil)
 
-				mockDB.On("DeleteTokenByUserID", mock.Anything, userID).Return(nil)
+				mockDB.On("DeleteRefreshTokenByToken", mock.Anything, "valid-token").Return(nil)
+				mockDB.On("RememberRotatedRefreshToken", mock.Anything, mock.Anything).Return(nil)
+				mockDB.On("GetUserAuthorization", mock.Anything, userID).Return(database.GetUserAuthorizationRow{}, nil)
 				mockDB.On("RefreshToken", mock.Anything, mock.Anything).Return(database.RefreshToken{}, errors.New("database error"))
 			},
 			expectedError: true,
 			errorCode:     codes.Internal,
-			errorMsg:      "can't store refresh token - RefreshToken",
+			errorReason:   autherr.ReasonInternal,
 		},
 	}
 
@@ -573,7 +853,7 @@ func TestRefreshToken(t *testing.T) {
 				statusErr, ok := status.FromError(err)
 				assert.True(t, ok)
 				assert.Equal(t, tc.errorCode, statusErr.Code())
-				assert.Contains(t, statusErr.Message(), tc.errorMsg)
+				assert.Equal(t, tc.errorReason, autherr.ReasonOf(err))
 				assert.Nil(t, response)
 			} else {
 				assert.NotNil(t, response)
@@ -593,7 +873,7 @@ func TestSendVerificationCode(t *testing.T) {
 		mockSetup     func(*mocks.MockQueries)
 		expectedError bool
 		errorCode     codes.Code
-		errorMsg      string
+		errorReason   autherr.Reason
 	}{
 		{
 			name: "successfully send verification code",
@@ -603,12 +883,14 @@ func TestSendVerificationCode(t *testing.T) {
 			mockSetup: func(mockDB *mocks.MockQueries) {
 				userID := uuid.New()
 				mockDB.On("GetUserByIdentifier", mock.Anything, mock.MatchedBy(func(arg database.GetUserByIdentifierParams) bool {
-					return arg.Email == "test@example.com"
+					return arg.EmailCanonical == "test@example.com"
 				})).Return(database.User{
 					ID:    userID,
 					Email: "test@example.com",
 				}, nil)
-				mockDB.On("SendVerifyCodeAgain", mock.Anything, mock.Anything).Return(nil)
+				mockDB.On("UpsertVerificationCode", mock.Anything, mock.MatchedBy(func(arg database.UpsertVerificationCodeParams) bool {
+					return arg.UserID == userID && arg.Purpose == database.PurposeEmailVerify
+				})).Return(database.VerificationCode{}, nil)
 			},
 			expectedError: false,
 		},
@@ -618,14 +900,30 @@ func TestSendVerificationCode(t *testing.T) {
 				Email: "notfound@example.com",
 			},
 			mockSetup: func(mockDB *mocks.MockQueries) {
-				mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(database.User{}, errors.New("user not found"))
+				mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(database.User{}, sql.ErrNoRows)
 			},
 			expectedError: true,
-			errorCode:     codes.Internal,
-			errorMsg:      "can't get user with identifier - SendVerifyCodeAgain",
+			errorCode:     codes.NotFound,
+			errorReason:   autherr.ReasonUserNotFound,
+		},
+		{
+			name: "email already verified",
+			request: &pb.SendVerifyCodeRequest{
+				Email: "test@example.com",
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(database.User{
+					ID:         uuid.New(),
+					Email:      "test@example.com",
+					IsVerified: true,
+				}, nil)
+			},
+			expectedError: true,
+			errorCode:     codes.FailedPrecondition,
+			errorReason:   autherr.ReasonEmailAlreadyVerified,
 		},
 		{
-			name: "db error on SendVerifyCodeAgain",
+			name: "db error storing verification code",
 			request: &pb.SendVerifyCodeRequest{
 				Email: "test@example.com",
 			},
@@ -635,11 +933,11 @@ func TestSendVerificationCode(t *testing.T) {
 					ID:    userID,
 					Email: "test@example.com",
 				}, nil)
-				mockDB.On("SendVerifyCodeAgain", mock.Anything, mock.Anything).Return(errors.New("db error"))
+				mockDB.On("UpsertVerificationCode", mock.Anything, mock.Anything).Return(database.VerificationCode{}, errors.New("db error"))
 			},
 			expectedError: true,
 			errorCode:     codes.Internal,
-			errorMsg:      "failed to send verification code again - SendVerifyCodeAgain",
+			errorReason:   autherr.ReasonInternal,
 		},
 	}
 
@@ -659,7 +957,7 @@ func TestSendVerificationCode(t *testing.T) {
 				statusErr, ok := status.FromError(err)
 				assert.True(t, ok)
 				assert.Equal(t, tc.errorCode, statusErr.Code())
-				assert.Contains(t, statusErr.Message(), tc.errorMsg)
+				assert.Equal(t, tc.errorReason, autherr.ReasonOf(err))
 				assert.Nil(t, response)
 			} else {
 				assert.NoError(t, err)
@@ -670,4 +968,4 @@ func TestSendVerificationCode(t *testing.T) {
 			mockDB.AssertExpectations(t)
 		})
 	}
-} 
+} 
\ No newline at end of file
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/cmd/authz"
+	"github.com/imhasandl/auth-service/internal/autherr"
+)
+
+// authenticatedUser returns the user whose access token was sent in the "authorization" metadata
+// as "Bearer <token>". Tokens of users who were suspended after the token was issued are refused too
+func (s *Server) authenticatedUser(ctx context.Context) (uuid.UUID, error) {
+	token, ok := authz.BearerToken(ctx)
+	if !ok {
+		return uuid.Nil, autherr.AccessTokenInvalid()
+	}
+
+	userID, err := auth.ValidateJWT(token, s.tokenSecret)
+	if err != nil {
+		return uuid.Nil, autherr.AccessTokenInvalid().WithCause(err)
+	}
+
+	if err := s.CheckUserStatus(ctx, userID); err != nil {
+		return uuid.Nil, err
+	}
+	return userID, nil
+}
+
+// CheckUserStatus fails when the user no longer exists or may not log in, so their access tokens stop working
+// before they expire. main passes it to the authz interceptor as its user check
+func (s *Server) CheckUserStatus(ctx context.Context, userID uuid.UUID) error {
+	status, err := s.db.GetUserStatus(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return autherr.AccessTokenInvalid().WithCause(err)
+		}
+		return err
+	}
+	return checkAccountStatus(status.Status, status.StatusUntil)
+}
+
+// principalSubject returns the subject of the caller's token, or "" for calls that didn't carry one
+func principalSubject(ctx context.Context) string {
+	principal, _ := authz.FromContext(ctx)
+	return principal.Subject
+}
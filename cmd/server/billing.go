@@ -0,0 +1,184 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/internal/billing"
+	"github.com/imhasandl/auth-service/internal/database"
+)
+
+const (
+	// maxBillingEventSize limits the body of billing webhook requests. Subscription events are a few kilobytes
+	maxBillingEventSize = 1 << 20
+	// billingGracePeriod keeps a subscription's entitlement past the end of its billing period, until the provider
+	// reports the renewal
+	billingGracePeriod = 24 * time.Hour
+)
+
+// BillingWebhookHandler receives the webhook events of the billing provider and keeps the entitlements of
+// subscriptions up to date. Events that were applied before, older than the last applied event of their
+// subscription, or of other types are acknowledged without changing anything. Failures are answered with a 500 so
+// the provider sends the event again
+func (s *Server) BillingWebhookHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if s.billingSecret == "" {
+			http.Error(w, "billing webhooks are not enabled", http.StatusNotFound)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxBillingEventSize+1))
+		if err != nil {
+			http.Error(w, "could not read the request", http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxBillingEventSize {
+			http.Error(w, "event too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		event, err := billing.ParseEvent(body, r.Header.Get(billing.SignatureHeader), s.billingSecret, time.Now())
+		if err != nil {
+			if errors.Is(err, billing.ErrMalformedEvent) {
+				http.Error(w, "malformed event", http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
+		var subscription *billing.Subscription
+		if event.IsSubscriptionEvent() {
+			parsed, err := event.Subscription()
+			if err != nil {
+				http.Error(w, "malformed event", http.StatusBadRequest)
+				return
+			}
+			subscription = &parsed
+		}
+
+		if err := s.applyBillingEvent(r.Context(), event, subscription); err != nil {
+			log.Printf("Error applying billing event %s: %v", event.ID, err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// applyBillingEvent records the event, so it is applied only once, and updates the entitlement of its
+// subscription. subscription is nil for events that aren't about one. Events that can't be applied yet, like those
+// of an unknown plan, are skipped without being recorded, so a redelivery is looked at again
+func (s *Server) applyBillingEvent(ctx context.Context, event billing.Event, subscription *billing.Subscription) error {
+	return s.db.WithTx(ctx, func(q DBQuerier) error {
+		var (
+			params database.UpsertBillingEntitlementParams
+			apply  bool
+		)
+		if subscription != nil {
+			params, apply = s.billingEntitlement(event, *subscription)
+		}
+		if apply {
+			skip, err := skipBillingEntitlement(ctx, q, event, params)
+			if err != nil || skip {
+				return err
+			}
+		}
+
+		recorded, err := q.RecordBillingEvent(ctx, database.RecordBillingEventParams{ID: event.ID, Type: event.Type})
+		if err != nil {
+			return err
+		}
+		if recorded == 0 || !apply {
+			return nil
+		}
+
+		if _, err := q.UpsertBillingEntitlement(ctx, params); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				// A newer event of the subscription was applied already
+				return nil
+			}
+			return err
+		}
+		_, err = q.SyncPremiumUsers(ctx, uuid.NullUUID{UUID: params.UserID, Valid: true})
+		return err
+	})
+}
+
+// skipBillingEntitlement reports whether the entitlement of an event can't be applied, because the user or the
+// plan doesn't exist or the account was purged. Accounts waiting for their deletion have the deleted status too,
+// but keep their account_deletions row and get their entitlements in case the deletion is cancelled
+func skipBillingEntitlement(ctx context.Context, q DBQuerier, event billing.Event, params database.UpsertBillingEntitlementParams) (bool, error) {
+	user, err := q.GetUserByID(ctx, params.UserID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Ignoring billing event %s: user %s not found", event.ID, params.UserID)
+			return true, nil
+		}
+		return false, err
+	}
+	if user.Status == database.StatusDeleted {
+		scheduled, err := q.AccountDeletionScheduled(ctx, user.ID)
+		if err != nil {
+			return false, err
+		}
+		if !scheduled {
+			// The purge removed the entitlements of the account, a late event must not bring them back
+			log.Printf("Ignoring billing event %s: user %s is deleted", event.ID, params.UserID)
+			return true, nil
+		}
+	}
+	if _, err := q.GetPlan(ctx, params.PlanID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Ignoring billing event %s: plan %s not found", event.ID, params.PlanID)
+			return true, nil
+		}
+		return false, err
+	}
+	return false, nil
+}
+
+// billingEntitlement turns a subscription into the entitlement it gives. ok is false for subscriptions without a
+// user ID or a price mapped to a plan, which are logged and ignored
+func (s *Server) billingEntitlement(event billing.Event, subscription billing.Subscription) (database.UpsertBillingEntitlementParams, bool) {
+	userID, err := uuid.Parse(subscription.UserID())
+	if err != nil {
+		log.Printf("Ignoring billing event %s: subscription %s has no %s metadata", event.ID, subscription.ID, billing.UserIDMetadataKey)
+		return database.UpsertBillingEntitlementParams{}, false
+	}
+	var planID string
+	for _, priceID := range subscription.PriceIDs() {
+		if plan, ok := s.billingPricePlans[priceID]; ok {
+			planID = plan
+			break
+		}
+	}
+	if planID == "" {
+		log.Printf("Ignoring billing event %s: no plan for the prices %v of subscription %s", event.ID, subscription.PriceIDs(), subscription.ID)
+		return database.UpsertBillingEntitlementParams{}, false
+	}
+
+	params := database.UpsertBillingEntitlementParams{
+		ID:              uuid.New(),
+		UserID:          userID,
+		PlanID:          planID,
+		ExternalID:      sql.NullString{String: subscription.ID, Valid: true},
+		StartsAt:        subscription.PeriodStart(),
+		ExpiresAt:       sql.NullTime{Time: subscription.PeriodEnd().Add(billingGracePeriod), Valid: true},
+		SourceUpdatedAt: sql.NullTime{Time: event.CreatedAt(), Valid: true},
+	}
+	if event.Type == billing.EventSubscriptionDeleted || !subscription.Entitled() {
+		params.RevokedAt = sql.NullTime{Time: event.CreatedAt(), Valid: true}
+	}
+	return params, true
+}
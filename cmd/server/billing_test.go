@@ -0,0 +1,279 @@
+package server
+
+import (
+	"bytes"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/internal/billing"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/database/mocks"
+	"github.com/imhasandl/auth-service/internal/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// billingFixtureUser is the user_id in the metadata of the subscription fixtures
+var billingFixtureUser = uuid.MustParse("7d1d5b0e-4c55-4e0f-9a43-2f7d0b6a1c11")
+
+func billingFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("..", "..", "internal", "billing", "testdata", name))
+	require.NoError(t, err)
+	return body
+}
+
+func postBillingEvent(t *testing.T, server *Server, body []byte, signature string) int {
+	t.Helper()
+	request := httptest.NewRequest(http.MethodPost, "/billing/webhook", bytes.NewReader(body))
+	request.Header.Set(billing.SignatureHeader, signature)
+	recorder := httptest.NewRecorder()
+	server.BillingWebhookHandler().ServeHTTP(recorder, request)
+	return recorder.Code
+}
+
+func newBillingServer(mockDB *mocks.MockQueries) *Server {
+	return NewServer(mockDB, "test-secret", "test@example.com", "email-secret",
+		WithBilling("whsec_billing", map[string]string{"price_premium_monthly": "premium"}))
+}
+
+func TestBillingWebhookHandler(t *testing.T) {
+	periodEnd := func(year int, month time.Month) sql.NullTime {
+		return sql.NullTime{Time: time.Date(year, month, 1, 0, 0, 0, 0, time.UTC).Add(billingGracePeriod), Valid: true}
+	}
+
+	testCases := []struct {
+		fixture string
+		want    database.UpsertBillingEntitlementParams
+	}{
+		{
+			fixture: "subscription_created.json",
+			want: database.UpsertBillingEntitlementParams{
+				StartsAt:        time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+				ExpiresAt:       periodEnd(2026, 2),
+				SourceUpdatedAt: sql.NullTime{Time: time.Unix(1767225600, 0).UTC(), Valid: true},
+			},
+		},
+		{
+			fixture: "subscription_past_due.json",
+			want: database.UpsertBillingEntitlementParams{
+				StartsAt:        time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+				ExpiresAt:       periodEnd(2026, 3),
+				SourceUpdatedAt: sql.NullTime{Time: time.Unix(1769990400, 0).UTC(), Valid: true},
+			},
+		},
+		{
+			fixture: "subscription_deleted.json",
+			want: database.UpsertBillingEntitlementParams{
+				StartsAt:        time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+				ExpiresAt:       periodEnd(2026, 3),
+				RevokedAt:       sql.NullTime{Time: time.Unix(1770595200, 0).UTC(), Valid: true},
+				SourceUpdatedAt: sql.NullTime{Time: time.Unix(1770595200, 0).UTC(), Valid: true},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.fixture, func(t *testing.T) {
+			mockDB := new(mocks.MockQueries)
+			server := newBillingServer(mockDB)
+			body := billingFixture(t, tc.fixture)
+			var upserted database.UpsertBillingEntitlementParams
+			mockDB.On("RecordBillingEvent", mock.Anything, mock.Anything).Return(int64(1), nil)
+			mockDB.On("GetUserByID", mock.Anything, billingFixtureUser).Return(database.User{ID: billingFixtureUser}, nil)
+			mockDB.On("GetPlan", mock.Anything, "premium").Return(database.Plan{ID: "premium", Premium: true}, nil)
+			mockDB.On("UpsertBillingEntitlement", mock.Anything, mock.Anything).
+				Run(func(args mock.Arguments) { upserted = args.Get(1).(database.UpsertBillingEntitlementParams) }).
+				Return(database.UserEntitlement{}, nil)
+			mockDB.On("SyncPremiumUsers", mock.Anything, uuid.NullUUID{UUID: billingFixtureUser, Valid: true}).
+				Return([]database.SyncPremiumUsersRow{}, nil)
+
+			code := postBillingEvent(t, server, body, webhook.Sign("whsec_billing", time.Now(), body))
+
+			assert.Equal(t, http.StatusOK, code)
+			assert.NotEqual(t, uuid.Nil, upserted.ID)
+			assert.Equal(t, billingFixtureUser, upserted.UserID)
+			assert.Equal(t, "premium", upserted.PlanID)
+			assert.Equal(t, sql.NullString{String: "sub_1QpremiumA1b2C3", Valid: true}, upserted.ExternalID)
+			assert.Equal(t, tc.want.StartsAt, upserted.StartsAt)
+			assert.Equal(t, tc.want.ExpiresAt, upserted.ExpiresAt)
+			assert.Equal(t, tc.want.RevokedAt, upserted.RevokedAt)
+			assert.Equal(t, tc.want.SourceUpdatedAt, upserted.SourceUpdatedAt)
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestBillingWebhookHandlerIgnoredEvents(t *testing.T) {
+	t.Run("duplicate event", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		server := newBillingServer(mockDB)
+		body := billingFixture(t, "subscription_created.json")
+		mockDB.On("RecordBillingEvent", mock.Anything, database.RecordBillingEventParams{
+			ID:   "evt_1QcreatedA1b2C3",
+			Type: billing.EventSubscriptionCreated,
+		}).Return(int64(0), nil)
+		mockDB.On("GetUserByID", mock.Anything, billingFixtureUser).Return(database.User{ID: billingFixtureUser}, nil)
+		mockDB.On("GetPlan", mock.Anything, "premium").Return(database.Plan{ID: "premium", Premium: true}, nil)
+
+		code := postBillingEvent(t, server, body, webhook.Sign("whsec_billing", time.Now(), body))
+
+		assert.Equal(t, http.StatusOK, code)
+		mockDB.AssertNotCalled(t, "UpsertBillingEntitlement", mock.Anything, mock.Anything)
+	})
+
+	t.Run("other event type", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		server := newBillingServer(mockDB)
+		body := billingFixture(t, "invoice_paid.json")
+		mockDB.On("RecordBillingEvent", mock.Anything, mock.Anything).Return(int64(1), nil)
+
+		code := postBillingEvent(t, server, body, webhook.Sign("whsec_billing", time.Now(), body))
+
+		assert.Equal(t, http.StatusOK, code)
+		mockDB.AssertNotCalled(t, "UpsertBillingEntitlement", mock.Anything, mock.Anything)
+	})
+
+	t.Run("price without a plan", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret",
+			WithBilling("whsec_billing", map[string]string{"price_team_yearly": "team"}))
+		body := billingFixture(t, "subscription_created.json")
+		mockDB.On("RecordBillingEvent", mock.Anything, mock.Anything).Return(int64(1), nil)
+
+		code := postBillingEvent(t, server, body, webhook.Sign("whsec_billing", time.Now(), body))
+
+		assert.Equal(t, http.StatusOK, code)
+		mockDB.AssertNotCalled(t, "UpsertBillingEntitlement", mock.Anything, mock.Anything)
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		server := newBillingServer(mockDB)
+		body := billingFixture(t, "subscription_created.json")
+		mockDB.On("GetUserByID", mock.Anything, billingFixtureUser).Return(database.User{}, sql.ErrNoRows)
+
+		code := postBillingEvent(t, server, body, webhook.Sign("whsec_billing", time.Now(), body))
+
+		assert.Equal(t, http.StatusOK, code)
+		mockDB.AssertNotCalled(t, "RecordBillingEvent", mock.Anything, mock.Anything)
+		mockDB.AssertNotCalled(t, "UpsertBillingEntitlement", mock.Anything, mock.Anything)
+	})
+
+	t.Run("unknown plan", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		server := newBillingServer(mockDB)
+		body := billingFixture(t, "subscription_created.json")
+		mockDB.On("GetUserByID", mock.Anything, billingFixtureUser).Return(database.User{ID: billingFixtureUser}, nil)
+		mockDB.On("GetPlan", mock.Anything, "premium").Return(database.Plan{}, sql.ErrNoRows)
+
+		code := postBillingEvent(t, server, body, webhook.Sign("whsec_billing", time.Now(), body))
+
+		assert.Equal(t, http.StatusOK, code)
+		mockDB.AssertNotCalled(t, "RecordBillingEvent", mock.Anything, mock.Anything)
+		mockDB.AssertNotCalled(t, "UpsertBillingEntitlement", mock.Anything, mock.Anything)
+	})
+
+	t.Run("purged user", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		server := newBillingServer(mockDB)
+		body := billingFixture(t, "subscription_created.json")
+		mockDB.On("GetUserByID", mock.Anything, billingFixtureUser).
+			Return(database.User{ID: billingFixtureUser, Status: database.StatusDeleted}, nil)
+		mockDB.On("AccountDeletionScheduled", mock.Anything, billingFixtureUser).Return(false, nil)
+
+		code := postBillingEvent(t, server, body, webhook.Sign("whsec_billing", time.Now(), body))
+
+		assert.Equal(t, http.StatusOK, code)
+		mockDB.AssertNotCalled(t, "RecordBillingEvent", mock.Anything, mock.Anything)
+		mockDB.AssertNotCalled(t, "UpsertBillingEntitlement", mock.Anything, mock.Anything)
+		mockDB.AssertNotCalled(t, "SyncPremiumUsers", mock.Anything, mock.Anything)
+	})
+
+	t.Run("user waiting for deletion", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		server := newBillingServer(mockDB)
+		body := billingFixture(t, "subscription_created.json")
+		mockDB.On("GetUserByID", mock.Anything, billingFixtureUser).
+			Return(database.User{ID: billingFixtureUser, Status: database.StatusDeleted}, nil)
+		mockDB.On("AccountDeletionScheduled", mock.Anything, billingFixtureUser).Return(true, nil)
+		mockDB.On("GetPlan", mock.Anything, "premium").Return(database.Plan{ID: "premium", Premium: true}, nil)
+		mockDB.On("RecordBillingEvent", mock.Anything, mock.Anything).Return(int64(1), nil)
+		mockDB.On("UpsertBillingEntitlement", mock.Anything, mock.Anything).Return(database.UserEntitlement{}, nil)
+		mockDB.On("SyncPremiumUsers", mock.Anything, uuid.NullUUID{UUID: billingFixtureUser, Valid: true}).
+			Return([]database.SyncPremiumUsersRow{}, nil)
+
+		code := postBillingEvent(t, server, body, webhook.Sign("whsec_billing", time.Now(), body))
+
+		// The entitlement is kept up to date in case the deletion is cancelled
+		assert.Equal(t, http.StatusOK, code)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("older than the last applied event", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		server := newBillingServer(mockDB)
+		body := billingFixture(t, "subscription_past_due.json")
+		mockDB.On("RecordBillingEvent", mock.Anything, mock.Anything).Return(int64(1), nil)
+		mockDB.On("GetUserByID", mock.Anything, billingFixtureUser).Return(database.User{ID: billingFixtureUser}, nil)
+		mockDB.On("GetPlan", mock.Anything, "premium").Return(database.Plan{ID: "premium", Premium: true}, nil)
+		mockDB.On("UpsertBillingEntitlement", mock.Anything, mock.Anything).Return(database.UserEntitlement{}, sql.ErrNoRows)
+
+		code := postBillingEvent(t, server, body, webhook.Sign("whsec_billing", time.Now(), body))
+
+		assert.Equal(t, http.StatusOK, code)
+		mockDB.AssertNotCalled(t, "SyncPremiumUsers", mock.Anything, mock.Anything)
+	})
+}
+
+func TestBillingWebhookHandlerRejects(t *testing.T) {
+	body := []byte(`{"id":"evt_1","type":"customer.subscription.created","data":{"object":{"status":"active"}}}`)
+
+	testCases := []struct {
+		name      string
+		body      []byte
+		signature string
+		status    int
+	}{
+		{name: "unsigned", body: body, status: http.StatusUnauthorized},
+		{name: "wrong secret", body: body, signature: webhook.Sign("whsec_other", time.Now(), body), status: http.StatusUnauthorized},
+		{name: "replayed", body: body, signature: webhook.Sign("whsec_billing", time.Now().Add(-time.Hour), body), status: http.StatusUnauthorized},
+		{name: "subscription without id", body: body, signature: webhook.Sign("whsec_billing", time.Now(), body), status: http.StatusBadRequest},
+		{name: "not an event", body: []byte("[]"), signature: webhook.Sign("whsec_billing", time.Now(), []byte("[]")), status: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB := new(mocks.MockQueries)
+			server := newBillingServer(mockDB)
+
+			code := postBillingEvent(t, server, tc.body, tc.signature)
+
+			assert.Equal(t, tc.status, code)
+			mockDB.AssertNotCalled(t, "RecordBillingEvent", mock.Anything, mock.Anything)
+		})
+	}
+
+	t.Run("not enabled", func(t *testing.T) {
+		server := NewServer(new(mocks.MockQueries), "test-secret", "test@example.com", "email-secret")
+
+		code := postBillingEvent(t, server, body, webhook.Sign("", time.Now(), body))
+
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+
+	t.Run("get", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		newBillingServer(new(mocks.MockQueries)).BillingWebhookHandler().
+			ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/billing/webhook", nil))
+
+		assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+	})
+}
@@ -0,0 +1,513 @@
+package server
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/cmd/helper"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	pb "github.com/imhasandl/auth-service/protos"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// dataExportBatchSize is how many exports one run of ProcessDataExports builds, and how many expired
+	// archives one run of PurgeExpiredDataExports deletes
+	dataExportBatchSize = 10
+	// dataExportStaleAfter is how long an export can be building before its job is considered dead and
+	// another one takes over
+	dataExportStaleAfter = 15 * time.Minute
+	// maxDataExportAttempts is how often building an export is started before it is given up
+	maxDataExportAttempts = 3
+	// dataExportEventPageSize is how many security events are read at a time while building an archive
+	dataExportEventPageSize = 500
+)
+
+// RequestDataExport starts building an archive of the data kept about the logged in user. The archive is built in
+// the background. Poll GetDataExport until it is ready. While an export is waiting or being built, requesting
+// another one returns it. Requests count against the login rate limit.
+func (s *Server) RequestDataExport(ctx context.Context, req *pb.RequestDataExportRequest) (*pb.RequestDataExportResponse, error) {
+	userID, err := s.authenticatedUser(ctx)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	if s.dataExportStore == nil {
+		return nil, helper.RespondWithError(ctx, autherr.DataExportsDisabled())
+	}
+
+	if err := s.checkRateLimit("data_export:" + userID.String()); err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	export, err := s.db.CreateDataExport(ctx, database.CreateDataExportParams{
+		ID:     uuid.New(),
+		UserID: userID,
+	})
+	switch {
+	case database.IsUniqueViolation(err, "data_exports_active_user_key"):
+		export, err = s.db.GetActiveDataExport(ctx, userID)
+		if err != nil {
+			return nil, helper.RespondWithError(ctx, err)
+		}
+	case err != nil:
+		return nil, helper.RespondWithError(ctx, err)
+	default:
+		s.recordEvent(ctx, AuthEvent{
+			Type:    EventDataExportRequested,
+			UserID:  userID,
+			Details: map[string]string{"export_id": export.ID.String()},
+		})
+	}
+
+	return &pb.RequestDataExportResponse{Export: dataExportToPB(export, "")}, nil
+}
+
+// GetDataExport returns a data export of the logged in user. Once the archive is ready, the response has a
+// download URL that works for a few minutes. Call again for a new one.
+func (s *Server) GetDataExport(ctx context.Context, req *pb.GetDataExportRequest) (*pb.GetDataExportResponse, error) {
+	userID, err := s.authenticatedUser(ctx)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	exportID, err := uuid.Parse(req.GetId())
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, autherr.InvalidArgument("id", "id should be a UUID"))
+	}
+
+	export, err := s.db.GetDataExport(ctx, database.GetDataExportParams{ID: exportID, UserID: userID})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, helper.RespondWithError(ctx, autherr.DataExportNotFound().WithCause(err))
+		}
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	var downloadURL string
+	if dataExportDownloadable(export, time.Now()) {
+		token, err := auth.MakeDataExportToken(userID, export.ID, s.tokenSecret, s.dataExportTokenTTL)
+		if err != nil {
+			return nil, helper.RespondWithError(ctx, err)
+		}
+		downloadURL, err = linkWithToken(s.dataExportDownloadURL, token)
+		if err != nil {
+			return nil, helper.RespondWithError(ctx, err)
+		}
+	}
+
+	return &pb.GetDataExportResponse{Export: dataExportToPB(export, downloadURL)}, nil
+}
+
+// dataExportDownloadable reports whether the archive of export can be downloaded at now
+func dataExportDownloadable(export database.DataExport, now time.Time) bool {
+	return export.Status == database.DataExportReady && export.ExpiresAt.Valid && now.Before(export.ExpiresAt.Time)
+}
+
+func dataExportToPB(export database.DataExport, downloadURL string) *pb.DataExport {
+	result := &pb.DataExport{
+		Id:          export.ID.String(),
+		Status:      export.Status,
+		CreatedAt:   timestamppb.New(export.CreatedAt),
+		SizeBytes:   export.SizeBytes,
+		DownloadUrl: downloadURL,
+	}
+	if export.CompletedAt.Valid {
+		result.CompletedAt = timestamppb.New(export.CompletedAt.Time)
+	}
+	if export.ExpiresAt.Valid {
+		result.ExpiresAt = timestamppb.New(export.ExpiresAt.Time)
+	}
+	return result
+}
+
+// DataExportHandler serves the archives of data exports over HTTP. It takes the download token from the "token"
+// query parameter of the download URL returned by GetDataExport and ignores the path, so it can be mounted anywhere
+func (s *Server) DataExportHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+
+		userID, exportID, err := auth.ValidateDataExportToken(r.URL.Query().Get("token"), s.tokenSecret)
+		if err != nil {
+			http.Error(w, "invalid or expired download link", http.StatusUnauthorized)
+			return
+		}
+
+		export, err := s.db.GetDataExport(r.Context(), database.GetDataExportParams{ID: exportID, UserID: userID})
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "data export not found", http.StatusNotFound)
+				return
+			}
+			log.Printf("Error loading data export %s: %v", exportID, err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if !dataExportDownloadable(export, time.Now()) {
+			http.Error(w, "the data export has expired, request a new one", http.StatusGone)
+			return
+		}
+
+		archive, err := s.dataExportStore.Open(r.Context(), export.StorageKey)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				http.Error(w, "the data export has expired, request a new one", http.StatusGone)
+				return
+			}
+			log.Printf("Error opening data export %s: %v", exportID, err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		defer archive.Close()
+
+		if r.Method == http.MethodGet {
+			ip, userAgent := s.httpClientInfo(r)
+			err := s.auditSink.Record(r.Context(), AuthEvent{
+				Type:      EventDataExportDownloaded,
+				UserID:    userID,
+				IPAddress: ip,
+				UserAgent: userAgent,
+				Details:   map[string]string{"export_id": exportID.String()},
+			})
+			if err != nil {
+				log.Printf("Error recording %s event: %v", EventDataExportDownloaded, err)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition",
+			`attachment; filename="data-export-`+export.CreatedAt.UTC().Format("2006-01-02")+`.zip"`)
+		http.ServeContent(w, r, "", export.CompletedAt.Time, archive)
+	})
+}
+
+// httpClientInfo is clientInfo for HTTP requests
+func (s *Server) httpClientInfo(r *http.Request) (ip, userAgent string) {
+	userAgent = r.UserAgent()
+	if s.trustForwardedFor {
+		first, _, _ := strings.Cut(r.Header.Get("X-Forwarded-For"), ",")
+		if first = strings.TrimSpace(first); first != "" {
+			return first, userAgent
+		}
+	}
+
+	ip = r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	return ip, userAgent
+}
+
+// ProcessDataExports builds the archives of requested data exports. Exports whose job died are taken over after a
+// while and given up after a few attempts. main runs it periodically
+func (s *Server) ProcessDataExports(ctx context.Context) error {
+	if s.dataExportStore == nil {
+		return nil
+	}
+
+	staleBefore := sql.NullTime{Time: time.Now().Add(-dataExportStaleAfter), Valid: true}
+	abandoned, err := s.db.FailAbandonedDataExports(ctx, database.FailAbandonedDataExportsParams{
+		StaleBefore: staleBefore,
+		MaxAttempts: maxDataExportAttempts,
+	})
+	if err != nil {
+		return err
+	}
+	if abandoned > 0 {
+		log.Printf("Gave up on %d data exports", abandoned)
+	}
+
+	for i := 0; i < dataExportBatchSize; i++ {
+		export, err := s.db.ClaimDataExport(ctx, database.ClaimDataExportParams{
+			StaleBefore: staleBefore,
+			MaxAttempts: maxDataExportAttempts,
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := s.buildDataExport(ctx, export); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// buildDataExport writes the archive of export to the export store and marks the export ready. When the archive
+// can't be built the export is marked failed, only errors recording that are returned
+func (s *Server) buildDataExport(ctx context.Context, export database.DataExport) error {
+	key := export.ID.String() + ".zip"
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(s.writeDataExportArchive(ctx, export.UserID, writer))
+	}()
+	size, err := s.dataExportStore.Put(ctx, key, reader)
+	reader.Close()
+
+	if err != nil {
+		log.Printf("Error building data export %s: %v", export.ID, err)
+		return s.db.FailDataExport(ctx, database.FailDataExportParams{ID: export.ID, Error: err.Error()})
+	}
+
+	err = s.db.CompleteDataExport(ctx, database.CompleteDataExportParams{
+		ID:         export.ID,
+		StorageKey: key,
+		SizeBytes:  size,
+		ExpiresAt:  sql.NullTime{Time: time.Now().Add(s.dataExportRetention), Valid: true},
+	})
+	if err != nil {
+		if deleteErr := s.dataExportStore.Delete(ctx, key); deleteErr != nil {
+			log.Printf("Error deleting archive of data export %s: %v", export.ID, deleteErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// Contents of the files in a data export archive. Secrets like password hashes and refresh tokens are left out
+type (
+	exportedProfile struct {
+		ID         uuid.UUID `json:"id"`
+		Email      string    `json:"email"`
+		Username   string    `json:"username"`
+		IsVerified bool      `json:"is_verified"`
+		IsPremium  bool      `json:"is_premium"`
+		IsPrivate  bool      `json:"is_private"`
+		Status     string    `json:"status"`
+		CreatedAt  time.Time `json:"created_at"`
+		UpdatedAt  time.Time `json:"updated_at"`
+	}
+
+	exportedFollow struct {
+		FollowerID uuid.UUID  `json:"follower_id"`
+		FolloweeID uuid.UUID  `json:"followee_id"`
+		Status     string     `json:"status"`
+		CreatedAt  time.Time  `json:"created_at"`
+		AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+	}
+
+	exportedSession struct {
+		SessionID string    `json:"session_id"`
+		CreatedAt time.Time `json:"created_at"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	exportedDeviceToken struct {
+		ID          uuid.UUID `json:"id"`
+		DeviceType  string    `json:"device_type"`
+		DeviceToken string    `json:"device_token"`
+		SessionID   string    `json:"session_id"`
+		CreatedAt   time.Time `json:"created_at"`
+		UpdatedAt   time.Time `json:"updated_at"`
+	}
+
+	exportedSecurityEvent struct {
+		Type      string          `json:"type"`
+		Actor     string          `json:"actor,omitempty"`
+		IPAddress string          `json:"ip_address,omitempty"`
+		UserAgent string          `json:"user_agent,omitempty"`
+		SessionID string          `json:"session_id,omitempty"`
+		Details   json.RawMessage `json:"details,omitempty"`
+		CreatedAt time.Time       `json:"created_at"`
+	}
+
+	exportedIdentity struct {
+		Provider  string    `json:"provider"`
+		Subject   string    `json:"subject"`
+		Email     string    `json:"email"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+)
+
+// writeDataExportArchive writes a ZIP archive with one JSON file per kind of data kept about the user to w
+func (s *Server) writeDataExportArchive(ctx context.Context, userID uuid.UUID, w io.Writer) error {
+	user, err := s.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	tokens, err := s.db.ListRefreshTokens(ctx, userID)
+	if err != nil {
+		return err
+	}
+	devices, err := s.db.ListDeviceTokens(ctx, userID)
+	if err != nil {
+		return err
+	}
+	identities, err := s.db.ListUserIdentities(ctx, userID)
+	if err != nil {
+		return err
+	}
+	follows, err := s.db.ListUserFollows(ctx, userID)
+	if err != nil {
+		return err
+	}
+	events, err := s.exportSecurityEvents(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	sessions := make([]exportedSession, 0, len(tokens))
+	for _, token := range tokens {
+		sessions = append(sessions, exportedSession{
+			SessionID: auth.SessionID(token.Token),
+			CreatedAt: token.CreatedAt,
+			ExpiresAt: token.ExpiryTime,
+		})
+	}
+	deviceTokens := make([]exportedDeviceToken, 0, len(devices))
+	for _, device := range devices {
+		deviceTokens = append(deviceTokens, exportedDeviceToken{
+			ID:          device.ID,
+			DeviceType:  device.DeviceType,
+			DeviceToken: device.DeviceToken,
+			SessionID:   device.SessionID,
+			CreatedAt:   device.CreatedAt,
+			UpdatedAt:   device.UpdatedAt,
+		})
+	}
+	linked := make([]exportedIdentity, 0, len(identities))
+	for _, identity := range identities {
+		linked = append(linked, exportedIdentity{
+			Provider:  identity.Provider,
+			Subject:   identity.Subject,
+			Email:     identity.Email,
+			CreatedAt: identity.CreatedAt,
+		})
+	}
+	exportedFollows := make([]exportedFollow, 0, len(follows))
+	for _, follow := range follows {
+		exported := exportedFollow{
+			FollowerID: follow.FollowerID,
+			FolloweeID: follow.FolloweeID,
+			Status:     follow.Status,
+			CreatedAt:  follow.CreatedAt,
+		}
+		if follow.AcceptedAt.Valid {
+			exported.AcceptedAt = &follow.AcceptedAt.Time
+		}
+		exportedFollows = append(exportedFollows, exported)
+	}
+
+	files := []struct {
+		name    string
+		content any
+	}{
+		{"profile.json", exportedProfile{
+			ID:         user.ID,
+			Email:      user.Email,
+			Username:   user.Username,
+			IsVerified: user.IsVerified,
+			IsPremium:  user.IsPremium,
+			IsPrivate:  user.IsPrivate,
+			Status:     user.Status,
+			CreatedAt:  user.CreatedAt,
+			UpdatedAt:  user.UpdatedAt,
+		}},
+		{"sessions.json", sessions},
+		{"device_tokens.json", deviceTokens},
+		{"security_events.json", events},
+		{"identities.json", linked},
+		{"follows.json", exportedFollows},
+	}
+
+	archive := zip.NewWriter(w)
+	now := time.Now()
+	for _, file := range files {
+		f, err := archive.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.content); err != nil {
+			return err
+		}
+	}
+	return archive.Close()
+}
+
+// exportSecurityEvents reads the whole security log of the user, newest first
+func (s *Server) exportSecurityEvents(ctx context.Context, userID uuid.UUID) ([]exportedSecurityEvent, error) {
+	events := []exportedSecurityEvent{}
+	params := database.ListAuthEventsParams{
+		UserID:   uuid.NullUUID{UUID: userID, Valid: true},
+		PageSize: dataExportEventPageSize,
+	}
+	for {
+		page, err := s.db.ListAuthEvents(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		for _, event := range page {
+			events = append(events, exportedSecurityEvent{
+				Type:      event.Type,
+				Actor:     event.Actor,
+				IPAddress: event.IpAddress,
+				UserAgent: event.UserAgent,
+				SessionID: event.SessionID,
+				Details:   event.Details,
+				CreatedAt: event.CreatedAt,
+			})
+		}
+		if len(page) < dataExportEventPageSize {
+			return events, nil
+		}
+		params.BeforeID = sql.NullInt64{Int64: page[len(page)-1].ID, Valid: true}
+	}
+}
+
+// PurgeExpiredDataExports deletes the archives of data exports whose download period ended. The exports stay
+// listed as expired. main runs it periodically
+func (s *Server) PurgeExpiredDataExports(ctx context.Context) error {
+	if s.dataExportStore == nil {
+		return nil
+	}
+
+	expired, err := s.db.ListExpiredDataExports(ctx, dataExportBatchSize)
+	if err != nil {
+		return err
+	}
+	for _, export := range expired {
+		if err := s.dataExportStore.Delete(ctx, export.StorageKey); err != nil {
+			return err
+		}
+		if err := s.db.ExpireDataExport(ctx, export.ID); err != nil {
+			return err
+		}
+	}
+	if len(expired) > 0 {
+		log.Printf("Deleted %d expired data exports", len(expired))
+	}
+	return nil
+}
+
+// deleteDataExportArchives deletes the archives of the ready exports of a user, whose rows were already expired
+func (s *Server) deleteDataExportArchives(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := s.dataExportStore.Delete(ctx, key); err != nil {
+			log.Printf("Error deleting data export archive %s: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,337 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/database/mocks"
+	"github.com/imhasandl/auth-service/internal/exportstore"
+	pb "github.com/imhasandl/auth-service/protos"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func newDataExportServer(t *testing.T, mockDB *mocks.MockQueries, sink AuditSink) (*Server, *exportstore.LocalDir) {
+	t.Helper()
+	store, err := exportstore.NewLocalDir(t.TempDir())
+	require.NoError(t, err)
+	server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret",
+		WithAuditSink(sink, false),
+		WithDataExports(store, "https://auth.example.com/exports/download", 7*24*time.Hour, 5*time.Minute),
+	)
+	return server, store
+}
+
+func TestRequestDataExport(t *testing.T) {
+	userID := uuid.New()
+
+	t.Run("queued", func(t *testing.T) {
+		testRedis.FlushAll()
+		mockDB := activeUsers(new(mocks.MockQueries))
+		sink := &recordingSink{}
+		server, _ := newDataExportServer(t, mockDB, sink)
+		mockDB.On("CreateDataExport", mock.Anything, mock.MatchedBy(func(arg database.CreateDataExportParams) bool {
+			return arg.UserID == userID && arg.ID != uuid.Nil
+		})).Return(database.DataExport{ID: uuid.New(), UserID: userID, Status: database.DataExportPending}, nil)
+
+		response, err := server.RequestDataExport(withAccessToken(t, userID), &pb.RequestDataExportRequest{})
+
+		require.NoError(t, err)
+		assert.Equal(t, database.DataExportPending, response.Export.Status)
+		assert.Empty(t, response.Export.DownloadUrl)
+		require.Len(t, sink.events, 1)
+		assert.Equal(t, EventDataExportRequested, sink.events[0].Type)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("already queued", func(t *testing.T) {
+		testRedis.FlushAll()
+		mockDB := activeUsers(new(mocks.MockQueries))
+		sink := &recordingSink{}
+		server, _ := newDataExportServer(t, mockDB, sink)
+		active := database.DataExport{ID: uuid.New(), UserID: userID, Status: database.DataExportRunning}
+		mockDB.On("CreateDataExport", mock.Anything, mock.Anything).
+			Return(database.DataExport{}, &pq.Error{Code: "23505", Constraint: "data_exports_active_user_key"})
+		mockDB.On("GetActiveDataExport", mock.Anything, userID).Return(active, nil)
+
+		response, err := server.RequestDataExport(withAccessToken(t, userID), &pb.RequestDataExportRequest{})
+
+		require.NoError(t, err)
+		assert.Equal(t, active.ID.String(), response.Export.Id)
+		assert.Empty(t, sink.events)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		mockDB := activeUsers(new(mocks.MockQueries))
+		server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+
+		_, err := server.RequestDataExport(withAccessToken(t, userID), &pb.RequestDataExportRequest{})
+
+		assertReason(t, err, codes.FailedPrecondition, autherr.ReasonDataExportsDisabled)
+	})
+}
+
+func TestGetDataExport(t *testing.T) {
+	userID, exportID := uuid.New(), uuid.New()
+	ready := database.DataExport{
+		ID:          exportID,
+		UserID:      userID,
+		Status:      database.DataExportReady,
+		StorageKey:  exportID.String() + ".zip",
+		CompletedAt: sql.NullTime{Time: time.Now(), Valid: true},
+		ExpiresAt:   sql.NullTime{Time: time.Now().Add(time.Hour), Valid: true},
+	}
+	lapsed := ready
+	lapsed.ExpiresAt = sql.NullTime{Time: time.Now().Add(-time.Minute), Valid: true}
+
+	testCases := []struct {
+		name     string
+		id       string
+		export   database.DataExport
+		err      error
+		code     codes.Code
+		reason   autherr.Reason
+		download bool
+	}{
+		{name: "ready", id: exportID.String(), export: ready, code: codes.OK, download: true},
+		{name: "pending", id: exportID.String(), export: database.DataExport{ID: exportID, Status: database.DataExportPending}, code: codes.OK},
+		{name: "past its retention", id: exportID.String(), export: lapsed, code: codes.OK},
+		{name: "not found", id: exportID.String(), err: sql.ErrNoRows, code: codes.NotFound, reason: autherr.ReasonDataExportNotFound},
+		{name: "invalid id", id: "export", code: codes.InvalidArgument, reason: autherr.ReasonInvalidArgument},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB := activeUsers(new(mocks.MockQueries))
+			server, _ := newDataExportServer(t, mockDB, &recordingSink{})
+			if tc.reason != autherr.ReasonInvalidArgument {
+				mockDB.On("GetDataExport", mock.Anything, database.GetDataExportParams{ID: exportID, UserID: userID}).
+					Return(tc.export, tc.err)
+			}
+
+			response, err := server.GetDataExport(withAccessToken(t, userID), &pb.GetDataExportRequest{Id: tc.id})
+
+			if tc.code != codes.OK {
+				assertReason(t, err, tc.code, tc.reason)
+				return
+			}
+			require.NoError(t, err)
+			if !tc.download {
+				assert.Empty(t, response.Export.DownloadUrl)
+				return
+			}
+
+			link, err := url.Parse(response.Export.DownloadUrl)
+			require.NoError(t, err)
+			assert.Equal(t, "auth.example.com", link.Host)
+			tokenUser, tokenExport, err := auth.ValidateDataExportToken(link.Query().Get("token"), "test-secret")
+			require.NoError(t, err)
+			assert.Equal(t, userID, tokenUser)
+			assert.Equal(t, exportID, tokenExport)
+		})
+	}
+}
+
+// readArchive returns the files of a ZIP archive by name
+func readArchive(t *testing.T, content []byte) map[string][]byte {
+	t.Helper()
+	archive, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	require.NoError(t, err)
+
+	files := make(map[string][]byte)
+	for _, file := range archive.File {
+		f, err := file.Open()
+		require.NoError(t, err)
+		files[file.Name], err = io.ReadAll(f)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+	return files
+}
+
+func TestProcessDataExports(t *testing.T) {
+	userID, exportID, followeeID := uuid.New(), uuid.New(), uuid.New()
+	export := database.DataExport{ID: exportID, UserID: userID, Status: database.DataExportRunning}
+
+	t.Run("built", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		server, store := newDataExportServer(t, mockDB, &recordingSink{})
+		mockDB.On("FailAbandonedDataExports", mock.Anything, mock.Anything).Return(int64(0), nil)
+		mockDB.On("ClaimDataExport", mock.Anything, mock.MatchedBy(func(arg database.ClaimDataExportParams) bool {
+			return arg.MaxAttempts == maxDataExportAttempts && arg.StaleBefore.Time.Before(time.Now())
+		})).Return(export, nil).Once()
+		mockDB.On("ClaimDataExport", mock.Anything, mock.Anything).Return(database.DataExport{}, sql.ErrNoRows)
+		mockDB.On("GetUserByID", mock.Anything, userID).Return(database.User{
+			ID:       userID,
+			Email:    "user@example.com",
+			Password: "password hash",
+			Username: "testuser",
+			Status:   database.StatusActive,
+		}, nil)
+		mockDB.On("ListRefreshTokens", mock.Anything, userID).
+			Return([]database.RefreshToken{{Token: "refresh-token", UserID: userID}}, nil)
+		mockDB.On("ListDeviceTokens", mock.Anything, userID).
+			Return([]database.DeviceToken{{ID: uuid.New(), UserID: userID, DeviceToken: "push-token", DeviceType: "ios"}}, nil)
+		mockDB.On("ListUserIdentities", mock.Anything, userID).
+			Return([]database.UserIdentity{{Provider: "github", Subject: "42", UserID: userID}}, nil)
+		mockDB.On("ListUserFollows", mock.Anything, userID).
+			Return([]database.Follow{{FollowerID: userID, FolloweeID: followeeID, Status: database.FollowAccepted}}, nil)
+		mockDB.On("ListAuthEvents", mock.Anything, mock.MatchedBy(func(arg database.ListAuthEventsParams) bool {
+			return arg.UserID.UUID == userID && !arg.BeforeID.Valid
+		})).Return([]database.AuthEvent{{ID: 7, Type: EventLoginSucceeded, Details: json.RawMessage(`{"method":"password"}`)}}, nil)
+		mockDB.On("CompleteDataExport", mock.Anything, mock.MatchedBy(func(arg database.CompleteDataExportParams) bool {
+			return arg.ID == exportID && arg.StorageKey == exportID.String()+".zip" && arg.SizeBytes > 0 &&
+				arg.ExpiresAt.Time.After(time.Now().Add(6*24*time.Hour))
+		})).Return(nil)
+
+		require.NoError(t, server.ProcessDataExports(context.Background()))
+		mockDB.AssertExpectations(t)
+
+		file, err := store.Open(context.Background(), exportID.String()+".zip")
+		require.NoError(t, err)
+		content, err := io.ReadAll(file)
+		require.NoError(t, err)
+		require.NoError(t, file.Close())
+
+		files := readArchive(t, content)
+		assert.Len(t, files, 6)
+
+		var profile map[string]any
+		require.NoError(t, json.Unmarshal(files["profile.json"], &profile))
+		assert.Equal(t, "user@example.com", profile["email"])
+		assert.NotContains(t, string(files["profile.json"]), "password")
+
+		assert.Contains(t, string(files["sessions.json"]), auth.SessionID("refresh-token"))
+		assert.NotContains(t, string(files["sessions.json"]), `"refresh-token"`)
+		assert.Contains(t, string(files["device_tokens.json"]), "push-token")
+		assert.Contains(t, string(files["identities.json"]), "github")
+		assert.Contains(t, string(files["security_events.json"]), `"method": "password"`)
+		assert.Contains(t, string(files["follows.json"]), followeeID.String())
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		server, store := newDataExportServer(t, mockDB, &recordingSink{})
+		mockDB.On("FailAbandonedDataExports", mock.Anything, mock.Anything).Return(int64(0), nil)
+		mockDB.On("ClaimDataExport", mock.Anything, mock.Anything).Return(export, nil).Once()
+		mockDB.On("ClaimDataExport", mock.Anything, mock.Anything).Return(database.DataExport{}, sql.ErrNoRows)
+		mockDB.On("GetUserByID", mock.Anything, userID).Return(database.User{}, errors.New("connection reset"))
+		mockDB.On("FailDataExport", mock.Anything, mock.MatchedBy(func(arg database.FailDataExportParams) bool {
+			return arg.ID == exportID && strings.Contains(arg.Error, "connection reset")
+		})).Return(nil)
+
+		require.NoError(t, server.ProcessDataExports(context.Background()))
+		mockDB.AssertExpectations(t)
+		mockDB.AssertNotCalled(t, "CompleteDataExport", mock.Anything, mock.Anything)
+
+		_, err := store.Open(context.Background(), exportID.String()+".zip")
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
+
+func TestDataExportHandler(t *testing.T) {
+	userID, exportID := uuid.New(), uuid.New()
+	key := exportID.String() + ".zip"
+	ready := database.DataExport{
+		ID:          exportID,
+		UserID:      userID,
+		Status:      database.DataExportReady,
+		StorageKey:  key,
+		CreatedAt:   time.Date(2025, 3, 4, 10, 0, 0, 0, time.UTC),
+		CompletedAt: sql.NullTime{Time: time.Now(), Valid: true},
+		ExpiresAt:   sql.NullTime{Time: time.Now().Add(time.Hour), Valid: true},
+	}
+	expired := ready
+	expired.Status = database.DataExportExpired
+
+	token, err := auth.MakeDataExportToken(userID, exportID, "test-secret", time.Minute)
+	require.NoError(t, err)
+	accessToken, err := auth.MakeJWT(userID, "test-secret", time.Minute)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name   string
+		token  string
+		export database.DataExport
+		status int
+	}{
+		{name: "downloaded", token: token, export: ready, status: http.StatusOK},
+		{name: "expired", token: token, export: expired, status: http.StatusGone},
+		{name: "access token", token: accessToken, status: http.StatusUnauthorized},
+		{name: "no token", status: http.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB := new(mocks.MockQueries)
+			sink := &recordingSink{}
+			server, store := newDataExportServer(t, mockDB, sink)
+			_, err := store.Put(context.Background(), key, strings.NewReader("archive"))
+			require.NoError(t, err)
+			if tc.export.ID != uuid.Nil {
+				mockDB.On("GetDataExport", mock.Anything, database.GetDataExportParams{ID: exportID, UserID: userID}).
+					Return(tc.export, nil)
+			}
+
+			httpServer := httptest.NewServer(server.DataExportHandler())
+			defer httpServer.Close()
+			response, err := http.Get(httpServer.URL + "/exports/download?token=" + url.QueryEscape(tc.token))
+			require.NoError(t, err)
+			defer response.Body.Close()
+			body, err := io.ReadAll(response.Body)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.status, response.StatusCode)
+			assert.Equal(t, "no-store", response.Header.Get("Cache-Control"))
+			if tc.status == http.StatusOK {
+				assert.Equal(t, "archive", string(body))
+				assert.Equal(t, "application/zip", response.Header.Get("Content-Type"))
+				assert.Equal(t, `attachment; filename="data-export-2025-03-04.zip"`, response.Header.Get("Content-Disposition"))
+				require.Len(t, sink.events, 1)
+				assert.Equal(t, EventDataExportDownloaded, sink.events[0].Type)
+				assert.Equal(t, "127.0.0.1", sink.events[0].IPAddress)
+			} else {
+				assert.Empty(t, sink.events)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPurgeExpiredDataExports(t *testing.T) {
+	exportID := uuid.New()
+	key := exportID.String() + ".zip"
+	mockDB := new(mocks.MockQueries)
+	server, store := newDataExportServer(t, mockDB, &recordingSink{})
+	_, err := store.Put(context.Background(), key, strings.NewReader("archive"))
+	require.NoError(t, err)
+	mockDB.On("ListExpiredDataExports", mock.Anything, int32(dataExportBatchSize)).
+		Return([]database.DataExport{{ID: exportID, StorageKey: key}}, nil)
+	mockDB.On("ExpireDataExport", mock.Anything, exportID).Return(nil)
+
+	require.NoError(t, server.PurgeExpiredDataExports(context.Background()))
+
+	_, err = store.Open(context.Background(), key)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	mockDB.AssertExpectations(t)
+}
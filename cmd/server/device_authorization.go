@@ -0,0 +1,278 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/cmd/helper"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/redis"
+	pb "github.com/imhasandl/auth-service/protos"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Status of a device authorization
+const (
+	deviceStatusPending  = "pending"
+	deviceStatusApproved = "approved"
+	deviceStatusDenied   = "denied"
+)
+
+const (
+	maxDeviceNameLength = 100
+	// userCodeAttempts is how often a new user code is generated when the previous one is in use
+	userCodeAttempts = 3
+)
+
+// deviceAuthorization is the state of a device authorization kept in Redis until the device redeems it
+type deviceAuthorization struct {
+	UserCode   string    `json:"user_code"`
+	DeviceName string    `json:"device_name"`
+	Status     string    `json:"status"`
+	UserID     uuid.UUID `json:"user_id,omitempty"`
+}
+
+// StartDeviceAuthorization begins the device authorization grant (RFC 8628) for devices that can't show a login form.
+// The device shows the user code and verification URI, then polls PollDeviceToken with the device code
+// while the user approves it with ApproveDevice on another device.
+func (s *Server) StartDeviceAuthorization(ctx context.Context, req *pb.StartDeviceAuthorizationRequest) (*pb.StartDeviceAuthorizationResponse, error) {
+	if s.deviceVerificationURL == "" {
+		return nil, helper.RespondWithError(ctx, autherr.DeviceFlowDisabled())
+	}
+	if len(req.GetDeviceName()) > maxDeviceNameLength {
+		return nil, helper.RespondWithError(ctx, autherr.InvalidArgument("device_name", "device name is too long"))
+	}
+
+	deviceCode, err := auth.MakeDeviceCode()
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	deviceCodeHash := auth.HashDeviceCode(deviceCode)
+
+	var userCode string
+	for attempt := 0; userCode == "" && attempt < userCodeAttempts; attempt++ {
+		candidate, err := auth.MakeUserCode()
+		if err != nil {
+			return nil, helper.RespondWithError(ctx, err)
+		}
+
+		state, err := json.Marshal(deviceAuthorization{
+			UserCode:   auth.NormalizeUserCode(candidate),
+			DeviceName: req.GetDeviceName(),
+			Status:     deviceStatusPending,
+		})
+		if err != nil {
+			return nil, helper.RespondWithError(ctx, err)
+		}
+
+		saved, err := redis.SaveDeviceAuthorization(deviceCodeHash, auth.NormalizeUserCode(candidate), string(state), s.deviceCodeTTL)
+		if err != nil {
+			return nil, helper.RespondWithError(ctx, autherr.Unavailable(err, 5*time.Second))
+		}
+		if saved {
+			userCode = candidate
+		}
+	}
+	if userCode == "" {
+		return nil, helper.RespondWithError(ctx, autherr.Unavailable(errors.New("no free user code found"), time.Second))
+	}
+
+	return &pb.StartDeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationUri:         s.deviceVerificationURL,
+		VerificationUriComplete: s.deviceVerificationURLWithCode(userCode),
+		ExpiresIn:               int32(s.deviceCodeTTL.Seconds()),
+		Interval:                int32(s.devicePollInterval.Seconds()),
+	}, nil
+}
+
+// ApproveDevice lets the logged in user approve or deny the device showing the user code.
+// The user code works once, and attempts are rate limited per user so codes can't be guessed.
+func (s *Server) ApproveDevice(ctx context.Context, req *pb.ApproveDeviceRequest) (*pb.ApproveDeviceResponse, error) {
+	userID, err := s.authenticatedUser(ctx)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	userCode := auth.NormalizeUserCode(req.GetUserCode())
+	if userCode == "" {
+		return nil, helper.RespondWithError(ctx, autherr.InvalidArgument("user_code", "user code is required"))
+	}
+
+	if err := s.checkRateLimit("device_approve:" + userID.String()); err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	deviceCodeHash, err := redis.GetDeviceUserCode(userCode)
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, helper.RespondWithError(ctx, autherr.UserCodeInvalid())
+		}
+		return nil, helper.RespondWithError(ctx, autherr.Unavailable(err, 5*time.Second))
+	}
+
+	state, err := getDeviceAuthorization(deviceCodeHash)
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, helper.RespondWithError(ctx, autherr.UserCodeInvalid())
+		}
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	if state.Status != deviceStatusPending || state.UserCode != userCode {
+		return nil, helper.RespondWithError(ctx, autherr.UserCodeInvalid())
+	}
+
+	state.Status = deviceStatusApproved
+	message := "Device approved"
+	if req.GetDeny() {
+		state.Status = deviceStatusDenied
+		message = "Device denied"
+	}
+	state.UserID = userID
+
+	value, err := json.Marshal(state)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	if err := redis.DecideDeviceAuthorization(deviceCodeHash, userCode, string(value)); err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, helper.RespondWithError(ctx, autherr.UserCodeInvalid())
+		}
+		return nil, helper.RespondWithError(ctx, autherr.Unavailable(err, 5*time.Second))
+	}
+
+	if state.Status == deviceStatusApproved {
+		s.recordEvent(ctx, AuthEvent{
+			Type:    EventDeviceApproved,
+			UserID:  userID,
+			Details: map[string]string{"device_name": state.DeviceName},
+		})
+	}
+
+	return &pb.ApproveDeviceResponse{
+		Success:    true,
+		Message:    message,
+		DeviceName: state.DeviceName,
+	}, nil
+}
+
+// PollDeviceToken is polled by the device until the user decided. It fails with AUTHORIZATION_PENDING while
+// the user hasn't, and with SLOW_DOWN when the device polls more often than the interval it was given.
+// Once approved, it logs the device in with the same tokens as Login, exactly once.
+func (s *Server) PollDeviceToken(ctx context.Context, req *pb.PollDeviceTokenRequest) (*pb.LoginResponse, error) {
+	if req.GetDeviceCode() == "" {
+		return nil, helper.RespondWithError(ctx, autherr.InvalidArgument("device_code", "device code is required"))
+	}
+	deviceCodeHash := auth.HashDeviceCode(req.GetDeviceCode())
+
+	allowed, err := redis.ThrottleDevicePoll(deviceCodeHash, s.devicePollInterval)
+	if err != nil {
+		log.Printf("WARNING: Failed to throttle device polling in Redis: %v", err)
+	} else if !allowed {
+		return nil, helper.RespondWithError(ctx, autherr.SlowDown(s.devicePollInterval))
+	}
+
+	state, err := getDeviceAuthorization(deviceCodeHash)
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, helper.RespondWithError(ctx, autherr.DeviceCodeExpired())
+		}
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	switch state.Status {
+	case deviceStatusPending:
+		return nil, helper.RespondWithError(ctx, autherr.AuthorizationPending())
+	case deviceStatusDenied:
+		if _, err := redis.TakeDeviceAuthorization(deviceCodeHash); err != nil && !errors.Is(err, redis.Nil) {
+			log.Printf("Failed to delete denied device authorization: %v", err)
+		}
+		return nil, helper.RespondWithError(ctx, autherr.DeviceAccessDenied())
+	}
+
+	// Taking the authorization makes sure concurrent polls can't both redeem it
+	if _, err := redis.TakeDeviceAuthorization(deviceCodeHash); err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, helper.RespondWithError(ctx, autherr.DeviceCodeExpired())
+		}
+		return nil, helper.RespondWithError(ctx, autherr.Unavailable(err, 5*time.Second))
+	}
+
+	var (
+		user                      database.User
+		accessToken, refreshToken string
+	)
+	err = s.db.WithTx(ctx, func(q DBQuerier) error {
+		user, err = q.GetUserByID(ctx, state.UserID)
+		if err != nil {
+			return err
+		}
+
+		accessToken, refreshToken, err = s.issueTokens(ctx, q, user.ID)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, helper.RespondWithError(ctx, autherr.DeviceCodeExpired().WithCause(err))
+		}
+		s.recordLoginFailure(ctx, state.UserID, "", loginMethodDevice, err)
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	cacheTokens(user.ID, accessToken, refreshToken)
+	s.recordLogin(ctx, user.ID, loginMethodDevice, refreshToken)
+
+	return &pb.LoginResponse{
+		User: &pb.User{
+			Id:         user.ID.String(),
+			CreatedAt:  timestamppb.New(user.CreatedAt),
+			UpdatedAt:  timestamppb.New(user.UpdatedAt),
+			Email:      user.Email,
+			Username:   user.Username,
+			IsPremium:  user.IsPremium,
+			IsVerified: user.IsVerified,
+		},
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// getDeviceAuthorization loads the device authorization stored under the hash of its device code
+func getDeviceAuthorization(deviceCodeHash string) (deviceAuthorization, error) {
+	value, err := redis.GetDeviceAuthorization(deviceCodeHash)
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return deviceAuthorization{}, err
+		}
+		return deviceAuthorization{}, autherr.Unavailable(err, 5*time.Second)
+	}
+
+	var state deviceAuthorization
+	if err := json.Unmarshal([]byte(value), &state); err != nil {
+		return deviceAuthorization{}, err
+	}
+	return state, nil
+}
+
+// deviceVerificationURLWithCode returns the verification URL with the user code filled in
+func (s *Server) deviceVerificationURLWithCode(userCode string) string {
+	u, err := url.Parse(s.deviceVerificationURL)
+	if err != nil {
+		return s.deviceVerificationURL
+	}
+
+	query := u.Query()
+	query.Set("user_code", userCode)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
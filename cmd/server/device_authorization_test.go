@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/database/mocks"
+	pb "github.com/imhasandl/auth-service/protos"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	deviceVerificationURL = "https://example.com/device"
+	devicePollInterval    = 5 * time.Second
+	deviceCodeTTL         = 10 * time.Minute
+)
+
+func newDeviceServer(mockDB *mocks.MockQueries) *Server {
+	return NewServer(mockDB, "test-secret", "test@example.com", "email-secret",
+		WithDeviceAuthorization(deviceVerificationURL, deviceCodeTTL, devicePollInterval))
+}
+
+// withAccessToken returns a context carrying the access token of userID like a gRPC client would send it
+func withAccessToken(t *testing.T, userID uuid.UUID) context.Context {
+	token, err := auth.MakeJWT(userID, "test-secret", time.Hour)
+	require.NoError(t, err)
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+}
+
+// activeUsers lets the access token of every user through the account status check
+func activeUsers(mockDB *mocks.MockQueries) *mocks.MockQueries {
+	mockDB.On("GetUserStatus", mock.Anything, mock.Anything).
+		Return(database.GetUserStatusRow{Status: database.StatusActive}, nil).Maybe()
+	return mockDB
+}
+
+func assertReason(t *testing.T, err error, code codes.Code, reason autherr.Reason) {
+	t.Helper()
+	require.Error(t, err)
+	assert.Equal(t, code, status.Code(err))
+	assert.Equal(t, reason, autherr.ReasonOf(err))
+}
+
+func TestDeviceAuthorizationApproved(t *testing.T) {
+	ctx := context.Background()
+	userID := uuid.New()
+	mockDB := activeUsers(new(mocks.MockQueries))
+	server := newDeviceServer(mockDB)
+
+	started, err := server.StartDeviceAuthorization(ctx, &pb.StartDeviceAuthorizationRequest{DeviceName: "Living room TV"})
+	require.NoError(t, err)
+	assert.NotEmpty(t, started.DeviceCode)
+	assert.Regexp(t, `^[A-Z]{4}-[A-Z]{4}$`, started.UserCode)
+	assert.Equal(t, deviceVerificationURL, started.VerificationUri)
+	assert.Equal(t, int32(600), started.ExpiresIn)
+	assert.Equal(t, int32(5), started.Interval)
+
+	complete, err := url.Parse(started.VerificationUriComplete)
+	require.NoError(t, err)
+	assert.Equal(t, started.UserCode, complete.Query().Get("user_code"))
+
+	poll := &pb.PollDeviceTokenRequest{DeviceCode: started.DeviceCode}
+	_, err = server.PollDeviceToken(ctx, poll)
+	assertReason(t, err, codes.FailedPrecondition, autherr.ReasonAuthorizationPending)
+
+	_, err = server.PollDeviceToken(ctx, poll)
+	assertReason(t, err, codes.ResourceExhausted, autherr.ReasonSlowDown)
+
+	_, err = server.ApproveDevice(ctx, &pb.ApproveDeviceRequest{UserCode: started.UserCode})
+	assertReason(t, err, codes.Unauthenticated, autherr.ReasonAccessTokenInvalid)
+
+	userCtx := withAccessToken(t, userID)
+	_, err = server.ApproveDevice(userCtx, &pb.ApproveDeviceRequest{UserCode: "BBBB-BBBB"})
+	assertReason(t, err, codes.InvalidArgument, autherr.ReasonUserCodeInvalid)
+
+	// Users may type the code in lower case and without the dash
+	approved, err := server.ApproveDevice(userCtx, &pb.ApproveDeviceRequest{
+		UserCode: strings.ToLower(strings.Replace(started.UserCode, "-", " ", 1)),
+	})
+	require.NoError(t, err)
+	assert.True(t, approved.Success)
+	assert.Equal(t, "Living room TV", approved.DeviceName)
+
+	_, err = server.ApproveDevice(userCtx, &pb.ApproveDeviceRequest{UserCode: started.UserCode})
+	assertReason(t, err, codes.InvalidArgument, autherr.ReasonUserCodeInvalid)
+
+	mockDB.On("GetUserByID", mock.Anything, userID).Return(database.User{
+		ID:       userID,
+		Email:    "device@example.com",
+		Username: "device",
+	}, nil).Once()
+	mockDB.On("GetUserAuthorization", mock.Anything, userID).Return(database.GetUserAuthorizationRow{}, nil)
+	mockDB.On("RefreshToken", mock.Anything, mock.MatchedBy(func(arg database.RefreshTokenParams) bool {
+		return arg.UserID == userID
+	})).Return(database.RefreshToken{}, nil).Once()
+
+	testRedis.FastForward(devicePollInterval)
+	response, err := server.PollDeviceToken(ctx, poll)
+	require.NoError(t, err)
+	assert.Equal(t, userID.String(), response.User.Id)
+	assert.NotEmpty(t, response.RefreshToken)
+	tokenUserID, err := auth.ValidateJWT(response.Token, "test-secret")
+	require.NoError(t, err)
+	assert.Equal(t, userID, tokenUserID)
+
+	// The device code can only be redeemed once
+	testRedis.FastForward(devicePollInterval)
+	_, err = server.PollDeviceToken(ctx, poll)
+	assertReason(t, err, codes.Unauthenticated, autherr.ReasonDeviceCodeExpired)
+
+	mockDB.AssertExpectations(t)
+}
+
+func TestDeviceAuthorizationDenied(t *testing.T) {
+	ctx := context.Background()
+	server := newDeviceServer(activeUsers(new(mocks.MockQueries)))
+
+	started, err := server.StartDeviceAuthorization(ctx, &pb.StartDeviceAuthorizationRequest{})
+	require.NoError(t, err)
+
+	denied, err := server.ApproveDevice(withAccessToken(t, uuid.New()), &pb.ApproveDeviceRequest{
+		UserCode: started.UserCode,
+		Deny:     true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Device denied", denied.Message)
+
+	poll := &pb.PollDeviceTokenRequest{DeviceCode: started.DeviceCode}
+	_, err = server.PollDeviceToken(ctx, poll)
+	assertReason(t, err, codes.PermissionDenied, autherr.ReasonDeviceAccessDenied)
+
+	testRedis.FastForward(devicePollInterval)
+	_, err = server.PollDeviceToken(ctx, poll)
+	assertReason(t, err, codes.Unauthenticated, autherr.ReasonDeviceCodeExpired)
+}
+
+func TestDeviceAuthorizationExpired(t *testing.T) {
+	ctx := context.Background()
+	server := newDeviceServer(activeUsers(new(mocks.MockQueries)))
+
+	started, err := server.StartDeviceAuthorization(ctx, &pb.StartDeviceAuthorizationRequest{})
+	require.NoError(t, err)
+
+	testRedis.FastForward(deviceCodeTTL)
+
+	_, err = server.ApproveDevice(withAccessToken(t, uuid.New()), &pb.ApproveDeviceRequest{UserCode: started.UserCode})
+	assertReason(t, err, codes.InvalidArgument, autherr.ReasonUserCodeInvalid)
+
+	_, err = server.PollDeviceToken(ctx, &pb.PollDeviceTokenRequest{DeviceCode: started.DeviceCode})
+	assertReason(t, err, codes.Unauthenticated, autherr.ReasonDeviceCodeExpired)
+}
+
+func TestDeviceAuthorizationErrors(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := NewServer(new(mocks.MockQueries), "test-secret", "test@example.com", "email-secret").
+		StartDeviceAuthorization(ctx, &pb.StartDeviceAuthorizationRequest{})
+	assertReason(t, err, codes.FailedPrecondition, autherr.ReasonDeviceFlowDisabled)
+
+	server := newDeviceServer(activeUsers(new(mocks.MockQueries)))
+
+	_, err = server.PollDeviceToken(ctx, &pb.PollDeviceTokenRequest{})
+	assertReason(t, err, codes.InvalidArgument, autherr.ReasonInvalidArgument)
+
+	_, err = server.PollDeviceToken(ctx, &pb.PollDeviceTokenRequest{DeviceCode: "unknown"})
+	assertReason(t, err, codes.Unauthenticated, autherr.ReasonDeviceCodeExpired)
+
+	_, err = server.ApproveDevice(withAccessToken(t, uuid.New()), &pb.ApproveDeviceRequest{})
+	assertReason(t, err, codes.InvalidArgument, autherr.ReasonInvalidArgument)
+
+	expired, err := auth.MakeJWT(uuid.New(), "test-secret", -time.Minute)
+	require.NoError(t, err)
+	expiredCtx := metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+expired))
+	_, err = server.ApproveDevice(expiredCtx, &pb.ApproveDeviceRequest{UserCode: "BBBB-BBBB"})
+	assertReason(t, err, codes.Unauthenticated, autherr.ReasonAccessTokenInvalid)
+}
+
+func TestApproveDeviceRateLimited(t *testing.T) {
+	server := newDeviceServer(activeUsers(new(mocks.MockQueries)))
+	userCtx := withAccessToken(t, uuid.New())
+
+	for i := 0; i < server.loginRateLimit; i++ {
+		_, err := server.ApproveDevice(userCtx, &pb.ApproveDeviceRequest{UserCode: "BBBB-BBBB"})
+		assertReason(t, err, codes.InvalidArgument, autherr.ReasonUserCodeInvalid)
+	}
+
+	_, err := server.ApproveDevice(userCtx, &pb.ApproveDeviceRequest{UserCode: "BBBB-BBBB"})
+	assertReason(t, err, codes.ResourceExhausted, autherr.ReasonRateLimited)
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/cmd/helper"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	pb "github.com/imhasandl/auth-service/protos"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// maxDeviceTokenLength is well above the length of APNs and FCM tokens
+const maxDeviceTokenLength = 4096
+
+// deviceTypes are the platforms push tokens can be registered for
+var deviceTypes = []string{"android", "ios", "web"}
+
+// RegisterDeviceToken registers a push notification token for the session of refresh_token. Registering a token
+// again moves it to the session and keeps it from going stale, so apps should register whenever they start.
+func (s *Server) RegisterDeviceToken(ctx context.Context, req *pb.RegisterDeviceTokenRequest) (*pb.RegisterDeviceTokenResponse, error) {
+	userID, err := s.authenticatedUser(ctx)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	var violations autherr.Violations
+	if req.GetRefreshToken() == "" {
+		violations.Add("refresh_token", "refresh token is required")
+	}
+	if req.GetDeviceToken() == "" {
+		violations.Add("device_token", "device token is required")
+	} else if len(req.GetDeviceToken()) > maxDeviceTokenLength {
+		violations.Add("device_token", "device token is too long")
+	}
+	if !slices.Contains(deviceTypes, req.GetDeviceType()) {
+		violations.Add("device_type", "device_type should be one of "+strings.Join(deviceTypes, ", "))
+	}
+	if err := violations.Err(); err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	session, err := s.db.GetRefreshToken(ctx, req.GetRefreshToken())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, helper.RespondWithError(ctx, autherr.RefreshTokenInvalid().WithCause(err))
+		}
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	if session.UserID != userID {
+		return nil, helper.RespondWithError(ctx, autherr.RefreshTokenInvalid())
+	}
+	if time.Now().After(session.ExpiryTime) {
+		return nil, helper.RespondWithError(ctx, autherr.RefreshTokenExpired())
+	}
+
+	deviceToken, err := s.db.UpsertDeviceToken(ctx, database.UpsertDeviceTokenParams{
+		DeviceToken: req.GetDeviceToken(),
+		UserID:      userID,
+		ID:          uuid.New(),
+		DeviceType:  req.GetDeviceType(),
+		SessionID:   auth.SessionID(req.GetRefreshToken()),
+	})
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	return &pb.RegisterDeviceTokenResponse{DeviceToken: deviceTokenResponse(deviceToken)}, nil
+}
+
+// UnregisterDeviceToken removes a push notification token of the logged in user, like apps do when
+// notifications are turned off.
+func (s *Server) UnregisterDeviceToken(ctx context.Context, req *pb.UnregisterDeviceTokenRequest) (*pb.UnregisterDeviceTokenResponse, error) {
+	userID, err := s.authenticatedUser(ctx)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	if req.GetDeviceToken() == "" {
+		return nil, helper.RespondWithError(ctx, autherr.InvalidArgument("device_token", "device token is required"))
+	}
+
+	deleted, err := s.db.DeleteDeviceToken(ctx, database.DeleteDeviceTokenParams{
+		UserID:      userID,
+		DeviceToken: req.GetDeviceToken(),
+	})
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	if deleted == 0 {
+		return nil, helper.RespondWithError(ctx, autherr.DeviceTokenNotFound())
+	}
+
+	return &pb.UnregisterDeviceTokenResponse{
+		Success: true,
+		Message: "Device token removed",
+	}, nil
+}
+
+// ListDeviceTokens returns the push notification tokens of the logged in user. Other users' tokens are only
+// available through the AdminService.
+func (s *Server) ListDeviceTokens(ctx context.Context, req *pb.ListDeviceTokensRequest) (*pb.ListDeviceTokensResponse, error) {
+	userID, err := s.authenticatedUser(ctx)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	if req.GetUserId() != "" && req.GetUserId() != userID.String() {
+		return nil, helper.RespondWithError(ctx, autherr.PermissionDenied(PermissionDevicesRead))
+	}
+
+	response, err := s.listDeviceTokens(ctx, userID)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	return response, nil
+}
+
+// ListDeviceTokens returns the push notification tokens of a user. The notification service calls it to find
+// where to send a user's notifications.
+func (s *AdminServer) ListDeviceTokens(ctx context.Context, req *pb.ListDeviceTokensRequest) (*pb.ListDeviceTokensResponse, error) {
+	actor, userID, err := adminRequest(ctx, req.GetUserId())
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	response, err := s.listDeviceTokens(ctx, userID)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	err = s.audit(ctx, s.db, actor, auditListDeviceTokens, userID, map[string]any{
+		"returned": len(response.DeviceTokens),
+	})
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	return response, nil
+}
+
+func (s *Server) listDeviceTokens(ctx context.Context, userID uuid.UUID) (*pb.ListDeviceTokensResponse, error) {
+	deviceTokens, err := s.db.ListDeviceTokens(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &pb.ListDeviceTokensResponse{}
+	for _, deviceToken := range deviceTokens {
+		response.DeviceTokens = append(response.DeviceTokens, deviceTokenResponse(deviceToken))
+	}
+	return response, nil
+}
+
+// PurgeStaleDeviceTokens deletes the push tokens that weren't registered again within the device token TTL,
+// and those of sessions that ended without removing them, like sessions that expired. main runs it periodically
+func (s *Server) PurgeStaleDeviceTokens(ctx context.Context) error {
+	purged, err := s.db.DeleteStaleDeviceTokens(ctx, time.Now().Add(-s.deviceTokenTTL))
+	if err != nil {
+		return err
+	}
+	if purged > 0 {
+		log.Printf("Purged %d stale device tokens", purged)
+	}
+	return nil
+}
+
+func deviceTokenResponse(deviceToken database.DeviceToken) *pb.DeviceToken {
+	return &pb.DeviceToken{
+		Id:          deviceToken.ID.String(),
+		DeviceToken: deviceToken.DeviceToken,
+		DeviceType:  deviceToken.DeviceType,
+		SessionId:   deviceToken.SessionID,
+		CreatedAt:   timestamppb.New(deviceToken.CreatedAt),
+		UpdatedAt:   timestamppb.New(deviceToken.UpdatedAt),
+	}
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/database/mocks"
+	pb "github.com/imhasandl/auth-service/protos"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func TestRegisterDeviceToken(t *testing.T) {
+	userID := uuid.New()
+	session := database.RefreshToken{Token: "refresh-token", UserID: userID, ExpiryTime: time.Now().Add(time.Hour)}
+	valid := &pb.RegisterDeviceTokenRequest{RefreshToken: "refresh-token", DeviceToken: "fcm-token", DeviceType: "android"}
+
+	testCases := []struct {
+		name        string
+		request     *pb.RegisterDeviceTokenRequest
+		mockSetup   func(*mocks.MockQueries)
+		errorCode   codes.Code
+		errorReason autherr.Reason
+	}{
+		{
+			name:    "registered",
+			request: valid,
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetRefreshToken", mock.Anything, "refresh-token").Return(session, nil)
+				mockDB.On("UpsertDeviceToken", mock.Anything, mock.MatchedBy(func(arg database.UpsertDeviceTokenParams) bool {
+					return arg.UserID == userID && arg.DeviceToken == "fcm-token" && arg.DeviceType == "android" &&
+						arg.SessionID == auth.SessionID("refresh-token") && arg.ID != uuid.Nil
+				})).Return(database.DeviceToken{
+					ID:          uuid.New(),
+					UserID:      userID,
+					DeviceToken: "fcm-token",
+					DeviceType:  "android",
+					SessionID:   auth.SessionID("refresh-token"),
+				}, nil)
+			},
+		},
+		{
+			name:        "invalid fields",
+			request:     &pb.RegisterDeviceTokenRequest{DeviceToken: strings.Repeat("a", maxDeviceTokenLength+1), DeviceType: "blackberry"},
+			mockSetup:   func(mockDB *mocks.MockQueries) {},
+			errorCode:   codes.InvalidArgument,
+			errorReason: autherr.ReasonInvalidArgument,
+		},
+		{
+			name:    "unknown session",
+			request: valid,
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetRefreshToken", mock.Anything, "refresh-token").Return(database.RefreshToken{}, sql.ErrNoRows)
+			},
+			errorCode:   codes.Unauthenticated,
+			errorReason: autherr.ReasonRefreshTokenInvalid,
+		},
+		{
+			name:    "someone else's session",
+			request: valid,
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetRefreshToken", mock.Anything, "refresh-token").
+					Return(database.RefreshToken{Token: "refresh-token", UserID: uuid.New(), ExpiryTime: time.Now().Add(time.Hour)}, nil)
+			},
+			errorCode:   codes.Unauthenticated,
+			errorReason: autherr.ReasonRefreshTokenInvalid,
+		},
+		{
+			name:    "expired session",
+			request: valid,
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetRefreshToken", mock.Anything, "refresh-token").
+					Return(database.RefreshToken{Token: "refresh-token", UserID: userID, ExpiryTime: time.Now().Add(-time.Minute)}, nil)
+			},
+			errorCode:   codes.Unauthenticated,
+			errorReason: autherr.ReasonRefreshTokenExpired,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB := activeUsers(new(mocks.MockQueries))
+			server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+
+			tc.mockSetup(mockDB)
+
+			response, err := server.RegisterDeviceToken(withAccessToken(t, userID), tc.request)
+
+			if tc.errorReason != "" {
+				assertReason(t, err, tc.errorCode, tc.errorReason)
+				assert.Nil(t, response)
+				mockDB.AssertNotCalled(t, "UpsertDeviceToken", mock.Anything, mock.Anything)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, "fcm-token", response.DeviceToken.DeviceToken)
+				assert.Equal(t, auth.SessionID("refresh-token"), response.DeviceToken.SessionId)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRegisterDeviceTokenUnauthenticated(t *testing.T) {
+	mockDB := activeUsers(new(mocks.MockQueries))
+	server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+
+	_, err := server.RegisterDeviceToken(context.Background(), &pb.RegisterDeviceTokenRequest{
+		RefreshToken: "refresh-token",
+		DeviceToken:  "fcm-token",
+		DeviceType:   "android",
+	})
+	assertReason(t, err, codes.Unauthenticated, autherr.ReasonAccessTokenInvalid)
+}
+
+func TestUnregisterDeviceToken(t *testing.T) {
+	userID := uuid.New()
+
+	testCases := []struct {
+		name        string
+		deviceToken string
+		mockSetup   func(*mocks.MockQueries)
+		errorCode   codes.Code
+		errorReason autherr.Reason
+	}{
+		{
+			name:        "removed",
+			deviceToken: "fcm-token",
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("DeleteDeviceToken", mock.Anything, database.DeleteDeviceTokenParams{UserID: userID, DeviceToken: "fcm-token"}).
+					Return(int64(1), nil)
+			},
+		},
+		{
+			name:        "not registered",
+			deviceToken: "fcm-token",
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("DeleteDeviceToken", mock.Anything, mock.Anything).Return(int64(0), nil)
+			},
+			errorCode:   codes.NotFound,
+			errorReason: autherr.ReasonDeviceTokenNotFound,
+		},
+		{
+			name:        "missing token",
+			mockSetup:   func(mockDB *mocks.MockQueries) {},
+			errorCode:   codes.InvalidArgument,
+			errorReason: autherr.ReasonInvalidArgument,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB := activeUsers(new(mocks.MockQueries))
+			server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+
+			tc.mockSetup(mockDB)
+
+			response, err := server.UnregisterDeviceToken(withAccessToken(t, userID), &pb.UnregisterDeviceTokenRequest{DeviceToken: tc.deviceToken})
+
+			if tc.errorReason != "" {
+				assertReason(t, err, tc.errorCode, tc.errorReason)
+			} else {
+				require.NoError(t, err)
+				assert.True(t, response.Success)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestListDeviceTokens(t *testing.T) {
+	userID := uuid.New()
+	deviceTokens := []database.DeviceToken{
+		{ID: uuid.New(), UserID: userID, DeviceToken: "apns-token", DeviceType: "ios", SessionID: "a1"},
+		{ID: uuid.New(), UserID: userID, DeviceToken: "fcm-token", DeviceType: "android", SessionID: "b2"},
+	}
+
+	t.Run("own tokens", func(t *testing.T) {
+		mockDB := activeUsers(new(mocks.MockQueries))
+		server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+		mockDB.On("ListDeviceTokens", mock.Anything, userID).Return(deviceTokens, nil)
+
+		response, err := server.ListDeviceTokens(withAccessToken(t, userID), &pb.ListDeviceTokensRequest{})
+		require.NoError(t, err)
+
+		require.Len(t, response.DeviceTokens, 2)
+		assert.Equal(t, "apns-token", response.DeviceTokens[0].DeviceToken)
+		assert.Equal(t, "b2", response.DeviceTokens[1].SessionId)
+	})
+
+	t.Run("other user", func(t *testing.T) {
+		mockDB := activeUsers(new(mocks.MockQueries))
+		server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+
+		_, err := server.ListDeviceTokens(withAccessToken(t, userID), &pb.ListDeviceTokensRequest{UserId: uuid.NewString()})
+
+		assertReason(t, err, codes.PermissionDenied, autherr.ReasonPermissionDenied)
+		mockDB.AssertNotCalled(t, "ListDeviceTokens", mock.Anything, mock.Anything)
+	})
+
+	t.Run("admin", func(t *testing.T) {
+		adminID := uuid.New()
+		mockDB := new(mocks.MockQueries)
+		admin := NewServer(mockDB, "test-secret", "test@example.com", "email-secret").Admin()
+		mockDB.On("ListDeviceTokens", mock.Anything, userID).Return(deviceTokens, nil)
+		details := expectAudit(mockDB, adminID, auditListDeviceTokens, userID)
+
+		response, err := admin.ListDeviceTokens(adminContext(adminID), &pb.ListDeviceTokensRequest{UserId: userID.String()})
+		require.NoError(t, err)
+
+		assert.Len(t, response.DeviceTokens, 2)
+		assert.Equal(t, float64(2), (*details)["returned"])
+		mockDB.AssertExpectations(t)
+	})
+}
+
+func TestPurgeStaleDeviceTokens(t *testing.T) {
+	mockDB := new(mocks.MockQueries)
+	server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret", WithDeviceTokenTTL(24*time.Hour))
+	mockDB.On("DeleteStaleDeviceTokens", mock.Anything, mock.MatchedBy(func(updatedBefore time.Time) bool {
+		return time.Since(updatedBefore) > 24*time.Hour-time.Minute && time.Since(updatedBefore) < 24*time.Hour+time.Minute
+	})).Return(int64(3), nil).Once()
+
+	require.NoError(t, server.PurgeStaleDeviceTokens(context.Background()))
+
+	mockDB.On("DeleteStaleDeviceTokens", mock.Anything, mock.Anything).Return(int64(0), errors.New("database error"))
+	assert.Error(t, server.PurgeStaleDeviceTokens(context.Background()))
+	mockDB.AssertExpectations(t)
+}
@@ -0,0 +1,25 @@
+package server
+
+import (
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/emailpolicy"
+)
+
+// newEmailViolation parses an address someone wants to give an account and checks it against the email policy.
+// It returns why the address can't be used, or "" with the normalized address when it can
+func (s *Server) newEmailViolation(raw string) (emailpolicy.Address, string) {
+	addr, err := emailpolicy.Parse(raw)
+	if err != nil {
+		return emailpolicy.Address{}, err.Error()
+	}
+	if violation := s.emailPolicy.Check(addr); violation != "" {
+		return emailpolicy.Address{}, violation
+	}
+	return addr, ""
+}
+
+// isEmailUniqueViolation reports whether a write failed because another account has the address
+func isEmailUniqueViolation(err error) bool {
+	return database.IsUniqueViolation(err, "users_email_key") ||
+		database.IsUniqueViolation(err, "users_email_canonical_key")
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/database/mocks"
+	"github.com/imhasandl/auth-service/internal/emailpolicy"
+	pb "github.com/imhasandl/auth-service/protos"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fieldViolations returns the field violations of a gRPC error by field
+func fieldViolations(t *testing.T, err error) map[string]string {
+	t.Helper()
+	st, ok := status.FromError(err)
+	require.True(t, ok)
+	require.Equal(t, codes.InvalidArgument, st.Code())
+
+	violations := make(map[string]string)
+	for _, detail := range st.Details() {
+		if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+			for _, violation := range badRequest.GetFieldViolations() {
+				violations[violation.GetField()] = violation.GetDescription()
+			}
+		}
+	}
+	return violations
+}
+
+func TestRegisterEmailPolicy(t *testing.T) {
+	companyOnly, err := emailpolicy.NewPolicy([]string{"acme.example"}, []string{"contractors.acme.example"}, "")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name      string
+		email     string
+		policy    *emailpolicy.Policy
+		violation string
+	}{
+		{name: "not an address", email: "alice.example.com", violation: emailpolicy.ErrInvalidAddress.Error()},
+		{name: "display name", email: "Alice <alice@example.com>", violation: emailpolicy.ErrDisplayName.Error()},
+		{name: "disposable", email: "alice@Mailinator.com", violation: emailpolicy.ViolationDisposable},
+		{name: "outside the allowed domains", email: "alice@example.com", policy: companyOnly, violation: emailpolicy.ViolationDomainNotAllowed},
+		{name: "denied subdomain", email: "bob@contractors.acme.example", policy: companyOnly, violation: emailpolicy.ViolationDomainNotAllowed},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var opts []Option
+			if tc.policy != nil {
+				opts = append(opts, WithEmailPolicy(tc.policy))
+			}
+			mockDB := new(mocks.MockQueries)
+			server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret", opts...)
+
+			_, err := server.Register(context.Background(), &pb.RegisterRequest{
+				Email:    tc.email,
+				Password: "password123",
+				Username: "testuser",
+			})
+
+			assert.Equal(t, map[string]string{"email": tc.violation}, fieldViolations(t, err))
+			mockDB.AssertExpectations(t)
+		})
+	}
+
+	t.Run("normalized", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret", WithEmailPolicy(companyOnly))
+		userID := uuid.New()
+		mockDB.On("UsernameAvailable", mock.Anything, mock.Anything).Return(true, nil)
+		mockDB.On("CreateUser", mock.Anything, mock.MatchedBy(func(arg database.CreateUserParams) bool {
+			return arg.Email == "Alice.Smith@eu.acme.example" && arg.EmailCanonical == "alice.smith@eu.acme.example"
+		})).Return(database.User{ID: userID, Email: "Alice.Smith@eu.acme.example", Username: "testuser"}, nil)
+		mockDB.On("UpsertVerificationCode", mock.Anything, mock.Anything).Return(database.VerificationCode{}, nil)
+		mockDB.On("CreateOutboxEvent", mock.Anything, mock.Anything).Return(nil)
+		mockDB.On("CreateWebhookDeliveries", mock.Anything, mock.Anything).Return(nil)
+
+		response, err := server.Register(context.Background(), &pb.RegisterRequest{
+			Email:    " Alice.Smith@EU.Acme.example ",
+			Password: "password123",
+			Username: "testuser",
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, "Alice.Smith@eu.acme.example", response.User.Email)
+		mockDB.AssertExpectations(t)
+	})
+}
+
+func TestRequestEmailChangeEmailPolicy(t *testing.T) {
+	userID := uuid.New()
+	mockDB := activeUsers(new(mocks.MockQueries))
+	server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+
+	_, err := server.RequestEmailChange(withAccessToken(t, userID), &pb.RequestEmailChangeRequest{
+		NewEmail: "alice@yopmail.com",
+		Password: "password123",
+	})
+
+	assert.Equal(t, map[string]string{"new_email": emailpolicy.ViolationDisposable}, fieldViolations(t, err))
+	mockDB.AssertExpectations(t)
+}
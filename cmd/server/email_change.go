@@ -0,0 +1,341 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/cmd/helper"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/emailpolicy"
+	"github.com/imhasandl/auth-service/internal/redis"
+	pb "github.com/imhasandl/auth-service/protos"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// RequestEmailChange starts moving the account of the logged in user to a new address. The current password
+// is checked again, a code is sent to the new address and the current one is told about the request.
+// Requests count against the login rate limit.
+func (s *Server) RequestEmailChange(ctx context.Context, req *pb.RequestEmailChangeRequest) (*pb.RequestEmailChangeResponse, error) {
+	userID, err := s.authenticatedUser(ctx)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	var (
+		violations autherr.Violations
+		newEmail   emailpolicy.Address
+	)
+	if req.GetNewEmail() == "" {
+		violations.Add("new_email", "new_email is required")
+	} else if addr, violation := s.newEmailViolation(req.GetNewEmail()); violation != "" {
+		violations.Add("new_email", violation)
+	} else {
+		newEmail = addr
+	}
+	if req.GetPassword() == "" {
+		violations.Add("password", "password is required")
+	}
+	if err := violations.Err(); err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	if err := s.checkRateLimit("email_change:" + userID.String()); err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	user, err := s.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	if err := auth.CheckPassword(user.Password, req.GetPassword()); err != nil {
+		return nil, helper.RespondWithError(ctx, autherr.InvalidCredentials().WithCause(err))
+	}
+	if newEmail.Email == user.Email {
+		return nil, helper.RespondWithError(ctx, autherr.InvalidArgument("new_email", "new_email is the current address"))
+	}
+
+	// Changing only the case of the address finds the user themselves
+	taken, err := s.db.GetUserByIdentifier(ctx, database.GetUserByIdentifierParams{EmailCanonical: newEmail.Canonical})
+	switch {
+	case err == nil:
+		if taken.ID != userID {
+			return nil, helper.RespondWithError(ctx, autherr.EmailTaken())
+		}
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	code, err := auth.GenerateVerificationCode(s.codeLength)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	// The new address is kept with the code, so the code can only confirm the address it was sent to
+	_, err = s.db.UpsertVerificationCode(ctx, database.UpsertVerificationCodeParams{
+		UserID:    userID,
+		Purpose:   database.PurposeEmailChange,
+		CodeHash:  auth.HashVerificationCode(code, s.tokenSecret),
+		ExpiresAt: time.Now().Add(s.codeTTL),
+		NewEmail:  newEmail.Email,
+	})
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	if s.email != "test@example.com" {
+		err = auth.SendEmailChangeCodeEmail(newEmail.Email, s.email, s.emailSecret, code, s.codeTTL)
+		if err != nil {
+			return nil, helper.RespondWithError(ctx, autherr.EmailDeliveryFailed(err))
+		}
+		if err := auth.SendEmailChangeRequestedEmail(user.Email, s.email, s.emailSecret, newEmail.Email); err != nil {
+			log.Printf("Error sending email change notice: %v", err)
+		}
+	}
+
+	s.recordEvent(ctx, AuthEvent{
+		Type:    EventEmailChangeRequested,
+		UserID:  userID,
+		Details: map[string]string{"new_email": newEmail.Email},
+	})
+
+	return &pb.RequestEmailChangeResponse{
+		Success:   true,
+		Message:   "A code was sent to the new address",
+		ExpiresIn: int64(s.codeTTL.Seconds()),
+	}, nil
+}
+
+// ConfirmEmailChange moves the account of the logged in user to the address the code was sent to. The new
+// address counts as verified. Every session is signed out and the caller gets a new token pair. The old address
+// is told about the change and, when undo links are enabled, stays reserved for the grace period.
+func (s *Server) ConfirmEmailChange(ctx context.Context, req *pb.ConfirmEmailChangeRequest) (*pb.LoginResponse, error) {
+	userID, err := s.authenticatedUser(ctx)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	stored, err := s.checkCode(ctx, userID, database.PurposeEmailChange, "", func(codeHash string) bool {
+		return auth.CheckVerificationCode(codeHash, req.GetVerificationCode(), s.tokenSecret)
+	})
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	user, err := s.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	var undoToken string
+	if s.emailChangeUndoURL != "" {
+		undoToken, err = auth.MakeEmailChangeUndoToken()
+		if err != nil {
+			return nil, helper.RespondWithError(ctx, err)
+		}
+	}
+
+	var (
+		changed                   database.User
+		accessToken, refreshToken string
+	)
+	err = s.db.WithTx(ctx, func(q DBQuerier) error {
+		consumed, err := q.ConsumeVerificationCode(ctx, database.ConsumeVerificationCodeParams{
+			UserID:   userID,
+			Purpose:  database.PurposeEmailChange,
+			CodeHash: stored.CodeHash,
+		})
+		if err != nil {
+			return err
+		}
+		if consumed == 0 {
+			// Another request used the code first
+			return autherr.VerificationCodeExpired()
+		}
+
+		changed, err = q.ChangeUserEmail(ctx, database.ChangeUserEmailParams{
+			ID:             userID,
+			Email:          stored.NewEmail,
+			IsVerified:     true,
+			EmailCanonical: emailpolicy.LookupKey(stored.NewEmail),
+		})
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) || isEmailUniqueViolation(err) {
+				return autherr.EmailTaken().WithCause(err)
+			}
+			return err
+		}
+
+		// A verification code of the old address can't verify anything anymore
+		err = q.DeleteVerificationCode(ctx, database.DeleteVerificationCodeParams{
+			UserID:  userID,
+			Purpose: database.PurposeEmailVerify,
+		})
+		if err != nil {
+			return err
+		}
+
+		if undoToken != "" {
+			held, err := q.HoldEmail(ctx, database.HoldEmailParams{
+				UserID:      userID,
+				Email:       user.Email,
+				WasVerified: user.IsVerified,
+				ChangedTo:   stored.NewEmail,
+				TokenHash:   auth.HashSingleUseToken(undoToken),
+				ExpiresAt:   time.Now().Add(s.emailChangeGracePeriod),
+			})
+			if err != nil {
+				return err
+			}
+			if held == 0 {
+				// The undo link of an earlier change in the grace period still works and restores an older address
+				undoToken = ""
+			}
+		}
+
+		if err := deleteAllSessions(ctx, q, userID); err != nil {
+			return err
+		}
+		accessToken, refreshToken, err = s.issueTokens(ctx, q, userID)
+		return err
+	})
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	if err := redis.DeleteVerificationCode(emailpolicy.LookupKey(user.Email)); err != nil {
+		log.Printf("Error deleting cached verification code of old address: %v", err)
+	}
+	if err := redis.DeleteAllUserTokens(userID.String()); err != nil {
+		log.Printf("Error deleting cached tokens after email change: %v", err)
+	}
+	cacheTokens(userID, accessToken, refreshToken)
+
+	s.recordEvent(ctx, AuthEvent{
+		Type:      EventEmailChanged,
+		UserID:    userID,
+		SessionID: auth.SessionID(refreshToken),
+		Details:   map[string]string{"old_email": user.Email, "new_email": changed.Email},
+	})
+
+	if err := s.sendEmailChangedEmail(user.Email, changed.Email, undoToken); err != nil {
+		log.Printf("Error sending email changed notice: %v", err)
+	}
+
+	return &pb.LoginResponse{
+		User: &pb.User{
+			Id:         changed.ID.String(),
+			CreatedAt:  timestamppb.New(changed.CreatedAt),
+			UpdatedAt:  timestamppb.New(changed.UpdatedAt),
+			Email:      changed.Email,
+			Username:   changed.Username,
+			IsPremium:  changed.IsPremium,
+			IsVerified: changed.IsVerified,
+		},
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+func (s *Server) sendEmailChangedEmail(oldEmail, newEmail, undoToken string) error {
+	var link string
+	if undoToken != "" {
+		var err error
+		link, err = linkWithToken(s.emailChangeUndoURL, undoToken)
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.email == "test@example.com" {
+		return nil
+	}
+	return auth.SendEmailChangedEmail(oldEmail, s.email, s.emailSecret, newEmail, link, s.emailChangeGracePeriod)
+}
+
+// UndoEmailChange handles the undo link sent to the old address after an email change. The account moves back to
+// the old address, every session is signed out and the password stops working until the user sets a new one with
+// the password reset token in the response. The link works once.
+func (s *Server) UndoEmailChange(ctx context.Context, req *pb.UndoEmailChangeRequest) (*pb.UndoEmailChangeResponse, error) {
+	if req.GetToken() == "" {
+		return nil, helper.RespondWithError(ctx, autherr.InvalidArgument("token", "token is required"))
+	}
+
+	var hold database.EmailHold
+	err := s.db.WithTx(ctx, func(q DBQuerier) error {
+		var err error
+		hold, err = q.TakeEmailHold(ctx, auth.HashSingleUseToken(req.GetToken()))
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return autherr.EmailChangeUndoInvalid().WithCause(err)
+			}
+			return err
+		}
+
+		_, err = q.ChangeUserEmail(ctx, database.ChangeUserEmailParams{
+			ID:             hold.UserID,
+			Email:          hold.Email,
+			IsVerified:     hold.WasVerified,
+			EmailCanonical: emailpolicy.LookupKey(hold.Email),
+		})
+		if err != nil {
+			return err
+		}
+
+		// A change requested by whoever changed the address shouldn't be confirmable afterwards
+		err = q.DeleteVerificationCode(ctx, database.DeleteVerificationCodeParams{
+			UserID:  hold.UserID,
+			Purpose: database.PurposeEmailChange,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := deleteAllSessions(ctx, q, hold.UserID); err != nil {
+			return err
+		}
+		return q.SetUserPassword(ctx, database.SetUserPasswordParams{ID: hold.UserID})
+	})
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	if err := redis.DeleteAllUserTokens(hold.UserID.String()); err != nil {
+		log.Printf("Error deleting cached tokens after undoing email change: %v", err)
+	}
+
+	s.recordEvent(ctx, AuthEvent{
+		Type:    EventEmailChangeUndone,
+		UserID:  hold.UserID,
+		Details: map[string]string{"restored_email": hold.Email},
+	})
+
+	resetToken, err := s.issuePasswordReset(hold.UserID)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	return &pb.UndoEmailChangeResponse{
+		Success:                true,
+		Message:                "The email change was undone and all sessions were signed out. Set a new password to log in again",
+		PasswordResetToken:     resetToken,
+		PasswordResetExpiresIn: int64(s.passwordResetTTL.Seconds()),
+	}, nil
+}
+
+// PurgeExpiredEmailHolds deletes the holds on old addresses whose grace period ended. Expired holds already
+// reserve nothing, this only keeps the table small. main runs it periodically
+func (s *Server) PurgeExpiredEmailHolds(ctx context.Context) error {
+	purged, err := s.db.DeleteExpiredEmailHolds(ctx)
+	if err != nil {
+		return err
+	}
+	if purged > 0 {
+		log.Printf("Purged %d expired email holds", purged)
+	}
+	return nil
+}
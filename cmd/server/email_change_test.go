@@ -0,0 +1,287 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/database/mocks"
+	"github.com/imhasandl/auth-service/internal/redis"
+	pb "github.com/imhasandl/auth-service/protos"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func newEmailChangeServer(mockDB *mocks.MockQueries, sink AuditSink) *Server {
+	return NewServer(mockDB, "test-secret", "test@example.com", "email-secret",
+		WithAuditSink(sink, false),
+		WithEmailChangeUndo("https://example.com/undo", 7*24*time.Hour),
+	)
+}
+
+func TestRequestEmailChange(t *testing.T) {
+	userID := uuid.New()
+	hashedPassword, err := auth.HashPassword("password123")
+	require.NoError(t, err)
+	user := database.User{ID: userID, Email: "old@example.com", Password: hashedPassword}
+
+	testCases := []struct {
+		name      string
+		request   *pb.RequestEmailChangeRequest
+		mockSetup func(*mocks.MockQueries)
+		code      codes.Code
+		reason    autherr.Reason
+	}{
+		{
+			name:    "code sent",
+			request: &pb.RequestEmailChangeRequest{NewEmail: "new@example.com", Password: "password123"},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByID", mock.Anything, userID).Return(user, nil)
+				mockDB.On("GetUserByIdentifier", mock.Anything, database.GetUserByIdentifierParams{EmailCanonical: "new@example.com"}).
+					Return(database.User{}, sql.ErrNoRows)
+				mockDB.On("UpsertVerificationCode", mock.Anything, mock.MatchedBy(func(arg database.UpsertVerificationCodeParams) bool {
+					return arg.UserID == userID && arg.Purpose == database.PurposeEmailChange &&
+						arg.NewEmail == "new@example.com" && arg.CodeHash != "" && arg.ExpiresAt.After(time.Now())
+				})).Return(database.VerificationCode{}, nil)
+			},
+			code: codes.OK,
+		},
+		{
+			name:      "missing fields",
+			request:   &pb.RequestEmailChangeRequest{},
+			mockSetup: func(mockDB *mocks.MockQueries) {},
+			code:      codes.InvalidArgument,
+			reason:    autherr.ReasonInvalidArgument,
+		},
+		{
+			name:    "wrong password",
+			request: &pb.RequestEmailChangeRequest{NewEmail: "new@example.com", Password: "wrong"},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByID", mock.Anything, userID).Return(user, nil)
+			},
+			code:   codes.Unauthenticated,
+			reason: autherr.ReasonInvalidCredentials,
+		},
+		{
+			name:    "current address",
+			request: &pb.RequestEmailChangeRequest{NewEmail: "old@example.com", Password: "password123"},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByID", mock.Anything, userID).Return(user, nil)
+			},
+			code:   codes.InvalidArgument,
+			reason: autherr.ReasonInvalidArgument,
+		},
+		{
+			name:    "address taken",
+			request: &pb.RequestEmailChangeRequest{NewEmail: "taken@example.com", Password: "password123"},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByID", mock.Anything, userID).Return(user, nil)
+				mockDB.On("GetUserByIdentifier", mock.Anything, database.GetUserByIdentifierParams{EmailCanonical: "taken@example.com"}).
+					Return(database.User{ID: uuid.New(), Email: "taken@example.com"}, nil)
+			},
+			code:   codes.AlreadyExists,
+			reason: autherr.ReasonEmailTaken,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testRedis.FlushAll()
+			mockDB := activeUsers(new(mocks.MockQueries))
+			tc.mockSetup(mockDB)
+			sink := &recordingSink{}
+			server := newEmailChangeServer(mockDB, sink)
+
+			response, err := server.RequestEmailChange(withAccessToken(t, userID), tc.request)
+
+			mockDB.AssertExpectations(t)
+			if tc.code != codes.OK {
+				assertReason(t, err, tc.code, tc.reason)
+				assert.Empty(t, sink.events)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, response.Success)
+			assert.Equal(t, int64(900), response.ExpiresIn)
+			require.Len(t, sink.events, 1)
+			assert.Equal(t, EventEmailChangeRequested, sink.events[0].Type)
+			assert.Equal(t, "new@example.com", sink.events[0].Details["new_email"])
+		})
+	}
+
+	t.Run("not logged in", func(t *testing.T) {
+		server := newEmailChangeServer(new(mocks.MockQueries), &recordingSink{})
+
+		_, err := server.RequestEmailChange(context.Background(), &pb.RequestEmailChangeRequest{
+			NewEmail: "new@example.com",
+			Password: "password123",
+		})
+		assertReason(t, err, codes.Unauthenticated, autherr.ReasonAccessTokenInvalid)
+	})
+}
+
+func TestConfirmEmailChange(t *testing.T) {
+	userID := uuid.New()
+	user := database.User{ID: userID, Email: "old@example.com", IsVerified: false}
+	pending := database.VerificationCode{
+		UserID:    userID,
+		Purpose:   database.PurposeEmailChange,
+		CodeHash:  auth.HashVerificationCode(123456, "test-secret"),
+		ExpiresAt: time.Now().Add(time.Minute),
+		NewEmail:  "new@example.com",
+	}
+	codeParams := database.GetVerificationCodeParams{UserID: userID, Purpose: database.PurposeEmailChange}
+
+	t.Run("confirmed", func(t *testing.T) {
+		testRedis.FlushAll()
+		require.NoError(t, redis.CacheVerificationCode("old@example.com", "hash", time.Minute))
+
+		var undoTokenHash string
+		mockDB := activeUsers(new(mocks.MockQueries))
+		mockDB.On("GetVerificationCode", mock.Anything, codeParams).Return(pending, nil)
+		mockDB.On("GetUserByID", mock.Anything, userID).Return(user, nil)
+		mockDB.On("ConsumeVerificationCode", mock.Anything, database.ConsumeVerificationCodeParams{
+			UserID:   userID,
+			Purpose:  database.PurposeEmailChange,
+			CodeHash: pending.CodeHash,
+		}).Return(int64(1), nil)
+		mockDB.On("ChangeUserEmail", mock.Anything, database.ChangeUserEmailParams{
+			ID:             userID,
+			Email:          "new@example.com",
+			IsVerified:     true,
+			EmailCanonical: "new@example.com",
+		}).Return(database.User{ID: userID, Email: "new@example.com", IsVerified: true}, nil)
+		mockDB.On("DeleteVerificationCode", mock.Anything, database.DeleteVerificationCodeParams{
+			UserID:  userID,
+			Purpose: database.PurposeEmailVerify,
+		}).Return(nil)
+		mockDB.On("HoldEmail", mock.Anything, mock.MatchedBy(func(arg database.HoldEmailParams) bool {
+			undoTokenHash = arg.TokenHash
+			return arg.UserID == userID && arg.Email == "old@example.com" && !arg.WasVerified &&
+				arg.ChangedTo == "new@example.com" && arg.TokenHash != "" &&
+				time.Until(arg.ExpiresAt) > 6*24*time.Hour
+		})).Return(int64(1), nil)
+		mockDB.On("DeleteTokenByUserID", mock.Anything, userID).Return(nil)
+		mockDB.On("DeleteUserDeviceTokens", mock.Anything, userID).Return(nil)
+		expectLoggedOutEverywhere(t, mockDB, userID)
+		mockDB.On("GetUserAuthorization", mock.Anything, userID).Return(database.GetUserAuthorizationRow{}, nil)
+		mockDB.On("RefreshToken", mock.Anything, mock.MatchedBy(func(arg database.RefreshTokenParams) bool {
+			return arg.UserID == userID
+		})).Return(database.RefreshToken{}, nil)
+		sink := &recordingSink{}
+		server := newEmailChangeServer(mockDB, sink)
+
+		response, err := server.ConfirmEmailChange(withAccessToken(t, userID), &pb.ConfirmEmailChangeRequest{VerificationCode: 123456})
+		require.NoError(t, err)
+		mockDB.AssertExpectations(t)
+
+		assert.Equal(t, "new@example.com", response.User.Email)
+		assert.True(t, response.User.IsVerified)
+		assert.NotEmpty(t, response.Token)
+		assert.NotEmpty(t, response.RefreshToken)
+		assert.NotEmpty(t, undoTokenHash)
+
+		_, err = redis.GetVerificationCode("old@example.com")
+		assert.ErrorIs(t, err, redis.Nil)
+
+		require.Len(t, sink.events, 1)
+		assert.Equal(t, EventEmailChanged, sink.events[0].Type)
+		assert.Equal(t, auth.SessionID(response.RefreshToken), sink.events[0].SessionID)
+		assert.Equal(t, map[string]string{"old_email": "old@example.com", "new_email": "new@example.com"}, sink.events[0].Details)
+	})
+
+	t.Run("address taken in the meantime", func(t *testing.T) {
+		mockDB := activeUsers(new(mocks.MockQueries))
+		mockDB.On("GetVerificationCode", mock.Anything, codeParams).Return(pending, nil)
+		mockDB.On("GetUserByID", mock.Anything, userID).Return(user, nil)
+		mockDB.On("ConsumeVerificationCode", mock.Anything, mock.Anything).Return(int64(1), nil)
+		mockDB.On("ChangeUserEmail", mock.Anything, mock.Anything).Return(database.User{}, sql.ErrNoRows)
+		server := newEmailChangeServer(mockDB, &recordingSink{})
+
+		_, err := server.ConfirmEmailChange(withAccessToken(t, userID), &pb.ConfirmEmailChangeRequest{VerificationCode: 123456})
+		assertReason(t, err, codes.AlreadyExists, autherr.ReasonEmailTaken)
+	})
+
+	t.Run("wrong code", func(t *testing.T) {
+		mockDB := activeUsers(new(mocks.MockQueries))
+		mockDB.On("GetVerificationCode", mock.Anything, codeParams).Return(pending, nil)
+		mockDB.On("IncrementVerificationCodeAttempts", mock.Anything, database.IncrementVerificationCodeAttemptsParams{
+			UserID:  userID,
+			Purpose: database.PurposeEmailChange,
+		}).Return(int32(1), nil)
+		server := newEmailChangeServer(mockDB, &recordingSink{})
+
+		_, err := server.ConfirmEmailChange(withAccessToken(t, userID), &pb.ConfirmEmailChangeRequest{VerificationCode: 654321})
+		assertReason(t, err, codes.InvalidArgument, autherr.ReasonVerificationCodeInvalid)
+		mockDB.AssertNotCalled(t, "ChangeUserEmail", mock.Anything, mock.Anything)
+	})
+
+	t.Run("no pending change", func(t *testing.T) {
+		mockDB := activeUsers(new(mocks.MockQueries))
+		mockDB.On("GetVerificationCode", mock.Anything, codeParams).Return(database.VerificationCode{}, sql.ErrNoRows)
+		server := newEmailChangeServer(mockDB, &recordingSink{})
+
+		_, err := server.ConfirmEmailChange(withAccessToken(t, userID), &pb.ConfirmEmailChangeRequest{VerificationCode: 123456})
+		assertReason(t, err, codes.FailedPrecondition, autherr.ReasonVerificationCodeExpired)
+	})
+}
+
+func TestUndoEmailChange(t *testing.T) {
+	testRedis.FlushAll()
+	userID := uuid.New()
+	hold := database.EmailHold{
+		UserID:      userID,
+		Email:       "old@example.com",
+		WasVerified: true,
+		ChangedTo:   "new@example.com",
+		ExpiresAt:   time.Now().Add(time.Hour),
+	}
+
+	mockDB := new(mocks.MockQueries)
+	mockDB.On("TakeEmailHold", mock.Anything, auth.HashSingleUseToken("undo-token")).Return(hold, nil).Once()
+	mockDB.On("TakeEmailHold", mock.Anything, auth.HashSingleUseToken("undo-token")).Return(database.EmailHold{}, sql.ErrNoRows)
+	mockDB.On("ChangeUserEmail", mock.Anything, database.ChangeUserEmailParams{
+		ID:             userID,
+		Email:          "old@example.com",
+		IsVerified:     true,
+		EmailCanonical: "old@example.com",
+	}).Return(database.User{ID: userID, Email: "old@example.com", IsVerified: true}, nil)
+	mockDB.On("DeleteVerificationCode", mock.Anything, database.DeleteVerificationCodeParams{
+		UserID:  userID,
+		Purpose: database.PurposeEmailChange,
+	}).Return(nil)
+	mockDB.On("DeleteTokenByUserID", mock.Anything, userID).Return(nil)
+	mockDB.On("DeleteUserDeviceTokens", mock.Anything, userID).Return(nil)
+	expectLoggedOutEverywhere(t, mockDB, userID)
+	mockDB.On("SetUserPassword", mock.Anything, database.SetUserPasswordParams{ID: userID}).Return(nil)
+	sink := &recordingSink{}
+	server := newEmailChangeServer(mockDB, sink)
+
+	response, err := server.UndoEmailChange(context.Background(), &pb.UndoEmailChangeRequest{Token: "undo-token"})
+	require.NoError(t, err)
+	mockDB.AssertExpectations(t)
+	assert.True(t, response.Success)
+	assert.NotEmpty(t, response.PasswordResetToken)
+	assert.Equal(t, int64(3600), response.PasswordResetExpiresIn)
+
+	resetUserID, err := redis.TakePasswordReset(auth.HashSingleUseToken(response.PasswordResetToken))
+	require.NoError(t, err)
+	assert.Equal(t, userID.String(), resetUserID)
+
+	require.Len(t, sink.events, 1)
+	assert.Equal(t, EventEmailChangeUndone, sink.events[0].Type)
+	assert.Equal(t, "old@example.com", sink.events[0].Details["restored_email"])
+
+	_, err = server.UndoEmailChange(context.Background(), &pb.UndoEmailChangeRequest{Token: "undo-token"})
+	assertReason(t, err, codes.Unauthenticated, autherr.ReasonEmailChangeUndoInvalid)
+
+	_, err = server.UndoEmailChange(context.Background(), &pb.UndoEmailChangeRequest{})
+	assertReason(t, err, codes.InvalidArgument, autherr.ReasonInvalidArgument)
+}
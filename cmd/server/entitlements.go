@@ -0,0 +1,219 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/helper"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	pb "github.com/imhasandl/auth-service/protos"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ListPlans lists the plans entitlements can be granted for.
+func (s *AdminServer) ListPlans(ctx context.Context, _ *pb.ListPlansRequest) (*pb.ListPlansResponse, error) {
+	actor, err := adminActor(ctx)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	plans, err := s.db.ListPlans(ctx)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	if err := s.audit(ctx, s.db, actor, auditListPlans, uuid.Nil, nil); err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	response := &pb.ListPlansResponse{}
+	for _, plan := range plans {
+		response.Plans = append(response.Plans, &pb.Plan{
+			Id:      plan.ID,
+			Name:    plan.Name,
+			Premium: plan.Premium,
+		})
+	}
+	return response, nil
+}
+
+// GrantEntitlement entitles a user to a plan, from starts_at or now until expires_at or for good. Access tokens
+// issued from then on carry the plan, and the user becomes premium for premium plans.
+func (s *AdminServer) GrantEntitlement(ctx context.Context, req *pb.GrantEntitlementRequest) (*pb.EntitlementResponse, error) {
+	actor, userID, err := adminRequest(ctx, req.GetUserId())
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	now := time.Now()
+	startsAt := now
+	if req.StartsAt != nil {
+		startsAt = req.GetStartsAt().AsTime()
+	}
+	var violations autherr.Violations
+	if req.GetPlanId() == "" {
+		violations.Add("plan_id", "plan_id is required")
+	}
+	expiresAt := sql.NullTime{}
+	if req.ExpiresAt != nil {
+		expiresAt = sql.NullTime{Time: req.GetExpiresAt().AsTime(), Valid: true}
+		if !expiresAt.Time.After(startsAt) {
+			violations.Add("expires_at", "expires_at should be after starts_at")
+		} else if !expiresAt.Time.After(now) {
+			violations.Add("expires_at", "expires_at should be in the future")
+		}
+	}
+	if err := violations.Err(); err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	var entitlement database.UserEntitlement
+	err = s.db.WithTx(ctx, func(q DBQuerier) error {
+		if _, err := s.adminGetUser(ctx, q, userID); err != nil {
+			return err
+		}
+		if _, err := q.GetPlan(ctx, req.GetPlanId()); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return autherr.PlanNotFound(req.GetPlanId()).WithCause(err)
+			}
+			return err
+		}
+		entitlement, err = q.CreateEntitlement(ctx, database.CreateEntitlementParams{
+			ID:        uuid.New(),
+			UserID:    userID,
+			PlanID:    req.GetPlanId(),
+			StartsAt:  startsAt,
+			ExpiresAt: expiresAt,
+			GrantedBy: actor,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := q.SyncPremiumUsers(ctx, uuid.NullUUID{UUID: userID, Valid: true}); err != nil {
+			return err
+		}
+		details := map[string]any{
+			"entitlement_id": entitlement.ID.String(),
+			"plan_id":        entitlement.PlanID,
+			"starts_at":      entitlement.StartsAt,
+		}
+		if entitlement.ExpiresAt.Valid {
+			details["expires_at"] = entitlement.ExpiresAt.Time
+		}
+		return s.audit(ctx, q, actor, auditGrantEntitlement, userID, details)
+	})
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	return &pb.EntitlementResponse{Entitlement: userEntitlement(entitlement, now)}, nil
+}
+
+// RevokeEntitlement ends an entitlement right away, whether an admin granted it or it came from the billing
+// provider. A later billing event for the same subscription restores it.
+func (s *AdminServer) RevokeEntitlement(ctx context.Context, req *pb.RevokeEntitlementRequest) (*pb.EntitlementResponse, error) {
+	actor, err := adminActor(ctx)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	entitlementID, err := uuid.Parse(req.GetEntitlementId())
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, autherr.InvalidArgument("entitlement_id", "entitlement_id should be a UUID"))
+	}
+
+	var entitlement database.UserEntitlement
+	err = s.db.WithTx(ctx, func(q DBQuerier) error {
+		entitlement, err = q.RevokeEntitlement(ctx, entitlementID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return autherr.EntitlementNotFound().WithCause(err)
+			}
+			return err
+		}
+		if _, err := q.SyncPremiumUsers(ctx, uuid.NullUUID{UUID: entitlement.UserID, Valid: true}); err != nil {
+			return err
+		}
+		return s.audit(ctx, q, actor, auditRevokeEntitlement, entitlement.UserID, map[string]any{
+			"entitlement_id": entitlement.ID.String(),
+			"plan_id":        entitlement.PlanID,
+		})
+	})
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	return &pb.EntitlementResponse{Entitlement: userEntitlement(entitlement, time.Now())}, nil
+}
+
+// ListEntitlements lists every entitlement of a user, newest first, including expired and revoked ones.
+func (s *AdminServer) ListEntitlements(ctx context.Context, req *pb.ListEntitlementsRequest) (*pb.ListEntitlementsResponse, error) {
+	actor, userID, err := adminRequest(ctx, req.GetUserId())
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	user, err := s.adminGetUser(ctx, s.db, userID)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	entitlements, err := s.db.ListUserEntitlements(ctx, userID)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	if err := s.audit(ctx, s.db, actor, auditListEntitlements, userID, nil); err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	now := time.Now()
+	response := &pb.ListEntitlementsResponse{Premium: user.IsPremium}
+	for _, entitlement := range entitlements {
+		response.Entitlements = append(response.Entitlements, userEntitlement(entitlement, now))
+	}
+	return response, nil
+}
+
+// SyncPremiumUsers updates is_premium of the users whose premium entitlements started or expired since the last
+// run. Access tokens don't need it, they are issued with the entitlements active at the time
+func (s *Server) SyncPremiumUsers(ctx context.Context) error {
+	changed, err := s.db.SyncPremiumUsers(ctx, uuid.NullUUID{})
+	if err != nil {
+		return err
+	}
+	if len(changed) > 0 {
+		log.Printf("Updated premium status of %d users", len(changed))
+	}
+	return nil
+}
+
+func userEntitlement(entitlement database.UserEntitlement, now time.Time) *pb.Entitlement {
+	result := &pb.Entitlement{
+		Id:         entitlement.ID.String(),
+		UserId:     entitlement.UserID.String(),
+		PlanId:     entitlement.PlanID,
+		Source:     entitlement.Source,
+		ExternalId: entitlement.ExternalID.String,
+		StartsAt:   timestamppb.New(entitlement.StartsAt),
+		GrantedBy:  entitlement.GrantedBy,
+		Active:     entitlementActive(entitlement, now),
+		CreatedAt:  timestamppb.New(entitlement.CreatedAt),
+	}
+	if entitlement.ExpiresAt.Valid {
+		result.ExpiresAt = timestamppb.New(entitlement.ExpiresAt.Time)
+	}
+	if entitlement.RevokedAt.Valid {
+		result.RevokedAt = timestamppb.New(entitlement.RevokedAt.Time)
+	}
+	return result
+}
+
+// entitlementActive matches the entitlements GetUserAuthorization puts into access tokens
+func entitlementActive(entitlement database.UserEntitlement, now time.Time) bool {
+	return !entitlement.RevokedAt.Valid && !entitlement.StartsAt.After(now) &&
+		(!entitlement.ExpiresAt.Valid || entitlement.ExpiresAt.Time.After(now))
+}
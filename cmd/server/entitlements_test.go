@@ -0,0 +1,202 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/database/mocks"
+	pb "github.com/imhasandl/auth-service/protos"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestGrantEntitlement(t *testing.T) {
+	adminID, userID := uuid.New(), uuid.New()
+	expiresAt := time.Now().Add(30 * 24 * time.Hour).UTC().Truncate(time.Second)
+
+	t.Run("grants and syncs premium", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		admin := NewServer(mockDB, "test-secret", "test@example.com", "email-secret").Admin()
+		mockDB.On("GetUserByID", mock.Anything, userID).Return(database.User{ID: userID}, nil)
+		mockDB.On("GetPlan", mock.Anything, "premium").Return(database.Plan{ID: "premium", Premium: true}, nil)
+		var created database.CreateEntitlementParams
+		mockDB.On("CreateEntitlement", mock.Anything, mock.Anything).
+			Run(func(args mock.Arguments) { created = args.Get(1).(database.CreateEntitlementParams) }).
+			Return(database.UserEntitlement{
+				ID:        uuid.New(),
+				UserID:    userID,
+				PlanID:    "premium",
+				Source:    database.EntitlementSourceAdmin,
+				StartsAt:  time.Now().Add(-time.Second),
+				ExpiresAt: sql.NullTime{Time: expiresAt, Valid: true},
+				GrantedBy: adminID.String(),
+			}, nil)
+		mockDB.On("SyncPremiumUsers", mock.Anything, uuid.NullUUID{UUID: userID, Valid: true}).
+			Return([]database.SyncPremiumUsersRow{{ID: userID, IsPremium: true}}, nil)
+		details := expectAudit(mockDB, adminID, auditGrantEntitlement, userID)
+
+		response, err := admin.GrantEntitlement(adminContext(adminID), &pb.GrantEntitlementRequest{
+			UserId:    userID.String(),
+			PlanId:    "premium",
+			ExpiresAt: timestamppb.New(expiresAt),
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, userID, created.UserID)
+		assert.Equal(t, adminID.String(), created.GrantedBy)
+		assert.Equal(t, sql.NullTime{Time: expiresAt, Valid: true}, created.ExpiresAt)
+		assert.WithinDuration(t, time.Now(), created.StartsAt, time.Minute)
+		assert.True(t, response.Entitlement.Active)
+		assert.Equal(t, database.EntitlementSourceAdmin, response.Entitlement.Source)
+		assert.Equal(t, "premium", (*details)["plan_id"])
+		assert.Contains(t, *details, "expires_at")
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("unknown plan", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		admin := NewServer(mockDB, "test-secret", "test@example.com", "email-secret").Admin()
+		mockDB.On("GetUserByID", mock.Anything, userID).Return(database.User{ID: userID}, nil)
+		mockDB.On("GetPlan", mock.Anything, "gold").Return(database.Plan{}, sql.ErrNoRows)
+
+		_, err := admin.GrantEntitlement(adminContext(adminID), &pb.GrantEntitlementRequest{UserId: userID.String(), PlanId: "gold"})
+
+		assertReason(t, err, codes.NotFound, autherr.ReasonPlanNotFound)
+		mockDB.AssertNotCalled(t, "CreateEntitlement", mock.Anything, mock.Anything)
+	})
+
+	t.Run("unknown user", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		admin := NewServer(mockDB, "test-secret", "test@example.com", "email-secret").Admin()
+		mockDB.On("GetUserByID", mock.Anything, userID).Return(database.User{}, sql.ErrNoRows)
+
+		_, err := admin.GrantEntitlement(adminContext(adminID), &pb.GrantEntitlementRequest{UserId: userID.String(), PlanId: "premium"})
+
+		assertReason(t, err, codes.NotFound, autherr.ReasonUserNotFound)
+	})
+
+	testCases := []struct {
+		name string
+		req  *pb.GrantEntitlementRequest
+	}{
+		{name: "no plan", req: &pb.GrantEntitlementRequest{UserId: userID.String()}},
+		{name: "invalid user id", req: &pb.GrantEntitlementRequest{UserId: "nope", PlanId: "premium"}},
+		{name: "expired", req: &pb.GrantEntitlementRequest{
+			UserId:    userID.String(),
+			PlanId:    "premium",
+			StartsAt:  timestamppb.New(time.Now().Add(-48 * time.Hour)),
+			ExpiresAt: timestamppb.New(time.Now().Add(-time.Hour)),
+		}},
+		{name: "expires before it starts", req: &pb.GrantEntitlementRequest{
+			UserId:    userID.String(),
+			PlanId:    "premium",
+			StartsAt:  timestamppb.New(time.Now().Add(48 * time.Hour)),
+			ExpiresAt: timestamppb.New(time.Now().Add(24 * time.Hour)),
+		}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB := new(mocks.MockQueries)
+			admin := NewServer(mockDB, "test-secret", "test@example.com", "email-secret").Admin()
+
+			_, err := admin.GrantEntitlement(adminContext(adminID), tc.req)
+
+			assertReason(t, err, codes.InvalidArgument, autherr.ReasonInvalidArgument)
+			mockDB.AssertNotCalled(t, "CreateEntitlement", mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestRevokeEntitlement(t *testing.T) {
+	adminID, userID, entitlementID := uuid.New(), uuid.New(), uuid.New()
+
+	t.Run("revokes and syncs premium", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		admin := NewServer(mockDB, "test-secret", "test@example.com", "email-secret").Admin()
+		mockDB.On("RevokeEntitlement", mock.Anything, entitlementID).Return(database.UserEntitlement{
+			ID:         entitlementID,
+			UserID:     userID,
+			PlanID:     "premium",
+			Source:     database.EntitlementSourceBilling,
+			ExternalID: sql.NullString{String: "sub_1", Valid: true},
+			StartsAt:   time.Now().Add(-time.Hour),
+			RevokedAt:  sql.NullTime{Time: time.Now(), Valid: true},
+		}, nil)
+		mockDB.On("SyncPremiumUsers", mock.Anything, uuid.NullUUID{UUID: userID, Valid: true}).
+			Return([]database.SyncPremiumUsersRow{{ID: userID, IsPremium: false}}, nil)
+		details := expectAudit(mockDB, adminID, auditRevokeEntitlement, userID)
+
+		response, err := admin.RevokeEntitlement(adminContext(adminID), &pb.RevokeEntitlementRequest{EntitlementId: entitlementID.String()})
+		require.NoError(t, err)
+
+		assert.False(t, response.Entitlement.Active)
+		assert.NotNil(t, response.Entitlement.RevokedAt)
+		assert.Equal(t, "sub_1", response.Entitlement.ExternalId)
+		assert.Equal(t, entitlementID.String(), (*details)["entitlement_id"])
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("unknown entitlement", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		admin := NewServer(mockDB, "test-secret", "test@example.com", "email-secret").Admin()
+		mockDB.On("RevokeEntitlement", mock.Anything, entitlementID).Return(database.UserEntitlement{}, sql.ErrNoRows)
+
+		_, err := admin.RevokeEntitlement(adminContext(adminID), &pb.RevokeEntitlementRequest{EntitlementId: entitlementID.String()})
+
+		assertReason(t, err, codes.NotFound, autherr.ReasonEntitlementNotFound)
+		mockDB.AssertNotCalled(t, "CreateAdminAuditEntry", mock.Anything, mock.Anything)
+	})
+
+	t.Run("invalid id", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		admin := NewServer(mockDB, "test-secret", "test@example.com", "email-secret").Admin()
+
+		_, err := admin.RevokeEntitlement(adminContext(adminID), &pb.RevokeEntitlementRequest{EntitlementId: "nope"})
+
+		assertReason(t, err, codes.InvalidArgument, autherr.ReasonInvalidArgument)
+	})
+}
+
+func TestListEntitlements(t *testing.T) {
+	adminID, userID := uuid.New(), uuid.New()
+	now := time.Now()
+	mockDB := new(mocks.MockQueries)
+	admin := NewServer(mockDB, "test-secret", "test@example.com", "email-secret").Admin()
+	mockDB.On("GetUserByID", mock.Anything, userID).Return(database.User{ID: userID, IsPremium: true}, nil)
+	mockDB.On("ListUserEntitlements", mock.Anything, userID).Return([]database.UserEntitlement{
+		{ID: uuid.New(), UserID: userID, PlanID: "premium", StartsAt: now.Add(24 * time.Hour)},
+		{ID: uuid.New(), UserID: userID, PlanID: "premium", StartsAt: now.Add(-time.Hour)},
+		{ID: uuid.New(), UserID: userID, PlanID: "premium", StartsAt: now.Add(-48 * time.Hour),
+			ExpiresAt: sql.NullTime{Time: now.Add(-24 * time.Hour), Valid: true}},
+	}, nil)
+	expectAudit(mockDB, adminID, auditListEntitlements, userID)
+
+	response, err := admin.ListEntitlements(adminContext(adminID), &pb.ListEntitlementsRequest{UserId: userID.String()})
+	require.NoError(t, err)
+
+	assert.True(t, response.Premium)
+	require.Len(t, response.Entitlements, 3)
+	assert.False(t, response.Entitlements[0].Active, "not started")
+	assert.True(t, response.Entitlements[1].Active)
+	assert.False(t, response.Entitlements[2].Active, "expired")
+	assert.Nil(t, response.Entitlements[1].ExpiresAt)
+	mockDB.AssertExpectations(t)
+}
+
+func TestSyncPremiumUsers(t *testing.T) {
+	mockDB := new(mocks.MockQueries)
+	server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+	mockDB.On("SyncPremiumUsers", mock.Anything, uuid.NullUUID{}).
+		Return([]database.SyncPremiumUsersRow{{ID: uuid.New(), IsPremium: false}}, nil)
+
+	require.NoError(t, server.SyncPremiumUsers(context.Background()))
+	mockDB.AssertExpectations(t)
+}
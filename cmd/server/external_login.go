@@ -0,0 +1,269 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/helper"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/emailpolicy"
+	"github.com/imhasandl/auth-service/internal/oauth"
+	"github.com/imhasandl/auth-service/internal/outbox"
+	"github.com/imhasandl/auth-service/internal/redis"
+	pb "github.com/imhasandl/auth-service/protos"
+	eventsv1 "github.com/imhasandl/auth-service/protos/events/v1"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// externalLoginTTL is how long a started external login can be completed
+const externalLoginTTL = 10 * time.Minute
+
+// externalLoginState is kept in Redis between StartExternalLogin and CompleteExternalLogin
+type externalLoginState struct {
+	Provider string     `json:"provider"`
+	Flow     oauth.Flow `json:"flow"`
+}
+
+// usernameUnsafeChars matches characters removed from emails when generating usernames for external accounts
+var usernameUnsafeChars = regexp.MustCompile(`[^a-z0-9_.]`)
+
+// StartExternalLogin begins the authorization code flow with an external provider.
+// The client sends the user to the returned URL, and passes the state and code returned to the redirect URL to CompleteExternalLogin.
+func (s *Server) StartExternalLogin(ctx context.Context, req *pb.StartExternalLoginRequest) (*pb.StartExternalLoginResponse, error) {
+	provider, err := s.externalProvider(req.GetProvider())
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	flow, err := oauth.NewFlow()
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	state, err := json.Marshal(externalLoginState{
+		Provider: provider.Name(),
+		Flow:     flow,
+	})
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	err = redis.SaveExternalLoginState(flow.State, string(state), externalLoginTTL)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, autherr.Unavailable(err, 5*time.Second))
+	}
+
+	return &pb.StartExternalLoginResponse{
+		AuthorizationUrl: provider.AuthCodeURL(flow),
+		State:            flow.State,
+	}, nil
+}
+
+// CompleteExternalLogin redeems the authorization code and logs the user in with the same tokens as Login.
+// A provider account that was used before logs in to its linked user. Otherwise it is linked to the user with the
+// same verified email, or a new user is created.
+func (s *Server) CompleteExternalLogin(ctx context.Context, req *pb.CompleteExternalLoginRequest) (*pb.LoginResponse, error) {
+	var violations autherr.Violations
+	if req.GetState() == "" {
+		violations.Add("state", "state is required")
+	}
+	if req.GetCode() == "" {
+		violations.Add("code", "code is required")
+	}
+	if err := violations.Err(); err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	provider, err := s.externalProvider(req.GetProvider())
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	rawState, err := redis.TakeExternalLoginState(req.GetState())
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, helper.RespondWithError(ctx, autherr.ExternalLoginStateInvalid())
+		}
+		return nil, helper.RespondWithError(ctx, autherr.Unavailable(err, 5*time.Second))
+	}
+
+	var state externalLoginState
+	if err := json.Unmarshal([]byte(rawState), &state); err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	if state.Provider != provider.Name() {
+		return nil, helper.RespondWithError(ctx, autherr.ExternalLoginStateInvalid())
+	}
+
+	method := "external:" + provider.Name()
+	identity, err := provider.Exchange(ctx, req.GetCode(), state.Flow)
+	if err != nil {
+		err = autherr.ExternalLoginFailed(err)
+		s.recordLoginFailure(ctx, uuid.Nil, "", method, err)
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	var (
+		user                      database.User
+		accessToken, refreshToken string
+	)
+	err = s.db.WithTx(ctx, func(q DBQuerier) error {
+		user, err = s.externalUser(ctx, q, provider.Name(), identity)
+		if err != nil {
+			return err
+		}
+
+		accessToken, refreshToken, err = s.issueTokens(ctx, q, user.ID)
+		return err
+	})
+	if err != nil {
+		s.recordLoginFailure(ctx, user.ID, identity.Email, method, err)
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	cacheTokens(user.ID, accessToken, refreshToken)
+	s.recordLogin(ctx, user.ID, method, refreshToken)
+
+	return &pb.LoginResponse{
+		User: &pb.User{
+			Id:         user.ID.String(),
+			CreatedAt:  timestamppb.New(user.CreatedAt),
+			UpdatedAt:  timestamppb.New(user.UpdatedAt),
+			Email:      user.Email,
+			Username:   user.Username,
+			IsPremium:  user.IsPremium,
+			IsVerified: user.IsVerified,
+		},
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// externalProvider returns the configured provider with the given name
+func (s *Server) externalProvider(name string) (oauth.Provider, error) {
+	if name == "" {
+		return nil, autherr.InvalidArgument("provider", "provider is required")
+	}
+
+	provider, ok := s.externalProviders[name]
+	if !ok {
+		return nil, autherr.InvalidArgument("provider", "unknown provider")
+	}
+
+	return provider, nil
+}
+
+// externalUser finds or creates the user for a provider identity and links the identity to it.
+// Identities are only linked by email when both the provider and the local account verified the address
+func (s *Server) externalUser(ctx context.Context, q DBQuerier, provider string, identity oauth.Identity) (database.User, error) {
+	linked, err := q.GetUserIdentity(ctx, database.GetUserIdentityParams{
+		Provider: provider,
+		Subject:  identity.Subject,
+	})
+	if err == nil {
+		return q.GetUserByID(ctx, linked.UserID)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, err
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return database.User{}, autherr.ExternalEmailUnverified()
+	}
+
+	user, err := q.GetUserByIdentifier(ctx, database.GetUserByIdentifierParams{
+		EmailCanonical: emailpolicy.LookupKey(identity.Email),
+	})
+	switch {
+	case err == nil:
+		if !user.IsVerified {
+			return database.User{}, autherr.ExternalAccountConflict()
+		}
+	case errors.Is(err, sql.ErrNoRows):
+		// Creating an account this way is registering, so the provider's address has to pass the email policy
+		email, violation := s.newEmailViolation(identity.Email)
+		if violation != "" {
+			return database.User{}, autherr.InvalidArgument("email", violation)
+		}
+
+		username, err := externalUsername(email.Email)
+		if err != nil {
+			return database.User{}, err
+		}
+
+		// External accounts have no password, so password login always fails for them
+		user, err = q.CreateUser(ctx, database.CreateUserParams{
+			ID:             uuid.New(),
+			Email:          email.Email,
+			Password:       "",
+			Username:       username,
+			IsPremium:      false,
+			IsVerified:     true,
+			EmailCanonical: email.Canonical,
+		})
+		if errors.Is(err, sql.ErrNoRows) || isEmailUniqueViolation(err) {
+			// The address is held for the undo link of another account's email change, or another login
+			// created the account first
+			return database.User{}, autherr.ExternalAccountConflict().WithCause(err)
+		}
+		if err != nil {
+			return database.User{}, err
+		}
+
+		// The provider verified the address, so the account is verified from the start
+		err = enqueueEvent(ctx, q, outbox.UserRegistered, user.ID, &eventsv1.UserRegistered{
+			UserId:   user.ID.String(),
+			Username: user.Username,
+			Provider: provider,
+		})
+		if err != nil {
+			return database.User{}, err
+		}
+		err = enqueueEvent(ctx, q, outbox.UserVerified, user.ID, &eventsv1.UserVerified{UserId: user.ID.String()})
+		if err != nil {
+			return database.User{}, err
+		}
+	default:
+		return database.User{}, err
+	}
+
+	_, err = q.CreateUserIdentity(ctx, database.CreateUserIdentityParams{
+		Provider: provider,
+		Subject:  identity.Subject,
+		UserID:   user.ID,
+		Email:    identity.Email,
+	})
+	if err != nil {
+		return database.User{}, err
+	}
+
+	return user, nil
+}
+
+// externalUsername derives a username from the local part of an email with a random suffix
+func externalUsername(email string) (string, error) {
+	local, _, _ := strings.Cut(strings.ToLower(email), "@")
+	local = strings.Trim(usernameUnsafeChars.ReplaceAllString(local, ""), ".")
+	if len(local) > 20 {
+		local = local[:20]
+	}
+	if local == "" {
+		local = "user"
+	}
+
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+
+	return local + "_" + hex.EncodeToString(suffix), nil
+}
@@ -0,0 +1,272 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/database/mocks"
+	"github.com/imhasandl/auth-service/internal/oauth"
+	"github.com/imhasandl/auth-service/internal/oauth/oauthtest"
+	"github.com/imhasandl/auth-service/internal/outbox"
+	pb "github.com/imhasandl/auth-service/protos"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newExternalProvider configures a provider with the given name against the fake OIDC provider
+func newExternalProvider(t *testing.T, fake *oauthtest.Provider, name string) oauth.Provider {
+	provider, err := oauth.New(context.Background(), oauth.Config{
+		Name:         name,
+		IssuerURL:    fake.URL,
+		ClientID:     oauthtest.ClientID,
+		ClientSecret: oauthtest.ClientSecret,
+		RedirectURL:  "https://app.example.com/callback",
+	})
+	require.NoError(t, err)
+	return provider
+}
+
+func TestExternalLogin(t *testing.T) {
+	fake := oauthtest.NewProvider(t)
+	corp := newExternalProvider(t, fake, "corp")
+	other := newExternalProvider(t, fake, "other")
+
+	userID := uuid.New()
+	providerUser := oauthtest.User{
+		Subject:       "subject-1",
+		Email:         "external@example.com",
+		EmailVerified: true,
+		Name:          "External User",
+	}
+
+	expectSession := func(mockDB *mocks.MockQueries, userID uuid.UUID) {
+		mockDB.On("GetUserAuthorization", mock.Anything, userID).Return(database.GetUserAuthorizationRow{}, nil)
+		mockDB.On("RefreshToken", mock.Anything, mock.MatchedBy(func(arg database.RefreshTokenParams) bool {
+			return arg.UserID == userID
+		})).Return(database.RefreshToken{}, nil)
+	}
+	expectLink := func(mockDB *mocks.MockQueries, userID uuid.UUID) {
+		mockDB.On("CreateUserIdentity", mock.Anything, mock.MatchedBy(func(arg database.CreateUserIdentityParams) bool {
+			return arg.Provider == "corp" &&
+				arg.Subject == "subject-1" &&
+				arg.Email == "external@example.com" &&
+				(userID == uuid.Nil || arg.UserID == userID)
+		})).Return(database.UserIdentity{}, nil)
+	}
+
+	testCases := []struct {
+		name             string
+		providerUser     oauthtest.User
+		completeProvider string
+		mockSetup        func(*mocks.MockQueries)
+		expectedError    bool
+		errorCode        codes.Code
+		errorReason      autherr.Reason
+	}{
+		{
+			name:         "returning user",
+			providerUser: providerUser,
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserIdentity", mock.Anything, database.GetUserIdentityParams{
+					Provider: "corp",
+					Subject:  "subject-1",
+				}).Return(database.UserIdentity{UserID: userID}, nil)
+				mockDB.On("GetUserByID", mock.Anything, userID).Return(database.User{
+					ID:         userID,
+					Email:      "external@example.com",
+					IsVerified: true,
+				}, nil)
+				expectSession(mockDB, userID)
+			},
+		},
+		{
+			name:         "new user signs up",
+			providerUser: providerUser,
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserIdentity", mock.Anything, mock.Anything).Return(database.UserIdentity{}, sql.ErrNoRows)
+				mockDB.On("GetUserByIdentifier", mock.Anything, database.GetUserByIdentifierParams{
+					EmailCanonical: "external@example.com",
+				}).Return(database.User{}, sql.ErrNoRows)
+				mockDB.On("CreateUser", mock.Anything, mock.MatchedBy(func(arg database.CreateUserParams) bool {
+					return arg.Email == "external@example.com" &&
+						arg.Password == "" &&
+						arg.IsVerified &&
+						len(arg.Username) >= 5
+				})).Return(database.User{
+					ID:         userID,
+					Email:      "external@example.com",
+					Username:   "external_abcdef",
+					IsVerified: true,
+				}, nil)
+				mockDB.On("CreateOutboxEvent", mock.Anything, mock.MatchedBy(func(arg database.CreateOutboxEventParams) bool {
+					return arg.EventType == outbox.UserRegistered && arg.UserID == userID
+				})).Return(nil).Once()
+				mockDB.On("CreateWebhookDeliveries", mock.Anything, mock.Anything).Return(nil)
+				mockDB.On("CreateOutboxEvent", mock.Anything, mock.MatchedBy(func(arg database.CreateOutboxEventParams) bool {
+					return arg.EventType == outbox.UserVerified && arg.UserID == userID
+				})).Return(nil).Once()
+				expectLink(mockDB, userID)
+				expectSession(mockDB, userID)
+			},
+		},
+		{
+			name:         "linked to the account with the same verified email",
+			providerUser: providerUser,
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserIdentity", mock.Anything, mock.Anything).Return(database.UserIdentity{}, sql.ErrNoRows)
+				mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(database.User{
+					ID:         userID,
+					Email:      "external@example.com",
+					IsVerified: true,
+				}, nil)
+				expectLink(mockDB, userID)
+				expectSession(mockDB, userID)
+			},
+		},
+		{
+			name:         "not linked to an unverified account",
+			providerUser: providerUser,
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserIdentity", mock.Anything, mock.Anything).Return(database.UserIdentity{}, sql.ErrNoRows)
+				mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(database.User{
+					ID:         userID,
+					Email:      "external@example.com",
+					IsVerified: false,
+				}, nil)
+			},
+			expectedError: true,
+			errorCode:     codes.FailedPrecondition,
+			errorReason:   autherr.ReasonExternalAccountConflict,
+		},
+		{
+			name: "provider email not verified",
+			providerUser: oauthtest.User{
+				Subject:       "subject-1",
+				Email:         "external@example.com",
+				EmailVerified: false,
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserIdentity", mock.Anything, mock.Anything).Return(database.UserIdentity{}, sql.ErrNoRows)
+			},
+			expectedError: true,
+			errorCode:     codes.FailedPrecondition,
+			errorReason:   autherr.ReasonExternalEmailUnverified,
+		},
+		{
+			name:             "state used with another provider",
+			providerUser:     providerUser,
+			completeProvider: "other",
+			mockSetup:        func(mockDB *mocks.MockQueries) {},
+			expectedError:    true,
+			errorCode:        codes.Unauthenticated,
+			errorReason:      autherr.ReasonExternalLoginStateInvalid,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB := new(mocks.MockQueries)
+			server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret",
+				WithExternalProviders(corp, other),
+			)
+			ctx := context.Background()
+
+			tc.mockSetup(mockDB)
+
+			started, err := server.StartExternalLogin(ctx, &pb.StartExternalLoginRequest{Provider: "corp"})
+			require.NoError(t, err)
+
+			authURL, err := url.Parse(started.AuthorizationUrl)
+			require.NoError(t, err)
+			assert.Equal(t, started.State, authURL.Query().Get("state"))
+
+			code, err := fake.Authorize(started.AuthorizationUrl, tc.providerUser)
+			require.NoError(t, err)
+
+			completeProvider := tc.completeProvider
+			if completeProvider == "" {
+				completeProvider = "corp"
+			}
+
+			response, err := server.CompleteExternalLogin(ctx, &pb.CompleteExternalLoginRequest{
+				Provider: completeProvider,
+				State:    started.State,
+				Code:     code,
+			})
+
+			if tc.expectedError {
+				assert.Error(t, err)
+				assert.Equal(t, tc.errorCode, status.Code(err))
+				assert.Equal(t, tc.errorReason, autherr.ReasonOf(err))
+				assert.Nil(t, response)
+			} else {
+				require.NoError(t, err)
+				assert.NotEmpty(t, response.Token)
+				assert.NotEmpty(t, response.RefreshToken)
+				assert.Equal(t, userID.String(), response.User.Id)
+				assert.Equal(t, "external@example.com", response.User.Email)
+			}
+
+			// A state can't be used twice
+			_, err = server.CompleteExternalLogin(ctx, &pb.CompleteExternalLoginRequest{
+				Provider: "corp",
+				State:    started.State,
+				Code:     code,
+			})
+			assert.Equal(t, autherr.ReasonExternalLoginStateInvalid, autherr.ReasonOf(err))
+
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCompleteExternalLoginErrors(t *testing.T) {
+	fake := oauthtest.NewProvider(t)
+	server := NewServer(new(mocks.MockQueries), "test-secret", "test@example.com", "email-secret",
+		WithExternalProviders(newExternalProvider(t, fake, "corp")),
+	)
+	ctx := context.Background()
+
+	_, err := server.StartExternalLogin(ctx, &pb.StartExternalLoginRequest{Provider: "unknown"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = server.CompleteExternalLogin(ctx, &pb.CompleteExternalLoginRequest{Provider: "corp"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = server.CompleteExternalLogin(ctx, &pb.CompleteExternalLoginRequest{
+		Provider: "corp",
+		State:    "never-started",
+		Code:     "code",
+	})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.Equal(t, autherr.ReasonExternalLoginStateInvalid, autherr.ReasonOf(err))
+
+	// The provider rejects a code that it didn't issue
+	started, err := server.StartExternalLogin(ctx, &pb.StartExternalLoginRequest{Provider: "corp"})
+	require.NoError(t, err)
+	_, err = server.CompleteExternalLogin(ctx, &pb.CompleteExternalLoginRequest{
+		Provider: "corp",
+		State:    started.State,
+		Code:     "forged-code",
+	})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.Equal(t, autherr.ReasonExternalLoginFailed, autherr.ReasonOf(err))
+}
+
+func TestExternalUsername(t *testing.T) {
+	username, err := externalUsername("John.Doe+tag@Example.com")
+	require.NoError(t, err)
+	assert.Regexp(t, `^john\.doetag_[0-9a-f]{6}$`, username)
+
+	username, err = externalUsername("+++@example.com")
+	require.NoError(t, err)
+	assert.Regexp(t, `^user_[0-9a-f]{6}$`, username)
+}
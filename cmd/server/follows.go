@@ -0,0 +1,382 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/helper"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	pb "github.com/imhasandl/auth-service/protos"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	defaultFollowsPageSize = 50
+	maxFollowsPageSize     = 200
+)
+
+// Follow follows a user. Following a private account sends a follow request, which only counts once the account
+// accepts it. Following a user again changes nothing.
+func (s *Server) Follow(ctx context.Context, req *pb.FollowRequest) (*pb.FollowResponse, error) {
+	userID, err := s.authenticatedUser(ctx)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	followeeID, err := followTarget(userID, req.GetUserId())
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	follow, err := s.db.CreateFollow(ctx, database.CreateFollowParams{
+		FollowerID: userID,
+		FolloweeID: followeeID,
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, helper.RespondWithError(ctx, autherr.UserNotFound().WithCause(err))
+		}
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	counts, err := s.db.CountFollows(ctx, followeeID)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	return &pb.FollowResponse{
+		Status:        follow.Status,
+		FollowerCount: counts.Followers,
+	}, nil
+}
+
+// Unfollow stops following a user, or withdraws a pending follow request.
+func (s *Server) Unfollow(ctx context.Context, req *pb.UnfollowRequest) (*pb.UnfollowResponse, error) {
+	userID, err := s.authenticatedUser(ctx)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	followeeID, err := followTarget(userID, req.GetUserId())
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	deleted, err := s.db.DeleteFollow(ctx, database.DeleteFollowParams{
+		FollowerID: userID,
+		FolloweeID: followeeID,
+	})
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	if deleted == 0 {
+		return nil, helper.RespondWithError(ctx, autherr.FollowNotFound())
+	}
+
+	return &pb.UnfollowResponse{
+		Success: true,
+		Message: "Unfollowed",
+	}, nil
+}
+
+// ListFollowers returns the users following a user, newest first, along with the user's follower and following
+// counts. The followers of a private account are only listed to the account and its followers.
+func (s *Server) ListFollowers(ctx context.Context, req *pb.ListFollowersRequest) (*pb.ListFollowersResponse, error) {
+	userID, err := s.authenticatedUser(ctx)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	page, err := parseFollowsPage(req.GetPageSize(), req.GetPageToken())
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	ownerID, err := s.followsOwner(ctx, userID, req.GetUserId())
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	rows, err := s.db.ListFollowers(ctx, database.ListFollowersParams{
+		UserID:          ownerID,
+		Status:          database.FollowAccepted,
+		CursorCreatedAt: page.cursorCreatedAt,
+		CursorID:        page.cursorID,
+		PageSize:        page.size + 1,
+	})
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	counts, err := s.db.CountFollows(ctx, ownerID)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	response := &pb.ListFollowersResponse{
+		FollowerCount:  counts.Followers,
+		FollowingCount: counts.Following,
+	}
+	if len(rows) > int(page.size) {
+		rows = rows[:page.size]
+		last := rows[len(rows)-1]
+		response.NextPageToken = encodeUsersPageToken(last.FollowedAt, last.ID)
+	}
+	for _, row := range rows {
+		response.Users = append(response.Users, followedUser(row.ID, row.Username, row.FollowedAt))
+	}
+	return response, nil
+}
+
+// ListFollowing returns the users a user follows, newest first, along with the user's follower and following
+// counts. Who a private account follows is only listed to the account and its followers.
+func (s *Server) ListFollowing(ctx context.Context, req *pb.ListFollowingRequest) (*pb.ListFollowingResponse, error) {
+	userID, err := s.authenticatedUser(ctx)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	page, err := parseFollowsPage(req.GetPageSize(), req.GetPageToken())
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	ownerID, err := s.followsOwner(ctx, userID, req.GetUserId())
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	rows, err := s.db.ListFollowing(ctx, database.ListFollowingParams{
+		UserID:          ownerID,
+		Status:          database.FollowAccepted,
+		CursorCreatedAt: page.cursorCreatedAt,
+		CursorID:        page.cursorID,
+		PageSize:        page.size + 1,
+	})
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	counts, err := s.db.CountFollows(ctx, ownerID)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	response := &pb.ListFollowingResponse{
+		FollowerCount:  counts.Followers,
+		FollowingCount: counts.Following,
+	}
+	if len(rows) > int(page.size) {
+		rows = rows[:page.size]
+		last := rows[len(rows)-1]
+		response.NextPageToken = encodeUsersPageToken(last.FollowedAt, last.ID)
+	}
+	for _, row := range rows {
+		response.Users = append(response.Users, followedUser(row.ID, row.Username, row.FollowedAt))
+	}
+	return response, nil
+}
+
+// ListFollowRequests returns the pending follow requests to the logged in user, newest first.
+func (s *Server) ListFollowRequests(ctx context.Context, req *pb.ListFollowRequestsRequest) (*pb.ListFollowRequestsResponse, error) {
+	userID, err := s.authenticatedUser(ctx)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	page, err := parseFollowsPage(req.GetPageSize(), req.GetPageToken())
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	rows, err := s.db.ListFollowers(ctx, database.ListFollowersParams{
+		UserID:          userID,
+		Status:          database.FollowPending,
+		CursorCreatedAt: page.cursorCreatedAt,
+		CursorID:        page.cursorID,
+		PageSize:        page.size + 1,
+	})
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	response := &pb.ListFollowRequestsResponse{}
+	if len(rows) > int(page.size) {
+		rows = rows[:page.size]
+		last := rows[len(rows)-1]
+		response.NextPageToken = encodeUsersPageToken(last.FollowedAt, last.ID)
+	}
+	for _, row := range rows {
+		response.Users = append(response.Users, followedUser(row.ID, row.Username, row.FollowedAt))
+	}
+	return response, nil
+}
+
+// AcceptFollowRequest accepts a pending follow request to the logged in user.
+func (s *Server) AcceptFollowRequest(ctx context.Context, req *pb.AcceptFollowRequestRequest) (*pb.AcceptFollowRequestResponse, error) {
+	userID, err := s.authenticatedUser(ctx)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	followerID, err := followTarget(userID, req.GetUserId())
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	accepted, err := s.db.AcceptFollowRequest(ctx, database.AcceptFollowRequestParams{
+		FollowerID: followerID,
+		FolloweeID: userID,
+	})
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	if accepted == 0 {
+		return nil, helper.RespondWithError(ctx, autherr.FollowRequestNotFound())
+	}
+
+	return &pb.AcceptFollowRequestResponse{
+		Success: true,
+		Message: "Follow request accepted",
+	}, nil
+}
+
+// DeclineFollowRequest declines a pending follow request to the logged in user. The requester can ask again.
+func (s *Server) DeclineFollowRequest(ctx context.Context, req *pb.DeclineFollowRequestRequest) (*pb.DeclineFollowRequestResponse, error) {
+	userID, err := s.authenticatedUser(ctx)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	followerID, err := followTarget(userID, req.GetUserId())
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	declined, err := s.db.DeleteFollowRequest(ctx, database.DeleteFollowRequestParams{
+		FollowerID: followerID,
+		FolloweeID: userID,
+	})
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	if declined == 0 {
+		return nil, helper.RespondWithError(ctx, autherr.FollowRequestNotFound())
+	}
+
+	return &pb.DeclineFollowRequestResponse{
+		Success: true,
+		Message: "Follow request declined",
+	}, nil
+}
+
+// SetAccountPrivacy makes the account of the logged in user private or public. New follows of a private account
+// need to be accepted. Making the account public accepts all pending requests.
+func (s *Server) SetAccountPrivacy(ctx context.Context, req *pb.SetAccountPrivacyRequest) (*pb.SetAccountPrivacyResponse, error) {
+	userID, err := s.authenticatedUser(ctx)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	var accepted int64
+	err = s.db.WithTx(ctx, func(q DBQuerier) error {
+		if _, err := q.SetUserPrivacy(ctx, database.SetUserPrivacyParams{
+			ID:        userID,
+			IsPrivate: req.GetIsPrivate(),
+		}); err != nil {
+			return err
+		}
+		if req.GetIsPrivate() {
+			return nil
+		}
+		accepted, err = q.AcceptAllFollowRequests(ctx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	return &pb.SetAccountPrivacyResponse{
+		IsPrivate:        req.GetIsPrivate(),
+		AcceptedRequests: accepted,
+	}, nil
+}
+
+// followTarget parses the ID of the other user of a follow, who can't be the caller
+func followTarget(callerID uuid.UUID, rawUserID string) (uuid.UUID, error) {
+	targetID, err := uuid.Parse(rawUserID)
+	if err != nil {
+		return uuid.Nil, autherr.InvalidArgument("user_id", "user_id should be a UUID")
+	}
+	if targetID == callerID {
+		return uuid.Nil, autherr.InvalidArgument("user_id", "user_id should be another user")
+	}
+	return targetID, nil
+}
+
+// followsOwner resolves whose follows the caller lists, the caller's own when rawUserID is empty. The follows
+// of a private account are only visible to the account and its accepted followers
+func (s *Server) followsOwner(ctx context.Context, callerID uuid.UUID, rawUserID string) (uuid.UUID, error) {
+	if rawUserID == "" || rawUserID == callerID.String() {
+		return callerID, nil
+	}
+	ownerID, err := uuid.Parse(rawUserID)
+	if err != nil {
+		return uuid.Nil, autherr.InvalidArgument("user_id", "user_id should be a UUID")
+	}
+
+	owner, err := s.db.GetUserByID(ctx, ownerID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return uuid.Nil, autherr.UserNotFound().WithCause(err)
+		}
+		return uuid.Nil, err
+	}
+	if owner.Status == database.StatusDeleted {
+		return uuid.Nil, autherr.UserNotFound()
+	}
+	if !owner.IsPrivate {
+		return ownerID, nil
+	}
+
+	follow, err := s.db.GetFollow(ctx, database.GetFollowParams{
+		FollowerID: callerID,
+		FolloweeID: ownerID,
+	})
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, err
+	}
+	if err != nil || follow.Status != database.FollowAccepted {
+		return uuid.Nil, autherr.AccountPrivate()
+	}
+	return ownerID, nil
+}
+
+// followsPage is a page of ListFollowers, ListFollowing or ListFollowRequests
+type followsPage struct {
+	size            int32
+	cursorCreatedAt sql.NullTime
+	cursorID        uuid.NullUUID
+}
+
+func parseFollowsPage(pageSize int32, pageToken string) (followsPage, error) {
+	var violations autherr.Violations
+	if pageSize < 0 || pageSize > maxFollowsPageSize {
+		violations.Add("page_size", "page_size should be between 0 and "+strconv.Itoa(maxFollowsPageSize))
+	}
+	if pageSize == 0 {
+		pageSize = defaultFollowsPageSize
+	}
+	page := followsPage{size: pageSize}
+	if pageToken != "" {
+		createdAt, id, err := decodeUsersPageToken(pageToken)
+		if err != nil {
+			violations.Add("page_token", "page_token is invalid")
+		}
+		page.cursorCreatedAt = sql.NullTime{Time: createdAt, Valid: err == nil}
+		page.cursorID = uuid.NullUUID{UUID: id, Valid: err == nil}
+	}
+	return page, violations.Err()
+}
+
+func followedUser(id uuid.UUID, username string, followedAt time.Time) *pb.FollowedUser {
+	return &pb.FollowedUser{
+		UserId:     id.String(),
+		Username:   username,
+		FollowedAt: timestamppb.New(followedAt),
+	}
+}
@@ -0,0 +1,342 @@
+package server
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/database/mocks"
+	pb "github.com/imhasandl/auth-service/protos"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func TestFollow(t *testing.T) {
+	userID, followeeID := uuid.New(), uuid.New()
+	params := database.CreateFollowParams{FollowerID: userID, FolloweeID: followeeID}
+
+	testCases := []struct {
+		name          string
+		userID        string
+		mockSetup     func(*mocks.MockQueries)
+		expectedState string
+		errorCode     codes.Code
+		errorReason   autherr.Reason
+	}{
+		{
+			name:   "public account",
+			userID: followeeID.String(),
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("CreateFollow", mock.Anything, params).
+					Return(database.Follow{FollowerID: userID, FolloweeID: followeeID, Status: database.FollowAccepted}, nil)
+				mockDB.On("CountFollows", mock.Anything, followeeID).
+					Return(database.CountFollowsRow{Followers: 3, Following: 1}, nil)
+			},
+			expectedState: database.FollowAccepted,
+		},
+		{
+			name:   "private account",
+			userID: followeeID.String(),
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("CreateFollow", mock.Anything, params).
+					Return(database.Follow{FollowerID: userID, FolloweeID: followeeID, Status: database.FollowPending}, nil)
+				mockDB.On("CountFollows", mock.Anything, followeeID).
+					Return(database.CountFollowsRow{Followers: 3, Following: 1}, nil)
+			},
+			expectedState: database.FollowPending,
+		},
+		{
+			name:   "unknown user",
+			userID: followeeID.String(),
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("CreateFollow", mock.Anything, params).Return(database.Follow{}, sql.ErrNoRows)
+			},
+			errorCode:   codes.NotFound,
+			errorReason: autherr.ReasonUserNotFound,
+		},
+		{
+			name:        "self",
+			userID:      userID.String(),
+			mockSetup:   func(mockDB *mocks.MockQueries) {},
+			errorCode:   codes.InvalidArgument,
+			errorReason: autherr.ReasonInvalidArgument,
+		},
+		{
+			name:        "invalid user ID",
+			userID:      "someone",
+			mockSetup:   func(mockDB *mocks.MockQueries) {},
+			errorCode:   codes.InvalidArgument,
+			errorReason: autherr.ReasonInvalidArgument,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB := activeUsers(new(mocks.MockQueries))
+			server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+
+			tc.mockSetup(mockDB)
+
+			response, err := server.Follow(withAccessToken(t, userID), &pb.FollowRequest{UserId: tc.userID})
+
+			if tc.errorReason != "" {
+				assertReason(t, err, tc.errorCode, tc.errorReason)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tc.expectedState, response.Status)
+				assert.Equal(t, int64(3), response.FollowerCount)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestUnfollow(t *testing.T) {
+	userID, followeeID := uuid.New(), uuid.New()
+	mockDB := activeUsers(new(mocks.MockQueries))
+	server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+	mockDB.On("DeleteFollow", mock.Anything, database.DeleteFollowParams{FollowerID: userID, FolloweeID: followeeID}).
+		Return(int64(1), nil).Once()
+	mockDB.On("DeleteFollow", mock.Anything, mock.Anything).Return(int64(0), nil).Once()
+
+	response, err := server.Unfollow(withAccessToken(t, userID), &pb.UnfollowRequest{UserId: followeeID.String()})
+	require.NoError(t, err)
+	assert.True(t, response.Success)
+
+	_, err = server.Unfollow(withAccessToken(t, userID), &pb.UnfollowRequest{UserId: followeeID.String()})
+	assertReason(t, err, codes.NotFound, autherr.ReasonFollowNotFound)
+	mockDB.AssertExpectations(t)
+}
+
+func TestListFollowers(t *testing.T) {
+	userID, ownerID := uuid.New(), uuid.New()
+	followedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	rows := []database.ListFollowersRow{
+		{ID: uuid.New(), Username: "first", FollowedAt: followedAt},
+		{ID: uuid.New(), Username: "second", FollowedAt: followedAt.Add(-time.Minute)},
+		{ID: uuid.New(), Username: "third", FollowedAt: followedAt.Add(-2 * time.Minute)},
+	}
+	counts := database.CountFollowsRow{Followers: 10, Following: 4}
+
+	testCases := []struct {
+		name        string
+		request     *pb.ListFollowersRequest
+		mockSetup   func(*mocks.MockQueries)
+		errorCode   codes.Code
+		errorReason autherr.Reason
+	}{
+		{
+			name:    "own followers",
+			request: &pb.ListFollowersRequest{PageSize: 2},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("ListFollowers", mock.Anything, database.ListFollowersParams{
+					UserID:   userID,
+					Status:   database.FollowAccepted,
+					PageSize: 3,
+				}).Return(rows, nil)
+				mockDB.On("CountFollows", mock.Anything, userID).Return(counts, nil)
+			},
+		},
+		{
+			name:    "public account",
+			request: &pb.ListFollowersRequest{UserId: ownerID.String(), PageSize: 2},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByID", mock.Anything, ownerID).
+					Return(database.User{ID: ownerID, Status: database.StatusActive}, nil)
+				mockDB.On("ListFollowers", mock.Anything, mock.MatchedBy(func(arg database.ListFollowersParams) bool {
+					return arg.UserID == ownerID
+				})).Return(rows, nil)
+				mockDB.On("CountFollows", mock.Anything, ownerID).Return(counts, nil)
+			},
+		},
+		{
+			name:    "private account followed by the caller",
+			request: &pb.ListFollowersRequest{UserId: ownerID.String(), PageSize: 2},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByID", mock.Anything, ownerID).
+					Return(database.User{ID: ownerID, Status: database.StatusActive, IsPrivate: true}, nil)
+				mockDB.On("GetFollow", mock.Anything, database.GetFollowParams{FollowerID: userID, FolloweeID: ownerID}).
+					Return(database.Follow{Status: database.FollowAccepted}, nil)
+				mockDB.On("ListFollowers", mock.Anything, mock.Anything).Return(rows, nil)
+				mockDB.On("CountFollows", mock.Anything, ownerID).Return(counts, nil)
+			},
+		},
+		{
+			name:    "private account with a pending request",
+			request: &pb.ListFollowersRequest{UserId: ownerID.String()},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByID", mock.Anything, ownerID).
+					Return(database.User{ID: ownerID, Status: database.StatusActive, IsPrivate: true}, nil)
+				mockDB.On("GetFollow", mock.Anything, mock.Anything).
+					Return(database.Follow{Status: database.FollowPending}, nil)
+			},
+			errorCode:   codes.PermissionDenied,
+			errorReason: autherr.ReasonAccountPrivate,
+		},
+		{
+			name:    "private account not followed",
+			request: &pb.ListFollowersRequest{UserId: ownerID.String()},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByID", mock.Anything, ownerID).
+					Return(database.User{ID: ownerID, Status: database.StatusActive, IsPrivate: true}, nil)
+				mockDB.On("GetFollow", mock.Anything, mock.Anything).Return(database.Follow{}, sql.ErrNoRows)
+			},
+			errorCode:   codes.PermissionDenied,
+			errorReason: autherr.ReasonAccountPrivate,
+		},
+		{
+			name:    "deleted account",
+			request: &pb.ListFollowersRequest{UserId: ownerID.String()},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByID", mock.Anything, ownerID).
+					Return(database.User{ID: ownerID, Status: database.StatusDeleted}, nil)
+			},
+			errorCode:   codes.NotFound,
+			errorReason: autherr.ReasonUserNotFound,
+		},
+		{
+			name:        "invalid page",
+			request:     &pb.ListFollowersRequest{PageSize: maxFollowsPageSize + 1, PageToken: "not a token"},
+			mockSetup:   func(mockDB *mocks.MockQueries) {},
+			errorCode:   codes.InvalidArgument,
+			errorReason: autherr.ReasonInvalidArgument,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB := activeUsers(new(mocks.MockQueries))
+			server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+
+			tc.mockSetup(mockDB)
+
+			response, err := server.ListFollowers(withAccessToken(t, userID), tc.request)
+
+			if tc.errorReason != "" {
+				assertReason(t, err, tc.errorCode, tc.errorReason)
+			} else {
+				require.NoError(t, err)
+				require.Len(t, response.Users, 2)
+				assert.Equal(t, "first", response.Users[0].Username)
+				assert.Equal(t, encodeUsersPageToken(rows[1].FollowedAt, rows[1].ID), response.NextPageToken)
+				assert.Equal(t, int64(10), response.FollowerCount)
+				assert.Equal(t, int64(4), response.FollowingCount)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestListFollowingNextPage(t *testing.T) {
+	userID := uuid.New()
+	cursorAt, cursorID := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), uuid.New()
+	mockDB := activeUsers(new(mocks.MockQueries))
+	server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+	mockDB.On("ListFollowing", mock.Anything, database.ListFollowingParams{
+		UserID:          userID,
+		Status:          database.FollowAccepted,
+		CursorCreatedAt: sql.NullTime{Time: cursorAt, Valid: true},
+		CursorID:        uuid.NullUUID{UUID: cursorID, Valid: true},
+		PageSize:        defaultFollowsPageSize + 1,
+	}).Return([]database.ListFollowingRow{{ID: uuid.New(), Username: "last", FollowedAt: cursorAt.Add(-time.Hour)}}, nil)
+	mockDB.On("CountFollows", mock.Anything, userID).Return(database.CountFollowsRow{Followers: 2, Following: 51}, nil)
+
+	response, err := server.ListFollowing(withAccessToken(t, userID), &pb.ListFollowingRequest{
+		PageToken: encodeUsersPageToken(cursorAt, cursorID),
+	})
+	require.NoError(t, err)
+	require.Len(t, response.Users, 1)
+	assert.Empty(t, response.NextPageToken)
+	assert.Equal(t, int64(51), response.FollowingCount)
+	mockDB.AssertExpectations(t)
+}
+
+func TestFollowRequests(t *testing.T) {
+	userID, requesterID := uuid.New(), uuid.New()
+	params := database.AcceptFollowRequestParams{FollowerID: requesterID, FolloweeID: userID}
+
+	t.Run("listed", func(t *testing.T) {
+		mockDB := activeUsers(new(mocks.MockQueries))
+		server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+		mockDB.On("ListFollowers", mock.Anything, database.ListFollowersParams{
+			UserID:   userID,
+			Status:   database.FollowPending,
+			PageSize: defaultFollowsPageSize + 1,
+		}).Return([]database.ListFollowersRow{{ID: requesterID, Username: "requester", FollowedAt: time.Now()}}, nil)
+
+		response, err := server.ListFollowRequests(withAccessToken(t, userID), &pb.ListFollowRequestsRequest{})
+		require.NoError(t, err)
+		require.Len(t, response.Users, 1)
+		assert.Equal(t, requesterID.String(), response.Users[0].UserId)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("accepted", func(t *testing.T) {
+		mockDB := activeUsers(new(mocks.MockQueries))
+		server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+		mockDB.On("AcceptFollowRequest", mock.Anything, params).Return(int64(1), nil)
+
+		response, err := server.AcceptFollowRequest(withAccessToken(t, userID), &pb.AcceptFollowRequestRequest{UserId: requesterID.String()})
+		require.NoError(t, err)
+		assert.True(t, response.Success)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("accepting a request that isn't pending", func(t *testing.T) {
+		mockDB := activeUsers(new(mocks.MockQueries))
+		server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+		mockDB.On("AcceptFollowRequest", mock.Anything, params).Return(int64(0), nil)
+
+		_, err := server.AcceptFollowRequest(withAccessToken(t, userID), &pb.AcceptFollowRequestRequest{UserId: requesterID.String()})
+		assertReason(t, err, codes.NotFound, autherr.ReasonFollowRequestNotFound)
+	})
+
+	t.Run("declined", func(t *testing.T) {
+		mockDB := activeUsers(new(mocks.MockQueries))
+		server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+		mockDB.On("DeleteFollowRequest", mock.Anything, database.DeleteFollowRequestParams{FollowerID: requesterID, FolloweeID: userID}).
+			Return(int64(1), nil)
+
+		response, err := server.DeclineFollowRequest(withAccessToken(t, userID), &pb.DeclineFollowRequestRequest{UserId: requesterID.String()})
+		require.NoError(t, err)
+		assert.True(t, response.Success)
+		mockDB.AssertExpectations(t)
+	})
+}
+
+func TestSetAccountPrivacy(t *testing.T) {
+	userID := uuid.New()
+
+	t.Run("private", func(t *testing.T) {
+		mockDB := activeUsers(new(mocks.MockQueries))
+		server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+		mockDB.On("SetUserPrivacy", mock.Anything, database.SetUserPrivacyParams{ID: userID, IsPrivate: true}).
+			Return(database.User{ID: userID, IsPrivate: true}, nil)
+
+		response, err := server.SetAccountPrivacy(withAccessToken(t, userID), &pb.SetAccountPrivacyRequest{IsPrivate: true})
+		require.NoError(t, err)
+		assert.True(t, response.IsPrivate)
+		assert.Zero(t, response.AcceptedRequests)
+		mockDB.AssertExpectations(t)
+		mockDB.AssertNotCalled(t, "AcceptAllFollowRequests", mock.Anything, mock.Anything)
+	})
+
+	t.Run("public accepts pending requests", func(t *testing.T) {
+		mockDB := activeUsers(new(mocks.MockQueries))
+		server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+		mockDB.On("SetUserPrivacy", mock.Anything, database.SetUserPrivacyParams{ID: userID}).
+			Return(database.User{ID: userID}, nil)
+		mockDB.On("AcceptAllFollowRequests", mock.Anything, userID).Return(int64(4), nil)
+
+		response, err := server.SetAccountPrivacy(withAccessToken(t, userID), &pb.SetAccountPrivacyRequest{})
+		require.NoError(t, err)
+		assert.False(t, response.IsPrivate)
+		assert.Equal(t, int64(4), response.AcceptedRequests)
+		mockDB.AssertExpectations(t)
+	})
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+)
+
+// CheckPasswordLogin counts a password login for the identifier and fails once the login rate limit is reached.
+// The Login RPC and the login form of the OpenID Connect provider share the limit, so neither can be used to get
+// around it
+func (s *Server) CheckPasswordLogin(identifier string) error {
+	return s.checkRateLimit("login_password:" + strings.ToLower(strings.TrimSpace(identifier)))
+}
+
+// RecordFormLogin records a login with the login form of the OpenID Connect provider in the security log, the
+// same way the Login RPC records its logins. user is the zero User when the identifier matched no account
+func (s *Server) RecordFormLogin(r *http.Request, user database.User, identifier string, passwordErr error) {
+	event := AuthEvent{
+		Type:    EventLoginSucceeded,
+		UserID:  user.ID,
+		Details: map[string]string{"method": loginMethodOIDC},
+	}
+	var err error
+	if user.ID == uuid.Nil || passwordErr != nil {
+		err = autherr.InvalidCredentials().WithCause(passwordErr)
+	} else {
+		err = checkAccountStatus(user.Status, user.StatusUntil)
+	}
+	if err != nil {
+		var ok bool
+		if event, ok = loginFailureEvent(user.ID, identifier, loginMethodOIDC, err); !ok {
+			return
+		}
+	}
+
+	event.IPAddress, event.UserAgent = s.httpClientInfo(r)
+	if err := s.auditSink.Record(r.Context(), event); err != nil {
+		log.Printf("Error recording %s event: %v", event.Type, err)
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/database/mocks"
+	pb "github.com/imhasandl/auth-service/protos"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func TestRecordFormLogin(t *testing.T) {
+	userID := uuid.New()
+
+	testCases := []struct {
+		name          string
+		user          database.User
+		identifier    string
+		passwordErr   error
+		expectedEvent AuthEvent
+	}{
+		{
+			name:       "success",
+			user:       database.User{ID: userID},
+			identifier: "testuser",
+			expectedEvent: AuthEvent{
+				Type:    EventLoginSucceeded,
+				UserID:  userID,
+				Details: map[string]string{"method": loginMethodOIDC},
+			},
+		},
+		{
+			name:        "wrong password",
+			user:        database.User{ID: userID},
+			identifier:  "testuser",
+			passwordErr: errors.New("wrong password"),
+			expectedEvent: AuthEvent{
+				Type:    EventLoginFailed,
+				UserID:  userID,
+				Details: map[string]string{"method": loginMethodOIDC, "reason": string(autherr.ReasonInvalidCredentials)},
+			},
+		},
+		{
+			name:        "unknown identifier",
+			identifier:  "nobody",
+			passwordErr: sql.ErrNoRows,
+			expectedEvent: AuthEvent{
+				Type: EventLoginFailed,
+				Details: map[string]string{
+					"method":     loginMethodOIDC,
+					"reason":     string(autherr.ReasonInvalidCredentials),
+					"identifier": "nobody",
+				},
+			},
+		},
+		{
+			name:       "banned user",
+			user:       database.User{ID: userID, Status: database.StatusBanned},
+			identifier: "testuser",
+			expectedEvent: AuthEvent{
+				Type:    EventLoginFailed,
+				UserID:  userID,
+				Details: map[string]string{"method": loginMethodOIDC, "reason": string(autherr.ReasonAccountBanned)},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sink := &recordingSink{}
+			server := NewServer(new(mocks.MockQueries), "test-secret", "test@example.com", "email-secret", WithAuditSink(sink, false))
+			request := httptest.NewRequest(http.MethodPost, "/oidc/login", nil)
+			request.RemoteAddr = "203.0.113.7:51234"
+			request.Header.Set("User-Agent", "test-agent/1.0")
+
+			server.RecordFormLogin(request, tc.user, tc.identifier, tc.passwordErr)
+
+			require.Len(t, sink.events, 1)
+			event := sink.events[0]
+			assert.Equal(t, tc.expectedEvent.Type, event.Type)
+			assert.Equal(t, tc.expectedEvent.UserID, event.UserID)
+			assert.Equal(t, tc.expectedEvent.Details, event.Details)
+			assert.Equal(t, "203.0.113.7", event.IPAddress)
+			assert.Equal(t, "test-agent/1.0", event.UserAgent)
+		})
+	}
+}
+
+func TestPasswordLoginRateLimit(t *testing.T) {
+	testRedis.FlushAll()
+	mockDB := new(mocks.MockQueries)
+	mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(database.User{}, sql.ErrNoRows)
+	sink := &recordingSink{}
+	server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret",
+		WithLoginRateLimit(2, time.Minute), WithAuditSink(sink, false))
+
+	// The login form and the Login RPC count against the same limit
+	require.NoError(t, server.CheckPasswordLogin("Limited@Example.com"))
+	_, err := server.Login(context.Background(), &pb.LoginRequest{Identifier: "limited@example.com", Password: "password123"})
+	assertReason(t, err, codes.Unauthenticated, autherr.ReasonInvalidCredentials)
+
+	_, err = server.Login(context.Background(), &pb.LoginRequest{Identifier: "limited@example.com", Password: "password123"})
+	assertReason(t, err, codes.ResourceExhausted, autherr.ReasonRateLimited)
+	assert.Error(t, server.CheckPasswordLogin(" limited@example.com"))
+
+	mockDB.AssertNumberOfCalls(t, "GetUserByIdentifier", 1)
+	require.Len(t, sink.events, 2)
+	assert.Equal(t, string(autherr.ReasonRateLimited), sink.events[1].Details["reason"])
+}
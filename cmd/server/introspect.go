@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/cmd/helper"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	pb "github.com/imhasandl/auth-service/protos"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Token types reported by IntrospectToken
+const (
+	introspectTypeAccess  = "access"
+	introspectTypeService = "service"
+	introspectTypeAPIKey  = "api_key"
+)
+
+// IntrospectToken tells a service who a credential belongs to and what it may do, in the spirit of RFC 7662.
+// API keys can only be checked here, since they are looked up in the database. Credentials that are unknown,
+// expired or revoked are reported as not active instead of failing.
+func (s *Server) IntrospectToken(ctx context.Context, req *pb.IntrospectTokenRequest) (*pb.IntrospectTokenResponse, error) {
+	token := req.GetToken()
+	if token == "" {
+		return nil, helper.RespondWithError(ctx, autherr.InvalidArgument("token", "token is required"))
+	}
+
+	if auth.IsAPIKey(token) {
+		response, err := s.introspectAPIKey(ctx, token)
+		if err != nil {
+			return nil, helper.RespondWithError(ctx, err)
+		}
+		return response, nil
+	}
+
+	tokenType, err := auth.ParseTokenType(token, s.tokenSecret)
+	if err != nil {
+		return &pb.IntrospectTokenResponse{}, nil
+	}
+
+	switch tokenType {
+	case auth.TokenTypeAccess:
+		claims, err := auth.ValidateAccessClaims(token, s.tokenSecret)
+		if err != nil {
+			return &pb.IntrospectTokenResponse{}, nil
+		}
+		userID, err := uuid.Parse(claims.Subject)
+		if err != nil {
+			return &pb.IntrospectTokenResponse{}, nil
+		}
+		active, err := s.userActive(ctx, userID)
+		if err != nil {
+			return nil, helper.RespondWithError(ctx, err)
+		}
+		if !active {
+			return &pb.IntrospectTokenResponse{}, nil
+		}
+		return &pb.IntrospectTokenResponse{
+			Active:       true,
+			TokenType:    introspectTypeAccess,
+			Subject:      claims.Subject,
+			Scopes:       claims.Permissions(),
+			Roles:        claims.Roles,
+			Premium:      claims.Premium,
+			Entitlements: claims.Entitlements,
+			ExpiresAt:    timestamppb.New(claims.ExpiresAt.Time),
+		}, nil
+	case auth.TokenTypeService:
+		claims, err := auth.ValidateServiceToken(token, s.tokenSecret, req.GetAudience())
+		if err != nil {
+			return &pb.IntrospectTokenResponse{}, nil
+		}
+		return &pb.IntrospectTokenResponse{
+			Active:    true,
+			TokenType: introspectTypeService,
+			Subject:   claims.Subject,
+			Scopes:    claims.Scopes(),
+			Audiences: claims.Audience,
+			ExpiresAt: timestamppb.New(claims.ExpiresAt.Time),
+		}, nil
+	default:
+		return &pb.IntrospectTokenResponse{}, nil
+	}
+}
+
+// introspectAPIKey looks up an API key and records that it was used
+func (s *Server) introspectAPIKey(ctx context.Context, key string) (*pb.IntrospectTokenResponse, error) {
+	apiKey, err := s.db.GetAPIKeyByHash(ctx, auth.HashAPIKey(key))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return &pb.IntrospectTokenResponse{}, nil
+		}
+		return nil, err
+	}
+	if apiKey.ExpiresAt.Valid && !apiKey.ExpiresAt.Time.After(time.Now()) {
+		return &pb.IntrospectTokenResponse{}, nil
+	}
+	active, err := s.userActive(ctx, apiKey.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return &pb.IntrospectTokenResponse{}, nil
+	}
+
+	if err := s.db.TouchAPIKey(ctx, apiKey.ID); err != nil {
+		log.Printf("Failed to record API key use: %v", err)
+	}
+
+	response := &pb.IntrospectTokenResponse{
+		Active:    true,
+		TokenType: introspectTypeAPIKey,
+		Subject:   apiKey.UserID.String(),
+		Scopes:    apiKey.Scopes,
+		ApiKeyId:  apiKey.ID.String(),
+	}
+	if apiKey.ExpiresAt.Valid {
+		response.ExpiresAt = timestamppb.New(apiKey.ExpiresAt.Time)
+	}
+	return response, nil
+}
+
+// userActive reports whether the user a credential belongs to still exists and may use it
+func (s *Server) userActive(ctx context.Context, userID uuid.UUID) (bool, error) {
+	status, err := s.db.GetUserStatus(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	return database.CanLogIn(status.Status, status.StatusUntil, time.Now()), nil
+}
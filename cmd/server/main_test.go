@@ -0,0 +1,30 @@
+package server
+
+import (
+	"os"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/imhasandl/auth-service/internal/redis"
+)
+
+// testRedis is the in-memory Redis server used by the tests. FastForward expires keys without waiting
+var testRedis *miniredis.Miniredis
+
+// TestMain points the global redis client at an in-memory server so handlers can cache values during tests
+func TestMain(m *testing.M) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		panic(err)
+	}
+	testRedis = mr
+
+	redis.InitRedisClient(&redis.Config{
+		Host: mr.Host(),
+		Port: mr.Port(),
+	})
+
+	code := m.Run()
+	mr.Close()
+	os.Exit(code)
+}
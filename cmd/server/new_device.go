@@ -0,0 +1,223 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/cmd/helper"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/redis"
+	pb "github.com/imhasandl/auth-service/protos"
+	"google.golang.org/grpc/metadata"
+)
+
+// signInReportTTL is how long the "this wasn't me" link of a new sign-in email works. It matches the lifetime
+// of the refresh token issued by the login the email is about
+const signInReportTTL = 7 * 24 * time.Hour
+
+// deviceIDMetadata is the metadata key clients send a stable ID of the device they run on in
+const deviceIDMetadata = "x-device-id"
+
+// signInReport is what the provider remembers about a new sign-in email until the link in it is used
+type signInReport struct {
+	UserID    uuid.UUID `json:"user_id"`
+	SessionID string    `json:"session_id"`
+}
+
+// alertNewDevice remembers the device a login came from and emails the user when they haven't logged in from
+// it before. The first device of an account isn't reported. The login already succeeded, so failures are
+// only logged
+func (s *Server) alertNewDevice(ctx context.Context, user database.User, refreshToken string) {
+	if s.signInReportURL == "" {
+		return
+	}
+
+	ip, userAgent := s.clientInfo(ctx)
+	var deviceID string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(deviceIDMetadata); len(values) > 0 {
+			deviceID = values[0]
+		}
+	}
+	fingerprint, network := auth.DeviceFingerprint(userAgent, ip, deviceID)
+
+	device, err := s.db.RememberDevice(ctx, database.RememberDeviceParams{
+		UserID:      user.ID,
+		Fingerprint: fingerprint,
+		UserAgent:   userAgent,
+		IpPrefix:    network,
+	})
+	if err != nil {
+		log.Printf("Error remembering login device: %v", err)
+		return
+	}
+	if !device.IsNew || device.KnownDevices == 0 {
+		return
+	}
+
+	sessionID := auth.SessionID(refreshToken)
+	if err := s.sendNewSignInEmail(user, sessionID, userAgent, network); err != nil {
+		log.Printf("Error sending new sign-in email: %v", err)
+		return
+	}
+
+	s.recordEvent(ctx, AuthEvent{
+		Type:      EventNewDevice,
+		UserID:    user.ID,
+		SessionID: sessionID,
+		Details:   map[string]string{"network": network},
+	})
+}
+
+func (s *Server) sendNewSignInEmail(user database.User, sessionID, userAgent, network string) error {
+	token, err := auth.MakeSignInReportToken()
+	if err != nil {
+		return err
+	}
+	link, err := linkWithToken(s.signInReportURL, token)
+	if err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(signInReport{UserID: user.ID, SessionID: sessionID})
+	if err != nil {
+		return err
+	}
+	if err := redis.SaveSignInReport(auth.HashSingleUseToken(token), string(value), signInReportTTL); err != nil {
+		return err
+	}
+
+	if s.email == "test@example.com" {
+		return nil
+	}
+	if userAgent == "" {
+		userAgent = "unknown"
+	}
+	if network == "" {
+		network = "unknown"
+	}
+	return auth.SendNewSignInEmail(user.Email, s.email, s.emailSecret, userAgent, network, time.Now(), link)
+}
+
+// ReportSignIn handles the "this wasn't me" link of a new sign-in email. Every session of the user is revoked,
+// because refreshing rotates the reported one away, and the password stops working until the user sets a new
+// one with the password reset token in the response. The link works once.
+func (s *Server) ReportSignIn(ctx context.Context, req *pb.ReportSignInRequest) (*pb.ReportSignInResponse, error) {
+	if req.GetToken() == "" {
+		return nil, helper.RespondWithError(ctx, autherr.InvalidArgument("token", "token is required"))
+	}
+
+	rawReport, err := redis.TakeSignInReport(auth.HashSingleUseToken(req.GetToken()))
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, helper.RespondWithError(ctx, autherr.SignInReportInvalid())
+		}
+		return nil, helper.RespondWithError(ctx, autherr.Unavailable(err, 5*time.Second))
+	}
+
+	var report signInReport
+	if err := json.Unmarshal([]byte(rawReport), &report); err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	err = s.db.WithTx(ctx, func(q DBQuerier) error {
+		if err := deleteAllSessions(ctx, q, report.UserID); err != nil {
+			return err
+		}
+		// No bcrypt hash matches an empty string, so logging in with the old password fails from now on
+		return q.SetUserPassword(ctx, database.SetUserPasswordParams{ID: report.UserID})
+	})
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	if err := redis.DeleteAllUserTokens(report.UserID.String()); err != nil {
+		log.Printf("Error deleting cached tokens of reported user: %v", err)
+	}
+
+	s.recordEvent(ctx, AuthEvent{Type: EventSignInReported, UserID: report.UserID, SessionID: report.SessionID})
+
+	resetToken, err := s.issuePasswordReset(report.UserID)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	return &pb.ReportSignInResponse{
+		Success:                true,
+		Message:                "All sessions were signed out. Set a new password to log in again",
+		PasswordResetToken:     resetToken,
+		PasswordResetExpiresIn: int64(s.passwordResetTTL.Seconds()),
+	}, nil
+}
+
+// issuePasswordReset makes a token that ResetPassword accepts once to set a new password of the user
+func (s *Server) issuePasswordReset(userID uuid.UUID) (string, error) {
+	token, err := auth.MakePasswordResetToken()
+	if err != nil {
+		return "", err
+	}
+	if err := redis.SavePasswordReset(auth.HashSingleUseToken(token), userID.String(), s.passwordResetTTL); err != nil {
+		return "", autherr.Unavailable(err, 5*time.Second)
+	}
+	return token, nil
+}
+
+// ResetPassword sets a new password with a password reset token and signs out every session. The token works
+// once.
+func (s *Server) ResetPassword(ctx context.Context, req *pb.ResetPasswordRequest) (*pb.ResetPasswordResponse, error) {
+	var violations autherr.Violations
+	if req.GetToken() == "" {
+		violations.Add("token", "token is required")
+	}
+	if req.GetNewPassword() == "" {
+		violations.Add("new_password", "new_password is required")
+	}
+	if err := violations.Err(); err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	// Hash first, so a password bcrypt refuses doesn't use the token up
+	hashedPassword, err := auth.HashPassword(req.GetNewPassword())
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, autherr.InvalidArgument("new_password", "new_password is too long").WithCause(err))
+	}
+
+	rawUserID, err := redis.TakePasswordReset(auth.HashSingleUseToken(req.GetToken()))
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, helper.RespondWithError(ctx, autherr.PasswordResetInvalid())
+		}
+		return nil, helper.RespondWithError(ctx, autherr.Unavailable(err, 5*time.Second))
+	}
+	userID, err := uuid.Parse(rawUserID)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, autherr.PasswordResetInvalid().WithCause(err))
+	}
+
+	err = s.db.WithTx(ctx, func(q DBQuerier) error {
+		if err := q.SetUserPassword(ctx, database.SetUserPasswordParams{ID: userID, Password: hashedPassword}); err != nil {
+			return err
+		}
+		return deleteAllSessions(ctx, q, userID)
+	})
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	if err := redis.DeleteAllUserTokens(userID.String()); err != nil {
+		log.Printf("Error deleting cached tokens after password reset: %v", err)
+	}
+
+	s.recordEvent(ctx, AuthEvent{Type: EventPasswordReset, UserID: userID})
+
+	return &pb.ResetPasswordResponse{
+		Success: true,
+		Message: "Password changed",
+	}, nil
+}
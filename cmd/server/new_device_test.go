@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/database/mocks"
+	"github.com/imhasandl/auth-service/internal/redis"
+	pb "github.com/imhasandl/auth-service/protos"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func newSignInAlertServer(mockDB *mocks.MockQueries, sink AuditSink) *Server {
+	return NewServer(mockDB, "test-secret", "test@example.com", "email-secret",
+		WithAuditSink(sink, false),
+		WithNewSignInAlerts("https://example.com/not-me", time.Hour),
+	)
+}
+
+func deviceContext() context.Context {
+	return peer.NewContext(
+		metadata.NewIncomingContext(context.Background(), metadata.Pairs("user-agent", "app/1.0", deviceIDMetadata, "device-1")),
+		&peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 51234}},
+	)
+}
+
+func TestAlertNewDevice(t *testing.T) {
+	user := database.User{ID: uuid.New(), Email: "user@example.com"}
+	fingerprint, _ := auth.DeviceFingerprint("app/1.0", "203.0.113.7", "device-1")
+
+	testCases := []struct {
+		name         string
+		device       database.RememberDeviceRow
+		expectAlert  bool
+		alertEnabled bool
+	}{
+		{
+			name:         "new device",
+			device:       database.RememberDeviceRow{IsNew: true, KnownDevices: 2},
+			alertEnabled: true,
+			expectAlert:  true,
+		},
+		{
+			name:         "known device",
+			device:       database.RememberDeviceRow{IsNew: false, KnownDevices: 3},
+			alertEnabled: true,
+		},
+		{
+			name:         "first device of the account",
+			device:       database.RememberDeviceRow{IsNew: true, KnownDevices: 0},
+			alertEnabled: true,
+		},
+		{
+			name: "alerts disabled",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testRedis.FlushAll()
+			mockDB := new(mocks.MockQueries)
+			sink := &recordingSink{}
+			server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret", WithAuditSink(sink, false))
+			if tc.alertEnabled {
+				server = newSignInAlertServer(mockDB, sink)
+				mockDB.On("RememberDevice", mock.Anything, database.RememberDeviceParams{
+					UserID:      user.ID,
+					Fingerprint: fingerprint,
+					UserAgent:   "app/1.0",
+					IpPrefix:    "203.0.113.0/24",
+				}).Return(tc.device, nil)
+			}
+
+			server.alertNewDevice(deviceContext(), user, "refresh-token")
+
+			mockDB.AssertExpectations(t)
+			reports := testRedis.Keys()
+			if !tc.expectAlert {
+				assert.Empty(t, reports)
+				assert.Empty(t, sink.events)
+				return
+			}
+
+			require.Len(t, reports, 1)
+			value, err := testRedis.Get(reports[0])
+			require.NoError(t, err)
+			var report signInReport
+			require.NoError(t, json.Unmarshal([]byte(value), &report))
+			assert.Equal(t, user.ID, report.UserID)
+			assert.Equal(t, auth.SessionID("refresh-token"), report.SessionID)
+
+			require.Len(t, sink.events, 1)
+			assert.Equal(t, EventNewDevice, sink.events[0].Type)
+			assert.Equal(t, "203.0.113.0/24", sink.events[0].Details["network"])
+		})
+	}
+}
+
+func TestReportSignInAndResetPassword(t *testing.T) {
+	testRedis.FlushAll()
+	userID := uuid.New()
+	value, err := json.Marshal(signInReport{UserID: userID, SessionID: "abc"})
+	require.NoError(t, err)
+	require.NoError(t, redis.SaveSignInReport(auth.HashSingleUseToken("report-token"), string(value), signInReportTTL))
+
+	mockDB := new(mocks.MockQueries)
+	mockDB.On("DeleteTokenByUserID", mock.Anything, userID).Return(nil)
+	mockDB.On("DeleteUserDeviceTokens", mock.Anything, userID).Return(nil)
+	expectLoggedOutEverywhere(t, mockDB, userID)
+	mockDB.On("SetUserPassword", mock.Anything, database.SetUserPasswordParams{ID: userID}).Return(nil).Once()
+	sink := &recordingSink{}
+	server := newSignInAlertServer(mockDB, sink)
+
+	reported, err := server.ReportSignIn(context.Background(), &pb.ReportSignInRequest{Token: "report-token"})
+	require.NoError(t, err)
+	assert.NotEmpty(t, reported.PasswordResetToken)
+	assert.Equal(t, int64(3600), reported.PasswordResetExpiresIn)
+	require.Len(t, sink.events, 1)
+	assert.Equal(t, EventSignInReported, sink.events[0].Type)
+	assert.Equal(t, "abc", sink.events[0].SessionID)
+
+	_, err = server.ReportSignIn(context.Background(), &pb.ReportSignInRequest{Token: "report-token"})
+	assertReason(t, err, codes.Unauthenticated, autherr.ReasonSignInReportInvalid)
+
+	mockDB.On("SetUserPassword", mock.Anything, mock.MatchedBy(func(arg database.SetUserPasswordParams) bool {
+		return arg.ID == userID && auth.CheckPassword(arg.Password, "new-password") == nil
+	})).Return(nil).Once()
+
+	reset, err := server.ResetPassword(context.Background(), &pb.ResetPasswordRequest{
+		Token:       reported.PasswordResetToken,
+		NewPassword: "new-password",
+	})
+	require.NoError(t, err)
+	assert.True(t, reset.Success)
+	assert.Equal(t, EventPasswordReset, sink.events[len(sink.events)-1].Type)
+	mockDB.AssertExpectations(t)
+
+	_, err = server.ResetPassword(context.Background(), &pb.ResetPasswordRequest{
+		Token:       reported.PasswordResetToken,
+		NewPassword: "another-password",
+	})
+	assertReason(t, err, codes.Unauthenticated, autherr.ReasonPasswordResetInvalid)
+}
+
+func TestResetPasswordValidation(t *testing.T) {
+	server := newSignInAlertServer(new(mocks.MockQueries), &recordingSink{})
+
+	testCases := []struct {
+		name    string
+		request *pb.ResetPasswordRequest
+		code    codes.Code
+		reason  autherr.Reason
+	}{
+		{
+			name:    "missing fields",
+			request: &pb.ResetPasswordRequest{},
+			code:    codes.InvalidArgument,
+			reason:  autherr.ReasonInvalidArgument,
+		},
+		{
+			name:    "unknown token",
+			request: &pb.ResetPasswordRequest{Token: "unknown", NewPassword: "new-password"},
+			code:    codes.Unauthenticated,
+			reason:  autherr.ReasonPasswordResetInvalid,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := server.ResetPassword(context.Background(), tc.request)
+			assertReason(t, err, tc.code, tc.reason)
+		})
+	}
+}
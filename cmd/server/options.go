@@ -0,0 +1,202 @@
+package server
+
+import (
+	"time"
+
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/internal/emailpolicy"
+	"github.com/imhasandl/auth-service/internal/exportstore"
+	"github.com/imhasandl/auth-service/internal/oauth"
+)
+
+// Option configures optional settings of the Server
+type Option func(*Server)
+
+// WithVerificationCodes sets the number of digits and the lifetime of verification codes
+func WithVerificationCodes(length int, ttl time.Duration) Option {
+	return func(s *Server) {
+		s.codeLength = length
+		s.codeTTL = ttl
+	}
+}
+
+// WithLoginLinks sets the URL that login links point to and how long they stay valid.
+// The link token is added to the URL as the "token" query parameter
+func WithLoginLinks(baseURL string, ttl time.Duration) Option {
+	return func(s *Server) {
+		s.loginLinkURL = baseURL
+		s.loginLinkTTL = ttl
+	}
+}
+
+// WithLoginRateLimit sets how many login links and codes can be requested for one email address per window
+func WithLoginRateLimit(limit int, window time.Duration) Option {
+	return func(s *Server) {
+		s.loginRateLimit = limit
+		s.loginRateWindow = window
+	}
+}
+
+// WithExternalProviders enables logging in with the given OAuth2 and OpenID Connect providers
+func WithExternalProviders(providers ...oauth.Provider) Option {
+	return func(s *Server) {
+		for _, provider := range providers {
+			s.externalProviders[provider.Name()] = provider
+		}
+	}
+}
+
+// WithDeviceAuthorization enables the device authorization grant. verificationURL is the page where users
+// enter the code shown by the device, codes expire after ttl and devices poll once per interval
+func WithDeviceAuthorization(verificationURL string, ttl, interval time.Duration) Option {
+	return func(s *Server) {
+		s.deviceVerificationURL = verificationURL
+		s.deviceCodeTTL = ttl
+		s.devicePollInterval = interval
+	}
+}
+
+// WithServiceTokenTTL sets how long tokens issued to service clients stay valid
+func WithServiceTokenTTL(ttl time.Duration) Option {
+	return func(s *Server) {
+		s.serviceTokenTTL = ttl
+	}
+}
+
+// WithAuditSink records auth events to sink. When trustForwardedFor is set, the client address is taken from
+// the x-forwarded-for metadata, which should only be done behind a proxy that sets it
+func WithAuditSink(sink AuditSink, trustForwardedFor bool) Option {
+	return func(s *Server) {
+		s.auditSink = sink
+		s.trustForwardedFor = trustForwardedFor
+	}
+}
+
+// WithNewSignInAlerts emails users when they log in from a device they haven't used before. The email links
+// to reportURL with a "token" query parameter, which the page passes to ReportSignIn. Password reset tokens
+// issued by ReportSignIn stay valid for passwordResetTTL
+func WithNewSignInAlerts(reportURL string, passwordResetTTL time.Duration) Option {
+	return func(s *Server) {
+		s.signInReportURL = reportURL
+		s.passwordResetTTL = passwordResetTTL
+	}
+}
+
+// WithEmailChangeUndo links the email sent to the old address after an email change to undoURL with a "token"
+// query parameter, which the page passes to UndoEmailChange. The old address stays reserved for the account and
+// the link works for gracePeriod. Without it email changes can't be undone
+func WithEmailChangeUndo(undoURL string, gracePeriod time.Duration) Option {
+	return func(s *Server) {
+		s.emailChangeUndoURL = undoURL
+		s.emailChangeGracePeriod = gracePeriod
+	}
+}
+
+// WithUsernamePolicy sets how often users can change their username and how long an old name stays reserved for
+// them afterwards. reserved is added to the built-in list of names users can't pick
+func WithUsernamePolicy(cooldown, holdPeriod time.Duration, reserved ...string) Option {
+	return func(s *Server) {
+		s.usernameCooldown = cooldown
+		s.usernameHoldPeriod = holdPeriod
+		for _, name := range reserved {
+			s.reservedUsernames[reservedUsernameKey(name)] = struct{}{}
+		}
+	}
+}
+
+// WithEmailPolicy sets which email domains can be used for new accounts and email changes. By default every
+// domain except the built-in disposable ones can
+func WithEmailPolicy(policy *emailpolicy.Policy) Option {
+	return func(s *Server) {
+		s.emailPolicy = policy
+	}
+}
+
+// WithAccountDeletion sets how long accounts wait for deletion after DeleteAccount and links the email sent then to
+// cancelURL with a "token" query parameter, which the page passes to CancelAccountDeletion. Without cancelURL the
+// email has no link. When anonymize is set, purged accounts keep an anonymized user row with their posts, comments
+// and messages, otherwise those are deleted with the user
+func WithAccountDeletion(cancelURL string, gracePeriod time.Duration, anonymize bool) Option {
+	return func(s *Server) {
+		s.accountDeletionCancelURL = cancelURL
+		s.accountDeletionGracePeriod = gracePeriod
+		s.accountDeletionAnonymize = anonymize
+	}
+}
+
+// WithDataExports enables RequestDataExport. Archives are written to store and kept for retention. downloadURL
+// is where DataExportHandler is served, GetDataExport adds a download token to it as the "token" query parameter,
+// which stays valid for tokenTTL
+func WithDataExports(store exportstore.Store, downloadURL string, retention, tokenTTL time.Duration) Option {
+	return func(s *Server) {
+		s.dataExportStore = store
+		s.dataExportDownloadURL = downloadURL
+		s.dataExportRetention = retention
+		s.dataExportTokenTTL = tokenTTL
+	}
+}
+
+// WithDeviceTokenTTL sets how long a push token is kept after it was last registered. Apps register their token
+// again whenever they start
+func WithDeviceTokenTTL(ttl time.Duration) Option {
+	return func(s *Server) {
+		s.deviceTokenTTL = ttl
+	}
+}
+
+// WithAPIKeyScopes replaces the built-in list of scopes every user may put on an API key. Users can always add
+// the permissions they hold
+func WithAPIKeyScopes(scopes ...string) Option {
+	return func(s *Server) {
+		s.apiKeyScopes = scopes
+	}
+}
+
+// WithBilling sets the secret the billing provider signs its webhook events with, and which plan each of its
+// price IDs entitles to. BillingWebhookHandler rejects every event without a secret
+func WithBilling(secret string, pricePlans map[string]string) Option {
+	return func(s *Server) {
+		s.billingSecret = secret
+		s.billingPricePlans = pricePlans
+	}
+}
+
+func defaultServer() *Server {
+	reservedUsernames := make(map[string]struct{}, len(defaultReservedUsernames))
+	for _, name := range defaultReservedUsernames {
+		reservedUsernames[reservedUsernameKey(name)] = struct{}{}
+	}
+
+	return &Server{
+		codeLength:      auth.DefaultVerificationCodeLength,
+		codeTTL:         15 * time.Minute,
+		loginLinkTTL:    15 * time.Minute,
+		loginRateLimit:  5,
+		loginRateWindow: 15 * time.Minute,
+		serviceTokenTTL: 15 * time.Minute,
+
+		passwordResetTTL: time.Hour,
+
+		emailChangeGracePeriod: 7 * 24 * time.Hour,
+
+		reservedUsernames:  reservedUsernames,
+		usernameCooldown:   30 * 24 * time.Hour,
+		usernameHoldPeriod: 30 * 24 * time.Hour,
+
+		emailPolicy: emailpolicy.DefaultPolicy(),
+
+		accountDeletionGracePeriod: 30 * 24 * time.Hour,
+		accountDeletionAnonymize:   true,
+
+		deviceCodeTTL:      10 * time.Minute,
+		devicePollInterval: 5 * time.Second,
+
+		deviceTokenTTL: 60 * 24 * time.Hour,
+
+		apiKeyScopes: defaultAPIKeyScopes,
+
+		externalProviders: make(map[string]oauth.Provider),
+
+		auditSink: nopAuditSink{},
+	}
+}
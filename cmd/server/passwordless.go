@@ -0,0 +1,325 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/cmd/helper"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/emailpolicy"
+	"github.com/imhasandl/auth-service/internal/redis"
+	pb "github.com/imhasandl/auth-service/protos"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// emailLoginSentMessage is returned by RequestLoginLink and RequestLoginCode for registered and unknown emails alike
+const emailLoginSentMessage = "if the email is registered, a login email was sent"
+
+// RequestLoginLink emails a single use login link to the user.
+// The response is the same whether or not the email is registered, so it can't be used to find out which accounts exist.
+func (s *Server) RequestLoginLink(ctx context.Context, req *pb.RequestLoginLinkRequest) (*pb.RequestLoginLinkResponse, error) {
+	if req.GetEmail() == "" {
+		return nil, helper.RespondWithError(ctx, autherr.InvalidArgument("email", "email is required"))
+	}
+
+	if s.loginLinkURL == "" {
+		return nil, helper.RespondWithError(ctx, autherr.LoginLinksDisabled())
+	}
+
+	user, found, err := s.findEmailLoginUser(ctx, req.GetEmail())
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	if found {
+		s.sendLoginEmail(ctx, func(ctx context.Context) error {
+			return s.sendLoginLink(ctx, user, req.GetDeviceId())
+		})
+	}
+
+	return &pb.RequestLoginLinkResponse{
+		Success: true,
+		Message: emailLoginSentMessage,
+	}, nil
+}
+
+// sendLoginLink stores a new login link token for the device and emails the link to the user
+func (s *Server) sendLoginLink(ctx context.Context, user database.User, deviceID string) error {
+	token, err := auth.MakeLoginLinkToken(user.ID, s.tokenSecret, time.Now().Add(s.loginLinkTTL))
+	if err != nil {
+		return err
+	}
+
+	link, err := s.loginLink(token)
+	if err != nil {
+		return err
+	}
+
+	tokenHash := auth.HashLoginLinkToken(token, s.tokenSecret)
+	if err := s.storeCode(ctx, s.db, user.ID, database.PurposeLoginLink, tokenHash, deviceID, s.loginLinkTTL); err != nil {
+		return err
+	}
+
+	if s.email == "test@example.com" {
+		return nil
+	}
+	return auth.SendLoginLinkEmail(user.Email, s.email, s.emailSecret, link, s.loginLinkTTL)
+}
+
+// RequestLoginCode emails a one time login code to the user.
+// The response is the same whether or not the email is registered, so it can't be used to find out which accounts exist.
+func (s *Server) RequestLoginCode(ctx context.Context, req *pb.RequestLoginCodeRequest) (*pb.RequestLoginCodeResponse, error) {
+	if req.GetEmail() == "" {
+		return nil, helper.RespondWithError(ctx, autherr.InvalidArgument("email", "email is required"))
+	}
+
+	user, found, err := s.findEmailLoginUser(ctx, req.GetEmail())
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	if found {
+		s.sendLoginEmail(ctx, func(ctx context.Context) error {
+			return s.sendLoginCode(ctx, user, req.GetDeviceId())
+		})
+	}
+
+	return &pb.RequestLoginCodeResponse{
+		Success: true,
+		Message: emailLoginSentMessage,
+	}, nil
+}
+
+// sendLoginCode stores a new login code for the device and emails it to the user
+func (s *Server) sendLoginCode(ctx context.Context, user database.User, deviceID string) error {
+	code, err := auth.GenerateVerificationCode(s.codeLength)
+	if err != nil {
+		return err
+	}
+
+	codeHash := auth.HashVerificationCode(code, s.tokenSecret)
+	if err := s.storeCode(ctx, s.db, user.ID, database.PurposeLoginCode, codeHash, deviceID, s.codeTTL); err != nil {
+		return err
+	}
+
+	if s.email == "test@example.com" {
+		return nil
+	}
+	return auth.SendLoginCodeEmail(user.Email, s.email, s.emailSecret, code, s.codeTTL)
+}
+
+// sendLoginEmail runs send off the request path, so registered emails are answered as fast as unknown ones and
+// delivery failures don't tell them apart either. Failures are only logged
+func (s *Server) sendLoginEmail(ctx context.Context, send func(context.Context) error) {
+	ctx = context.WithoutCancel(ctx)
+	s.loginEmails.Add(1)
+	go func() {
+		defer s.loginEmails.Done()
+		if err := send(ctx); err != nil {
+			log.Printf("Error sending login email: %v", err)
+		}
+	}()
+}
+
+// LoginWithEmailToken logs a user in with a login link token or a login code and issues the same
+// access and refresh token pair as Login. Links and codes work once, and only with the device_id they were requested with.
+// Logging in this way proves the user owns the email address, so it is marked as verified.
+func (s *Server) LoginWithEmailToken(ctx context.Context, req *pb.LoginWithEmailTokenRequest) (*pb.LoginResponse, error) {
+	var violations autherr.Violations
+	switch {
+	case req.GetLinkToken() == "" && req.GetCode() == 0:
+		violations.Add("link_token", "either link_token or code is required")
+	case req.GetLinkToken() != "" && req.GetCode() != 0:
+		violations.Add("code", "set either link_token or code, not both")
+	case req.GetCode() != 0 && req.GetEmail() == "":
+		violations.Add("email", "email is required to log in with a code")
+	}
+	if err := violations.Err(); err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	var (
+		user    database.User
+		stored  database.VerificationCode
+		purpose string
+		method  string
+		err     error
+	)
+	if req.GetLinkToken() != "" {
+		purpose, method = database.PurposeLoginLink, loginMethodLoginLink
+		user, stored, err = s.checkLoginLink(ctx, req.GetLinkToken(), req.GetDeviceId())
+	} else {
+		purpose, method = database.PurposeLoginCode, loginMethodLoginCode
+		user, stored, err = s.checkLoginCode(ctx, req.GetEmail(), req.GetCode(), req.GetDeviceId())
+	}
+	if err != nil {
+		s.recordLoginFailure(ctx, user.ID, req.GetEmail(), method, err)
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	var accessToken, refreshToken string
+	err = s.db.WithTx(ctx, func(q DBQuerier) error {
+		consumed, err := q.ConsumeVerificationCode(ctx, database.ConsumeVerificationCodeParams{
+			UserID:   user.ID,
+			Purpose:  purpose,
+			CodeHash: stored.CodeHash,
+		})
+		if err != nil {
+			return err
+		}
+		if consumed == 0 {
+			// A concurrent request used the link or code first
+			if purpose == database.PurposeLoginLink {
+				return autherr.LoginLinkInvalid()
+			}
+			return autherr.VerificationCodeExpired()
+		}
+
+		if !user.IsVerified {
+			if err := verifyUser(ctx, q, emailpolicy.LookupKey(user.Email)); err != nil {
+				return err
+			}
+		}
+
+		accessToken, refreshToken, err = s.issueTokens(ctx, q, user.ID)
+		return err
+	})
+	if err != nil {
+		s.recordLoginFailure(ctx, user.ID, req.GetEmail(), method, err)
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	cacheTokens(user.ID, accessToken, refreshToken)
+	s.recordLogin(ctx, user.ID, method, refreshToken)
+
+	return &pb.LoginResponse{
+		User: &pb.User{
+			Id:         user.ID.String(),
+			CreatedAt:  timestamppb.New(user.CreatedAt),
+			UpdatedAt:  timestamppb.New(user.UpdatedAt),
+			Email:      user.Email,
+			Username:   user.Username,
+			IsPremium:  user.IsPremium,
+			IsVerified: true,
+		},
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// findEmailLoginUser applies the login email rate limit and looks the user up by email.
+// Both go by the canonical address, so changing the case of the email doesn't get around the limit.
+// found is false when no account uses the email
+func (s *Server) findEmailLoginUser(ctx context.Context, email string) (user database.User, found bool, err error) {
+	canonical := emailpolicy.LookupKey(email)
+	if err := s.checkLoginRateLimit(canonical); err != nil {
+		return user, false, err
+	}
+
+	user, err = s.db.GetUserByIdentifier(ctx, database.GetUserByIdentifierParams{
+		EmailCanonical: canonical,
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return user, false, nil
+		}
+		return user, false, err
+	}
+
+	return user, true, nil
+}
+
+// checkLoginRateLimit counts a login link or code request for the email and fails once the limit is reached
+func (s *Server) checkLoginRateLimit(email string) error {
+	return s.checkRateLimit("login_email:" + strings.ToLower(email))
+}
+
+// checkRateLimit counts an attempt against key with the login rate limit and fails once the limit is reached.
+// Redis errors are only logged, so a Redis outage doesn't lock everybody out
+func (s *Server) checkRateLimit(key string) error {
+	count, retryAfter, err := redis.IncrementRateLimit(key, s.loginRateWindow)
+	if err != nil {
+		log.Printf("WARNING: Failed to check rate limit in Redis: %v", err)
+		return nil
+	}
+
+	if count > int64(s.loginRateLimit) {
+		return autherr.RateLimited(retryAfter)
+	}
+
+	return nil
+}
+
+// checkLoginLink validates a login link token and returns the user it belongs to with the stored link
+func (s *Server) checkLoginLink(ctx context.Context, token, deviceID string) (database.User, database.VerificationCode, error) {
+	userID, err := auth.ParseLoginLinkToken(token, s.tokenSecret)
+	if err != nil {
+		if errors.Is(err, auth.ErrLoginLinkExpired) {
+			return database.User{}, database.VerificationCode{}, autherr.LoginLinkExpired()
+		}
+		return database.User{}, database.VerificationCode{}, autherr.LoginLinkInvalid().WithCause(err)
+	}
+
+	user, err := s.db.GetUserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return user, database.VerificationCode{}, autherr.LoginLinkInvalid().WithCause(err)
+		}
+		return user, database.VerificationCode{}, err
+	}
+
+	stored, err := s.checkCode(ctx, user.ID, database.PurposeLoginLink, deviceID, func(codeHash string) bool {
+		return auth.CheckLoginLinkToken(codeHash, token, s.tokenSecret)
+	})
+	if errors.Is(err, autherr.VerificationCodeInvalid()) || errors.Is(err, autherr.VerificationCodeExpired()) {
+		// The link was already used or replaced by a newer one
+		return user, stored, autherr.LoginLinkInvalid().WithCause(err)
+	}
+
+	return user, stored, err
+}
+
+// checkLoginCode validates a login code and returns the user it belongs to with the stored code.
+// An unknown email is reported like an email without an active code
+func (s *Server) checkLoginCode(ctx context.Context, email string, code int32, deviceID string) (database.User, database.VerificationCode, error) {
+	user, err := s.db.GetUserByIdentifier(ctx, database.GetUserByIdentifierParams{
+		EmailCanonical: emailpolicy.LookupKey(email),
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return user, database.VerificationCode{}, autherr.VerificationCodeExpired().WithCause(err)
+		}
+		return user, database.VerificationCode{}, err
+	}
+
+	stored, err := s.checkCode(ctx, user.ID, database.PurposeLoginCode, deviceID, func(codeHash string) bool {
+		return auth.CheckVerificationCode(codeHash, code, s.tokenSecret)
+	})
+	return user, stored, err
+}
+
+// loginLink builds the URL sent in login link emails
+func (s *Server) loginLink(token string) (string, error) {
+	return linkWithToken(s.loginLinkURL, token)
+}
+
+// linkWithToken adds token to baseURL as the "token" query parameter
+func linkWithToken(baseURL, token string) (string, error) {
+	link, err := url.Parse(baseURL)
+	if err != nil {
+		return "", err
+	}
+
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	return link.String(), nil
+}
@@ -0,0 +1,531 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/database/mocks"
+	"github.com/imhasandl/auth-service/internal/outbox"
+	pb "github.com/imhasandl/auth-service/protos"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRequestLoginCode(t *testing.T) {
+	const secret = "test-secret"
+	userID := uuid.New()
+
+	testCases := []struct {
+		name          string
+		request       *pb.RequestLoginCodeRequest
+		mockSetup     func(*mocks.MockQueries)
+		expectedError bool
+		errorCode     codes.Code
+		errorReason   autherr.Reason
+	}{
+		{
+			name: "code bound to the requesting device",
+			request: &pb.RequestLoginCodeRequest{
+				Email:    "code@example.com",
+				DeviceId: "device-1",
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByIdentifier", mock.Anything, database.GetUserByIdentifierParams{
+					EmailCanonical: "code@example.com",
+				}).Return(database.User{
+					ID:    userID,
+					Email: "code@example.com",
+				}, nil)
+				mockDB.On("UpsertVerificationCode", mock.Anything, mock.MatchedBy(func(arg database.UpsertVerificationCodeParams) bool {
+					return arg.UserID == userID &&
+						arg.Purpose == database.PurposeLoginCode &&
+						arg.CodeHash != "" &&
+						arg.DeviceHash == auth.HashDeviceID("device-1", secret) &&
+						arg.ExpiresAt.After(time.Now())
+				})).Return(database.VerificationCode{}, nil)
+			},
+			expectedError: false,
+		},
+		{
+			name: "failure to send gets the same response",
+			request: &pb.RequestLoginCodeRequest{
+				Email: "failing-code@example.com",
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(database.User{
+					ID:    userID,
+					Email: "failing-code@example.com",
+				}, nil)
+				mockDB.On("UpsertVerificationCode", mock.Anything, mock.Anything).
+					Return(database.VerificationCode{}, errors.New("database is down"))
+			},
+			expectedError: false,
+		},
+		{
+			name: "unknown email gets the same response",
+			request: &pb.RequestLoginCodeRequest{
+				Email: "unknown-code@example.com",
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(database.User{}, sql.ErrNoRows)
+			},
+			expectedError: false,
+		},
+		{
+			name:          "missing email",
+			request:       &pb.RequestLoginCodeRequest{},
+			mockSetup:     func(mockDB *mocks.MockQueries) {},
+			expectedError: true,
+			errorCode:     codes.InvalidArgument,
+			errorReason:   autherr.ReasonInvalidArgument,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB := new(mocks.MockQueries)
+			server := NewServer(mockDB, secret, "test@example.com", "email-secret")
+
+			tc.mockSetup(mockDB)
+
+			response, err := server.RequestLoginCode(context.Background(), tc.request)
+			server.loginEmails.Wait()
+
+			if tc.expectedError {
+				assert.Error(t, err)
+				assert.Equal(t, tc.errorCode, status.Code(err))
+				assert.Equal(t, tc.errorReason, autherr.ReasonOf(err))
+				assert.Nil(t, response)
+			} else {
+				assert.NoError(t, err)
+				assert.True(t, response.Success)
+				assert.Equal(t, emailLoginSentMessage, response.Message)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRequestLoginLink(t *testing.T) {
+	const secret = "test-secret"
+	userID := uuid.New()
+
+	testCases := []struct {
+		name          string
+		linkURL       string
+		request       *pb.RequestLoginLinkRequest
+		mockSetup     func(*mocks.MockQueries)
+		expectedError bool
+		errorCode     codes.Code
+		errorReason   autherr.Reason
+	}{
+		{
+			name:    "link sent",
+			linkURL: "https://example.com/login?source=email",
+			request: &pb.RequestLoginLinkRequest{
+				Email:    "link@example.com",
+				DeviceId: "device-1",
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(database.User{
+					ID:    userID,
+					Email: "link@example.com",
+				}, nil)
+				mockDB.On("UpsertVerificationCode", mock.Anything, mock.MatchedBy(func(arg database.UpsertVerificationCodeParams) bool {
+					return arg.UserID == userID &&
+						arg.Purpose == database.PurposeLoginLink &&
+						arg.CodeHash != "" &&
+						arg.DeviceHash == auth.HashDeviceID("device-1", secret) &&
+						arg.ExpiresAt.After(time.Now().Add(time.Hour))
+				})).Return(database.VerificationCode{}, nil)
+			},
+			expectedError: false,
+		},
+		{
+			name:    "unknown email gets the same response",
+			linkURL: "https://example.com/login",
+			request: &pb.RequestLoginLinkRequest{
+				Email: "unknown-link@example.com",
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(database.User{}, sql.ErrNoRows)
+			},
+			expectedError: false,
+		},
+		{
+			name: "login links not configured",
+			request: &pb.RequestLoginLinkRequest{
+				Email: "link@example.com",
+			},
+			mockSetup:     func(mockDB *mocks.MockQueries) {},
+			expectedError: true,
+			errorCode:     codes.FailedPrecondition,
+			errorReason:   autherr.ReasonLoginLinksDisabled,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB := new(mocks.MockQueries)
+			server := NewServer(mockDB, secret, "test@example.com", "email-secret",
+				WithLoginLinks(tc.linkURL, 2*time.Hour),
+			)
+
+			tc.mockSetup(mockDB)
+
+			response, err := server.RequestLoginLink(context.Background(), tc.request)
+			server.loginEmails.Wait()
+
+			if tc.expectedError {
+				assert.Error(t, err)
+				assert.Equal(t, tc.errorCode, status.Code(err))
+				assert.Equal(t, tc.errorReason, autherr.ReasonOf(err))
+				assert.Nil(t, response)
+			} else {
+				assert.NoError(t, err)
+				assert.True(t, response.Success)
+				assert.Equal(t, emailLoginSentMessage, response.Message)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestLoginLinkURL(t *testing.T) {
+	server := NewServer(new(mocks.MockQueries), "test-secret", "test@example.com", "email-secret",
+		WithLoginLinks("https://example.com/login?source=email", time.Hour),
+	)
+
+	link, err := server.loginLink("abc.def")
+	assert.NoError(t, err)
+
+	parsed, err := url.Parse(link)
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com", parsed.Host)
+	assert.Equal(t, "abc.def", parsed.Query().Get("token"))
+	assert.Equal(t, "email", parsed.Query().Get("source"))
+}
+
+func TestEmailLoginRateLimit(t *testing.T) {
+	mockDB := new(mocks.MockQueries)
+	server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret",
+		WithLoginLinks("https://example.com/login", time.Hour),
+		WithLoginRateLimit(2, time.Minute),
+	)
+	ctx := context.Background()
+
+	mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(database.User{}, sql.ErrNoRows)
+
+	// Links and codes share the limit, and the email is compared case insensitively
+	_, err := server.RequestLoginCode(ctx, &pb.RequestLoginCodeRequest{Email: "limited@example.com"})
+	assert.NoError(t, err)
+	_, err = server.RequestLoginLink(ctx, &pb.RequestLoginLinkRequest{Email: "Limited@example.com"})
+	assert.NoError(t, err)
+
+	_, err = server.RequestLoginCode(ctx, &pb.RequestLoginCodeRequest{Email: "limited@example.com"})
+	assert.Error(t, err)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, autherr.ReasonRateLimited, autherr.ReasonOf(err))
+
+	// Other emails are not affected
+	_, err = server.RequestLoginCode(ctx, &pb.RequestLoginCodeRequest{Email: "other-limited@example.com"})
+	assert.NoError(t, err)
+
+	mockDB.AssertNumberOfCalls(t, "GetUserByIdentifier", 3)
+}
+
+func TestLoginWithEmailToken(t *testing.T) {
+	const secret = "test-secret"
+	userID := uuid.New()
+
+	linkToken, err := auth.MakeLoginLinkToken(userID, secret, time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+	expiredLinkToken, err := auth.MakeLoginLinkToken(userID, secret, time.Now().Add(-time.Minute))
+	assert.NoError(t, err)
+	forgedLinkToken, err := auth.MakeLoginLinkToken(userID, "other-secret", time.Now().Add(time.Hour))
+	assert.NoError(t, err)
+
+	user := database.User{
+		ID:         userID,
+		Email:      "test@example.com",
+		Username:   "testuser",
+		IsVerified: false,
+	}
+
+	storedCode := database.VerificationCode{
+		UserID:     userID,
+		Purpose:    database.PurposeLoginCode,
+		CodeHash:   auth.HashVerificationCode(123456, secret),
+		ExpiresAt:  time.Now().Add(time.Hour),
+		DeviceHash: auth.HashDeviceID("device-1", secret),
+	}
+
+	storedLink := database.VerificationCode{
+		UserID:     userID,
+		Purpose:    database.PurposeLoginLink,
+		CodeHash:   auth.HashLoginLinkToken(linkToken, secret),
+		ExpiresAt:  time.Now().Add(time.Hour),
+		DeviceHash: auth.HashDeviceID("device-1", secret),
+	}
+
+	expectSession := func(mockDB *mocks.MockQueries) {
+		mockDB.On("GetUserAuthorization", mock.Anything, userID).Return(database.GetUserAuthorizationRow{}, nil)
+		mockDB.On("RefreshToken", mock.Anything, mock.MatchedBy(func(arg database.RefreshTokenParams) bool {
+			return arg.UserID == userID && arg.Token != ""
+		})).Return(database.RefreshToken{}, nil)
+	}
+
+	testCases := []struct {
+		name          string
+		request       *pb.LoginWithEmailTokenRequest
+		mockSetup     func(*mocks.MockQueries)
+		expectedError bool
+		errorCode     codes.Code
+		errorReason   autherr.Reason
+	}{
+		{
+			name: "login with code",
+			request: &pb.LoginWithEmailTokenRequest{
+				Email:    "test@example.com",
+				Code:     123456,
+				DeviceId: "device-1",
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByIdentifier", mock.Anything, database.GetUserByIdentifierParams{
+					EmailCanonical: "test@example.com",
+				}).Return(user, nil)
+				mockDB.On("GetVerificationCode", mock.Anything, database.GetVerificationCodeParams{
+					UserID:  userID,
+					Purpose: database.PurposeLoginCode,
+				}).Return(storedCode, nil)
+				mockDB.On("ConsumeVerificationCode", mock.Anything, database.ConsumeVerificationCodeParams{
+					UserID:   userID,
+					Purpose:  database.PurposeLoginCode,
+					CodeHash: storedCode.CodeHash,
+				}).Return(int64(1), nil)
+				mockDB.On("VerifyUser", mock.Anything, "test@example.com").Return(userID, nil)
+				mockDB.On("CreateOutboxEvent", mock.Anything, mock.MatchedBy(func(arg database.CreateOutboxEventParams) bool {
+					return arg.EventType == outbox.UserVerified && arg.UserID == userID
+				})).Return(nil)
+				mockDB.On("CreateWebhookDeliveries", mock.Anything, mock.Anything).Return(nil)
+				expectSession(mockDB)
+			},
+			expectedError: false,
+		},
+		{
+			name: "code used on another device",
+			request: &pb.LoginWithEmailTokenRequest{
+				Email:    "test@example.com",
+				Code:     123456,
+				DeviceId: "device-2",
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(user, nil)
+				mockDB.On("GetVerificationCode", mock.Anything, mock.Anything).Return(storedCode, nil)
+				mockDB.On("IncrementVerificationCodeAttempts", mock.Anything, database.IncrementVerificationCodeAttemptsParams{
+					UserID:  userID,
+					Purpose: database.PurposeLoginCode,
+				}).Return(int32(1), nil)
+			},
+			expectedError: true,
+			errorCode:     codes.PermissionDenied,
+			errorReason:   autherr.ReasonDeviceMismatch,
+		},
+		{
+			name: "wrong code",
+			request: &pb.LoginWithEmailTokenRequest{
+				Email:    "test@example.com",
+				Code:     654321,
+				DeviceId: "device-1",
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(user, nil)
+				mockDB.On("GetVerificationCode", mock.Anything, mock.Anything).Return(storedCode, nil)
+				mockDB.On("IncrementVerificationCodeAttempts", mock.Anything, mock.Anything).Return(int32(1), nil)
+			},
+			expectedError: true,
+			errorCode:     codes.InvalidArgument,
+			errorReason:   autherr.ReasonVerificationCodeInvalid,
+		},
+		{
+			name: "unknown email looks like a missing code",
+			request: &pb.LoginWithEmailTokenRequest{
+				Email: "notfound@example.com",
+				Code:  123456,
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(database.User{}, sql.ErrNoRows)
+			},
+			expectedError: true,
+			errorCode:     codes.FailedPrecondition,
+			errorReason:   autherr.ReasonVerificationCodeExpired,
+		},
+		{
+			name: "code used by a concurrent request",
+			request: &pb.LoginWithEmailTokenRequest{
+				Email:    "test@example.com",
+				Code:     123456,
+				DeviceId: "device-1",
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByIdentifier", mock.Anything, mock.Anything).Return(user, nil)
+				mockDB.On("GetVerificationCode", mock.Anything, mock.Anything).Return(storedCode, nil)
+				mockDB.On("ConsumeVerificationCode", mock.Anything, mock.Anything).Return(int64(0), nil)
+			},
+			expectedError: true,
+			errorCode:     codes.FailedPrecondition,
+			errorReason:   autherr.ReasonVerificationCodeExpired,
+		},
+		{
+			name: "login with link",
+			request: &pb.LoginWithEmailTokenRequest{
+				LinkToken: linkToken,
+				DeviceId:  "device-1",
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				verifiedUser := user
+				verifiedUser.IsVerified = true
+				mockDB.On("GetUserByID", mock.Anything, userID).Return(verifiedUser, nil)
+				mockDB.On("GetVerificationCode", mock.Anything, database.GetVerificationCodeParams{
+					UserID:  userID,
+					Purpose: database.PurposeLoginLink,
+				}).Return(storedLink, nil)
+				mockDB.On("ConsumeVerificationCode", mock.Anything, database.ConsumeVerificationCodeParams{
+					UserID:   userID,
+					Purpose:  database.PurposeLoginLink,
+					CodeHash: storedLink.CodeHash,
+				}).Return(int64(1), nil)
+				expectSession(mockDB)
+			},
+			expectedError: false,
+		},
+		{
+			name: "link replaced by a newer one",
+			request: &pb.LoginWithEmailTokenRequest{
+				LinkToken: linkToken,
+				DeviceId:  "device-1",
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				newerLink := storedLink
+				newerLink.CodeHash = auth.HashLoginLinkToken("newer-token", secret)
+				mockDB.On("GetUserByID", mock.Anything, userID).Return(user, nil)
+				mockDB.On("GetVerificationCode", mock.Anything, mock.Anything).Return(newerLink, nil)
+				mockDB.On("IncrementVerificationCodeAttempts", mock.Anything, mock.Anything).Return(int32(1), nil)
+			},
+			expectedError: true,
+			errorCode:     codes.Unauthenticated,
+			errorReason:   autherr.ReasonLoginLinkInvalid,
+		},
+		{
+			name: "link already used",
+			request: &pb.LoginWithEmailTokenRequest{
+				LinkToken: linkToken,
+				DeviceId:  "device-1",
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByID", mock.Anything, userID).Return(user, nil)
+				mockDB.On("GetVerificationCode", mock.Anything, mock.Anything).Return(database.VerificationCode{}, sql.ErrNoRows)
+			},
+			expectedError: true,
+			errorCode:     codes.Unauthenticated,
+			errorReason:   autherr.ReasonLoginLinkInvalid,
+		},
+		{
+			name: "link opened on another device",
+			request: &pb.LoginWithEmailTokenRequest{
+				LinkToken: linkToken,
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByID", mock.Anything, userID).Return(user, nil)
+				mockDB.On("GetVerificationCode", mock.Anything, mock.Anything).Return(storedLink, nil)
+				mockDB.On("IncrementVerificationCodeAttempts", mock.Anything, mock.Anything).Return(int32(1), nil)
+			},
+			expectedError: true,
+			errorCode:     codes.PermissionDenied,
+			errorReason:   autherr.ReasonDeviceMismatch,
+		},
+		{
+			name: "forged link",
+			request: &pb.LoginWithEmailTokenRequest{
+				LinkToken: forgedLinkToken,
+			},
+			mockSetup:     func(mockDB *mocks.MockQueries) {},
+			expectedError: true,
+			errorCode:     codes.Unauthenticated,
+			errorReason:   autherr.ReasonLoginLinkInvalid,
+		},
+		{
+			name: "expired link",
+			request: &pb.LoginWithEmailTokenRequest{
+				LinkToken: expiredLinkToken,
+			},
+			mockSetup:     func(mockDB *mocks.MockQueries) {},
+			expectedError: true,
+			errorCode:     codes.Unauthenticated,
+			errorReason:   autherr.ReasonLoginLinkExpired,
+		},
+		{
+			name:          "no link or code",
+			request:       &pb.LoginWithEmailTokenRequest{Email: "test@example.com"},
+			mockSetup:     func(mockDB *mocks.MockQueries) {},
+			expectedError: true,
+			errorCode:     codes.InvalidArgument,
+			errorReason:   autherr.ReasonInvalidArgument,
+		},
+		{
+			name: "both link and code",
+			request: &pb.LoginWithEmailTokenRequest{
+				Email:     "test@example.com",
+				LinkToken: linkToken,
+				Code:      123456,
+			},
+			mockSetup:     func(mockDB *mocks.MockQueries) {},
+			expectedError: true,
+			errorCode:     codes.InvalidArgument,
+			errorReason:   autherr.ReasonInvalidArgument,
+		},
+		{
+			name:          "code without email",
+			request:       &pb.LoginWithEmailTokenRequest{Code: 123456},
+			mockSetup:     func(mockDB *mocks.MockQueries) {},
+			expectedError: true,
+			errorCode:     codes.InvalidArgument,
+			errorReason:   autherr.ReasonInvalidArgument,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB := new(mocks.MockQueries)
+			server := NewServer(mockDB, secret, "test@example.com", "email-secret")
+
+			tc.mockSetup(mockDB)
+
+			response, err := server.LoginWithEmailToken(context.Background(), tc.request)
+
+			if tc.expectedError {
+				assert.Error(t, err)
+				assert.Equal(t, tc.errorCode, status.Code(err))
+				assert.Equal(t, tc.errorReason, autherr.ReasonOf(err))
+				assert.Nil(t, response)
+			} else {
+				assert.NoError(t, err)
+				assert.NotEmpty(t, response.Token)
+				assert.NotEmpty(t, response.RefreshToken)
+				assert.Equal(t, userID.String(), response.User.Id)
+				assert.True(t, response.User.IsVerified)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import "github.com/imhasandl/auth-service/cmd/authz"
+
+// Audience is the name service tokens have to be issued for to call this service
+const Audience = "auth-service"
+
+// Permissions checked by this service
+const (
+	PermissionRolesRead  = "roles:read"
+	PermissionRolesWrite = "roles:write"
+	PermissionUsersRead  = "users:read"
+	PermissionUsersWrite = "users:write"
+
+	PermissionDevicesRead = "devices:read"
+
+	PermissionWebhooksRead  = "webhooks:read"
+	PermissionWebhooksWrite = "webhooks:write"
+
+	PermissionEntitlementsRead  = "entitlements:read"
+	PermissionEntitlementsWrite = "entitlements:write"
+)
+
+// AuthorizationPolicy lists the permissions the admin RPCs require. main installs it with an authz interceptor
+var AuthorizationPolicy = authz.Policy{
+	"/auth.AuthService/AssignRole": PermissionRolesWrite,
+	"/auth.AuthService/RevokeRole": PermissionRolesWrite,
+	"/auth.AuthService/ListRoles":  PermissionRolesRead,
+
+	"/auth.AdminService/ListUsers":          PermissionUsersRead,
+	"/auth.AdminService/GetUser":            PermissionUsersRead,
+	"/auth.AdminService/UpdateUser":         PermissionUsersWrite,
+	"/auth.AdminService/SuspendUser":        PermissionUsersWrite,
+	"/auth.AdminService/BanUser":            PermissionUsersWrite,
+	"/auth.AdminService/UnsuspendUser":      PermissionUsersWrite,
+	"/auth.AdminService/ForceLogout":        PermissionUsersWrite,
+	"/auth.AdminService/ForceVerifyEmail":   PermissionUsersWrite,
+	"/auth.AdminService/ResendVerification": PermissionUsersWrite,
+	"/auth.AdminService/ListSecurityEvents": PermissionUsersRead,
+	"/auth.AdminService/ListDeviceTokens":   PermissionDevicesRead,
+
+	"/auth.AdminService/CreateWebhookEndpoint":   PermissionWebhooksWrite,
+	"/auth.AdminService/ListWebhookEndpoints":    PermissionWebhooksRead,
+	"/auth.AdminService/DeleteWebhookEndpoint":   PermissionWebhooksWrite,
+	"/auth.AdminService/ListWebhookDeliveries":   PermissionWebhooksRead,
+	"/auth.AdminService/ReplayWebhookDeliveries": PermissionWebhooksWrite,
+
+	"/auth.AdminService/ListPlans":         PermissionEntitlementsRead,
+	"/auth.AdminService/GrantEntitlement":  PermissionEntitlementsWrite,
+	"/auth.AdminService/RevokeEntitlement": PermissionEntitlementsWrite,
+	"/auth.AdminService/ListEntitlements":  PermissionEntitlementsRead,
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/authz"
+	"github.com/imhasandl/auth-service/cmd/helper"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	pb "github.com/imhasandl/auth-service/protos"
+)
+
+// AssignRole gives a user a role. The permissions of the role are added to the user's access tokens the next
+// time they log in or refresh their token.
+func (s *Server) AssignRole(ctx context.Context, req *pb.AssignRoleRequest) (*pb.AssignRoleResponse, error) {
+	userID, role, err := parseRoleRequest(req.GetUserId(), req.GetRole())
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	if err := s.checkRoleAndUser(ctx, role, userID); err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	var grantedBy uuid.NullUUID
+	if principal, ok := authz.FromContext(ctx); ok {
+		grantedBy.UUID, grantedBy.Valid = principal.UserID()
+	}
+
+	assigned, err := s.db.AssignRole(ctx, database.AssignRoleParams{
+		UserID:    userID,
+		Role:      role,
+		GrantedBy: grantedBy,
+	})
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	message := "Role assigned"
+	if assigned == 0 {
+		message = "User already has the role"
+	} else {
+		s.recordEvent(ctx, AuthEvent{
+			Type:    EventRoleAssigned,
+			UserID:  userID,
+			Actor:   principalSubject(ctx),
+			Details: map[string]string{"role": role},
+		})
+	}
+	return &pb.AssignRoleResponse{
+		Success: true,
+		Message: message,
+	}, nil
+}
+
+// RevokeRole takes a role away from a user. Access tokens issued before keep the role until they expire.
+func (s *Server) RevokeRole(ctx context.Context, req *pb.RevokeRoleRequest) (*pb.RevokeRoleResponse, error) {
+	userID, role, err := parseRoleRequest(req.GetUserId(), req.GetRole())
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	if _, err := s.db.GetRole(ctx, role); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, helper.RespondWithError(ctx, autherr.RoleNotFound(role).WithCause(err))
+		}
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	revoked, err := s.db.RevokeRole(ctx, database.RevokeRoleParams{
+		UserID: userID,
+		Role:   role,
+	})
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	message := "Role revoked"
+	if revoked == 0 {
+		message = "User did not have the role"
+	} else {
+		s.recordEvent(ctx, AuthEvent{
+			Type:    EventRoleRevoked,
+			UserID:  userID,
+			Actor:   principalSubject(ctx),
+			Details: map[string]string{"role": role},
+		})
+	}
+	return &pb.RevokeRoleResponse{
+		Success: true,
+		Message: message,
+	}, nil
+}
+
+// ListRoles lists every role with its permissions, or only the roles of a user when user_id is set.
+func (s *Server) ListRoles(ctx context.Context, req *pb.ListRolesRequest) (*pb.ListRolesResponse, error) {
+	response := &pb.ListRolesResponse{}
+
+	if req.GetUserId() == "" {
+		roles, err := s.db.ListRoles(ctx)
+		if err != nil {
+			return nil, helper.RespondWithError(ctx, err)
+		}
+		for _, role := range roles {
+			response.Roles = append(response.Roles, &pb.Role{
+				Name:        role.Name,
+				Description: role.Description,
+				Permissions: role.Permissions,
+			})
+		}
+		return response, nil
+	}
+
+	userID, err := uuid.Parse(req.GetUserId())
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, autherr.InvalidArgument("user_id", "user_id should be a UUID"))
+	}
+
+	roles, err := s.db.ListUserRoles(ctx, userID)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	for _, role := range roles {
+		response.Roles = append(response.Roles, &pb.Role{
+			Name:        role.Name,
+			Description: role.Description,
+			Permissions: role.Permissions,
+		})
+	}
+	return response, nil
+}
+
+// parseRoleRequest validates the user ID and role name of AssignRole and RevokeRole
+func parseRoleRequest(rawUserID, role string) (uuid.UUID, string, error) {
+	var violations autherr.Violations
+	userID, err := uuid.Parse(rawUserID)
+	if err != nil {
+		violations.Add("user_id", "user_id should be a UUID")
+	}
+	role = strings.TrimSpace(role)
+	if role == "" {
+		violations.Add("role", "role is required")
+	}
+	return userID, role, violations.Err()
+}
+
+// checkRoleAndUser makes sure both sides of a role assignment exist
+func (s *Server) checkRoleAndUser(ctx context.Context, role string, userID uuid.UUID) error {
+	if _, err := s.db.GetRole(ctx, role); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return autherr.RoleNotFound(role).WithCause(err)
+		}
+		return err
+	}
+
+	if _, err := s.db.GetUserByID(ctx, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return autherr.UserNotFound().WithCause(err)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/cmd/authz"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/database/mocks"
+	pb "github.com/imhasandl/auth-service/protos"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func TestAssignRole(t *testing.T) {
+	userID := uuid.New()
+	adminID := uuid.New()
+
+	testCases := []struct {
+		name            string
+		request         *pb.AssignRoleRequest
+		mockSetup       func(*mocks.MockQueries)
+		expectedMessage string
+		errorCode       codes.Code
+		errorReason     autherr.Reason
+	}{
+		{
+			name:    "assigned",
+			request: &pb.AssignRoleRequest{UserId: userID.String(), Role: "admin"},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetRole", mock.Anything, "admin").Return(database.Role{Name: "admin"}, nil)
+				mockDB.On("GetUserByID", mock.Anything, userID).Return(database.User{ID: userID}, nil)
+				mockDB.On("AssignRole", mock.Anything, database.AssignRoleParams{
+					UserID:    userID,
+					Role:      "admin",
+					GrantedBy: uuid.NullUUID{UUID: adminID, Valid: true},
+				}).Return(int64(1), nil)
+			},
+			expectedMessage: "Role assigned",
+		},
+		{
+			name:    "already assigned",
+			request: &pb.AssignRoleRequest{UserId: userID.String(), Role: "admin"},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetRole", mock.Anything, "admin").Return(database.Role{Name: "admin"}, nil)
+				mockDB.On("GetUserByID", mock.Anything, userID).Return(database.User{ID: userID}, nil)
+				mockDB.On("AssignRole", mock.Anything, mock.Anything).Return(int64(0), nil)
+			},
+			expectedMessage: "User already has the role",
+		},
+		{
+			name:    "unknown role",
+			request: &pb.AssignRoleRequest{UserId: userID.String(), Role: "owner"},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetRole", mock.Anything, "owner").Return(database.Role{}, sql.ErrNoRows)
+			},
+			errorCode:   codes.NotFound,
+			errorReason: autherr.ReasonRoleNotFound,
+		},
+		{
+			name:    "unknown user",
+			request: &pb.AssignRoleRequest{UserId: userID.String(), Role: "admin"},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetRole", mock.Anything, "admin").Return(database.Role{Name: "admin"}, nil)
+				mockDB.On("GetUserByID", mock.Anything, userID).Return(database.User{}, sql.ErrNoRows)
+			},
+			errorCode:   codes.NotFound,
+			errorReason: autherr.ReasonUserNotFound,
+		},
+		{
+			name:        "invalid request",
+			request:     &pb.AssignRoleRequest{UserId: "not-a-uuid"},
+			mockSetup:   func(mockDB *mocks.MockQueries) {},
+			errorCode:   codes.InvalidArgument,
+			errorReason: autherr.ReasonInvalidArgument,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB := new(mocks.MockQueries)
+			server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+			ctx := authz.NewContext(context.Background(), authz.Principal{
+				Subject:   adminID.String(),
+				TokenType: auth.TokenTypeAccess,
+			})
+
+			tc.mockSetup(mockDB)
+
+			response, err := server.AssignRole(ctx, tc.request)
+
+			if tc.errorReason != "" {
+				assertReason(t, err, tc.errorCode, tc.errorReason)
+			} else {
+				require.NoError(t, err)
+				assert.True(t, response.Success)
+				assert.Equal(t, tc.expectedMessage, response.Message)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRevokeRole(t *testing.T) {
+	userID := uuid.New()
+
+	testCases := []struct {
+		name            string
+		request         *pb.RevokeRoleRequest
+		mockSetup       func(*mocks.MockQueries)
+		expectedMessage string
+		errorCode       codes.Code
+		errorReason     autherr.Reason
+	}{
+		{
+			name:    "revoked",
+			request: &pb.RevokeRoleRequest{UserId: userID.String(), Role: "admin"},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetRole", mock.Anything, "admin").Return(database.Role{Name: "admin"}, nil)
+				mockDB.On("RevokeRole", mock.Anything, database.RevokeRoleParams{UserID: userID, Role: "admin"}).Return(int64(1), nil)
+			},
+			expectedMessage: "Role revoked",
+		},
+		{
+			name:    "not assigned",
+			request: &pb.RevokeRoleRequest{UserId: userID.String(), Role: "admin"},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetRole", mock.Anything, "admin").Return(database.Role{Name: "admin"}, nil)
+				mockDB.On("RevokeRole", mock.Anything, mock.Anything).Return(int64(0), nil)
+			},
+			expectedMessage: "User did not have the role",
+		},
+		{
+			name:    "unknown role",
+			request: &pb.RevokeRoleRequest{UserId: userID.String(), Role: "owner"},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetRole", mock.Anything, "owner").Return(database.Role{}, sql.ErrNoRows)
+			},
+			errorCode:   codes.NotFound,
+			errorReason: autherr.ReasonRoleNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB := new(mocks.MockQueries)
+			server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+
+			tc.mockSetup(mockDB)
+
+			response, err := server.RevokeRole(context.Background(), tc.request)
+
+			if tc.errorReason != "" {
+				assertReason(t, err, tc.errorCode, tc.errorReason)
+			} else {
+				require.NoError(t, err)
+				assert.True(t, response.Success)
+				assert.Equal(t, tc.expectedMessage, response.Message)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
+
+func TestListRoles(t *testing.T) {
+	userID := uuid.New()
+	mockDB := new(mocks.MockQueries)
+	server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+
+	mockDB.On("ListRoles", mock.Anything).Return([]database.ListRolesRow{
+		{Name: "admin", Description: "Manages users and their roles", Permissions: []string{"roles:read", "roles:write"}},
+		{Name: "support", Permissions: []string{}},
+	}, nil)
+	mockDB.On("ListUserRoles", mock.Anything, userID).Return([]database.ListUserRolesRow{
+		{Name: "admin", Permissions: []string{"roles:read", "roles:write"}},
+	}, nil)
+
+	all, err := server.ListRoles(context.Background(), &pb.ListRolesRequest{})
+	require.NoError(t, err)
+	require.Len(t, all.Roles, 2)
+	assert.Equal(t, "admin", all.Roles[0].Name)
+	assert.Equal(t, []string{"roles:read", "roles:write"}, all.Roles[0].Permissions)
+
+	mine, err := server.ListRoles(context.Background(), &pb.ListRolesRequest{UserId: userID.String()})
+	require.NoError(t, err)
+	require.Len(t, mine.Roles, 1)
+	assert.Equal(t, "admin", mine.Roles[0].Name)
+
+	_, err = server.ListRoles(context.Background(), &pb.ListRolesRequest{UserId: "not-a-uuid"})
+	assertReason(t, err, codes.InvalidArgument, autherr.ReasonInvalidArgument)
+	mockDB.AssertExpectations(t)
+}
+
+func TestRefreshTokenCarriesRoles(t *testing.T) {
+	userID := uuid.New()
+	mockDB := new(mocks.MockQueries)
+	server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+
+	mockDB.On("GetRefreshToken", mock.Anything, "refresh-token").Return(database.RefreshToken{
+		Token:      "refresh-token",
+		UserID:     userID,
+		ExpiryTime: time.Now().Add(time.Hour),
+	}, nil)
+	mockDB.On("GetUserAuthorization", mock.Anything, userID).Return(database.GetUserAuthorizationRow{
+		Roles:       []string{"admin"},
+		Permissions: []string{"roles:read", "roles:write"},
+	}, nil)
+	mockDB.On("DeleteRefreshTokenByToken", mock.Anything, "refresh-token").Return(nil)
+	mockDB.On("RememberRotatedRefreshToken", mock.Anything, mock.Anything).Return(nil)
+	mockDB.On("MoveSessionDeviceTokens", mock.Anything, mock.Anything).Return(nil)
+	mockDB.On("RefreshToken", mock.Anything, mock.Anything).Return(database.RefreshToken{}, nil)
+
+	response, err := server.RefreshToken(context.Background(), &pb.RefreshTokenRequest{RefreshToken: "refresh-token"})
+	require.NoError(t, err)
+
+	claims, err := auth.ValidateAccessClaims(response.AccessToken, "test-secret")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"admin"}, claims.Roles)
+	assert.True(t, claims.HasPermission(PermissionRolesWrite))
+	mockDB.AssertExpectations(t)
+}
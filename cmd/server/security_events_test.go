@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/database/mocks"
+	pb "github.com/imhasandl/auth-service/protos"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func authEvents(userID uuid.UUID, ids ...int64) []database.AuthEvent {
+	details, _ := json.Marshal(map[string]string{"method": loginMethodPassword})
+	events := make([]database.AuthEvent, 0, len(ids))
+	for _, id := range ids {
+		events = append(events, database.AuthEvent{
+			ID:        id,
+			UserID:    uuid.NullUUID{UUID: userID, Valid: true},
+			Type:      EventLoginSucceeded,
+			IpAddress: "203.0.113.7",
+			SessionID: "abc",
+			Details:   details,
+			CreatedAt: time.Now(),
+		})
+	}
+	return events
+}
+
+func TestListSecurityEvents(t *testing.T) {
+	userID := uuid.New()
+
+	t.Run("own events", func(t *testing.T) {
+		mockDB := activeUsers(new(mocks.MockQueries))
+		mockDB.On("ListAuthEvents", mock.Anything, database.ListAuthEventsParams{
+			UserID:   uuid.NullUUID{UUID: userID, Valid: true},
+			Types:    []string{EventLoginSucceeded},
+			PageSize: 3,
+		}).Return(authEvents(userID, 9, 8, 7), nil)
+		mockDB.On("ListAuthEvents", mock.Anything, database.ListAuthEventsParams{
+			UserID:   uuid.NullUUID{UUID: userID, Valid: true},
+			Types:    []string{EventLoginSucceeded},
+			BeforeID: sql.NullInt64{Int64: 8, Valid: true},
+			PageSize: 3,
+		}).Return(authEvents(userID, 7), nil)
+		server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret")
+
+		first, err := server.ListSecurityEvents(withAccessToken(t, userID), &pb.ListSecurityEventsRequest{
+			PageSize: 2,
+			Types:    []string{EventLoginSucceeded},
+		})
+		require.NoError(t, err)
+		require.Len(t, first.Events, 2)
+		assert.Equal(t, int64(9), first.Events[0].Id)
+		assert.Equal(t, userID.String(), first.Events[0].UserId)
+		assert.Equal(t, "203.0.113.7", first.Events[0].IpAddress)
+		assert.Equal(t, map[string]string{"method": loginMethodPassword}, first.Events[0].Details)
+		assert.NotEmpty(t, first.NextPageToken)
+
+		second, err := server.ListSecurityEvents(withAccessToken(t, userID), &pb.ListSecurityEventsRequest{
+			PageSize:  2,
+			PageToken: first.NextPageToken,
+			Types:     []string{EventLoginSucceeded},
+		})
+		require.NoError(t, err)
+		require.Len(t, second.Events, 1)
+		assert.Empty(t, second.NextPageToken)
+	})
+
+	t.Run("other user", func(t *testing.T) {
+		server := NewServer(activeUsers(new(mocks.MockQueries)), "test-secret", "test@example.com", "email-secret")
+
+		_, err := server.ListSecurityEvents(withAccessToken(t, userID), &pb.ListSecurityEventsRequest{UserId: uuid.NewString()})
+		assertReason(t, err, codes.PermissionDenied, autherr.ReasonPermissionDenied)
+	})
+
+	t.Run("not logged in", func(t *testing.T) {
+		server := NewServer(new(mocks.MockQueries), "test-secret", "test@example.com", "email-secret")
+
+		_, err := server.ListSecurityEvents(context.Background(), &pb.ListSecurityEventsRequest{})
+		assertReason(t, err, codes.Unauthenticated, autherr.ReasonAccessTokenInvalid)
+	})
+
+	t.Run("invalid request", func(t *testing.T) {
+		server := NewServer(activeUsers(new(mocks.MockQueries)), "test-secret", "test@example.com", "email-secret")
+
+		_, err := server.ListSecurityEvents(withAccessToken(t, userID), &pb.ListSecurityEventsRequest{
+			PageSize:  500,
+			PageToken: "not a token",
+		})
+		assertReason(t, err, codes.InvalidArgument, autherr.ReasonInvalidArgument)
+	})
+}
+
+func TestAdminListSecurityEvents(t *testing.T) {
+	adminID := uuid.New()
+	userID := uuid.New()
+
+	t.Run("one user", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		mockDB.On("ListAuthEvents", mock.Anything, database.ListAuthEventsParams{
+			UserID:   uuid.NullUUID{UUID: userID, Valid: true},
+			PageSize: defaultEventsPageSize + 1,
+		}).Return(authEvents(userID, 2, 1), nil)
+		details := expectAudit(mockDB, adminID, auditListSecurityEvents, userID)
+		admin := NewServer(mockDB, "test-secret", "test@example.com", "email-secret").Admin()
+
+		response, err := admin.ListSecurityEvents(adminContext(adminID), &pb.ListSecurityEventsRequest{UserId: userID.String()})
+		require.NoError(t, err)
+		assert.Len(t, response.Events, 2)
+		assert.Empty(t, response.NextPageToken)
+		assert.Equal(t, float64(2), (*details)["returned"])
+	})
+
+	t.Run("every user", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		mockDB.On("ListAuthEvents", mock.Anything, database.ListAuthEventsParams{
+			PageSize: defaultEventsPageSize + 1,
+		}).Return(authEvents(userID, 1), nil)
+		expectAudit(mockDB, adminID, auditListSecurityEvents, uuid.Nil)
+		admin := NewServer(mockDB, "test-secret", "test@example.com", "email-secret").Admin()
+
+		response, err := admin.ListSecurityEvents(adminContext(adminID), &pb.ListSecurityEventsRequest{})
+		require.NoError(t, err)
+		assert.Len(t, response.Events, 1)
+	})
+
+	t.Run("invalid user ID", func(t *testing.T) {
+		admin := NewServer(new(mocks.MockQueries), "test-secret", "test@example.com", "email-secret").Admin()
+
+		_, err := admin.ListSecurityEvents(adminContext(adminID), &pb.ListSecurityEventsRequest{UserId: "nope"})
+		assertReason(t, err, codes.InvalidArgument, autherr.ReasonInvalidArgument)
+	})
+}
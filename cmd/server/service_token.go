@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/cmd/helper"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	pb "github.com/imhasandl/auth-service/protos"
+)
+
+// IssueServiceToken authenticates a service client with its secret and issues a short-lived service token.
+// The token is limited to the requested scopes and audiences, which must have been granted to the client.
+func (s *Server) IssueServiceToken(ctx context.Context, req *pb.IssueServiceTokenRequest) (*pb.IssueServiceTokenResponse, error) {
+	var violations autherr.Violations
+	if req.GetClientId() == "" {
+		violations.Add("client_id", "client_id is required")
+	}
+	if req.GetClientSecret() == "" {
+		violations.Add("client_secret", "client_secret is required")
+	}
+	if err := violations.Err(); err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	client, err := s.db.GetServiceClient(ctx, req.GetClientId())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, helper.RespondWithError(ctx, autherr.ServiceClientInvalid().WithCause(err))
+		}
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	if !checkServiceClientSecret(client, req.GetClientSecret(), time.Now().UTC()) {
+		return nil, helper.RespondWithError(ctx, autherr.ServiceClientInvalid())
+	}
+
+	scopes, err := grantedValues(req.GetScopes(), client.Scopes, autherr.ScopeNotAllowed)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	audiences, err := grantedValues(req.GetAudiences(), client.Audiences, autherr.AudienceNotAllowed)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	token, err := auth.MakeServiceToken(client.ID, scopes, audiences, s.tokenSecret, s.serviceTokenTTL)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	return &pb.IssueServiceTokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(s.serviceTokenTTL.Seconds()),
+		Scopes:      scopes,
+		Audiences:   audiences,
+	}, nil
+}
+
+// checkServiceClientSecret accepts the current secret, and the previous one until its grace period ends
+func checkServiceClientSecret(client database.ServiceClient, secret string, now time.Time) bool {
+	if auth.CheckClientSecret(client.SecretHash, secret) {
+		return true
+	}
+	return client.PreviousSecretHash != "" &&
+		client.PreviousSecretExpiresAt.Valid &&
+		now.Before(client.PreviousSecretExpiresAt.Time) &&
+		auth.CheckClientSecret(client.PreviousSecretHash, secret)
+}
+
+// grantedValues checks that every requested value was granted. Requesting nothing grants everything
+func grantedValues(requested, granted []string, notAllowed func(string) *autherr.Error) ([]string, error) {
+	if len(requested) == 0 {
+		return granted, nil
+	}
+
+	values := make([]string, 0, len(requested))
+	for _, value := range requested {
+		if !slices.Contains(granted, value) {
+			return nil, notAllowed(value)
+		}
+		if !slices.Contains(values, value) {
+			values = append(values, value)
+		}
+	}
+	return values, nil
+}
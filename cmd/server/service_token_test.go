@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/database/mocks"
+	pb "github.com/imhasandl/auth-service/protos"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIssueServiceToken(t *testing.T) {
+	const secret = "test-secret"
+
+	client := database.ServiceClient{
+		ID:         "post-service",
+		Name:       "post-service",
+		SecretHash: auth.HashClientSecret("current-secret"),
+		Scopes:     []string{"users:read", "users:write"},
+		Audiences:  []string{"auth-service", "messaging"},
+	}
+	rotated := client
+	rotated.PreviousSecretHash = auth.HashClientSecret("previous-secret")
+	rotated.PreviousSecretExpiresAt = sql.NullTime{Time: time.Now().Add(time.Hour), Valid: true}
+	rotationOver := rotated
+	rotationOver.PreviousSecretExpiresAt = sql.NullTime{Time: time.Now().Add(-time.Hour), Valid: true}
+
+	testCases := []struct {
+		name              string
+		request           *pb.IssueServiceTokenRequest
+		mockSetup         func(*mocks.MockQueries)
+		expectedScopes    []string
+		expectedAudiences []string
+		expectedError     bool
+		errorCode         codes.Code
+		errorReason       autherr.Reason
+	}{
+		{
+			name: "all scopes and audiences by default",
+			request: &pb.IssueServiceTokenRequest{
+				ClientId:     "post-service",
+				ClientSecret: "current-secret",
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetServiceClient", mock.Anything, "post-service").Return(client, nil)
+			},
+			expectedScopes:    []string{"users:read", "users:write"},
+			expectedAudiences: []string{"auth-service", "messaging"},
+		},
+		{
+			name: "narrowed scopes and audiences",
+			request: &pb.IssueServiceTokenRequest{
+				ClientId:     "post-service",
+				ClientSecret: "current-secret",
+				Scopes:       []string{"users:read", "users:read"},
+				Audiences:    []string{"messaging"},
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetServiceClient", mock.Anything, "post-service").Return(client, nil)
+			},
+			expectedScopes:    []string{"users:read"},
+			expectedAudiences: []string{"messaging"},
+		},
+		{
+			name: "previous secret during rotation",
+			request: &pb.IssueServiceTokenRequest{
+				ClientId:     "post-service",
+				ClientSecret: "previous-secret",
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetServiceClient", mock.Anything, "post-service").Return(rotated, nil)
+			},
+			expectedScopes:    []string{"users:read", "users:write"},
+			expectedAudiences: []string{"auth-service", "messaging"},
+		},
+		{
+			name: "previous secret after rotation",
+			request: &pb.IssueServiceTokenRequest{
+				ClientId:     "post-service",
+				ClientSecret: "previous-secret",
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetServiceClient", mock.Anything, "post-service").Return(rotationOver, nil)
+			},
+			expectedError: true,
+			errorCode:     codes.Unauthenticated,
+			errorReason:   autherr.ReasonServiceClientInvalid,
+		},
+		{
+			name: "wrong secret",
+			request: &pb.IssueServiceTokenRequest{
+				ClientId:     "post-service",
+				ClientSecret: "wrong-secret",
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetServiceClient", mock.Anything, "post-service").Return(client, nil)
+			},
+			expectedError: true,
+			errorCode:     codes.Unauthenticated,
+			errorReason:   autherr.ReasonServiceClientInvalid,
+		},
+		{
+			name: "unknown client",
+			request: &pb.IssueServiceTokenRequest{
+				ClientId:     "unknown",
+				ClientSecret: "current-secret",
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetServiceClient", mock.Anything, "unknown").Return(database.ServiceClient{}, sql.ErrNoRows)
+			},
+			expectedError: true,
+			errorCode:     codes.Unauthenticated,
+			errorReason:   autherr.ReasonServiceClientInvalid,
+		},
+		{
+			name: "scope not granted",
+			request: &pb.IssueServiceTokenRequest{
+				ClientId:     "post-service",
+				ClientSecret: "current-secret",
+				Scopes:       []string{"users:delete"},
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetServiceClient", mock.Anything, "post-service").Return(client, nil)
+			},
+			expectedError: true,
+			errorCode:     codes.PermissionDenied,
+			errorReason:   autherr.ReasonScopeNotAllowed,
+		},
+		{
+			name: "audience not granted",
+			request: &pb.IssueServiceTokenRequest{
+				ClientId:     "post-service",
+				ClientSecret: "current-secret",
+				Audiences:    []string{"billing"},
+			},
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetServiceClient", mock.Anything, "post-service").Return(client, nil)
+			},
+			expectedError: true,
+			errorCode:     codes.PermissionDenied,
+			errorReason:   autherr.ReasonAudienceNotAllowed,
+		},
+		{
+			name:          "missing credentials",
+			request:       &pb.IssueServiceTokenRequest{},
+			mockSetup:     func(mockDB *mocks.MockQueries) {},
+			expectedError: true,
+			errorCode:     codes.InvalidArgument,
+			errorReason:   autherr.ReasonInvalidArgument,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB := new(mocks.MockQueries)
+			server := NewServer(mockDB, secret, "test@example.com", "email-secret", WithServiceTokenTTL(5*time.Minute))
+
+			tc.mockSetup(mockDB)
+
+			response, err := server.IssueServiceToken(context.Background(), tc.request)
+
+			if tc.expectedError {
+				assert.Error(t, err)
+				assert.Equal(t, tc.errorCode, status.Code(err))
+				assert.Equal(t, tc.errorReason, autherr.ReasonOf(err))
+				assert.Nil(t, response)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, "Bearer", response.TokenType)
+				assert.Equal(t, int64(300), response.ExpiresIn)
+				assert.Equal(t, tc.expectedScopes, response.Scopes)
+				assert.Equal(t, tc.expectedAudiences, response.Audiences)
+
+				claims, err := auth.ValidateServiceToken(response.AccessToken, secret, tc.expectedAudiences[0])
+				require.NoError(t, err)
+				assert.Equal(t, "post-service", claims.Subject)
+				assert.Equal(t, tc.expectedScopes, claims.Scopes())
+
+				_, err = auth.ValidateJWT(response.AccessToken, secret)
+				assert.Error(t, err)
+			}
+			mockDB.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/outbox"
+	eventsv1 "github.com/imhasandl/auth-service/protos/events/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// enqueueEvent writes an event for other services to the outbox and creates its deliveries to the webhook
+// endpoints. q has to be the transaction that makes the change the event is about, so the event is published
+// exactly when the change is committed
+func enqueueEvent(ctx context.Context, q DBQuerier, eventType string, userID uuid.UUID, payload proto.Message) error {
+	event, err := outbox.NewEvent(eventType, userID, payload)
+	if err != nil {
+		return err
+	}
+	if err := q.CreateOutboxEvent(ctx, event); err != nil {
+		return err
+	}
+
+	body, err := outbox.JSON(event.Payload)
+	if err != nil {
+		return err
+	}
+	return q.CreateWebhookDeliveries(ctx, database.CreateWebhookDeliveriesParams{
+		EventID:   event.EventID,
+		EventType: eventType,
+		Payload:   body,
+	})
+}
+
+// verifyUser marks the user with the canonical address as verified. The user.verified event is only written the
+// first time, verifying an already verified user does nothing
+func verifyUser(ctx context.Context, q DBQuerier, emailCanonical string) error {
+	userID, err := q.VerifyUser(ctx, emailCanonical)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return enqueueEvent(ctx, q, outbox.UserVerified, userID, &eventsv1.UserVerified{UserId: userID.String()})
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/database/mocks"
+	"github.com/imhasandl/auth-service/internal/outbox"
+	eventsv1 "github.com/imhasandl/auth-service/protos/events/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+// outboxEvent matches the outbox row of an event of eventType about userID and decodes its payload into payload
+func outboxEvent(t *testing.T, eventType string, userID uuid.UUID, payload proto.Message) interface{} {
+	return mock.MatchedBy(func(arg database.CreateOutboxEventParams) bool {
+		if arg.EventType != eventType || arg.UserID != userID {
+			return false
+		}
+
+		var envelope eventsv1.Envelope
+		require.NoError(t, proto.Unmarshal(arg.Payload, &envelope))
+		assert.Equal(t, arg.EventID.String(), envelope.GetId())
+		assert.Equal(t, eventType, envelope.GetType())
+		require.NoError(t, envelope.GetPayload().UnmarshalTo(payload))
+		return true
+	})
+}
+
+// expectLoggedOutEverywhere expects the user.logged_out event deleteAllSessions writes for userID
+func expectLoggedOutEverywhere(t *testing.T, mockDB *mocks.MockQueries, userID uuid.UUID) {
+	var event eventsv1.UserLoggedOut
+	mockDB.On("CreateOutboxEvent", mock.Anything, outboxEvent(t, outbox.UserLoggedOut, userID, &event)).
+		Run(func(mock.Arguments) {
+			assert.Equal(t, userID.String(), event.GetUserId())
+			assert.Empty(t, event.GetSessionId())
+		}).
+		Return(nil)
+	mockDB.On("CreateWebhookDeliveries", mock.Anything, mock.MatchedBy(func(arg database.CreateWebhookDeliveriesParams) bool {
+		return arg.EventType == outbox.UserLoggedOut
+	})).Return(nil)
+}
+
+func TestEnqueueEventCreatesWebhookDeliveries(t *testing.T) {
+	userID := uuid.New()
+	mockDB := new(mocks.MockQueries)
+	var outboxRow database.CreateOutboxEventParams
+	mockDB.On("CreateOutboxEvent", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { outboxRow = args.Get(1).(database.CreateOutboxEventParams) }).
+		Return(nil)
+	var deliveries database.CreateWebhookDeliveriesParams
+	mockDB.On("CreateWebhookDeliveries", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { deliveries = args.Get(1).(database.CreateWebhookDeliveriesParams) }).
+		Return(nil)
+
+	err := enqueueEvent(context.Background(), mockDB, outbox.UserLoggedOut, userID, &eventsv1.UserLoggedOut{UserId: userID.String()})
+	require.NoError(t, err)
+
+	assert.Equal(t, outboxRow.EventID, deliveries.EventID)
+	assert.Equal(t, outbox.UserLoggedOut, deliveries.EventType)
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(deliveries.Payload, &body))
+	assert.Equal(t, outboxRow.EventID.String(), body["id"])
+	assert.Equal(t, outbox.UserLoggedOut, body["type"])
+	assert.Equal(t, userID.String(), body["payload"].(map[string]any)["userId"])
+}
+
+func TestVerifyUser(t *testing.T) {
+	userID := uuid.New()
+
+	t.Run("first verification", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		mockDB.On("VerifyUser", mock.Anything, "new@example.com").Return(userID, nil)
+		var event eventsv1.UserVerified
+		mockDB.On("CreateOutboxEvent", mock.Anything, outboxEvent(t, outbox.UserVerified, userID, &event)).Return(nil)
+		mockDB.On("CreateWebhookDeliveries", mock.Anything, mock.Anything).Return(nil)
+
+		require.NoError(t, verifyUser(context.Background(), mockDB, "new@example.com"))
+
+		assert.Equal(t, userID.String(), event.GetUserId())
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("already verified", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		mockDB.On("VerifyUser", mock.Anything, "old@example.com").Return(uuid.Nil, sql.ErrNoRows)
+
+		require.NoError(t, verifyUser(context.Background(), mockDB, "old@example.com"))
+
+		mockDB.AssertNotCalled(t, "CreateOutboxEvent", mock.Anything, mock.Anything)
+	})
+}
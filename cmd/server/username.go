@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/imhasandl/auth-service/cmd/helper"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	pb "github.com/imhasandl/auth-service/protos"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Length limits of usernames
+const (
+	minUsernameLength = 5
+	maxUsernameLength = 30
+)
+
+// usernameChars matches usernames made only of the characters they may contain
+var usernameChars = regexp.MustCompile(`^[A-Za-z0-9_.]+$`)
+
+// defaultReservedUsernames can't be picked by users, because accounts with them could pass for the service or its
+// staff. Dots and underscores are ignored when comparing, so "ad.min" is reserved too
+var defaultReservedUsernames = []string{
+	"abuse", "account", "accounts", "admin", "administrator", "anonymous", "billing", "contact", "deleted",
+	"everyone", "help", "helpdesk", "hostmaster", "login", "logout", "moderator", "noreply", "null", "official",
+	"postmaster", "register", "root", "security", "settings", "signin", "signup", "staff", "support", "system",
+	"undefined", "webmaster",
+}
+
+// reservedUsernameKey is what reserved usernames are compared by
+func reservedUsernameKey(username string) string {
+	return strings.NewReplacer(".", "", "_", "").Replace(strings.ToLower(username))
+}
+
+// usernameViolation returns why username doesn't follow the username policy, or "" when it does.
+// Usernames can't contain "@", so they never look like an email to Login
+func usernameViolation(username string) string {
+	switch {
+	case len(username) < minUsernameLength:
+		return "username should be at least 5 characters long"
+	case len(username) > maxUsernameLength:
+		return "username should be at most 30 characters long"
+	case !usernameChars.MatchString(username):
+		return "username may only contain letters, digits, underscores and dots"
+	case strings.HasPrefix(username, ".") || strings.HasSuffix(username, "."):
+		return "username can't start or end with a dot"
+	default:
+		return ""
+	}
+}
+
+// chosenUsernameViolation is usernameViolation for names users pick themselves, which also can't be reserved
+func (s *Server) chosenUsernameViolation(username string) string {
+	if violation := usernameViolation(username); violation != "" {
+		return violation
+	}
+	if _, reserved := s.reservedUsernames[reservedUsernameKey(username)]; reserved {
+		return "username is reserved"
+	}
+	return ""
+}
+
+// ChangeUsername renames the logged in user. Usernames can be changed once per cooldown, and the old name stays
+// reserved for the user for the hold period so nobody else can take it over right away.
+func (s *Server) ChangeUsername(ctx context.Context, req *pb.ChangeUsernameRequest) (*pb.ChangeUsernameResponse, error) {
+	userID, err := s.authenticatedUser(ctx)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	if violation := s.chosenUsernameViolation(req.GetUsername()); violation != "" {
+		return nil, helper.RespondWithError(ctx, autherr.InvalidArgument("username", violation))
+	}
+
+	user, err := s.db.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	if req.GetUsername() == user.Username {
+		return nil, helper.RespondWithError(ctx, autherr.InvalidArgument("username", "username is the current one"))
+	}
+
+	lastChange, err := s.db.GetLastUsernameChange(ctx, userID)
+	switch {
+	case err == nil:
+		if wait := time.Until(lastChange.Add(s.usernameCooldown)); wait > 0 {
+			return nil, helper.RespondWithError(ctx, autherr.UsernameChangeTooSoon(wait))
+		}
+	case !errors.Is(err, sql.ErrNoRows):
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	now := time.Now()
+	var changed database.User
+	err = s.db.WithTx(ctx, func(q DBQuerier) error {
+		available, err := q.UsernameAvailable(ctx, database.UsernameAvailableParams{
+			Username: req.GetUsername(),
+			UserID:   userID,
+		})
+		if err != nil {
+			return err
+		}
+		if !available {
+			return autherr.UsernameTaken()
+		}
+
+		changed, err = q.SetUsername(ctx, database.SetUsernameParams{ID: userID, Username: req.GetUsername()})
+		if err != nil {
+			if database.IsUniqueViolation(err, "users_username_lower_key") {
+				return autherr.UsernameTaken().WithCause(err)
+			}
+			return err
+		}
+
+		return q.CreateUsernameChange(ctx, database.CreateUsernameChangeParams{
+			UserID:      userID,
+			OldUsername: user.Username,
+			NewUsername: changed.Username,
+			HeldUntil:   now.Add(s.usernameHoldPeriod),
+		})
+	})
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	s.recordEvent(ctx, AuthEvent{
+		Type:    EventUsernameChanged,
+		UserID:  userID,
+		Details: map[string]string{"old_username": user.Username, "new_username": changed.Username},
+	})
+
+	return &pb.ChangeUsernameResponse{
+		User: &pb.User{
+			Id:         changed.ID.String(),
+			CreatedAt:  timestamppb.New(changed.CreatedAt),
+			UpdatedAt:  timestamppb.New(changed.UpdatedAt),
+			Email:      changed.Email,
+			Username:   changed.Username,
+			IsPremium:  changed.IsPremium,
+			IsVerified: changed.IsVerified,
+		},
+		NextChangeAt: timestamppb.New(now.Add(s.usernameCooldown)),
+	}, nil
+}
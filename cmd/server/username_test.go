@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/database/mocks"
+	pb "github.com/imhasandl/auth-service/protos"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+)
+
+func TestChosenUsernameViolation(t *testing.T) {
+	server := NewServer(new(mocks.MockQueries), "test-secret", "test@example.com", "email-secret",
+		WithUsernamePolicy(time.Hour, time.Hour, "acme"))
+
+	testCases := []struct {
+		username string
+		valid    bool
+	}{
+		{username: "alice_1", valid: true},
+		{username: "Alice.Smith", valid: true},
+		{username: "alic"},
+		{username: "a_very_long_username_that_goes_on"},
+		{username: "alice@example.com"},
+		{username: "alice smith"},
+		{username: ".alice"},
+		{username: "alice."},
+		{username: "Admin"},
+		{username: "sup.port"},
+		{username: "a_c_m_e"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.username, func(t *testing.T) {
+			violation := server.chosenUsernameViolation(tc.username)
+			if tc.valid {
+				assert.Empty(t, violation)
+			} else {
+				assert.NotEmpty(t, violation)
+			}
+		})
+	}
+}
+
+func TestChangeUsername(t *testing.T) {
+	userID := uuid.New()
+	user := database.User{ID: userID, Username: "old_name"}
+
+	testCases := []struct {
+		name      string
+		username  string
+		mockSetup func(*mocks.MockQueries)
+		code      codes.Code
+		reason    autherr.Reason
+	}{
+		{
+			name:     "changed",
+			username: "new_name",
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByID", mock.Anything, userID).Return(user, nil)
+				mockDB.On("GetLastUsernameChange", mock.Anything, userID).Return(time.Now().Add(-31*24*time.Hour), nil)
+				mockDB.On("UsernameAvailable", mock.Anything, database.UsernameAvailableParams{Username: "new_name", UserID: userID}).
+					Return(true, nil)
+				mockDB.On("SetUsername", mock.Anything, database.SetUsernameParams{ID: userID, Username: "new_name"}).
+					Return(database.User{ID: userID, Username: "new_name"}, nil)
+				mockDB.On("CreateUsernameChange", mock.Anything, mock.MatchedBy(func(arg database.CreateUsernameChangeParams) bool {
+					return arg.UserID == userID && arg.OldUsername == "old_name" && arg.NewUsername == "new_name" &&
+						time.Until(arg.HeldUntil) > 29*24*time.Hour
+				})).Return(nil)
+			},
+			code: codes.OK,
+		},
+		{
+			name:     "first change",
+			username: "new_name",
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByID", mock.Anything, userID).Return(user, nil)
+				mockDB.On("GetLastUsernameChange", mock.Anything, userID).Return(time.Time{}, sql.ErrNoRows)
+				mockDB.On("UsernameAvailable", mock.Anything, mock.Anything).Return(true, nil)
+				mockDB.On("SetUsername", mock.Anything, mock.Anything).Return(database.User{ID: userID, Username: "new_name"}, nil)
+				mockDB.On("CreateUsernameChange", mock.Anything, mock.Anything).Return(nil)
+			},
+			code: codes.OK,
+		},
+		{
+			name:     "changed recently",
+			username: "new_name",
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByID", mock.Anything, userID).Return(user, nil)
+				mockDB.On("GetLastUsernameChange", mock.Anything, userID).Return(time.Now().Add(-24*time.Hour), nil)
+			},
+			code:   codes.FailedPrecondition,
+			reason: autherr.ReasonUsernameChangeTooSoon,
+		},
+		{
+			name:     "taken or held",
+			username: "Taken_Name",
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByID", mock.Anything, userID).Return(user, nil)
+				mockDB.On("GetLastUsernameChange", mock.Anything, userID).Return(time.Time{}, sql.ErrNoRows)
+				mockDB.On("UsernameAvailable", mock.Anything, mock.Anything).Return(false, nil)
+			},
+			code:   codes.AlreadyExists,
+			reason: autherr.ReasonUsernameTaken,
+		},
+		{
+			name:      "reserved",
+			username:  "support",
+			mockSetup: func(mockDB *mocks.MockQueries) {},
+			code:      codes.InvalidArgument,
+			reason:    autherr.ReasonInvalidArgument,
+		},
+		{
+			name:     "current name",
+			username: "old_name",
+			mockSetup: func(mockDB *mocks.MockQueries) {
+				mockDB.On("GetUserByID", mock.Anything, userID).Return(user, nil)
+			},
+			code:   codes.InvalidArgument,
+			reason: autherr.ReasonInvalidArgument,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB := activeUsers(new(mocks.MockQueries))
+			tc.mockSetup(mockDB)
+			sink := &recordingSink{}
+			server := NewServer(mockDB, "test-secret", "test@example.com", "email-secret", WithAuditSink(sink, false))
+
+			response, err := server.ChangeUsername(withAccessToken(t, userID), &pb.ChangeUsernameRequest{Username: tc.username})
+
+			mockDB.AssertExpectations(t)
+			if tc.code != codes.OK {
+				assertReason(t, err, tc.code, tc.reason)
+				assert.Empty(t, sink.events)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "new_name", response.User.Username)
+			assert.WithinDuration(t, time.Now().Add(30*24*time.Hour), response.NextChangeAt.AsTime(), time.Minute)
+			require.Len(t, sink.events, 1)
+			assert.Equal(t, EventUsernameChanged, sink.events[0].Type)
+			assert.Equal(t, map[string]string{"old_username": "old_name", "new_username": "new_name"}, sink.events[0].Details)
+		})
+	}
+
+	t.Run("not logged in", func(t *testing.T) {
+		server := NewServer(new(mocks.MockQueries), "test-secret", "test@example.com", "email-secret")
+
+		_, err := server.ChangeUsername(context.Background(), &pb.ChangeUsernameRequest{Username: "new_name"})
+		assertReason(t, err, codes.Unauthenticated, autherr.ReasonAccessTokenInvalid)
+	})
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/emailpolicy"
+	"github.com/imhasandl/auth-service/internal/redis"
+)
+
+// maxVerificationAttempts is how many wrong guesses a verification code survives
+const maxVerificationAttempts = 5
+
+// storeVerificationCode saves the hash of code for the given purpose, replacing the previous code of the user.
+// The code expires after the configured TTL
+func (s *Server) storeVerificationCode(ctx context.Context, q DBQuerier, userID uuid.UUID, purpose string, code int32) error {
+	return s.storeCode(ctx, q, userID, purpose, auth.HashVerificationCode(code, s.tokenSecret), "", s.codeTTL)
+}
+
+// storeCode saves codeHash for the given purpose, replacing the previous code of the user.
+// A non empty deviceID binds the code to that device
+func (s *Server) storeCode(ctx context.Context, q DBQuerier, userID uuid.UUID, purpose, codeHash, deviceID string, ttl time.Duration) error {
+	_, err := q.UpsertVerificationCode(ctx, database.UpsertVerificationCodeParams{
+		UserID:     userID,
+		Purpose:    purpose,
+		CodeHash:   codeHash,
+		ExpiresAt:  time.Now().Add(ttl),
+		DeviceHash: auth.HashDeviceID(deviceID, s.tokenSecret),
+	})
+	return err
+}
+
+// sendVerificationCode replaces the email verification code of an unverified user and emails the new one
+func (s *Server) sendVerificationCode(ctx context.Context, user database.User) error {
+	if user.IsVerified {
+		return autherr.EmailAlreadyVerified()
+	}
+
+	code, err := auth.GenerateVerificationCode(s.codeLength)
+	if err != nil {
+		return err
+	}
+
+	if err := s.storeVerificationCode(ctx, s.db, user.ID, database.PurposeEmailVerify, code); err != nil {
+		return err
+	}
+
+	s.cacheVerificationCode(user.Email, code)
+
+	// Skip email sending in test mode
+	if s.email != "test@example.com" {
+		if err := auth.SendVerificationEmail(user.Email, s.email, s.emailSecret, code, s.codeTTL); err != nil {
+			return autherr.EmailDeliveryFailed(err)
+		}
+	}
+	return nil
+}
+
+// cacheVerificationCode caches the hash of an email verification code in Redis with the same TTL as the database copy.
+// The cache is keyed by the canonical address, which VerifyEmail looks it up by
+func (s *Server) cacheVerificationCode(email string, code int32) {
+	err := redis.CacheVerificationCode(emailpolicy.LookupKey(email), auth.HashVerificationCode(code, s.tokenSecret), s.codeTTL)
+	if err != nil {
+		log.Printf("WARNING: Failed to cache verification code in Redis: %v", err)
+	}
+}
+
+// checkVerificationCode validates code against the active code of the user for the given purpose.
+// Wrong guesses are counted, and the code stops being accepted after maxVerificationAttempts.
+// It must not run inside a transaction that is rolled back on failure, or the attempts are lost
+func (s *Server) checkVerificationCode(ctx context.Context, userID uuid.UUID, purpose string, code int32) error {
+	_, err := s.checkCode(ctx, userID, purpose, "", func(codeHash string) bool {
+		return auth.CheckVerificationCode(codeHash, code, s.tokenSecret)
+	})
+	return err
+}
+
+// checkCode validates the active code of the user for the given purpose with matches and returns it.
+// Using the code from another device than the one it is bound to counts as a wrong guess
+func (s *Server) checkCode(ctx context.Context, userID uuid.UUID, purpose, deviceID string, matches func(codeHash string) bool) (database.VerificationCode, error) {
+	stored, err := s.db.GetVerificationCode(ctx, database.GetVerificationCodeParams{
+		UserID:  userID,
+		Purpose: purpose,
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return stored, autherr.VerificationCodeExpired().WithCause(err)
+		}
+		return stored, err
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		return stored, autherr.VerificationCodeExpired()
+	}
+
+	if stored.Attempts >= maxVerificationAttempts {
+		return stored, autherr.VerificationAttemptsExceeded()
+	}
+
+	var failure *autherr.Error
+	switch {
+	case !auth.CheckDeviceID(stored.DeviceHash, deviceID, s.tokenSecret):
+		failure = autherr.DeviceMismatch()
+	case !matches(stored.CodeHash):
+		failure = autherr.VerificationCodeInvalid()
+	default:
+		return stored, nil
+	}
+
+	attempts, err := s.db.IncrementVerificationCodeAttempts(ctx, database.IncrementVerificationCodeAttemptsParams{
+		UserID:  userID,
+		Purpose: purpose,
+	})
+	if err != nil {
+		return stored, err
+	}
+	if attempts >= maxVerificationAttempts {
+		return stored, autherr.VerificationAttemptsExceeded()
+	}
+	return stored, failure
+}
@@ -0,0 +1,322 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/cmd/helper"
+	"github.com/imhasandl/auth-service/internal/autherr"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/outbox"
+	"github.com/imhasandl/auth-service/internal/webhook"
+	pb "github.com/imhasandl/auth-service/protos"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	maxWebhookDescriptionLength = 200
+	defaultDeliveriesPageSize   = 50
+	maxDeliveriesPageSize       = 200
+)
+
+// webhookDeliveryStatuses are the statuses ListWebhookDeliveries can filter on
+var webhookDeliveryStatuses = []string{
+	database.WebhookDeliveryPending, database.WebhookDeliverySucceeded, database.WebhookDeliveryFailed,
+}
+
+// CreateWebhookEndpoint registers an endpoint that receives events as signed JSON. The secret the requests are
+// signed with is only returned here.
+func (s *AdminServer) CreateWebhookEndpoint(ctx context.Context, req *pb.CreateWebhookEndpointRequest) (*pb.CreateWebhookEndpointResponse, error) {
+	actor, err := adminActor(ctx)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	var violations autherr.Violations
+	if err := validateWebhookURL(req.GetUrl()); err != nil {
+		violations.Add("url", err.Error())
+	}
+	if len(req.GetDescription()) > maxWebhookDescriptionLength {
+		violations.Add("description", "description should be at most "+strconv.Itoa(maxWebhookDescriptionLength)+" characters")
+	}
+	eventTypes := []string{}
+	for _, eventType := range req.GetEventTypes() {
+		if !slices.Contains(outbox.EventTypes, eventType) {
+			violations.Add("event_types", "unknown event type "+eventType+", should be one of "+strings.Join(outbox.EventTypes, ", "))
+			continue
+		}
+		if !slices.Contains(eventTypes, eventType) {
+			eventTypes = append(eventTypes, eventType)
+		}
+	}
+	if err := violations.Err(); err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	secret, err := webhook.NewSecret()
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	var endpoint database.WebhookEndpoint
+	err = s.db.WithTx(ctx, func(q DBQuerier) error {
+		endpoint, err = q.CreateWebhookEndpoint(ctx, database.CreateWebhookEndpointParams{
+			ID:          uuid.New(),
+			Url:         req.GetUrl(),
+			Secret:      secret,
+			Description: req.GetDescription(),
+			EventTypes:  eventTypes,
+			CreatedBy:   actor,
+		})
+		if err != nil {
+			return err
+		}
+		return s.audit(ctx, q, actor, auditCreateWebhookEndpoint, uuid.Nil, map[string]any{
+			"endpoint_id": endpoint.ID.String(),
+			"url":         endpoint.Url,
+			"event_types": eventTypes,
+		})
+	})
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	return &pb.CreateWebhookEndpointResponse{
+		Endpoint: webhookEndpoint(endpoint),
+		Secret:   secret,
+	}, nil
+}
+
+// ListWebhookEndpoints lists the registered webhook endpoints, oldest first, including disabled ones.
+func (s *AdminServer) ListWebhookEndpoints(ctx context.Context, _ *pb.ListWebhookEndpointsRequest) (*pb.ListWebhookEndpointsResponse, error) {
+	actor, err := adminActor(ctx)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	endpoints, err := s.db.ListWebhookEndpoints(ctx)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	if err := s.audit(ctx, s.db, actor, auditListWebhookEndpoints, uuid.Nil, nil); err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	response := &pb.ListWebhookEndpointsResponse{}
+	for _, endpoint := range endpoints {
+		response.Endpoints = append(response.Endpoints, webhookEndpoint(endpoint))
+	}
+	return response, nil
+}
+
+// DeleteWebhookEndpoint removes a webhook endpoint together with its delivery log. Pending deliveries are
+// dropped.
+func (s *AdminServer) DeleteWebhookEndpoint(ctx context.Context, req *pb.DeleteWebhookEndpointRequest) (*pb.DeleteWebhookEndpointResponse, error) {
+	actor, endpointID, err := webhookRequest(ctx, req.GetEndpointId())
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	err = s.db.WithTx(ctx, func(q DBQuerier) error {
+		deleted, err := q.DeleteWebhookEndpoint(ctx, endpointID)
+		if err != nil {
+			return err
+		}
+		if deleted == 0 {
+			return autherr.WebhookEndpointNotFound()
+		}
+		return s.audit(ctx, q, actor, auditDeleteWebhookEndpoint, uuid.Nil, map[string]any{
+			"endpoint_id": endpointID.String(),
+		})
+	})
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	return &pb.DeleteWebhookEndpointResponse{
+		Success: true,
+		Message: "Webhook endpoint deleted",
+	}, nil
+}
+
+// ListWebhookDeliveries pages through the delivery log of a webhook endpoint, newest first.
+func (s *AdminServer) ListWebhookDeliveries(ctx context.Context, req *pb.ListWebhookDeliveriesRequest) (*pb.ListWebhookDeliveriesResponse, error) {
+	actor, endpointID, err := webhookRequest(ctx, req.GetEndpointId())
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	pageSize := req.GetPageSize()
+	var violations autherr.Violations
+	if pageSize < 0 || pageSize > maxDeliveriesPageSize {
+		violations.Add("page_size", "page_size should be between 0 and "+strconv.Itoa(maxDeliveriesPageSize))
+	}
+	if pageSize == 0 {
+		pageSize = defaultDeliveriesPageSize
+	}
+	params := database.ListWebhookDeliveriesParams{
+		EndpointID: endpointID,
+		PageSize:   pageSize + 1,
+	}
+	if req.GetStatus() != "" {
+		if !slices.Contains(webhookDeliveryStatuses, req.GetStatus()) {
+			violations.Add("status", "status should be one of "+strings.Join(webhookDeliveryStatuses, ", "))
+		}
+		params.Status = sql.NullString{String: req.GetStatus(), Valid: true}
+	}
+	if req.GetPageToken() != "" {
+		beforeID, err := decodeEventsPageToken(req.GetPageToken())
+		if err != nil {
+			violations.Add("page_token", "page_token is invalid")
+		}
+		params.BeforeID = sql.NullInt64{Int64: beforeID, Valid: err == nil}
+	}
+	if err := violations.Err(); err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	deliveries, err := s.db.ListWebhookDeliveries(ctx, params)
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	response := &pb.ListWebhookDeliveriesResponse{}
+	if len(deliveries) > int(pageSize) {
+		deliveries = deliveries[:pageSize]
+		response.NextPageToken = encodeEventsPageToken(deliveries[len(deliveries)-1].ID)
+	}
+	for _, delivery := range deliveries {
+		response.Deliveries = append(response.Deliveries, webhookDelivery(delivery))
+	}
+
+	err = s.audit(ctx, s.db, actor, auditListWebhookDeliveries, uuid.Nil, map[string]any{
+		"endpoint_id": endpointID.String(),
+		"status":      req.GetStatus(),
+		"page_token":  req.GetPageToken(),
+		"returned":    len(response.Deliveries),
+	})
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+	return response, nil
+}
+
+// ReplayWebhookDeliveries sends failed deliveries of a webhook endpoint again, all of them or a single one. The
+// endpoint is enabled again if it was disabled for failing too often.
+func (s *AdminServer) ReplayWebhookDeliveries(ctx context.Context, req *pb.ReplayWebhookDeliveriesRequest) (*pb.ReplayWebhookDeliveriesResponse, error) {
+	actor, endpointID, err := webhookRequest(ctx, req.GetEndpointId())
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	var replayed int64
+	err = s.db.WithTx(ctx, func(q DBQuerier) error {
+		enabled, err := q.EnableWebhookEndpoint(ctx, endpointID)
+		if err != nil {
+			return err
+		}
+		if enabled == 0 {
+			return autherr.WebhookEndpointNotFound()
+		}
+		replayed, err = q.ReplayWebhookDeliveries(ctx, database.ReplayWebhookDeliveriesParams{
+			EndpointID: endpointID,
+			DeliveryID: sql.NullInt64{Int64: req.GetDeliveryId(), Valid: req.DeliveryId != nil},
+		})
+		if err != nil {
+			return err
+		}
+		return s.audit(ctx, q, actor, auditReplayWebhookDeliveries, uuid.Nil, map[string]any{
+			"endpoint_id": endpointID.String(),
+			"delivery_id": req.DeliveryId,
+			"replayed":    replayed,
+		})
+	})
+	if err != nil {
+		return nil, helper.RespondWithError(ctx, err)
+	}
+
+	return &pb.ReplayWebhookDeliveriesResponse{Replayed: replayed}, nil
+}
+
+// webhookRequest returns the caller and the endpoint a webhook admin request is about
+func webhookRequest(ctx context.Context, rawEndpointID string) (string, uuid.UUID, error) {
+	actor, err := adminActor(ctx)
+	if err != nil {
+		return "", uuid.Nil, err
+	}
+	endpointID, err := uuid.Parse(rawEndpointID)
+	if err != nil {
+		return "", uuid.Nil, autherr.InvalidArgument("endpoint_id", "endpoint_id should be a UUID")
+	}
+	return actor, endpointID, nil
+}
+
+// validateWebhookURL makes sure events only leave the service encrypted. Plain http is allowed for loopback
+// hosts, so receivers can be tried out locally
+func validateWebhookURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || !u.IsAbs() {
+		return errors.New("url should be an absolute URL")
+	}
+	if u.User != nil {
+		return errors.New("url should not contain credentials")
+	}
+	switch u.Scheme {
+	case "https":
+		return nil
+	case "http":
+		host := u.Hostname()
+		if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+			return nil
+		}
+	}
+	return errors.New("url should use https")
+}
+
+func webhookEndpoint(endpoint database.WebhookEndpoint) *pb.WebhookEndpoint {
+	result := &pb.WebhookEndpoint{
+		Id:                  endpoint.ID.String(),
+		Url:                 endpoint.Url,
+		Description:         endpoint.Description,
+		EventTypes:          endpoint.EventTypes,
+		CreatedBy:           endpoint.CreatedBy,
+		CreatedAt:           timestamppb.New(endpoint.CreatedAt),
+		ConsecutiveFailures: endpoint.ConsecutiveFailures,
+		DisabledReason:      endpoint.DisabledReason,
+	}
+	if endpoint.DisabledAt.Valid {
+		result.DisabledAt = timestamppb.New(endpoint.DisabledAt.Time)
+	}
+	return result
+}
+
+func webhookDelivery(delivery database.WebhookDelivery) *pb.WebhookDelivery {
+	result := &pb.WebhookDelivery{
+		Id:             delivery.ID,
+		EventId:        delivery.EventID.String(),
+		EventType:      delivery.EventType,
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		ResponseStatus: delivery.ResponseStatus,
+		LastError:      delivery.LastError,
+		CreatedAt:      timestamppb.New(delivery.CreatedAt),
+	}
+	if delivery.LastAttemptAt.Valid {
+		result.LastAttemptAt = timestamppb.New(delivery.LastAttemptAt.Time)
+	}
+	if delivery.Status == database.WebhookDeliveryPending {
+		result.NextAttemptAt = timestamppb.New(delivery.NextAttemptAt)
+	}
+	if delivery.DeliveredAt.Valid {
+		result.DeliveredAt = timestamppb.New(delivery.DeliveredAt.Time)
+	}
+	return result
+}
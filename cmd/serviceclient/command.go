@@ -0,0 +1,210 @@
+// Package serviceclient implements the service-client subcommand, which manages the clients allowed to get
+// service tokens with IssueServiceToken
+package serviceclient
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/internal/database"
+)
+
+// Usage describes the service-client subcommand
+const Usage = `usage: auth-service service-client <command> [flags]
+
+commands:
+  create -id CLIENT_ID -audiences SERVICE,... [-scopes SCOPE,...] [-name NAME]
+  rotate -id CLIENT_ID [-grace 24h]
+  list
+  delete -id CLIENT_ID`
+
+var (
+	clientIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,62}$`)
+	valuePattern    = regexp.MustCompile(`^[A-Za-z0-9._:/-]+$`)
+)
+
+// Run executes the service-client subcommand with args and writes its output to out
+func Run(ctx context.Context, db database.DBQuerier, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(Usage)
+	}
+
+	switch args[0] {
+	case "create":
+		return create(ctx, db, args[1:], out)
+	case "rotate":
+		return rotate(ctx, db, args[1:], out)
+	case "list":
+		return list(ctx, db, out)
+	case "delete":
+		return remove(ctx, db, args[1:], out)
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], Usage)
+	}
+}
+
+func create(ctx context.Context, db database.DBQuerier, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("create", flag.ContinueOnError)
+	flags.SetOutput(out)
+	id := flags.String("id", "", "client ID, used as the subject of its tokens, like post-service")
+	name := flags.String("name", "", "human readable name, defaults to the ID")
+	scopes := flags.String("scopes", "", "comma separated scopes the client may request")
+	audiences := flags.String("audiences", "", "comma separated services the client may call")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if !clientIDPattern.MatchString(*id) {
+		return errors.New("-id should be lowercase letters, digits, '.', '_' or '-'")
+	}
+	if *name == "" {
+		*name = *id
+	}
+
+	clientScopes, err := parseList("-scopes", *scopes)
+	if err != nil {
+		return err
+	}
+	clientAudiences, err := parseList("-audiences", *audiences)
+	if err != nil {
+		return err
+	}
+	if len(clientAudiences) == 0 {
+		return errors.New("-audiences is required")
+	}
+
+	secret, err := auth.MakeClientSecret()
+	if err != nil {
+		return err
+	}
+
+	client, err := db.CreateServiceClient(ctx, database.CreateServiceClientParams{
+		ID:         *id,
+		Name:       *name,
+		SecretHash: auth.HashClientSecret(secret),
+		Scopes:     clientScopes,
+		Audiences:  clientAudiences,
+	})
+	if err != nil {
+		return err
+	}
+
+	printCredentials(out, client.ID, secret)
+	return nil
+}
+
+func rotate(ctx context.Context, db database.DBQuerier, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("rotate", flag.ContinueOnError)
+	flags.SetOutput(out)
+	id := flags.String("id", "", "client ID")
+	grace := flags.Duration("grace", 24*time.Hour, "how long the old secret keeps working, 0 revokes it right away")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *id == "" {
+		return errors.New("-id is required")
+	}
+	if *grace < 0 {
+		return errors.New("-grace can't be negative")
+	}
+
+	secret, err := auth.MakeClientSecret()
+	if err != nil {
+		return err
+	}
+
+	params := database.RotateServiceClientSecretParams{
+		ID:         *id,
+		SecretHash: auth.HashClientSecret(secret),
+	}
+	if *grace > 0 {
+		params.PreviousSecretExpiresAt = sql.NullTime{Time: time.Now().UTC().Add(*grace), Valid: true}
+	}
+
+	client, err := db.RotateServiceClientSecret(ctx, params)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("client %s not found", *id)
+		}
+		return err
+	}
+
+	printCredentials(out, client.ID, secret)
+	if client.PreviousSecretExpiresAt.Valid {
+		fmt.Fprintf(out, "The previous secret works until %s.\n", client.PreviousSecretExpiresAt.Time.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func list(ctx context.Context, db database.DBQuerier, out io.Writer) error {
+	clients, err := db.ListServiceClients(ctx)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CLIENT ID\tNAME\tSCOPES\tAUDIENCES\tPREVIOUS SECRET UNTIL")
+	for _, client := range clients {
+		previousUntil := "-"
+		if client.PreviousSecretExpiresAt.Valid && client.PreviousSecretExpiresAt.Time.After(time.Now().UTC()) {
+			previousUntil = client.PreviousSecretExpiresAt.Time.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", client.ID, client.Name,
+			strings.Join(client.Scopes, ","), strings.Join(client.Audiences, ","), previousUntil)
+	}
+	return tw.Flush()
+}
+
+func remove(ctx context.Context, db database.DBQuerier, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("delete", flag.ContinueOnError)
+	flags.SetOutput(out)
+	id := flags.String("id", "", "client ID")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *id == "" {
+		return errors.New("-id is required")
+	}
+
+	deleted, err := db.DeleteServiceClient(ctx, *id)
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return fmt.Errorf("client %s not found", *id)
+	}
+
+	fmt.Fprintf(out, "deleted client %s\n", *id)
+	return nil
+}
+
+func printCredentials(out io.Writer, clientID, secret string) {
+	fmt.Fprintf(out, "client_id:     %s\n", clientID)
+	fmt.Fprintf(out, "client_secret: %s\n", secret)
+	fmt.Fprintln(out, "The secret is not stored and can't be shown again.")
+}
+
+// parseList splits a comma separated flag value, rejecting values tokens can't carry
+func parseList(flagName, value string) ([]string, error) {
+	items := []string{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !valuePattern.MatchString(item) {
+			return nil, fmt.Errorf("%s: invalid value %q", flagName, item)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
@@ -0,0 +1,117 @@
+package serviceclient
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/database/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+var secretLine = regexp.MustCompile(`client_secret: (\S+)`)
+
+func TestCreate(t *testing.T) {
+	mockDB := new(mocks.MockQueries)
+	var created database.CreateServiceClientParams
+	mockDB.On("CreateServiceClient", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		created = args.Get(1).(database.CreateServiceClientParams)
+	}).Return(database.ServiceClient{ID: "post-service"}, nil)
+
+	var out bytes.Buffer
+	err := Run(context.Background(), mockDB, []string{"create", "-id", "post-service",
+		"-scopes", "users:read, users:write", "-audiences", "auth-service,messaging"}, &out)
+	require.NoError(t, err)
+
+	secret := secretLine.FindStringSubmatch(out.String())
+	require.Len(t, secret, 2)
+	assert.True(t, auth.CheckClientSecret(created.SecretHash, secret[1]))
+	assert.Equal(t, "post-service", created.ID)
+	assert.Equal(t, "post-service", created.Name)
+	assert.Equal(t, []string{"users:read", "users:write"}, created.Scopes)
+	assert.Equal(t, []string{"auth-service", "messaging"}, created.Audiences)
+}
+
+func TestCreateInvalidArguments(t *testing.T) {
+	testCases := []struct {
+		name string
+		args []string
+	}{
+		{name: "missing id", args: []string{"create", "-audiences", "messaging"}},
+		{name: "invalid id", args: []string{"create", "-id", "Post Service", "-audiences", "messaging"}},
+		{name: "missing audiences", args: []string{"create", "-id", "post-service"}},
+		{name: "scope with spaces", args: []string{"create", "-id", "post-service", "-audiences", "messaging", "-scopes", "read all"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB := new(mocks.MockQueries)
+			var out bytes.Buffer
+
+			assert.Error(t, Run(context.Background(), mockDB, tc.args, &out))
+			mockDB.AssertNotCalled(t, "CreateServiceClient", mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestRotate(t *testing.T) {
+	t.Run("keeps the previous secret for the grace period", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		var rotated database.RotateServiceClientSecretParams
+		mockDB.On("RotateServiceClientSecret", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+			rotated = args.Get(1).(database.RotateServiceClientSecretParams)
+		}).Return(database.ServiceClient{
+			ID:                      "post-service",
+			PreviousSecretExpiresAt: sql.NullTime{Time: time.Now().Add(time.Hour), Valid: true},
+		}, nil)
+
+		var out bytes.Buffer
+		err := Run(context.Background(), mockDB, []string{"rotate", "-id", "post-service", "-grace", "1h"}, &out)
+		require.NoError(t, err)
+
+		secret := secretLine.FindStringSubmatch(out.String())
+		require.Len(t, secret, 2)
+		assert.True(t, auth.CheckClientSecret(rotated.SecretHash, secret[1]))
+		assert.True(t, rotated.PreviousSecretExpiresAt.Valid)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), rotated.PreviousSecretExpiresAt.Time, time.Minute)
+		assert.Contains(t, out.String(), "previous secret works until")
+	})
+
+	t.Run("revokes the previous secret without grace period", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		mockDB.On("RotateServiceClientSecret", mock.Anything, mock.MatchedBy(func(arg database.RotateServiceClientSecretParams) bool {
+			return arg.ID == "post-service" && !arg.PreviousSecretExpiresAt.Valid
+		})).Return(database.ServiceClient{ID: "post-service"}, nil)
+
+		var out bytes.Buffer
+		err := Run(context.Background(), mockDB, []string{"rotate", "-id", "post-service", "-grace", "0"}, &out)
+		require.NoError(t, err)
+		assert.NotContains(t, out.String(), "previous secret")
+	})
+
+	t.Run("unknown client", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		mockDB.On("RotateServiceClientSecret", mock.Anything, mock.Anything).Return(database.ServiceClient{}, sql.ErrNoRows)
+
+		var out bytes.Buffer
+		err := Run(context.Background(), mockDB, []string{"rotate", "-id", "missing"}, &out)
+		assert.ErrorContains(t, err, "not found")
+	})
+}
+
+func TestDelete(t *testing.T) {
+	mockDB := new(mocks.MockQueries)
+	mockDB.On("DeleteServiceClient", mock.Anything, "post-service").Return(int64(1), nil)
+	mockDB.On("DeleteServiceClient", mock.Anything, "missing").Return(int64(0), nil)
+
+	var out bytes.Buffer
+	require.NoError(t, Run(context.Background(), mockDB, []string{"delete", "-id", "post-service"}, &out))
+	assert.ErrorContains(t, Run(context.Background(), mockDB, []string{"delete", "-id", "missing"}, &out), "not found")
+}
@@ -0,0 +1,529 @@
+package autherr
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Domain is reported in the ErrorInfo detail of every error returned by the service
+const Domain = "auth-service"
+
+// Reason is a stable, machine readable identifier of a failure. Clients should
+// branch on the reason instead of parsing error messages
+type Reason string
+
+// Reasons returned by the auth service
+const (
+	ReasonInvalidArgument              Reason = "INVALID_ARGUMENT"
+	ReasonInvalidCredentials           Reason = "INVALID_CREDENTIALS"
+	ReasonUserNotFound                 Reason = "USER_NOT_FOUND"
+	ReasonEmailTaken                   Reason = "EMAIL_TAKEN"
+	ReasonEmailAlreadyVerified         Reason = "EMAIL_ALREADY_VERIFIED"
+	ReasonUsernameTaken                Reason = "USERNAME_TAKEN"
+	ReasonUsernameChangeTooSoon        Reason = "USERNAME_CHANGE_TOO_SOON"
+	ReasonVerificationCodeInvalid      Reason = "VERIFICATION_CODE_INVALID"
+	ReasonVerificationCodeExpired      Reason = "VERIFICATION_CODE_EXPIRED"
+	ReasonVerificationAttemptsExceeded Reason = "VERIFICATION_ATTEMPTS_EXCEEDED"
+	ReasonLoginLinkInvalid             Reason = "LOGIN_LINK_INVALID"
+	ReasonLoginLinkExpired             Reason = "LOGIN_LINK_EXPIRED"
+	ReasonLoginLinksDisabled           Reason = "LOGIN_LINKS_DISABLED"
+	ReasonDeviceMismatch               Reason = "DEVICE_MISMATCH"
+	ReasonRateLimited                  Reason = "RATE_LIMITED"
+	ReasonExternalLoginStateInvalid    Reason = "EXTERNAL_LOGIN_STATE_INVALID"
+	ReasonExternalLoginFailed          Reason = "EXTERNAL_LOGIN_FAILED"
+	ReasonExternalEmailUnverified      Reason = "EXTERNAL_EMAIL_UNVERIFIED"
+	ReasonExternalAccountConflict      Reason = "EXTERNAL_ACCOUNT_CONFLICT"
+	ReasonDeviceFlowDisabled           Reason = "DEVICE_FLOW_DISABLED"
+	ReasonUserCodeInvalid              Reason = "USER_CODE_INVALID"
+	ReasonAuthorizationPending         Reason = "AUTHORIZATION_PENDING"
+	ReasonSlowDown                     Reason = "SLOW_DOWN"
+	ReasonDeviceAccessDenied           Reason = "DEVICE_ACCESS_DENIED"
+	ReasonDeviceCodeExpired            Reason = "DEVICE_CODE_EXPIRED"
+	ReasonServiceClientInvalid         Reason = "SERVICE_CLIENT_INVALID"
+	ReasonScopeNotAllowed              Reason = "SCOPE_NOT_ALLOWED"
+	ReasonAudienceNotAllowed           Reason = "AUDIENCE_NOT_ALLOWED"
+	ReasonAPIKeyNameTaken              Reason = "API_KEY_NAME_TAKEN"
+	ReasonAPIKeyNotFound               Reason = "API_KEY_NOT_FOUND"
+	ReasonDeviceTokenNotFound          Reason = "DEVICE_TOKEN_NOT_FOUND"
+	ReasonFollowNotFound               Reason = "FOLLOW_NOT_FOUND"
+	ReasonFollowRequestNotFound        Reason = "FOLLOW_REQUEST_NOT_FOUND"
+	ReasonAccountPrivate               Reason = "ACCOUNT_PRIVATE"
+	ReasonRoleNotFound                 Reason = "ROLE_NOT_FOUND"
+	ReasonDataExportsDisabled          Reason = "DATA_EXPORTS_DISABLED"
+	ReasonDataExportNotFound           Reason = "DATA_EXPORT_NOT_FOUND"
+	ReasonWebhookEndpointNotFound      Reason = "WEBHOOK_ENDPOINT_NOT_FOUND"
+	ReasonPlanNotFound                 Reason = "PLAN_NOT_FOUND"
+	ReasonEntitlementNotFound          Reason = "ENTITLEMENT_NOT_FOUND"
+	ReasonPermissionDenied             Reason = "PERMISSION_DENIED"
+	ReasonAccountSuspended             Reason = "ACCOUNT_SUSPENDED"
+	ReasonAccountBanned                Reason = "ACCOUNT_BANNED"
+	ReasonAccountDeleted               Reason = "ACCOUNT_DELETED"
+	ReasonSignInReportInvalid          Reason = "SIGN_IN_REPORT_INVALID"
+	ReasonPasswordResetInvalid         Reason = "PASSWORD_RESET_INVALID"
+	ReasonEmailChangeUndoInvalid       Reason = "EMAIL_CHANGE_UNDO_INVALID"
+	ReasonAccountDeletionCancelInvalid Reason = "ACCOUNT_DELETION_CANCEL_INVALID"
+	ReasonAccessTokenInvalid           Reason = "ACCESS_TOKEN_INVALID"
+	ReasonRefreshTokenInvalid          Reason = "REFRESH_TOKEN_INVALID"
+	ReasonRefreshTokenExpired          Reason = "REFRESH_TOKEN_EXPIRED"
+	ReasonEmailDeliveryFailed          Reason = "EMAIL_DELIVERY_FAILED"
+	ReasonUnavailable                  Reason = "UNAVAILABLE"
+	ReasonInternal                     Reason = "INTERNAL"
+)
+
+// FieldViolation describes a single invalid field of a request
+type FieldViolation struct {
+	Field       string
+	Description string
+}
+
+// Error is a domain error carrying everything needed to build a rich gRPC status.
+// Cause is logged by the service but never sent to clients
+type Error struct {
+	Code       codes.Code
+	Reason     Reason
+	Message    string
+	Metadata   map[string]string
+	Violations []FieldViolation
+	RetryAfter time.Duration
+	Cause      error
+}
+
+// New creates a domain error with the given gRPC code, reason and client facing message
+func New(code codes.Code, reason Reason, msg string) *Error {
+	return &Error{Code: code, Reason: reason, Message: msg}
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Reason, e.Message, e.Cause)
+	}
+	return fmt.Sprintf("%s: %s", e.Reason, e.Message)
+}
+
+// Unwrap returns the underlying cause of the error
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// Is reports whether target is a domain error with the same reason
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Reason == e.Reason
+}
+
+// WithCause attaches the underlying error that caused the failure
+func (e *Error) WithCause(err error) *Error {
+	e.Cause = err
+	return e
+}
+
+// WithField adds a field violation, which is reported as a BadRequest detail
+func (e *Error) WithField(field, description string) *Error {
+	e.Violations = append(e.Violations, FieldViolation{Field: field, Description: description})
+	return e
+}
+
+// WithRetryAfter tells the client how long to wait before retrying, reported as a RetryInfo detail
+func (e *Error) WithRetryAfter(d time.Duration) *Error {
+	e.RetryAfter = d
+	return e
+}
+
+// WithMetadata adds a key/value pair to the ErrorInfo metadata
+func (e *Error) WithMetadata(key, value string) *Error {
+	if e.Metadata == nil {
+		e.Metadata = make(map[string]string)
+	}
+	e.Metadata[key] = value
+	return e
+}
+
+// GRPCStatus converts the error to a gRPC status with ErrorInfo, BadRequest and RetryInfo details.
+// It makes *Error usable with status.FromError and status.Code directly
+func (e *Error) GRPCStatus() *status.Status {
+	st := status.New(e.Code, e.Message)
+
+	details := []protoadapt.MessageV1{
+		&errdetails.ErrorInfo{
+			Reason:   string(e.Reason),
+			Domain:   Domain,
+			Metadata: e.Metadata,
+		},
+	}
+
+	if len(e.Violations) > 0 {
+		badRequest := &errdetails.BadRequest{}
+		for _, v := range e.Violations {
+			badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       v.Field,
+				Description: v.Description,
+			})
+		}
+		details = append(details, badRequest)
+	}
+
+	if e.RetryAfter > 0 {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(e.RetryAfter)})
+	}
+
+	withDetails, err := st.WithDetails(details...)
+	if err != nil {
+		return st
+	}
+	return withDetails
+}
+
+// From converts any error to a domain error. Errors that are not domain errors
+// become internal errors with the original error kept as the cause
+func From(err error) *Error {
+	if err == nil {
+		return nil
+	}
+
+	var domainErr *Error
+	if errors.As(err, &domainErr) {
+		return domainErr
+	}
+
+	return Internal(err)
+}
+
+// ReasonOf extracts the ErrorInfo reason from a gRPC status error
+func ReasonOf(err error) Reason {
+	st, ok := status.FromError(err)
+	if !ok {
+		return ""
+	}
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return Reason(info.GetReason())
+		}
+	}
+	return ""
+}
+
+// InvalidArgument creates an error for a single invalid request field
+func InvalidArgument(field, description string) *Error {
+	return New(codes.InvalidArgument, ReasonInvalidArgument, "request contains invalid fields").
+		WithField(field, description)
+}
+
+// InvalidCredentials is the uniform response for a failed login. It must not reveal
+// whether the identifier or the password was wrong
+func InvalidCredentials() *Error {
+	return New(codes.Unauthenticated, ReasonInvalidCredentials, "invalid credentials")
+}
+
+// UserNotFound is returned when the requested user does not exist
+func UserNotFound() *Error {
+	return New(codes.NotFound, ReasonUserNotFound, "user not found")
+}
+
+// EmailTaken is returned when registering with an email that already has an account
+func EmailTaken() *Error {
+	return New(codes.AlreadyExists, ReasonEmailTaken, "email is already registered").
+		WithField("email", "email is already registered")
+}
+
+// UsernameTaken is returned when a username is used by another account, regardless of case, or is held after
+// another account changed away from it
+func UsernameTaken() *Error {
+	return New(codes.AlreadyExists, ReasonUsernameTaken, "username is already taken").
+		WithField("username", "username is already taken")
+}
+
+// UsernameChangeTooSoon is returned when a user changes their username again before the cooldown ended
+func UsernameChangeTooSoon(retryAfter time.Duration) *Error {
+	return New(codes.FailedPrecondition, ReasonUsernameChangeTooSoon, "username was changed recently, try again later").
+		WithRetryAfter(retryAfter)
+}
+
+// EmailAlreadyVerified is returned when verifying an already verified email
+func EmailAlreadyVerified() *Error {
+	return New(codes.FailedPrecondition, ReasonEmailAlreadyVerified, "email already verified")
+}
+
+// VerificationCodeInvalid is returned when the verification code does not match
+func VerificationCodeInvalid() *Error {
+	return New(codes.InvalidArgument, ReasonVerificationCodeInvalid, "invalid verification code").
+		WithField("verification_code", "code does not match")
+}
+
+// VerificationCodeExpired is returned when the verification code is no longer valid
+func VerificationCodeExpired() *Error {
+	return New(codes.FailedPrecondition, ReasonVerificationCodeExpired, "verification code expired")
+}
+
+// VerificationAttemptsExceeded is returned when a code was guessed wrong too many times.
+// The code is no longer accepted and a new one has to be requested
+func VerificationAttemptsExceeded() *Error {
+	return New(codes.ResourceExhausted, ReasonVerificationAttemptsExceeded, "too many invalid verification attempts, request a new code")
+}
+
+// LoginLinkInvalid is returned when a login link is forged, was already used or was replaced by a newer link
+func LoginLinkInvalid() *Error {
+	return New(codes.Unauthenticated, ReasonLoginLinkInvalid, "invalid login link")
+}
+
+// LoginLinkExpired is returned when a login link is past its expiry time
+func LoginLinkExpired() *Error {
+	return New(codes.Unauthenticated, ReasonLoginLinkExpired, "login link expired, request a new one")
+}
+
+// LoginLinksDisabled is returned when login links are requested but no link URL is configured
+func LoginLinksDisabled() *Error {
+	return New(codes.FailedPrecondition, ReasonLoginLinksDisabled, "login links are not enabled, request a login code instead")
+}
+
+// DeviceMismatch is returned when a login code or link is used on a different device than the one that requested it
+func DeviceMismatch() *Error {
+	return New(codes.PermissionDenied, ReasonDeviceMismatch, "login must be completed on the device that requested it")
+}
+
+// RateLimited is returned when a client makes too many requests. retryAfter tells it when the limit resets
+func RateLimited(retryAfter time.Duration) *Error {
+	return New(codes.ResourceExhausted, ReasonRateLimited, "too many requests, try again later").
+		WithRetryAfter(retryAfter)
+}
+
+// ExternalLoginStateInvalid is returned when an external login is completed with an unknown, expired or reused state
+func ExternalLoginStateInvalid() *Error {
+	return New(codes.Unauthenticated, ReasonExternalLoginStateInvalid, "external login expired or was already completed, start again")
+}
+
+// ExternalLoginFailed is returned when the provider rejects the authorization code or returns an invalid ID token
+func ExternalLoginFailed(err error) *Error {
+	return New(codes.Unauthenticated, ReasonExternalLoginFailed, "external login failed").WithCause(err)
+}
+
+// ExternalEmailUnverified is returned when a provider account without a verified email is used to sign up
+func ExternalEmailUnverified() *Error {
+	return New(codes.FailedPrecondition, ReasonExternalEmailUnverified, "the provider account has no verified email address")
+}
+
+// ExternalAccountConflict is returned when the email of a provider account belongs to an unverified local account.
+// Linking it could hand over an account registered by someone else, so the local email has to be verified first
+func ExternalAccountConflict() *Error {
+	return New(codes.FailedPrecondition, ReasonExternalAccountConflict,
+		"an account with this email exists, verify its email before logging in with this provider")
+}
+
+// DeviceFlowDisabled is returned when device authorization is requested but no verification URL is configured
+func DeviceFlowDisabled() *Error {
+	return New(codes.FailedPrecondition, ReasonDeviceFlowDisabled, "device authorization is not enabled")
+}
+
+// UserCodeInvalid is returned when a user approves a device with an unknown, expired or already used user code
+func UserCodeInvalid() *Error {
+	return New(codes.InvalidArgument, ReasonUserCodeInvalid, "the code is invalid or expired").
+		WithField("user_code", "unknown or expired code")
+}
+
+// AuthorizationPending is returned to a polling device until the user approved or denied it
+func AuthorizationPending() *Error {
+	return New(codes.FailedPrecondition, ReasonAuthorizationPending, "the user has not approved the device yet")
+}
+
+// SlowDown is returned to a device polling more often than the interval it was given
+func SlowDown(retryAfter time.Duration) *Error {
+	return New(codes.ResourceExhausted, ReasonSlowDown, "polling too often, slow down").WithRetryAfter(retryAfter)
+}
+
+// DeviceAccessDenied is returned to a polling device when the user denied it
+func DeviceAccessDenied() *Error {
+	return New(codes.PermissionDenied, ReasonDeviceAccessDenied, "the user denied the device")
+}
+
+// DeviceCodeExpired is returned for unknown, expired or already redeemed device codes. The device has to start again
+func DeviceCodeExpired() *Error {
+	return New(codes.Unauthenticated, ReasonDeviceCodeExpired, "the device code expired, start again")
+}
+
+// ServiceClientInvalid is returned when a service client is unknown or its secret is wrong
+func ServiceClientInvalid() *Error {
+	return New(codes.Unauthenticated, ReasonServiceClientInvalid, "invalid client credentials")
+}
+
+// ScopeNotAllowed is returned when a client requests a scope it was not registered with
+func ScopeNotAllowed(scope string) *Error {
+	return New(codes.PermissionDenied, ReasonScopeNotAllowed, "the client may not request scope "+scope).
+		WithMetadata("scope", scope)
+}
+
+// AudienceNotAllowed is returned when a client requests a token for a service it was not registered for
+func AudienceNotAllowed(audience string) *Error {
+	return New(codes.PermissionDenied, ReasonAudienceNotAllowed, "the client may not request tokens for "+audience).
+		WithMetadata("audience", audience)
+}
+
+// APIKeyNameTaken is returned when the user already has an API key with the same name
+func APIKeyNameTaken() *Error {
+	return New(codes.AlreadyExists, ReasonAPIKeyNameTaken, "an API key with this name already exists").
+		WithField("name", "name is already used by another API key")
+}
+
+// APIKeyNotFound is returned when revoking an API key that doesn't exist or belongs to another user
+func APIKeyNotFound() *Error {
+	return New(codes.NotFound, ReasonAPIKeyNotFound, "API key not found")
+}
+
+// DeviceTokenNotFound is returned when unregistering a push token the user didn't register
+func DeviceTokenNotFound() *Error {
+	return New(codes.NotFound, ReasonDeviceTokenNotFound, "device token not found")
+}
+
+// FollowNotFound is returned when unfollowing a user the caller doesn't follow or asked to follow
+func FollowNotFound() *Error {
+	return New(codes.NotFound, ReasonFollowNotFound, "not following this user")
+}
+
+// FollowRequestNotFound is returned when accepting or declining a follow request that isn't pending
+func FollowRequestNotFound() *Error {
+	return New(codes.NotFound, ReasonFollowRequestNotFound, "follow request not found")
+}
+
+// AccountPrivate is returned when listing the follows of a private account the caller doesn't follow
+func AccountPrivate() *Error {
+	return New(codes.PermissionDenied, ReasonAccountPrivate, "the account is private")
+}
+
+// DataExportsDisabled is returned when a data export is requested but no export storage is configured
+func DataExportsDisabled() *Error {
+	return New(codes.FailedPrecondition, ReasonDataExportsDisabled, "data exports are not enabled")
+}
+
+// DataExportNotFound is returned when a data export doesn't exist or belongs to another user
+func DataExportNotFound() *Error {
+	return New(codes.NotFound, ReasonDataExportNotFound, "data export not found")
+}
+
+// WebhookEndpointNotFound is returned when an admin names a webhook endpoint that doesn't exist
+func WebhookEndpointNotFound() *Error {
+	return New(codes.NotFound, ReasonWebhookEndpointNotFound, "webhook endpoint not found")
+}
+
+// PlanNotFound is returned when granting an entitlement to a plan that doesn't exist
+func PlanNotFound(plan string) *Error {
+	return New(codes.NotFound, ReasonPlanNotFound, "plan "+plan+" not found").
+		WithMetadata("plan", plan)
+}
+
+// EntitlementNotFound is returned when an admin names an entitlement that doesn't exist
+func EntitlementNotFound() *Error {
+	return New(codes.NotFound, ReasonEntitlementNotFound, "entitlement not found")
+}
+
+// RoleNotFound is returned when assigning or revoking a role that doesn't exist
+func RoleNotFound(role string) *Error {
+	return New(codes.NotFound, ReasonRoleNotFound, "role "+role+" not found").
+		WithMetadata("role", role)
+}
+
+// PermissionDenied is returned when the caller's token lacks the permission an RPC requires
+func PermissionDenied(permission string) *Error {
+	return New(codes.PermissionDenied, ReasonPermissionDenied, "missing permission "+permission).
+		WithMetadata("permission", permission)
+}
+
+// AccountSuspended is returned when a suspended user tries to log in or use their tokens. A zero until means
+// the suspension lasts until an admin lifts it
+func AccountSuspended(until time.Time) *Error {
+	if until.IsZero() {
+		return New(codes.PermissionDenied, ReasonAccountSuspended, "the account is suspended")
+	}
+	return New(codes.PermissionDenied, ReasonAccountSuspended, "the account is suspended until "+until.UTC().Format(time.RFC3339)).
+		WithMetadata("until", until.UTC().Format(time.RFC3339))
+}
+
+// AccountBanned is returned when a banned user tries to log in or use their tokens
+func AccountBanned() *Error {
+	return New(codes.PermissionDenied, ReasonAccountBanned, "the account is banned")
+}
+
+// AccountDeleted is returned when the user of a deleted account tries to log in or use their tokens
+func AccountDeleted() *Error {
+	return New(codes.PermissionDenied, ReasonAccountDeleted, "the account was deleted")
+}
+
+// SignInReportInvalid is returned when a "this wasn't me" link is unknown, was already used or expired
+func SignInReportInvalid() *Error {
+	return New(codes.Unauthenticated, ReasonSignInReportInvalid, "invalid or expired sign-in report link")
+}
+
+// PasswordResetInvalid is returned when a password reset token is unknown, was already used or expired
+func PasswordResetInvalid() *Error {
+	return New(codes.Unauthenticated, ReasonPasswordResetInvalid, "invalid or expired password reset token")
+}
+
+// EmailChangeUndoInvalid is returned when the undo link of an email change is unknown, was already used or expired
+func EmailChangeUndoInvalid() *Error {
+	return New(codes.Unauthenticated, ReasonEmailChangeUndoInvalid, "invalid or expired email change undo link")
+}
+
+// AccountDeletionCancelInvalid is returned when the cancel link of an account deletion is unknown, was already
+// used or the account was already deleted
+func AccountDeletionCancelInvalid() *Error {
+	return New(codes.Unauthenticated, ReasonAccountDeletionCancelInvalid, "invalid or expired account deletion cancel link")
+}
+
+// AccessTokenInvalid is returned when an RPC that needs a logged in user is called without a valid access token
+func AccessTokenInvalid() *Error {
+	return New(codes.Unauthenticated, ReasonAccessTokenInvalid, "missing or invalid access token")
+}
+
+// RefreshTokenInvalid is returned when the refresh token is unknown or was revoked
+func RefreshTokenInvalid() *Error {
+	return New(codes.Unauthenticated, ReasonRefreshTokenInvalid, "invalid refresh token")
+}
+
+// RefreshTokenExpired is returned when the refresh token is past its expiry time
+func RefreshTokenExpired() *Error {
+	return New(codes.Unauthenticated, ReasonRefreshTokenExpired, "refresh token expired")
+}
+
+// EmailDeliveryFailed is returned when an email could not be sent. The client may retry later
+func EmailDeliveryFailed(err error) *Error {
+	return New(codes.Unavailable, ReasonEmailDeliveryFailed, "failed to send email").
+		WithRetryAfter(30 * time.Second).
+		WithCause(err)
+}
+
+// Unavailable is returned when a dependency is temporarily unavailable
+func Unavailable(err error, retryAfter time.Duration) *Error {
+	return New(codes.Unavailable, ReasonUnavailable, "service temporarily unavailable").
+		WithRetryAfter(retryAfter).
+		WithCause(err)
+}
+
+// Internal hides the cause behind a generic message
+func Internal(err error) *Error {
+	return New(codes.Internal, ReasonInternal, "internal error").WithCause(err)
+}
+
+// Violations collects field violations so that all invalid fields of a request
+// are reported at once in a single BadRequest detail
+type Violations []FieldViolation
+
+// Add records an invalid field
+func (v *Violations) Add(field, description string) {
+	*v = append(*v, FieldViolation{Field: field, Description: description})
+}
+
+// Err returns an InvalidArgument error with all collected violations, or nil if there are none
+func (v Violations) Err() error {
+	if len(v) == 0 {
+		return nil
+	}
+
+	err := New(codes.InvalidArgument, ReasonInvalidArgument, "request contains invalid fields")
+	err.Violations = append(err.Violations, v...)
+	return err
+}
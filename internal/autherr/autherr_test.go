@@ -0,0 +1,96 @@
+package autherr
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPCStatusDetails(t *testing.T) {
+	err := New(codes.Unavailable, ReasonEmailDeliveryFailed, "failed to send email").
+		WithField("email", "mailbox unavailable").
+		WithRetryAfter(30*time.Second).
+		WithMetadata("provider", "smtp")
+
+	st, ok := status.FromError(err)
+	assert.True(t, ok)
+	assert.Equal(t, codes.Unavailable, st.Code())
+	assert.Equal(t, "failed to send email", st.Message())
+
+	var (
+		info       *errdetails.ErrorInfo
+		badRequest *errdetails.BadRequest
+		retryInfo  *errdetails.RetryInfo
+	)
+	for _, d := range st.Details() {
+		switch detail := d.(type) {
+		case *errdetails.ErrorInfo:
+			info = detail
+		case *errdetails.BadRequest:
+			badRequest = detail
+		case *errdetails.RetryInfo:
+			retryInfo = detail
+		}
+	}
+
+	if assert.NotNil(t, info) {
+		assert.Equal(t, string(ReasonEmailDeliveryFailed), info.GetReason())
+		assert.Equal(t, Domain, info.GetDomain())
+		assert.Equal(t, "smtp", info.GetMetadata()["provider"])
+	}
+	if assert.NotNil(t, badRequest) {
+		assert.Len(t, badRequest.GetFieldViolations(), 1)
+		assert.Equal(t, "email", badRequest.GetFieldViolations()[0].GetField())
+	}
+	if assert.NotNil(t, retryInfo) {
+		assert.Equal(t, 30*time.Second, retryInfo.GetRetryDelay().AsDuration())
+	}
+}
+
+func TestCauseIsNotExposed(t *testing.T) {
+	cause := errors.New("pq: connection refused")
+	err := Internal(cause)
+
+	st := err.GRPCStatus()
+	assert.Equal(t, codes.Internal, st.Code())
+	assert.NotContains(t, st.Message(), "connection refused")
+	assert.ErrorIs(t, err, cause)
+}
+
+func TestFrom(t *testing.T) {
+	domainErr := InvalidCredentials()
+	assert.Same(t, domainErr, From(domainErr))
+
+	wrapped := From(errors.New("boom"))
+	assert.Equal(t, codes.Internal, wrapped.Code)
+	assert.Equal(t, ReasonInternal, wrapped.Reason)
+
+	assert.Nil(t, From(nil))
+}
+
+func TestViolations(t *testing.T) {
+	var v Violations
+	assert.NoError(t, v.Err())
+
+	v.Add("email", "email is required")
+	v.Add("username", "username is too short")
+
+	err := v.Err()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, ReasonInvalidArgument, ReasonOf(err))
+
+	var domainErr *Error
+	assert.True(t, errors.As(err, &domainErr))
+	assert.Len(t, domainErr.Violations, 2)
+}
+
+func TestIs(t *testing.T) {
+	err := InvalidCredentials().WithCause(errors.New("wrong password"))
+	assert.ErrorIs(t, err, InvalidCredentials())
+	assert.False(t, errors.Is(err, UserNotFound()))
+}
@@ -0,0 +1,14 @@
+// Package backoff computes how long failed background work waits before it is tried again
+package backoff
+
+import "time"
+
+// Exponential is how long to wait after the attempts-th failed attempt: initial after the first, doubling with
+// every attempt up to limit
+func Exponential(attempts int32, initial, limit time.Duration) time.Duration {
+	backoff := initial
+	for i := int32(1); i < attempts && backoff < limit; i++ {
+		backoff *= 2
+	}
+	return min(backoff, limit)
+}
@@ -0,0 +1,31 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExponential(t *testing.T) {
+	testCases := []struct {
+		attempts int32
+		initial  time.Duration
+		limit    time.Duration
+		expected time.Duration
+	}{
+		{attempts: 0, initial: time.Second, limit: 10 * time.Minute, expected: time.Second},
+		{attempts: 1, initial: time.Second, limit: 10 * time.Minute, expected: time.Second},
+		{attempts: 2, initial: time.Second, limit: 10 * time.Minute, expected: 2 * time.Second},
+		{attempts: 10, initial: time.Second, limit: 10 * time.Minute, expected: 512 * time.Second},
+		{attempts: 11, initial: time.Second, limit: 10 * time.Minute, expected: 10 * time.Minute},
+		{attempts: 1000, initial: time.Second, limit: 10 * time.Minute, expected: 10 * time.Minute},
+		{attempts: 4, initial: 30 * time.Second, limit: time.Hour, expected: 4 * time.Minute},
+		{attempts: 8, initial: 30 * time.Second, limit: time.Hour, expected: time.Hour},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, Exponential(tc.attempts, tc.initial, tc.limit),
+			"attempts %d from %s up to %s", tc.attempts, tc.initial, tc.limit)
+	}
+}
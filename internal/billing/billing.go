@@ -0,0 +1,135 @@
+// Package billing reads the webhook events of the billing provider. Events have the format of Stripe webhooks and
+// are signed the same way, with the HMAC-SHA256 of the timestamp, a dot and the body in the Stripe-Signature header
+package billing
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/imhasandl/auth-service/internal/webhook"
+)
+
+// SignatureHeader holds the time the event was signed and its signature, like t=1700000000,v1=5257a8...
+const SignatureHeader = "Stripe-Signature"
+
+// SignatureTolerance is how long after it was signed an event is accepted
+const SignatureTolerance = 5 * time.Minute
+
+// Event types that change entitlements. Other events are acknowledged and ignored
+const (
+	EventSubscriptionCreated = "customer.subscription.created"
+	EventSubscriptionUpdated = "customer.subscription.updated"
+	EventSubscriptionDeleted = "customer.subscription.deleted"
+)
+
+// UserIDMetadataKey is the metadata key of subscriptions that holds the ID of the user who subscribed. Checkout
+// sessions have to set it
+const UserIDMetadataKey = "user_id"
+
+// ErrMalformedEvent is returned by ParseEvent for correctly signed bodies that aren't events
+var ErrMalformedEvent = errors.New("malformed billing event")
+
+// Event is a webhook event of the billing provider
+type Event struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Created int64  `json:"created"`
+	Data    struct {
+		Object json.RawMessage `json:"object"`
+	} `json:"data"`
+}
+
+// CreatedAt is when the provider created the event. Events can arrive in a different order
+func (e Event) CreatedAt() time.Time {
+	return time.Unix(e.Created, 0).UTC()
+}
+
+// IsSubscriptionEvent reports whether the event changes a subscription
+func (e Event) IsSubscriptionEvent() bool {
+	switch e.Type {
+	case EventSubscriptionCreated, EventSubscriptionUpdated, EventSubscriptionDeleted:
+		return true
+	}
+	return false
+}
+
+// Subscription decodes the subscription of a subscription event
+func (e Event) Subscription() (Subscription, error) {
+	var subscription Subscription
+	if err := json.Unmarshal(e.Data.Object, &subscription); err != nil {
+		return Subscription{}, errors.Join(ErrMalformedEvent, err)
+	}
+	if subscription.ID == "" {
+		return Subscription{}, ErrMalformedEvent
+	}
+	return subscription, nil
+}
+
+// Subscription is the part of a subscription of the billing provider entitlements are made from
+type Subscription struct {
+	ID                 string            `json:"id"`
+	Status             string            `json:"status"`
+	CurrentPeriodStart int64             `json:"current_period_start"`
+	CurrentPeriodEnd   int64             `json:"current_period_end"`
+	Metadata           map[string]string `json:"metadata"`
+	Items              struct {
+		Data []struct {
+			Price struct {
+				ID string `json:"id"`
+			} `json:"price"`
+		} `json:"data"`
+	} `json:"items"`
+}
+
+// UserID returns the user ID the subscription was made for, "" when it has none
+func (s Subscription) UserID() string {
+	return strings.TrimSpace(s.Metadata[UserIDMetadataKey])
+}
+
+// PriceIDs returns the IDs of the prices the subscription is for
+func (s Subscription) PriceIDs() []string {
+	ids := make([]string, 0, len(s.Items.Data))
+	for _, item := range s.Items.Data {
+		ids = append(ids, item.Price.ID)
+	}
+	return ids
+}
+
+// PeriodStart is when the billing period that was paid for started
+func (s Subscription) PeriodStart() time.Time {
+	return time.Unix(s.CurrentPeriodStart, 0).UTC()
+}
+
+// PeriodEnd is when the billing period that was paid for ends, the subscription renews or lapses then
+func (s Subscription) PeriodEnd() time.Time {
+	return time.Unix(s.CurrentPeriodEnd, 0).UTC()
+}
+
+// Entitled reports whether the subscription gives access to its plan. Past due subscriptions keep access while
+// the provider retries the payment, incomplete ones don't have it yet
+func (s Subscription) Entitled() bool {
+	switch s.Status {
+	case "active", "trialing", "past_due":
+		return true
+	}
+	return false
+}
+
+// ParseEvent checks the signature of a webhook request and decodes its event. It returns the errors of
+// webhook.Verify for requests that weren't signed with secret
+func ParseEvent(body []byte, signature, secret string, now time.Time) (Event, error) {
+	if err := webhook.Verify(secret, signature, body, SignatureTolerance, now); err != nil {
+		return Event{}, err
+	}
+
+	var event Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		return Event{}, errors.Join(ErrMalformedEvent, err)
+	}
+	if event.ID == "" || event.Type == "" {
+		return Event{}, ErrMalformedEvent
+	}
+	return event, nil
+}
@@ -0,0 +1,153 @@
+package billing
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/imhasandl/auth-service/internal/webhook"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fixture(t *testing.T, name string) []byte {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	return body
+}
+
+func TestParseEvent(t *testing.T) {
+	now := time.Unix(1767225700, 0)
+	body := fixture(t, "subscription_created.json")
+
+	tests := []struct {
+		name      string
+		body      []byte
+		signature string
+		wantErr   error
+	}{
+		{
+			name:      "signed event",
+			body:      body,
+			signature: webhook.Sign("whsec_billing", now, body),
+		},
+		{
+			name:      "signed with another secret",
+			body:      body,
+			signature: webhook.Sign("whsec_other", now, body),
+			wantErr:   webhook.ErrInvalidSignature,
+		},
+		{
+			name:      "missing signature",
+			body:      body,
+			signature: "",
+			wantErr:   webhook.ErrInvalidSignature,
+		},
+		{
+			name:      "signed too long ago",
+			body:      body,
+			signature: webhook.Sign("whsec_billing", now.Add(-SignatureTolerance-time.Second), body),
+			wantErr:   webhook.ErrTimestampTooOld,
+		},
+		{
+			name:      "not json",
+			body:      []byte("not json"),
+			signature: webhook.Sign("whsec_billing", now, []byte("not json")),
+			wantErr:   ErrMalformedEvent,
+		},
+		{
+			name:      "no event id",
+			body:      []byte(`{"type":"customer.subscription.created"}`),
+			signature: webhook.Sign("whsec_billing", now, []byte(`{"type":"customer.subscription.created"}`)),
+			wantErr:   ErrMalformedEvent,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			event, err := ParseEvent(tc.body, tc.signature, "whsec_billing", now)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "evt_1QcreatedA1b2C3", event.ID)
+			assert.Equal(t, EventSubscriptionCreated, event.Type)
+			assert.Equal(t, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), event.CreatedAt())
+		})
+	}
+}
+
+func TestEventSubscription(t *testing.T) {
+	tests := []struct {
+		fixture         string
+		subscription    bool
+		status          string
+		entitled        bool
+		wantPeriodStart time.Time
+		wantPeriodEnd   time.Time
+	}{
+		{
+			fixture:         "subscription_created.json",
+			subscription:    true,
+			status:          "active",
+			entitled:        true,
+			wantPeriodStart: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			wantPeriodEnd:   time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			fixture:         "subscription_past_due.json",
+			subscription:    true,
+			status:          "past_due",
+			entitled:        true,
+			wantPeriodStart: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+			wantPeriodEnd:   time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			fixture:         "subscription_deleted.json",
+			subscription:    true,
+			status:          "canceled",
+			entitled:        false,
+			wantPeriodStart: time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+			wantPeriodEnd:   time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			fixture: "invoice_paid.json",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.fixture, func(t *testing.T) {
+			body := fixture(t, tc.fixture)
+			now := time.Now()
+			event, err := ParseEvent(body, webhook.Sign("whsec_billing", now, body), "whsec_billing", now)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.subscription, event.IsSubscriptionEvent())
+			if !tc.subscription {
+				return
+			}
+
+			subscription, err := event.Subscription()
+			require.NoError(t, err)
+			assert.Equal(t, "sub_1QpremiumA1b2C3", subscription.ID)
+			assert.Equal(t, tc.status, subscription.Status)
+			assert.Equal(t, tc.entitled, subscription.Entitled())
+			assert.Equal(t, "7d1d5b0e-4c55-4e0f-9a43-2f7d0b6a1c11", subscription.UserID())
+			assert.Equal(t, []string{"price_premium_monthly"}, subscription.PriceIDs())
+			assert.Equal(t, tc.wantPeriodStart, subscription.PeriodStart())
+			assert.Equal(t, tc.wantPeriodEnd, subscription.PeriodEnd())
+		})
+	}
+}
+
+func TestEventSubscriptionMalformed(t *testing.T) {
+	event := Event{ID: "evt_1", Type: EventSubscriptionUpdated}
+	event.Data.Object = []byte(`{"status":"active"}`)
+
+	_, err := event.Subscription()
+
+	assert.ErrorIs(t, err, ErrMalformedEvent)
+}
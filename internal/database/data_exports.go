@@ -0,0 +1,10 @@
+package database
+
+// Data export states, stored in data_exports.status
+const (
+	DataExportPending = "pending"
+	DataExportRunning = "running"
+	DataExportReady   = "ready"
+	DataExportFailed  = "failed"
+	DataExportExpired = "expired"
+)
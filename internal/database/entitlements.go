@@ -0,0 +1,7 @@
+package database
+
+// Where entitlements come from, stored in user_entitlements.source
+const (
+	EntitlementSourceAdmin   = "admin"
+	EntitlementSourceBilling = "billing"
+)
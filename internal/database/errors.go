@@ -0,0 +1,34 @@
+package database
+
+import (
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// postgres error codes checked by the service
+const (
+	uniqueViolation      = "23505"
+	serializationFailure = "40001"
+	deadlockDetected     = "40P01"
+)
+
+// IsUniqueViolation reports whether err is a unique constraint violation of the given constraint.
+// An empty constraint matches any unique constraint
+func IsUniqueViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != uniqueViolation {
+		return false
+	}
+	return constraint == "" || pqErr.Constraint == constraint
+}
+
+// IsSerializationFailure reports whether err is a serialization failure or a deadlock,
+// which means the transaction can be retried
+func IsSerializationFailure(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == serializationFailure || pqErr.Code == deadlockDetected
+}
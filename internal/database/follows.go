@@ -0,0 +1,7 @@
+package database
+
+// Follow states, stored in follows.status
+const (
+	FollowPending  = "pending"
+	FollowAccepted = "accepted"
+)
@@ -2,13 +2,15 @@ package mocks
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/imhasandl/auth-service/internal/database"
 	"github.com/stretchr/testify/mock"
 )
 
-// MockQueries is a mock implementation of the database.Queries interface
+// MockQueries is a mock implementation of the database.DBQuerier interface
 type MockQueries struct {
 	mock.Mock
 }
@@ -25,24 +27,732 @@ func (m *MockQueries) GetUserByIdentifier(ctx context.Context, arg database.GetU
 	return args.Get(0).(database.User), args.Error(1)
 }
 
+// GetUserByID mocks the GetUserByID method
+func (m *MockQueries) GetUserByID(ctx context.Context, id uuid.UUID) (database.User, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(database.User), args.Error(1)
+}
+
 // VerifyUser mocks the VerifyUser method
-func (m *MockQueries) VerifyUser(ctx context.Context, email string) error {
-	args := m.Called(ctx, email)
+func (m *MockQueries) VerifyUser(ctx context.Context, emailCanonical string) (uuid.UUID, error) {
+	args := m.Called(ctx, emailCanonical)
+	return args.Get(0).(uuid.UUID), args.Error(1)
+}
+
+// CreateUserIdentity mocks the CreateUserIdentity method
+func (m *MockQueries) CreateUserIdentity(ctx context.Context, arg database.CreateUserIdentityParams) (database.UserIdentity, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(database.UserIdentity), args.Error(1)
+}
+
+// GetUserIdentity mocks the GetUserIdentity method
+func (m *MockQueries) GetUserIdentity(ctx context.Context, arg database.GetUserIdentityParams) (database.UserIdentity, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(database.UserIdentity), args.Error(1)
+}
+
+// UpsertVerificationCode mocks the UpsertVerificationCode method
+func (m *MockQueries) UpsertVerificationCode(ctx context.Context, arg database.UpsertVerificationCodeParams) (database.VerificationCode, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(database.VerificationCode), args.Error(1)
+}
+
+// GetVerificationCode mocks the GetVerificationCode method
+func (m *MockQueries) GetVerificationCode(ctx context.Context, arg database.GetVerificationCodeParams) (database.VerificationCode, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(database.VerificationCode), args.Error(1)
+}
+
+// IncrementVerificationCodeAttempts mocks the IncrementVerificationCodeAttempts method
+func (m *MockQueries) IncrementVerificationCodeAttempts(ctx context.Context, arg database.IncrementVerificationCodeAttemptsParams) (int32, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(int32), args.Error(1)
+}
+
+// ConsumeVerificationCode mocks the ConsumeVerificationCode method
+func (m *MockQueries) ConsumeVerificationCode(ctx context.Context, arg database.ConsumeVerificationCodeParams) (int64, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// DeleteVerificationCode mocks the DeleteVerificationCode method
+func (m *MockQueries) DeleteVerificationCode(ctx context.Context, arg database.DeleteVerificationCodeParams) error {
+	args := m.Called(ctx, arg)
+	return args.Error(0)
+}
+
+// DeleteVerificationCodeByEmail mocks the DeleteVerificationCodeByEmail method
+func (m *MockQueries) DeleteVerificationCodeByEmail(ctx context.Context, arg database.DeleteVerificationCodeByEmailParams) error {
+	args := m.Called(ctx, arg)
+	return args.Error(0)
+}
+
+// CreateOAuthClient mocks the CreateOAuthClient method
+func (m *MockQueries) CreateOAuthClient(ctx context.Context, arg database.CreateOAuthClientParams) (database.OauthClient, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(database.OauthClient), args.Error(1)
+}
+
+// GetOAuthClient mocks the GetOAuthClient method
+func (m *MockQueries) GetOAuthClient(ctx context.Context, id string) (database.OauthClient, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(database.OauthClient), args.Error(1)
+}
+
+// ListOAuthClients mocks the ListOAuthClients method
+func (m *MockQueries) ListOAuthClients(ctx context.Context) ([]database.OauthClient, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]database.OauthClient), args.Error(1)
+}
+
+// DeleteOAuthClient mocks the DeleteOAuthClient method
+func (m *MockQueries) DeleteOAuthClient(ctx context.Context, id string) (int64, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// GetOAuthConsent mocks the GetOAuthConsent method
+func (m *MockQueries) GetOAuthConsent(ctx context.Context, arg database.GetOAuthConsentParams) (database.OauthConsent, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(database.OauthConsent), args.Error(1)
+}
+
+// UpsertOAuthConsent mocks the UpsertOAuthConsent method
+func (m *MockQueries) UpsertOAuthConsent(ctx context.Context, arg database.UpsertOAuthConsentParams) error {
+	args := m.Called(ctx, arg)
+	return args.Error(0)
+}
+
+// CreateServiceClient mocks the CreateServiceClient method
+func (m *MockQueries) CreateServiceClient(ctx context.Context, arg database.CreateServiceClientParams) (database.ServiceClient, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(database.ServiceClient), args.Error(1)
+}
+
+// GetServiceClient mocks the GetServiceClient method
+func (m *MockQueries) GetServiceClient(ctx context.Context, id string) (database.ServiceClient, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(database.ServiceClient), args.Error(1)
+}
+
+// ListServiceClients mocks the ListServiceClients method
+func (m *MockQueries) ListServiceClients(ctx context.Context) ([]database.ServiceClient, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]database.ServiceClient), args.Error(1)
+}
+
+// RotateServiceClientSecret mocks the RotateServiceClientSecret method
+func (m *MockQueries) RotateServiceClientSecret(ctx context.Context, arg database.RotateServiceClientSecretParams) (database.ServiceClient, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(database.ServiceClient), args.Error(1)
+}
+
+// DeleteServiceClient mocks the DeleteServiceClient method
+func (m *MockQueries) DeleteServiceClient(ctx context.Context, id string) (int64, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// CreateAPIKey mocks the CreateAPIKey method
+func (m *MockQueries) CreateAPIKey(ctx context.Context, arg database.CreateAPIKeyParams) (database.ApiKey, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(database.ApiKey), args.Error(1)
+}
+
+// ListAPIKeys mocks the ListAPIKeys method
+func (m *MockQueries) ListAPIKeys(ctx context.Context, userID uuid.UUID) ([]database.ApiKey, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]database.ApiKey), args.Error(1)
+}
+
+// GetAPIKeyByHash mocks the GetAPIKeyByHash method
+func (m *MockQueries) GetAPIKeyByHash(ctx context.Context, keyHash string) (database.ApiKey, error) {
+	args := m.Called(ctx, keyHash)
+	return args.Get(0).(database.ApiKey), args.Error(1)
+}
+
+// TouchAPIKey mocks the TouchAPIKey method
+func (m *MockQueries) TouchAPIKey(ctx context.Context, id uuid.UUID) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+// DeleteAPIKey mocks the DeleteAPIKey method
+func (m *MockQueries) DeleteAPIKey(ctx context.Context, arg database.DeleteAPIKeyParams) (int64, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// GetRole mocks the GetRole method
+func (m *MockQueries) GetRole(ctx context.Context, name string) (database.Role, error) {
+	args := m.Called(ctx, name)
+	return args.Get(0).(database.Role), args.Error(1)
+}
+
+// ListRoles mocks the ListRoles method
+func (m *MockQueries) ListRoles(ctx context.Context) ([]database.ListRolesRow, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]database.ListRolesRow), args.Error(1)
+}
+
+// ListUserRoles mocks the ListUserRoles method
+func (m *MockQueries) ListUserRoles(ctx context.Context, userID uuid.UUID) ([]database.ListUserRolesRow, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]database.ListUserRolesRow), args.Error(1)
+}
+
+// GetUserAuthorization mocks the GetUserAuthorization method
+func (m *MockQueries) GetUserAuthorization(ctx context.Context, userID uuid.UUID) (database.GetUserAuthorizationRow, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(database.GetUserAuthorizationRow), args.Error(1)
+}
+
+// AssignRole mocks the AssignRole method
+func (m *MockQueries) AssignRole(ctx context.Context, arg database.AssignRoleParams) (int64, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// RevokeRole mocks the RevokeRole method
+func (m *MockQueries) RevokeRole(ctx context.Context, arg database.RevokeRoleParams) (int64, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// ListUsers mocks the ListUsers method
+func (m *MockQueries) ListUsers(ctx context.Context, arg database.ListUsersParams) ([]database.User, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).([]database.User), args.Error(1)
+}
+
+// UpdateUser mocks the UpdateUser method
+func (m *MockQueries) UpdateUser(ctx context.Context, arg database.UpdateUserParams) (database.User, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(database.User), args.Error(1)
+}
+
+// SetUserStatus mocks the SetUserStatus method
+func (m *MockQueries) SetUserStatus(ctx context.Context, arg database.SetUserStatusParams) (database.User, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(database.User), args.Error(1)
+}
+
+// GetUserStatus mocks the GetUserStatus method
+func (m *MockQueries) GetUserStatus(ctx context.Context, id uuid.UUID) (database.GetUserStatusRow, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(database.GetUserStatusRow), args.Error(1)
+}
+
+// LiftExpiredSuspensions mocks the LiftExpiredSuspensions method
+func (m *MockQueries) LiftExpiredSuspensions(ctx context.Context) (int64, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// CreateAdminAuditEntry mocks the CreateAdminAuditEntry method
+func (m *MockQueries) CreateAdminAuditEntry(ctx context.Context, arg database.CreateAdminAuditEntryParams) error {
+	args := m.Called(ctx, arg)
+	return args.Error(0)
+}
+
+// CreateAuthEvent mocks the CreateAuthEvent method
+func (m *MockQueries) CreateAuthEvent(ctx context.Context, arg database.CreateAuthEventParams) error {
+	args := m.Called(ctx, arg)
+	return args.Error(0)
+}
+
+// ListAuthEvents mocks the ListAuthEvents method
+func (m *MockQueries) ListAuthEvents(ctx context.Context, arg database.ListAuthEventsParams) ([]database.AuthEvent, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).([]database.AuthEvent), args.Error(1)
+}
+
+// DeleteAuthEventsBefore mocks the DeleteAuthEventsBefore method
+func (m *MockQueries) DeleteAuthEventsBefore(ctx context.Context, createdAt time.Time) (int64, error) {
+	args := m.Called(ctx, createdAt)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// RememberDevice mocks the RememberDevice method
+func (m *MockQueries) RememberDevice(ctx context.Context, arg database.RememberDeviceParams) (database.RememberDeviceRow, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(database.RememberDeviceRow), args.Error(1)
+}
+
+// SetUserPassword mocks the SetUserPassword method
+func (m *MockQueries) SetUserPassword(ctx context.Context, arg database.SetUserPasswordParams) error {
+	args := m.Called(ctx, arg)
+	return args.Error(0)
+}
+
+// ChangeUserEmail mocks the ChangeUserEmail method
+func (m *MockQueries) ChangeUserEmail(ctx context.Context, arg database.ChangeUserEmailParams) (database.User, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(database.User), args.Error(1)
+}
+
+// HoldEmail mocks the HoldEmail method
+func (m *MockQueries) HoldEmail(ctx context.Context, arg database.HoldEmailParams) (int64, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// TakeEmailHold mocks the TakeEmailHold method
+func (m *MockQueries) TakeEmailHold(ctx context.Context, tokenHash string) (database.EmailHold, error) {
+	args := m.Called(ctx, tokenHash)
+	return args.Get(0).(database.EmailHold), args.Error(1)
+}
+
+// DeleteExpiredEmailHolds mocks the DeleteExpiredEmailHolds method
+func (m *MockQueries) DeleteExpiredEmailHolds(ctx context.Context) (int64, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// UsernameAvailable mocks the UsernameAvailable method
+func (m *MockQueries) UsernameAvailable(ctx context.Context, arg database.UsernameAvailableParams) (bool, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(bool), args.Error(1)
+}
+
+// SetUsername mocks the SetUsername method
+func (m *MockQueries) SetUsername(ctx context.Context, arg database.SetUsernameParams) (database.User, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(database.User), args.Error(1)
+}
+
+// CreateUsernameChange mocks the CreateUsernameChange method
+func (m *MockQueries) CreateUsernameChange(ctx context.Context, arg database.CreateUsernameChangeParams) error {
+	args := m.Called(ctx, arg)
+	return args.Error(0)
+}
+
+// GetLastUsernameChange mocks the GetLastUsernameChange method
+func (m *MockQueries) GetLastUsernameChange(ctx context.Context, userID uuid.UUID) (time.Time, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(time.Time), args.Error(1)
+}
+
+// ScheduleAccountDeletion mocks the ScheduleAccountDeletion method
+func (m *MockQueries) ScheduleAccountDeletion(ctx context.Context, arg database.ScheduleAccountDeletionParams) error {
+	args := m.Called(ctx, arg)
+	return args.Error(0)
+}
+
+// TakeAccountDeletion mocks the TakeAccountDeletion method
+func (m *MockQueries) TakeAccountDeletion(ctx context.Context, tokenHash string) (database.AccountDeletion, error) {
+	args := m.Called(ctx, tokenHash)
+	return args.Get(0).(database.AccountDeletion), args.Error(1)
+}
+
+// ListDueAccountDeletions mocks the ListDueAccountDeletions method
+func (m *MockQueries) ListDueAccountDeletions(ctx context.Context, limit int32) ([]uuid.UUID, error) {
+	args := m.Called(ctx, limit)
+	return args.Get(0).([]uuid.UUID), args.Error(1)
+}
+
+// ClaimAccountDeletion mocks the ClaimAccountDeletion method
+func (m *MockQueries) ClaimAccountDeletion(ctx context.Context, userID uuid.UUID) (int64, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// DeleteUserPersonalData mocks the DeleteUserPersonalData method
+func (m *MockQueries) DeleteUserPersonalData(ctx context.Context, userID uuid.UUID) error {
+	args := m.Called(ctx, userID)
+	return args.Error(0)
+}
+
+// DeleteUserContent mocks the DeleteUserContent method
+func (m *MockQueries) DeleteUserContent(ctx context.Context, userID uuid.UUID) error {
+	args := m.Called(ctx, userID)
+	return args.Error(0)
+}
+
+// DeleteUser mocks the DeleteUser method
+func (m *MockQueries) DeleteUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// AnonymizeUser mocks the AnonymizeUser method
+func (m *MockQueries) AnonymizeUser(ctx context.Context, arg database.AnonymizeUserParams) (database.User, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(database.User), args.Error(1)
+}
+
+// CreateDataExport mocks the CreateDataExport method
+func (m *MockQueries) CreateDataExport(ctx context.Context, arg database.CreateDataExportParams) (database.DataExport, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(database.DataExport), args.Error(1)
+}
+
+// GetActiveDataExport mocks the GetActiveDataExport method
+func (m *MockQueries) GetActiveDataExport(ctx context.Context, userID uuid.UUID) (database.DataExport, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(database.DataExport), args.Error(1)
+}
+
+// GetDataExport mocks the GetDataExport method
+func (m *MockQueries) GetDataExport(ctx context.Context, arg database.GetDataExportParams) (database.DataExport, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(database.DataExport), args.Error(1)
+}
+
+// ClaimDataExport mocks the ClaimDataExport method
+func (m *MockQueries) ClaimDataExport(ctx context.Context, arg database.ClaimDataExportParams) (database.DataExport, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(database.DataExport), args.Error(1)
+}
+
+// CompleteDataExport mocks the CompleteDataExport method
+func (m *MockQueries) CompleteDataExport(ctx context.Context, arg database.CompleteDataExportParams) error {
+	args := m.Called(ctx, arg)
+	return args.Error(0)
+}
+
+// FailDataExport mocks the FailDataExport method
+func (m *MockQueries) FailDataExport(ctx context.Context, arg database.FailDataExportParams) error {
+	args := m.Called(ctx, arg)
+	return args.Error(0)
+}
+
+// FailAbandonedDataExports mocks the FailAbandonedDataExports method
+func (m *MockQueries) FailAbandonedDataExports(ctx context.Context, arg database.FailAbandonedDataExportsParams) (int64, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// ListExpiredDataExports mocks the ListExpiredDataExports method
+func (m *MockQueries) ListExpiredDataExports(ctx context.Context, limit int32) ([]database.DataExport, error) {
+	args := m.Called(ctx, limit)
+	return args.Get(0).([]database.DataExport), args.Error(1)
+}
+
+// ExpireDataExport mocks the ExpireDataExport method
+func (m *MockQueries) ExpireDataExport(ctx context.Context, id uuid.UUID) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+// ListDeviceTokens mocks the ListDeviceTokens method
+func (m *MockQueries) ListDeviceTokens(ctx context.Context, userID uuid.UUID) ([]database.DeviceToken, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]database.DeviceToken), args.Error(1)
+}
+
+// ListRefreshTokens mocks the ListRefreshTokens method
+func (m *MockQueries) ListRefreshTokens(ctx context.Context, userID uuid.UUID) ([]database.RefreshToken, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]database.RefreshToken), args.Error(1)
+}
+
+// ListUserIdentities mocks the ListUserIdentities method
+func (m *MockQueries) ListUserIdentities(ctx context.Context, userID uuid.UUID) ([]database.UserIdentity, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]database.UserIdentity), args.Error(1)
+}
+
+// ExpireUserDataExports mocks the ExpireUserDataExports method
+func (m *MockQueries) ExpireUserDataExports(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+// CreateOutboxEvent mocks the CreateOutboxEvent method
+func (m *MockQueries) CreateOutboxEvent(ctx context.Context, arg database.CreateOutboxEventParams) error {
+	args := m.Called(ctx, arg)
+	return args.Error(0)
+}
+
+// ClaimOutboxEvents mocks the ClaimOutboxEvents method
+func (m *MockQueries) ClaimOutboxEvents(ctx context.Context, arg database.ClaimOutboxEventsParams) ([]database.EventsOutbox, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).([]database.EventsOutbox), args.Error(1)
+}
+
+// MarkOutboxEventPublished mocks the MarkOutboxEventPublished method
+func (m *MockQueries) MarkOutboxEventPublished(ctx context.Context, id int64) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+// RetryOutboxEvent mocks the RetryOutboxEvent method
+func (m *MockQueries) RetryOutboxEvent(ctx context.Context, arg database.RetryOutboxEventParams) error {
+	args := m.Called(ctx, arg)
+	return args.Error(0)
+}
+
+// DeletePublishedOutboxEvents mocks the DeletePublishedOutboxEvents method
+func (m *MockQueries) DeletePublishedOutboxEvents(ctx context.Context, publishedAt sql.NullTime) (int64, error) {
+	args := m.Called(ctx, publishedAt)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// CreateWebhookEndpoint mocks the CreateWebhookEndpoint method
+func (m *MockQueries) CreateWebhookEndpoint(ctx context.Context, arg database.CreateWebhookEndpointParams) (database.WebhookEndpoint, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(database.WebhookEndpoint), args.Error(1)
+}
+
+// ListWebhookEndpoints mocks the ListWebhookEndpoints method
+func (m *MockQueries) ListWebhookEndpoints(ctx context.Context) ([]database.WebhookEndpoint, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]database.WebhookEndpoint), args.Error(1)
+}
+
+// DeleteWebhookEndpoint mocks the DeleteWebhookEndpoint method
+func (m *MockQueries) DeleteWebhookEndpoint(ctx context.Context, id uuid.UUID) (int64, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// EnableWebhookEndpoint mocks the EnableWebhookEndpoint method
+func (m *MockQueries) EnableWebhookEndpoint(ctx context.Context, id uuid.UUID) (int64, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// RecordWebhookEndpointSuccess mocks the RecordWebhookEndpointSuccess method
+func (m *MockQueries) RecordWebhookEndpointSuccess(ctx context.Context, id uuid.UUID) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+// RecordWebhookEndpointFailure mocks the RecordWebhookEndpointFailure method
+func (m *MockQueries) RecordWebhookEndpointFailure(ctx context.Context, arg database.RecordWebhookEndpointFailureParams) (database.WebhookEndpoint, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(database.WebhookEndpoint), args.Error(1)
+}
+
+// CreateWebhookDeliveries mocks the CreateWebhookDeliveries method
+func (m *MockQueries) CreateWebhookDeliveries(ctx context.Context, arg database.CreateWebhookDeliveriesParams) error {
+	args := m.Called(ctx, arg)
+	return args.Error(0)
+}
+
+// ClaimWebhookDeliveries mocks the ClaimWebhookDeliveries method
+func (m *MockQueries) ClaimWebhookDeliveries(ctx context.Context, arg database.ClaimWebhookDeliveriesParams) ([]database.ClaimWebhookDeliveriesRow, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).([]database.ClaimWebhookDeliveriesRow), args.Error(1)
+}
+
+// CompleteWebhookDelivery mocks the CompleteWebhookDelivery method
+func (m *MockQueries) CompleteWebhookDelivery(ctx context.Context, arg database.CompleteWebhookDeliveryParams) error {
+	args := m.Called(ctx, arg)
 	return args.Error(0)
 }
 
-// StoreVerificationCode mocks the StoreVerificationCode method
-func (m *MockQueries) StoreVerificationCode(ctx context.Context, arg database.StoreVerificationCodeParams) error {
+// RetryWebhookDelivery mocks the RetryWebhookDelivery method
+func (m *MockQueries) RetryWebhookDelivery(ctx context.Context, arg database.RetryWebhookDeliveryParams) error {
 	args := m.Called(ctx, arg)
 	return args.Error(0)
 }
 
-// SendVerifyCodeAgain mocks the SendVerifyCodeAgain method
-func (m *MockQueries) SendVerifyCodeAgain(ctx context.Context, arg database.SendVerifyCodeAgainParams) error {
+// FailWebhookDelivery mocks the FailWebhookDelivery method
+func (m *MockQueries) FailWebhookDelivery(ctx context.Context, arg database.FailWebhookDeliveryParams) error {
 	args := m.Called(ctx, arg)
 	return args.Error(0)
 }
 
+// ListWebhookDeliveries mocks the ListWebhookDeliveries method
+func (m *MockQueries) ListWebhookDeliveries(ctx context.Context, arg database.ListWebhookDeliveriesParams) ([]database.WebhookDelivery, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).([]database.WebhookDelivery), args.Error(1)
+}
+
+// ReplayWebhookDeliveries mocks the ReplayWebhookDeliveries method
+func (m *MockQueries) ReplayWebhookDeliveries(ctx context.Context, arg database.ReplayWebhookDeliveriesParams) (int64, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// DeleteFinishedWebhookDeliveries mocks the DeleteFinishedWebhookDeliveries method
+func (m *MockQueries) DeleteFinishedWebhookDeliveries(ctx context.Context, createdAt time.Time) (int64, error) {
+	args := m.Called(ctx, createdAt)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// DeleteDeviceToken mocks the DeleteDeviceToken method
+func (m *MockQueries) DeleteDeviceToken(ctx context.Context, arg database.DeleteDeviceTokenParams) (int64, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// DeleteSessionDeviceTokens mocks the DeleteSessionDeviceTokens method
+func (m *MockQueries) DeleteSessionDeviceTokens(ctx context.Context, arg database.DeleteSessionDeviceTokensParams) error {
+	args := m.Called(ctx, arg)
+	return args.Error(0)
+}
+
+// DeleteStaleDeviceTokens mocks the DeleteStaleDeviceTokens method
+func (m *MockQueries) DeleteStaleDeviceTokens(ctx context.Context, updatedBefore time.Time) (int64, error) {
+	args := m.Called(ctx, updatedBefore)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// DeleteUserDeviceTokens mocks the DeleteUserDeviceTokens method
+func (m *MockQueries) DeleteUserDeviceTokens(ctx context.Context, userID uuid.UUID) error {
+	args := m.Called(ctx, userID)
+	return args.Error(0)
+}
+
+// MoveSessionDeviceTokens mocks the MoveSessionDeviceTokens method
+func (m *MockQueries) MoveSessionDeviceTokens(ctx context.Context, arg database.MoveSessionDeviceTokensParams) error {
+	args := m.Called(ctx, arg)
+	return args.Error(0)
+}
+
+// UpsertDeviceToken mocks the UpsertDeviceToken method
+func (m *MockQueries) UpsertDeviceToken(ctx context.Context, arg database.UpsertDeviceTokenParams) (database.DeviceToken, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(database.DeviceToken), args.Error(1)
+}
+
+// CreateFollow mocks the CreateFollow method
+func (m *MockQueries) CreateFollow(ctx context.Context, arg database.CreateFollowParams) (database.Follow, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(database.Follow), args.Error(1)
+}
+
+// GetFollow mocks the GetFollow method
+func (m *MockQueries) GetFollow(ctx context.Context, arg database.GetFollowParams) (database.Follow, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(database.Follow), args.Error(1)
+}
+
+// DeleteFollow mocks the DeleteFollow method
+func (m *MockQueries) DeleteFollow(ctx context.Context, arg database.DeleteFollowParams) (int64, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// AcceptFollowRequest mocks the AcceptFollowRequest method
+func (m *MockQueries) AcceptFollowRequest(ctx context.Context, arg database.AcceptFollowRequestParams) (int64, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// DeleteFollowRequest mocks the DeleteFollowRequest method
+func (m *MockQueries) DeleteFollowRequest(ctx context.Context, arg database.DeleteFollowRequestParams) (int64, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// AcceptAllFollowRequests mocks the AcceptAllFollowRequests method
+func (m *MockQueries) AcceptAllFollowRequests(ctx context.Context, followeeID uuid.UUID) (int64, error) {
+	args := m.Called(ctx, followeeID)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// CountFollows mocks the CountFollows method
+func (m *MockQueries) CountFollows(ctx context.Context, userID uuid.UUID) (database.CountFollowsRow, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(database.CountFollowsRow), args.Error(1)
+}
+
+// ListFollowers mocks the ListFollowers method
+func (m *MockQueries) ListFollowers(ctx context.Context, arg database.ListFollowersParams) ([]database.ListFollowersRow, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).([]database.ListFollowersRow), args.Error(1)
+}
+
+// ListFollowing mocks the ListFollowing method
+func (m *MockQueries) ListFollowing(ctx context.Context, arg database.ListFollowingParams) ([]database.ListFollowingRow, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).([]database.ListFollowingRow), args.Error(1)
+}
+
+// ListUserFollows mocks the ListUserFollows method
+func (m *MockQueries) ListUserFollows(ctx context.Context, followerID uuid.UUID) ([]database.Follow, error) {
+	args := m.Called(ctx, followerID)
+	return args.Get(0).([]database.Follow), args.Error(1)
+}
+
+// SetUserPrivacy mocks the SetUserPrivacy method
+func (m *MockQueries) SetUserPrivacy(ctx context.Context, arg database.SetUserPrivacyParams) (database.User, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(database.User), args.Error(1)
+}
+
+// GetPlan mocks the GetPlan method
+func (m *MockQueries) GetPlan(ctx context.Context, id string) (database.Plan, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(database.Plan), args.Error(1)
+}
+
+// ListPlans mocks the ListPlans method
+func (m *MockQueries) ListPlans(ctx context.Context) ([]database.Plan, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]database.Plan), args.Error(1)
+}
+
+// CreateEntitlement mocks the CreateEntitlement method
+func (m *MockQueries) CreateEntitlement(ctx context.Context, arg database.CreateEntitlementParams) (database.UserEntitlement, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(database.UserEntitlement), args.Error(1)
+}
+
+// RevokeEntitlement mocks the RevokeEntitlement method
+func (m *MockQueries) RevokeEntitlement(ctx context.Context, id uuid.UUID) (database.UserEntitlement, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(database.UserEntitlement), args.Error(1)
+}
+
+// ListUserEntitlements mocks the ListUserEntitlements method
+func (m *MockQueries) ListUserEntitlements(ctx context.Context, userID uuid.UUID) ([]database.UserEntitlement, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]database.UserEntitlement), args.Error(1)
+}
+
+// UpsertBillingEntitlement mocks the UpsertBillingEntitlement method
+func (m *MockQueries) UpsertBillingEntitlement(ctx context.Context, arg database.UpsertBillingEntitlementParams) (database.UserEntitlement, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(database.UserEntitlement), args.Error(1)
+}
+
+// RecordBillingEvent mocks the RecordBillingEvent method
+func (m *MockQueries) RecordBillingEvent(ctx context.Context, arg database.RecordBillingEventParams) (int64, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+// SyncPremiumUsers mocks the SyncPremiumUsers method
+func (m *MockQueries) SyncPremiumUsers(ctx context.Context, userID uuid.NullUUID) ([]database.SyncPremiumUsersRow, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]database.SyncPremiumUsersRow), args.Error(1)
+}
+
+// AccountDeletionScheduled mocks the AccountDeletionScheduled method
+func (m *MockQueries) AccountDeletionScheduled(ctx context.Context, userID uuid.UUID) (bool, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(bool), args.Error(1)
+}
+
+// DeleteRefreshTokenFamily mocks the DeleteRefreshTokenFamily method
+func (m *MockQueries) DeleteRefreshTokenFamily(ctx context.Context, arg database.DeleteRefreshTokenFamilyParams) ([]string, error) {
+	args := m.Called(ctx, arg)
+	return args.Get(0).([]string), args.Error(1)
+}
+
+// RememberRotatedRefreshToken mocks the RememberRotatedRefreshToken method
+func (m *MockQueries) RememberRotatedRefreshToken(ctx context.Context, arg database.RememberRotatedRefreshTokenParams) error {
+	args := m.Called(ctx, arg)
+	return args.Error(0)
+}
+
+// GetRotatedRefreshToken mocks the GetRotatedRefreshToken method
+func (m *MockQueries) GetRotatedRefreshToken(ctx context.Context, tokenHash string) (database.RotatedRefreshToken, error) {
+	args := m.Called(ctx, tokenHash)
+	return args.Get(0).(database.RotatedRefreshToken), args.Error(1)
+}
+
+// DeleteExpiredRotatedRefreshTokens mocks the DeleteExpiredRotatedRefreshTokens method
+func (m *MockQueries) DeleteExpiredRotatedRefreshTokens(ctx context.Context) (int64, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(int64), args.Error(1)
+}
+
 // RefreshToken mocks the RefreshToken method
 func (m *MockQueries) RefreshToken(ctx context.Context, arg database.RefreshTokenParams) (database.RefreshToken, error) {
 	args := m.Called(ctx, arg)
@@ -66,3 +776,18 @@ func (m *MockQueries) DeleteRefreshTokenByToken(ctx context.Context, token strin
 	args := m.Called(ctx, token)
 	return args.Error(0)
 }
+
+// WithTx mocks the WithTx method by running fn against the mock itself, so the queries made inside
+// the transaction are matched against the same expectations. If an expectation for "WithTx" is set,
+// its error is returned before fn runs, which simulates a transaction that can't be started
+func (m *MockQueries) WithTx(ctx context.Context, fn func(database.DBQuerier) error) error {
+	for _, call := range m.ExpectedCalls {
+		if call.Method == "WithTx" {
+			if err := m.Called(ctx).Error(0); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	return fn(m)
+}
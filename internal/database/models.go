@@ -5,11 +5,59 @@
 package database
 
 import (
+	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+type AccountDeletion struct {
+	UserID      uuid.UUID
+	TokenHash   string
+	RequestedAt time.Time
+	PurgeAfter  time.Time
+}
+
+type AdminAuditLog struct {
+	ID           int64
+	Actor        string
+	Action       string
+	TargetUserID uuid.NullUUID
+	Details      json.RawMessage
+	CreatedAt    time.Time
+}
+
+type ApiKey struct {
+	ID         uuid.UUID
+	UserID     uuid.UUID
+	Name       string
+	KeyPrefix  string
+	KeyHash    string
+	Scopes     []string
+	ExpiresAt  sql.NullTime
+	LastUsedAt sql.NullTime
+	CreatedAt  time.Time
+}
+
+type AuthEvent struct {
+	ID        int64
+	UserID    uuid.NullUUID
+	Type      string
+	Actor     string
+	IpAddress string
+	UserAgent string
+	SessionID string
+	Details   json.RawMessage
+	CreatedAt time.Time
+}
+
+type BillingEvent struct {
+	ID         string
+	Type       string
+	ReceivedAt time.Time
+}
+
 type Comment struct {
 	ID          uuid.UUID
 	CreatedAt   time.Time
@@ -18,6 +66,20 @@ type Comment struct {
 	CommentText string
 }
 
+type DataExport struct {
+	ID          uuid.UUID
+	UserID      uuid.UUID
+	Status      string
+	Attempts    int32
+	StorageKey  string
+	SizeBytes   int64
+	Error       string
+	CreatedAt   time.Time
+	StartedAt   sql.NullTime
+	CompletedAt sql.NullTime
+	ExpiresAt   sql.NullTime
+}
+
 type DeviceToken struct {
 	ID          uuid.UUID
 	UserID      uuid.UUID
@@ -25,6 +87,47 @@ type DeviceToken struct {
 	DeviceType  string
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
+	SessionID   string
+}
+
+type EmailHold struct {
+	UserID      uuid.UUID
+	Email       string
+	WasVerified bool
+	ChangedTo   string
+	TokenHash   string
+	ExpiresAt   time.Time
+	CreatedAt   time.Time
+}
+
+type EventsOutbox struct {
+	ID            int64
+	EventID       uuid.UUID
+	EventType     string
+	UserID        uuid.UUID
+	Payload       []byte
+	CreatedAt     time.Time
+	Attempts      int32
+	LastError     string
+	NextAttemptAt time.Time
+	PublishedAt   sql.NullTime
+}
+
+type Follow struct {
+	FollowerID uuid.UUID
+	FolloweeID uuid.UUID
+	Status     string
+	CreatedAt  time.Time
+	AcceptedAt sql.NullTime
+}
+
+type KnownDevice struct {
+	UserID      uuid.UUID
+	Fingerprint string
+	UserAgent   string
+	IpPrefix    string
+	FirstSeenAt time.Time
+	LastSeenAt  time.Time
 }
 
 type Message struct {
@@ -35,6 +138,37 @@ type Message struct {
 	Content    string
 }
 
+type OauthClient struct {
+	ID           string
+	SecretHash   string
+	Name         string
+	RedirectUris []string
+	Scopes       []string
+	Trusted      bool
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+type OauthConsent struct {
+	UserID    uuid.UUID
+	ClientID  string
+	Scopes    []string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type Permission struct {
+	Name        string
+	Description string
+}
+
+type Plan struct {
+	ID        string
+	Name      string
+	Premium   bool
+	CreatedAt time.Time
+}
+
 type Post struct {
 	ID        uuid.UUID
 	CreatedAt time.Time
@@ -51,6 +185,8 @@ type RefreshToken struct {
 	UserID     uuid.UUID
 	ExpiryTime time.Time
 	CreatedAt  time.Time
+	SessionID  string
+	FamilyID   string
 }
 
 type Report struct {
@@ -60,17 +196,128 @@ type Report struct {
 	Reason     string
 }
 
+type Role struct {
+	Name        string
+	Description string
+	CreatedAt   time.Time
+}
+
+type RolePermission struct {
+	Role       string
+	Permission string
+}
+
+type RotatedRefreshToken struct {
+	TokenHash string
+	UserID    uuid.UUID
+	FamilyID  string
+	ExpiresAt time.Time
+}
+
+type ServiceClient struct {
+	ID                      string
+	Name                    string
+	SecretHash              string
+	PreviousSecretHash      string
+	PreviousSecretExpiresAt sql.NullTime
+	Scopes                  []string
+	Audiences               []string
+	CreatedAt               time.Time
+	UpdatedAt               time.Time
+}
+
 type User struct {
-	ID                     uuid.UUID
-	CreatedAt              time.Time
-	UpdatedAt              time.Time
-	Email                  string
-	Password               string
-	Username               string
-	Subscribers            []uuid.UUID
-	SubscribedTo           []uuid.UUID
-	IsPremium              bool
-	VerificationCode       int32
-	VerificationExpireTime time.Time
-	IsVerified             bool
+	ID              uuid.UUID
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	Email           string
+	Password        string
+	Username        string
+	IsPremium       bool
+	IsVerified      bool
+	StatusChangedAt sql.NullTime
+	StatusReason    string
+	Status          string
+	StatusUntil     sql.NullTime
+	EmailCanonical  sql.NullString
+	IsPrivate       bool
+}
+
+type UsernameChange struct {
+	ID          int64
+	UserID      uuid.UUID
+	OldUsername string
+	NewUsername string
+	ChangedAt   time.Time
+	HeldUntil   time.Time
+}
+
+type UserIdentity struct {
+	Provider  string
+	Subject   string
+	UserID    uuid.UUID
+	Email     string
+	CreatedAt time.Time
+}
+
+type UserRole struct {
+	UserID    uuid.UUID
+	Role      string
+	GrantedBy uuid.NullUUID
+	CreatedAt time.Time
+}
+
+type UserEntitlement struct {
+	ID              uuid.UUID
+	UserID          uuid.UUID
+	PlanID          string
+	Source          string
+	ExternalID      sql.NullString
+	StartsAt        time.Time
+	ExpiresAt       sql.NullTime
+	RevokedAt       sql.NullTime
+	GrantedBy       string
+	SourceUpdatedAt sql.NullTime
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+type VerificationCode struct {
+	UserID     uuid.UUID
+	Purpose    string
+	CodeHash   string
+	ExpiresAt  time.Time
+	Attempts   int32
+	CreatedAt  time.Time
+	DeviceHash string
+	NewEmail   string
+}
+
+type WebhookDelivery struct {
+	ID             int64
+	EndpointID     uuid.UUID
+	EventID        uuid.UUID
+	EventType      string
+	Payload        []byte
+	Status         string
+	Attempts       int32
+	NextAttemptAt  time.Time
+	LastAttemptAt  sql.NullTime
+	ResponseStatus int32
+	LastError      string
+	CreatedAt      time.Time
+	DeliveredAt    sql.NullTime
+}
+
+type WebhookEndpoint struct {
+	ID                  uuid.UUID
+	Url                 string
+	Secret              string
+	Description         string
+	EventTypes          []string
+	CreatedBy           string
+	CreatedAt           time.Time
+	ConsecutiveFailures int32
+	DisabledAt          sql.NullTime
+	DisabledReason      string
 }
@@ -12,6 +12,63 @@ import (
 	"github.com/google/uuid"
 )
 
+const deleteExpiredRotatedRefreshTokens = `-- name: DeleteExpiredRotatedRefreshTokens :execrows
+DELETE FROM rotated_refresh_tokens
+WHERE expires_at <= NOW()
+`
+
+func (q *Queries) DeleteExpiredRotatedRefreshTokens(ctx context.Context) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteExpiredRotatedRefreshTokens)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteRefreshTokenByToken = `-- name: DeleteRefreshTokenByToken :exec
+DELETE FROM refresh_tokens
+WHERE token = $1
+`
+
+func (q *Queries) DeleteRefreshTokenByToken(ctx context.Context, token string) error {
+	_, err := q.db.ExecContext(ctx, deleteRefreshTokenByToken, token)
+	return err
+}
+
+const deleteRefreshTokenFamily = `-- name: DeleteRefreshTokenFamily :many
+DELETE FROM refresh_tokens
+WHERE user_id = $1 AND family_id = $2
+RETURNING session_id
+`
+
+type DeleteRefreshTokenFamilyParams struct {
+	UserID   uuid.UUID
+	FamilyID string
+}
+
+func (q *Queries) DeleteRefreshTokenFamily(ctx context.Context, arg DeleteRefreshTokenFamilyParams) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, deleteRefreshTokenFamily, arg.UserID, arg.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var session_id string
+		if err := rows.Scan(&session_id); err != nil {
+			return nil, err
+		}
+		items = append(items, session_id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const deleteTokenByUserID = `-- name: DeleteTokenByUserID :exec
 DELETE FROM refresh_tokens
 WHERE user_id = $1
@@ -23,7 +80,7 @@ func (q *Queries) DeleteTokenByUserID(ctx context.Context, userID uuid.UUID) err
 }
 
 const getRefreshToken = `-- name: GetRefreshToken :one
-SELECT token, user_id, expiry_time, created_at FROM refresh_tokens
+SELECT token, user_id, expiry_time, created_at, session_id, family_id FROM refresh_tokens
 WHERE token = $1
 `
 
@@ -35,34 +92,124 @@ func (q *Queries) GetRefreshToken(ctx context.Context, token string) (RefreshTok
 		&i.UserID,
 		&i.ExpiryTime,
 		&i.CreatedAt,
+		&i.SessionID,
+		&i.FamilyID,
+	)
+	return i, err
+}
+
+const getRotatedRefreshToken = `-- name: GetRotatedRefreshToken :one
+SELECT token_hash, user_id, family_id, expires_at FROM rotated_refresh_tokens
+WHERE token_hash = $1 AND expires_at > NOW()
+`
+
+func (q *Queries) GetRotatedRefreshToken(ctx context.Context, tokenHash string) (RotatedRefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, getRotatedRefreshToken, tokenHash)
+	var i RotatedRefreshToken
+	err := row.Scan(
+		&i.TokenHash,
+		&i.UserID,
+		&i.FamilyID,
+		&i.ExpiresAt,
 	)
 	return i, err
 }
 
+const listRefreshTokens = `-- name: ListRefreshTokens :many
+SELECT token, user_id, expiry_time, created_at, session_id, family_id FROM refresh_tokens
+WHERE user_id = $1
+ORDER BY created_at
+`
+
+func (q *Queries) ListRefreshTokens(ctx context.Context, userID uuid.UUID) ([]RefreshToken, error) {
+	rows, err := q.db.QueryContext(ctx, listRefreshTokens, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []RefreshToken
+	for rows.Next() {
+		var i RefreshToken
+		if err := rows.Scan(
+			&i.Token,
+			&i.UserID,
+			&i.ExpiryTime,
+			&i.CreatedAt,
+			&i.SessionID,
+			&i.FamilyID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const refreshToken = `-- name: RefreshToken :one
-INSERT INTO refresh_tokens (token, user_id, expiry_time) 
+INSERT INTO refresh_tokens (token, user_id, expiry_time, session_id, family_id) 
 VALUES (
    $1, 
    $2, 
-   $3
+   $3,
+   $4,
+   $5
 )
-RETURNING token, user_id, expiry_time, created_at
+RETURNING token, user_id, expiry_time, created_at, session_id, family_id
 `
 
 type RefreshTokenParams struct {
 	Token      string
 	UserID     uuid.UUID
 	ExpiryTime time.Time
+	SessionID  string
+	FamilyID   string
 }
 
 func (q *Queries) RefreshToken(ctx context.Context, arg RefreshTokenParams) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, refreshToken, arg.Token, arg.UserID, arg.ExpiryTime)
+	row := q.db.QueryRowContext(ctx, refreshToken,
+		arg.Token,
+		arg.UserID,
+		arg.ExpiryTime,
+		arg.SessionID,
+		arg.FamilyID,
+	)
 	var i RefreshToken
 	err := row.Scan(
 		&i.Token,
 		&i.UserID,
 		&i.ExpiryTime,
 		&i.CreatedAt,
+		&i.SessionID,
+		&i.FamilyID,
 	)
 	return i, err
 }
+
+const rememberRotatedRefreshToken = `-- name: RememberRotatedRefreshToken :exec
+INSERT INTO rotated_refresh_tokens (token_hash, user_id, family_id, expires_at)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (token_hash) DO NOTHING
+`
+
+type RememberRotatedRefreshTokenParams struct {
+	TokenHash string
+	UserID    uuid.UUID
+	FamilyID  string
+	ExpiresAt time.Time
+}
+
+func (q *Queries) RememberRotatedRefreshToken(ctx context.Context, arg RememberRotatedRefreshTokenParams) error {
+	_, err := q.db.ExecContext(ctx, rememberRotatedRefreshToken,
+		arg.TokenHash,
+		arg.UserID,
+		arg.FamilyID,
+		arg.ExpiresAt,
+	)
+	return err
+}
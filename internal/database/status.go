@@ -0,0 +1,33 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Account statuses, stored in users.status. Only active users can log in
+const (
+	StatusActive    = "active"
+	StatusSuspended = "suspended"
+	StatusBanned    = "banned"
+	StatusDeleted   = "deleted"
+)
+
+// EffectiveStatus is the status of an account at now. A timed suspension counts as lifted as soon as it ends,
+// even before LiftExpiredSuspensions has updated the row
+func EffectiveStatus(status string, until sql.NullTime, now time.Time) string {
+	if status == StatusSuspended && until.Valid && !now.Before(until.Time) {
+		return StatusActive
+	}
+	return status
+}
+
+// CanLogIn reports whether an account with status may log in and use its tokens at now
+func CanLogIn(status string, until sql.NullTime, now time.Time) bool {
+	switch EffectiveStatus(status, until, now) {
+	case StatusSuspended, StatusBanned, StatusDeleted:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,43 @@
+package database
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEffectiveStatus(t *testing.T) {
+	now := time.Now()
+
+	testCases := []struct {
+		name     string
+		status   string
+		until    sql.NullTime
+		expected string
+	}{
+		{"active", StatusActive, sql.NullTime{}, StatusActive},
+		{"suspended until lifted", StatusSuspended, sql.NullTime{}, StatusSuspended},
+		{"suspension still running", StatusSuspended, sql.NullTime{Time: now.Add(time.Minute), Valid: true}, StatusSuspended},
+		{"suspension ended", StatusSuspended, sql.NullTime{Time: now, Valid: true}, StatusActive},
+		{"banned", StatusBanned, sql.NullTime{Time: now.Add(-time.Minute), Valid: true}, StatusBanned},
+		{"deleted", StatusDeleted, sql.NullTime{}, StatusDeleted},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, EffectiveStatus(tc.status, tc.until, now))
+		})
+	}
+}
+
+func TestCanLogIn(t *testing.T) {
+	now := time.Now()
+
+	assert.True(t, CanLogIn(StatusActive, sql.NullTime{}, now))
+	assert.True(t, CanLogIn(StatusSuspended, sql.NullTime{Time: now.Add(-time.Second), Valid: true}, now))
+	assert.False(t, CanLogIn(StatusSuspended, sql.NullTime{Time: now.Add(time.Hour), Valid: true}, now))
+	assert.False(t, CanLogIn(StatusBanned, sql.NullTime{}, now))
+	assert.False(t, CanLogIn(StatusDeleted, sql.NullTime{}, now))
+}
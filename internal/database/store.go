@@ -0,0 +1,222 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DBQuerier defines the database operations used by the auth service.
+// WithTx runs several of them atomically
+type DBQuerier interface {
+	CreateUser(ctx context.Context, arg CreateUserParams) (User, error)
+	GetUserByIdentifier(ctx context.Context, arg GetUserByIdentifierParams) (User, error)
+	GetUserByID(ctx context.Context, id uuid.UUID) (User, error)
+	VerifyUser(ctx context.Context, emailCanonical string) (uuid.UUID, error)
+	ListUsers(ctx context.Context, arg ListUsersParams) ([]User, error)
+	UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error)
+	SetUserStatus(ctx context.Context, arg SetUserStatusParams) (User, error)
+	SetUserPrivacy(ctx context.Context, arg SetUserPrivacyParams) (User, error)
+	GetUserStatus(ctx context.Context, id uuid.UUID) (GetUserStatusRow, error)
+	LiftExpiredSuspensions(ctx context.Context) (int64, error)
+	CreateUserIdentity(ctx context.Context, arg CreateUserIdentityParams) (UserIdentity, error)
+	GetUserIdentity(ctx context.Context, arg GetUserIdentityParams) (UserIdentity, error)
+	UpsertVerificationCode(ctx context.Context, arg UpsertVerificationCodeParams) (VerificationCode, error)
+	GetVerificationCode(ctx context.Context, arg GetVerificationCodeParams) (VerificationCode, error)
+	IncrementVerificationCodeAttempts(ctx context.Context, arg IncrementVerificationCodeAttemptsParams) (int32, error)
+	ConsumeVerificationCode(ctx context.Context, arg ConsumeVerificationCodeParams) (int64, error)
+	DeleteVerificationCode(ctx context.Context, arg DeleteVerificationCodeParams) error
+	DeleteVerificationCodeByEmail(ctx context.Context, arg DeleteVerificationCodeByEmailParams) error
+	CreateOAuthClient(ctx context.Context, arg CreateOAuthClientParams) (OauthClient, error)
+	GetOAuthClient(ctx context.Context, id string) (OauthClient, error)
+	ListOAuthClients(ctx context.Context) ([]OauthClient, error)
+	DeleteOAuthClient(ctx context.Context, id string) (int64, error)
+	GetOAuthConsent(ctx context.Context, arg GetOAuthConsentParams) (OauthConsent, error)
+	UpsertOAuthConsent(ctx context.Context, arg UpsertOAuthConsentParams) error
+	CreateServiceClient(ctx context.Context, arg CreateServiceClientParams) (ServiceClient, error)
+	GetServiceClient(ctx context.Context, id string) (ServiceClient, error)
+	ListServiceClients(ctx context.Context) ([]ServiceClient, error)
+	RotateServiceClientSecret(ctx context.Context, arg RotateServiceClientSecretParams) (ServiceClient, error)
+	DeleteServiceClient(ctx context.Context, id string) (int64, error)
+	CreateAPIKey(ctx context.Context, arg CreateAPIKeyParams) (ApiKey, error)
+	ListAPIKeys(ctx context.Context, userID uuid.UUID) ([]ApiKey, error)
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (ApiKey, error)
+	TouchAPIKey(ctx context.Context, id uuid.UUID) error
+	DeleteAPIKey(ctx context.Context, arg DeleteAPIKeyParams) (int64, error)
+	GetRole(ctx context.Context, name string) (Role, error)
+	ListRoles(ctx context.Context) ([]ListRolesRow, error)
+	ListUserRoles(ctx context.Context, userID uuid.UUID) ([]ListUserRolesRow, error)
+	GetUserAuthorization(ctx context.Context, userID uuid.UUID) (GetUserAuthorizationRow, error)
+	AssignRole(ctx context.Context, arg AssignRoleParams) (int64, error)
+	RevokeRole(ctx context.Context, arg RevokeRoleParams) (int64, error)
+	CreateAdminAuditEntry(ctx context.Context, arg CreateAdminAuditEntryParams) error
+	CreateAuthEvent(ctx context.Context, arg CreateAuthEventParams) error
+	ListAuthEvents(ctx context.Context, arg ListAuthEventsParams) ([]AuthEvent, error)
+	DeleteAuthEventsBefore(ctx context.Context, createdAt time.Time) (int64, error)
+	RememberDevice(ctx context.Context, arg RememberDeviceParams) (RememberDeviceRow, error)
+	SetUserPassword(ctx context.Context, arg SetUserPasswordParams) error
+	ChangeUserEmail(ctx context.Context, arg ChangeUserEmailParams) (User, error)
+	HoldEmail(ctx context.Context, arg HoldEmailParams) (int64, error)
+	TakeEmailHold(ctx context.Context, tokenHash string) (EmailHold, error)
+	DeleteExpiredEmailHolds(ctx context.Context) (int64, error)
+	UsernameAvailable(ctx context.Context, arg UsernameAvailableParams) (bool, error)
+	SetUsername(ctx context.Context, arg SetUsernameParams) (User, error)
+	CreateUsernameChange(ctx context.Context, arg CreateUsernameChangeParams) error
+	GetLastUsernameChange(ctx context.Context, userID uuid.UUID) (time.Time, error)
+	ScheduleAccountDeletion(ctx context.Context, arg ScheduleAccountDeletionParams) error
+	TakeAccountDeletion(ctx context.Context, tokenHash string) (AccountDeletion, error)
+	ListDueAccountDeletions(ctx context.Context, limit int32) ([]uuid.UUID, error)
+	ClaimAccountDeletion(ctx context.Context, userID uuid.UUID) (int64, error)
+	DeleteUserPersonalData(ctx context.Context, userID uuid.UUID) error
+	DeleteUserContent(ctx context.Context, userID uuid.UUID) error
+	DeleteUser(ctx context.Context, id uuid.UUID) (int64, error)
+	AnonymizeUser(ctx context.Context, arg AnonymizeUserParams) (User, error)
+	CreateDataExport(ctx context.Context, arg CreateDataExportParams) (DataExport, error)
+	GetActiveDataExport(ctx context.Context, userID uuid.UUID) (DataExport, error)
+	GetDataExport(ctx context.Context, arg GetDataExportParams) (DataExport, error)
+	ClaimDataExport(ctx context.Context, arg ClaimDataExportParams) (DataExport, error)
+	CompleteDataExport(ctx context.Context, arg CompleteDataExportParams) error
+	FailDataExport(ctx context.Context, arg FailDataExportParams) error
+	FailAbandonedDataExports(ctx context.Context, arg FailAbandonedDataExportsParams) (int64, error)
+	ListExpiredDataExports(ctx context.Context, limit int32) ([]DataExport, error)
+	ExpireDataExport(ctx context.Context, id uuid.UUID) error
+	ListDeviceTokens(ctx context.Context, userID uuid.UUID) ([]DeviceToken, error)
+	ListRefreshTokens(ctx context.Context, userID uuid.UUID) ([]RefreshToken, error)
+	ListUserIdentities(ctx context.Context, userID uuid.UUID) ([]UserIdentity, error)
+	ExpireUserDataExports(ctx context.Context, userID uuid.UUID) ([]string, error)
+	CreateOutboxEvent(ctx context.Context, arg CreateOutboxEventParams) error
+	ClaimOutboxEvents(ctx context.Context, arg ClaimOutboxEventsParams) ([]EventsOutbox, error)
+	MarkOutboxEventPublished(ctx context.Context, id int64) error
+	RetryOutboxEvent(ctx context.Context, arg RetryOutboxEventParams) error
+	DeletePublishedOutboxEvents(ctx context.Context, publishedAt sql.NullTime) (int64, error)
+	CreateWebhookEndpoint(ctx context.Context, arg CreateWebhookEndpointParams) (WebhookEndpoint, error)
+	ListWebhookEndpoints(ctx context.Context) ([]WebhookEndpoint, error)
+	DeleteWebhookEndpoint(ctx context.Context, id uuid.UUID) (int64, error)
+	EnableWebhookEndpoint(ctx context.Context, id uuid.UUID) (int64, error)
+	RecordWebhookEndpointSuccess(ctx context.Context, id uuid.UUID) error
+	RecordWebhookEndpointFailure(ctx context.Context, arg RecordWebhookEndpointFailureParams) (WebhookEndpoint, error)
+	CreateWebhookDeliveries(ctx context.Context, arg CreateWebhookDeliveriesParams) error
+	ClaimWebhookDeliveries(ctx context.Context, arg ClaimWebhookDeliveriesParams) ([]ClaimWebhookDeliveriesRow, error)
+	CompleteWebhookDelivery(ctx context.Context, arg CompleteWebhookDeliveryParams) error
+	RetryWebhookDelivery(ctx context.Context, arg RetryWebhookDeliveryParams) error
+	FailWebhookDelivery(ctx context.Context, arg FailWebhookDeliveryParams) error
+	ListWebhookDeliveries(ctx context.Context, arg ListWebhookDeliveriesParams) ([]WebhookDelivery, error)
+	ReplayWebhookDeliveries(ctx context.Context, arg ReplayWebhookDeliveriesParams) (int64, error)
+	DeleteFinishedWebhookDeliveries(ctx context.Context, createdAt time.Time) (int64, error)
+	DeleteDeviceToken(ctx context.Context, arg DeleteDeviceTokenParams) (int64, error)
+	DeleteSessionDeviceTokens(ctx context.Context, arg DeleteSessionDeviceTokensParams) error
+	DeleteStaleDeviceTokens(ctx context.Context, updatedBefore time.Time) (int64, error)
+	DeleteUserDeviceTokens(ctx context.Context, userID uuid.UUID) error
+	MoveSessionDeviceTokens(ctx context.Context, arg MoveSessionDeviceTokensParams) error
+	UpsertDeviceToken(ctx context.Context, arg UpsertDeviceTokenParams) (DeviceToken, error)
+	CreateFollow(ctx context.Context, arg CreateFollowParams) (Follow, error)
+	GetFollow(ctx context.Context, arg GetFollowParams) (Follow, error)
+	DeleteFollow(ctx context.Context, arg DeleteFollowParams) (int64, error)
+	AcceptFollowRequest(ctx context.Context, arg AcceptFollowRequestParams) (int64, error)
+	DeleteFollowRequest(ctx context.Context, arg DeleteFollowRequestParams) (int64, error)
+	AcceptAllFollowRequests(ctx context.Context, followeeID uuid.UUID) (int64, error)
+	CountFollows(ctx context.Context, userID uuid.UUID) (CountFollowsRow, error)
+	ListFollowers(ctx context.Context, arg ListFollowersParams) ([]ListFollowersRow, error)
+	ListFollowing(ctx context.Context, arg ListFollowingParams) ([]ListFollowingRow, error)
+	ListUserFollows(ctx context.Context, followerID uuid.UUID) ([]Follow, error)
+	GetPlan(ctx context.Context, id string) (Plan, error)
+	ListPlans(ctx context.Context) ([]Plan, error)
+	CreateEntitlement(ctx context.Context, arg CreateEntitlementParams) (UserEntitlement, error)
+	RevokeEntitlement(ctx context.Context, id uuid.UUID) (UserEntitlement, error)
+	ListUserEntitlements(ctx context.Context, userID uuid.UUID) ([]UserEntitlement, error)
+	UpsertBillingEntitlement(ctx context.Context, arg UpsertBillingEntitlementParams) (UserEntitlement, error)
+	RecordBillingEvent(ctx context.Context, arg RecordBillingEventParams) (int64, error)
+	SyncPremiumUsers(ctx context.Context, userID uuid.NullUUID) ([]SyncPremiumUsersRow, error)
+	AccountDeletionScheduled(ctx context.Context, userID uuid.UUID) (bool, error)
+	DeleteRefreshTokenFamily(ctx context.Context, arg DeleteRefreshTokenFamilyParams) ([]string, error)
+	RememberRotatedRefreshToken(ctx context.Context, arg RememberRotatedRefreshTokenParams) error
+	GetRotatedRefreshToken(ctx context.Context, tokenHash string) (RotatedRefreshToken, error)
+	DeleteExpiredRotatedRefreshTokens(ctx context.Context) (int64, error)
+	RefreshToken(ctx context.Context, arg RefreshTokenParams) (RefreshToken, error)
+	GetRefreshToken(ctx context.Context, token string) (RefreshToken, error)
+	DeleteTokenByUserID(ctx context.Context, userID uuid.UUID) error
+	DeleteRefreshTokenByToken(ctx context.Context, token string) error
+
+	// WithTx runs fn inside a single transaction. The transaction is committed when fn returns nil
+	// and rolled back otherwise. fn may be run again when the transaction hits a serialization failure,
+	// so it must not have side effects outside of the database
+	WithTx(ctx context.Context, fn func(DBQuerier) error) error
+}
+
+const (
+	// maxTxAttempts is how many times a transaction is tried before a serialization failure is returned
+	maxTxAttempts = 3
+	// txRetryBackoff is the base delay between transaction attempts, multiplied by the attempt number
+	txRetryBackoff = 20 * time.Millisecond
+)
+
+// Store implements DBQuerier on top of the sqlc generated Queries
+type Store struct {
+	*Queries
+	db *sql.DB
+	tx *sql.Tx
+}
+
+// NewStore creates a Store running queries against db
+func NewStore(db *sql.DB) *Store {
+	return &Store{
+		Queries: New(db),
+		db:      db,
+	}
+}
+
+// WithTx runs fn in a serializable transaction and retries it on serialization failures and deadlocks.
+// Calling WithTx on the store passed to fn joins the already running transaction
+func (s *Store) WithTx(ctx context.Context, fn func(DBQuerier) error) error {
+	if s.tx != nil {
+		return fn(s)
+	}
+
+	var err error
+	for attempt := 1; attempt <= maxTxAttempts; attempt++ {
+		err = s.execTx(ctx, fn)
+		if err == nil || !IsSerializationFailure(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt) * txRetryBackoff):
+		}
+	}
+
+	return fmt.Errorf("transaction failed after %d attempts: %w", maxTxAttempts, err)
+}
+
+func (s *Store) execTx(ctx context.Context, fn func(DBQuerier) error) (err error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	txStore := &Store{
+		Queries: s.Queries.WithTx(tx),
+		db:      s.db,
+		tx:      tx,
+	}
+
+	if err := fn(txStore); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("%w (rollback failed: %v)", err, rbErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMockStore(t *testing.T) (*Store, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return NewStore(db), mock
+}
+
+func TestWithTxCommits(t *testing.T) {
+	store, mock := newMockStore(t)
+	userID := uuid.New()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM refresh_tokens").WithArgs(userID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := store.WithTx(context.Background(), func(q DBQuerier) error {
+		return q.DeleteTokenByUserID(context.Background(), userID)
+	})
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWithTxRollsBackOnError(t *testing.T) {
+	store, mock := newMockStore(t)
+	fnErr := errors.New("boom")
+
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	err := store.WithTx(context.Background(), func(q DBQuerier) error {
+		return fnErr
+	})
+
+	assert.ErrorIs(t, err, fnErr)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWithTxRetriesSerializationFailures(t *testing.T) {
+	store, mock := newMockStore(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM refresh_tokens").WillReturnError(&pq.Error{Code: serializationFailure})
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM refresh_tokens").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	attempts := 0
+	err := store.WithTx(context.Background(), func(q DBQuerier) error {
+		attempts++
+		return q.DeleteRefreshTokenByToken(context.Background(), "token")
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWithTxGivesUpAfterMaxAttempts(t *testing.T) {
+	store, mock := newMockStore(t)
+
+	for i := 0; i < maxTxAttempts; i++ {
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+	}
+
+	err := store.WithTx(context.Background(), func(q DBQuerier) error {
+		return &pq.Error{Code: deadlockDetected}
+	})
+
+	assert.True(t, IsSerializationFailure(err))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWithTxJoinsRunningTransaction(t *testing.T) {
+	store, mock := newMockStore(t)
+
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+
+	err := store.WithTx(context.Background(), func(q DBQuerier) error {
+		return q.WithTx(context.Background(), func(inner DBQuerier) error {
+			assert.Same(t, q, inner)
+			return nil
+		})
+	})
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestIsUniqueViolation(t *testing.T) {
+	err := &pq.Error{Code: uniqueViolation, Constraint: "users_email_key"}
+
+	assert.True(t, IsUniqueViolation(err, "users_email_key"))
+	assert.True(t, IsUniqueViolation(err, ""))
+	assert.False(t, IsUniqueViolation(err, "other_key"))
+	assert.False(t, IsUniqueViolation(sql.ErrNoRows, ""))
+}
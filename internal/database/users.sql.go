@@ -7,14 +7,60 @@ package database
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/google/uuid"
-	"github.com/lib/pq"
 )
 
+const changeUserEmail = `-- name: ChangeUserEmail :one
+UPDATE users
+SET email = $2, email_canonical = $4::text, is_verified = $3, updated_at = NOW()
+WHERE id = $1
+  AND NOT EXISTS (
+      SELECT 1 FROM email_holds h
+      WHERE lower(h.email) = $4::text AND h.user_id <> $1 AND h.expires_at > NOW()
+  )
+RETURNING id, created_at, updated_at, email, password, username, is_premium, is_verified, status_changed_at, status_reason, status, status_until, email_canonical, is_private
+`
+
+type ChangeUserEmailParams struct {
+	ID             uuid.UUID
+	Email          string
+	IsVerified     bool
+	EmailCanonical string
+}
+
+// No row is returned when another user holds the address
+func (q *Queries) ChangeUserEmail(ctx context.Context, arg ChangeUserEmailParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, changeUserEmail,
+		arg.ID,
+		arg.Email,
+		arg.IsVerified,
+		arg.EmailCanonical,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.Password,
+		&i.Username,
+		&i.IsPremium,
+		&i.IsVerified,
+		&i.StatusChangedAt,
+		&i.StatusReason,
+		&i.Status,
+		&i.StatusUntil,
+		&i.EmailCanonical,
+		&i.IsPrivate,
+	)
+	return i, err
+}
+
 const createUser = `-- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, password, username, is_premium, verification_code, is_verified)
-VALUES (
+INSERT INTO users (id, created_at, updated_at, email, password, username, is_premium, is_verified, email_canonical)
+SELECT
    $1,
    NOW(),
    NOW(),
@@ -23,21 +69,22 @@ VALUES (
    $4,
    $5,
    $6,
-   $7
-)
-RETURNING id, created_at, updated_at, email, password, username, subscribers, subscribed_to, is_premium, verification_code, verification_expire_time, is_verified
+   $7::text
+WHERE NOT EXISTS (SELECT 1 FROM email_holds WHERE lower(email) = $7::text AND expires_at > NOW())
+RETURNING id, created_at, updated_at, email, password, username, is_premium, is_verified, status_changed_at, status_reason, status, status_until, email_canonical, is_private
 `
 
 type CreateUserParams struct {
-	ID               uuid.UUID
-	Email            string
-	Password         string
-	Username         string
-	IsPremium        bool
-	VerificationCode int32
-	IsVerified       bool
+	ID             uuid.UUID
+	Email          string
+	Password       string
+	Username       string
+	IsPremium      bool
+	IsVerified     bool
+	EmailCanonical string
 }
 
+// Addresses held for the undo link of an email change can't be registered, no row is returned for them
 func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
 	row := q.db.QueryRowContext(ctx, createUser,
 		arg.ID,
@@ -45,8 +92,8 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		arg.Password,
 		arg.Username,
 		arg.IsPremium,
-		arg.VerificationCode,
 		arg.IsVerified,
+		arg.EmailCanonical,
 	)
 	var i User
 	err := row.Scan(
@@ -56,28 +103,31 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Email,
 		&i.Password,
 		&i.Username,
-		pq.Array(&i.Subscribers),
-		pq.Array(&i.SubscribedTo),
 		&i.IsPremium,
-		&i.VerificationCode,
-		&i.VerificationExpireTime,
 		&i.IsVerified,
+		&i.StatusChangedAt,
+		&i.StatusReason,
+		&i.Status,
+		&i.StatusUntil,
+		&i.EmailCanonical,
+		&i.IsPrivate,
 	)
 	return i, err
 }
 
 const getUserByIdentifier = `-- name: GetUserByIdentifier :one
-SELECT id, created_at, updated_at, email, password, username, subscribers, subscribed_to, is_premium, verification_code, verification_expire_time, is_verified FROM users
-WHERE email = $1 OR username = $2
+SELECT id, created_at, updated_at, email, password, username, is_premium, is_verified, status_changed_at, status_reason, status, status_until, email_canonical, is_private FROM users
+WHERE email_canonical = $1::text OR ($2 <> '' AND lower(username) = lower($2))
 `
 
 type GetUserByIdentifierParams struct {
-	Email    string
-	Username string
+	EmailCanonical string
+	Username       string
 }
 
+// Emails match by their canonical form and usernames regardless of case. An empty username matches nobody
 func (q *Queries) GetUserByIdentifier(ctx context.Context, arg GetUserByIdentifierParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, getUserByIdentifier, arg.Email, arg.Username)
+	row := q.db.QueryRowContext(ctx, getUserByIdentifier, arg.EmailCanonical, arg.Username)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -86,55 +136,358 @@ func (q *Queries) GetUserByIdentifier(ctx context.Context, arg GetUserByIdentifi
 		&i.Email,
 		&i.Password,
 		&i.Username,
-		pq.Array(&i.Subscribers),
-		pq.Array(&i.SubscribedTo),
 		&i.IsPremium,
-		&i.VerificationCode,
-		&i.VerificationExpireTime,
 		&i.IsVerified,
+		&i.StatusChangedAt,
+		&i.StatusReason,
+		&i.Status,
+		&i.StatusUntil,
+		&i.EmailCanonical,
+		&i.IsPrivate,
 	)
 	return i, err
 }
 
-const sendVerifyCodeAgain = `-- name: SendVerifyCodeAgain :exec
+const getUserByID = `-- name: GetUserByID :one
+SELECT id, created_at, updated_at, email, password, username, is_premium, is_verified, status_changed_at, status_reason, status, status_until, email_canonical, is_private FROM users
+WHERE id = $1
+`
+
+func (q *Queries) GetUserByID(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByID, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.Password,
+		&i.Username,
+		&i.IsPremium,
+		&i.IsVerified,
+		&i.StatusChangedAt,
+		&i.StatusReason,
+		&i.Status,
+		&i.StatusUntil,
+		&i.EmailCanonical,
+		&i.IsPrivate,
+	)
+	return i, err
+}
+
+const verifyUser = `-- name: VerifyUser :one
 UPDATE users 
-SET verification_code = $1, is_verified = FALSE
-WHERE id = $2
+SET is_verified = TRUE, updated_at = NOW()
+WHERE email_canonical = $1::text AND NOT is_verified
+RETURNING id
 `
 
-type SendVerifyCodeAgainParams struct {
-	VerificationCode int32
-	ID               uuid.UUID
+// Returns no row when the user is already verified
+func (q *Queries) VerifyUser(ctx context.Context, emailCanonical string) (uuid.UUID, error) {
+	row := q.db.QueryRowContext(ctx, verifyUser, emailCanonical)
+	var id uuid.UUID
+	err := row.Scan(&id)
+	return id, err
 }
 
-func (q *Queries) SendVerifyCodeAgain(ctx context.Context, arg SendVerifyCodeAgainParams) error {
-	_, err := q.db.ExecContext(ctx, sendVerifyCodeAgain, arg.VerificationCode, arg.ID)
-	return err
+const listUsers = `-- name: ListUsers :many
+SELECT id, created_at, updated_at, email, password, username, is_premium, is_verified, status_changed_at, status_reason, status, status_until, email_canonical, is_private FROM users
+WHERE ($1::boolean IS NULL OR is_verified = $1)
+  AND ($2::boolean IS NULL OR is_premium = $2)
+  AND ($3::timestamp IS NULL OR created_at >= $3)
+  AND ($4::timestamp IS NULL OR created_at < $4)
+  AND ($5::text IS NULL OR status = $5)
+  AND ($6::text IS NULL OR email ILIKE '%' || $6 || '%' OR username ILIKE '%' || $6 || '%')
+  AND ($7::timestamp IS NULL OR (created_at, id) < ($7, $8::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT $9
+`
+
+type ListUsersParams struct {
+	IsVerified      sql.NullBool
+	IsPremium       sql.NullBool
+	CreatedAfter    sql.NullTime
+	CreatedBefore   sql.NullTime
+	Status          sql.NullString
+	Search          sql.NullString
+	CursorCreatedAt sql.NullTime
+	CursorID        uuid.NullUUID
+	PageSize        int32
 }
 
-const storeVerificationCode = `-- name: StoreVerificationCode :exec
-UPDATE users 
-SET verification_code = $1, is_verified = FALSE
-WHERE id = $2
+func (q *Queries) ListUsers(ctx context.Context, arg ListUsersParams) ([]User, error) {
+	rows, err := q.db.QueryContext(ctx, listUsers,
+		arg.IsVerified,
+		arg.IsPremium,
+		arg.CreatedAfter,
+		arg.CreatedBefore,
+		arg.Status,
+		arg.Search,
+		arg.CursorCreatedAt,
+		arg.CursorID,
+		arg.PageSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []User
+	for rows.Next() {
+		var i User
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Email,
+			&i.Password,
+			&i.Username,
+			&i.IsPremium,
+			&i.IsVerified,
+			&i.StatusChangedAt,
+			&i.StatusReason,
+			&i.Status,
+			&i.StatusUntil,
+			&i.EmailCanonical,
+			&i.IsPrivate,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const updateUser = `-- name: UpdateUser :one
+UPDATE users
+SET email = COALESCE($1, email),
+    email_canonical = COALESCE($2, email_canonical),
+    username = COALESCE($3, username),
+    is_premium = COALESCE($4, is_premium),
+    is_verified = COALESCE($5, is_verified),
+    updated_at = NOW()
+WHERE id = $6
+RETURNING id, created_at, updated_at, email, password, username, is_premium, is_verified, status_changed_at, status_reason, status, status_until, email_canonical, is_private
 `
 
-type StoreVerificationCodeParams struct {
-	VerificationCode int32
-	ID               uuid.UUID
+type UpdateUserParams struct {
+	Email          sql.NullString
+	EmailCanonical sql.NullString
+	Username       sql.NullString
+	IsPremium      sql.NullBool
+	IsVerified     sql.NullBool
+	ID             uuid.UUID
 }
 
-func (q *Queries) StoreVerificationCode(ctx context.Context, arg StoreVerificationCodeParams) error {
-	_, err := q.db.ExecContext(ctx, storeVerificationCode, arg.VerificationCode, arg.ID)
-	return err
+func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUser,
+		arg.Email,
+		arg.EmailCanonical,
+		arg.Username,
+		arg.IsPremium,
+		arg.IsVerified,
+		arg.ID,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.Password,
+		&i.Username,
+		&i.IsPremium,
+		&i.IsVerified,
+		&i.StatusChangedAt,
+		&i.StatusReason,
+		&i.Status,
+		&i.StatusUntil,
+		&i.EmailCanonical,
+		&i.IsPrivate,
+	)
+	return i, err
 }
 
-const verifyUser = `-- name: VerifyUser :exec
-UPDATE users 
-SET is_verified = TRUE, verification_code = 0
-WHERE email = $1
+const setUsername = `-- name: SetUsername :one
+UPDATE users
+SET username = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, password, username, is_premium, is_verified, status_changed_at, status_reason, status, status_until, email_canonical, is_private
+`
+
+type SetUsernameParams struct {
+	ID       uuid.UUID
+	Username string
+}
+
+func (q *Queries) SetUsername(ctx context.Context, arg SetUsernameParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setUsername, arg.ID, arg.Username)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.Password,
+		&i.Username,
+		&i.IsPremium,
+		&i.IsVerified,
+		&i.StatusChangedAt,
+		&i.StatusReason,
+		&i.Status,
+		&i.StatusUntil,
+		&i.EmailCanonical,
+		&i.IsPrivate,
+	)
+	return i, err
+}
+
+const setUserPrivacy = `-- name: SetUserPrivacy :one
+UPDATE users
+SET is_private = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, password, username, is_premium, is_verified, status_changed_at, status_reason, status, status_until, email_canonical, is_private
 `
 
-func (q *Queries) VerifyUser(ctx context.Context, email string) error {
-	_, err := q.db.ExecContext(ctx, verifyUser, email)
+type SetUserPrivacyParams struct {
+	ID        uuid.UUID
+	IsPrivate bool
+}
+
+func (q *Queries) SetUserPrivacy(ctx context.Context, arg SetUserPrivacyParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setUserPrivacy, arg.ID, arg.IsPrivate)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.Password,
+		&i.Username,
+		&i.IsPremium,
+		&i.IsVerified,
+		&i.StatusChangedAt,
+		&i.StatusReason,
+		&i.Status,
+		&i.StatusUntil,
+		&i.EmailCanonical,
+		&i.IsPrivate,
+	)
+	return i, err
+}
+
+const setUserStatus = `-- name: SetUserStatus :one
+UPDATE users
+SET status = $2, status_reason = $3, status_until = $4, status_changed_at = NOW(), updated_at = NOW()
+WHERE id = $1
+RETURNING id, created_at, updated_at, email, password, username, is_premium, is_verified, status_changed_at, status_reason, status, status_until, email_canonical, is_private
+`
+
+type SetUserStatusParams struct {
+	ID           uuid.UUID
+	Status       string
+	StatusReason string
+	StatusUntil  sql.NullTime
+}
+
+func (q *Queries) SetUserStatus(ctx context.Context, arg SetUserStatusParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setUserStatus,
+		arg.ID,
+		arg.Status,
+		arg.StatusReason,
+		arg.StatusUntil,
+	)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.Password,
+		&i.Username,
+		&i.IsPremium,
+		&i.IsVerified,
+		&i.StatusChangedAt,
+		&i.StatusReason,
+		&i.Status,
+		&i.StatusUntil,
+		&i.EmailCanonical,
+		&i.IsPrivate,
+	)
+	return i, err
+}
+
+const getUserStatus = `-- name: GetUserStatus :one
+SELECT status, status_until FROM users
+WHERE id = $1
+`
+
+type GetUserStatusRow struct {
+	Status      string
+	StatusUntil sql.NullTime
+}
+
+func (q *Queries) GetUserStatus(ctx context.Context, id uuid.UUID) (GetUserStatusRow, error) {
+	row := q.db.QueryRowContext(ctx, getUserStatus, id)
+	var i GetUserStatusRow
+	err := row.Scan(&i.Status, &i.StatusUntil)
+	return i, err
+}
+
+const liftExpiredSuspensions = `-- name: LiftExpiredSuspensions :execrows
+UPDATE users
+SET status = 'active', status_reason = '', status_until = NULL, status_changed_at = NOW(), updated_at = NOW()
+WHERE status = 'suspended' AND status_until <= NOW()
+`
+
+func (q *Queries) LiftExpiredSuspensions(ctx context.Context) (int64, error) {
+	result, err := q.db.ExecContext(ctx, liftExpiredSuspensions)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setUserPassword = `-- name: SetUserPassword :exec
+UPDATE users
+SET password = $2, updated_at = NOW()
+WHERE id = $1
+`
+
+type SetUserPasswordParams struct {
+	ID       uuid.UUID
+	Password string
+}
+
+func (q *Queries) SetUserPassword(ctx context.Context, arg SetUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, setUserPassword, arg.ID, arg.Password)
 	return err
 }
+
+const usernameAvailable = `-- name: UsernameAvailable :one
+SELECT NOT EXISTS (
+      SELECT 1 FROM users
+      WHERE lower(username) = lower($1) AND id <> $2
+   ) AND NOT EXISTS (
+      SELECT 1 FROM username_changes
+      WHERE lower(old_username) = lower($1) AND user_id <> $2 AND held_until > NOW()
+   ) AS available
+`
+
+type UsernameAvailableParams struct {
+	Username string
+	UserID   uuid.UUID
+}
+
+// Names are compared regardless of case. Names the user holds after a change stay available to them
+func (q *Queries) UsernameAvailable(ctx context.Context, arg UsernameAvailableParams) (bool, error) {
+	row := q.db.QueryRowContext(ctx, usernameAvailable, arg.Username, arg.UserID)
+	var available bool
+	err := row.Scan(&available)
+	return available, err
+}
@@ -0,0 +1,11 @@
+package database
+
+// Purposes of verification codes, stored in verification_codes.purpose.
+// A user has at most one active code per purpose
+const (
+	PurposeEmailVerify   = "email_verify"
+	PurposeEmailChange   = "email_change"
+	PurposePasswordReset = "password_reset"
+	PurposeLoginCode     = "login_code"
+	PurposeLoginLink     = "login_link"
+)
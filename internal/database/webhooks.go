@@ -0,0 +1,8 @@
+package database
+
+// Webhook delivery states, stored in webhook_deliveries.status
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
@@ -0,0 +1,114 @@
+// Package emailpolicy validates and normalizes email addresses and decides which domains may be used to
+// create accounts
+package emailpolicy
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Length limits of addresses in octets, from RFC 5321
+const (
+	maxLocalLength   = 64
+	maxDomainLength  = 253
+	maxAddressLength = 254
+)
+
+// Errors returned by Parse. Their messages can be shown to users
+var (
+	ErrInvalidAddress = errors.New("email is not a valid address")
+	ErrDisplayName    = errors.New("email should be a bare address without a name or angle brackets")
+	ErrDomainLiteral  = errors.New("email domain should be a host name, not an IP address")
+	ErrInvalidDomain  = errors.New("email domain is not a valid host name")
+	ErrTooLong        = errors.New("email is too long")
+)
+
+// Address is a parsed and normalized email address
+type Address struct {
+	// Email is the address as it is stored and mailed to: the local part in Unicode NFC and the domain in
+	// lower case ASCII, with internationalized labels in punycode
+	Email string
+	// Canonical is Email in lower case. Accounts are looked up and kept unique by it, so addresses that only
+	// differ in case belong to the same account
+	Canonical string
+	// Domain is the ASCII domain of the address
+	Domain string
+}
+
+// Parse checks that raw is a single RFC 5322 addr-spec and normalizes it. Display names, comments, angle
+// brackets and domain literals are rejected, since they can't be what a user means as their login
+func Parse(raw string) (Address, error) {
+	raw = strings.TrimSpace(raw)
+	switch {
+	case strings.ContainsAny(raw, "<>"):
+		return Address{}, ErrDisplayName
+	case raw == "" || strings.ContainsAny(raw, "()"):
+		return Address{}, ErrInvalidAddress
+	}
+
+	parsed, err := mail.ParseAddress(raw)
+	if err != nil {
+		return Address{}, ErrInvalidAddress
+	}
+	if parsed.Name != "" {
+		return Address{}, ErrDisplayName
+	}
+
+	// String quotes local parts that need it again, Address holds them unquoted
+	spec := strings.TrimSuffix(strings.TrimPrefix(parsed.String(), "<"), ">")
+	at := strings.LastIndex(spec, "@")
+	if at < 0 {
+		return Address{}, ErrInvalidAddress
+	}
+	local, domain := norm.NFC.String(spec[:at]), spec[at+1:]
+	if strings.HasPrefix(domain, "[") {
+		return Address{}, ErrDomainLiteral
+	}
+
+	domain, err = normalizeDomain(domain)
+	if err != nil {
+		return Address{}, err
+	}
+	if len(local) > maxLocalLength {
+		return Address{}, ErrTooLong
+	}
+
+	email := local + "@" + domain
+	if len(email) > maxAddressLength {
+		return Address{}, ErrTooLong
+	}
+
+	return Address{
+		Email:     email,
+		Canonical: strings.ToLower(email),
+		Domain:    domain,
+	}, nil
+}
+
+// normalizeDomain converts a host name to lower case ASCII and checks that it can receive mail from the
+// internet, which needs at least two labels
+func normalizeDomain(domain string) (string, error) {
+	ascii, err := idna.Lookup.ToASCII(domain)
+	if err != nil || !strings.Contains(ascii, ".") || strings.HasSuffix(ascii, ".") {
+		return "", ErrInvalidDomain
+	}
+	if len(ascii) > maxDomainLength {
+		return "", ErrTooLong
+	}
+	return strings.ToLower(ascii), nil
+}
+
+// LookupKey returns the value accounts are looked up by for an address a user typed. Input that isn't a
+// valid address is only trimmed and lower cased, so it still finds accounts created before addresses were
+// validated
+func LookupKey(raw string) string {
+	addr, err := Parse(raw)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(raw))
+	}
+	return addr.Canonical
+}
@@ -0,0 +1,75 @@
+package emailpolicy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		name     string
+		raw      string
+		expected Address
+		err      error
+	}{
+		{
+			name:     "plain",
+			raw:      "alice@example.com",
+			expected: Address{Email: "alice@example.com", Canonical: "alice@example.com", Domain: "example.com"},
+		},
+		{
+			name:     "mixed case and spaces",
+			raw:      "  Alice.Smith+news@Example.COM ",
+			expected: Address{Email: "Alice.Smith+news@example.com", Canonical: "alice.smith+news@example.com", Domain: "example.com"},
+		},
+		{
+			name:     "internationalized domain",
+			raw:      "bob@Bücher.example",
+			expected: Address{Email: "bob@xn--bcher-kva.example", Canonical: "bob@xn--bcher-kva.example", Domain: "xn--bcher-kva.example"},
+		},
+		{
+			name:     "unicode local part is NFC normalized",
+			raw:      "josé@example.com",
+			expected: Address{Email: "josé@example.com", Canonical: "josé@example.com", Domain: "example.com"},
+		},
+		{
+			name:     "quoted local part",
+			raw:      `"john doe"@example.com`,
+			expected: Address{Email: `"john doe"@example.com`, Canonical: `"john doe"@example.com`, Domain: "example.com"},
+		},
+		{name: "empty", raw: "", err: ErrInvalidAddress},
+		{name: "no at", raw: "alice.example.com", err: ErrInvalidAddress},
+		{name: "two ats", raw: "alice@bob@example.com", err: ErrInvalidAddress},
+		{name: "double dot", raw: "alice..smith@example.com", err: ErrInvalidAddress},
+		{name: "display name", raw: "Alice <alice@example.com>", err: ErrDisplayName},
+		{name: "angle brackets", raw: "<alice@example.com>", err: ErrDisplayName},
+		{name: "comment", raw: "alice@example.com (Alice)", err: ErrInvalidAddress},
+		{name: "two addresses", raw: "alice@example.com, bob@example.com", err: ErrInvalidAddress},
+		{name: "domain literal", raw: "alice@[192.0.2.1]", err: ErrDomainLiteral},
+		{name: "single label domain", raw: "alice@localhost", err: ErrInvalidDomain},
+		{name: "invalid domain", raw: "alice@exa_mple.com", err: ErrInvalidDomain},
+		{name: "long local part", raw: strings.Repeat("a", 65) + "@example.com", err: ErrTooLong},
+		{name: "long address", raw: strings.Repeat("a", 64) + "@" + strings.Repeat("b", 63) + "." + strings.Repeat("c", 63) + "." + strings.Repeat("d", 63) + ".com", err: ErrTooLong},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			addr, err := Parse(tc.raw)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, addr)
+		})
+	}
+}
+
+func TestLookupKey(t *testing.T) {
+	assert.Equal(t, "alice@example.com", LookupKey(" Alice@EXAMPLE.com"))
+	assert.Equal(t, "bob@xn--bcher-kva.example", LookupKey("Bob@bücher.example"))
+	assert.Equal(t, "not an address", LookupKey(" Not An Address "))
+}
@@ -0,0 +1,149 @@
+package emailpolicy
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// defaultDisposableDomains is the built in list of disposable mail providers, one domain per line
+//
+//go:embed disposable_domains.txt
+var defaultDisposableDomains string
+
+// Violations returned by Policy.Check
+const (
+	ViolationDomainNotAllowed = "email domain is not allowed"
+	ViolationDisposable       = "disposable email addresses are not allowed"
+)
+
+// Policy decides which domains new accounts and new addresses may use. A domain matches a list entry when it
+// is the entry or one of its subdomains. The lists are checked in order: allowed, denied, disposable
+type Policy struct {
+	// allowed is empty when every domain is allowed
+	allowed []string
+	denied  []string
+
+	// disposableFile extends the built in disposable domains and is read again by Reload
+	disposableFile string
+	disposable     atomic.Pointer[map[string]struct{}]
+}
+
+// NewPolicy creates a policy. Without allowed domains every domain that isn't denied or disposable is
+// allowed, which is the setting for public deployments. disposableFile is optional
+func NewPolicy(allowed, denied []string, disposableFile string) (*Policy, error) {
+	p := &Policy{disposableFile: disposableFile}
+
+	var err error
+	if p.allowed, err = normalizeDomains(allowed); err != nil {
+		return nil, fmt.Errorf("allowed domains: %w", err)
+	}
+	if p.denied, err = normalizeDomains(denied); err != nil {
+		return nil, fmt.Errorf("denied domains: %w", err)
+	}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// DefaultPolicy allows every domain except the built in disposable ones
+func DefaultPolicy() *Policy {
+	p := &Policy{}
+	if err := p.Reload(); err != nil {
+		// Only the built in list is read, which the tests check
+		panic(err)
+	}
+	return p
+}
+
+// Reload reads the disposable domains file again, so the list can be updated without a restart. The previous
+// list stays in use when the file can't be read
+func (p *Policy) Reload() error {
+	domains := make(map[string]struct{})
+	if err := readDomains(strings.NewReader(defaultDisposableDomains), domains); err != nil {
+		return fmt.Errorf("built in disposable domains: %w", err)
+	}
+
+	if p.disposableFile != "" {
+		file, err := os.Open(p.disposableFile)
+		if err != nil {
+			return fmt.Errorf("disposable domains: %w", err)
+		}
+		defer file.Close()
+
+		if err := readDomains(file, domains); err != nil {
+			return fmt.Errorf("disposable domains %s: %w", p.disposableFile, err)
+		}
+	}
+
+	p.disposable.Store(&domains)
+	return nil
+}
+
+// Check returns why addr can't be used for an account, or "" when it can
+func (p *Policy) Check(addr Address) string {
+	if len(p.allowed) > 0 && !matchesAny(addr.Domain, p.allowed) {
+		return ViolationDomainNotAllowed
+	}
+	if matchesAny(addr.Domain, p.denied) {
+		return ViolationDomainNotAllowed
+	}
+
+	disposable := *p.disposable.Load()
+	for domain := addr.Domain; ; {
+		if _, found := disposable[domain]; found {
+			return ViolationDisposable
+		}
+		dot := strings.IndexByte(domain, '.')
+		if dot < 0 {
+			return ""
+		}
+		domain = domain[dot+1:]
+	}
+}
+
+// matchesAny reports whether domain is one of domains or a subdomain of one of them
+func matchesAny(domain string, domains []string) bool {
+	for _, d := range domains {
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeDomains(domains []string) ([]string, error) {
+	normalized := make([]string, 0, len(domains))
+	for _, domain := range domains {
+		ascii, err := normalizeDomain(strings.TrimPrefix(strings.TrimSpace(domain), "@"))
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", domain, err)
+		}
+		normalized = append(normalized, ascii)
+	}
+	return normalized, nil
+}
+
+// readDomains adds the domains listed in r to domains. Lines hold one domain each, and blank lines and
+// lines starting with "#" are skipped
+func readDomains(r io.Reader, domains map[string]struct{}) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		domain, err := normalizeDomain(text)
+		if err != nil {
+			return fmt.Errorf("line %d: %q: %w", line, text, err)
+		}
+		domains[domain] = struct{}{}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,84 @@
+package emailpolicy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	testCases := []struct {
+		name      string
+		allowed   []string
+		denied    []string
+		email     string
+		violation string
+	}{
+		{name: "public", email: "alice@example.com"},
+		{name: "disposable", email: "alice@mailinator.com", violation: ViolationDisposable},
+		{name: "disposable subdomain", email: "alice@eu.mailinator.com", violation: ViolationDisposable},
+		{name: "lookalike of disposable", email: "alice@notmailinator.com"},
+		{name: "denied", denied: []string{"competitor.example"}, email: "alice@competitor.example", violation: ViolationDomainNotAllowed},
+		{name: "denied subdomain", denied: []string{"competitor.example"}, email: "alice@mail.competitor.example", violation: ViolationDomainNotAllowed},
+		{name: "allowed", allowed: []string{"@Acme.example"}, email: "alice@acme.example"},
+		{name: "allowed subdomain", allowed: []string{"acme.example"}, email: "alice@eu.acme.example"},
+		{name: "not allowed", allowed: []string{"acme.example"}, email: "alice@example.com", violation: ViolationDomainNotAllowed},
+		{name: "internationalized allowed", allowed: []string{"bücher.example"}, email: "alice@BÜCHER.example"},
+		{name: "denied wins over allowed", allowed: []string{"acme.example"}, denied: []string{"contractors.acme.example"}, email: "bob@contractors.acme.example", violation: ViolationDomainNotAllowed},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy, err := NewPolicy(tc.allowed, tc.denied, "")
+			require.NoError(t, err)
+			addr, err := Parse(tc.email)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.violation, policy.Check(addr))
+		})
+	}
+}
+
+func TestNewPolicyInvalidDomain(t *testing.T) {
+	_, err := NewPolicy([]string{"not a domain"}, nil, "")
+	assert.Error(t, err)
+
+	_, err = NewPolicy(nil, nil, filepath.Join(t.TempDir(), "missing.txt"))
+	assert.Error(t, err)
+}
+
+func TestPolicyReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "disposable.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# local additions\nthrowaway.example\n"), 0o600))
+
+	policy, err := NewPolicy(nil, nil, path)
+	require.NoError(t, err)
+
+	addr := func(email string) Address {
+		parsed, err := Parse(email)
+		require.NoError(t, err)
+		return parsed
+	}
+	assert.Equal(t, ViolationDisposable, policy.Check(addr("alice@throwaway.example")))
+	assert.Equal(t, ViolationDisposable, policy.Check(addr("alice@yopmail.com")), "the built in list is kept")
+	assert.Empty(t, policy.Check(addr("alice@burner.example")))
+
+	require.NoError(t, os.WriteFile(path, []byte("burner.example\n"), 0o600))
+	require.NoError(t, policy.Reload())
+	assert.Equal(t, ViolationDisposable, policy.Check(addr("alice@burner.example")))
+	assert.Empty(t, policy.Check(addr("alice@throwaway.example")))
+
+	require.NoError(t, os.WriteFile(path, []byte("not a domain\n"), 0o600))
+	assert.Error(t, policy.Reload())
+	assert.Equal(t, ViolationDisposable, policy.Check(addr("alice@burner.example")), "a broken file keeps the previous list")
+}
+
+func TestDefaultPolicy(t *testing.T) {
+	policy := DefaultPolicy()
+	addr, err := Parse("alice@guerrillamail.com")
+	require.NoError(t, err)
+	assert.Equal(t, ViolationDisposable, policy.Check(addr))
+}
@@ -0,0 +1,100 @@
+// Package exportstore keeps the archives of data exports until users download them
+package exportstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// ErrInvalidKey is returned for keys that aren't a plain file name
+var ErrInvalidKey = errors.New("invalid export key")
+
+// validKey matches the keys archives can be stored under, which can't leave the storage directory
+var validKey = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// Store is where export archives are written to and read from
+type Store interface {
+	// Put stores everything read from r under key and returns the number of bytes stored. Nothing is stored
+	// when reading r fails
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	// Open returns the archive stored under key. Errors wrap os.ErrNotExist when there is none
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	// Delete removes the archive stored under key. Deleting a missing archive is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+// LocalDir stores archives as files in a directory
+type LocalDir struct {
+	dir string
+}
+
+// NewLocalDir creates a store in dir, creating the directory if it doesn't exist
+func NewLocalDir(dir string) (*LocalDir, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating export directory: %w", err)
+	}
+	return &LocalDir{dir: dir}, nil
+}
+
+func (d *LocalDir) path(key string) (string, error) {
+	if !validKey.MatchString(key) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(d.dir, key), nil
+}
+
+// Put writes r to a temporary file first and renames it to key, so a partly written archive is never served
+func (d *LocalDir) Put(_ context.Context, key string, r io.Reader) (int64, error) {
+	path, err := d.path(key)
+	if err != nil {
+		return 0, err
+	}
+
+	tmp, err := os.CreateTemp(d.dir, ".upload-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err := io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// Open opens the file stored under key
+func (d *LocalDir) Open(_ context.Context, key string) (io.ReadSeekCloser, error) {
+	path, err := d.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// Delete removes the file stored under key
+func (d *LocalDir) Delete(_ context.Context, key string) error {
+	path, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
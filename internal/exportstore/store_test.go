@@ -0,0 +1,72 @@
+package exportstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalDir(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewLocalDir(dir)
+	require.NoError(t, err)
+
+	size, err := store.Put(ctx, "export.zip", strings.NewReader("archive"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), size)
+
+	file, err := store.Open(ctx, "export.zip")
+	require.NoError(t, err)
+	content, err := io.ReadAll(file)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	assert.Equal(t, "archive", string(content))
+
+	require.NoError(t, store.Delete(ctx, "export.zip"))
+	_, err = store.Open(ctx, "export.zip")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.NoError(t, store.Delete(ctx, "export.zip"), "deleting twice is fine")
+}
+
+func TestLocalDirFailedPut(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewLocalDir(dir)
+	require.NoError(t, err)
+
+	broken := io.MultiReader(strings.NewReader("partial"), failingReader{})
+	_, err = store.Put(ctx, "export.zip", broken)
+	require.Error(t, err)
+
+	_, err = store.Open(ctx, "export.zip")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries, "the temporary file is removed")
+}
+
+func TestLocalDirInvalidKey(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalDir(t.TempDir())
+	require.NoError(t, err)
+
+	for _, key := range []string{"", "../escape.zip", "nested/export.zip", ".hidden"} {
+		_, err := store.Put(ctx, key, strings.NewReader("archive"))
+		assert.ErrorIs(t, err, ErrInvalidKey, key)
+		_, err = store.Open(ctx, key)
+		assert.ErrorIs(t, err, ErrInvalidKey, key)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("read failed")
+}
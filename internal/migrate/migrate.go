@@ -0,0 +1,120 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/imhasandl/auth-service/sql/schema"
+	"github.com/pressly/goose/v3"
+	"github.com/pressly/goose/v3/lock"
+)
+
+// Commands supported by Run
+const (
+	CommandUp     = "up"
+	CommandDown   = "down"
+	CommandStatus = "status"
+	CommandRedo   = "redo"
+)
+
+// Usage describes the migrate subcommand
+const Usage = `usage: server migrate <command>
+
+commands:
+  up      apply all pending migrations
+  down    roll back the latest migration
+  status  print the state of every migration
+  redo    roll back the latest migration and apply it again`
+
+// NewProvider creates a goose provider for the embedded migrations. Migrations that change the schema
+// take a postgres advisory lock first, so replicas starting at the same time don't race each other
+func NewProvider(db *sql.DB) (*goose.Provider, error) {
+	locker, err := lock.NewPostgresSessionLocker()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create migration lock: %w", err)
+	}
+
+	provider, err := goose.NewProvider(goose.DialectPostgres, db, schema.FS, goose.WithSessionLocker(locker))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load migrations: %w", err)
+	}
+
+	return provider, nil
+}
+
+// Up applies all pending migrations
+func Up(ctx context.Context, db *sql.DB, out io.Writer) error {
+	provider, err := NewProvider(db)
+	if err != nil {
+		return err
+	}
+
+	results, err := provider.Up(ctx)
+	printResults(out, results...)
+	return err
+}
+
+// Run executes one of the migrate subcommands and writes its output to out
+func Run(ctx context.Context, db *sql.DB, command string, out io.Writer) error {
+	provider, err := NewProvider(db)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case CommandUp:
+		results, err := provider.Up(ctx)
+		printResults(out, results...)
+		if err == nil && len(results) == 0 {
+			fmt.Fprintln(out, "no pending migrations")
+		}
+		return err
+	case CommandDown:
+		result, err := provider.Down(ctx)
+		printResults(out, result)
+		return err
+	case CommandRedo:
+		result, err := provider.Down(ctx)
+		printResults(out, result)
+		if err != nil {
+			return err
+		}
+		result, err = provider.UpByOne(ctx)
+		printResults(out, result)
+		return err
+	case CommandStatus:
+		statuses, err := provider.Status(ctx)
+		if err != nil {
+			return err
+		}
+		printStatus(out, statuses)
+		return nil
+	default:
+		return fmt.Errorf("unknown migrate command %q\n\n%s", command, Usage)
+	}
+}
+
+func printResults(out io.Writer, results ...*goose.MigrationResult) {
+	for _, result := range results {
+		if result != nil {
+			fmt.Fprintln(out, result.String())
+		}
+	}
+}
+
+func printStatus(out io.Writer, statuses []*goose.MigrationStatus) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tSTATE\tAPPLIED AT\tMIGRATION")
+	for _, st := range statuses {
+		appliedAt := "-"
+		if !st.AppliedAt.IsZero() {
+			appliedAt = st.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", st.Source.Version, st.State, appliedAt, st.Source.Path)
+	}
+	w.Flush()
+}
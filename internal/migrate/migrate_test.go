@@ -0,0 +1,49 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/imhasandl/auth-service/sql/schema"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmbeddedMigrations(t *testing.T) {
+	db, _, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	provider, err := NewProvider(db)
+	assert.NoError(t, err)
+
+	sources := provider.ListSources()
+	assert.NotEmpty(t, sources)
+	for i, source := range sources {
+		assert.Equal(t, int64(i+1), source.Version, "migrations should be numbered without gaps")
+	}
+}
+
+func TestMigrationsCanBeRolledBack(t *testing.T) {
+	files, err := fs.Glob(schema.FS, "*.sql")
+	assert.NoError(t, err)
+
+	for _, file := range files {
+		content, err := fs.ReadFile(schema.FS, file)
+		assert.NoError(t, err)
+		assert.True(t, strings.Contains(string(content), "-- +goose Down"), "%s has no down migration", file)
+	}
+}
+
+func TestRunUnknownCommand(t *testing.T) {
+	db, _, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	var out bytes.Buffer
+	err = Run(context.Background(), db, "sideways", &out)
+	assert.ErrorContains(t, err, `unknown migrate command "sideways"`)
+}
@@ -0,0 +1,119 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+)
+
+// githubAPIURL is the base URL of the GitHub REST API
+const githubAPIURL = "https://api.github.com"
+
+// GitHubProvider logs users in with GitHub. GitHub doesn't support OpenID Connect for users,
+// so the user is read from the REST API with the access token
+type GitHubProvider struct {
+	name   string
+	config oauth2.Config
+	apiURL string
+}
+
+// NewGitHub creates a GitHub provider
+func NewGitHub(cfg Config) *GitHubProvider {
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"read:user", "user:email"}
+	}
+
+	return &GitHubProvider{
+		name: cfg.Name,
+		config: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     github.Endpoint,
+			Scopes:       scopes,
+		},
+		apiURL: githubAPIURL,
+	}
+}
+
+// Name returns the configured name of the provider
+func (p *GitHubProvider) Name() string {
+	return p.name
+}
+
+// AuthCodeURL returns the authorization URL with the state and PKCE challenge of the flow.
+// GitHub doesn't issue ID tokens, so the nonce is not used
+func (p *GitHubProvider) AuthCodeURL(flow Flow) string {
+	return p.config.AuthCodeURL(flow.State, oauth2.S256ChallengeOption(flow.Verifier))
+}
+
+// Exchange redeems the code and returns the GitHub user with their primary email
+func (p *GitHubProvider) Exchange(ctx context.Context, code string, flow Flow) (Identity, error) {
+	token, err := p.config.Exchange(ctx, code, oauth2.VerifierOption(flow.Verifier))
+	if err != nil {
+		return Identity{}, fmt.Errorf("exchange code: %w", err)
+	}
+
+	client := p.config.Client(ctx, token)
+
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := p.get(client, "/user", &user); err != nil {
+		return Identity{}, err
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := p.get(client, "/user/emails", &emails); err != nil {
+		return Identity{}, err
+	}
+
+	identity := Identity{
+		Subject: strconv.FormatInt(user.ID, 10),
+		Name:    user.Name,
+	}
+	if identity.Name == "" {
+		identity.Name = user.Login
+	}
+	for _, email := range emails {
+		if email.Primary {
+			identity.Email = email.Email
+			identity.EmailVerified = email.Verified
+			break
+		}
+	}
+
+	return identity, nil
+}
+
+func (p *GitHubProvider) get(client *http.Client, path string, v any) error {
+	req, err := http.NewRequest(http.MethodGet, p.apiURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("github %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("github %s: unexpected status %s", path, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,68 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+func TestGitHubProvider(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		if r.PostForm.Get("code") != "good-code" || r.PostForm.Get("code_verifier") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "bad_verification_code"})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "gh-token", "token_type": "bearer"})
+	})
+	mux.HandleFunc("/user", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer gh-token", r.Header.Get("Authorization"))
+		_ = json.NewEncoder(w).Encode(map[string]any{"id": 42, "login": "octocat", "name": ""})
+	})
+	mux.HandleFunc("/user/emails", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode([]map[string]any{
+			{"email": "other@example.com", "primary": false, "verified": true},
+			{"email": "octocat@example.com", "primary": true, "verified": true},
+		})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	provider := NewGitHub(Config{
+		Name:        "github",
+		ClientID:    "id",
+		RedirectURL: "https://app.example.com/callback",
+	})
+	provider.config.Endpoint = oauth2.Endpoint{
+		AuthURL:   server.URL + "/login/oauth/authorize",
+		TokenURL:  server.URL + "/login/oauth/access_token",
+		AuthStyle: oauth2.AuthStyleInParams,
+	}
+	provider.apiURL = server.URL
+
+	flow, err := NewFlow()
+	require.NoError(t, err)
+
+	assert.Contains(t, provider.AuthCodeURL(flow), "code_challenge_method=S256")
+
+	identity, err := provider.Exchange(context.Background(), "good-code", flow)
+	require.NoError(t, err)
+	assert.Equal(t, Identity{
+		Subject:       "42",
+		Email:         "octocat@example.com",
+		EmailVerified: true,
+		Name:          "octocat",
+	}, identity)
+
+	_, err = provider.Exchange(context.Background(), "bad-code", flow)
+	assert.Error(t, err)
+}
@@ -0,0 +1,205 @@
+// Package oauthtest runs a fake OpenID Connect provider for tests. It serves discovery, keys and the
+// token endpoint, and checks the client credentials, redirect URI and PKCE verifier like a real provider.
+// Logging in at the provider is simulated with Authorize
+package oauthtest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/coreos/go-oidc/v3/oidc/oidctest"
+	"golang.org/x/oauth2"
+)
+
+// Client credentials accepted by the fake provider
+const (
+	ClientID     = "test-client"
+	ClientSecret = "test-client-secret"
+)
+
+const keyID = "test-key"
+
+// User is the account that logs in at the fake provider
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Provider is a running fake OpenID Connect provider
+type Provider struct {
+	// URL is the issuer URL of the provider
+	URL string
+	// ExtraClaims are added to every ID token, overriding the generated claims.
+	// They can be used to issue tokens with a wrong nonce or audience
+	ExtraClaims map[string]any
+
+	server    *httptest.Server
+	discovery *oidctest.Server
+	key       *rsa.PrivateKey
+
+	mu     sync.Mutex
+	grants map[string]grant
+}
+
+type grant struct {
+	user          User
+	nonce         string
+	codeChallenge string
+	redirectURI   string
+}
+
+// NewProvider starts a fake provider that is stopped when the test finishes
+func NewProvider(t testing.TB) *Provider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("oauthtest: generate key: %v", err)
+	}
+
+	p := &Provider{
+		key: key,
+		discovery: &oidctest.Server{
+			PublicKeys: []oidctest.PublicKey{
+				{PublicKey: key.Public(), KeyID: keyID, Algorithm: oidc.RS256},
+			},
+		},
+		grants: make(map[string]grant),
+	}
+
+	p.server = httptest.NewServer(p)
+	p.URL = p.server.URL
+	p.discovery.SetIssuer(p.URL)
+	t.Cleanup(p.server.Close)
+
+	return p
+}
+
+// ServeHTTP serves the token endpoint and hands everything else to the discovery server
+func (p *Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/token" {
+		p.serveToken(w, r)
+		return
+	}
+	p.discovery.ServeHTTP(w, r)
+}
+
+// Authorize simulates the user logging in at the authorization URL built by the client and returns
+// the authorization code the provider would send to the redirect URL
+func (p *Provider) Authorize(authURL string, user User) (string, error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", err
+	}
+
+	query := u.Query()
+	switch {
+	case query.Get("client_id") != ClientID:
+		return "", errors.New("oauthtest: unknown client_id")
+	case query.Get("response_type") != "code":
+		return "", errors.New("oauthtest: response_type should be code")
+	case query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "":
+		return "", errors.New("oauthtest: S256 code challenge is required")
+	case query.Get("state") == "":
+		return "", errors.New("oauthtest: state is required")
+	}
+
+	code := randomString()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.grants[code] = grant{
+		user:          user,
+		nonce:         query.Get("nonce"),
+		codeChallenge: query.Get("code_challenge"),
+		redirectURI:   query.Get("redirect_uri"),
+	}
+
+	return code, nil
+}
+
+func (p *Provider) serveToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != ClientID || clientSecret != ClientSecret {
+		writeError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	p.mu.Lock()
+	g, ok := p.grants[r.PostForm.Get("code")]
+	delete(p.grants, r.PostForm.Get("code"))
+	p.mu.Unlock()
+
+	if !ok ||
+		r.PostForm.Get("redirect_uri") != g.redirectURI ||
+		oauth2.S256ChallengeFromVerifier(r.PostForm.Get("code_verifier")) != g.codeChallenge {
+		writeError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	claims := map[string]any{
+		"iss":            p.URL,
+		"aud":            ClientID,
+		"sub":            g.user.Subject,
+		"email":          g.user.Email,
+		"email_verified": g.user.EmailVerified,
+		"name":           g.user.Name,
+		"nonce":          g.nonce,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range p.ExtraClaims {
+		claims[k] = v
+	}
+
+	rawClaims, err := json.Marshal(claims)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     oidctest.SignIDToken(p.key, keyID, oidc.RS256, string(rawClaims)),
+	})
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,137 @@
+package oauth_test
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/imhasandl/auth-service/internal/oauth"
+	"github.com/imhasandl/auth-service/internal/oauth/oauthtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOIDCProvider(t *testing.T) {
+	fake := oauthtest.NewProvider(t)
+	ctx := context.Background()
+
+	provider, err := oauth.New(ctx, oauth.Config{
+		Name:         "corp",
+		IssuerURL:    fake.URL,
+		ClientID:     oauthtest.ClientID,
+		ClientSecret: oauthtest.ClientSecret,
+		RedirectURL:  "https://app.example.com/callback",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "corp", provider.Name())
+
+	user := oauthtest.User{
+		Subject:       "subject-1",
+		Email:         "user@example.com",
+		EmailVerified: true,
+		Name:          "Test User",
+	}
+
+	testCases := []struct {
+		name        string
+		extraClaims map[string]any
+		tamperFlow  func(flow *oauth.Flow)
+		expectedErr error
+		expectError bool
+	}{
+		{
+			name: "successful login",
+		},
+		{
+			name: "wrong PKCE verifier",
+			tamperFlow: func(flow *oauth.Flow) {
+				other, _ := oauth.NewFlow()
+				flow.Verifier = other.Verifier
+			},
+			expectError: true,
+		},
+		{
+			name: "wrong nonce",
+			tamperFlow: func(flow *oauth.Flow) {
+				flow.Nonce = "other-nonce"
+			},
+			expectedErr: oauth.ErrNonceMismatch,
+			expectError: true,
+		},
+		{
+			name:        "token for another client",
+			extraClaims: map[string]any{"aud": "other-client"},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake.ExtraClaims = tc.extraClaims
+
+			flow, err := oauth.NewFlow()
+			require.NoError(t, err)
+
+			authURL := provider.AuthCodeURL(flow)
+			parsed, err := url.Parse(authURL)
+			require.NoError(t, err)
+			assert.Equal(t, flow.State, parsed.Query().Get("state"))
+			assert.Equal(t, flow.Nonce, parsed.Query().Get("nonce"))
+			assert.NotEqual(t, flow.Verifier, parsed.Query().Get("code_challenge"))
+
+			code, err := fake.Authorize(authURL, user)
+			require.NoError(t, err)
+
+			if tc.tamperFlow != nil {
+				tc.tamperFlow(&flow)
+			}
+
+			identity, err := provider.Exchange(ctx, code, flow)
+			if tc.expectError {
+				assert.Error(t, err)
+				if tc.expectedErr != nil {
+					assert.ErrorIs(t, err, tc.expectedErr)
+				}
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, oauth.Identity{
+				Subject:       "subject-1",
+				Email:         "user@example.com",
+				EmailVerified: true,
+				Name:          "Test User",
+			}, identity)
+
+			// Codes can only be redeemed once
+			_, err = provider.Exchange(ctx, code, flow)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestNewProviderValidation(t *testing.T) {
+	ctx := context.Background()
+
+	_, err := oauth.New(ctx, oauth.Config{Name: "corp", ClientID: "id", RedirectURL: "https://app.example.com/callback"})
+	assert.Error(t, err, "generic providers need an issuer")
+
+	_, err = oauth.New(ctx, oauth.Config{Name: "corp", IssuerURL: "https://issuer.example.com"})
+	assert.Error(t, err, "client ID and redirect URL are required")
+
+	provider, err := oauth.New(ctx, oauth.Config{Name: "github", ClientID: "id", RedirectURL: "https://app.example.com/callback"})
+	assert.NoError(t, err)
+	assert.Equal(t, "github", provider.Name())
+}
+
+func TestNewFlow(t *testing.T) {
+	first, err := oauth.NewFlow()
+	require.NoError(t, err)
+	second, err := oauth.NewFlow()
+	require.NoError(t, err)
+
+	assert.NotEmpty(t, first.State)
+	assert.NotEmpty(t, first.Nonce)
+	assert.NotEmpty(t, first.Verifier)
+	assert.NotEqual(t, first, second)
+}
@@ -0,0 +1,110 @@
+// Package oauth implements logging in with external OAuth2 and OpenID Connect providers
+// using the authorization code flow with PKCE
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"golang.org/x/oauth2"
+)
+
+// Errors returned by Provider.Exchange
+var (
+	ErrMissingIDToken = errors.New("token response has no id_token")
+	ErrNonceMismatch  = errors.New("id_token nonce does not match")
+)
+
+// GoogleIssuerURL is the default issuer of the "google" provider
+const GoogleIssuerURL = "https://accounts.google.com"
+
+// Config configures an external identity provider
+type Config struct {
+	// Name identifies the provider in requests and in user_identities. "github" uses the GitHub API,
+	// every other name is an OpenID Connect provider
+	Name         string
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// Identity is the user returned by a provider after a successful login
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Provider is an external identity provider
+type Provider interface {
+	// Name returns the configured name of the provider
+	Name() string
+	// AuthCodeURL returns the URL the user is sent to for logging in
+	AuthCodeURL(flow Flow) string
+	// Exchange redeems an authorization code returned to the redirect URL and returns the logged in user
+	Exchange(ctx context.Context, code string, flow Flow) (Identity, error)
+}
+
+// Flow holds the per login secrets of the authorization code flow. It has to be kept by the service
+// between AuthCodeURL and Exchange, and must never be reused
+type Flow struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// NewFlow generates a random state, nonce and PKCE verifier
+func NewFlow() (Flow, error) {
+	state, err := randomString()
+	if err != nil {
+		return Flow{}, err
+	}
+
+	nonce, err := randomString()
+	if err != nil {
+		return Flow{}, err
+	}
+
+	return Flow{
+		State:    state,
+		Nonce:    nonce,
+		Verifier: oauth2.GenerateVerifier(),
+	}, nil
+}
+
+// New creates the provider described by cfg. OpenID Connect providers are discovered from their issuer,
+// so New makes network requests
+func New(ctx context.Context, cfg Config) (Provider, error) {
+	if cfg.Name == "" || cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, fmt.Errorf("oauth provider %q: name, client ID and redirect URL are required", cfg.Name)
+	}
+
+	switch cfg.Name {
+	case "github":
+		return NewGitHub(cfg), nil
+	case "google":
+		if cfg.IssuerURL == "" {
+			cfg.IssuerURL = GoogleIssuerURL
+		}
+	}
+
+	if cfg.IssuerURL == "" {
+		return nil, fmt.Errorf("oauth provider %q: issuer URL is required", cfg.Name)
+	}
+
+	return NewOIDC(ctx, cfg)
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
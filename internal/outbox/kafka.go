@@ -0,0 +1,45 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaPublisher publishes events to a Kafka topic. Messages are keyed by user, so the events of a user land
+// in the same partition and stay in order
+type KafkaPublisher struct {
+	writer *kafka.Writer
+}
+
+// NewKafkaPublisher creates a publisher writing to topic on brokers. The topic has to exist
+func NewKafkaPublisher(brokers []string, topic string) *KafkaPublisher {
+	return &KafkaPublisher{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Topic:        topic,
+			Balancer:     &kafka.Hash{},
+			RequiredAcks: kafka.RequireAll,
+			// The relay publishes one event at a time and waits for it, so don't hold messages back for a batch
+			BatchTimeout: 10 * time.Millisecond,
+		},
+	}
+}
+
+// Publish writes msg and waits until all in-sync replicas have it
+func (p *KafkaPublisher) Publish(ctx context.Context, msg Message) error {
+	return p.writer.WriteMessages(ctx, kafka.Message{
+		Key:   []byte(msg.Key),
+		Value: msg.Payload,
+		Headers: []kafka.Header{
+			{Key: "event-id", Value: []byte(msg.ID)},
+			{Key: "event-type", Value: []byte(msg.Type)},
+		},
+	})
+}
+
+// Close flushes the pending messages and closes the writer
+func (p *KafkaPublisher) Close() error {
+	return p.writer.Close()
+}
@@ -0,0 +1,55 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsFlushTimeout bounds how long Publish waits for the server to confirm it got a message
+const natsFlushTimeout = 5 * time.Second
+
+// NATSPublisher publishes events to NATS under the subject prefix followed by the event type,
+// like auth.events.user.deleted
+type NATSPublisher struct {
+	conn   *nats.Conn
+	prefix string
+}
+
+// NewNATSPublisher connects to the NATS server at url
+func NewNATSPublisher(url, subjectPrefix string) (*NATSPublisher, error) {
+	conn, err := nats.Connect(url, nats.Name("auth-service outbox"))
+	if err != nil {
+		return nil, err
+	}
+	return &NATSPublisher{conn: conn, prefix: subjectPrefix}, nil
+}
+
+// Publish sends msg and waits until the server received it. The Nats-Msg-Id header lets JetStream streams
+// drop events delivered twice
+func (p *NATSPublisher) Publish(ctx context.Context, msg Message) error {
+	m := nats.NewMsg(p.subject(msg.Type))
+	m.Data = msg.Payload
+	m.Header.Set(nats.MsgIdHdr, msg.ID)
+	m.Header.Set("Event-Type", msg.Type)
+	if err := p.conn.PublishMsg(m); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, natsFlushTimeout)
+	defer cancel()
+	return p.conn.FlushWithContext(ctx)
+}
+
+func (p *NATSPublisher) subject(eventType string) string {
+	if p.prefix == "" {
+		return eventType
+	}
+	return p.prefix + "." + eventType
+}
+
+// Close flushes the pending messages and closes the connection
+func (p *NATSPublisher) Close() error {
+	return p.conn.Drain()
+}
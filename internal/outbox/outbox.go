@@ -0,0 +1,84 @@
+// Package outbox publishes the events other services react to. Handlers write events to the events_outbox table
+// in the transaction of the change they describe, and the relay publishes them afterwards, so an event is published
+// at least once exactly when its change was committed
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/internal/database"
+	eventsv1 "github.com/imhasandl/auth-service/protos/events/v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Types of the published events
+const (
+	UserRegistered = "user.registered"
+	UserVerified   = "user.verified"
+	UserLoggedOut  = "user.logged_out"
+	UserDeleted    = "user.deleted"
+)
+
+// EventTypes lists every event type
+var EventTypes = []string{UserRegistered, UserVerified, UserLoggedOut, UserDeleted}
+
+// Message is an event as it is handed to a publisher
+type Message struct {
+	// ID identifies the event, consumers use it to drop events delivered twice
+	ID string
+	// Type is one of the event types, like user.deleted
+	Type string
+	// Key is the ID of the user the event is about. Publishers that partition messages use it, so the events of
+	// a user stay in order
+	Key string
+	// Payload is the encoded auth.events.v1.Envelope
+	Payload []byte
+}
+
+// Publisher delivers events to a message broker
+type Publisher interface {
+	// Publish returns once the broker accepted the message
+	Publish(ctx context.Context, msg Message) error
+	Close() error
+}
+
+// NewEvent builds the outbox row for an event of eventType about userID. payload is one of the messages of
+// auth.events.v1 and is wrapped in an Envelope
+func NewEvent(eventType string, userID uuid.UUID, payload proto.Message) (database.CreateOutboxEventParams, error) {
+	body, err := anypb.New(payload)
+	if err != nil {
+		return database.CreateOutboxEventParams{}, err
+	}
+
+	id := uuid.New()
+	envelope, err := proto.Marshal(&eventsv1.Envelope{
+		Id:         id.String(),
+		Type:       eventType,
+		OccurredAt: timestamppb.New(time.Now()),
+		Payload:    body,
+	})
+	if err != nil {
+		return database.CreateOutboxEventParams{}, err
+	}
+
+	return database.CreateOutboxEventParams{
+		EventID:   id,
+		EventType: eventType,
+		UserID:    userID,
+		Payload:   envelope,
+	}, nil
+}
+
+// message turns an outbox row into the message handed to publishers
+func message(event database.EventsOutbox) Message {
+	return Message{
+		ID:      event.EventID.String(),
+		Type:    event.EventType,
+		Key:     event.UserID.String(),
+		Payload: event.Payload,
+	}
+}
@@ -0,0 +1,36 @@
+package outbox
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	eventsv1 "github.com/imhasandl/auth-service/protos/events/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestNewEvent(t *testing.T) {
+	userID := uuid.New()
+
+	event, err := NewEvent(UserLoggedOut, userID, &eventsv1.UserLoggedOut{
+		UserId:    userID.String(),
+		SessionId: "session",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, UserLoggedOut, event.EventType)
+	assert.Equal(t, userID, event.UserID)
+
+	var envelope eventsv1.Envelope
+	require.NoError(t, proto.Unmarshal(event.Payload, &envelope))
+	assert.Equal(t, event.EventID.String(), envelope.GetId())
+	assert.Equal(t, UserLoggedOut, envelope.GetType())
+	assert.WithinDuration(t, time.Now(), envelope.GetOccurredAt().AsTime(), time.Minute)
+	assert.Equal(t, "type.googleapis.com/auth.events.v1.UserLoggedOut", envelope.GetPayload().GetTypeUrl())
+
+	var payload eventsv1.UserLoggedOut
+	require.NoError(t, envelope.GetPayload().UnmarshalTo(&payload))
+	assert.Equal(t, "session", payload.GetSessionId())
+}
@@ -0,0 +1,78 @@
+package outbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/go-redis/redis"
+)
+
+// Names of the publishers NewPublisher can create
+const (
+	PublisherStdout = "stdout"
+	PublisherFile   = "file"
+	PublisherNATS   = "nats"
+	PublisherKafka  = "kafka"
+	PublisherRedis  = "redis"
+)
+
+// Config selects and configures a publisher
+type Config struct {
+	// Publisher is the name of the publisher to use
+	Publisher string
+
+	// File is the path the file publisher appends to
+	File string
+
+	NATSURL           string
+	NATSSubjectPrefix string
+
+	KafkaBrokers []string
+	KafkaTopic   string
+
+	// RedisClient is the client the Redis stream publisher uses
+	RedisClient    *redis.Client
+	RedisStream    string
+	RedisStreamMax int64
+}
+
+// NewPublisher creates the publisher cfg selects
+func NewPublisher(cfg Config) (Publisher, error) {
+	switch cfg.Publisher {
+	case PublisherStdout:
+		return NewWriterPublisher(nopCloser{os.Stdout}), nil
+	case PublisherFile:
+		if cfg.File == "" {
+			return nil, errors.New("the file publisher needs a file")
+		}
+		f, err := os.OpenFile(cfg.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		return NewWriterPublisher(f), nil
+	case PublisherNATS:
+		return NewNATSPublisher(cfg.NATSURL, cfg.NATSSubjectPrefix)
+	case PublisherKafka:
+		if len(cfg.KafkaBrokers) == 0 || cfg.KafkaTopic == "" {
+			return nil, errors.New("the kafka publisher needs brokers and a topic")
+		}
+		return NewKafkaPublisher(cfg.KafkaBrokers, cfg.KafkaTopic), nil
+	case PublisherRedis:
+		if cfg.RedisClient == nil || cfg.RedisStream == "" {
+			return nil, errors.New("the redis publisher needs a client and a stream")
+		}
+		return NewRedisStreamPublisher(cfg.RedisClient, cfg.RedisStream, cfg.RedisStreamMax), nil
+	default:
+		return nil, fmt.Errorf("unknown event publisher %q", cfg.Publisher)
+	}
+}
+
+// nopCloser keeps Close of the writer publisher from closing stdout
+type nopCloser struct {
+	*os.File
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
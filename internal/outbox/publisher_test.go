@@ -0,0 +1,49 @@
+package outbox
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPublisher(t *testing.T) {
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "events.jsonl")
+
+		publisher, err := NewPublisher(Config{Publisher: PublisherFile, File: path})
+		require.NoError(t, err)
+		require.NoError(t, publisher.Close())
+
+		_, err = os.Stat(path)
+		assert.NoError(t, err)
+	})
+
+	t.Run("stdout isn't closed", func(t *testing.T) {
+		publisher, err := NewPublisher(Config{Publisher: PublisherStdout})
+		require.NoError(t, err)
+		require.NoError(t, publisher.Close())
+
+		_, err = os.Stdout.Stat()
+		assert.NoError(t, err)
+	})
+
+	testCases := []struct {
+		name   string
+		config Config
+	}{
+		{name: "unknown", config: Config{Publisher: "carrier-pigeon"}},
+		{name: "file without a path", config: Config{Publisher: PublisherFile}},
+		{name: "kafka without brokers", config: Config{Publisher: PublisherKafka, KafkaTopic: "auth.events"}},
+		{name: "redis without a client", config: Config{Publisher: PublisherRedis, RedisStream: "auth:events"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewPublisher(tc.config)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package outbox
+
+import (
+	"context"
+
+	"github.com/go-redis/redis"
+)
+
+// RedisStreamPublisher appends events to a Redis stream. Each entry has the fields id, type, user_id and payload
+type RedisStreamPublisher struct {
+	client *redis.Client
+	stream string
+	maxLen int64
+}
+
+// NewRedisStreamPublisher creates a publisher appending to stream with client. The stream is trimmed to about
+// maxLen entries, zero doesn't trim it
+func NewRedisStreamPublisher(client *redis.Client, stream string, maxLen int64) *RedisStreamPublisher {
+	return &RedisStreamPublisher{client: client, stream: stream, maxLen: maxLen}
+}
+
+// Publish appends msg to the stream
+func (p *RedisStreamPublisher) Publish(ctx context.Context, msg Message) error {
+	return p.client.WithContext(ctx).XAdd(&redis.XAddArgs{
+		Stream:       p.stream,
+		MaxLenApprox: p.maxLen,
+		Values: map[string]interface{}{
+			"id":      msg.ID,
+			"type":    msg.Type,
+			"user_id": msg.Key,
+			"payload": msg.Payload,
+		},
+	}).Err()
+}
+
+// Close does nothing, the client is shared with the rest of the service
+func (p *RedisStreamPublisher) Close() error {
+	return nil
+}
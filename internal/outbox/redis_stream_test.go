@@ -0,0 +1,31 @@
+package outbox
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisStreamPublisher(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	publisher := NewRedisStreamPublisher(client, "auth:events", 1000)
+
+	msg := Message{ID: "event-id", Type: UserVerified, Key: "user-id", Payload: []byte{1, 2, 3}}
+	require.NoError(t, publisher.Publish(context.Background(), msg))
+
+	entries, err := client.XRange("auth:events", "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, map[string]interface{}{
+		"id":      "event-id",
+		"type":    "user.verified",
+		"user_id": "user-id",
+		"payload": string([]byte{1, 2, 3}),
+	}, entries[0].Values)
+}
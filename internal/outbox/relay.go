@@ -0,0 +1,135 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/imhasandl/auth-service/internal/backoff"
+	"github.com/imhasandl/auth-service/internal/database"
+)
+
+const (
+	// relayBatchSize is how many events the relay claims at once
+	relayBatchSize = 100
+	// relayLease is how long claimed events are left alone by other relays. Events the relay didn't report back
+	// on within the lease are claimed again
+	relayLease = time.Minute
+	// minRetryBackoff is how long a failed event waits before its first retry. The wait doubles with every
+	// attempt up to maxRetryBackoff
+	minRetryBackoff = time.Second
+	maxRetryBackoff = 10 * time.Minute
+)
+
+// Store is the part of the database the relay uses
+type Store interface {
+	ClaimOutboxEvents(ctx context.Context, arg database.ClaimOutboxEventsParams) ([]database.EventsOutbox, error)
+	MarkOutboxEventPublished(ctx context.Context, id int64) error
+	RetryOutboxEvent(ctx context.Context, arg database.RetryOutboxEventParams) error
+	DeletePublishedOutboxEvents(ctx context.Context, publishedAt sql.NullTime) (int64, error)
+}
+
+// Relay publishes the events written to the outbox
+type Relay struct {
+	store     Store
+	publisher Publisher
+	retention time.Duration
+	now       func() time.Time
+}
+
+// NewRelay creates a relay that publishes the events in store with publisher. Published events are kept for
+// retention, zero keeps them forever
+func NewRelay(store Store, publisher Publisher, retention time.Duration) *Relay {
+	return &Relay{
+		store:     store,
+		publisher: publisher,
+		retention: retention,
+		now:       time.Now,
+	}
+}
+
+// Run publishes the waiting events in the order they were written, until there are none left. When publishing
+// an event fails it is retried with a growing backoff, and later events of the same user wait for it so they
+// stay in order: ClaimOutboxEvents only hands out the oldest unpublished event of each user. main runs it
+// periodically
+func (r *Relay) Run(ctx context.Context) error {
+	var published int
+	defer func() {
+		if published > 0 {
+			log.Printf("Published %d events", published)
+		}
+	}()
+
+	for {
+		events, err := r.store.ClaimOutboxEvents(ctx, database.ClaimOutboxEventsParams{
+			LeaseUntil: r.now().Add(relayLease),
+			BatchSize:  relayBatchSize,
+		})
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+		sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+
+		// Publishing a user's event makes their next one claimable, so claiming goes on until nothing is left
+		n, err := r.publish(ctx, events)
+		published += n
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// publish publishes claimed events and returns how many were published. A batch holds at most one event per user,
+// so a failed event is scheduled for a retry and the others are still published. The error of the first failure
+// is returned once the batch is done
+func (r *Relay) publish(ctx context.Context, events []database.EventsOutbox) (int, error) {
+	var (
+		published int
+		failure   error
+	)
+	for _, event := range events {
+		pubErr := r.publisher.Publish(ctx, message(event))
+		if pubErr == nil {
+			if err := r.store.MarkOutboxEventPublished(ctx, event.ID); err != nil {
+				return published, err
+			}
+			published++
+			continue
+		}
+
+		err := r.store.RetryOutboxEvent(ctx, database.RetryOutboxEventParams{
+			ID:            event.ID,
+			LastError:     pubErr.Error(),
+			NextAttemptAt: r.now().Add(backoff.Exponential(event.Attempts, minRetryBackoff, maxRetryBackoff)),
+		})
+		if err != nil {
+			return published, err
+		}
+		if failure == nil {
+			failure = fmt.Errorf("publishing event %s: %w", event.EventID, pubErr)
+		}
+	}
+	return published, failure
+}
+
+// Purge deletes the events that were published longer ago than the retention. main runs it periodically
+func (r *Relay) Purge(ctx context.Context) error {
+	if r.retention <= 0 {
+		return nil
+	}
+
+	deleted, err := r.store.DeletePublishedOutboxEvents(ctx, sql.NullTime{Time: r.now().Add(-r.retention), Valid: true})
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		log.Printf("Deleted %d published events", deleted)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/database/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingPublisher records the published messages and fails the ones listed in fail
+type recordingPublisher struct {
+	published []string
+	fail      map[string]bool
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, msg Message) error {
+	if p.fail[msg.ID] {
+		return errors.New("broker unavailable")
+	}
+	p.published = append(p.published, msg.ID)
+	return nil
+}
+
+func (p *recordingPublisher) Close() error {
+	return nil
+}
+
+func outboxRow(id int64, userID uuid.UUID, attempts int32) database.EventsOutbox {
+	return database.EventsOutbox{
+		ID:        id,
+		EventID:   uuid.New(),
+		EventType: UserLoggedOut,
+		UserID:    userID,
+		Attempts:  attempts,
+	}
+}
+
+func TestRelayRun(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	alice, bob := uuid.New(), uuid.New()
+
+	t.Run("publishes in order", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		publisher := &recordingPublisher{}
+		relay := NewRelay(mockDB, publisher, 0)
+		relay.now = func() time.Time { return now }
+		first, second := outboxRow(1, alice, 1), outboxRow(2, bob, 1)
+		mockDB.On("ClaimOutboxEvents", mock.Anything, database.ClaimOutboxEventsParams{
+			LeaseUntil: now.Add(relayLease),
+			BatchSize:  relayBatchSize,
+		}).Return([]database.EventsOutbox{second, first}, nil).Once()
+		mockDB.On("ClaimOutboxEvents", mock.Anything, mock.Anything).Return([]database.EventsOutbox{}, nil).Once()
+		mockDB.On("MarkOutboxEventPublished", mock.Anything, int64(1)).Return(nil)
+		mockDB.On("MarkOutboxEventPublished", mock.Anything, int64(2)).Return(nil)
+
+		require.NoError(t, relay.Run(context.Background()))
+
+		assert.Equal(t, []string{first.EventID.String(), second.EventID.String()}, publisher.published)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("failed event is retried and other users' events are published", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		failed := outboxRow(1, alice, 3)
+		publisher := &recordingPublisher{fail: map[string]bool{failed.EventID.String(): true}}
+		relay := NewRelay(mockDB, publisher, 0)
+		relay.now = func() time.Time { return now }
+		bobEvent := outboxRow(2, bob, 1)
+		mockDB.On("ClaimOutboxEvents", mock.Anything, mock.Anything).
+			Return([]database.EventsOutbox{failed, bobEvent}, nil).Once()
+		mockDB.On("RetryOutboxEvent", mock.Anything, database.RetryOutboxEventParams{
+			ID:            1,
+			LastError:     "broker unavailable",
+			NextAttemptAt: now.Add(4 * time.Second),
+		}).Return(nil).Once()
+		mockDB.On("MarkOutboxEventPublished", mock.Anything, int64(2)).Return(nil).Once()
+
+		err := relay.Run(context.Background())
+
+		assert.ErrorContains(t, err, "broker unavailable")
+		assert.Equal(t, []string{bobEvent.EventID.String()}, publisher.published)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("claims until the outbox is empty", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		publisher := &recordingPublisher{}
+		relay := NewRelay(mockDB, publisher, 0)
+		// Each claim only holds the oldest unpublished event of a user, the next one comes with the next claim
+		first, second := outboxRow(1, alice, 1), outboxRow(2, alice, 1)
+		mockDB.On("ClaimOutboxEvents", mock.Anything, mock.Anything).Return([]database.EventsOutbox{first}, nil).Once()
+		mockDB.On("ClaimOutboxEvents", mock.Anything, mock.Anything).Return([]database.EventsOutbox{second}, nil).Once()
+		mockDB.On("ClaimOutboxEvents", mock.Anything, mock.Anything).Return([]database.EventsOutbox{}, nil).Once()
+		mockDB.On("MarkOutboxEventPublished", mock.Anything, mock.Anything).Return(nil)
+
+		require.NoError(t, relay.Run(context.Background()))
+
+		assert.Equal(t, []string{first.EventID.String(), second.EventID.String()}, publisher.published)
+		mockDB.AssertExpectations(t)
+	})
+}
+
+func TestRelayPurge(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	t.Run("deletes events published before the retention", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		relay := NewRelay(mockDB, &recordingPublisher{}, 24*time.Hour)
+		relay.now = func() time.Time { return now }
+		mockDB.On("DeletePublishedOutboxEvents", mock.Anything,
+			sql.NullTime{Time: now.Add(-24 * time.Hour), Valid: true}).Return(int64(3), nil)
+
+		require.NoError(t, relay.Purge(context.Background()))
+
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("no retention keeps events", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		relay := NewRelay(mockDB, &recordingPublisher{}, 0)
+
+		require.NoError(t, relay.Purge(context.Background()))
+
+		mockDB.AssertNotCalled(t, "DeletePublishedOutboxEvents", mock.Anything, mock.Anything)
+	})
+}
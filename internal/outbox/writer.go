@@ -0,0 +1,52 @@
+package outbox
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	eventsv1 "github.com/imhasandl/auth-service/protos/events/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// WriterPublisher writes events to w as JSON, one per line. It is meant for local use, with stdout or a file
+type WriterPublisher struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterPublisher creates a publisher that writes events to w
+func NewWriterPublisher(w io.Writer) *WriterPublisher {
+	return &WriterPublisher{w: w}
+}
+
+// Publish writes the envelope of msg as a line of JSON
+func (p *WriterPublisher) Publish(_ context.Context, msg Message) error {
+	line, err := JSON(msg.Payload)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err = p.w.Write(append(line, '\n'))
+	return err
+}
+
+// JSON renders an encoded auth.events.v1.Envelope as JSON
+func JSON(envelope []byte) ([]byte, error) {
+	var decoded eventsv1.Envelope
+	if err := proto.Unmarshal(envelope, &decoded); err != nil {
+		return nil, err
+	}
+	return protojson.Marshal(&decoded)
+}
+
+// Close closes w when it is a closer, like a file
+func (p *WriterPublisher) Close() error {
+	if c, ok := p.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
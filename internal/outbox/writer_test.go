@@ -0,0 +1,44 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/internal/database"
+	eventsv1 "github.com/imhasandl/auth-service/protos/events/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriterPublisher(t *testing.T) {
+	var buf bytes.Buffer
+	publisher := NewWriterPublisher(&buf)
+	userID := uuid.New()
+	event, err := NewEvent(UserDeleted, userID, &eventsv1.UserDeleted{UserId: userID.String(), Mode: "anonymize"})
+	require.NoError(t, err)
+
+	require.NoError(t, publisher.Publish(context.Background(), message(database.EventsOutbox{
+		EventID:   event.EventID,
+		EventType: event.EventType,
+		UserID:    event.UserID,
+		Payload:   event.Payload,
+	})))
+
+	var decoded struct {
+		ID      string            `json:"id"`
+		Type    string            `json:"type"`
+		Payload map[string]string `json:"payload"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	assert.Equal(t, event.EventID.String(), decoded.ID)
+	assert.Equal(t, "user.deleted", decoded.Type)
+	assert.Equal(t, map[string]string{
+		"@type":  "type.googleapis.com/auth.events.v1.UserDeleted",
+		"userId": userID.String(),
+		"mode":   "anonymize",
+	}, decoded.Payload)
+	assert.Equal(t, byte('\n'), buf.Bytes()[buf.Len()-1])
+}
@@ -25,6 +25,9 @@ func NewRedisConfig(password string) *Config {
 	}
 }
 
+// Nil is the error returned when a key does not exist
+const Nil = redis.Nil
+
 var (
 	// Client is a global Redis client instance
 	Client *redis.Client
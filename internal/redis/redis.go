@@ -26,20 +26,17 @@ func DeleteToken(userID string) error {
 	return Client.Del(userID).Err()
 }
 
-// CacheVerificationCode сохраняет верификационный код для email
-func CacheVerificationCode(email string, code int32, expiration time.Duration) error {
+// CacheVerificationCode сохраняет хеш верификационного кода для email.
+// The expiration should match the TTL of the code stored in the database
+func CacheVerificationCode(email, codeHash string, expiration time.Duration) error {
 	key := fmt.Sprintf("verification:%s", email)
-	return Client.Set(key, code, expiration).Err()
+	return Client.Set(key, codeHash, expiration).Err()
 }
 
-// GetVerificationCode получает верификационный код для email
-func GetVerificationCode(email string) (int, error) {
+// GetVerificationCode получает хеш верификационного кода для email
+func GetVerificationCode(email string) (string, error) {
 	key := fmt.Sprintf("verification:%s", email)
-	result, err := Client.Get(key).Int()
-	if err != nil {
-		return 0, err
-	}
-	return result, nil
+	return Client.Get(key).Result()
 }
 
 // DeleteVerificationCode удаляет верификационный код
@@ -48,6 +45,163 @@ func DeleteVerificationCode(email string) error {
 	return Client.Del(key).Err()
 }
 
+// IncrementRateLimit counts a request against key in a fixed window. It returns the number of requests
+// made in the current window and the time left until the window resets
+func IncrementRateLimit(key string, window time.Duration) (int64, time.Duration, error) {
+	key = fmt.Sprintf("ratelimit:%s", key)
+
+	count, err := Client.Incr(key).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+	if count == 1 {
+		if err := Client.Expire(key, window).Err(); err != nil {
+			return 0, 0, err
+		}
+		return count, window, nil
+	}
+
+	ttl, err := Client.TTL(key).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+	if ttl < 0 {
+		// The key lost its expiry, for example when the first Expire call failed. Restart the window
+		// so the key doesn't block the client forever
+		if err := Client.Expire(key, window).Err(); err != nil {
+			return 0, 0, err
+		}
+		ttl = window
+	}
+
+	return count, ttl, nil
+}
+
+// SaveExternalLoginState stores the state of a started external login until it is completed or expires
+func SaveExternalLoginState(state, value string, expiration time.Duration) error {
+	key := fmt.Sprintf("external_login:%s", state)
+	return Client.Set(key, value, expiration).Err()
+}
+
+// TakeExternalLoginState returns the state of an external login and deletes it, so a state can only be used once
+func TakeExternalLoginState(state string) (string, error) {
+	key := fmt.Sprintf("external_login:%s", state)
+	return take(key)
+}
+
+// SaveAuthorizationCode stores an authorization code issued by the OpenID Connect provider
+func SaveAuthorizationCode(code, value string, expiration time.Duration) error {
+	key := fmt.Sprintf("oidc_code:%s", code)
+	return Client.Set(key, value, expiration).Err()
+}
+
+// TakeAuthorizationCode returns an authorization code and deletes it, so a code can only be redeemed once
+func TakeAuthorizationCode(code string) (string, error) {
+	key := fmt.Sprintf("oidc_code:%s", code)
+	return take(key)
+}
+
+// SaveOIDCSession stores the user logged in at the OpenID Connect provider under the hash of the session ID
+func SaveOIDCSession(sessionHash, userID string, expiration time.Duration) error {
+	return Client.Set(fmt.Sprintf("oidc_session:%s", sessionHash), userID, expiration).Err()
+}
+
+// GetOIDCSession returns the user of a session at the OpenID Connect provider
+func GetOIDCSession(sessionHash string) (string, error) {
+	return Client.Get(fmt.Sprintf("oidc_session:%s", sessionHash)).Result()
+}
+
+// SaveDeviceAuthorization stores a pending device authorization under the hash of its device code, and the
+// user code pointing to it. It returns false without saving anything when the user code is already in use
+func SaveDeviceAuthorization(deviceCodeHash, userCode, value string, expiration time.Duration) (bool, error) {
+	saved, err := Client.SetNX(fmt.Sprintf("device_user_code:%s", userCode), deviceCodeHash, expiration).Result()
+	if err != nil || !saved {
+		return false, err
+	}
+
+	if err := Client.Set(fmt.Sprintf("device:%s", deviceCodeHash), value, expiration).Err(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GetDeviceAuthorization returns the device authorization stored under the hash of its device code
+func GetDeviceAuthorization(deviceCodeHash string) (string, error) {
+	return Client.Get(fmt.Sprintf("device:%s", deviceCodeHash)).Result()
+}
+
+// TakeDeviceAuthorization returns a device authorization and deletes it, so it can only be redeemed once
+func TakeDeviceAuthorization(deviceCodeHash string) (string, error) {
+	return take(fmt.Sprintf("device:%s", deviceCodeHash))
+}
+
+// GetDeviceUserCode returns the hash of the device code a user code belongs to
+func GetDeviceUserCode(userCode string) (string, error) {
+	return Client.Get(fmt.Sprintf("device_user_code:%s", userCode)).Result()
+}
+
+// DecideDeviceAuthorization replaces a device authorization with the user's decision, keeping its expiry,
+// and deletes the user code so it can't be used again. It returns Nil when the authorization expired
+func DecideDeviceAuthorization(deviceCodeHash, userCode, value string) error {
+	key := fmt.Sprintf("device:%s", deviceCodeHash)
+
+	ttl, err := Client.PTTL(key).Result()
+	if err != nil {
+		return err
+	}
+	if ttl <= 0 {
+		return Nil
+	}
+
+	pipe := Client.TxPipeline()
+	set := pipe.SetXX(key, value, ttl)
+	pipe.Del(fmt.Sprintf("device_user_code:%s", userCode))
+	if _, err := pipe.Exec(); err != nil {
+		return err
+	}
+	if !set.Val() {
+		return Nil
+	}
+	return nil
+}
+
+// ThrottleDevicePoll reports whether a device may poll now. Devices may poll once per interval
+func ThrottleDevicePoll(deviceCodeHash string, interval time.Duration) (bool, error) {
+	return Client.SetNX(fmt.Sprintf("device_poll:%s", deviceCodeHash), 1, interval).Result()
+}
+
+// SaveSignInReport stores what the "this wasn't me" link of a new sign-in email is about, under the hash of its token
+func SaveSignInReport(tokenHash, value string, expiration time.Duration) error {
+	return Client.Set(fmt.Sprintf("signin_report:%s", tokenHash), value, expiration).Err()
+}
+
+// TakeSignInReport returns a sign-in report and deletes it, so a link can only be used once
+func TakeSignInReport(tokenHash string) (string, error) {
+	return take(fmt.Sprintf("signin_report:%s", tokenHash))
+}
+
+// SavePasswordReset stores the user a password reset token was issued for, under the hash of the token
+func SavePasswordReset(tokenHash, userID string, expiration time.Duration) error {
+	return Client.Set(fmt.Sprintf("password_reset:%s", tokenHash), userID, expiration).Err()
+}
+
+// TakePasswordReset returns the user of a password reset token and deletes it, so a token can only be used once
+func TakePasswordReset(tokenHash string) (string, error) {
+	return take(fmt.Sprintf("password_reset:%s", tokenHash))
+}
+
+// take gets and deletes key in one transaction
+func take(key string) (string, error) {
+	pipe := Client.TxPipeline()
+	get := pipe.Get(key)
+	pipe.Del(key)
+	if _, err := pipe.Exec(); err != nil {
+		return "", err
+	}
+
+	return get.Val(), nil
+}
+
 // SaveAccessToken stores token
 func SaveAccessToken(userID, token string, expiration time.Duration) error {
 	key := fmt.Sprintf("user:%s:%s_token", userID, AccessToken)
@@ -0,0 +1,207 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/internal/backoff"
+	"github.com/imhasandl/auth-service/internal/database"
+)
+
+const (
+	// dispatchBatchSize is how many deliveries are claimed and sent at once
+	dispatchBatchSize = 20
+	// requestTimeout bounds a single request to an endpoint
+	requestTimeout = 10 * time.Second
+	// dispatchLease is how long claimed deliveries are left alone by other dispatchers
+	dispatchLease = time.Minute
+	// MaxAttempts is how often a delivery is tried before it fails for good
+	MaxAttempts = 10
+	// minRetryBackoff is how long a delivery waits before its first retry. The wait doubles with every attempt up
+	// to maxRetryBackoff, so a delivery is retried for a little over three hours
+	minRetryBackoff = 30 * time.Second
+	maxRetryBackoff = time.Hour
+	// MaxConsecutiveFailures is how many attempts to an endpoint can fail in a row before it is disabled
+	MaxConsecutiveFailures = 25
+	// maxErrorLength is how much of an error or response body is kept in the delivery log
+	maxErrorLength = 500
+)
+
+// Store is the part of the database the dispatcher uses
+type Store interface {
+	ClaimWebhookDeliveries(ctx context.Context, arg database.ClaimWebhookDeliveriesParams) ([]database.ClaimWebhookDeliveriesRow, error)
+	CompleteWebhookDelivery(ctx context.Context, arg database.CompleteWebhookDeliveryParams) error
+	RetryWebhookDelivery(ctx context.Context, arg database.RetryWebhookDeliveryParams) error
+	FailWebhookDelivery(ctx context.Context, arg database.FailWebhookDeliveryParams) error
+	RecordWebhookEndpointSuccess(ctx context.Context, id uuid.UUID) error
+	RecordWebhookEndpointFailure(ctx context.Context, arg database.RecordWebhookEndpointFailureParams) (database.WebhookEndpoint, error)
+	DeleteFinishedWebhookDeliveries(ctx context.Context, createdAt time.Time) (int64, error)
+}
+
+// Dispatcher sends the pending webhook deliveries
+type Dispatcher struct {
+	store     Store
+	client    *http.Client
+	retention time.Duration
+	now       func() time.Time
+}
+
+// NewDispatcher creates a dispatcher sending the deliveries in store. Finished deliveries are kept in the
+// delivery log for retention, zero keeps them forever
+func NewDispatcher(store Store, retention time.Duration) *Dispatcher {
+	return &Dispatcher{
+		store: store,
+		client: &http.Client{
+			Timeout: requestTimeout,
+			// A redirect would send the signed body somewhere the endpoint wasn't registered for
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		retention: retention,
+		now:       time.Now,
+	}
+}
+
+// Run sends the due deliveries until there are none left. A delivery succeeds when the endpoint answers with a
+// 2xx status. Failed deliveries are retried with a growing backoff, and endpoints that keep failing are disabled.
+// main runs it periodically
+func (d *Dispatcher) Run(ctx context.Context) error {
+	for {
+		deliveries, err := d.store.ClaimWebhookDeliveries(ctx, database.ClaimWebhookDeliveriesParams{
+			LeaseUntil: d.now().Add(dispatchLease),
+			BatchSize:  dispatchBatchSize,
+		})
+		if err != nil {
+			return err
+		}
+
+		errs := make([]error, len(deliveries))
+		var wg sync.WaitGroup
+		for i, delivery := range deliveries {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs[i] = d.deliver(ctx, delivery)
+			}()
+		}
+		wg.Wait()
+
+		for _, err := range errs {
+			if err != nil {
+				return err
+			}
+		}
+		if len(deliveries) < dispatchBatchSize {
+			return nil
+		}
+	}
+}
+
+// deliver sends one delivery and records the outcome
+func (d *Dispatcher) deliver(ctx context.Context, delivery database.ClaimWebhookDeliveriesRow) error {
+	status, sendErr := d.send(ctx, delivery)
+	if sendErr == nil {
+		err := d.store.CompleteWebhookDelivery(ctx, database.CompleteWebhookDeliveryParams{
+			ID:             delivery.ID,
+			ResponseStatus: int32(status),
+		})
+		if err != nil {
+			return err
+		}
+		return d.store.RecordWebhookEndpointSuccess(ctx, delivery.EndpointID)
+	}
+
+	lastError := truncate(sendErr.Error())
+	if delivery.Attempts >= MaxAttempts {
+		err := d.store.FailWebhookDelivery(ctx, database.FailWebhookDeliveryParams{
+			ID:             delivery.ID,
+			ResponseStatus: int32(status),
+			LastError:      lastError,
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		err := d.store.RetryWebhookDelivery(ctx, database.RetryWebhookDeliveryParams{
+			ID:             delivery.ID,
+			ResponseStatus: int32(status),
+			LastError:      lastError,
+			NextAttemptAt:  d.now().Add(backoff.Exponential(delivery.Attempts, minRetryBackoff, maxRetryBackoff)),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	endpoint, err := d.store.RecordWebhookEndpointFailure(ctx, database.RecordWebhookEndpointFailureParams{
+		MaxFailures:    MaxConsecutiveFailures,
+		DisabledReason: fmt.Sprintf("%d deliveries in a row failed, the last with: %s", MaxConsecutiveFailures, lastError),
+		ID:             delivery.EndpointID,
+	})
+	if err != nil {
+		return err
+	}
+	if endpoint.DisabledAt.Valid && endpoint.ConsecutiveFailures == MaxConsecutiveFailures {
+		log.Printf("Disabled webhook endpoint %s after %d failed deliveries", endpoint.ID, endpoint.ConsecutiveFailures)
+	}
+	return nil
+}
+
+// send posts the payload of delivery to its endpoint. It returns the response status, zero when there was no
+// response, and an error unless the status is 2xx
+func (d *Dispatcher) send(ctx context.Context, delivery database.ClaimWebhookDeliveriesRow) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "auth-service-webhooks")
+	req.Header.Set(IDHeader, delivery.EventID.String())
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, d.now(), delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorLength))
+		return resp.StatusCode, fmt.Errorf("endpoint answered %s: %s", strconv.Itoa(resp.StatusCode), body)
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	return resp.StatusCode, nil
+}
+
+// Purge deletes the finished deliveries older than the retention from the delivery log. main runs it periodically
+func (d *Dispatcher) Purge(ctx context.Context) error {
+	if d.retention <= 0 {
+		return nil
+	}
+
+	deleted, err := d.store.DeleteFinishedWebhookDeliveries(ctx, d.now().Add(-d.retention))
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		log.Printf("Deleted %d webhook deliveries", deleted)
+	}
+	return nil
+}
+
+func truncate(s string) string {
+	if len(s) <= maxErrorLength {
+		return s
+	}
+	return s[:maxErrorLength]
+}
@@ -0,0 +1,212 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/database/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// receiver is a partner endpoint that answers with status and records the requests it verified
+type receiver struct {
+	t      *testing.T
+	secret string
+	now    time.Time
+	status int
+
+	mu       sync.Mutex
+	received []http.Header
+	bodies   []string
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	require.NoError(r.t, err)
+	assert.NoError(r.t, Verify(r.secret, req.Header.Get(SignatureHeader), body, 5*time.Minute, r.now))
+
+	r.mu.Lock()
+	r.received = append(r.received, req.Header.Clone())
+	r.bodies = append(r.bodies, string(body))
+	r.mu.Unlock()
+
+	w.WriteHeader(r.status)
+	_, _ = w.Write([]byte("receiver says hi"))
+}
+
+func deliveryRow(id int64, endpointID uuid.UUID, url string, attempts int32) database.ClaimWebhookDeliveriesRow {
+	return database.ClaimWebhookDeliveriesRow{
+		ID:         id,
+		EndpointID: endpointID,
+		EventID:    uuid.New(),
+		EventType:  "user.deleted",
+		Payload:    []byte(`{"type":"user.deleted"}`),
+		Attempts:   attempts,
+		Url:        url,
+		Secret:     "whsec_test",
+	}
+}
+
+func TestDispatcherRun(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	endpointID := uuid.New()
+
+	newDispatcher := func(mockDB *mocks.MockQueries) *Dispatcher {
+		d := NewDispatcher(mockDB, 0)
+		d.now = func() time.Time { return now }
+		return d
+	}
+
+	t.Run("delivers signed request", func(t *testing.T) {
+		rcv := &receiver{t: t, secret: "whsec_test", now: now, status: http.StatusNoContent}
+		srv := httptest.NewServer(rcv)
+		defer srv.Close()
+		mockDB := new(mocks.MockQueries)
+		delivery := deliveryRow(1, endpointID, srv.URL, 1)
+		mockDB.On("ClaimWebhookDeliveries", mock.Anything, database.ClaimWebhookDeliveriesParams{
+			LeaseUntil: now.Add(dispatchLease),
+			BatchSize:  dispatchBatchSize,
+		}).Return([]database.ClaimWebhookDeliveriesRow{delivery}, nil).Once()
+		mockDB.On("CompleteWebhookDelivery", mock.Anything, database.CompleteWebhookDeliveryParams{
+			ID:             1,
+			ResponseStatus: http.StatusNoContent,
+		}).Return(nil)
+		mockDB.On("RecordWebhookEndpointSuccess", mock.Anything, endpointID).Return(nil)
+
+		require.NoError(t, newDispatcher(mockDB).Run(context.Background()))
+
+		require.Len(t, rcv.received, 1)
+		assert.Equal(t, `{"type":"user.deleted"}`, rcv.bodies[0])
+		assert.Equal(t, "application/json", rcv.received[0].Get("Content-Type"))
+		assert.Equal(t, delivery.EventID.String(), rcv.received[0].Get(IDHeader))
+		assert.Equal(t, "user.deleted", rcv.received[0].Get(EventHeader))
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("retries failed request with backoff", func(t *testing.T) {
+		rcv := &receiver{t: t, secret: "whsec_test", now: now, status: http.StatusServiceUnavailable}
+		srv := httptest.NewServer(rcv)
+		defer srv.Close()
+		mockDB := new(mocks.MockQueries)
+		mockDB.On("ClaimWebhookDeliveries", mock.Anything, mock.Anything).
+			Return([]database.ClaimWebhookDeliveriesRow{deliveryRow(1, endpointID, srv.URL, 3)}, nil).Once()
+		mockDB.On("RetryWebhookDelivery", mock.Anything, database.RetryWebhookDeliveryParams{
+			ID:             1,
+			ResponseStatus: http.StatusServiceUnavailable,
+			LastError:      "endpoint answered 503: receiver says hi",
+			NextAttemptAt:  now.Add(2 * time.Minute),
+		}).Return(nil)
+		mockDB.On("RecordWebhookEndpointFailure", mock.Anything, mock.MatchedBy(func(arg database.RecordWebhookEndpointFailureParams) bool {
+			return arg.ID == endpointID && arg.MaxFailures == MaxConsecutiveFailures
+		})).Return(database.WebhookEndpoint{ID: endpointID, ConsecutiveFailures: 3}, nil)
+
+		require.NoError(t, newDispatcher(mockDB).Run(context.Background()))
+
+		assert.Len(t, rcv.received, 1)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("fails delivery after last attempt", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		srv := httptest.NewServer(http.NotFoundHandler())
+		defer srv.Close()
+		mockDB.On("ClaimWebhookDeliveries", mock.Anything, mock.Anything).
+			Return([]database.ClaimWebhookDeliveriesRow{deliveryRow(1, endpointID, srv.URL, MaxAttempts)}, nil).Once()
+		mockDB.On("FailWebhookDelivery", mock.Anything, mock.MatchedBy(func(arg database.FailWebhookDeliveryParams) bool {
+			return arg.ID == 1 && arg.ResponseStatus == http.StatusNotFound
+		})).Return(nil)
+		mockDB.On("RecordWebhookEndpointFailure", mock.Anything, mock.Anything).
+			Return(database.WebhookEndpoint{ID: endpointID, ConsecutiveFailures: 10}, nil)
+
+		require.NoError(t, newDispatcher(mockDB).Run(context.Background()))
+
+		mockDB.AssertNotCalled(t, "RetryWebhookDelivery", mock.Anything, mock.Anything)
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("unreachable endpoint", func(t *testing.T) {
+		srv := httptest.NewServer(http.NotFoundHandler())
+		srv.Close()
+		mockDB := new(mocks.MockQueries)
+		mockDB.On("ClaimWebhookDeliveries", mock.Anything, mock.Anything).
+			Return([]database.ClaimWebhookDeliveriesRow{deliveryRow(1, endpointID, srv.URL, 1)}, nil).Once()
+		mockDB.On("RetryWebhookDelivery", mock.Anything, mock.MatchedBy(func(arg database.RetryWebhookDeliveryParams) bool {
+			return arg.ResponseStatus == 0 && arg.LastError != "" && arg.NextAttemptAt.Equal(now.Add(minRetryBackoff))
+		})).Return(nil)
+		mockDB.On("RecordWebhookEndpointFailure", mock.Anything, mock.Anything).
+			Return(database.WebhookEndpoint{ID: endpointID, ConsecutiveFailures: 1}, nil)
+
+		require.NoError(t, newDispatcher(mockDB).Run(context.Background()))
+
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("redirect is a failure", func(t *testing.T) {
+		srv := httptest.NewServer(http.RedirectHandler("https://example.com/elsewhere", http.StatusFound))
+		defer srv.Close()
+		mockDB := new(mocks.MockQueries)
+		mockDB.On("ClaimWebhookDeliveries", mock.Anything, mock.Anything).
+			Return([]database.ClaimWebhookDeliveriesRow{deliveryRow(1, endpointID, srv.URL, 1)}, nil).Once()
+		mockDB.On("RetryWebhookDelivery", mock.Anything, mock.MatchedBy(func(arg database.RetryWebhookDeliveryParams) bool {
+			return arg.ResponseStatus == http.StatusFound
+		})).Return(nil)
+		mockDB.On("RecordWebhookEndpointFailure", mock.Anything, mock.Anything).
+			Return(database.WebhookEndpoint{ID: endpointID, ConsecutiveFailures: 1}, nil)
+
+		require.NoError(t, newDispatcher(mockDB).Run(context.Background()))
+
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("sends full batches until drained", func(t *testing.T) {
+		rcv := &receiver{t: t, secret: "whsec_test", now: now, status: http.StatusOK}
+		srv := httptest.NewServer(rcv)
+		defer srv.Close()
+		mockDB := new(mocks.MockQueries)
+		batch := make([]database.ClaimWebhookDeliveriesRow, dispatchBatchSize)
+		for i := range batch {
+			batch[i] = deliveryRow(int64(i+1), endpointID, srv.URL, 1)
+		}
+		mockDB.On("ClaimWebhookDeliveries", mock.Anything, mock.Anything).Return(batch, nil).Once()
+		mockDB.On("ClaimWebhookDeliveries", mock.Anything, mock.Anything).Return([]database.ClaimWebhookDeliveriesRow{}, nil).Once()
+		mockDB.On("CompleteWebhookDelivery", mock.Anything, mock.Anything).Return(nil)
+		mockDB.On("RecordWebhookEndpointSuccess", mock.Anything, endpointID).Return(nil)
+
+		require.NoError(t, newDispatcher(mockDB).Run(context.Background()))
+
+		assert.Len(t, rcv.received, dispatchBatchSize)
+		mockDB.AssertNumberOfCalls(t, "ClaimWebhookDeliveries", 2)
+	})
+}
+
+func TestDispatcherPurge(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	t.Run("deletes finished deliveries", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+		d := NewDispatcher(mockDB, 24*time.Hour)
+		d.now = func() time.Time { return now }
+		mockDB.On("DeleteFinishedWebhookDeliveries", mock.Anything, now.Add(-24*time.Hour)).Return(int64(3), nil)
+
+		require.NoError(t, d.Purge(context.Background()))
+
+		mockDB.AssertExpectations(t)
+	})
+
+	t.Run("keeps everything without retention", func(t *testing.T) {
+		mockDB := new(mocks.MockQueries)
+
+		require.NoError(t, NewDispatcher(mockDB, 0).Purge(context.Background()))
+
+		mockDB.AssertNotCalled(t, "DeleteFinishedWebhookDeliveries", mock.Anything, mock.Anything)
+	})
+}
@@ -0,0 +1,95 @@
+// Package webhook delivers events to the HTTP endpoints of partners that can't read from a message broker
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers of a webhook request
+const (
+	// SignatureHeader holds the time the request was signed and the signature, like t=1700000000,v1=5257a8...
+	SignatureHeader = "Webhook-Signature"
+	// IDHeader holds the ID of the event. It stays the same when a delivery is retried or replayed
+	IDHeader = "Webhook-Id"
+	// EventHeader holds the type of the event, like user.deleted
+	EventHeader = "Webhook-Event"
+)
+
+// secretPrefix marks webhook secrets, so they are recognized when they leak
+const secretPrefix = "whsec_"
+
+var (
+	// ErrInvalidSignature is returned by Verify for requests that weren't signed with the secret
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	// ErrTimestampTooOld is returned by Verify for requests signed longer ago than the tolerance
+	ErrTimestampTooOld = errors.New("webhook timestamp is outside the tolerance")
+)
+
+// NewSecret generates the secret an endpoint's requests are signed with
+func NewSecret() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return secretPrefix + base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// Sign returns the value of the signature header for body sent at signedAt. The signature is the hex encoded
+// HMAC-SHA256 of the unix time, a dot and the body
+func Sign(secret string, signedAt time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+	return "t=" + timestamp + ",v1=" + signature(secret, timestamp, body)
+}
+
+// Verify checks the signature header of a webhook request. Requests signed more than tolerance before now are
+// refused, so captured requests can't be replayed later
+func Verify(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return ErrInvalidSignature
+	}
+
+	expected := signature(secret, timestamp, body)
+	valid := false
+	for _, candidate := range signatures {
+		if hmac.Equal([]byte(candidate), []byte(expected)) {
+			valid = true
+		}
+	}
+	if !valid {
+		return ErrInvalidSignature
+	}
+
+	if age := now.Sub(time.Unix(unix, 0)); age > tolerance || age < -tolerance {
+		return ErrTimestampTooOld
+	}
+	return nil
+}
+
+func signature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,65 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSecret(t *testing.T) {
+	first, err := NewSecret()
+	require.NoError(t, err)
+	second, err := NewSecret()
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(first, secretPrefix))
+	assert.Len(t, first, len(secretPrefix)+43)
+	assert.NotEqual(t, first, second)
+}
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"id":"1"}`)
+	mac := hmac.New(sha256.New, []byte("whsec_test"))
+	mac.Write([]byte("1700000000." + string(body)))
+
+	header := Sign("whsec_test", time.Unix(1700000000, 0), body)
+
+	assert.Equal(t, "t=1700000000,v1="+hex.EncodeToString(mac.Sum(nil)), header)
+}
+
+func TestVerify(t *testing.T) {
+	signedAt := time.Unix(1700000000, 0)
+	body := []byte(`{"type":"user.deleted"}`)
+	header := Sign("whsec_test", signedAt, body)
+
+	tests := []struct {
+		name    string
+		secret  string
+		header  string
+		body    []byte
+		now     time.Time
+		wantErr error
+	}{
+		{name: "valid", secret: "whsec_test", header: header, body: body, now: signedAt.Add(time.Minute)},
+		{name: "rotated secret listed first", secret: "whsec_test", header: "t=1700000000,v1=00," + header[len("t=1700000000,"):], body: body, now: signedAt},
+		{name: "wrong secret", secret: "whsec_other", header: header, body: body, now: signedAt, wantErr: ErrInvalidSignature},
+		{name: "changed body", secret: "whsec_test", header: header, body: []byte(`{"type":"user.verified"}`), now: signedAt, wantErr: ErrInvalidSignature},
+		{name: "changed timestamp", secret: "whsec_test", header: "t=1700000001" + header[len("t=1700000000"):], body: body, now: signedAt, wantErr: ErrInvalidSignature},
+		{name: "missing signature", secret: "whsec_test", header: "t=1700000000", body: body, now: signedAt, wantErr: ErrInvalidSignature},
+		{name: "garbage", secret: "whsec_test", header: "garbage", body: body, now: signedAt, wantErr: ErrInvalidSignature},
+		{name: "too old", secret: "whsec_test", header: header, body: body, now: signedAt.Add(6 * time.Minute), wantErr: ErrTimestampTooOld},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.secret, tt.header, tt.body, 5*time.Minute, tt.now)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
@@ -1,22 +1,55 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"log"
 	"net"
+	"net/http"
+	"os"
+	"time"
 
 	_ "github.com/lib/pq" // Import the postgres driver
 
+	"github.com/imhasandl/auth-service/cmd/auth"
+	"github.com/imhasandl/auth-service/cmd/authz"
 	"github.com/imhasandl/auth-service/cmd/helper"
+	"github.com/imhasandl/auth-service/cmd/idp"
+	"github.com/imhasandl/auth-service/cmd/role"
 	server "github.com/imhasandl/auth-service/cmd/server"
+	"github.com/imhasandl/auth-service/cmd/serviceclient"
 	"github.com/imhasandl/auth-service/internal/database"
+	"github.com/imhasandl/auth-service/internal/emailpolicy"
+	"github.com/imhasandl/auth-service/internal/exportstore"
+	"github.com/imhasandl/auth-service/internal/migrate"
+	"github.com/imhasandl/auth-service/internal/oauth"
+	"github.com/imhasandl/auth-service/internal/outbox"
 	"github.com/imhasandl/auth-service/internal/redis"
+	"github.com/imhasandl/auth-service/internal/webhook"
 	pb "github.com/imhasandl/auth-service/protos"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			runMigrate(os.Args[2:])
+			return
+		case "oidc-client":
+			runOIDCClient(os.Args[2:])
+			return
+		case "service-client":
+			runServiceClient(os.Args[2:])
+			return
+		case "role":
+			runRole(os.Args[2:])
+			return
+		}
+	}
+
 	envConfig := helper.GetENVSecrets()
 
 	lis, err := net.Listen("tcp", envConfig.Port)
@@ -28,16 +61,120 @@ func main() {
 	if err != nil {
 		log.Fatalf("Error opening database: %s", err)
 	}
-	dbQueries := database.New(dbConn)
 	defer dbConn.Close()
 
+	if envConfig.AutoMigrate {
+		if err := migrate.Up(context.Background(), dbConn, log.Writer()); err != nil {
+			log.Fatalf("failed to apply migrations: %v", err)
+		}
+	}
+
+	dbStore := database.NewStore(dbConn)
+
 	redisConfig := redis.NewRedisConfig(envConfig.RedisSecret)
 	redis.InitRedisClient(redisConfig)
 
-	server := server.NewServer(dbQueries, envConfig.TokenSecret, envConfig.Email, envConfig.EmailSecret)
+	var externalProviders []oauth.Provider
+	for _, cfg := range envConfig.ExternalProviders {
+		provider, err := oauth.New(context.Background(), cfg)
+		if err != nil {
+			log.Fatalf("failed to set up login provider: %v", err)
+		}
+		externalProviders = append(externalProviders, provider)
+	}
+
+	auditSink := server.NewDatabaseAuditSink(dbStore, envConfig.AuthEventRetention)
+
+	emailPolicy, err := emailpolicy.NewPolicy(envConfig.EmailAllowedDomains, envConfig.EmailDeniedDomains,
+		envConfig.DisposableDomainsFile)
+	if err != nil {
+		log.Fatalf("failed to set up email policy: %v", err)
+	}
+
+	opts := []server.Option{
+		server.WithVerificationCodes(envConfig.VerificationCodeLength, envConfig.VerificationCodeTTL),
+		server.WithLoginLinks(envConfig.LoginLinkURL, envConfig.LoginLinkTTL),
+		server.WithLoginRateLimit(envConfig.LoginRateLimit, envConfig.LoginRateLimitWindow),
+		server.WithExternalProviders(externalProviders...),
+		server.WithServiceTokenTTL(envConfig.ServiceTokenTTL),
+		server.WithDeviceAuthorization(envConfig.DeviceVerificationURL, envConfig.DeviceCodeTTL, envConfig.DevicePollInterval),
+		server.WithAuditSink(auditSink, envConfig.AuditTrustForwardedFor),
+		server.WithNewSignInAlerts(envConfig.SignInReportURL, envConfig.PasswordResetTTL),
+		server.WithEmailChangeUndo(envConfig.EmailChangeUndoURL, envConfig.EmailChangeGracePeriod),
+		server.WithUsernamePolicy(envConfig.UsernameChangeCooldown, envConfig.UsernameHoldPeriod, envConfig.ReservedUsernames...),
+		server.WithEmailPolicy(emailPolicy),
+		server.WithAccountDeletion(envConfig.AccountDeletionCancelURL, envConfig.AccountDeletionGracePeriod,
+			envConfig.AccountDeletionAnonymize),
+		server.WithDeviceTokenTTL(envConfig.DeviceTokenTTL),
+	}
+
+	var exportStore exportstore.Store
+	if envConfig.DataExportDir != "" {
+		exportStore, err = exportstore.NewLocalDir(envConfig.DataExportDir)
+		if err != nil {
+			log.Fatalf("failed to set up data export storage: %v", err)
+		}
+		opts = append(opts, server.WithDataExports(exportStore, envConfig.DataExportDownloadURL,
+			envConfig.DataExportRetention, envConfig.DataExportTokenTTL))
+	}
+	if len(envConfig.APIKeyScopes) > 0 {
+		opts = append(opts, server.WithAPIKeyScopes(envConfig.APIKeyScopes...))
+	}
+	if envConfig.BillingWebhookSecret != "" {
+		opts = append(opts, server.WithBilling(envConfig.BillingWebhookSecret, envConfig.BillingPricePlans))
+	}
+
+	authServer := server.NewServer(dbStore, envConfig.TokenSecret, envConfig.Email, envConfig.EmailSecret, opts...)
+	authorizer := authz.New(envConfig.TokenSecret, server.Audience, server.AuthorizationPolicy,
+		authz.WithUserCheck(authServer.CheckUserStatus))
+
+	if envConfig.OIDCIssuerURL != "" {
+		go serveOIDC(envConfig, dbStore, authServer)
+	}
+
+	go runPeriodically("lifting expired suspensions", envConfig.SuspensionSweepInterval, authServer.LiftExpiredSuspensions)
+	if envConfig.AuthEventRetention > 0 {
+		go runPeriodically("purging auth events", time.Hour, auditSink.Purge)
+	}
+	go runPeriodically("purging expired email holds", time.Hour, authServer.PurgeExpiredEmailHolds)
+	go runPeriodically("purging deleted accounts", envConfig.AccountDeletionSweepInterval, authServer.PurgeDeletedAccounts)
+	go runPeriodically("purging stale device tokens", envConfig.DeviceTokenSweepInterval, authServer.PurgeStaleDeviceTokens)
+	go runPeriodically("purging rotated refresh tokens", time.Hour, authServer.PurgeRotatedRefreshTokens)
+	go runPeriodically("syncing premium users", envConfig.EntitlementSyncInterval, authServer.SyncPremiumUsers)
+	if envConfig.BillingWebhookSecret != "" {
+		go serveBillingWebhooks(envConfig.BillingWebhookHTTPPort, authServer.BillingWebhookHandler())
+	}
+	if exportStore != nil {
+		go serveDataExports(envConfig.DataExportHTTPPort, authServer.DataExportHandler())
+		go runPeriodically("building data exports", envConfig.DataExportPollInterval, authServer.ProcessDataExports)
+		go runPeriodically("purging expired data exports", time.Hour, authServer.PurgeExpiredDataExports)
+	}
+	if envConfig.Events.Publisher != "" {
+		envConfig.Events.RedisClient = redis.Client
+		publisher, err := outbox.NewPublisher(envConfig.Events)
+		if err != nil {
+			log.Fatalf("failed to set up event publisher: %v", err)
+		}
+		defer publisher.Close()
+
+		relay := outbox.NewRelay(dbStore, publisher, envConfig.EventRetention)
+		go runPeriodically("publishing events", envConfig.EventRelayInterval, relay.Run)
+		go runPeriodically("purging published events", time.Hour, relay.Purge)
+	}
+	dispatcher := webhook.NewDispatcher(dbStore, envConfig.WebhookDeliveryRetention)
+	go runPeriodically("sending webhooks", envConfig.WebhookPollInterval, dispatcher.Run)
+	go runPeriodically("purging webhook deliveries", time.Hour, dispatcher.Purge)
+	if envConfig.DisposableDomainsFile != "" {
+		go runPeriodically("reloading disposable domains", envConfig.DisposableDomainsReloadInterval,
+			func(context.Context) error { return emailPolicy.Reload() })
+	}
 
-	s := grpc.NewServer()
-	pb.RegisterAuthServiceServer(s, server)
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(authorizer.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(authorizer.StreamServerInterceptor()),
+	)
+	pb.RegisterAuthServiceServer(s, authServer)
+	pb.RegisterAdminServiceServer(s, authServer.Admin())
 
 	reflection.Register(s)
 	log.Printf("Server listening on %v", lis.Addr())
@@ -46,3 +183,131 @@ func main() {
 		log.Fatalf("failed to lister: %v", err)
 	}
 }
+
+// runPeriodically runs job every interval for as long as the server is up. Failures are logged and the job
+// is tried again at the next tick
+func runPeriodically(name string, interval time.Duration, job func(context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		if err := job(ctx); err != nil {
+			log.Printf("Error %s: %v", name, err)
+		}
+		cancel()
+	}
+}
+
+// runMigrate handles the "migrate" subcommand, which applies the embedded schema migrations
+func runMigrate(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, migrate.Usage)
+		os.Exit(2)
+	}
+
+	dbConn, err := sql.Open("postgres", helper.GetDatabaseURL())
+	if err != nil {
+		log.Fatalf("Error opening database: %s", err)
+	}
+	defer dbConn.Close()
+
+	if err := migrate.Run(context.Background(), dbConn, args[0], os.Stdout); err != nil {
+		log.Fatalf("migrate %s: %v", args[0], err)
+	}
+}
+
+// runOIDCClient handles the "oidc-client" subcommand, which manages the clients of the OpenID Connect provider
+func runOIDCClient(args []string) {
+	dbConn, err := sql.Open("postgres", helper.GetDatabaseURL())
+	if err != nil {
+		log.Fatalf("Error opening database: %s", err)
+	}
+	defer dbConn.Close()
+
+	if err := idp.RunClientCommand(context.Background(), database.NewStore(dbConn), args, os.Stdout); err != nil {
+		log.Fatalf("oidc-client: %v", err)
+	}
+}
+
+// runServiceClient handles the "service-client" subcommand, which manages the clients that get service tokens
+func runServiceClient(args []string) {
+	dbConn, err := sql.Open("postgres", helper.GetDatabaseURL())
+	if err != nil {
+		log.Fatalf("Error opening database: %s", err)
+	}
+	defer dbConn.Close()
+
+	if err := serviceclient.Run(context.Background(), database.NewStore(dbConn), args, os.Stdout); err != nil {
+		log.Fatalf("service-client: %v", err)
+	}
+}
+
+// runRole handles the "role" subcommand, which assigns and revokes roles of users
+func runRole(args []string) {
+	dbConn, err := sql.Open("postgres", helper.GetDatabaseURL())
+	if err != nil {
+		log.Fatalf("Error opening database: %s", err)
+	}
+	defer dbConn.Close()
+
+	if err := role.Run(context.Background(), database.NewStore(dbConn), args, os.Stdout); err != nil {
+		log.Fatalf("role: %v", err)
+	}
+}
+
+// serveOIDC serves the OpenID Connect provider over HTTP next to the gRPC server
+func serveOIDC(envConfig helper.EnvConfig, dbStore database.DBQuerier, guard idp.LoginGuard) {
+	var key *auth.SigningKey
+	var err error
+	if envConfig.OIDCSigningKeyFile != "" {
+		key, err = auth.LoadSigningKey(envConfig.OIDCSigningKeyFile)
+	} else {
+		log.Println("OIDC_SIGNING_KEY_FILE is not set, signing ID tokens with a temporary key")
+		key, err = auth.GenerateSigningKey()
+	}
+	if err != nil {
+		log.Fatalf("failed to load OIDC signing key: %v", err)
+	}
+
+	provider := idp.New(dbStore, envConfig.TokenSecret, envConfig.OIDCIssuerURL, key, idp.WithLoginGuard(guard))
+	httpServer := &http.Server{
+		Addr:              envConfig.OIDCHTTPPort,
+		Handler:           provider.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	log.Printf("OpenID Connect provider listening on %v", envConfig.OIDCHTTPPort)
+	if err := httpServer.ListenAndServe(); err != nil {
+		log.Fatalf("failed to serve OpenID Connect provider: %v", err)
+	}
+}
+
+// serveDataExports serves the archives of data exports over HTTP next to the gRPC server
+func serveDataExports(port string, handler http.Handler) {
+	httpServer := &http.Server{
+		Addr:              port,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	log.Printf("Data export downloads listening on %v", port)
+	if err := httpServer.ListenAndServe(); err != nil {
+		log.Fatalf("failed to serve data export downloads: %v", err)
+	}
+}
+
+// serveBillingWebhooks receives the webhook events of the billing provider over HTTP next to the gRPC server
+func serveBillingWebhooks(port string, handler http.Handler) {
+	httpServer := &http.Server{
+		Addr:              port,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+	}
+
+	log.Printf("Billing webhooks listening on %v", port)
+	if err := httpServer.ListenAndServe(); err != nil {
+		log.Fatalf("failed to serve billing webhooks: %v", err)
+	}
+}
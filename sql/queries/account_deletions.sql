@@ -0,0 +1,81 @@
+-- name: ScheduleAccountDeletion :exec
+INSERT INTO account_deletions (user_id, token_hash, purge_after)
+VALUES ($1, $2, $3)
+ON CONFLICT (user_id) DO UPDATE
+SET token_hash = EXCLUDED.token_hash, requested_at = NOW(), purge_after = EXCLUDED.purge_after;
+
+-- name: AccountDeletionScheduled :one
+SELECT EXISTS (
+    SELECT 1 FROM account_deletions WHERE user_id = $1
+) AS scheduled;
+
+-- name: TakeAccountDeletion :one
+-- The cancel link stops working once the purge is due, even before the purge job ran
+DELETE FROM account_deletions
+WHERE token_hash = $1 AND purge_after > NOW()
+RETURNING *;
+
+-- name: ListDueAccountDeletions :many
+SELECT user_id FROM account_deletions
+WHERE purge_after <= NOW()
+ORDER BY purge_after
+LIMIT $1;
+
+-- name: ClaimAccountDeletion :execrows
+-- No row is affected when the deletion was cancelled or another replica purged the account first
+DELETE FROM account_deletions
+WHERE user_id = $1 AND purge_after <= NOW();
+
+-- name: DeleteUserPersonalData :exec
+-- Removes everything about the user except the users row, their posts, comments and messages. Foreign keys
+-- without ON DELETE CASCADE, like the one of refresh_tokens, are handled here too. The security log and the admin
+-- audit log have no foreign keys and are kept for their own retention period
+WITH refresh AS (
+    DELETE FROM refresh_tokens WHERE user_id = $1
+), rotated AS (
+    DELETE FROM rotated_refresh_tokens WHERE user_id = $1
+), devices AS (
+    DELETE FROM device_tokens WHERE user_id = $1
+), identities AS (
+    DELETE FROM user_identities WHERE user_id = $1
+), keys AS (
+    DELETE FROM api_keys WHERE user_id = $1
+), consents AS (
+    DELETE FROM oauth_consents WHERE user_id = $1
+), known AS (
+    DELETE FROM known_devices WHERE user_id = $1
+), codes AS (
+    DELETE FROM verification_codes WHERE user_id = $1
+), holds AS (
+    DELETE FROM email_holds WHERE user_id = $1
+), renames AS (
+    DELETE FROM username_changes WHERE user_id = $1
+), roles AS (
+    DELETE FROM user_roles WHERE user_id = $1
+), entitlements AS (
+    DELETE FROM user_entitlements WHERE user_id = $1
+)
+DELETE FROM follows WHERE follower_id = $1 OR followee_id = $1;
+
+-- name: DeleteUserContent :exec
+-- Comments of other users on the user's posts go with the posts
+WITH user_comments AS (
+    DELETE FROM comments WHERE user_id = $1
+), user_messages AS (
+    DELETE FROM messages WHERE sender_id = $1 OR receiver_id = $1
+)
+DELETE FROM posts WHERE posted_by = $1;
+
+-- name: DeleteUser :execrows
+DELETE FROM users
+WHERE id = $1;
+
+-- name: AnonymizeUser :one
+-- The row stays so posts, comments and messages of the user keep their author, but nothing in it identifies
+-- the user anymore
+UPDATE users
+SET email = $2, email_canonical = NULL, username = $3, password = '', is_private = FALSE, is_premium = FALSE,
+    is_verified = FALSE, status = 'deleted', status_reason = '', status_until = NULL,
+    status_changed_at = NOW(), updated_at = NOW()
+WHERE id = $1
+RETURNING *;
\ No newline at end of file
@@ -0,0 +1,8 @@
+-- name: CreateAdminAuditEntry :exec
+INSERT INTO admin_audit_log (actor, action, target_user_id, details)
+VALUES (
+   $1,
+   $2,
+   $3,
+   $4
+);
\ No newline at end of file
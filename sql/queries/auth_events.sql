@@ -0,0 +1,23 @@
+-- name: CreateAuthEvent :exec
+INSERT INTO auth_events (user_id, type, actor, ip_address, user_agent, session_id, details)
+VALUES (
+   $1,
+   $2,
+   $3,
+   $4,
+   $5,
+   $6,
+   $7
+);
+
+-- name: ListAuthEvents :many
+SELECT * FROM auth_events
+WHERE (sqlc.narg('user_id')::uuid IS NULL OR user_id = sqlc.narg('user_id'))
+  AND (coalesce(cardinality(sqlc.arg('types')::text[]), 0) = 0 OR type = ANY(sqlc.arg('types')::text[]))
+  AND (sqlc.narg('before_id')::bigint IS NULL OR id < sqlc.narg('before_id'))
+ORDER BY id DESC
+LIMIT sqlc.arg('page_size');
+
+-- name: DeleteAuthEventsBefore :execrows
+DELETE FROM auth_events
+WHERE created_at < $1;
\ No newline at end of file
@@ -0,0 +1,70 @@
+-- name: CreateDataExport :one
+INSERT INTO data_exports (id, user_id)
+VALUES (
+   $1,
+   $2
+)
+RETURNING *;
+
+-- name: GetActiveDataExport :one
+SELECT * FROM data_exports
+WHERE user_id = $1 AND status IN ('pending', 'running');
+
+-- name: GetDataExport :one
+SELECT * FROM data_exports
+WHERE id = $1 AND user_id = $2;
+
+-- Takes the oldest pending export, or one whose job died while building it, for building.
+-- SKIP LOCKED lets several replicas run the job at once
+-- name: ClaimDataExport :one
+UPDATE data_exports
+SET status = 'running', started_at = NOW(), attempts = attempts + 1
+WHERE id = (
+    SELECT id FROM data_exports
+    WHERE (status = 'pending' OR (status = 'running' AND started_at < sqlc.arg('stale_before')))
+      AND attempts < sqlc.arg('max_attempts')
+    ORDER BY created_at
+    LIMIT 1
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: CompleteDataExport :exec
+UPDATE data_exports
+SET status = 'ready', storage_key = $2, size_bytes = $3, error = '', completed_at = NOW(), expires_at = $4
+WHERE id = $1;
+
+-- name: FailDataExport :exec
+UPDATE data_exports
+SET status = 'failed', error = $2, completed_at = NOW()
+WHERE id = $1;
+
+-- Exports whose job died too often are failed, so they don't block new requests
+-- name: FailAbandonedDataExports :execrows
+UPDATE data_exports
+SET status = 'failed', error = 'the export job stopped too often', completed_at = NOW()
+WHERE status = 'running' AND started_at < sqlc.arg('stale_before') AND attempts >= sqlc.arg('max_attempts');
+
+-- name: ListExpiredDataExports :many
+SELECT * FROM data_exports
+WHERE status = 'ready' AND expires_at <= NOW()
+ORDER BY expires_at
+LIMIT $1;
+
+-- name: ExpireDataExport :exec
+UPDATE data_exports
+SET status = 'expired', storage_key = ''
+WHERE id = $1;
+
+-- Expires every ready export of a user, returning the storage keys of the archives to delete
+-- name: ExpireUserDataExports :many
+WITH ready AS (
+    SELECT id, storage_key FROM data_exports
+    WHERE user_id = $1 AND status = 'ready'
+    FOR UPDATE
+)
+UPDATE data_exports
+SET status = 'expired', storage_key = ''
+FROM ready
+WHERE data_exports.id = ready.id
+RETURNING ready.storage_key;
\ No newline at end of file
@@ -0,0 +1,53 @@
+-- name: ListDeviceTokens :many
+SELECT * FROM device_tokens
+WHERE user_id = $1
+ORDER BY created_at;
+
+-- Registers a push token for a session of a user, or moves it to the session when it was registered before. A
+-- token identifies one app installation, so it is taken away from other users first
+-- name: UpsertDeviceToken :one
+WITH taken AS (
+    DELETE FROM device_tokens
+    WHERE device_token = sqlc.arg('device_token') AND user_id <> sqlc.arg('user_id')
+)
+INSERT INTO device_tokens (id, user_id, device_token, device_type, session_id)
+VALUES (
+   sqlc.arg('id'),
+   sqlc.arg('user_id'),
+   sqlc.arg('device_token'),
+   sqlc.arg('device_type'),
+   sqlc.arg('session_id')
+)
+ON CONFLICT (user_id, device_token) DO UPDATE
+SET device_type = EXCLUDED.device_type, session_id = EXCLUDED.session_id, updated_at = NOW()
+RETURNING *;
+
+-- name: DeleteDeviceToken :execrows
+DELETE FROM device_tokens
+WHERE user_id = $1 AND device_token = $2;
+
+-- name: DeleteSessionDeviceTokens :exec
+DELETE FROM device_tokens
+WHERE user_id = $1 AND session_id = $2;
+
+-- name: DeleteUserDeviceTokens :exec
+DELETE FROM device_tokens
+WHERE user_id = $1;
+
+-- Keeps the push tokens of a session when its refresh token is rotated
+-- name: MoveSessionDeviceTokens :exec
+UPDATE device_tokens
+SET session_id = sqlc.arg('new_session_id')
+WHERE user_id = sqlc.arg('user_id') AND session_id = sqlc.arg('old_session_id');
+
+-- Deletes the push tokens that weren't registered again since updated_before, and those whose session ended
+-- without removing them
+-- name: DeleteStaleDeviceTokens :execrows
+DELETE FROM device_tokens d
+WHERE d.updated_at < sqlc.arg('updated_before')
+   OR NOT EXISTS (
+       SELECT 1 FROM refresh_tokens r
+       WHERE r.user_id = d.user_id
+         AND r.session_id = d.session_id
+         AND r.expiry_time > NOW()
+   );
\ No newline at end of file
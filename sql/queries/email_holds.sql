@@ -0,0 +1,25 @@
+-- name: HoldEmail :execrows
+-- An active hold is kept, so the undo link always restores the address the account had before the first change
+-- of the grace period. No row is affected then
+INSERT INTO email_holds (user_id, email, was_verified, changed_to, token_hash, expires_at)
+VALUES (
+   $1,
+   $2,
+   $3,
+   $4,
+   $5,
+   $6
+)
+ON CONFLICT (user_id) DO UPDATE
+SET email = EXCLUDED.email, was_verified = EXCLUDED.was_verified, changed_to = EXCLUDED.changed_to,
+    token_hash = EXCLUDED.token_hash, expires_at = EXCLUDED.expires_at, created_at = NOW()
+WHERE email_holds.expires_at <= NOW();
+
+-- name: TakeEmailHold :one
+DELETE FROM email_holds
+WHERE token_hash = $1 AND expires_at > NOW()
+RETURNING *;
+
+-- name: DeleteExpiredEmailHolds :execrows
+DELETE FROM email_holds
+WHERE expires_at <= NOW();
\ No newline at end of file
@@ -0,0 +1,56 @@
+-- name: GetPlan :one
+SELECT * FROM plans
+WHERE id = $1;
+
+-- name: ListPlans :many
+SELECT * FROM plans
+ORDER BY id;
+
+-- name: CreateEntitlement :one
+INSERT INTO user_entitlements (id, user_id, plan_id, source, starts_at, expires_at, granted_by)
+VALUES ($1, $2, $3, 'admin', $4, $5, $6)
+RETURNING *;
+
+-- name: RevokeEntitlement :one
+-- Revoking an entitlement again keeps the time of the first revocation
+UPDATE user_entitlements
+SET revoked_at = COALESCE(revoked_at, NOW()), updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: ListUserEntitlements :many
+SELECT * FROM user_entitlements
+WHERE user_id = $1
+ORDER BY created_at DESC;
+
+-- name: UpsertBillingEntitlement :one
+-- No row is returned when the entitlement was already updated by a newer billing event
+INSERT INTO user_entitlements (id, user_id, plan_id, source, external_id, starts_at, expires_at, revoked_at, source_updated_at)
+VALUES ($1, $2, $3, 'billing', $4, $5, $6, $7, $8)
+ON CONFLICT (external_id) DO UPDATE
+SET plan_id = EXCLUDED.plan_id, starts_at = EXCLUDED.starts_at, expires_at = EXCLUDED.expires_at,
+    revoked_at = EXCLUDED.revoked_at, source_updated_at = EXCLUDED.source_updated_at, updated_at = NOW()
+WHERE user_entitlements.source_updated_at IS NULL OR user_entitlements.source_updated_at <= EXCLUDED.source_updated_at
+RETURNING *;
+
+-- name: RecordBillingEvent :execrows
+-- No row is affected when the event was applied before
+INSERT INTO billing_events (id, type)
+VALUES ($1, $2)
+ON CONFLICT (id) DO NOTHING;
+
+-- name: SyncPremiumUsers :many
+-- Sets is_premium of the users with entitlements to premium plans, all of them or only user_id, to whether one of
+-- those entitlements is active. Users without any keep the is_premium they have. Returns the users that changed
+UPDATE users u
+SET is_premium = a.premium, updated_at = NOW()
+FROM (
+    SELECT e.user_id,
+           bool_or(e.revoked_at IS NULL AND e.starts_at <= NOW() AND (e.expires_at IS NULL OR e.expires_at > NOW())) AS premium
+    FROM user_entitlements e
+    JOIN plans p ON p.id = e.plan_id
+    WHERE p.premium AND (sqlc.narg('user_id')::uuid IS NULL OR e.user_id = sqlc.narg('user_id'))
+    GROUP BY e.user_id
+) a
+WHERE u.id = a.user_id AND u.is_premium <> a.premium
+RETURNING u.id, u.is_premium;
\ No newline at end of file
@@ -0,0 +1,42 @@
+-- name: CreateOutboxEvent :exec
+INSERT INTO events_outbox (event_id, event_type, user_id, payload)
+VALUES (
+   $1,
+   $2,
+   $3,
+   $4
+);
+
+-- Takes the next unpublished events for publishing. Only the oldest unpublished event of each user is taken, so a
+-- user's events are published in order even when an earlier one is waiting for a retry or claimed by another
+-- replica. next_attempt_at is pushed to lease_until, so the events are tried again if the relay dies before
+-- reporting back. SKIP LOCKED lets several replicas run the relay at once
+-- name: ClaimOutboxEvents :many
+UPDATE events_outbox
+SET attempts = attempts + 1, next_attempt_at = sqlc.arg('lease_until')
+WHERE id IN (
+    SELECT o.id FROM events_outbox o
+    WHERE o.published_at IS NULL AND o.next_attempt_at <= NOW()
+      AND NOT EXISTS (
+          SELECT 1 FROM events_outbox earlier
+          WHERE earlier.user_id = o.user_id AND earlier.published_at IS NULL AND earlier.id < o.id
+      )
+    ORDER BY o.id
+    LIMIT sqlc.arg('batch_size')
+    FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: MarkOutboxEventPublished :exec
+UPDATE events_outbox
+SET published_at = NOW(), last_error = ''
+WHERE id = $1;
+
+-- name: RetryOutboxEvent :exec
+UPDATE events_outbox
+SET last_error = $2, next_attempt_at = $3
+WHERE id = $1;
+
+-- name: DeletePublishedOutboxEvents :execrows
+DELETE FROM events_outbox
+WHERE published_at < $1;
\ No newline at end of file
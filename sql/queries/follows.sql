@@ -0,0 +1,67 @@
+-- name: CreateFollow :one
+-- Follows of private accounts start as pending requests. Following again returns the existing follow. No row is
+-- returned when the followee doesn't exist or was deleted
+INSERT INTO follows (follower_id, followee_id, status, accepted_at)
+SELECT sqlc.arg('follower_id')::uuid, u.id,
+       CASE WHEN u.is_private THEN 'pending' ELSE 'accepted' END,
+       CASE WHEN u.is_private THEN NULL ELSE NOW() END
+FROM users u
+WHERE u.id = sqlc.arg('followee_id') AND u.status <> 'deleted'
+ON CONFLICT (follower_id, followee_id) DO UPDATE
+SET status = follows.status
+RETURNING *;
+
+-- name: GetFollow :one
+SELECT * FROM follows
+WHERE follower_id = $1 AND followee_id = $2;
+
+-- name: DeleteFollow :execrows
+-- Removes a follow as well as a pending request
+DELETE FROM follows
+WHERE follower_id = $1 AND followee_id = $2;
+
+-- name: AcceptFollowRequest :execrows
+UPDATE follows
+SET status = 'accepted', accepted_at = NOW()
+WHERE follower_id = $1 AND followee_id = $2 AND status = 'pending';
+
+-- name: DeleteFollowRequest :execrows
+DELETE FROM follows
+WHERE follower_id = $1 AND followee_id = $2 AND status = 'pending';
+
+-- name: AcceptAllFollowRequests :execrows
+UPDATE follows
+SET status = 'accepted', accepted_at = NOW()
+WHERE followee_id = $1 AND status = 'pending';
+
+-- name: CountFollows :one
+-- Only accepted follows count
+SELECT
+    (SELECT COUNT(*) FROM follows WHERE followee_id = $1 AND status = 'accepted') AS followers,
+    (SELECT COUNT(*) FROM follows WHERE follower_id = $1 AND status = 'accepted') AS following;
+
+-- name: ListFollowers :many
+-- Newest first. The cursor is the follow time and user ID of the last follower of the previous page
+SELECT u.id, u.username, f.created_at AS followed_at
+FROM follows f
+JOIN users u ON u.id = f.follower_id
+WHERE f.followee_id = sqlc.arg('user_id') AND f.status = sqlc.arg('status')
+  AND (sqlc.narg('cursor_created_at')::timestamp IS NULL OR (f.created_at, f.follower_id) < (sqlc.narg('cursor_created_at'), sqlc.narg('cursor_id')::uuid))
+ORDER BY f.created_at DESC, f.follower_id DESC
+LIMIT sqlc.arg('page_size');
+
+-- name: ListFollowing :many
+-- Newest first. The cursor is the follow time and user ID of the last followee of the previous page
+SELECT u.id, u.username, f.created_at AS followed_at
+FROM follows f
+JOIN users u ON u.id = f.followee_id
+WHERE f.follower_id = sqlc.arg('user_id') AND f.status = sqlc.arg('status')
+  AND (sqlc.narg('cursor_created_at')::timestamp IS NULL OR (f.created_at, f.followee_id) < (sqlc.narg('cursor_created_at'), sqlc.narg('cursor_id')::uuid))
+ORDER BY f.created_at DESC, f.followee_id DESC
+LIMIT sqlc.arg('page_size');
+
+-- name: ListUserFollows :many
+-- Both directions, for data exports
+SELECT * FROM follows
+WHERE follower_id = $1 OR followee_id = $1
+ORDER BY created_at;
\ No newline at end of file
@@ -0,0 +1,13 @@
+-- name: RememberDevice :one
+-- known_devices counts the devices the user had before this one, the subquery doesn't see the inserted row
+INSERT INTO known_devices (user_id, fingerprint, user_agent, ip_prefix)
+VALUES (
+   $1,
+   $2,
+   $3,
+   $4
+)
+ON CONFLICT (user_id, fingerprint) DO UPDATE
+SET last_seen_at = NOW()
+RETURNING (xmax = 0)::boolean AS is_new,
+    (SELECT COUNT(*) FROM known_devices k WHERE k.user_id = $1) AS known_devices;
\ No newline at end of file
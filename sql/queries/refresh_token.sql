@@ -1,9 +1,11 @@
 -- name: RefreshToken :one
-INSERT INTO refresh_tokens (token, user_id, expiry_time) 
+INSERT INTO refresh_tokens (token, user_id, expiry_time, session_id, family_id) 
 VALUES (
    $1, 
    $2, 
-   $3
+   $3,
+   $4,
+   $5
 )
 RETURNING *;
 
@@ -15,3 +17,30 @@ WHERE token = $1;
 DELETE FROM refresh_tokens
 WHERE user_id = $1;
 
+-- name: DeleteRefreshTokenByToken :exec
+DELETE FROM refresh_tokens
+WHERE token = $1;
+
+
+-- name: ListRefreshTokens :many
+SELECT * FROM refresh_tokens
+WHERE user_id = $1
+ORDER BY created_at;;
+
+-- name: DeleteRefreshTokenFamily :many
+DELETE FROM refresh_tokens
+WHERE user_id = $1 AND family_id = $2
+RETURNING session_id;
+
+-- name: RememberRotatedRefreshToken :exec
+INSERT INTO rotated_refresh_tokens (token_hash, user_id, family_id, expires_at)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (token_hash) DO NOTHING;
+
+-- name: GetRotatedRefreshToken :one
+SELECT * FROM rotated_refresh_tokens
+WHERE token_hash = $1 AND expires_at > NOW();
+
+-- name: DeleteExpiredRotatedRefreshTokens :execrows
+DELETE FROM rotated_refresh_tokens
+WHERE expires_at <= NOW();
\ No newline at end of file
@@ -0,0 +1,66 @@
+-- name: GetRole :one
+SELECT * FROM roles
+WHERE name = $1;
+
+-- name: ListRoles :many
+SELECT
+    r.name,
+    r.description,
+    COALESCE(array_agg(rp.permission ORDER BY rp.permission) FILTER (WHERE rp.permission IS NOT NULL), '{}')::text[] AS permissions
+FROM roles r
+LEFT JOIN role_permissions rp ON rp.role = r.name
+GROUP BY r.name
+ORDER BY r.name;
+
+-- name: ListUserRoles :many
+SELECT
+    r.name,
+    r.description,
+    COALESCE(array_agg(rp.permission ORDER BY rp.permission) FILTER (WHERE rp.permission IS NOT NULL), '{}')::text[] AS permissions
+FROM user_roles ur
+JOIN roles r ON r.name = ur.role
+LEFT JOIN role_permissions rp ON rp.role = r.name
+WHERE ur.user_id = $1
+GROUP BY r.name
+ORDER BY r.name;
+
+-- name: GetUserAuthorization :one
+-- Users with entitlements to premium plans are premium while one of them is active, others keep is_premium
+SELECT
+    u.status,
+    u.status_until,
+    COALESCE(array_agg(DISTINCT ur.role) FILTER (WHERE ur.role IS NOT NULL), '{}')::text[] AS roles,
+    COALESCE(array_agg(DISTINCT rp.permission) FILTER (WHERE rp.permission IS NOT NULL), '{}')::text[] AS permissions,
+    (CASE
+        WHEN EXISTS (SELECT 1 FROM user_entitlements e JOIN plans p ON p.id = e.plan_id WHERE e.user_id = u.id AND p.premium)
+        THEN EXISTS (
+            SELECT 1 FROM user_entitlements e JOIN plans p ON p.id = e.plan_id
+            WHERE e.user_id = u.id AND p.premium AND e.revoked_at IS NULL AND e.starts_at <= NOW()
+              AND (e.expires_at IS NULL OR e.expires_at > NOW())
+        )
+        ELSE u.is_premium
+    END)::boolean AS premium,
+    ARRAY(
+        SELECT DISTINCT e.plan_id FROM user_entitlements e
+        WHERE e.user_id = u.id AND e.revoked_at IS NULL AND e.starts_at <= NOW()
+          AND (e.expires_at IS NULL OR e.expires_at > NOW())
+        ORDER BY e.plan_id
+    )::text[] AS entitlements
+FROM users u
+LEFT JOIN user_roles ur ON ur.user_id = u.id
+LEFT JOIN role_permissions rp ON rp.role = ur.role
+WHERE u.id = $1
+GROUP BY u.id;
+
+-- name: AssignRole :execrows
+INSERT INTO user_roles (user_id, role, granted_by)
+VALUES (
+   $1,
+   $2,
+   $3
+)
+ON CONFLICT (user_id, role) DO NOTHING;
+
+-- name: RevokeRole :execrows
+DELETE FROM user_roles
+WHERE user_id = $1 AND role = $2;
\ No newline at end of file
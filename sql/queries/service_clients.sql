@@ -0,0 +1,31 @@
+-- name: CreateServiceClient :one
+INSERT INTO service_clients (id, name, secret_hash, scopes, audiences)
+VALUES (
+   $1,
+   $2,
+   $3,
+   $4,
+   $5
+)
+RETURNING *;
+
+-- name: GetServiceClient :one
+SELECT * FROM service_clients
+WHERE id = $1;
+
+-- name: ListServiceClients :many
+SELECT * FROM service_clients
+ORDER BY name;
+
+-- name: RotateServiceClientSecret :one
+UPDATE service_clients
+SET previous_secret_hash = secret_hash,
+    previous_secret_expires_at = $3,
+    secret_hash = $2,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: DeleteServiceClient :execrows
+DELETE FROM service_clients
+WHERE id = $1;
\ No newline at end of file
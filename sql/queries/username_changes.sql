@@ -0,0 +1,14 @@
+-- name: CreateUsernameChange :exec
+INSERT INTO username_changes (user_id, old_username, new_username, held_until)
+VALUES (
+   $1,
+   $2,
+   $3,
+   $4
+);
+
+-- name: GetLastUsernameChange :one
+SELECT changed_at FROM username_changes
+WHERE user_id = $1
+ORDER BY changed_at DESC
+LIMIT 1;
\ No newline at end of file
@@ -1,6 +1,7 @@
 -- name: CreateUser :one
-INSERT INTO users (id, created_at, updated_at, email, password, username, is_premium, verification_code, is_verified)
-VALUES (
+-- Addresses held for the undo link of an email change can't be registered, no row is returned for them
+INSERT INTO users (id, created_at, updated_at, email, password, username, is_premium, is_verified, email_canonical)
+SELECT
    $1,
    NOW(),
    NOW(),
@@ -9,25 +10,98 @@ VALUES (
    $4,
    $5,
    $6,
-   $7
-)
+   sqlc.arg('email_canonical')::text
+WHERE NOT EXISTS (SELECT 1 FROM email_holds WHERE lower(email) = sqlc.arg('email_canonical')::text AND expires_at > NOW())
 RETURNING *;
 
 -- name: GetUserByIdentifier :one
+-- Emails match by their canonical form and usernames regardless of case. An empty username matches nobody
 SELECT * FROM users
-WHERE email = $1 OR username = $2;
+WHERE email_canonical = sqlc.arg('email_canonical')::text OR (sqlc.arg('username') <> '' AND lower(username) = lower(sqlc.arg('username')));
 
--- name: StoreVerificationCode :exec
-UPDATE users 
-SET verification_code = $1, is_verified = FALSE
-WHERE id = $2;
+-- name: GetUserByID :one
+SELECT * FROM users
+WHERE id = $1;
 
--- name: VerifyUser :exec
+-- Returns no row when the user is already verified
+-- name: VerifyUser :one
 UPDATE users 
-SET is_verified = TRUE, verification_code = 0
-WHERE email = $1;
+SET is_verified = TRUE, updated_at = NOW()
+WHERE email_canonical = sqlc.arg('email_canonical')::text AND NOT is_verified
+RETURNING id;
 
--- name: SendVerifyCodeAgain :exec
-UPDATE users 
-SET verification_code = $1, is_verified = FALSE
-WHERE id = $2;
+-- name: ListUsers :many
+SELECT * FROM users
+WHERE (sqlc.narg('is_verified')::boolean IS NULL OR is_verified = sqlc.narg('is_verified'))
+  AND (sqlc.narg('is_premium')::boolean IS NULL OR is_premium = sqlc.narg('is_premium'))
+  AND (sqlc.narg('created_after')::timestamp IS NULL OR created_at >= sqlc.narg('created_after'))
+  AND (sqlc.narg('created_before')::timestamp IS NULL OR created_at < sqlc.narg('created_before'))
+  AND (sqlc.narg('status')::text IS NULL OR status = sqlc.narg('status'))
+  AND (sqlc.narg('search')::text IS NULL OR email ILIKE '%' || sqlc.narg('search') || '%' OR username ILIKE '%' || sqlc.narg('search') || '%')
+  AND (sqlc.narg('cursor_created_at')::timestamp IS NULL OR (created_at, id) < (sqlc.narg('cursor_created_at'), sqlc.narg('cursor_id')::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('page_size');
+
+-- name: UpdateUser :one
+UPDATE users
+SET email = COALESCE(sqlc.narg('email'), email),
+    email_canonical = COALESCE(sqlc.narg('email_canonical'), email_canonical),
+    username = COALESCE(sqlc.narg('username'), username),
+    is_premium = COALESCE(sqlc.narg('is_premium'), is_premium),
+    is_verified = COALESCE(sqlc.narg('is_verified'), is_verified),
+    updated_at = NOW()
+WHERE id = sqlc.arg('id')
+RETURNING *;
+
+-- name: SetUserStatus :one
+UPDATE users
+SET status = $2, status_reason = $3, status_until = $4, status_changed_at = NOW(), updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: SetUserPrivacy :one
+UPDATE users
+SET is_private = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: GetUserStatus :one
+SELECT status, status_until FROM users
+WHERE id = $1;
+
+-- name: LiftExpiredSuspensions :execrows
+UPDATE users
+SET status = 'active', status_reason = '', status_until = NULL, status_changed_at = NOW(), updated_at = NOW()
+WHERE status = 'suspended' AND status_until <= NOW();
+
+-- name: SetUserPassword :exec
+UPDATE users
+SET password = $2, updated_at = NOW()
+WHERE id = $1;
+
+-- name: ChangeUserEmail :one
+-- No row is returned when another user holds the address
+UPDATE users
+SET email = $2, email_canonical = sqlc.arg('email_canonical')::text, is_verified = $3, updated_at = NOW()
+WHERE id = $1
+  AND NOT EXISTS (
+      SELECT 1 FROM email_holds h
+      WHERE lower(h.email) = sqlc.arg('email_canonical')::text AND h.user_id <> $1 AND h.expires_at > NOW()
+  )
+RETURNING *;
+
+-- name: UsernameAvailable :one
+-- Names are compared regardless of case. Names the user holds after a change stay available to them
+SELECT NOT EXISTS (
+      SELECT 1 FROM users
+      WHERE lower(username) = lower(sqlc.arg('username')) AND id <> sqlc.arg('user_id')
+   ) AND NOT EXISTS (
+      SELECT 1 FROM username_changes
+      WHERE lower(old_username) = lower(sqlc.arg('username')) AND user_id <> sqlc.arg('user_id') AND held_until > NOW()
+   ) AS available;
+
+-- name: SetUsername :one
+UPDATE users
+SET username = $2, updated_at = NOW()
+WHERE id = $1
+RETURNING *;
\ No newline at end of file
@@ -0,0 +1,35 @@
+-- name: UpsertVerificationCode :one
+INSERT INTO verification_codes (user_id, purpose, code_hash, expires_at, device_hash, new_email)
+VALUES (
+   $1,
+   $2,
+   $3,
+   $4,
+   $5,
+   $6
+)
+ON CONFLICT (user_id, purpose) DO UPDATE
+SET code_hash = EXCLUDED.code_hash, expires_at = EXCLUDED.expires_at, device_hash = EXCLUDED.device_hash, new_email = EXCLUDED.new_email, attempts = 0, created_at = NOW()
+RETURNING *;
+
+-- name: GetVerificationCode :one
+SELECT * FROM verification_codes
+WHERE user_id = $1 AND purpose = $2;
+
+-- name: IncrementVerificationCodeAttempts :one
+UPDATE verification_codes
+SET attempts = attempts + 1
+WHERE user_id = $1 AND purpose = $2
+RETURNING attempts;
+
+-- name: ConsumeVerificationCode :execrows
+DELETE FROM verification_codes
+WHERE user_id = $1 AND purpose = $2 AND code_hash = $3;
+
+-- name: DeleteVerificationCode :exec
+DELETE FROM verification_codes
+WHERE user_id = $1 AND purpose = $2;
+
+-- name: DeleteVerificationCodeByEmail :exec
+DELETE FROM verification_codes
+WHERE purpose = $1 AND user_id IN (SELECT id FROM users WHERE email_canonical = sqlc.arg('email_canonical')::text);
\ No newline at end of file
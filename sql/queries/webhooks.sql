@@ -0,0 +1,105 @@
+-- name: CreateWebhookEndpoint :one
+INSERT INTO webhook_endpoints (id, url, secret, description, event_types, created_by)
+VALUES (
+   $1,
+   $2,
+   $3,
+   $4,
+   $5,
+   $6
+)
+RETURNING *;
+
+-- name: ListWebhookEndpoints :many
+SELECT * FROM webhook_endpoints
+ORDER BY created_at, id;
+
+-- name: DeleteWebhookEndpoint :execrows
+DELETE FROM webhook_endpoints
+WHERE id = $1;
+
+-- Re-enables an endpoint and forgets its failures
+-- name: EnableWebhookEndpoint :execrows
+UPDATE webhook_endpoints
+SET consecutive_failures = 0, disabled_at = NULL, disabled_reason = ''
+WHERE id = $1;
+
+-- name: RecordWebhookEndpointSuccess :exec
+UPDATE webhook_endpoints
+SET consecutive_failures = 0
+WHERE id = $1;
+
+-- Counts a failed attempt and disables the endpoint once max_failures attempts in a row failed
+-- name: RecordWebhookEndpointFailure :one
+UPDATE webhook_endpoints
+SET consecutive_failures = consecutive_failures + 1,
+    disabled_at = CASE
+        WHEN disabled_at IS NULL AND consecutive_failures + 1 >= sqlc.arg('max_failures')::int THEN NOW()
+        ELSE disabled_at
+    END,
+    disabled_reason = CASE
+        WHEN disabled_at IS NULL AND consecutive_failures + 1 >= sqlc.arg('max_failures')::int THEN sqlc.arg('disabled_reason')::text
+        ELSE disabled_reason
+    END
+WHERE id = sqlc.arg('id')
+RETURNING *;
+
+-- Creates a delivery of an event for every enabled endpoint that receives its type
+-- name: CreateWebhookDeliveries :exec
+INSERT INTO webhook_deliveries (endpoint_id, event_id, event_type, payload)
+SELECT id, sqlc.arg('event_id'), sqlc.arg('event_type')::text, sqlc.arg('payload')
+FROM webhook_endpoints
+WHERE disabled_at IS NULL
+  AND (cardinality(event_types) = 0 OR sqlc.arg('event_type')::text = ANY(event_types));
+
+-- Takes the next due deliveries of enabled endpoints for sending. next_attempt_at is pushed to lease_until, so
+-- the deliveries are tried again if the sender dies before reporting back. SKIP LOCKED lets several replicas send
+-- at once
+-- name: ClaimWebhookDeliveries :many
+UPDATE webhook_deliveries d
+SET attempts = d.attempts + 1, next_attempt_at = sqlc.arg('lease_until')
+FROM webhook_endpoints e
+WHERE e.id = d.endpoint_id AND d.id IN (
+    SELECT dd.id FROM webhook_deliveries dd
+    JOIN webhook_endpoints de ON de.id = dd.endpoint_id
+    WHERE dd.status = 'pending' AND dd.next_attempt_at <= NOW() AND de.disabled_at IS NULL
+    ORDER BY dd.id
+    LIMIT sqlc.arg('batch_size')
+    FOR UPDATE OF dd SKIP LOCKED
+)
+RETURNING d.id, d.endpoint_id, d.event_id, d.event_type, d.payload, d.attempts, e.url, e.secret;
+
+-- name: CompleteWebhookDelivery :exec
+UPDATE webhook_deliveries
+SET status = 'succeeded', response_status = $2, last_error = '', last_attempt_at = NOW(), delivered_at = NOW()
+WHERE id = $1;
+
+-- name: RetryWebhookDelivery :exec
+UPDATE webhook_deliveries
+SET response_status = $2, last_error = $3, last_attempt_at = NOW(), next_attempt_at = $4
+WHERE id = $1;
+
+-- name: FailWebhookDelivery :exec
+UPDATE webhook_deliveries
+SET status = 'failed', response_status = $2, last_error = $3, last_attempt_at = NOW()
+WHERE id = $1;
+
+-- name: ListWebhookDeliveries :many
+SELECT * FROM webhook_deliveries
+WHERE endpoint_id = sqlc.arg('endpoint_id')
+  AND (sqlc.narg('status')::text IS NULL OR status = sqlc.narg('status'))
+  AND (sqlc.narg('before_id')::bigint IS NULL OR id < sqlc.narg('before_id'))
+ORDER BY id DESC
+LIMIT sqlc.arg('page_size');
+
+-- Sends failed deliveries of an endpoint again, all of them or only delivery_id
+-- name: ReplayWebhookDeliveries :execrows
+UPDATE webhook_deliveries
+SET status = 'pending', attempts = 0, next_attempt_at = NOW()
+WHERE endpoint_id = sqlc.arg('endpoint_id')
+  AND status = 'failed'
+  AND (sqlc.narg('delivery_id')::bigint IS NULL OR id = sqlc.narg('delivery_id'));
+
+-- name: DeleteFinishedWebhookDeliveries :execrows
+DELETE FROM webhook_deliveries
+WHERE status <> 'pending' AND created_at < $1;
\ No newline at end of file
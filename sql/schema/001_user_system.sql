@@ -36,8 +36,6 @@ CREATE TABLE refresh_tokens (
 );
 
 -- +goose Down
-DROP TABLE users;
-DROP INDEX idx_users_username;
-DROP TABLE device_token;
-DROP INDEX idx_device_tokens_user_id;
 DROP TABLE refresh_tokens;
+DROP TABLE device_tokens;
+DROP TABLE users;
@@ -0,0 +1,18 @@
+-- +goose Up
+CREATE TABLE verification_codes (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose TEXT NOT NULL CHECK (purpose IN ('email_verify', 'email_change', 'password_reset')),
+    code_hash TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, purpose)
+);
+
+ALTER TABLE users DROP COLUMN verification_code;
+ALTER TABLE users DROP COLUMN verification_expire_time;
+
+-- +goose Down
+ALTER TABLE users ADD COLUMN verification_code INT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN verification_expire_time TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP;
+DROP TABLE verification_codes;
@@ -0,0 +1,14 @@
+-- +goose Up
+ALTER TABLE verification_codes DROP CONSTRAINT verification_codes_purpose_check;
+ALTER TABLE verification_codes ADD CONSTRAINT verification_codes_purpose_check
+    CHECK (purpose IN ('email_verify', 'email_change', 'password_reset', 'login_code', 'login_link'));
+
+-- Keyed hash of the device that requested the code. Empty when the code isn't bound to a device
+ALTER TABLE verification_codes ADD COLUMN device_hash TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+DELETE FROM verification_codes WHERE purpose IN ('login_code', 'login_link');
+ALTER TABLE verification_codes DROP COLUMN device_hash;
+ALTER TABLE verification_codes DROP CONSTRAINT verification_codes_purpose_check;
+ALTER TABLE verification_codes ADD CONSTRAINT verification_codes_purpose_check
+    CHECK (purpose IN ('email_verify', 'email_change', 'password_reset'));
@@ -0,0 +1,18 @@
+-- +goose Up
+CREATE TABLE service_clients (
+    id TEXT NOT NULL PRIMARY KEY,
+    name TEXT NOT NULL,
+    -- SHA-256 of the client secret
+    secret_hash TEXT NOT NULL,
+    -- The secret replaced by the latest rotation keeps working until previous_secret_expires_at,
+    -- so services can be redeployed with the new one without downtime
+    previous_secret_hash TEXT NOT NULL DEFAULT '',
+    previous_secret_expires_at TIMESTAMP,
+    scopes TEXT[] NOT NULL,
+    audiences TEXT[] NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE service_clients;
@@ -0,0 +1,19 @@
+-- +goose Up
+CREATE TABLE api_keys (
+    id UUID NOT NULL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    -- First characters of the key, shown in listings so users can tell their keys apart
+    key_prefix TEXT NOT NULL,
+    -- SHA-256 of the full key
+    key_hash TEXT NOT NULL,
+    scopes TEXT[] NOT NULL,
+    expires_at TIMESTAMP,
+    last_used_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT api_keys_key_hash_key UNIQUE (key_hash),
+    CONSTRAINT api_keys_user_id_name_key UNIQUE (user_id, name)
+);
+
+-- +goose Down
+DROP TABLE api_keys;
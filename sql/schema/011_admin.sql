@@ -0,0 +1,36 @@
+-- +goose Up
+ALTER TABLE users
+    ADD COLUMN suspended_at TIMESTAMP,
+    ADD COLUMN suspension_reason TEXT NOT NULL DEFAULT '';
+
+-- ListUsers pages through users from the newest
+CREATE INDEX idx_users_created_at_id ON users(created_at DESC, id DESC);
+
+CREATE TABLE admin_audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    -- Subject of the admin's token, a user ID or the client ID of a service
+    actor TEXT NOT NULL,
+    action TEXT NOT NULL,
+    -- No foreign key, entries outlive the users they are about
+    target_user_id UUID,
+    details JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_admin_audit_log_target_user_id ON admin_audit_log(target_user_id, created_at);
+
+INSERT INTO permissions (name, description) VALUES
+    ('users:read', 'List and look up users'),
+    ('users:write', 'Edit, suspend and log out users');
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'users:read'),
+    ('admin', 'users:write');
+
+-- +goose Down
+DELETE FROM permissions WHERE name IN ('users:read', 'users:write');
+DROP TABLE admin_audit_log;
+DROP INDEX idx_users_created_at_id;
+ALTER TABLE users
+    DROP COLUMN suspension_reason,
+    DROP COLUMN suspended_at;
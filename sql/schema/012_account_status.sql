@@ -0,0 +1,27 @@
+-- +goose Up
+-- Suspension becomes one of several account statuses
+ALTER TABLE users RENAME COLUMN suspended_at TO status_changed_at;
+ALTER TABLE users RENAME COLUMN suspension_reason TO status_reason;
+
+ALTER TABLE users
+    ADD COLUMN status TEXT NOT NULL DEFAULT 'active'
+        CONSTRAINT users_status_check CHECK (status IN ('active', 'suspended', 'banned', 'deleted')),
+    -- End of a timed suspension, NULL while it lasts until lifted
+    ADD COLUMN status_until TIMESTAMP;
+
+UPDATE users SET status = 'suspended' WHERE status_changed_at IS NOT NULL;
+
+-- LiftExpiredSuspensions looks for timed suspensions that ended
+CREATE INDEX idx_users_status_until ON users(status_until) WHERE status = 'suspended';
+
+-- +goose Down
+DROP INDEX idx_users_status_until;
+
+UPDATE users SET status_changed_at = NULL, status_reason = '' WHERE status = 'active';
+
+ALTER TABLE users
+    DROP COLUMN status_until,
+    DROP COLUMN status;
+
+ALTER TABLE users RENAME COLUMN status_reason TO suspension_reason;
+ALTER TABLE users RENAME COLUMN status_changed_at TO suspended_at;
@@ -0,0 +1,36 @@
+-- +goose Up
+CREATE TABLE auth_events (
+    id BIGSERIAL PRIMARY KEY,
+    -- No foreign key, events outlive the users they are about. NULL when the user is unknown,
+    -- like a failed login with an identifier nobody has
+    user_id UUID,
+    type TEXT NOT NULL,
+    -- Subject of the token of whoever caused the event when it wasn't the user, like an admin
+    actor TEXT NOT NULL DEFAULT '',
+    ip_address TEXT NOT NULL DEFAULT '',
+    user_agent TEXT NOT NULL DEFAULT '',
+    session_id TEXT NOT NULL DEFAULT '',
+    details JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_auth_events_user_id ON auth_events(user_id, id DESC);
+CREATE INDEX idx_auth_events_created_at ON auth_events(created_at);
+
+-- Events are append only. The retention job deletes old ones, nothing changes them
+-- +goose StatementBegin
+CREATE FUNCTION reject_auth_event_update() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'auth_events is append only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER auth_events_append_only
+    BEFORE UPDATE ON auth_events
+    FOR EACH ROW EXECUTE FUNCTION reject_auth_event_update();
+
+-- +goose Down
+DROP TRIGGER auth_events_append_only ON auth_events;
+DROP FUNCTION reject_auth_event_update();
+DROP TABLE auth_events;
@@ -0,0 +1,16 @@
+-- +goose Up
+-- Devices users logged in from, so logins from new ones can be reported to the user
+CREATE TABLE known_devices (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    -- SHA-256 of the user agent, the network and the device ID the client sent
+    fingerprint TEXT NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    -- The /24 of an IPv4 address or the /64 of an IPv6 one
+    ip_prefix TEXT NOT NULL DEFAULT '',
+    first_seen_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, fingerprint)
+);
+
+-- +goose Down
+DROP TABLE known_devices;
@@ -0,0 +1,22 @@
+-- +goose Up
+-- The address an email_change code was sent to. Empty for every other purpose
+ALTER TABLE verification_codes ADD COLUMN new_email TEXT NOT NULL DEFAULT '';
+
+-- Addresses users changed away from. Until the hold expires nobody else can register or switch to the address,
+-- and the undo link sent to it can move the account back
+CREATE TABLE email_holds (
+    user_id UUID NOT NULL PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    was_verified BOOLEAN NOT NULL,
+    changed_to TEXT NOT NULL,
+    -- SHA-256 of the undo link token
+    token_hash TEXT NOT NULL UNIQUE,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_email_holds_email ON email_holds(email);
+
+-- +goose Down
+DROP TABLE email_holds;
+ALTER TABLE verification_codes DROP COLUMN new_email;
@@ -0,0 +1,34 @@
+-- +goose Up
+-- Usernames are unique regardless of case and can't contain "@", so a login identifier is either an email or
+-- a username and never matches two accounts
+UPDATE users SET username = replace(username, '@', '_'), updated_at = NOW()
+WHERE username LIKE '%@%';
+
+-- The oldest account keeps a shared name, the others get the start of their ID appended
+UPDATE users u
+SET username = u.username || '_' || substr(replace(u.id::text, '-', ''), 1, 6), updated_at = NOW()
+WHERE EXISTS (
+    SELECT 1 FROM users o
+    WHERE lower(o.username) = lower(u.username) AND (o.created_at, o.id) < (u.created_at, u.id)
+);
+
+DROP INDEX idx_users_username;
+CREATE UNIQUE INDEX users_username_lower_key ON users(lower(username));
+
+-- Username changes. The old name stays reserved for the user until held_until
+CREATE TABLE username_changes (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    old_username TEXT NOT NULL,
+    new_username TEXT NOT NULL,
+    changed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    held_until TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_username_changes_user_id ON username_changes(user_id, changed_at);
+CREATE INDEX idx_username_changes_old_username ON username_changes(lower(old_username));
+
+-- +goose Down
+DROP TABLE username_changes;
+DROP INDEX users_username_lower_key;
+CREATE INDEX idx_users_username ON users(username);
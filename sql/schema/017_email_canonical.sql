@@ -0,0 +1,25 @@
+-- +goose Up
+-- Accounts are looked up and kept unique by the lower case address. The service also normalizes
+-- internationalized domains to punycode before storing addresses, which SQL can't do, so the column is written
+-- by the service instead of being generated
+ALTER TABLE users ADD COLUMN email_canonical TEXT;
+
+-- Of accounts whose addresses only differ in case, the oldest keeps its address for logging in. The others keep
+-- a NULL canonical address and log in by username until an admin gives them a different address
+UPDATE users u
+SET email_canonical = lower(u.email)
+WHERE NOT EXISTS (
+    SELECT 1 FROM users o
+    WHERE lower(o.email) = lower(u.email) AND (o.created_at, o.id) < (u.created_at, u.id)
+);
+
+CREATE UNIQUE INDEX users_email_canonical_key ON users(email_canonical);
+
+DROP INDEX idx_email_holds_email;
+CREATE INDEX idx_email_holds_email ON email_holds(lower(email));
+
+-- +goose Down
+DROP INDEX idx_email_holds_email;
+CREATE INDEX idx_email_holds_email ON email_holds(email);
+DROP INDEX users_email_canonical_key;
+ALTER TABLE users DROP COLUMN email_canonical;
@@ -0,0 +1,14 @@
+-- +goose Up
+-- Accounts whose users asked for them to be deleted. The user has status 'deleted' and can't log in, the cancel
+-- link restores the account until purge_after, when the purge job deletes or anonymizes it
+CREATE TABLE account_deletions (
+    user_id UUID NOT NULL PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    token_hash TEXT NOT NULL UNIQUE,
+    requested_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    purge_after TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_account_deletions_purge_after ON account_deletions(purge_after);
+
+-- +goose Down
+DROP TABLE account_deletions;
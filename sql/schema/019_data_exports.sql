@@ -0,0 +1,25 @@
+-- +goose Up
+-- Copies of their data users asked for. The export job claims pending rows, writes the archive to the export
+-- storage under storage_key and marks them ready. Archives are deleted once expires_at passes
+CREATE TABLE data_exports (
+    id UUID NOT NULL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'ready', 'failed', 'expired')),
+    attempts INT NOT NULL DEFAULT 0,
+    storage_key TEXT NOT NULL DEFAULT '',
+    size_bytes BIGINT NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    started_at TIMESTAMP,
+    completed_at TIMESTAMP,
+    expires_at TIMESTAMP
+);
+
+CREATE INDEX idx_data_exports_user_id ON data_exports(user_id);
+CREATE INDEX idx_data_exports_status ON data_exports(status, created_at);
+
+-- A user has at most one export waiting or being built
+CREATE UNIQUE INDEX data_exports_active_user_key ON data_exports(user_id) WHERE status IN ('pending', 'running');
+
+-- +goose Down
+DROP TABLE data_exports;
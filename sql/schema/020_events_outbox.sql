@@ -0,0 +1,23 @@
+-- +goose Up
+-- Events for other services, written in the same transaction as the change they describe. The relay publishes
+-- unpublished rows in id order and keeps published ones until the retention ends. payload is the encoded
+-- auth.events.v1.Envelope that goes on the wire
+CREATE TABLE events_outbox (
+    id BIGSERIAL PRIMARY KEY,
+    event_id UUID NOT NULL UNIQUE,
+    event_type TEXT NOT NULL,
+    -- No foreign key, the user.deleted event outlives the user
+    user_id UUID NOT NULL,
+    payload BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    published_at TIMESTAMP
+);
+
+CREATE INDEX idx_events_outbox_pending ON events_outbox(next_attempt_at) WHERE published_at IS NULL;
+CREATE INDEX idx_events_outbox_published_at ON events_outbox(published_at) WHERE published_at IS NOT NULL;
+
+-- +goose Down
+DROP TABLE events_outbox;
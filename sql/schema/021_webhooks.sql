@@ -0,0 +1,53 @@
+-- +goose Up
+-- Partner endpoints that receive events as signed JSON over HTTP. secret signs the requests, so it is kept as is
+CREATE TABLE webhook_endpoints (
+    id UUID NOT NULL PRIMARY KEY,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    -- Event types the endpoint receives, every type when empty
+    event_types TEXT[] NOT NULL DEFAULT '{}',
+    -- Subject of the admin's token
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    -- Failed attempts since the last successful one. The endpoint is disabled when there are too many
+    consecutive_failures INT NOT NULL DEFAULT 0,
+    disabled_at TIMESTAMP,
+    disabled_reason TEXT NOT NULL DEFAULT ''
+);
+
+-- The delivery log. A delivery is created for each event and endpoint in the transaction that writes the event,
+-- payload is the exact JSON body that is sent
+CREATE TABLE webhook_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    endpoint_id UUID NOT NULL REFERENCES webhook_endpoints(id) ON DELETE CASCADE,
+    event_id UUID NOT NULL,
+    event_type TEXT NOT NULL,
+    payload BYTEA NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'succeeded', 'failed')),
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_attempt_at TIMESTAMP,
+    -- HTTP status of the last attempt, 0 when there was no response
+    response_status INT NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP,
+    UNIQUE (endpoint_id, event_id)
+);
+
+CREATE INDEX idx_webhook_deliveries_pending ON webhook_deliveries(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX idx_webhook_deliveries_created_at ON webhook_deliveries(created_at) WHERE status <> 'pending';
+
+INSERT INTO permissions (name, description) VALUES
+    ('webhooks:read', 'List webhook endpoints and their deliveries'),
+    ('webhooks:write', 'Add and remove webhook endpoints and replay deliveries');
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'webhooks:read'),
+    ('admin', 'webhooks:write');
+
+-- +goose Down
+DELETE FROM permissions WHERE name IN ('webhooks:read', 'webhooks:write');
+DROP TABLE webhook_deliveries;
+DROP TABLE webhook_endpoints;
@@ -0,0 +1,20 @@
+-- +goose Up
+-- Push tokens belong to the session that registered them, see auth.SessionID. They are removed when the session
+-- ends and moved along when its refresh token is rotated
+ALTER TABLE device_tokens
+    ADD COLUMN session_id TEXT NOT NULL DEFAULT '';
+
+-- A push token identifies an app installation, registering it for one user takes it away from the others
+CREATE INDEX idx_device_tokens_device_token ON device_tokens(device_token);
+
+INSERT INTO permissions (name, description) VALUES
+    ('devices:read', 'Look up the push tokens of users');
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'devices:read');
+
+-- +goose Down
+DELETE FROM permissions WHERE name = 'devices:read';
+DROP INDEX idx_device_tokens_device_token;
+ALTER TABLE device_tokens
+    DROP COLUMN session_id;
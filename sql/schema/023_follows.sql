@@ -0,0 +1,48 @@
+-- +goose Up
+ALTER TABLE users
+    ADD COLUMN is_private BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Following a private account makes a pending request, which becomes accepted when the account approves it. Only
+-- accepted follows count as followers
+CREATE TABLE follows (
+    follower_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    followee_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status TEXT NOT NULL CHECK (status IN ('pending', 'accepted')),
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    accepted_at TIMESTAMP,
+    PRIMARY KEY (follower_id, followee_id),
+    CHECK (follower_id <> followee_id)
+);
+
+CREATE INDEX idx_follows_followee ON follows(followee_id, status, created_at DESC, follower_id DESC);
+CREATE INDEX idx_follows_follower ON follows(follower_id, status, created_at DESC, followee_id DESC);
+
+-- The arrays were never kept in sync with each other, a follow listed on either side is kept. IDs of users that
+-- no longer exist are dropped
+INSERT INTO follows (follower_id, followee_id, status, accepted_at)
+SELECT pairs.follower_id, pairs.followee_id, 'accepted', NOW()
+FROM (
+    SELECT id AS follower_id, unnest(subscribed_to) AS followee_id FROM users
+    UNION
+    SELECT unnest(subscribers), id FROM users
+) pairs
+JOIN users follower ON follower.id = pairs.follower_id
+JOIN users followee ON followee.id = pairs.followee_id
+WHERE pairs.follower_id <> pairs.followee_id;
+
+ALTER TABLE users
+    DROP COLUMN subscribers,
+    DROP COLUMN subscribed_to;
+
+-- +goose Down
+ALTER TABLE users
+    ADD COLUMN subscribers UUID[],
+    ADD COLUMN subscribed_to UUID[];
+
+UPDATE users u
+SET subscribers = (SELECT array_agg(f.follower_id) FROM follows f WHERE f.followee_id = u.id AND f.status = 'accepted'),
+    subscribed_to = (SELECT array_agg(f.followee_id) FROM follows f WHERE f.follower_id = u.id AND f.status = 'accepted');
+
+DROP TABLE follows;
+ALTER TABLE users
+    DROP COLUMN is_private;
@@ -0,0 +1,53 @@
+-- +goose Up
+-- Plans are what users can be entitled to. Users with an active entitlement to a premium plan are premium
+CREATE TABLE plans (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    premium BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO plans (id, name, premium) VALUES
+    ('premium', 'Premium', TRUE);
+
+-- An entitlement is active from starts_at until expires_at, or forever without one, unless it was revoked.
+-- Entitlements bought through the billing provider have the provider's subscription ID as external_id, and
+-- source_updated_at is the time of the last billing event applied to them, so events arriving out of order
+-- are skipped
+CREATE TABLE user_entitlements (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    plan_id TEXT NOT NULL REFERENCES plans(id),
+    source TEXT NOT NULL CHECK (source IN ('admin', 'billing')),
+    external_id TEXT UNIQUE,
+    starts_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    granted_by TEXT NOT NULL DEFAULT '',
+    source_updated_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_user_entitlements_user_id ON user_entitlements(user_id);
+
+-- IDs of the billing events already applied, so redelivered events are only applied once
+CREATE TABLE billing_events (
+    id TEXT PRIMARY KEY,
+    type TEXT NOT NULL,
+    received_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO permissions (name, description) VALUES
+    ('entitlements:read', 'View the entitlements of users'),
+    ('entitlements:write', 'Grant and revoke entitlements');
+
+INSERT INTO role_permissions (role, permission) VALUES
+    ('admin', 'entitlements:read'),
+    ('admin', 'entitlements:write');
+
+-- +goose Down
+DELETE FROM permissions WHERE name IN ('entitlements:read', 'entitlements:write');
+DROP TABLE billing_events;
+DROP TABLE user_entitlements;
+DROP TABLE plans;
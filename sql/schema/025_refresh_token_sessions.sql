@@ -0,0 +1,15 @@
+-- +goose Up
+-- Refresh tokens keep the ID of their session, see auth.SessionID, so the push tokens of sessions that ended can
+-- be found with a join. Existing tokens get it computed the same way
+ALTER TABLE refresh_tokens
+    ADD COLUMN session_id TEXT NOT NULL DEFAULT '';
+
+UPDATE refresh_tokens
+SET session_id = encode(substring(sha256(convert_to(token, 'UTF8')) FROM 1 FOR 8), 'hex');
+
+CREATE INDEX idx_refresh_tokens_session ON refresh_tokens(user_id, session_id);
+
+-- +goose Down
+DROP INDEX idx_refresh_tokens_session;
+ALTER TABLE refresh_tokens
+    DROP COLUMN session_id;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- The relay only claims the oldest unpublished event of each user, so events of a user are published in order
+-- across runs and replicas. This index finds the earlier events of a user
+CREATE INDEX idx_events_outbox_pending_user ON events_outbox(user_id, id) WHERE published_at IS NULL;
+
+-- +goose Down
+DROP INDEX idx_events_outbox_pending_user;
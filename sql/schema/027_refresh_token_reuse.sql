@@ -0,0 +1,26 @@
+-- +goose Up
+-- Every refresh token belongs to the family of the login it was rotated from, named after the session ID of the
+-- login's first token. Rotated tokens are remembered until they would have expired, so a rotated token that is
+-- presented again can be told apart from an unknown one and its family revoked
+ALTER TABLE refresh_tokens
+    ADD COLUMN family_id TEXT NOT NULL DEFAULT '';
+
+UPDATE refresh_tokens
+SET family_id = session_id;
+
+CREATE INDEX idx_refresh_tokens_family ON refresh_tokens(user_id, family_id);
+
+CREATE TABLE rotated_refresh_tokens (
+    token_hash TEXT PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    family_id TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_rotated_refresh_tokens_expires ON rotated_refresh_tokens(expires_at);
+
+-- +goose Down
+DROP TABLE rotated_refresh_tokens;
+DROP INDEX idx_refresh_tokens_family;
+ALTER TABLE refresh_tokens
+    DROP COLUMN family_id;
@@ -0,0 +1,9 @@
+// Package schema embeds the goose migrations, so the service binary can apply them without the source tree
+package schema
+
+import "embed"
+
+// FS contains the versioned goose migrations of the service database
+//
+//go:embed *.sql
+var FS embed.FS